@@ -0,0 +1,185 @@
+// Package admincli implements the admin subcommands of the catalyst-api binary, e.g. `catalyst-api jobs list`. They
+// call the internal API of a running catalyst-api, authenticating with the configured API token.
+package admincli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/events"
+	"github.com/livepeer/catalyst-api/handlers/admin"
+	"github.com/livepeer/catalyst-api/pipeline"
+)
+
+const usage = `usage: catalyst-api [flags] <command>
+
+commands:
+  jobs list                                   list the VOD jobs in flight
+  jobs prioritize <requestID>                 bump the priority of a running VOD job
+  stream nuke <playbackID>                    nuke the stream on all nodes
+  stream refresh <playbackID>                 refresh the stream config on all nodes
+  stream stop-sessions <playbackID>           stop the playback sessions of the stream on all nodes
+  balancer decisions <playbackID> [lat lon]   show which node each balancer would send a viewer to`
+
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// Run executes the admin command in args against the internal API of the catalyst-api configured in cli
+func Run(ctx context.Context, cli config.Cli, args []string, out io.Writer) error {
+	c := &client{
+		baseURL: internalURL(cli.HTTPInternalAddress),
+		token:   cli.APIToken,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	return c.run(ctx, args, out)
+}
+
+// internalURL is the base URL of the internal API, reached over loopback when it listens on all interfaces
+func internalURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return "http://" + addr
+}
+
+func (c *client) run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing command\n%s", usage)
+	}
+	switch args[0] + " " + args[1] {
+	case "jobs list":
+		return c.listJobs(ctx, out)
+	case "jobs prioritize":
+		requestID, err := singleArg(args[2:], "requestID")
+		if err != nil {
+			return err
+		}
+		if err := c.do(ctx, http.MethodPost, "/api/vod/"+url.PathEscape(requestID)+"/priority", nil, nil); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "prioritized job %s\n", requestID)
+		return nil
+	case "stream nuke":
+		return c.sendStreamEvent(ctx, args[2:], out, func(id string) any { return events.NewNukeEvent(id) })
+	case "stream refresh":
+		return c.sendStreamEvent(ctx, args[2:], out, func(id string) any { return events.NewStreamEvent(id) })
+	case "stream stop-sessions":
+		return c.sendStreamEvent(ctx, args[2:], out, func(id string) any { return events.NewStopSessionsEvent(id) })
+	case "balancer decisions":
+		return c.balancerDecisions(ctx, args[2:], out)
+	}
+	return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), usage)
+}
+
+func singleArg(args []string, name string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", fmt.Errorf("expected a single <%s> argument\n%s", name, usage)
+	}
+	return args[0], nil
+}
+
+func (c *client) listJobs(ctx context.Context, out io.Writer) error {
+	var jobs []pipeline.JobSummary
+	if err := c.do(ctx, http.MethodGet, "/api/admin/jobs", nil, &jobs); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST ID\tEXTERNAL ID\tSTAGE\tSTATUS\tCOMPLETION\tRUNNING FOR\tDEADLINE IN")
+	for _, job := range jobs {
+		deadlineIn := "-"
+		if job.Deadline != nil {
+			deadlineIn = time.Until(*job.Deadline).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.0f%%\t%s\t%s\n",
+			job.RequestID, job.ExternalID, job.Stage, job.Status, job.Completion*100,
+			time.Since(job.StartedAt).Round(time.Second), deadlineIn)
+	}
+	return tw.Flush()
+}
+
+func (c *client) sendStreamEvent(ctx context.Context, args []string, out io.Writer, newEvent func(playbackID string) any) error {
+	playbackID, err := singleArg(args, "playbackID")
+	if err != nil {
+		return err
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/events", newEvent(playbackID), nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "sent event for %s\n", playbackID)
+	return nil
+}
+
+func (c *client) balancerDecisions(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) != 1 && len(args) != 3 {
+		return fmt.Errorf("expected <playbackID> [lat lon] arguments\n%s", usage)
+	}
+	path := "/api/admin/balancer/" + url.PathEscape(args[0])
+	if len(args) == 3 {
+		path += "?" + url.Values{"lat": {args[1]}, "lon": {args[2]}}.Encode()
+	}
+	var decisions []admin.BalancerDecision
+	if err := c.do(ctx, http.MethodGet, path, nil, &decisions); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BALANCER\tACTIVE\tCLUSTER\tNODES\tPLAYBACK ID\tERROR")
+	for _, d := range decisions {
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%s\t%s\t%s\n", d.Balancer, d.Active, d.Cluster, strings.Join(d.Nodes, ","), d.FullPlaybackID, d.Error)
+	}
+	return tw.Flush()
+}
+
+// do calls the internal API, encoding body as the JSON payload and decoding the JSON response into result if set
+func (c *client) do(ctx context.Context, method, path string, body, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+		}
+	}
+	return nil
+}
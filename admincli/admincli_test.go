@@ -0,0 +1,121 @@
+package admincli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/handlers/admin"
+	"github.com/livepeer/catalyst-api/pipeline"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedRequest struct {
+	method, path, auth, body string
+}
+
+func testServer(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) (config.Cli, *[]recordedRequest) {
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, recordedRequest{r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), string(body)})
+		respond(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return config.Cli{HTTPInternalAddress: strings.TrimPrefix(server.URL, "http://"), APIToken: "secret"}, &requests
+}
+
+func TestJobsList(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	cli, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]pipeline.JobSummary{{
+			RequestID:  "req-1",
+			ExternalID: "asset-1",
+			Stage:      "transcoding",
+			Status:     "transcoding",
+			Completion: 0.25,
+			StartedAt:  time.Now().Add(-time.Minute),
+			Deadline:   &deadline,
+		}, {
+			RequestID: "req-2",
+			Stage:     "input_copy",
+			StartedAt: time.Now(),
+		}})
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), cli, []string{"jobs", "list"}, &out))
+	require.Equal(t, []recordedRequest{{http.MethodGet, "/api/admin/jobs", "Bearer secret", ""}}, *requests)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, lines[0], "REQUEST ID")
+	require.Contains(t, lines[1], "req-1")
+	require.Contains(t, lines[1], "asset-1")
+	require.Contains(t, lines[1], "25%")
+	require.Contains(t, lines[2], "req-2")
+	require.True(t, strings.HasSuffix(lines[2], "-"), lines[2])
+}
+
+func TestStreamAndJobCommands(t *testing.T) {
+	cli, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, args := range [][]string{
+		{"stream", "nuke", "abcd"},
+		{"stream", "refresh", "abcd"},
+		{"stream", "stop-sessions", "abcd"},
+		{"jobs", "prioritize", "req-1"},
+	} {
+		require.NoError(t, Run(context.Background(), cli, args, io.Discard))
+	}
+	require.Equal(t, []recordedRequest{
+		{http.MethodPost, "/api/events", "Bearer secret", `{"resource":"nuke","playback_id":"abcd"}`},
+		{http.MethodPost, "/api/events", "Bearer secret", `{"resource":"stream","playback_id":"abcd"}`},
+		{http.MethodPost, "/api/events", "Bearer secret", `{"resource":"stopSessions","playback_id":"abcd"}`},
+		{http.MethodPost, "/api/vod/req-1/priority", "Bearer secret", ""},
+	}, *requests)
+}
+
+func TestBalancerDecisions(t *testing.T) {
+	cli, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]admin.BalancerDecision{
+			{Balancer: "catabalancer", Nodes: []string{"node-a"}, FullPlaybackID: "video+abcd"},
+			{Balancer: "mist", Active: true, Nodes: []string{"node-b"}, FullPlaybackID: "video+abcd"},
+		})
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), cli, []string{"balancer", "decisions", "abcd", "51.5", "-0.1"}, &out))
+	require.Equal(t, "/api/admin/balancer/abcd?lat=51.5&lon=-0.1", (*requests)[0].path)
+	require.Contains(t, out.String(), "node-a")
+	require.Contains(t, out.String(), "node-b")
+}
+
+func TestCommandErrors(t *testing.T) {
+	cli, requests := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Authorized", http.StatusUnauthorized)
+	})
+
+	err := Run(context.Background(), cli, []string{"jobs", "list"}, io.Discard)
+	require.ErrorContains(t, err, "GET /api/admin/jobs returned 401: Not Authorized")
+
+	require.ErrorContains(t, Run(context.Background(), cli, []string{"jobs"}, io.Discard), "missing command")
+	require.ErrorContains(t, Run(context.Background(), cli, []string{"jobs", "delete"}, io.Discard), `unknown command "jobs delete"`)
+	require.ErrorContains(t, Run(context.Background(), cli, []string{"stream", "nuke"}, io.Discard), "expected a single <playbackID> argument")
+	require.ErrorContains(t, Run(context.Background(), cli, []string{"balancer", "decisions", "abcd", "51.5"}, io.Discard), "expected <playbackID> [lat lon] arguments")
+	require.Len(t, *requests, 1)
+}
+
+func TestInternalURL(t *testing.T) {
+	require.Equal(t, "http://127.0.0.1:7979", internalURL("127.0.0.1:7979"))
+	require.Equal(t, "http://127.0.0.1:7979", internalURL("0.0.0.0:7979"))
+	require.Equal(t, "http://127.0.0.1:7979", internalURL(":7979"))
+	require.Equal(t, "http://127.0.0.1:7979", internalURL("[::]:7979"))
+	require.Equal(t, "http://10.0.0.1:7979", internalURL("10.0.0.1:7979"))
+}
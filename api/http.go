@@ -3,7 +3,6 @@ package api
 import (
 	"context"
 	"net/http"
-	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/livepeer/catalyst-api/balancer"
@@ -16,13 +15,12 @@ import (
 	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/livepeer/catalyst-api/middleware"
 	"github.com/livepeer/catalyst-api/pipeline"
+	"github.com/livepeer/catalyst-api/playback"
 	"github.com/livepeer/go-api-client"
 )
 
-func ListenAndServe(ctx context.Context, cli config.Cli, vodEngine *pipeline.Coordinator, bal balancer.Balancer, mapic mistapiconnector.IMac, serfMembersEndpoint string) error {
-	router := NewCatalystAPIRouter(cli, vodEngine, bal, mapic, serfMembersEndpoint)
-	server := http.Server{Addr: cli.HTTPAddress, Handler: router}
-	ctx, cancel := context.WithCancel(ctx)
+func ListenAndServe(ctx context.Context, cli config.Cli, vodEngine *pipeline.Coordinator, bal balancer.Balancer, mapic mistapiconnector.IMac, cdnRedirects *geolocation.CdnRedirectOverrides, vanityPaths *geolocation.VanityPaths, serfMembersEndpoint string, sourceSessions *playback.SourceSessions, bucketRouter *playback.BucketRouter, heatmap *analytics.GeoHeatmap) error {
+	router := NewCatalystAPIRouter(cli, vodEngine, bal, mapic, cdnRedirects, vanityPaths, serfMembersEndpoint, sourceSessions, bucketRouter, heatmap)
 
 	log.LogNoRequestID(
 		"Starting Catalyst API!",
@@ -30,25 +28,12 @@ func ListenAndServe(ctx context.Context, cli config.Cli, vodEngine *pipeline.Coo
 		"host", cli.HTTPAddress,
 	)
 
-	var err error
-	go func() {
-		err = server.ListenAndServe()
-		cancel()
-	}()
-
-	<-ctx.Done()
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return server.Shutdown(ctx)
+	return serve(ctx, cli, "public", cli.HTTPAddress, router)
 }
 
-func NewCatalystAPIRouter(cli config.Cli, vodEngine *pipeline.Coordinator, bal balancer.Balancer, mapic mistapiconnector.IMac, serfMembersEndpoint string) *httprouter.Router {
-	router := httprouter.New()
-	withLogging := middleware.LogRequest()
+func NewCatalystAPIRouter(cli config.Cli, vodEngine *pipeline.Coordinator, bal balancer.Balancer, mapic mistapiconnector.IMac, cdnRedirects *geolocation.CdnRedirectOverrides, vanityPaths *geolocation.VanityPaths, serfMembersEndpoint string, sourceSessions *playback.SourceSessions, bucketRouter *playback.BucketRouter, heatmap *analytics.GeoHeatmap) *httprouter.Router {
+	router := middleware.NewRouter("public")
+	router.LimitConcurrency(cli.HTTPRouteConcurrency)
 	withCORS := middleware.AllowCORS()
 	withGatingCheck := middleware.NewGatingHandler(cli, mapic).GatingCheck
 
@@ -57,41 +42,51 @@ func NewCatalystAPIRouter(cli config.Cli, vodEngine *pipeline.Coordinator, bal b
 		AccessToken: cli.APIToken,
 	})
 	catalystApiHandlers := &handlers.CatalystAPIHandlersCollection{VODEngine: vodEngine}
-	geoHandlers := geolocation.NewGeolocationHandlersCollection(bal, cli, lapi, serfMembersEndpoint)
+	geoHandlers := geolocation.NewGeolocationHandlersCollection(bal, cli, lapi, serfMembersEndpoint, cdnRedirects, vanityPaths)
 
-	router.GET("/ok", withLogging(catalystApiHandlers.Ok()))
-	router.GET("/healthcheck", withLogging(catalystApiHandlers.Healthcheck()))
+	router.HandleSampled(http.MethodGet, "/ok", cli.AccessLogSampleRate, catalystApiHandlers.Ok())
+	router.HandleSampled(http.MethodGet, "/healthcheck", cli.AccessLogSampleRate, catalystApiHandlers.Healthcheck())
+	router.HandleSampled(http.MethodGet, "/healthz", cli.AccessLogSampleRate, catalystApiHandlers.Healthcheck())
 
-	if cli.EnableAnalytics == "true" || cli.EnableAnalytics == "enabled" {
-		logProcessor := analytics.NewLogProcessor(cli.KafkaBootstrapServers, cli.KafkaUser, cli.KafkaPassword, cli.AnalyticsKafkaTopic)
+	if cli.AnalyticsEnabled() {
+		logProcessor := analytics.NewLogProcessor(cli.KafkaBootstrapServers, cli.KafkaUser, cli.KafkaPassword, cli.AnalyticsKafkaTopic, heatmap)
 
 		analyticsApiHandlers := handlers.NewAnalyticsHandlersCollection(mapic, lapi, logProcessor)
-		router.POST("/analytics/log", withCORS(analyticsApiHandlers.Log()))
+		router.HandleSampled(http.MethodPost, "/analytics/log", cli.AccessLogSampleRate, withCORS(analyticsApiHandlers.Log()))
 		// Redirect GET /analytics/log to the specific catalyst node, e.g. "mdw-staging-staging-catalyst-0.livepeer.monster"
 		// This is useful for the player, because then it can stick to one node while sending analytics logs
-		router.GET("/analytics/log", withLogging(withCORS(geoHandlers.RedirectConstPathHandler())))
-		router.HEAD("/analytics/log", withLogging(withCORS(geoHandlers.RedirectConstPathHandler())))
+		router.HandleSampled(http.MethodGet, "/analytics/log", cli.AccessLogSampleRate, withCORS(geoHandlers.RedirectConstPathHandler()))
+		router.HandleSampled(http.MethodHead, "/analytics/log", cli.AccessLogSampleRate, withCORS(geoHandlers.RedirectConstPathHandler()))
+	}
+
+	// Renewal of the signed source playback URLs of private buckets
+	if sourceSessions != nil {
+		sourcePlaybackHandlers := handlers.NewSourcePlaybackHandler(sourceSessions)
+		router.HandleSampled(http.MethodGet, playback.SourceRenewPath, cli.AccessLogSampleRate, withCORS(sourcePlaybackHandlers.RenewPlayback))
+		router.HandleSampled(http.MethodPost, playback.SourceRenewPath, cli.AccessLogSampleRate, withCORS(sourcePlaybackHandlers.Renew))
+		router.HandleSampled(http.MethodOptions, playback.SourceRenewPath, cli.AccessLogSampleRate, withCORS(sourcePlaybackHandlers.Renew))
 	}
 
 	// Playback endpoint
-	playback := middleware.LogAndMetrics(metrics.Metrics.PlaybackRequestDurationSec)(
+	playback := middleware.RecordDuration(metrics.Metrics.PlaybackRequestDurationSec)(
 		withCORS(
 			withGatingCheck(
-				handlers.NewPlaybackHandler(cli.PrivateBucketURLs).Handle,
+				handlers.NewPlaybackHandler(cli.PrivateBucketURLs, bucketRouter).Handle,
 			),
 		),
 	)
-	router.GET("/asset/hls/:playbackID/*file", playback)
-	router.HEAD("/asset/hls/:playbackID/*file", playback)
+	router.HandleSampled(http.MethodGet, "/asset/hls/:playbackID/*file", cli.AccessLogSampleRate, playback)
+	router.HandleSampled(http.MethodHead, "/asset/hls/:playbackID/*file", cli.AccessLogSampleRate, playback)
 	for _, path := range [...]string{"/asset/hls/:playbackID/*file", "/webrtc/:playbackID"} {
-		router.OPTIONS(path, playback)
+		router.HandleSampled(http.MethodOptions, path, cli.AccessLogSampleRate, playback)
 	}
 
+	// Short, memorable links to playback IDs, e.g. /v/my-event
+	router.HandleSampled(http.MethodGet, geolocation.VanityPathPrefix+":path", cli.AccessLogSampleRate, withCORS(geoHandlers.VanityPathHandler()))
+	router.HandleSampled(http.MethodHead, geolocation.VanityPathPrefix+":path", cli.AccessLogSampleRate, withCORS(geoHandlers.VanityPathHandler()))
+
 	// Handling incoming playback redirection requests
-	redirectHandler := withLogging(withCORS(geoHandlers.RedirectHandler()))
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		redirectHandler(w, r, httprouter.Params{})
-	})
+	router.SetNotFound("redirect", cli.AccessLogSampleRate, withCORS(geoHandlers.RedirectHandler()))
 
-	return router
+	return router.Router
 }
@@ -8,10 +8,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/livepeer/catalyst-api/balancer"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
+	"github.com/livepeer/catalyst-api/balancer/federation"
 	"github.com/livepeer/catalyst-api/cluster"
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/crypto"
@@ -23,17 +24,18 @@ import (
 	"github.com/livepeer/catalyst-api/handlers/geolocation"
 	"github.com/livepeer/catalyst-api/handlers/misttriggers"
 	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/maintenance"
 	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
 	"github.com/livepeer/catalyst-api/middleware"
+	"github.com/livepeer/catalyst-api/mistbackup"
 	"github.com/livepeer/catalyst-api/pipeline"
+	"github.com/livepeer/catalyst-api/playback"
 	"github.com/livepeer/go-api-client"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func ListenAndServeInternal(ctx context.Context, cli config.Cli, vodEngine *pipeline.Coordinator, mapic mistapiconnector.IMac, bal balancer.Balancer, c cluster.Cluster, broker misttriggers.TriggerBroker, metricsDB *sql.DB, serfMembersEndpoint, eventsEndpoint string, catalystApiURL string) error {
-	router := NewCatalystAPIRouterInternal(cli, vodEngine, mapic, bal, c, broker, metricsDB, serfMembersEndpoint, eventsEndpoint, catalystApiURL)
-	server := http.Server{Addr: cli.HTTPInternalAddress, Handler: router}
-	ctx, cancel := context.WithCancel(ctx)
+func ListenAndServeInternal(ctx context.Context, cli config.Cli, vodEngine *pipeline.Coordinator, mapic mistapiconnector.IMac, bal balancer.Balancer, c cluster.Cluster, broker misttriggers.TriggerBroker, mistBackups *mistbackup.Backups, metricsDB *sql.DB, cdnRedirects *geolocation.CdnRedirectOverrides, vanityPaths *geolocation.VanityPaths, recordingAutoVOD *handlers.RecordingAutoVOD, nodeStats *catabalancer.SerfNodeStats, heatmap *analytics.GeoHeatmap, serfMembersEndpoint, eventsEndpoint string, catalystApiURL string) error {
+	router := NewCatalystAPIRouterInternal(cli, vodEngine, mapic, bal, c, broker, mistBackups, metricsDB, cdnRedirects, vanityPaths, recordingAutoVOD, nodeStats, heatmap, serfMembersEndpoint, eventsEndpoint, catalystApiURL)
 
 	log.LogNoRequestID(
 		"Starting Catalyst Internal API!",
@@ -41,25 +43,12 @@ func ListenAndServeInternal(ctx context.Context, cli config.Cli, vodEngine *pipe
 		"host", cli.HTTPInternalAddress,
 	)
 
-	var err error
-	go func() {
-		err = server.ListenAndServe()
-		cancel()
-	}()
-
-	<-ctx.Done()
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return server.Shutdown(ctx)
+	return serve(ctx, cli, "internal", cli.HTTPInternalAddress, router)
 }
 
-func NewCatalystAPIRouterInternal(cli config.Cli, vodEngine *pipeline.Coordinator, mapic mistapiconnector.IMac, bal balancer.Balancer, c cluster.Cluster, broker misttriggers.TriggerBroker, metricsDB *sql.DB, serfMembersEndpoint, eventsEndpoint string, catalystApiURL string) *httprouter.Router {
-	router := httprouter.New()
-	withLogging := middleware.LogRequest()
+func NewCatalystAPIRouterInternal(cli config.Cli, vodEngine *pipeline.Coordinator, mapic mistapiconnector.IMac, bal balancer.Balancer, c cluster.Cluster, broker misttriggers.TriggerBroker, mistBackups *mistbackup.Backups, metricsDB *sql.DB, cdnRedirects *geolocation.CdnRedirectOverrides, vanityPaths *geolocation.VanityPaths, recordingAutoVOD *handlers.RecordingAutoVOD, nodeStats *catabalancer.SerfNodeStats, heatmap *analytics.GeoHeatmap, serfMembersEndpoint, eventsEndpoint string, catalystApiURL string) *httprouter.Router {
+	router := middleware.NewRouter("internal")
+	router.LimitConcurrency(cli.HTTPRouteConcurrency)
 	withAuth := middleware.IsAuthorized
 
 	capacityMiddleware := middleware.CapacityMiddleware{}
@@ -69,28 +58,43 @@ func NewCatalystAPIRouterInternal(cli config.Cli, vodEngine *pipeline.Coordinato
 		Server:      cli.APIServer,
 		AccessToken: cli.APIToken,
 	})
-	geoHandlers := geolocation.NewGeolocationHandlersCollection(bal, cli, lapi, serfMembersEndpoint)
+	geoHandlers := geolocation.NewGeolocationHandlersCollection(bal, cli, lapi, serfMembersEndpoint, cdnRedirects, vanityPaths)
 
 	spkiPublicKey, _ := crypto.ConvertToSpki(cli.VodDecryptPublicKey)
 
 	catalystApiHandlers := &handlers.CatalystAPIHandlersCollection{VODEngine: vodEngine}
-	eventsHandler := handlers.NewEventsHandlersCollection(c, mapic, bal, eventsEndpoint)
-	ffmpegSegmentingHandlers := &ffmpeg.HandlersCollection{VODEngine: vodEngine}
 	accessControlHandlers := accesscontrol.NewAccessControlHandlersCollection(cli, mapic)
-	analyticsHandlers := analytics.NewAnalyticsHandler(cli, metricsDB)
-	encryptionHandlers := accesscontrol.NewEncryptionHandlersCollection(cli, spkiPublicKey)
-	adminHandlers := &admin.AdminHandlersCollection{Cluster: c}
+	eventsHandler := handlers.NewEventsHandlersCollection(c, mapic, bal, cdnRedirects, vanityPaths, recordingAutoVOD, nodeStats, accessControlHandlers, eventsEndpoint)
+	ffmpegSegmentingHandlers := &ffmpeg.HandlersCollection{VODEngine: vodEngine}
+	analyticsHandlers := analytics.NewAnalyticsHandler(cli, metricsDB, mapic)
+	var vodDecryptKeys *crypto.KeyRing
+	if vodEngine != nil {
+		vodDecryptKeys = vodEngine.VodDecryptKeys
+	}
+	encryptionHandlers := accesscontrol.NewEncryptionHandlersCollection(cli, spkiPublicKey, vodDecryptKeys)
+	adminHandlers := &admin.AdminHandlersCollection{Cluster: c, VODEngine: vodEngine, Balancer: bal, RedirectPrefixes: cli.RedirectPrefixes, MistBackups: mistBackups, Mapic: mapic, MetricsDB: metricsDB, Maintenance: maintenance.Node, Heatmap: heatmap}
 	mistCallbackHandlers := misttriggers.NewMistCallbackHandlersCollection(cli, broker)
 
 	// Simple endpoint for healthchecks
-	router.GET("/ok", withLogging(catalystApiHandlers.Ok()))
+	router.HandleSampled(http.MethodGet, "/ok", cli.AccessLogSampleRate, catalystApiHandlers.Ok())
 
 	var metricsHandlers []http.Handler
 
 	if cli.IsApiMode() {
 		if cli.ShouldMapic() {
 			metricsHandlers = append(metricsHandlers, mapic.MetricsHandler())
+
+			// Changes of the Mist streams and pushes as they happen, for live ops dashboards
+			router.GET("/api/admin/mist/state-diffs", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.MistStateDiffsHandler()))
+			// What the node knows of a live stream, including the URL to ingest it over SRT
+			router.GET("/api/admin/streams/:playbackID", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.StreamInfoHandler()))
+			// Allocates an SRT ingest port to a stream
+			router.POST("/api/admin/streams/:playbackID/srt-ingest", withAuth(cli.APITokens, config.ScopeAdminWrite, adminHandlers.SRTIngestHandler()))
+			// Health of the multistream targets of a stream, without reading their events from AMQP
+			router.GET("/api/stream/:playbackID/multistream/status", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.MultistreamStatusHandler()))
 		}
+		// Audience of a stream by region, for real-time audience maps
+		router.GET("/api/admin/analytics/heatmap/:playbackID", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.GeoHeatmapHandler()))
 		if cli.MistPrometheus != "" {
 			// Enable Mist metrics enrichment
 			metricsHandlers = append(metricsHandlers, mapic.MistMetricsHandler())
@@ -98,22 +102,58 @@ func NewCatalystAPIRouterInternal(cli config.Cli, vodEngine *pipeline.Coordinato
 
 		// Public Catalyst API
 		router.POST("/api/vod",
-			withLogging(
-				withAuth(
-					cli.APIToken,
-					withCapacityChecking(
-						vodEngine,
-						catalystApiHandlers.UploadVOD(),
-					),
+			withAuth(
+				cli.APITokens,
+				config.ScopeVODWrite,
+				withCapacityChecking(
+					vodEngine,
+					catalystApiHandlers.UploadVOD(),
+				),
+			),
+		)
+
+		// Cut a recording into a clip per marker, e.g. its chapters, for highlight reels
+		router.POST("/api/clips",
+			withAuth(
+				cli.APITokens,
+				config.ScopeVODWrite,
+				withCapacityChecking(
+					vodEngine,
+					catalystApiHandlers.ClipBatch(),
 				),
 			),
 		)
 
+		// Accelerate a running job, e.g. when a user starts waiting for it
+		router.POST("/api/vod/:requestID/priority", withAuth(cli.APITokens, config.ScopeVODWrite, catalystApiHandlers.PrioritizeJob()))
+
+		// List the VOD jobs in flight, e.g. for `catalyst-api jobs list`
+		router.GET("/api/admin/jobs", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.JobsHandler()))
+
+		// Look up past jobs in the metrics DB, e.g. for support
+		router.GET("/api/vod/history", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.VODHistoryHandler()))
+
+		// Everything support needs to debug a recent job of this node in one download
+		router.GET("/api/debug/bundle/:requestID", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.DebugBundleHandler()))
+
+		// Storage event notifications for direct uploads, starting the jobs waiting for the uploaded objects of the storage
+		// at ?host=
+		router.POST("/api/storage/notification", withAuth(cli.APITokens, config.ScopeVODWrite, catalystApiHandlers.UploadComplete()))
+
+		// Check that output locations can be written to, e.g. the credentials of a user's own bucket
+		router.POST("/api/storage/validate", withAuth(cli.APITokens, config.ScopeVODWrite, catalystApiHandlers.ValidateStorage()))
+
+		// Delete the outputs of a job, e.g. when the asset is deleted
+		router.DELETE("/api/vod/outputs", withAuth(cli.APITokens, config.ScopeVODWrite, catalystApiHandlers.DeleteVODOutputs()))
+
 		// Public GET handler to retrieve the public key for vod encryption
-		router.GET("/api/pubkey", withLogging(encryptionHandlers.PublicKeyHandler()))
+		router.GET("/api/pubkey", encryptionHandlers.PublicKeyHandler())
+
+		// Load a private key for vod decryption without a restart, e.g. to rotate the node's key
+		router.POST("/api/encryption/keys", withAuth(cli.APITokens, config.ScopeAdminWrite, encryptionHandlers.AddDecryptionKeyHandler()))
 
 		// Endpoint to receive "Triggers" (callbacks) from Mist
-		router.POST("/api/mist/trigger", withLogging(mistCallbackHandlers.Trigger()))
+		router.HandleSampled(http.MethodPost, "/api/mist/trigger", cli.AccessLogSampleRate, mistCallbackHandlers.Trigger())
 
 		// Handler for STREAM_SOURCE triggers
 		broker.OnStreamSource(geoHandlers.HandleStreamSource)
@@ -121,36 +161,94 @@ func NewCatalystAPIRouterInternal(cli config.Cli, vodEngine *pipeline.Coordinato
 		// Handler for USER_NEW triggers
 		broker.OnUserNew(accessControlHandlers.HandleUserNew)
 
+		// Block a playback JWT on all nodes, rejecting its existing sessions too
+		router.POST("/api/access-control/blocked-jwts", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.BlockJWT()))
+		// Reload the -gate-blocked-jwts-list straight away
+		router.POST("/api/access-control/blocked-jwts/reload", withAuth(cli.APITokens, config.ScopeAdminWrite, accessControlHandlers.ReloadBlockedJWTsHandler()))
+
+		// Mint the signed playback URLs of -playback-signing-key
+		if signer := playback.NewURLSigner(cli.PlaybackSigningKey); signer != nil {
+			router.POST("/api/playback/sign", withAuth(cli.APITokens, config.ScopePlaybackSign, handlers.NewSignedPlaybackHandler(signer).Sign()))
+		}
+
 		// Handler for USER_END triggers.
 		broker.OnUserEnd(analyticsHandlers.HandleUserEnd)
 
+		// Handler for RECORDING_END triggers, enqueuing the VOD jobs of the recordings
+		broker.OnRecordingEnd(recordingAutoVOD.HandleRecordingEnd)
+		if cli.StorageWriteThrough {
+			// Mist writes the recordings to the stores itself, they're copied to the backup store once they end
+			broker.OnRecordingEnd(handlers.MirrorRecording)
+		}
+
 		// Endpoint to receive segments and manifests that ffmpeg produces
-		router.POST("/api/ffmpeg/:id/:filename", withLogging(ffmpegSegmentingHandlers.NewFile()))
+		router.HandleSampled(http.MethodPost, "/api/ffmpeg/:id/:filename", cli.AccessLogSampleRate, ffmpegSegmentingHandlers.NewFile())
 
 		// Handler to forward the user event from Catalyst => Catalyst API
-		router.POST("/api/serf/receiveUserEvent", withLogging(eventsHandler.ReceiveUserEvent()))
+		router.HandleSampled(http.MethodPost, "/api/serf/receiveUserEvent", cli.AccessLogSampleRate, eventsHandler.ReceiveUserEvent())
 	} else {
 		// Endpoint to receive "Triggers" (callbacks) from Mist and redirect them to the standalone Catalyst API
 		mistTriggerHandlerEndpoint := fmt.Sprintf("%s/api/mist/trigger", catalystApiURL)
-		router.POST("/api/mist/trigger", withLogging(handlers.ProxyRequest(mistTriggerHandlerEndpoint)))
+		router.HandleSampled(http.MethodPost, "/api/mist/trigger", cli.AccessLogSampleRate, handlers.ProxyRequest(mistTriggerHandlerEndpoint))
 	}
 
 	metricsHandlers = append(metricsHandlers, promhttp.Handler())
-	// Hacky combined metrics handler. To be refactored away with mapic.
-	router.GET("/metrics", concatHandlers(metricsHandlers...))
+	// Hacky combined metrics handler. To be refactored away with mapic. Not logged, it's scraped every few seconds.
+	router.Router.GET("/metrics", concatHandlers(metricsHandlers...))
+
+	// Runtime CDN redirect rules, propagated to all nodes
+	router.GET("/api/cdn-redirect", withAuth(cli.APITokens, config.ScopeAdminRead, eventsHandler.CdnRedirectRules(cli)))
+	router.PUT("/api/cdn-redirect/:playbackID", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.SetCdnRedirectRule()))
+	router.DELETE("/api/cdn-redirect/:playbackID", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.DeleteCdnRedirectRule()))
+
+	// Vanity paths to playback IDs, propagated to all nodes
+	router.GET("/api/vanity-paths", withAuth(cli.APITokens, config.ScopeAdminRead, eventsHandler.VanityPaths()))
+	router.PUT("/api/vanity-paths/:path", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.SetVanityPath()))
+	router.DELETE("/api/vanity-paths/:path", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.DeleteVanityPath()))
+
+	// Where the balancers would send a viewer of a playback ID
+	router.GET("/api/admin/balancer/:playbackID", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.BalancerDecisionsHandler()))
+
+	if fed, ok := bal.(*federation.FederatedBalancer); ok {
+		// Summary of this cluster for the other clusters of the federation
+		router.GET(federation.SummaryPath, withAuth(cli.APITokens, config.ScopeFederationRead, adminHandlers.FederationSummaryHandler(fed.Local)))
+	}
+
+	if maintenance.Node != nil {
+		// Drain the node by hand or change its maintenance windows, e.g. for host patching
+		router.GET("/api/admin/maintenance", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.MaintenanceHandler()))
+		router.POST("/api/admin/maintenance/drain", withAuth(cli.APITokens, config.ScopeAdminWrite, adminHandlers.DrainHandler()))
+		router.PUT("/api/admin/maintenance/windows", withAuth(cli.APITokens, config.ScopeAdminWrite, adminHandlers.MaintenanceWindowsHandler()))
+	}
 
 	if cli.IsClusterMode() {
 		// Temporary endpoint for admin queries
-		router.GET("/admin/members", withLogging(adminHandlers.MembersHandler()))
+		router.HandleSampled(http.MethodGet, "/admin/members", cli.AccessLogSampleRate, adminHandlers.MembersHandler())
+		if mistBackups != nil {
+			// Backups of the Mist triggers and stream configs, to roll back a bad update
+			router.GET("/api/mist/config-backups", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.MistBackupsHandler()))
+			router.POST("/api/mist/config-backups", withAuth(cli.APITokens, config.ScopeAdminWrite, adminHandlers.BackupMistConfigHandler()))
+			router.GET("/api/mist/config-backups/:id", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.MistBackupHandler()))
+			router.GET("/api/mist/config-backups/:id/diff", withAuth(cli.APITokens, config.ScopeAdminRead, adminHandlers.MistBackupDiffHandler()))
+			router.POST("/api/mist/config-backups/:id/restore", withAuth(cli.APITokens, config.ScopeAdminWrite, adminHandlers.RestoreMistBackupHandler()))
+		}
 		// Handler to get members Catalyst API => Catalyst
-		router.GET("/api/serf/members", withLogging(adminHandlers.MembersHandler()))
+		router.HandleSampled(http.MethodGet, "/api/serf/members", cli.AccessLogSampleRate, adminHandlers.MembersHandler())
 		// Public handler to propagate an event to all Catalyst nodes, execute from Studio API => Catalyst
-		router.POST("/api/events", withLogging(eventsHandler.Events()))
+		router.POST("/api/events", eventsHandler.Events())
+		// Handler to propagate the events changing the playback rules to all Catalyst nodes
+		router.POST("/api/admin/events", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.AdminEvents()))
+		// Nuke a stream on all the nodes, waiting for them to act, with an audit trail of who asked for it and why
+		nukeAudit := handlers.NewStreamNukeAudit(metricsDB)
+		router.POST("/api/admin/streams/:playbackID/nuke", withAuth(cli.APITokens, config.ScopeAdminWrite, eventsHandler.NukeStreamEverywhere(nukeAudit)))
+		router.GET("/api/admin/nukes", withAuth(cli.APITokens, config.ScopeAdminRead, eventsHandler.NukeAuditLog(nukeAudit)))
 	} else {
-		router.POST("/api/events", withLogging(handlers.ProxyRequest(eventsEndpoint)))
+		router.POST("/api/events", handlers.ProxyRequest(eventsEndpoint))
+		// The Authorization header is passed on, for the Catalyst node to check
+		router.POST("/api/admin/events", handlers.ProxyRequest(handlers.AdminEventsEndpoint(eventsEndpoint)))
 	}
 
-	return router
+	return router.Router
 }
 
 // Hack to combine main metrics and mapic metrics. To be refactored away with mapic.
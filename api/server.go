@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/metrics"
+	"golang.org/x/net/netutil"
+)
+
+// serve runs an API server until the context is done, with the connection limit and timeouts of the flags. The
+// connections over the limit wait in the listen backlog until others are closed, so that a storm of requests slows
+// the server down rather than exhausting its file descriptors.
+func serve(ctx context.Context, cli config.Cli, name, addr string, handler http.Handler) error {
+	connections := metrics.Metrics.HTTPConnections.WithLabelValues(name)
+	goroutines := metrics.Metrics.HTTPHandlerGoroutines.WithLabelValues(name)
+	server := http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			goroutines.Inc()
+			defer goroutines.Dec()
+			handler.ServeHTTP(w, r)
+		}),
+		ReadTimeout:  cli.HTTPReadTimeout,
+		WriteTimeout: cli.HTTPWriteTimeout,
+		IdleTimeout:  cli.HTTPIdleTimeout,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				connections.Inc()
+			case http.StateHijacked, http.StateClosed:
+				connections.Dec()
+			}
+		},
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if cli.HTTPMaxConnections > 0 {
+		listener = netutil.LimitListener(listener, cli.HTTPMaxConnections)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		err = server.Serve(listener)
+		cancel()
+	}()
+
+	<-ctx.Done()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
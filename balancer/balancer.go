@@ -19,6 +19,13 @@ type Balancer interface {
 	MistUtilLoadSource(ctx context.Context, streamID, lat, lon string) (string, error)
 }
 
+// Previewer is implemented by the balancers that can tell where GetBestNode would send a viewer without its side
+// effects, i.e. without counting the decision in the metrics or in the load of the chosen node. The nodes one of
+// which would be picked are returned, with the full playback ID.
+type Previewer interface {
+	PreviewBestNode(ctx context.Context, redirectPrefixes []string, playbackID, lat, lon string) ([]string, string, error)
+}
+
 // CombinedBalancerEnabled checks if catabalancer is enabled in any way
 // enabled - catabalancer fully enabled
 // background - only run in background, no results are used
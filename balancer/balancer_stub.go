@@ -32,6 +32,10 @@ func (b *BalancerStub) GetBestNode(ctx context.Context, redirectPrefixes []strin
 	return "localhost", playbackID, nil
 }
 
+func (b *BalancerStub) PreviewBestNode(ctx context.Context, redirectPrefixes []string, playbackID, lat, lon string) ([]string, string, error) {
+	return []string{"localhost"}, playbackID, nil
+}
+
 func (b *BalancerStub) QueryMistForClosestNodeSource(ctx context.Context, playbackID, lat, lon, prefix string, source bool) (string, error) {
 	return "dtsc://localhost", nil
 }
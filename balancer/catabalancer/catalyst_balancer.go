@@ -2,8 +2,6 @@ package catabalancer
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"sort"
@@ -16,12 +14,15 @@ import (
 	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/cluster"
 	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/patrickmn/go-cache"
 )
 
 const (
 	stateCacheKey  = "stateCacheKey"
 	dbQueryTimeout = 10 * time.Second
+	// How many of the best nodes a viewer is sent to one of at random
+	selectedTopNodes = 3
 )
 
 type CataBalancer struct {
@@ -29,9 +30,14 @@ type CataBalancer struct {
 
 	metricTimeout       time.Duration
 	ingestStreamTimeout time.Duration
-	nodeStatsDB         *sql.DB
+	nodeStats           NodeStatsStore
 	nodeStatsCache      *cache.Cache
 	cacheMutex          sync.Mutex
+	// playbackID -> name of the node the stream was last ingested on, kept for a while after the stream ends so
+	// that a broadcaster reconnecting after a brief drop goes back to the same node
+	ingestAffinity *cache.Cache
+
+	metricsExporter nodeMetricsExporter
 }
 
 type stats struct {
@@ -62,6 +68,8 @@ type NodeMetrics struct {
 	GeoLatitude              float64   `json:"la,omitempty"`
 	GeoLongitude             float64   `json:"lo,omitempty"`
 	Timestamp                time.Time `json:"t,omitempty"` // the time we received these node metrics
+	// Set while the node is draining for maintenance, so that it's not picked for new viewers
+	Draining bool `json:"d,omitempty"`
 }
 
 // All of the scores are in the range 0-2, where:
@@ -100,6 +108,8 @@ type NodeUpdateEvent struct {
 	NodeID      string      `json:"n,omitempty"`
 	NodeMetrics NodeMetrics `json:"nm,omitempty"`
 	Streams     string      `json:"s,omitempty"`
+	// The account of each ingest stream, when known, to count the ingests of an account across the cluster
+	IngestUsers map[string]string `json:"iu,omitempty"`
 }
 
 func (n *NodeUpdateEvent) SetStreams(streamIDs []string, ingestStreamIDs []string) {
@@ -122,17 +132,23 @@ func (n *NodeUpdateEvent) GetIngestStreams() []string {
 	return []string{}
 }
 
-func NewBalancer(nodeName string, metricTimeout time.Duration, ingestStreamTimeout time.Duration, nodeStatsDB *sql.DB, cacheExpiry time.Duration) *CataBalancer {
-	return &CataBalancer{
+func NewBalancer(nodeName string, metricTimeout time.Duration, ingestStreamTimeout time.Duration, nodeStats NodeStatsStore, cacheExpiry time.Duration, ingestAffinityTTL time.Duration) *CataBalancer {
+	c := &CataBalancer{
 		NodeName:            nodeName,
 		metricTimeout:       metricTimeout,
 		ingestStreamTimeout: ingestStreamTimeout,
-		nodeStatsDB:         nodeStatsDB,
+		nodeStats:           nodeStats,
 		nodeStatsCache:      cache.New(cacheExpiry, 10*time.Minute),
 	}
+	if ingestAffinityTTL > 0 {
+		c.ingestAffinity = cache.New(ingestAffinityTTL, 10*time.Minute)
+	}
+	return c
 }
 
+// Start exports the stats of the nodes as metrics in the background, see publishNodeMetrics
 func (c *CataBalancer) Start(ctx context.Context) error {
+	go c.publishNodeMetricsLoop(ctx)
 	return nil
 }
 
@@ -145,48 +161,129 @@ func (c *CataBalancer) GetBestNode(ctx context.Context, redirectPrefixes []strin
 	if err != nil {
 		return "", "", fmt.Errorf("error refreshing nodes: %w", err)
 	}
-
-	latf := 0.0
-	if lat != "" {
-		latf, err = strconv.ParseFloat(lat, 64)
-		if err != nil {
-			return "", "", err
-		}
-	}
-	lonf := 0.0
-	if lon != "" {
-		lonf, err = strconv.ParseFloat(lon, 64)
-		if err != nil {
-			return "", "", err
-		}
+	latf, lonf, err := parseLocation(lat, lon)
+	if err != nil {
+		return "", "", err
 	}
 
 	// default to ourself if there are no other nodes
 	nodeName := c.NodeName
 
 	scoredNodes := c.createScoredNodes(s)
-	if len(scoredNodes) > 0 {
+	if affinityNode, ok := c.getIngestAffinityNode(scoredNodes, playbackID, isStudioReq); ok {
+		nodeName = affinityNode
+	} else if len(scoredNodes) > 0 {
 		node, err := SelectNode(scoredNodes, playbackID, latf, lonf)
 		if err != nil {
 			return "", "", err
 		}
 		nodeName = node.Name
+		recordNodeScores(scoredNodes, playbackID)
 	} else {
 		log.LogNoRequestID("catabalancer no nodes found, choosing myself", "chosenNode", nodeName, "streamID", playbackID, "reqLat", lat, "reqLon", lon)
 	}
+	metrics.Metrics.CatabalancerMetrics.NodeSelected.WithLabelValues(nodeName).Inc()
+
+	return nodeName, fullPlaybackID(redirectPrefixes, playbackID), nil
+}
 
+// PreviewBestNode returns the nodes GetBestNode picks one of at random for a viewer of the playback ID, without
+// recording the decision in the metrics
+func (c *CataBalancer) PreviewBestNode(ctx context.Context, redirectPrefixes []string, playbackID, lat, lon string) ([]string, string, error) {
+	s, err := c.refreshNodes(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("error refreshing nodes: %w", err)
+	}
+	latf, lonf, err := parseLocation(lat, lon)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nodeNames := []string{c.NodeName}
+	if scoredNodes := c.createScoredNodes(s); len(scoredNodes) > 0 {
+		nodeNames = nil
+		for _, node := range TopNodes(scoredNodes, playbackID, latf, lonf) {
+			nodeNames = append(nodeNames, node.Name)
+		}
+	}
+	return nodeNames, fullPlaybackID(redirectPrefixes, playbackID), nil
+}
+
+func parseLocation(lat, lon string) (float64, float64, error) {
+	latf, lonf := 0.0, 0.0
+	var err error
+	if lat != "" {
+		latf, err = strconv.ParseFloat(lat, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if lon != "" {
+		lonf, err = strconv.ParseFloat(lon, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return latf, lonf, nil
+}
+
+func fullPlaybackID(redirectPrefixes []string, playbackID string) string {
 	prefix := "video"
 	if len(redirectPrefixes) > 0 {
 		prefix = redirectPrefixes[0]
 	}
-	return nodeName, fmt.Sprintf("%s+%s", prefix, playbackID), nil
+	return fmt.Sprintf("%s+%s", prefix, playbackID)
+}
+
+// HealthyNodes returns the nodes with fresh metrics that aren't draining, as considered for playback
+func (c *CataBalancer) HealthyNodes(ctx context.Context) ([]ScoredNode, error) {
+	s, err := c.refreshNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing nodes: %w", err)
+	}
+	return c.createScoredNodes(s), nil
+}
+
+// getIngestAffinityNode returns the node that the stream was recently ingested on, if it's still healthy
+func (c *CataBalancer) getIngestAffinityNode(scoredNodes []ScoredNode, playbackID string, isIngest bool) (string, bool) {
+	if !isIngest || c.ingestAffinity == nil {
+		return "", false
+	}
+	nodeName, found := c.ingestAffinity.Get(playbackID)
+	if !found {
+		return "", false
+	}
+	for _, node := range scoredNodes {
+		if node.Name == nodeName && node.GetLoadScore() > 0 {
+			log.LogNoRequestID("catabalancer choosing previous ingest node", "chosenNode", node.Name, "streamID", playbackID)
+			return node.Name, true
+		}
+	}
+	log.LogNoRequestID("catabalancer previous ingest node not available", "previousNode", nodeName, "streamID", playbackID)
+	return "", false
+}
+
+// recordIngestAffinity remembers the node of every active ingest stream. The entries are refreshed for as long as the
+// stream is live, so they expire once the stream has been gone for the affinity TTL.
+func (c *CataBalancer) recordIngestAffinity(s stats) {
+	if c.ingestAffinity == nil {
+		return
+	}
+	for nodeName, streams := range s.IngestStreams {
+		for _, stream := range streams {
+			c.ingestAffinity.SetDefault(stream.PlaybackID, nodeName)
+		}
+	}
 }
 
 func (c *CataBalancer) createScoredNodes(s stats) []ScoredNode {
 	var nodesList []ScoredNode
-	for nodeName, metrics := range s.NodeMetrics {
-		if isStale(metrics.Timestamp, c.metricTimeout) {
-			log.LogNoRequestID("catabalancer ignoring node with stale metrics", "nodeName", nodeName, "timestamp", metrics.Timestamp)
+	for nodeName, nodeMetrics := range s.NodeMetrics {
+		if isStale(nodeMetrics.Timestamp, c.metricTimeout) {
+			log.LogNoRequestID("catabalancer ignoring node with stale metrics", "nodeName", nodeName, "timestamp", nodeMetrics.Timestamp)
+			continue
+		}
+		if nodeMetrics.Draining {
 			continue
 		}
 		// make a copy of the streams map so that we can release the nodesLock (UpdateStreams will be making changes in the background)
@@ -227,7 +324,7 @@ func SelectNode(nodes []ScoredNode, streamID string, requestLatitude, requestLon
 		return Node{}, fmt.Errorf("no nodes to select from")
 	}
 
-	topNodes := selectTopNodes(nodes, streamID, requestLatitude, requestLongitude, 3)
+	topNodes := selectTopNodes(nodes, streamID, requestLatitude, requestLongitude, selectedTopNodes)
 
 	if len(topNodes) == 0 {
 		return Node{}, fmt.Errorf("selectTopNodes returned no nodes")
@@ -237,6 +334,15 @@ func SelectNode(nodes []ScoredNode, streamID string, requestLatitude, requestLon
 	return chosen, nil
 }
 
+// TopNodes returns the nodes SelectNode picks one of at random, without logging the decision
+func TopNodes(nodes []ScoredNode, streamID string, requestLatitude, requestLongitude float64) []Node {
+	var topNodes []Node
+	for _, node := range selectTopNodes(nodes, streamID, requestLatitude, requestLongitude, selectedTopNodes) {
+		topNodes = append(topNodes, node.Node)
+	}
+	return topNodes
+}
+
 func selectTopNodes(scoredNodes []ScoredNode, streamID string, requestLatitude, requestLongitude float64, numNodes int) []ScoredNode {
 	scoredNodes = geoScores(scoredNodes, requestLatitude, requestLongitude)
 
@@ -326,33 +432,12 @@ func (c *CataBalancer) refreshNodes(ctx context.Context) (stats, error) {
 		NodeMetrics:   make(map[string]NodeMetrics),
 	}
 
-	if c.nodeStatsDB == nil {
-		return s, fmt.Errorf("node stats DB was nil")
-	}
-
-	queryContext, cancel := context.WithTimeout(ctx, dbQueryTimeout)
-	defer cancel()
-
-	query := "SELECT stats FROM node_stats"
-	rows, err := c.nodeStatsDB.QueryContext(queryContext, query)
+	nodeUpdates, err := c.nodeStats.GetNodeUpdates(ctx)
 	if err != nil {
-		return s, fmt.Errorf("failed to query node stats: %w", err)
+		return s, err
 	}
-	defer rows.Close()
-
-	// Process the result set
-	for rows.Next() {
-		var statsBytes []byte
-		if err := rows.Scan(&statsBytes); err != nil {
-			return s, fmt.Errorf("failed to scan node stats row: %w", err)
-		}
-
-		var event NodeUpdateEvent
-		err = json.Unmarshal(statsBytes, &event)
-		if err != nil {
-			return s, fmt.Errorf("failed to unmarshal node update event: %w", err)
-		}
 
+	for _, event := range nodeUpdates {
 		if isStale(event.NodeMetrics.Timestamp, c.metricTimeout) {
 			log.LogNoRequestID("catabalancer skipping stale data while refreshing", "nodeID", event.NodeID, "timestamp", event.NodeMetrics.Timestamp)
 			continue
@@ -373,12 +458,8 @@ func (c *CataBalancer) refreshNodes(ctx context.Context) (stats, error) {
 		}
 	}
 
-	// Check for errors after iterating through rows
-	if err := rows.Err(); err != nil {
-		return s, err
-	}
-
 	c.nodeStatsCache.SetDefault(stateCacheKey, &s)
+	c.recordIngestAffinity(s)
 	return s, nil
 }
 
@@ -416,7 +497,7 @@ func isStale(timestamp time.Time, stale time.Duration) bool {
 	return time.Since(timestamp) >= stale
 }
 
-func StartMetricSending(nodeName string, latitude float64, longitude float64, mist clients.MistAPIClient, nodeStatsDB *sql.DB) {
+func StartMetricSending(nodeName string, latitude float64, longitude float64, mist clients.MistAPIClient, nodeStats NodeStatsStore, draining func() bool, ingestUser func(streamName string) string) {
 	ticker := time.NewTicker(UpdateNodeStatsEvery)
 	go func() {
 		for range ticker.C {
@@ -427,7 +508,7 @@ func StartMetricSending(nodeName string, latitude float64, longitude float64, mi
 			}
 
 			event := NodeUpdateEvent{
-				Resource: "nodeUpdate",
+				Resource: nodeUpdateResource,
 				NodeID:   nodeName,
 				NodeMetrics: NodeMetrics{
 					CPUUsagePercentage:       sysusage.CPUUsagePercentage,
@@ -437,6 +518,7 @@ func StartMetricSending(nodeName string, latitude float64, longitude float64, mi
 					GeoLatitude:              latitude,
 					GeoLongitude:             longitude,
 					Timestamp:                time.Now(),
+					Draining:                 draining(),
 				},
 			}
 
@@ -451,6 +533,12 @@ func StartMetricSending(nodeName string, latitude float64, longitude float64, mi
 				for streamID := range mistState.ActiveStreams {
 					if mistState.IsIngestStream(streamID) {
 						ingestStreams = append(ingestStreams, streamID)
+						if user := ingestUserOf(ingestUser, streamID); user != "" {
+							if event.IngestUsers == nil {
+								event.IngestUsers = map[string]string{}
+							}
+							event.IngestUsers[streamID] = user
+						}
 					} else {
 						nonIngestStreams = append(nonIngestStreams, streamID)
 					}
@@ -458,27 +546,17 @@ func StartMetricSending(nodeName string, latitude float64, longitude float64, mi
 				event.SetStreams(nonIngestStreams, ingestStreams)
 			}
 
-			payload, err := json.Marshal(event)
-			if err != nil {
-				log.LogNoRequestID("catabalancer failed to marhsal node update", "err", err)
-				continue
-			}
-
-			insertStatement := `insert into "node_stats"(
-                            "node_id",
-                            "stats"
-                            ) values($1, $2)
-							ON CONFLICT (node_id)
-							DO UPDATE SET stats = EXCLUDED.stats;`
-			_, err = nodeStatsDB.Exec(
-				insertStatement,
-				nodeName,
-				payload,
-			)
-			if err != nil {
-				log.LogNoRequestID("error writing postgres node stats", "err", err)
+			if err := nodeStats.PublishNodeUpdate(event); err != nil {
+				log.LogNoRequestID("catabalancer failed to publish node stats", "err", err)
 				continue
 			}
 		}
 	}()
 }
+
+func ingestUserOf(ingestUser func(string) string, streamName string) string {
+	if ingestUser == nil {
+		return ""
+	}
+	return ingestUser(streamName)
+}
@@ -9,6 +9,8 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/livepeer/catalyst-api/cluster"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 )
@@ -58,7 +60,7 @@ func TestItReturnsItselfWhenNoOtherNodesPresent(t *testing.T) {
 	require.NoError(t, err)
 	mock.ExpectQuery("SELECT stats FROM node_stats").
 		WillReturnRows(sqlmock.NewRows([]string{"stats"}).AddRow("{}"))
-	c := NewBalancer("me", time.Second, time.Second, db, 0)
+	c := NewBalancer("me", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
 	nodeName, prefix, err := c.GetBestNode(context.Background(), nil, "playbackID", "", "", "", false)
 	require.NoError(t, err)
 	require.Equal(t, "me", nodeName)
@@ -68,7 +70,7 @@ func TestItReturnsItselfWhenNoOtherNodesPresent(t *testing.T) {
 func TestStaleNodes(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	c := NewBalancer("me", time.Second, time.Second, db, 1*time.Millisecond)
+	c := NewBalancer("me", time.Second, time.Second, NewDBNodeStats(db), 1*time.Millisecond, 0)
 	err = c.UpdateMembers(context.Background(), []cluster.Member{{Name: "node1", Tags: mediaTags}})
 	require.NoError(t, err)
 
@@ -303,7 +305,7 @@ func TestSetMetrics(t *testing.T) {
 	// simple check that node metrics make it through to the load balancing algo
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	c := NewBalancer("", time.Second, time.Second, db, 0)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
 	err = c.UpdateMembers(context.Background(), []cluster.Member{{Name: "node1", Tags: mediaTags}, {Name: "node2", Tags: mediaTags}})
 	require.NoError(t, err)
 
@@ -318,10 +320,31 @@ func TestSetMetrics(t *testing.T) {
 	require.Equal(t, "video+1234", fullPlaybackID)
 }
 
+func TestPreviewBestNode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
+
+	setNodeMetrics(t, mock, []NodeUpdateEvent{
+		{NodeID: "node1", NodeMetrics: NodeMetrics{CPUUsagePercentage: 90, Timestamp: time.Now()}},
+		{NodeID: "node2", NodeMetrics: NodeMetrics{CPUUsagePercentage: 0, Timestamp: time.Now()}},
+	})
+
+	selected := testutil.ToFloat64(metrics.Metrics.CatabalancerMetrics.NodeSelected.WithLabelValues("node2"))
+	nodes, fullPlaybackID, err := c.PreviewBestNode(context.Background(), nil, "1234", "", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"node2"}, nodes)
+	require.Equal(t, "video+1234", fullPlaybackID)
+	require.Equal(t, selected, testutil.ToFloat64(metrics.Metrics.CatabalancerMetrics.NodeSelected.WithLabelValues("node2")))
+
+	_, _, err = c.PreviewBestNode(context.Background(), nil, "1234", "invalid", "")
+	require.Error(t, err)
+}
+
 func TestNoIngestStream(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	c := NewBalancer("", time.Second, time.Second, db, 0)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
 	// first test no nodes available
 	nodeStats := NodeUpdateEvent{NodeID: "id", NodeMetrics: NodeMetrics{Timestamp: time.Now()}}
 	nodeStats.SetStreams([]string{"stream"}, nil)
@@ -345,7 +368,7 @@ func TestNoIngestStream(t *testing.T) {
 func TestMistUtilLoadSource(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	c := NewBalancer("", time.Second, time.Second, db, 1*time.Millisecond)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 1*time.Millisecond, 0)
 	err = c.UpdateMembers(context.Background(), []cluster.Member{{
 		Name: "node",
 		Tags: mediaTags,
@@ -381,7 +404,7 @@ func TestMistUtilLoadSource(t *testing.T) {
 func TestStreamTimeout(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	c := NewBalancer("", time.Second, time.Second, db, 0)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
 	err = c.UpdateMembers(context.Background(), []cluster.Member{{
 		Name: "node",
 		Tags: mediaTags,
@@ -421,6 +444,65 @@ func TestStreamTimeout(t *testing.T) {
 	require.Empty(t, nodes)
 }
 
+func TestDrainingNodesAreNotPicked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
+
+	draining := NodeUpdateEvent{NodeID: "draining", NodeMetrics: NodeMetrics{Timestamp: time.Now(), Draining: true}}
+	draining.SetStreams([]string{"video+stream"}, []string{"video+ingest"})
+	available := NodeUpdateEvent{NodeID: "available", NodeMetrics: NodeMetrics{Timestamp: time.Now()}}
+	setNodeMetrics(t, mock, []NodeUpdateEvent{draining, available})
+	s, err := c.refreshNodes(context.Background())
+	require.NoError(t, err)
+
+	nodes := c.createScoredNodes(s)
+	require.Len(t, nodes, 1)
+	require.Equal(t, "available", nodes[0].Name)
+
+	// The streams ingested on a draining node can still be pulled from it
+	source, err := c.MistUtilLoadSource(context.Background(), "video+ingest", "", "")
+	require.NoError(t, err)
+	require.Equal(t, "dtsc://draining", source)
+}
+
+func TestIngestAffinity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), time.Millisecond, time.Minute)
+
+	// node1 is closer to the broadcaster but the stream was ingested on node2
+	node1 := NodeUpdateEvent{NodeID: "node1", NodeMetrics: NodeMetrics{Timestamp: time.Now(), GeoLatitude: 1, GeoLongitude: 1}}
+	node2 := NodeUpdateEvent{NodeID: "node2", NodeMetrics: NodeMetrics{Timestamp: time.Now(), GeoLatitude: 50, GeoLongitude: 50}}
+	node2.SetStreams(nil, []string{"video+1234"})
+	setNodeMetrics(t, mock, []NodeUpdateEvent{node1, node2})
+	_, err = c.refreshNodes(context.Background())
+	require.NoError(t, err)
+
+	// The stream ends and the broadcaster reconnects
+	node2.SetStreams(nil, nil)
+	time.Sleep(2 * time.Millisecond)
+	setNodeMetrics(t, mock, []NodeUpdateEvent{node1, node2})
+	node, _, err := c.GetBestNode(context.Background(), nil, "1234", "1", "1", "", true)
+	require.NoError(t, err)
+	require.Equal(t, "node2", node)
+
+	// Playback requests aren't affected
+	time.Sleep(2 * time.Millisecond)
+	setNodeMetrics(t, mock, []NodeUpdateEvent{node1, node2})
+	node, _, err = c.GetBestNode(context.Background(), nil, "1234", "1", "1", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "node1", node)
+
+	// The previous node isn't used once it's overloaded
+	node2.NodeMetrics.CPUUsagePercentage = 100
+	time.Sleep(2 * time.Millisecond)
+	setNodeMetrics(t, mock, []NodeUpdateEvent{node1, node2})
+	node, _, err = c.GetBestNode(context.Background(), nil, "1234", "1", "1", "", true)
+	require.NoError(t, err)
+	require.Equal(t, "node1", node)
+}
+
 func TestSimulate(t *testing.T) {
 	// update these values to test the lock contention with higher numbers of nodes etc
 	nodeCount := 1
@@ -430,7 +512,7 @@ func TestSimulate(t *testing.T) {
 
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
-	c := NewBalancer("node0", time.Second, time.Second, db, 0)
+	c := NewBalancer("node0", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
 	var nodes []cluster.Member
 	for i := 0; i < nodeCount; i++ {
 		nodes = append(nodes, cluster.Member{Name: fmt.Sprintf("node%d", i)})
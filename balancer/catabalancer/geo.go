@@ -15,6 +15,24 @@ func toRadians(deg float64) float64 {
 	return deg * (math.Pi / 180)
 }
 
+// GeoDistance returns the distance in kilometers between two points, rounded to the kilometer
+func GeoDistance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	// Convert latitude and longitude from degrees to radians
+	lat1 := toRadians(latitude1)
+	lon1 := toRadians(longitude1)
+	lat2 := toRadians(latitude2)
+	lon2 := toRadians(longitude2)
+
+	// Haversine formula
+	dlat := lat2 - lat1
+	dlon := lon2 - lon1
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	// Distance in kilometers
+	return math.Round(earthRadius * c)
+}
+
 // Rate the nodes as Good / Okay / Bad based on distance from the request
 func geoScores(nodes []ScoredNode, requestLatitude, requestLongitude float64) []ScoredNode {
 	if len(nodes) < 1 {
@@ -24,20 +42,7 @@ func geoScores(nodes []ScoredNode, requestLatitude, requestLongitude float64) []
 
 	// Calculate distance from request for each node
 	for i := range nodes {
-		// Convert latitude and longitude from degrees to radians
-		lat1 := toRadians(requestLatitude)
-		lon1 := toRadians(requestLongitude)
-		lat2 := toRadians(nodes[i].GeoLatitude)
-		lon2 := toRadians(nodes[i].GeoLongitude)
-
-		// Haversine formula
-		dlat := lat2 - lat1
-		dlon := lon2 - lon1
-		a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
-		c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-		// Distance in kilometers
-		nodes[i].GeoDistance = math.Round(earthRadius * c)
+		nodes[i].GeoDistance = GeoDistance(requestLatitude, requestLongitude, nodes[i].GeoLatitude, nodes[i].GeoLongitude)
 	}
 
 	// Order nodes by distance
@@ -0,0 +1,100 @@
+package catabalancer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nodeMetricsExporter keeps track of the nodes the catabalancer metrics are exported for, to remove the ones of the
+// nodes gone from the node stats
+type nodeMetricsExporter struct {
+	mu    sync.Mutex
+	nodes map[string]bool
+}
+
+// publishNodeMetricsLoop exports the stats of every node as metrics until the context is done, see publishNodeMetrics
+func (c *CataBalancer) publishNodeMetricsLoop(ctx context.Context) {
+	ticker := time.NewTicker(UpdateNodeStatsEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.publishNodeMetrics(ctx)
+		}
+	}
+}
+
+// publishNodeMetrics exports the resource usage, load score, draining state and staleness of the latest stats of every
+// node, the stale ones included, so that it shows why some nodes are picked over the others
+func (c *CataBalancer) publishNodeMetrics(ctx context.Context) {
+	events, err := c.nodeStats.GetNodeUpdates(ctx)
+	if err != nil {
+		log.LogNoRequestID("catabalancer failed to get node stats for metrics", "err", err)
+		return
+	}
+
+	m := metrics.Metrics.CatabalancerMetrics
+	nodes := map[string]bool{}
+	for _, event := range events {
+		nodes[event.NodeID] = true
+		nm := event.NodeMetrics
+		m.NodeUsage.WithLabelValues(event.NodeID, "cpu").Set(nm.CPUUsagePercentage)
+		m.NodeUsage.WithLabelValues(event.NodeID, "ram").Set(nm.RAMUsagePercentage)
+		m.NodeUsage.WithLabelValues(event.NodeID, "bandwidth").Set(nm.BandwidthUsagePercentage)
+		m.NodeUsage.WithLabelValues(event.NodeID, "load_avg").Set(nm.LoadAvg)
+		m.NodeStatsAge.WithLabelValues(event.NodeID).Set(time.Since(nm.Timestamp).Seconds())
+		m.NodeDraining.WithLabelValues(event.NodeID).Set(boolGauge(nm.Draining))
+		m.NodeScore.WithLabelValues(event.NodeID, "load").Set(float64(ScoredNode{NodeMetrics: nm}.GetLoadScore()))
+	}
+
+	c.metricsExporter.mu.Lock()
+	defer c.metricsExporter.mu.Unlock()
+	for node := range c.metricsExporter.nodes {
+		if !nodes[node] {
+			deleteNodeMetrics(node)
+		}
+	}
+	c.metricsExporter.nodes = nodes
+}
+
+// recordNodeScores exports the scores of the nodes considered for a viewer or broadcaster of the stream, once
+// SelectNode has scored their distance
+func recordNodeScores(nodes []ScoredNode, streamID string) {
+	m := metrics.Metrics.CatabalancerMetrics
+	for _, node := range nodes {
+		var streamScore int64
+		if node.HasStream(streamID) {
+			streamScore = 2
+		}
+		loadScore := int64(node.GetLoadScore())
+		m.NodeScore.WithLabelValues(node.Name, "load").Set(float64(loadScore))
+		m.NodeScore.WithLabelValues(node.Name, "geo").Set(float64(node.GeoScore))
+		m.NodeScore.WithLabelValues(node.Name, "stream").Set(float64(streamScore))
+		m.NodeScore.WithLabelValues(node.Name, "total").Set(float64(loadScore + node.GeoScore + streamScore))
+		m.NodeGeoDistance.WithLabelValues(node.Name).Set(node.GeoDistance)
+	}
+}
+
+func deleteNodeMetrics(node string) {
+	m := metrics.Metrics.CatabalancerMetrics
+	labels := prometheus.Labels{"node": node}
+	m.NodeUsage.DeletePartialMatch(labels)
+	m.NodeStatsAge.DeletePartialMatch(labels)
+	m.NodeDraining.DeletePartialMatch(labels)
+	m.NodeScore.DeletePartialMatch(labels)
+	m.NodeGeoDistance.DeletePartialMatch(labels)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package catabalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishNodeMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
+	m := metrics.Metrics.CatabalancerMetrics
+
+	setNodeMetrics(t, mock, []NodeUpdateEvent{
+		{NodeID: "metrics-node1", NodeMetrics: NodeMetrics{CPUUsagePercentage: 60, RAMUsagePercentage: 10, Timestamp: time.Now().Add(-time.Minute)}},
+		{NodeID: "metrics-node2", NodeMetrics: NodeMetrics{BandwidthUsagePercentage: 20, Draining: true, Timestamp: time.Now()}},
+	})
+	c.publishNodeMetrics(context.Background())
+
+	require.Equal(t, 60.0, testutil.ToFloat64(m.NodeUsage.WithLabelValues("metrics-node1", "cpu")))
+	require.Equal(t, 10.0, testutil.ToFloat64(m.NodeUsage.WithLabelValues("metrics-node1", "ram")))
+	require.Equal(t, 20.0, testutil.ToFloat64(m.NodeUsage.WithLabelValues("metrics-node2", "bandwidth")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.NodeScore.WithLabelValues("metrics-node1", "load")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.NodeScore.WithLabelValues("metrics-node2", "load")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.NodeDraining.WithLabelValues("metrics-node1")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.NodeDraining.WithLabelValues("metrics-node2")))
+	require.InDelta(t, 60, testutil.ToFloat64(m.NodeStatsAge.WithLabelValues("metrics-node1")), 5)
+
+	// the metrics of the nodes gone from the stats are removed
+	setNodeMetrics(t, mock, []NodeUpdateEvent{
+		{NodeID: "metrics-node2", NodeMetrics: NodeMetrics{Timestamp: time.Now()}},
+	})
+	c.publishNodeMetrics(context.Background())
+	require.False(t, m.NodeStatsAge.DeleteLabelValues("metrics-node1"))
+	require.False(t, m.NodeUsage.DeleteLabelValues("metrics-node1", "cpu"))
+	require.True(t, m.NodeStatsAge.DeleteLabelValues("metrics-node2"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordNodeScores(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	c := NewBalancer("", time.Second, time.Second, NewDBNodeStats(db), 0, 0)
+	m := metrics.Metrics.CatabalancerMetrics
+	selected := testutil.ToFloat64(m.NodeSelected.WithLabelValues("scores-near"))
+
+	setNodeMetrics(t, mock, []NodeUpdateEvent{
+		{NodeID: "scores-near", NodeMetrics: NodeMetrics{GeoLatitude: 51.5, GeoLongitude: 0, Timestamp: time.Now()}},
+		{NodeID: "scores-far", NodeMetrics: NodeMetrics{GeoLatitude: -33.9, GeoLongitude: 151.2, CPUUsagePercentage: 90, Timestamp: time.Now()}},
+	})
+	node, _, err := c.GetBestNode(context.Background(), nil, "1234", "51.5", "0", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "scores-near", node)
+
+	require.Equal(t, selected+1, testutil.ToFloat64(m.NodeSelected.WithLabelValues("scores-near")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.NodeScore.WithLabelValues("scores-near", "geo")))
+	require.Equal(t, 4.0, testutil.ToFloat64(m.NodeScore.WithLabelValues("scores-near", "total")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.NodeScore.WithLabelValues("scores-far", "geo")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.NodeScore.WithLabelValues("scores-far", "load")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.NodeGeoDistance.WithLabelValues("scores-near")))
+	require.Greater(t, testutil.ToFloat64(m.NodeGeoDistance.WithLabelValues("scores-far")), 15000.0)
+}
@@ -0,0 +1,179 @@
+package catabalancer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/livepeer/catalyst-api/cluster"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+const nodeUpdateResource = "nodeUpdate"
+
+// NodeStatsStore is where the node metrics of all nodes are published to and read back from by the balancer
+type NodeStatsStore interface {
+	// PublishNodeUpdate shares the latest stats of this node with the other nodes
+	PublishNodeUpdate(event NodeUpdateEvent) error
+	// GetNodeUpdates returns the latest stats received from every node
+	GetNodeUpdates(ctx context.Context) ([]NodeUpdateEvent, error)
+}
+
+// DBNodeStats keeps the node stats in the node_stats table of a shared Postgres DB
+type DBNodeStats struct {
+	db *sql.DB
+}
+
+func NewDBNodeStats(db *sql.DB) *DBNodeStats {
+	return &DBNodeStats{db: db}
+}
+
+func (d *DBNodeStats) PublishNodeUpdate(event NodeUpdateEvent) error {
+	if d.db == nil {
+		return fmt.Errorf("node stats DB was nil")
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node update: %w", err)
+	}
+
+	insertStatement := `insert into "node_stats"(
+                            "node_id",
+                            "stats"
+                            ) values($1, $2)
+							ON CONFLICT (node_id)
+							DO UPDATE SET stats = EXCLUDED.stats;`
+	_, err = d.db.Exec(
+		insertStatement,
+		event.NodeID,
+		payload,
+	)
+	if err != nil {
+		return fmt.Errorf("error writing postgres node stats: %w", err)
+	}
+	return nil
+}
+
+func (d *DBNodeStats) GetNodeUpdates(ctx context.Context) ([]NodeUpdateEvent, error) {
+	if d.db == nil {
+		return nil, fmt.Errorf("node stats DB was nil")
+	}
+
+	queryContext, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	query := "SELECT stats FROM node_stats"
+	rows, err := d.db.QueryContext(queryContext, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node stats: %w", err)
+	}
+	defer rows.Close()
+
+	var events []NodeUpdateEvent
+	for rows.Next() {
+		var statsBytes []byte
+		if err := rows.Scan(&statsBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan node stats row: %w", err)
+		}
+
+		var event NodeUpdateEvent
+		err = json.Unmarshal(statsBytes, &event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal node update event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	// Check for errors after iterating through rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// SerfNodeStats disseminates the node stats as Serf user events and caches the ones received in memory, so that small
+// clusters don't need a stats DB. Every node publishes its own stats and receives everyone's, including its own, through
+// the /api/serf/receiveUserEvent callback.
+type SerfNodeStats struct {
+	cluster cluster.Cluster
+
+	mu    sync.RWMutex
+	nodes map[string]NodeUpdateEvent // Node name -> latest update
+}
+
+// NewSerfNodeStats creates the store. The cluster is only needed on the nodes publishing their stats and can be nil
+// for a catalyst-api instance that only receives them.
+func NewSerfNodeStats(c cluster.Cluster) *SerfNodeStats {
+	return &SerfNodeStats{
+		cluster: c,
+		nodes:   map[string]NodeUpdateEvent{},
+	}
+}
+
+func (s *SerfNodeStats) PublishNodeUpdate(event NodeUpdateEvent) error {
+	if s.cluster == nil {
+		return fmt.Errorf("cannot publish node stats without a cluster")
+	}
+	event.Resource = nodeUpdateResource
+	name := fmt.Sprintf("%s-%s", nodeUpdateResource, event.NodeID)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node update: %w", err)
+	}
+	if len(name)+len(payload) > cluster.MaxUserEventSize {
+		// Playback streams are only a hint for the node selection, the ingest streams are needed to find the source
+		log.LogNoRequestID("catabalancer node update too large for serf, dropping playback streams", "nodeID", event.NodeID, "size", len(payload))
+		event.SetStreams(nil, event.GetIngestStreams())
+		if payload, err = json.Marshal(event); err != nil {
+			return fmt.Errorf("failed to marshal node update: %w", err)
+		}
+	}
+	if len(name)+len(payload) > cluster.MaxUserEventSize && len(event.IngestUsers) > 0 {
+		// Without them the ingest quotas only count the ingests of each node
+		log.LogNoRequestID("catabalancer node update too large for serf, dropping ingest users", "nodeID", event.NodeID, "size", len(payload))
+		event.IngestUsers = nil
+		if payload, err = json.Marshal(event); err != nil {
+			return fmt.Errorf("failed to marshal node update: %w", err)
+		}
+	}
+
+	// Coalescing by node keeps only the latest update of each node in the Serf queues
+	return s.cluster.BroadcastEvent(serf.UserEvent{
+		Name:     name,
+		Payload:  payload,
+		Coalesce: true,
+	})
+}
+
+// ReceiveNodeUpdate caches a node update received from Serf
+func (s *SerfNodeStats) ReceiveNodeUpdate(payload []byte) error {
+	var event NodeUpdateEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal node update event: %w", err)
+	}
+	if event.NodeID == "" {
+		return fmt.Errorf("node update event without a node ID")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Serf doesn't guarantee ordering, so don't let a delayed update overwrite a newer one
+	if existing, ok := s.nodes[event.NodeID]; ok && existing.NodeMetrics.Timestamp.After(event.NodeMetrics.Timestamp) {
+		return nil
+	}
+	s.nodes[event.NodeID] = event
+	return nil
+}
+
+func (s *SerfNodeStats) GetNodeUpdates(ctx context.Context) ([]NodeUpdateEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]NodeUpdateEvent, 0, len(s.nodes))
+	for _, event := range s.nodes {
+		events = append(events, event)
+	}
+	return events, nil
+}
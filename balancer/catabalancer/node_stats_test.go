@@ -0,0 +1,86 @@
+package catabalancer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/serf/serf"
+	"github.com/livepeer/catalyst-api/cluster"
+	mockcluster "github.com/livepeer/catalyst-api/mocks/cluster"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerfNodeStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := mockcluster.NewMockCluster(ctrl)
+	var broadcast []serf.UserEvent
+	mc.EXPECT().BroadcastEvent(gomock.Any()).DoAndReturn(func(event serf.UserEvent) error {
+		broadcast = append(broadcast, event)
+		return nil
+	}).Times(2)
+
+	publisher := NewSerfNodeStats(mc)
+	busy := NodeUpdateEvent{NodeID: "busy", NodeMetrics: NodeMetrics{CPUUsagePercentage: 90, Timestamp: time.Now()}}
+	idle := NodeUpdateEvent{NodeID: "idle", NodeMetrics: NodeMetrics{CPUUsagePercentage: 1, Timestamp: time.Now()}}
+	idle.SetStreams(nil, []string{"video+ingest"})
+	require.NoError(t, publisher.PublishNodeUpdate(busy))
+	require.NoError(t, publisher.PublishNodeUpdate(idle))
+	require.Equal(t, "nodeUpdate-busy", broadcast[0].Name)
+	require.True(t, broadcast[0].Coalesce)
+
+	// the receiving catalyst-api only gets the events forwarded by Serf
+	receiver := NewSerfNodeStats(nil)
+	for _, event := range broadcast {
+		require.NoError(t, receiver.ReceiveNodeUpdate(event.Payload))
+	}
+
+	c := NewBalancer("me", time.Second, time.Second, receiver, 0, 0)
+	node, _, err := c.GetBestNode(context.Background(), nil, "1234", "", "", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "idle", node)
+	source, err := c.MistUtilLoadSource(context.Background(), "video+ingest", "", "")
+	require.NoError(t, err)
+	require.Equal(t, "dtsc://idle", source)
+}
+
+func TestSerfNodeStatsIgnoresOutOfOrderUpdates(t *testing.T) {
+	s := NewSerfNodeStats(nil)
+	now := time.Now()
+	require.NoError(t, s.ReceiveNodeUpdate([]byte(fmt.Sprintf(`{"resource":"nodeUpdate","n":"node","nm":{"c":10,"t":%q}}`, now.Format(time.RFC3339Nano)))))
+	require.NoError(t, s.ReceiveNodeUpdate([]byte(fmt.Sprintf(`{"resource":"nodeUpdate","n":"node","nm":{"c":80,"t":%q}}`, now.Add(-time.Second).Format(time.RFC3339Nano)))))
+	require.Error(t, s.ReceiveNodeUpdate([]byte(`{"resource":"nodeUpdate"}`)))
+
+	updates, err := s.GetNodeUpdates(context.Background())
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	require.Equal(t, 10.0, updates[0].NodeMetrics.CPUUsagePercentage)
+}
+
+func TestSerfNodeStatsDropsPlaybackStreamsWhenTooLarge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := mockcluster.NewMockCluster(ctrl)
+	var broadcast serf.UserEvent
+	mc.EXPECT().BroadcastEvent(gomock.Any()).DoAndReturn(func(event serf.UserEvent) error {
+		broadcast = event
+		return nil
+	})
+
+	var streams []string
+	for i := 0; i < 1000; i++ {
+		streams = append(streams, fmt.Sprintf("video+playback%d", i))
+	}
+	event := NodeUpdateEvent{NodeID: "node", NodeMetrics: NodeMetrics{Timestamp: time.Now()}}
+	event.SetStreams(streams, []string{"video+ingest"})
+	require.NoError(t, NewSerfNodeStats(mc).PublishNodeUpdate(event))
+	require.LessOrEqual(t, len(broadcast.Name)+len(broadcast.Payload), cluster.MaxUserEventSize)
+
+	receiver := NewSerfNodeStats(nil)
+	require.NoError(t, receiver.ReceiveNodeUpdate(broadcast.Payload))
+	updates, err := receiver.GetNodeUpdates(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, updates[0].GetStreams())
+	require.Equal(t, []string{"video+ingest"}, updates[0].GetIngestStreams())
+}
@@ -90,6 +90,15 @@ func GetSystemUsage() (SystemUsage, error) {
 	return systemUsage, nil
 }
 
+// GetDiskUsage returns the used percentage of the filesystem holding the path, e.g. the temp dir jobs write to
+func GetDiskUsage(path string) (float64, error) {
+	usage, err := disk.Usage(path)
+	if err != nil {
+		return 0, err
+	}
+	return usage.UsedPercent, nil
+}
+
 // Get bandwidth usage using the vnstat utility.
 // 'vnstat --json --iface en0 -tr 2.5' calculates traffic for given interface
 // over the specified duration in seconds
@@ -0,0 +1,365 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/balancer"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
+	"github.com/livepeer/catalyst-api/cluster"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// A peer cluster is only chosen over this one when it's closer to the viewer by more than this, so that viewers halfway
+// between regions aren't sent away from the cluster that knows where the streams are
+const localPreferenceKm = 500
+
+// The Serf tags of the addresses viewers can be redirected to on the nodes of a peer cluster
+var peerNodeProtocols = []string{"http", "https"}
+
+// NodeSummary is what a cluster shares of one of its nodes with the other clusters of the federation: enough to pick
+// a node, without the streams it serves
+type NodeSummary struct {
+	Name                     string  `json:"name"`
+	Latitude                 float64 `json:"lat"`
+	Longitude                float64 `json:"lon"`
+	CPUUsagePercentage       float64 `json:"cpu"`
+	RAMUsagePercentage       float64 `json:"ram"`
+	BandwidthUsagePercentage float64 `json:"bw"`
+	// The http and https addresses of the node, as the node names of a peer can't be resolved in this cluster's Serf
+	Addresses map[string]string `json:"addresses,omitempty"`
+}
+
+// ClusterSummary is the summary of the healthy nodes of a cluster: GET /api/federation/summary
+type ClusterSummary struct {
+	Cluster string `json:"cluster"`
+	// The centre of the nodes, to pick the cluster closest to a viewer
+	Latitude  float64       `json:"lat"`
+	Longitude float64       `json:"lon"`
+	Nodes     []NodeSummary `json:"nodes"`
+}
+
+// scoredNodes returns the nodes viewers can be redirected to
+func (s ClusterSummary) scoredNodes() []catabalancer.ScoredNode {
+	var nodes []catabalancer.ScoredNode
+	for _, n := range s.Nodes {
+		if len(n.Addresses) == 0 {
+			continue
+		}
+		nodes = append(nodes, catabalancer.ScoredNode{
+			Node: catabalancer.Node{Name: n.Name},
+			NodeMetrics: catabalancer.NodeMetrics{
+				CPUUsagePercentage:       n.CPUUsagePercentage,
+				RAMUsagePercentage:       n.RAMUsagePercentage,
+				BandwidthUsagePercentage: n.BandwidthUsagePercentage,
+				GeoLatitude:              n.Latitude,
+				GeoLongitude:             n.Longitude,
+			},
+		})
+	}
+	return nodes
+}
+
+// hasCapacity returns whether any node of the cluster viewers can be redirected to isn't overloaded
+func (s ClusterSummary) hasCapacity() bool {
+	return hasCapacity(s.scoredNodes())
+}
+
+func hasCapacity(nodes []catabalancer.ScoredNode) bool {
+	for _, n := range nodes {
+		if n.GetLoadScore() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeLister returns the nodes of this cluster that can serve viewers, i.e. catabalancer's view of the cluster
+type NodeLister interface {
+	HealthyNodes(ctx context.Context) ([]catabalancer.ScoredNode, error)
+}
+
+// MemberLister returns the Serf members of this cluster, to share the addresses of its nodes
+type MemberLister interface {
+	MembersFiltered(filter map[string]string, status, name string) ([]cluster.Member, error)
+}
+
+// LocalCluster is this cluster, as summarized to the other clusters of the federation
+type LocalCluster struct {
+	Name    string
+	Nodes   NodeLister
+	Members MemberLister
+}
+
+func (l *LocalCluster) Summary(ctx context.Context) (ClusterSummary, error) {
+	nodes, err := l.Nodes.HealthyNodes(ctx)
+	if err != nil {
+		return ClusterSummary{}, err
+	}
+	return l.summarize(nodes)
+}
+
+func (l *LocalCluster) summarize(nodes []catabalancer.ScoredNode) (ClusterSummary, error) {
+	addresses, err := l.nodeAddresses()
+	if err != nil {
+		return ClusterSummary{}, err
+	}
+	summary := ClusterSummary{Cluster: l.Name, Nodes: []NodeSummary{}}
+	for _, n := range nodes {
+		summary.Nodes = append(summary.Nodes, NodeSummary{
+			Name:                     n.Name,
+			Latitude:                 n.GeoLatitude,
+			Longitude:                n.GeoLongitude,
+			CPUUsagePercentage:       n.CPUUsagePercentage,
+			RAMUsagePercentage:       n.RAMUsagePercentage,
+			BandwidthUsagePercentage: n.BandwidthUsagePercentage,
+			Addresses:                addresses[n.Name],
+		})
+		summary.Latitude += n.GeoLatitude
+		summary.Longitude += n.GeoLongitude
+	}
+	if len(nodes) > 0 {
+		summary.Latitude /= float64(len(nodes))
+		summary.Longitude /= float64(len(nodes))
+	}
+	return summary, nil
+}
+
+// nodeAddresses returns the http and https addresses of the alive nodes by name, from their Serf tags
+func (l *LocalCluster) nodeAddresses() (map[string]map[string]string, error) {
+	if l.Members == nil {
+		return nil, nil
+	}
+	members, err := l.Members.MembersFiltered(map[string]string{}, "alive", "")
+	if err != nil {
+		return nil, fmt.Errorf("error listing members: %w", err)
+	}
+	addresses := map[string]map[string]string{}
+	for _, m := range members {
+		for _, protocol := range peerNodeProtocols {
+			if addr, ok := m.Tags[protocol]; ok {
+				if addresses[m.Name] == nil {
+					addresses[m.Name] = map[string]string{}
+				}
+				addresses[m.Name][protocol] = addr
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// FederatedBalancer routes playback across the clusters of a federation in two stages: the cluster closest to the
+// viewer with capacity left, then a node within that cluster. This cluster's nodes are picked by the local balancer,
+// which knows where the streams are, while the nodes of the peer clusters are picked from their summaries. Ingest, and
+// playback of the streams live in this cluster, always stay within this cluster, as the peers can't source them.
+type FederatedBalancer struct {
+	LocalBalancer balancer.Balancer
+	Local         *LocalCluster
+
+	peers []*peerState
+	now   func() time.Time
+}
+
+func NewBalancer(localBalancer balancer.Balancer, local *LocalCluster, peers []Peer) *FederatedBalancer {
+	f := &FederatedBalancer{LocalBalancer: localBalancer, Local: local, now: time.Now}
+	for _, p := range peers {
+		f.peers = append(f.peers, &peerState{Peer: p})
+	}
+	return f
+}
+
+// Run polls the summaries of the peer clusters until the context is cancelled
+func (f *FederatedBalancer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f.pollPeers(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *FederatedBalancer) pollPeers(ctx context.Context) {
+	now := f.now()
+	var wg sync.WaitGroup
+	for _, p := range f.peers {
+		if !p.due(now) {
+			continue
+		}
+		wg.Add(1)
+		go func(p *peerState) {
+			defer wg.Done()
+			p.poll(ctx, now)
+		}(p)
+	}
+	wg.Wait()
+	for _, p := range f.peers {
+		_, ok := p.available(now)
+		setPeerAvailable(p.Name, ok)
+	}
+}
+
+func (f *FederatedBalancer) Start(ctx context.Context) error {
+	return f.LocalBalancer.Start(ctx)
+}
+
+func (f *FederatedBalancer) UpdateMembers(ctx context.Context, members []cluster.Member) error {
+	return f.LocalBalancer.UpdateMembers(ctx, members)
+}
+
+func (f *FederatedBalancer) MistUtilLoadSource(ctx context.Context, streamID, lat, lon string) (string, error) {
+	return f.LocalBalancer.MistUtilLoadSource(ctx, streamID, lat, lon)
+}
+
+func (f *FederatedBalancer) GetBestNode(ctx context.Context, redirectPrefixes []string, playbackID, lat, lon, fallbackPrefix string, isStudioReq bool) (string, string, error) {
+	latf, lonf, ok := parseLocation(lat, lon)
+	if !ok || len(f.peers) == 0 || isStudioReq {
+		return f.LocalBalancer.GetBestNode(ctx, redirectPrefixes, playbackID, lat, lon, fallbackPrefix, isStudioReq)
+	}
+
+	peer, remote := f.chooseCluster(ctx, playbackID, latf, lonf)
+	if remote {
+		node, err := catabalancer.SelectNode(peer.scoredNodes(), playbackID, latf, lonf)
+		if err == nil {
+			metrics.Metrics.FederationRoutingCount.WithLabelValues(peer.Cluster).Inc()
+			prefix := "video"
+			if len(redirectPrefixes) > 0 {
+				prefix = redirectPrefixes[0]
+			}
+			return node.Name, fmt.Sprintf("%s+%s", prefix, playbackID), nil
+		}
+		log.LogNoRequestID("federation failed to select a node of peer cluster, routing locally", "cluster", peer.Cluster, "err", err)
+	}
+	metrics.Metrics.FederationRoutingCount.WithLabelValues(f.Local.Name).Inc()
+	return f.LocalBalancer.GetBestNode(ctx, redirectPrefixes, playbackID, lat, lon, fallbackPrefix, isStudioReq)
+}
+
+// PreviewCluster returns the cluster GetBestNode sends a viewer of the playback ID to, without recording the decision
+// in the metrics. For a peer cluster, the nodes one of which is picked at random and the full playback ID are
+// returned too, viewers kept in this cluster are left to the local balancer.
+func (f *FederatedBalancer) PreviewCluster(ctx context.Context, redirectPrefixes []string, playbackID, lat, lon string) (string, []string, string) {
+	latf, lonf, ok := parseLocation(lat, lon)
+	if !ok || len(f.peers) == 0 {
+		return f.Local.Name, nil, ""
+	}
+	peer, remote := f.chooseCluster(ctx, playbackID, latf, lonf)
+	if !remote {
+		return f.Local.Name, nil, ""
+	}
+	var nodes []string
+	for _, node := range catabalancer.TopNodes(peer.scoredNodes(), playbackID, latf, lonf) {
+		nodes = append(nodes, node.Name)
+	}
+	if len(nodes) == 0 {
+		return f.Local.Name, nil, ""
+	}
+	prefix := "video"
+	if len(redirectPrefixes) > 0 {
+		prefix = redirectPrefixes[0]
+	}
+	return peer.Cluster, nodes, fmt.Sprintf("%s+%s", prefix, playbackID)
+}
+
+// chooseCluster returns the peer cluster a viewer should be sent to, false to keep them in this cluster. This cluster
+// is kept while the stream is live in it, and otherwise unless a peer with capacity is clearly closer, or this cluster
+// has no capacity left.
+func (f *FederatedBalancer) chooseCluster(ctx context.Context, playbackID string, lat, lon float64) (ClusterSummary, bool) {
+	nodes, err := f.Local.Nodes.HealthyNodes(ctx)
+	if err != nil {
+		log.LogNoRequestID("federation failed to list local nodes, routing locally", "err", err)
+		return ClusterSummary{}, false
+	}
+	for _, n := range nodes {
+		if n.HasStream(playbackID) {
+			return ClusterSummary{}, false
+		}
+	}
+	local, err := f.Local.summarize(nodes)
+	if err != nil {
+		log.LogNoRequestID("federation failed to summarize local cluster, routing locally", "err", err)
+		return ClusterSummary{}, false
+	}
+	localDistance := catabalancer.GeoDistance(lat, lon, local.Latitude, local.Longitude)
+	if !hasCapacity(nodes) {
+		localDistance = -1
+	}
+
+	var best ClusterSummary
+	bestDistance := -1.0
+	now := f.now()
+	for _, p := range f.peers {
+		summary, ok := p.available(now)
+		if !ok || !summary.hasCapacity() {
+			continue
+		}
+		d := catabalancer.GeoDistance(lat, lon, summary.Latitude, summary.Longitude)
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = summary, d
+		}
+	}
+	if bestDistance < 0 {
+		return ClusterSummary{}, false
+	}
+	if localDistance >= 0 && localDistance-bestDistance <= localPreferenceKm {
+		return ClusterSummary{}, false
+	}
+	return best, true
+}
+
+// ResolvePeerNodeURL replaces the node of a URL returned by GetBestNode with its address, if it's a node of a peer
+// cluster
+func (f *FederatedBalancer) ResolvePeerNodeURL(nodeURL string) (string, bool) {
+	u, err := url.Parse(nodeURL)
+	if err != nil {
+		return "", false
+	}
+	now := f.now()
+	for _, p := range f.peers {
+		summary, ok := p.available(now)
+		if !ok {
+			continue
+		}
+		for _, n := range summary.Nodes {
+			if n.Name != u.Host {
+				continue
+			}
+			// a node only reachable over https is redirected to over https
+			address, ok := n.Addresses[u.Scheme]
+			for i := len(peerNodeProtocols) - 1; !ok && i >= 0; i-- {
+				address, ok = n.Addresses[peerNodeProtocols[i]]
+			}
+			addr, err := url.Parse(address)
+			if err != nil || addr.Host == "" {
+				return "", false
+			}
+			addr.Path = path.Join(addr.Path, u.Path)
+			addr.RawQuery = u.RawQuery
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+func parseLocation(lat, lon string) (float64, float64, bool) {
+	if lat == "" || lon == "" {
+		return 0, 0, false
+	}
+	latf, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lonf, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return latf, lonf, true
+}
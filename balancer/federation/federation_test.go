@@ -0,0 +1,240 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/balancer"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
+	"github.com/livepeer/catalyst-api/cluster"
+	"github.com/stretchr/testify/require"
+)
+
+type stubNodes []catabalancer.ScoredNode
+
+func (s stubNodes) HealthyNodes(ctx context.Context) ([]catabalancer.ScoredNode, error) {
+	return s, nil
+}
+
+// stubMembers are Serf members with an https address named after them
+type stubMembers []string
+
+func (s stubMembers) MembersFiltered(filter map[string]string, status, name string) ([]cluster.Member, error) {
+	var members []cluster.Member
+	for _, m := range s {
+		members = append(members, cluster.Member{Name: m, Status: "alive", Tags: map[string]string{"https": "https://" + m + ":8443", "dtsc": "dtsc://" + m}})
+	}
+	return members, nil
+}
+
+func node(name string, lat, lon, cpu float64) catabalancer.ScoredNode {
+	return catabalancer.ScoredNode{
+		Node:        catabalancer.Node{Name: name},
+		NodeMetrics: catabalancer.NodeMetrics{GeoLatitude: lat, GeoLongitude: lon, CPUUsagePercentage: cpu},
+	}
+}
+
+// newPeer serves the summary of a cluster of two nodes in Frankfurt, failing while failing is set
+func newPeer(t *testing.T, cpu float64, failing *atomic.Bool, polls *atomic.Int32) *httptest.Server {
+	local := &LocalCluster{
+		Name:    "eu",
+		Nodes:   stubNodes{node("fra-1.example.com", 50.11, 8.68, cpu), node("fra-2.example.com", 50.11, 8.68, cpu)},
+		Members: stubMembers{"fra-1.example.com", "fra-2.example.com"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		require.Equal(t, SummaryPath, r.URL.Path)
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		summary, err := local.Summary(r.Context())
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(summary))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newFederatedBalancer(t *testing.T, peerURL string) *FederatedBalancer {
+	peers, err := ParsePeers(map[string]string{"eu": strings.Replace(peerURL, "http://", "http://secret@", 1)})
+	require.NoError(t, err)
+	local := &LocalCluster{Name: "us", Nodes: stubNodes{node("nyc-1.example.com", 40.71, -74.01, 10)}}
+	return NewBalancer(balancer.NewBalancerStub(nil), local, peers)
+}
+
+func TestLocalClusterSummary(t *testing.T) {
+	local := &LocalCluster{Name: "eu", Nodes: stubNodes{node("lon-1", 51.5, 0, 10), node("fra-1", 50.5, 8, 90)}}
+	summary, err := local.Summary(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "eu", summary.Cluster)
+	require.InDelta(t, 51, summary.Latitude, 0.001)
+	require.InDelta(t, 4, summary.Longitude, 0.001)
+	require.Len(t, summary.Nodes, 2)
+	require.Equal(t, NodeSummary{Name: "fra-1", Latitude: 50.5, Longitude: 8, CPUUsagePercentage: 90}, summary.Nodes[1])
+
+	local.Members = stubMembers{"fra-1"}
+	summary, err = local.Summary(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, summary.Nodes[0].Addresses)
+	require.Equal(t, map[string]string{"https": "https://fra-1:8443"}, summary.Nodes[1].Addresses)
+}
+
+func TestRoutesToTheClosestCluster(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 10, &failing, &polls).URL)
+	f.pollPeers(context.Background())
+
+	// A viewer in London goes to the European cluster
+	node, fullPlaybackID, err := f.GetBestNode(context.Background(), []string{"video"}, "abc", "51.5", "-0.1", "", false)
+	require.NoError(t, err)
+	require.Contains(t, []string{"fra-1.example.com", "fra-2.example.com"}, node)
+	require.Equal(t, "video+abc", fullPlaybackID)
+
+	// A viewer in Boston stays in this cluster
+	node, _, err = f.GetBestNode(context.Background(), []string{"video"}, "abc", "42.36", "-71.06", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", node)
+
+	// A viewer without a location stays in this cluster
+	node, _, err = f.GetBestNode(context.Background(), []string{"video"}, "abc", "", "", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", node)
+}
+
+func TestPreviewCluster(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 10, &failing, &polls).URL)
+	f.pollPeers(context.Background())
+
+	cluster, nodes, fullPlaybackID := f.PreviewCluster(context.Background(), []string{"video"}, "abc", "51.5", "-0.1")
+	require.Equal(t, "eu", cluster)
+	require.ElementsMatch(t, []string{"fra-1.example.com", "fra-2.example.com"}, nodes)
+	require.Equal(t, "video+abc", fullPlaybackID)
+
+	cluster, nodes, fullPlaybackID = f.PreviewCluster(context.Background(), []string{"video"}, "abc", "42.36", "-71.06")
+	require.Equal(t, "us", cluster)
+	require.Empty(t, nodes)
+	require.Empty(t, fullPlaybackID)
+}
+
+func TestKeepsIngestAndLiveStreamsLocal(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 10, &failing, &polls).URL)
+	f.pollPeers(context.Background())
+
+	// Ingest from London stays in this cluster
+	chosen, _, err := f.GetBestNode(context.Background(), []string{"video"}, "abc", "51.5", "-0.1", "", true)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", chosen)
+
+	// So does playback of a stream live in this cluster, which the peers can't source
+	live := node("nyc-1.example.com", 40.71, -74.01, 10)
+	live.Streams = catabalancer.Streams{"abc": catabalancer.Stream{ID: "video+abc", PlaybackID: "abc"}}
+	f.Local.Nodes = stubNodes{live}
+	chosen, _, err = f.GetBestNode(context.Background(), []string{"video"}, "abc", "51.5", "-0.1", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", chosen)
+	chosen, _, err = f.GetBestNode(context.Background(), []string{"video"}, "other", "51.5", "-0.1", "", false)
+	require.NoError(t, err)
+	require.NotEqual(t, "localhost", chosen)
+}
+
+func TestResolvePeerNodeURL(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 10, &failing, &polls).URL)
+	f.pollPeers(context.Background())
+
+	resolved, ok := f.ResolvePeerNodeURL("https://fra-1.example.com/hls/video+abc/index.m3u8?a=b")
+	require.True(t, ok)
+	require.Equal(t, "https://fra-1.example.com:8443/hls/video+abc/index.m3u8?a=b", resolved)
+	// The nodes without an http address are redirected to over https
+	resolved, ok = f.ResolvePeerNodeURL("http://fra-2.example.com/hls/video+abc/index.m3u8")
+	require.True(t, ok)
+	require.Equal(t, "https://fra-2.example.com:8443/hls/video+abc/index.m3u8", resolved)
+
+	_, ok = f.ResolvePeerNodeURL("https://nyc-1.example.com/hls/video+abc/index.m3u8")
+	require.False(t, ok)
+}
+
+func TestSkipsOverloadedClusters(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 95, &failing, &polls).URL)
+	f.pollPeers(context.Background())
+
+	node, _, err := f.GetBestNode(context.Background(), []string{"video"}, "abc", "51.5", "-0.1", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", node)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 10, &failing, &polls).URL)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	route := func() string {
+		node, _, err := f.GetBestNode(context.Background(), []string{"video"}, "abc", "51.5", "-0.1", "", false)
+		require.NoError(t, err)
+		return node
+	}
+
+	f.pollPeers(context.Background())
+	require.NotEqual(t, "localhost", route())
+
+	// The last summary is still used while the breaker is closed
+	failing.Store(true)
+	for i := 0; i < breakerThreshold-1; i++ {
+		f.pollPeers(context.Background())
+	}
+	require.NotEqual(t, "localhost", route())
+
+	// Then the peer is no longer routed to nor polled until the cooldown is over
+	f.pollPeers(context.Background())
+	require.Equal(t, "localhost", route())
+	require.Equal(t, int32(breakerThreshold+1), polls.Load())
+	f.pollPeers(context.Background())
+	require.Equal(t, int32(breakerThreshold+1), polls.Load())
+
+	// A successful poll after the cooldown closes the breaker again
+	failing.Store(false)
+	now = now.Add(breakerCooldown)
+	f.pollPeers(context.Background())
+	require.Equal(t, int32(breakerThreshold+2), polls.Load())
+	require.NotEqual(t, "localhost", route())
+}
+
+func TestStaleSummariesAreNotRoutedTo(t *testing.T) {
+	var failing atomic.Bool
+	var polls atomic.Int32
+	f := newFederatedBalancer(t, newPeer(t, 10, &failing, &polls).URL)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	f.pollPeers(context.Background())
+
+	now = now.Add(summaryTimeout + time.Second)
+	node, _, err := f.GetBestNode(context.Background(), []string{"video"}, "abc", "51.5", "-0.1", "", false)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", node)
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers(map[string]string{"eu": "https://secret@eu.example.com:7979"})
+	require.NoError(t, err)
+	require.Equal(t, []Peer{{Name: "eu", SummaryURL: "https://eu.example.com:7979/api/federation/summary", Token: "secret"}}, peers)
+
+	_, err = ParsePeers(map[string]string{"eu": "eu.example.com"})
+	require.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+const (
+	// The path of the summary endpoint on the internal API of every cluster
+	SummaryPath = "/api/federation/summary"
+
+	// Consecutive failed polls after which a peer is no longer routed to
+	breakerThreshold = 3
+	// How long a peer isn't polled nor routed to once its breaker opened, before a single poll tries it again
+	breakerCooldown = time.Minute
+	// How old the summary of a peer can get before the peer is no longer routed to
+	summaryTimeout = 30 * time.Second
+)
+
+var summaryClient = &http.Client{Timeout: 5 * time.Second, Transport: clients.NewRequestHeadersTransport(nil)}
+
+// Peer is another cluster of the federation
+type Peer struct {
+	Name       string
+	SummaryURL string
+	Token      string
+}
+
+// ParsePeers returns the peers from a map of cluster names to the internal API URLs of the clusters, with the token to
+// call them with as the username, e.g. https://<token>@eu.example.com:7979
+func ParsePeers(peerURLs map[string]string) ([]Peer, error) {
+	var peers []Peer
+	for name, peerURL := range peerURLs {
+		u, err := url.Parse(peerURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL for federation peer %s: %w", name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL for federation peer %s: must be a http(s) URL with a host", name)
+		}
+		token := u.User.Username()
+		u.User = nil
+		peers = append(peers, Peer{Name: name, SummaryURL: u.JoinPath(SummaryPath).String(), Token: token})
+	}
+	return peers, nil
+}
+
+// peerState is the latest summary of a peer, along with its circuit breaker. The breaker keeps a failing region from
+// slowing down or misrouting the others: once open, the peer isn't routed to and is only polled again after a cooldown.
+type peerState struct {
+	Peer
+
+	mu        sync.Mutex
+	summary   *ClusterSummary
+	fetchedAt time.Time
+	failures  int
+	openUntil time.Time
+}
+
+// due returns whether the peer should be polled, i.e. its breaker is closed or its cooldown is over
+func (p *peerState) due(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !now.Before(p.openUntil)
+}
+
+func (p *peerState) poll(ctx context.Context, now time.Time) {
+	summary, err := fetchSummary(ctx, p.Peer)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failures++
+		if p.failures >= breakerThreshold {
+			if now.After(p.openUntil) {
+				log.LogNoRequestID("federation peer circuit breaker open", "cluster", p.Name, "failures", p.failures, "err", err)
+			}
+			p.openUntil = now.Add(breakerCooldown)
+		} else {
+			log.LogNoRequestID("federation failed to poll peer", "cluster", p.Name, "failures", p.failures, "err", err)
+		}
+		return
+	}
+	if !p.openUntil.IsZero() {
+		log.LogNoRequestID("federation peer circuit breaker closed", "cluster", p.Name)
+	}
+	p.failures = 0
+	p.openUntil = time.Time{}
+	p.summary = &summary
+	p.fetchedAt = now
+}
+
+// available returns the latest summary of the peer if it can be routed to
+func (p *peerState) available(now time.Time) (ClusterSummary, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Before(p.openUntil) || p.summary == nil || now.Sub(p.fetchedAt) > summaryTimeout {
+		return ClusterSummary{}, false
+	}
+	return *p.summary, true
+}
+
+func fetchSummary(ctx context.Context, peer Peer) (ClusterSummary, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer.SummaryURL, nil)
+	if err != nil {
+		return ClusterSummary{}, err
+	}
+	if peer.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.Token)
+	}
+	resp, err := summaryClient.Do(req)
+	if err != nil {
+		return ClusterSummary{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return ClusterSummary{}, fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	var summary ClusterSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return ClusterSummary{}, fmt.Errorf("error parsing summary: %w", err)
+	}
+	return summary, nil
+}
+
+func setPeerAvailable(name string, available bool) {
+	v := 0.0
+	if available {
+		v = 1
+	}
+	metrics.Metrics.FederationPeerAvailable.WithLabelValues(name).Set(v)
+}
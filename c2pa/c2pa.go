@@ -49,7 +49,7 @@ func (c C2PA) c2paManifest(title string) string {
 	return fmt.Sprintf(c2paManifestTemplate, c.alg, c.privateKeyPath, c.signCertPath, title)
 }
 
-func (c C2PA) SignFile(inFile, outFile, title, parent string) error {
+func (c C2PA) SignFile(ctx context.Context, inFile, outFile, title, parent string) error {
 	args := []string{
 		inFile,
 		"--config",
@@ -61,7 +61,7 @@ func (c C2PA) SignFile(inFile, outFile, title, parent string) error {
 	if parent != "" {
 		args = append(args, "--parent", parent)
 	}
-	_, err := runCmd(exec.CommandContext(context.TODO(), "c2patool", args...))
+	_, err := runCmd(exec.CommandContext(ctx, "c2patool", args...))
 	return err
 }
 
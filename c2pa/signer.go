@@ -0,0 +1,209 @@
+package c2pa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Kinds of outputs signed, as counted in a Summary
+const (
+	KindMP4       = "mp4"
+	KindFMP4      = "fmp4"
+	KindThumbnail = "thumbnail"
+)
+
+const claimGenerator = "LivepeerStudio"
+
+// DefaultSigningWorkers is the number of files signed at once by a Signer when it's not given one
+const DefaultSigningWorkers = 4
+
+// Summary is what was signed for a job, reported in its final callback
+type Summary struct {
+	ClaimGenerator string `json:"claim_generator"`
+	Alg            string `json:"alg"`
+	// Number of files signed, and failed to be, by kind of output
+	Signed map[string]int `json:"signed"`
+	Failed map[string]int `json:"failed,omitempty"`
+}
+
+// File is a file signed in place, titled in its manifest
+type File struct {
+	Path  string
+	Title string
+}
+
+// Signer signs the outputs of a job, a few files at a time, keeping count of them for the job's Summary. Its methods
+// do nothing on a nil Signer, i.e. a job not asking for C2PA signing. c2patool is killed once the ctx given to them is
+// done, e.g. at the job's deadline.
+type Signer struct {
+	c       C2PA
+	workers int
+
+	mu     sync.Mutex
+	signed map[string]int
+	failed map[string]int
+}
+
+// NewSigner returns a Signer of the outputs of a job, or nil when signing isn't configured
+func (c *C2PA) NewSigner(workers int) *Signer {
+	if c == nil {
+		return nil
+	}
+	if workers <= 0 {
+		workers = DefaultSigningWorkers
+	}
+	return &Signer{c: *c, workers: workers, signed: map[string]int{}, failed: map[string]int{}}
+}
+
+// SignFiles signs the files in place, with the source of the job as the parent ingredient if it's set. A file that
+// fails to be signed is left as it was, and its error is returned along with those of the others once all are done.
+func (s *Signer) SignFiles(ctx context.Context, kind string, files []File, parent string) error {
+	if s == nil {
+		return nil
+	}
+	return s.each(ctx, len(files), func(i int) error {
+		err := s.c.SignFile(ctx, files[i].Path, files[i].Path, files[i].Title, parent)
+		s.record(kind, err)
+		if err != nil {
+			return fmt.Errorf("failed to sign %s: %w", files[i].Path, err)
+		}
+		return nil
+	})
+}
+
+// Fragments are the init segment of a fragmented MP4 rendition and the files of its fragments, all in the same dir
+type Fragments struct {
+	InitSegment string
+	// Glob of the fragment files, relative to the dir of the init segment
+	Glob  string
+	Title string
+}
+
+// SignFragments signs fragmented MP4 renditions in place, the manifest going in their init segment and covering the
+// hashes of their fragments. The fragments are counted with their init segment in the Summary.
+func (s *Signer) SignFragments(ctx context.Context, renditions []Fragments, parent string) error {
+	if s == nil {
+		return nil
+	}
+	return s.each(ctx, len(renditions), func(i int) error {
+		err := s.c.SignFragments(ctx, renditions[i].InitSegment, renditions[i].Glob, renditions[i].Title, parent)
+		s.record(KindFMP4, err)
+		if err != nil {
+			return fmt.Errorf("failed to sign %s: %w", renditions[i].InitSegment, err)
+		}
+		return nil
+	})
+}
+
+// Summary returns the counts of the files signed so far
+func (s *Signer) Summary() *Summary {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := &Summary{ClaimGenerator: claimGenerator, Alg: s.c.alg, Signed: map[string]int{}}
+	for kind, n := range s.signed {
+		summary.Signed[kind] = n
+	}
+	if len(s.failed) > 0 {
+		summary.Failed = map[string]int{}
+		for kind, n := range s.failed {
+			summary.Failed[kind] = n
+		}
+	}
+	return summary
+}
+
+func (s *Signer) record(kind string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed[kind]++
+	} else {
+		s.signed[kind]++
+	}
+}
+
+// each runs sign for each of n items, at most workers at once. Unlike an errgroup it doesn't stop at the first error,
+// so that every file that can be signed is.
+func (s *Signer) each(ctx context.Context, n int, sign func(i int) error) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []error
+		slots = make(chan struct{}, s.workers)
+	)
+	for i := 0; i < n; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := sign(i); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// SignFragments signs a fragmented MP4 rendition in place: its init segment and the fragments matched by the glob,
+// which is relative to the dir of the init segment. c2patool is killed when ctx is done.
+func (c C2PA) SignFragments(ctx context.Context, initSegment, fragmentsGlob, title, parent string) error {
+	dir := filepath.Dir(initSegment)
+	signedDir, err := os.MkdirTemp(dir, "signed-")
+	if err != nil {
+		return fmt.Errorf("failed to create dir for signed fragments: %w", err)
+	}
+	defer os.RemoveAll(signedDir)
+
+	args := []string{
+		filepath.Base(initSegment),
+		"--config",
+		c.c2paManifest(title),
+		"--fragments_glob",
+		fragmentsGlob,
+		"--force",
+		"--output",
+		filepath.Base(signedDir),
+	}
+	if parent != "" {
+		args = append(args, "--parent", parent)
+	}
+	cmd := exec.CommandContext(ctx, "c2patool", args...)
+	cmd.Dir = dir
+	if _, err := runCmd(cmd); err != nil {
+		return err
+	}
+
+	// Only replace the unsigned files once all of them were signed
+	signed, err := os.ReadDir(signedDir)
+	if err != nil {
+		return fmt.Errorf("failed to list signed fragments: %w", err)
+	}
+	for _, entry := range signed {
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(signedDir, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to replace fragment with its signed copy: %w", err)
+		}
+	}
+	return nil
+}
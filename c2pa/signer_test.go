@@ -0,0 +1,74 @@
+package c2pa
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilSignerDoesNothing(t *testing.T) {
+	var c *C2PA
+	s := c.NewSigner(2)
+	require.Nil(t, s)
+	require.NoError(t, s.SignFiles(context.Background(), KindMP4, []File{{Path: "test/tiny.mp4"}}, ""))
+	require.NoError(t, s.SignFragments(context.Background(), []Fragments{{InitSegment: "init.m4s"}}, ""))
+	require.Nil(t, s.Summary())
+}
+
+func TestSignerSignsAllFilesWithLimitedWorkers(t *testing.T) {
+	c := NewC2PA("es256", "test/es256_private.key", "test/es256_certs.pem")
+	s := c.NewSigner(3)
+
+	var running, maxRunning, signed int32
+	started, release := make(chan struct{}, 10), make(chan struct{})
+	go func() {
+		// Hold the first files until as many are signed at once as there are workers
+		for i := 0; i < 3; i++ {
+			<-started
+		}
+		close(release)
+	}()
+	err := s.each(context.Background(), 10, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&signed, 1)
+		if i%4 == 0 {
+			return fmt.Errorf("file %d failed", i)
+		}
+		return nil
+	})
+	require.EqualValues(t, 10, signed)
+	require.EqualValues(t, 3, maxRunning)
+	require.ErrorContains(t, err, "file 0 failed")
+	require.ErrorContains(t, err, "file 4 failed")
+	require.ErrorContains(t, err, "file 8 failed")
+}
+
+func TestSignerSummary(t *testing.T) {
+	c := NewC2PA("es256", "test/es256_private.key", "test/es256_certs.pem")
+	s := c.NewSigner(0)
+	require.Equal(t, &Summary{ClaimGenerator: "LivepeerStudio", Alg: "es256", Signed: map[string]int{}}, s.Summary())
+
+	s.record(KindMP4, nil)
+	s.record(KindMP4, nil)
+	s.record(KindThumbnail, nil)
+	s.record(KindFMP4, fmt.Errorf("failed"))
+	summary := s.Summary()
+	require.Equal(t, map[string]int{KindMP4: 2, KindThumbnail: 1}, summary.Signed)
+	require.Equal(t, map[string]int{KindFMP4: 1}, summary.Failed)
+
+	// The summary is a copy, unchanged by files signed later
+	s.record(KindMP4, nil)
+	require.Equal(t, 2, summary.Signed[KindMP4])
+}
@@ -1,63 +1,448 @@
 package cache
 
 import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
 )
 
+// How long entries without a TTL are kept in the shared backend, so that they don't pile up there forever. Entries of
+// a group are never kept longer, see generationTTL.
+const backendDefaultTTL = 24 * time.Hour
+
+// How long the generation of a group is kept after its last invalidation. Longer than the entries of the group, so
+// that once it's gone there's no entry left from before the invalidation.
+const generationTTL = 2 * backendDefaultTTL
+
+// How often an invalidation that didn't reach the backend is tried again
+var generationRetryInterval = 5 * time.Second
+
+// The generation of a group that was never invalidated
+const initialGeneration = "0"
+
+// Options of a Cache. The zero value is an unbounded in-memory cache whose entries never expire.
+type Options struct {
+	// Labels the cache's metrics and prefixes its keys in the backend. No metrics are recorded without a name.
+	Name string
+	// How long entries are kept for, 0 to keep them until they're removed
+	TTL time.Duration
+	// Most entries kept in memory, the least recently stored ones are evicted first. 0 for no limit.
+	MaxSize int
+	// Optional backend shared by the nodes, e.g. Redis, that entries are written through to and read from on a local
+	// miss. Only for values that can be encoded to JSON. Failures of the backend are logged and the cache keeps
+	// working from memory.
+	Backend Backend
+	// Returns the group of a key, the prefix that RemovePrefix is called with. Entries are stored in the backend under
+	// the current generation of their group, so that RemovePrefix can invalidate them on every node at once by
+	// starting a new generation. Needed to RemovePrefix from the backend.
+	Group func(key string) string
+}
+
+type entry[T interface{}] struct {
+	key       string
+	value     T
+	expiresAt time.Time
+	elem      *list.Element
+}
+
+func (e *entry[T]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// backendEntry is how entries are encoded in the backend, with their expiry so that every node expires them at the
+// same time
+type backendEntry struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt time.Time       `json:"expires_at,omitempty"`
+}
+
 type Cache[T interface{}] struct {
-	cache map[string]T
-	mutex sync.RWMutex
+	opts  Options
+	cache map[string]*entry[T]
+	// entries from the least to the most recently stored, to evict the oldest ones first
+	order *list.List
+	// Groups whose invalidation didn't reach the backend yet. Their entries aren't read from the backend until it does,
+	// so that invalidated entries can't come back from there.
+	untrusted map[string]bool
+	mutex     sync.Mutex
 }
 
 func New[T interface{}]() *Cache[T] {
+	return NewWithOptions[T](Options{})
+}
+
+func NewWithOptions[T interface{}](opts Options) *Cache[T] {
 	return &Cache[T]{
-		cache: make(map[string]T),
+		opts:      opts,
+		cache:     make(map[string]*entry[T]),
+		order:     list.New(),
+		untrusted: make(map[string]bool),
 	}
 }
 
 func (c *Cache[T]) Remove(streamName string) {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	delete(c.cache, streamName)
+	if e, ok := c.cache[streamName]; ok {
+		c.remove(e)
+	}
+	c.observeSize()
+	c.mutex.Unlock()
+
+	if c.opts.Backend != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+		defer cancel()
+		if key, ok := c.backendKey(ctx, streamName); ok {
+			c.backendError(c.opts.Backend.Delete(ctx, key))
+		}
+	}
+}
+
+// RemovePrefix removes all the entries whose key starts with the prefix. With a backend, the prefix must be a group
+// (see Options.Group), whose entries are invalidated on every node by starting a new generation. When that fails,
+// the group isn't read from the backend on this node until the invalidation is retried successfully, and the error
+// is returned.
+func (c *Cache[T]) RemovePrefix(prefix string) error {
+	c.mutex.Lock()
+	for key, e := range c.cache {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+	c.observeSize()
+	c.mutex.Unlock()
+
+	if c.opts.Backend == nil {
+		return nil
+	}
+	if c.opts.Group == nil {
+		return errors.New("entries of a cache without groups can't be removed from the backend by prefix")
+	}
+	err := c.newGeneration(prefix)
+	if err == nil {
+		return nil
+	}
+	c.backendError(err)
+	c.mutex.Lock()
+	retrying := c.untrusted[prefix]
+	c.untrusted[prefix] = true
+	c.mutex.Unlock()
+	if !retrying {
+		go c.retryNewGeneration(prefix)
+	}
+	return err
+}
+
+func (c *Cache[T]) newGeneration(group string) error {
+	generation := make([]byte, 8)
+	if _, err := rand.Read(generation); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	return c.opts.Backend.Set(ctx, c.generationKey(group), []byte(hex.EncodeToString(generation)), generationTTL)
+}
+
+func (c *Cache[T]) retryNewGeneration(group string) {
+	for {
+		time.Sleep(generationRetryInterval)
+		if err := c.newGeneration(group); err != nil {
+			c.backendError(err)
+			continue
+		}
+		c.mutex.Lock()
+		delete(c.untrusted, group)
+		c.mutex.Unlock()
+		return
+	}
 }
 
+// Get returns the entry, or the zero value when it's missing
 func (c *Cache[T]) Get(streamName string) T {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	info, ok := c.cache[streamName]
+	value, _ := c.Lookup(streamName)
+	return value
+}
+
+// Lookup returns the entry and whether it was found, in memory or else in the backend
+func (c *Cache[T]) Lookup(key string) (T, bool) {
+	c.mutex.Lock()
+	e, ok := c.cache[key]
+	if ok && e.expired(time.Now()) {
+		c.remove(e)
+		c.observeEviction("expired")
+		c.observeSize()
+		ok = false
+	}
+	c.mutex.Unlock()
 	if ok {
-		return info
+		c.observeLookup(true)
+		return e.value, true
 	}
+
+	if c.opts.Backend != nil {
+		if value, expiresAt, found := c.backendGet(key); found {
+			c.observeLookup(true)
+			c.mutex.Lock()
+			c.store(key, value, expiresAt)
+			c.mutex.Unlock()
+			return value, true
+		}
+	}
+	c.observeLookup(false)
 	var zero T
-	return zero
+	return zero, false
 }
 
+// GetJobs returns the entries, in no particular order
 func (c *Cache[T]) GetJobs() []T {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	vals := make([]T, 0, len(c.cache))
-	for _, info := range c.cache {
-		vals = append(vals, info)
+	snapshot := c.Snapshot()
+	vals := make([]T, 0, len(snapshot))
+	for _, value := range snapshot {
+		vals = append(vals, value)
 	}
 	return vals
 }
 
 func (c *Cache[T]) GetKeys() []string {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	keys := make([]string, 0, len(c.cache))
-	for k := range c.cache {
+	snapshot := c.Snapshot()
+	keys := make([]string, 0, len(snapshot))
+	for k := range snapshot {
 		keys = append(keys, k)
 	}
 	return keys
 }
 
-func (c *Cache[T]) Store(streamName string, value T) {
+// Snapshot returns a copy of the entries held in memory, which can be iterated over without holding up the cache.
+// Entries only found in the backend aren't included.
+func (c *Cache[T]) Snapshot() map[string]T {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.cache[streamName] = value
+	now := time.Now()
+	snapshot := make(map[string]T, len(c.cache))
+	for k, e := range c.cache {
+		if !e.expired(now) {
+			snapshot[k] = e.value
+		}
+	}
+	return snapshot
+}
+
+// Len returns the number of entries held in memory, including the expired ones not dropped yet
+func (c *Cache[T]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.cache)
+}
+
+func (c *Cache[T]) Store(streamName string, value T) {
+	c.StoreTTL(streamName, value, c.opts.TTL)
+}
+
+// StoreTTL stores the entry for the given time instead of the cache's TTL. A TTL of 0 keeps it until it's removed.
+func (c *Cache[T]) StoreTTL(key string, value T, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	c.mutex.Lock()
+	c.store(key, value, expiresAt)
+	c.mutex.Unlock()
+
+	if c.opts.Backend != nil {
+		c.backendSet(key, value, expiresAt)
+	}
 }
 
-func (c *Cache[T]) UnittestIntrospection() *map[string]T {
-	return &c.cache
+// Take removes the entry and returns it, false if it was missing. With a backend, the entry has to still be there
+// too, since another node may have taken it already. It's only taken from memory when the backend fails. Two nodes
+// taking the same entry at once may both get it.
+func (c *Cache[T]) Take(key string) (T, bool) {
+	var value T
+	c.mutex.Lock()
+	e, ok := c.cache[key]
+	if ok {
+		c.remove(e)
+		c.observeSize()
+		value, ok = e.value, !e.expired(time.Now())
+	}
+	c.mutex.Unlock()
+	if c.opts.Backend == nil {
+		return value, ok
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	backendKey, trusted := c.backendKey(ctx, key)
+	if !trusted {
+		return value, ok
+	}
+	data, found, err := c.opts.Backend.Get(ctx, backendKey)
+	if err != nil {
+		c.backendError(err)
+		return value, ok
+	}
+	var zero T
+	if !found {
+		return zero, false
+	}
+	c.backendError(c.opts.Backend.Delete(ctx, backendKey))
+	var be backendEntry
+	if err := json.Unmarshal(data, &be); err != nil {
+		c.backendError(err)
+		return value, ok
+	}
+	if !be.ExpiresAt.IsZero() && time.Now().After(be.ExpiresAt) {
+		return zero, false
+	}
+	var taken T
+	if err := json.Unmarshal(be.Value, &taken); err != nil {
+		c.backendError(err)
+		return value, ok
+	}
+	return taken, true
+}
+
+func (c *Cache[T]) store(key string, value T, expiresAt time.Time) {
+	if e, ok := c.cache[key]; ok {
+		c.remove(e)
+	}
+	e := &entry[T]{key: key, value: value, expiresAt: expiresAt}
+	e.elem = c.order.PushBack(e)
+	c.cache[key] = e
+
+	// Drop the expired entries that were stored the longest ago, so that entries which are never looked up again
+	// don't pile up
+	now := time.Now()
+	for front := c.order.Front(); front != nil && front.Value.(*entry[T]).expired(now); front = c.order.Front() {
+		c.remove(front.Value.(*entry[T]))
+		c.observeEviction("expired")
+	}
+	for c.opts.MaxSize > 0 && len(c.cache) > c.opts.MaxSize {
+		c.remove(c.order.Front().Value.(*entry[T]))
+		c.observeEviction("size")
+	}
+	c.observeSize()
+}
+
+func (c *Cache[T]) remove(e *entry[T]) {
+	c.order.Remove(e.elem)
+	delete(c.cache, e.key)
+}
+
+// backendKey returns the key of the entry in the backend, under the current generation of its group. Returns false
+// when the entry mustn't be read from or written to the backend.
+func (c *Cache[T]) backendKey(ctx context.Context, key string) (string, bool) {
+	if c.opts.Group == nil {
+		return c.opts.Name + ":" + key, true
+	}
+	group := c.opts.Group(key)
+	c.mutex.Lock()
+	untrusted := c.untrusted[group]
+	c.mutex.Unlock()
+	if untrusted {
+		return "", false
+	}
+	generation, found, err := c.opts.Backend.Get(ctx, c.generationKey(group))
+	if err != nil {
+		c.backendError(err)
+		return "", false
+	}
+	if !found {
+		generation = []byte(initialGeneration)
+	}
+	return c.opts.Name + ":" + string(generation) + ":" + key, true
+}
+
+func (c *Cache[T]) generationKey(group string) string {
+	return c.opts.Name + ":generation:" + group
+}
+
+func (c *Cache[T]) backendGet(key string) (value T, expiresAt time.Time, found bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	backendKey, ok := c.backendKey(ctx, key)
+	if !ok {
+		return value, expiresAt, false
+	}
+	data, found, err := c.opts.Backend.Get(ctx, backendKey)
+	if err != nil || !found {
+		c.backendError(err)
+		return value, expiresAt, false
+	}
+	var be backendEntry
+	if err := json.Unmarshal(data, &be); err != nil {
+		c.backendError(err)
+		return value, expiresAt, false
+	}
+	if err := json.Unmarshal(be.Value, &value); err != nil {
+		c.backendError(err)
+		return value, expiresAt, false
+	}
+	if !be.ExpiresAt.IsZero() && time.Now().After(be.ExpiresAt) {
+		return value, expiresAt, false
+	}
+	return value, be.ExpiresAt, true
+}
+
+func (c *Cache[T]) backendSet(key string, value T, expiresAt time.Time) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.backendError(err)
+		return
+	}
+	data, err = json.Marshal(backendEntry{Value: data, ExpiresAt: expiresAt})
+	if err != nil {
+		c.backendError(err)
+		return
+	}
+	ttl := backendDefaultTTL
+	if !expiresAt.IsZero() && (c.opts.Group == nil || time.Until(expiresAt) < ttl) {
+		ttl = time.Until(expiresAt)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	if backendKey, ok := c.backendKey(ctx, key); ok {
+		c.backendError(c.opts.Backend.Set(ctx, backendKey, data, ttl))
+	}
+}
+
+func (c *Cache[T]) backendError(err error) {
+	if err == nil {
+		return
+	}
+	log.LogNoRequestID("cache backend request failed", "cache", c.opts.Name, "err", err)
+	if c.opts.Name != "" {
+		metrics.Metrics.CacheMetrics.BackendErrors.WithLabelValues(c.opts.Name).Inc()
+	}
+}
+
+func (c *Cache[T]) observeLookup(hit bool) {
+	if c.opts.Name == "" {
+		return
+	}
+	if hit {
+		metrics.Metrics.CacheMetrics.Hits.WithLabelValues(c.opts.Name).Inc()
+	} else {
+		metrics.Metrics.CacheMetrics.Misses.WithLabelValues(c.opts.Name).Inc()
+	}
+}
+
+func (c *Cache[T]) observeEviction(reason string) {
+	if c.opts.Name != "" {
+		metrics.Metrics.CacheMetrics.Evictions.WithLabelValues(c.opts.Name, reason).Inc()
+	}
+}
+
+func (c *Cache[T]) observeSize() {
+	if c.opts.Name != "" {
+		metrics.Metrics.CacheMetrics.Size.WithLabelValues(c.opts.Name).Set(float64(len(c.cache)))
+	}
 }
@@ -1,7 +1,12 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -34,3 +39,169 @@ func TestStoreAndRemoveSegmenting(t *testing.T) {
 	c.Remove("some-stream-name")
 	require.Equal(t, "", c.Get("some-stream-name").CallbackURL)
 }
+
+func TestEntriesExpire(t *testing.T) {
+	c := NewWithOptions[testStreamInfo](Options{TTL: 50 * time.Millisecond})
+	c.Store("some-stream-name", testStreamInfo{CallbackURL: "http://some-callback-url.com"})
+	c.StoreTTL("other-stream-name", testStreamInfo{CallbackURL: "http://other-callback-url.com"}, 0)
+
+	_, found := c.Lookup("some-stream-name")
+	require.True(t, found)
+	time.Sleep(100 * time.Millisecond)
+	_, found = c.Lookup("some-stream-name")
+	require.False(t, found)
+	require.Equal(t, map[string]testStreamInfo{"other-stream-name": {CallbackURL: "http://other-callback-url.com"}}, c.Snapshot())
+}
+
+func TestOldestEntriesAreEvicted(t *testing.T) {
+	c := NewWithOptions[int](Options{MaxSize: 2})
+	c.Store("a", 1)
+	c.Store("b", 2)
+	c.Store("a", 3)
+	c.Store("c", 4)
+
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, map[string]int{"a": 3, "c": 4}, c.Snapshot())
+}
+
+func TestRemovePrefix(t *testing.T) {
+	c := New[int]()
+	c.Store("a/1", 1)
+	c.Store("a/2", 2)
+	c.Store("ab/1", 3)
+
+	c.RemovePrefix("a/")
+	require.ElementsMatch(t, []string{"ab/1"}, c.GetKeys())
+	require.ElementsMatch(t, []int{3}, c.GetJobs())
+}
+
+type memoryBackend struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	// Fails every request when set
+	err error
+}
+
+func (m *memoryBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, false, m.err
+	}
+	value, ok := m.data[key]
+	return value, ok, nil
+}
+
+func (m *memoryBackend) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.data[key] = value
+	return nil
+}
+
+func (m *memoryBackend) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memoryBackend) setErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func TestEntriesAreSharedThroughTheBackend(t *testing.T) {
+	backend := &memoryBackend{data: map[string][]byte{}}
+	node1 := NewWithOptions[*testStreamInfo](Options{Name: "test", Backend: backend})
+	node2 := NewWithOptions[*testStreamInfo](Options{Name: "test", Backend: backend})
+
+	node1.Store("some-stream-name", &testStreamInfo{CallbackURL: "http://some-callback-url.com"})
+	require.Contains(t, backend.data, "test:some-stream-name")
+	require.Equal(t, "http://some-callback-url.com", node2.Get("some-stream-name").CallbackURL)
+	// Read through into memory
+	require.Equal(t, 1, node2.Len())
+
+	node1.Remove("some-stream-name")
+	require.Empty(t, backend.data)
+	require.Nil(t, node1.Get("some-stream-name"))
+	require.Error(t, node1.RemovePrefix("some-"), "a cache without groups can't be invalidated by prefix")
+}
+
+func TestTakeOnlyOnce(t *testing.T) {
+	local := New[int]()
+	local.Store("key", 1)
+	value, ok := local.Take("key")
+	require.True(t, ok)
+	require.Equal(t, 1, value)
+	_, ok = local.Take("key")
+	require.False(t, ok)
+
+	backend := &memoryBackend{data: map[string][]byte{}}
+	node1 := NewWithOptions[int](Options{Name: "test", Backend: backend})
+	node2 := NewWithOptions[int](Options{Name: "test", Backend: backend})
+	node1.Store("key", 2)
+	value, ok = node2.Take("key")
+	require.True(t, ok)
+	require.Equal(t, 2, value)
+	require.Empty(t, backend.data)
+	// Still in the memory of the first node, but taken by the second one
+	_, ok = node1.Take("key")
+	require.False(t, ok)
+
+	// Taken from memory while the backend fails
+	node1.Store("key", 3)
+	backend.setErr(errors.New("backend down"))
+	value, ok = node1.Take("key")
+	require.True(t, ok)
+	require.Equal(t, 3, value)
+}
+
+func playbackIDGroup(key string) string {
+	playbackID, _, _ := strings.Cut(key, "/")
+	return playbackID + "/"
+}
+
+func TestRemovePrefixInvalidatesTheGroupOnEveryNode(t *testing.T) {
+	backend := &memoryBackend{data: map[string][]byte{}}
+	node1 := NewWithOptions[bool](Options{Name: "test", Backend: backend, Group: playbackIDGroup})
+	node2 := NewWithOptions[bool](Options{Name: "test", Backend: backend, Group: playbackIDGroup})
+
+	node1.Store("abc/1", true)
+	node1.Store("def/1", true)
+	require.Contains(t, backend.data, "test:0:abc/1")
+
+	require.NoError(t, node2.RemovePrefix("abc/"))
+	_, found := node2.Lookup("abc/1")
+	require.False(t, found)
+	require.True(t, node2.Get("def/1"))
+
+	// Entries stored in the new generation are shared again
+	node2.Store("abc/2", true)
+	require.True(t, NewWithOptions[bool](Options{Name: "test", Backend: backend, Group: playbackIDGroup}).Get("abc/2"))
+}
+
+func TestRemovePrefixFailsClosed(t *testing.T) {
+	defer func(interval time.Duration) { generationRetryInterval = interval }(generationRetryInterval)
+	generationRetryInterval = 10 * time.Millisecond
+	backend := &memoryBackend{data: map[string][]byte{}}
+	node1 := NewWithOptions[bool](Options{Name: "test", Backend: backend, Group: playbackIDGroup})
+	node1.Store("abc/1", true)
+
+	backend.setErr(errors.New("unreachable"))
+	require.Error(t, node1.RemovePrefix("abc/"))
+	backend.setErr(nil)
+	// The entry is still in the backend, but it isn't trusted until the invalidation goes through
+	require.False(t, node1.Get("abc/1"))
+
+	require.Eventually(t, func() bool {
+		node1.mutex.Lock()
+		defer node1.mutex.Unlock()
+		return len(node1.untrusted) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.False(t, node1.Get("abc/1"))
+}
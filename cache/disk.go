@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Artifacts is the node-local cache of the job artifacts downloaded from object storage, e.g. the manifests and
+// segments of a recording clipped several times in a row. nil when the cache is disabled.
+var Artifacts *DiskCache
+
+const diskCacheTempPrefix = "tmp-"
+
+// DiskCache is a content-addressed cache of files in a local directory. Entries are keyed by what identifies a
+// version of their content, e.g. the source's URL and checksum, and the least recently used ones are evicted to keep
+// the directory within its size budget.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type diskCacheEntry struct {
+	name string
+	size int64
+}
+
+// NewDiskCache creates the cache directory if needed and picks up the entries left by a previous run, most recently
+// used first.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid disk cache size: %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create disk cache dir: %w", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk cache dir: %w", err)
+	}
+
+	type existing struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var found []existing
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), diskCacheTempPrefix) {
+			// Left over from a write that didn't complete
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, existing{name: f.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].modTime.After(found[j].modTime)
+	})
+
+	c := &DiskCache{dir: dir, maxBytes: maxBytes, lru: list.New(), entries: map[string]*list.Element{}}
+	for _, f := range found {
+		c.entries[f.name] = c.lru.PushBack(&diskCacheEntry{name: f.name, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return c, nil
+}
+
+func (c *DiskCache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Open returns the cached file of the key, if there is one
+func (c *DiskCache) Open(key string) (*os.File, bool) {
+	if c == nil {
+		return nil, false
+	}
+	name := c.fileName(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	// The modification time keeps the order of use across restarts
+	now := time.Now()
+	_ = os.Chtimes(f.Name(), now, now)
+	return f, true
+}
+
+// Put stores the content read from r under the key, evicting the least recently used entries to stay within the
+// size budget. Content larger than the whole budget isn't stored.
+func (c *DiskCache) Put(key string, r io.Reader) error {
+	if c == nil {
+		return nil
+	}
+	tmp, err := os.CreateTemp(c.dir, diskCacheTempPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create disk cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write disk cache file: %w", err)
+	}
+	if size > c.maxBytes {
+		return nil
+	}
+
+	name := c.fileName(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		return fmt.Errorf("failed to write disk cache file: %w", err)
+	}
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*diskCacheEntry).size
+		c.lru.Remove(elem)
+	}
+	c.entries[name] = c.lru.PushFront(&diskCacheEntry{name: name, size: size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// GetBytes returns the cached content of the key, for small entries such as manifests or probe results
+func (c *DiskCache) GetBytes(key string) ([]byte, bool) {
+	f, ok := c.Open(key)
+	if !ok {
+		return nil, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *DiskCache) PutBytes(key string, data []byte) error {
+	return c.Put(key, bytes.NewReader(data))
+}
+
+// Size returns the total size in bytes of the cached entries
+func (c *DiskCache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// evict removes the least recently used entries until the cache is within its budget. Files already opened stay
+// readable until they're closed.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+func (c *DiskCache) remove(elem *list.Element) {
+	entry := elem.Value.(*diskCacheEntry)
+	_ = os.Remove(filepath.Join(c.dir, entry.name))
+	c.lru.Remove(elem)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+}
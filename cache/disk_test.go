@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readCached(t *testing.T, c *DiskCache, key string) (string, bool) {
+	f, ok := c.Open(key)
+	if !ok {
+		return "", false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(data), true
+}
+
+func TestDiskCacheStoresAndEvicts(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	_, ok := c.Open("segment-1")
+	require.False(t, ok)
+
+	require.NoError(t, c.Put("segment-1", strings.NewReader("aaaa")))
+	require.NoError(t, c.Put("segment-2", strings.NewReader("bbbb")))
+	data, ok := readCached(t, c, "segment-1")
+	require.True(t, ok)
+	require.Equal(t, "aaaa", data)
+
+	// segment-2 is the least recently used now
+	require.NoError(t, c.Put("segment-3", strings.NewReader("cccc")))
+	_, ok = c.Open("segment-2")
+	require.False(t, ok)
+	_, ok = c.Open("segment-1")
+	require.True(t, ok)
+	require.Equal(t, int64(8), c.Size())
+
+	// Larger than the whole budget
+	require.NoError(t, c.Put("segment-4", strings.NewReader("ddddddddddd")))
+	_, ok = c.Open("segment-4")
+	require.False(t, ok)
+	require.Equal(t, int64(8), c.Size())
+
+	// Overwriting an entry replaces its size
+	require.NoError(t, c.PutBytes("segment-1", []byte("a")))
+	data, ok = readCached(t, c, "segment-1")
+	require.True(t, ok)
+	require.Equal(t, "a", data)
+	require.Equal(t, int64(5), c.Size())
+}
+
+func TestDiskCacheReloadsEntries(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 10)
+	require.NoError(t, err)
+	require.NoError(t, c.PutBytes("manifest", []byte("#EXTM3U")))
+	require.NoError(t, os.WriteFile(dir+"/"+diskCacheTempPrefix+"123", []byte("partial"), 0644))
+
+	c, err = NewDiskCache(dir, 10)
+	require.NoError(t, err)
+	data, ok := c.GetBytes("manifest")
+	require.True(t, ok)
+	require.Equal(t, "#EXTM3U", string(data))
+	require.Equal(t, int64(7), c.Size())
+	_, err = os.Stat(dir + "/" + diskCacheTempPrefix + "123")
+	require.True(t, os.IsNotExist(err))
+
+	// A smaller budget evicts down to it
+	c, err = NewDiskCache(dir, 5)
+	require.NoError(t, err)
+	_, ok = c.GetBytes("manifest")
+	require.False(t, ok)
+}
+
+func TestDiskCacheDisabled(t *testing.T) {
+	var c *DiskCache
+	require.NoError(t, c.PutBytes("manifest", []byte("#EXTM3U")))
+	_, ok := c.GetBytes("manifest")
+	require.False(t, ok)
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Shared is the backend shared by the nodes, for the caches whose entries are worth sharing. nil when no Redis is
+// configured with -redis-url.
+var Shared Backend
+
+// Timeout of the backend requests made by the caches, which are on the request path of e.g. playback
+const backendTimeout = 500 * time.Millisecond
+
+// Backend is a key-value store the caches write their entries through to
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Idle connections kept open to Redis
+const redisIdleConns = 8
+
+// Redis is a Backend storing the entries in Redis
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis returns a Redis backend for a URL like redis://[[user]:password@]host[:port][/db]
+func NewRedis(redisURL string) (*Redis, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL, expected redis://[[user]:password@]host[:port][/db]: %w", err)
+	}
+	opts.MaxIdleConns = redisIdleConns
+	opts.ReadTimeout = backendTimeout
+	opts.WriteTimeout = backendTimeout
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, max(ttl, time.Millisecond)).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, key).Err()
+}
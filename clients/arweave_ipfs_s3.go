@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -79,10 +80,16 @@ func (d *DStorageDownload) DownloadDStorageFromGatewayList(u, requestID string)
 	return nil, fmt.Errorf("failed to fetch %s from any of the gateways: %w", u, lastErr)
 }
 
+var dStorageClient = withRequestHeaders(&http.Client{})
+
 func downloadDStorageResourceFromSingleGateway(gateway *url.URL, resourceId, requestID string) (io.ReadCloser, error) {
 	fullURL := gateway.JoinPath(resourceId).String()
 	log.Log(requestID, "downloading from gateway", "resourceID", resourceId, "url", fullURL)
-	resp, err := http.DefaultClient.Get(fullURL)
+	req, err := http.NewRequestWithContext(log.WithRequestID(context.Background(), requestID), http.MethodGet, fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dStorageClient.Do(req)
 
 	if err != nil {
 		log.LogError(requestID, "failed to fetch content from gateway", err, "url", fullURL)
@@ -96,7 +103,7 @@ func downloadDStorageResourceFromSingleGateway(gateway *url.URL, resourceId, req
 	} else if resp.StatusCode >= 300 {
 		resp.Body.Close()
 		log.Log(requestID, "unexpected response from gateway", "status_code", resp.StatusCode, "url", fullURL)
-		return nil, fmt.Errorf("unexpected response from gateway: %d", resp.StatusCode)
+		return nil, HTTPStatusError{StatusCode: resp.StatusCode, msg: fmt.Sprintf("unexpected response from gateway: %d", resp.StatusCode)}
 	}
 
 	return resp.Body, nil
@@ -0,0 +1,637 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+const (
+	SCHEME_AZURE_BLOB = "azblob"
+
+	// Version of the Blob service REST API the requests and the SAS tokens are made for
+	azureBlobAPIVersion = "2020-12-06"
+	// Uploads up to this size are a single Put Blob, the bigger ones are staged in blocks of this size
+	azureBlobBlockSize = 8 * 1024 * 1024
+)
+
+// azureBlobEndpoint returns the Blob service endpoint of a storage account, replaced in tests
+var azureBlobEndpoint = func(account string) string {
+	return "https://" + account + ".blob.core.windows.net"
+}
+
+var azureBlobClient = withRequestHeaders(&http.Client{})
+
+// azureBlobLocation is an Azure Blob Storage URL:
+//
+//	azblob://<account>:<account key>@<container>/<blob path>
+//	azblob://<account>@<container>/<blob path>?<SAS token>
+//
+// The account key is base64 and must be URL escaped. With a SAS token rather than the account key the token is passed
+// through as is, so it must grant the permissions of what the URL is used for, e.g. read for the inputs and
+// create/write for the outputs.
+type azureBlobLocation struct {
+	account   string
+	key       []byte
+	sas       url.Values
+	container string
+	blob      string
+}
+
+// IsAzureBlobURL returns whether the URL is an azblob:// Azure Blob Storage URL
+func IsAzureBlobURL(osURL string) bool {
+	return strings.HasPrefix(strings.ToLower(osURL), SCHEME_AZURE_BLOB+"://")
+}
+
+func parseAzureBlobURL(osURL string) (*azureBlobLocation, error) {
+	u, err := url.Parse(osURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != SCHEME_AZURE_BLOB {
+		return nil, fmt.Errorf("not an %s URL", SCHEME_AZURE_BLOB)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing the storage account")
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing the container")
+	}
+	loc := &azureBlobLocation{
+		account:   u.User.Username(),
+		container: u.Host,
+		blob:      strings.TrimPrefix(u.Path, "/"),
+	}
+	if key, ok := u.User.Password(); ok {
+		loc.key, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account key: %w", err)
+		}
+	} else if q := u.Query(); q.Get("sig") != "" {
+		loc.sas = q
+	} else {
+		return nil, fmt.Errorf("missing the account key or SAS token")
+	}
+	return loc, nil
+}
+
+// join returns the location of a blob under this one
+func (l azureBlobLocation) join(name string) azureBlobLocation {
+	if name != "" {
+		l.blob = path.Join(l.blob, name)
+	}
+	return l
+}
+
+// blobURL returns the https URL of the blob, with the SAS token if there's one
+func (l azureBlobLocation) blobURL() *url.URL {
+	u, _ := url.Parse(azureBlobEndpoint(l.account))
+	u = u.JoinPath(l.container, l.blob)
+	if l.sas != nil {
+		u.RawQuery = l.sas.Encode()
+	}
+	return u
+}
+
+func (l azureBlobLocation) newRequest(ctx context.Context, method string, query url.Values, body []byte) (*http.Request, error) {
+	u := l.blobURL()
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureBlobAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	return req, nil
+}
+
+// sign adds the Shared Key authorization of the request, once all of its headers are set. Requests authorized by a SAS
+// token in their URL aren't signed.
+func (l azureBlobLocation) sign(req *http.Request) {
+	if l.key == nil {
+		return
+	}
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	h := req.Header
+	stringToSign := strings.Join([]string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}, "\n") + "\n" + azureCanonicalizedHeaders(h) + l.canonicalizedResource(req.URL)
+
+	req.Header.Set("Authorization", "SharedKey "+l.account+":"+l.hmac(stringToSign))
+}
+
+func (l azureBlobLocation) hmac(stringToSign string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func azureCanonicalizedHeaders(h http.Header) string {
+	var names []string
+	for name := range h {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(h.Get(name)) + "\n")
+	}
+	return b.String()
+}
+
+func (l azureBlobLocation) canonicalizedResource(u *url.URL) string {
+	resource := "/" + l.account + u.EscapedPath()
+	q := u.Query()
+	var names []string
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := q[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return resource
+}
+
+// do sends the request made by newReq, retrying it on network errors and on the status codes that ask us to back off.
+// The response is returned for the 2xx status codes only, see azureBlobError for the others.
+func (l azureBlobLocation) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	var resp *http.Response
+	err := backoff.Retry(func() error {
+		req, err := newReq()
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		l.sign(req)
+		resp, err = azureBlobClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = azureBlobError(resp)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(UploadRetryBackoff(), ctx))
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// azureBlobError returns the error of a failed request, closing its response
+func azureBlobError(resp *http.Response) error {
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	msg := fmt.Sprintf("azure blob request failed: %s", resp.Status)
+	if code := resp.Header.Get("x-ms-error-code"); code != "" {
+		msg += " (" + code + ")"
+	}
+	statusErr := HTTPStatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")), msg: msg}
+	if resp.StatusCode == http.StatusNotFound {
+		return catErrs.NewObjectNotFoundError(msg, statusErr)
+	}
+	return storageFailure(statusErr)
+}
+
+// getAzureBlob reads the blob, or the byteRange of it if not empty
+func getAzureBlob(ctx context.Context, osURL, byteRange string) (*drivers.FileInfoReader, error) {
+	loc, err := parseAzureBlobURL(osURL)
+	if err != nil {
+		return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, fmt.Errorf("failed to parse OS URL %q: %w", log.RedactURL(osURL), err)))
+	}
+	info, err := loc.get(ctx, byteRange)
+	if err != nil {
+		if catErrs.IsObjectNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read from OS URL %q: %w", log.RedactURL(osURL), err)
+	}
+	return info, nil
+}
+
+func (l azureBlobLocation) get(ctx context.Context, byteRange string) (*drivers.FileInfoReader, error) {
+	resp, err := l.do(ctx, func() (*http.Request, error) {
+		req, err := l.newRequest(ctx, http.MethodGet, nil, nil)
+		if err == nil && byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &drivers.FileInfoReader{
+		FileInfo: drivers.FileInfo{
+			Name: l.blob,
+			ETag: resp.Header.Get("ETag"),
+		},
+		Metadata:     map[string]string{},
+		Body:         resp.Body,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ContentRange: resp.Header.Get("Content-Range"),
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = lastModified
+	}
+	if resp.ContentLength >= 0 {
+		size := resp.ContentLength
+		info.Size = &size
+	}
+	for name := range resp.Header {
+		if key, ok := strings.CutPrefix(strings.ToLower(name), "x-ms-meta-"); ok {
+			info.Metadata[key] = resp.Header.Get(name)
+		}
+	}
+	return info, nil
+}
+
+// uploadAzureBlob uploads the data as a block blob. Small files are a single Put Blob, the others are staged block by
+// block and then committed with Put Block List, each request being retried on its own.
+func uploadAzureBlob(ctx context.Context, osURL, filename string, data io.Reader, fields *drivers.FileProperties) error {
+	base, err := parseAzureBlobURL(osURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse OS URL %q: %s", log.RedactURL(osURL), err)
+	}
+	return base.join(filename).upload(ctx, data, fields)
+}
+
+func (l azureBlobLocation) upload(ctx context.Context, data io.Reader, fields *drivers.FileProperties) error {
+	block := make([]byte, azureBlobBlockSize)
+	n, err := io.ReadFull(data, block)
+	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read the data: %w", err)
+	}
+	contentType := azureBlobContentType(l.blob, block[:n], fields)
+	if n < azureBlobBlockSize {
+		return l.put(ctx, url.Values{}, block[:n], func(h http.Header) {
+			h.Set("x-ms-blob-type", "BlockBlob")
+			setAzureBlobProperties(h, contentType, fields)
+		})
+	}
+
+	var blockIDs []string
+	for i := 0; n > 0; i++ {
+		// Block IDs must all be of the same length
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", i)))
+		query := url.Values{"comp": {"block"}, "blockid": {blockID}}
+		if err := l.put(ctx, query, block[:n], nil); err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, blockID)
+
+		n, err = io.ReadFull(data, block)
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("failed to read the data: %w", err)
+		}
+	}
+
+	blockList, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+	return l.put(ctx, url.Values{"comp": {"blocklist"}}, append([]byte(xml.Header), blockList...), func(h http.Header) {
+		h.Set("Content-Type", "application/xml")
+		setAzureBlobProperties(h, contentType, fields)
+	})
+}
+
+func (l azureBlobLocation) put(ctx context.Context, query url.Values, body []byte, headers func(http.Header)) error {
+	resp, err := l.do(ctx, func() (*http.Request, error) {
+		req, err := l.newRequest(ctx, http.MethodPut, query, body)
+		if err == nil && headers != nil {
+			headers(req.Header)
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// azureBlobContentType returns the content type of the blob, from its file properties, its extension or its first bytes
+func azureBlobContentType(name string, firstBytes []byte, fields *drivers.FileProperties) string {
+	if fields != nil && fields.ContentType != "" {
+		return fields.ContentType
+	}
+	if contentType, err := drivers.TypeByExtension(path.Ext(name)); err == nil {
+		return contentType
+	}
+	return http.DetectContentType(firstBytes)
+}
+
+func setAzureBlobProperties(h http.Header, contentType string, fields *drivers.FileProperties) {
+	h.Set("x-ms-blob-content-type", contentType)
+	if fields == nil {
+		return
+	}
+	if fields.CacheControl != "" {
+		h.Set("x-ms-blob-cache-control", fields.CacheControl)
+	}
+	for k, v := range fields.Metadata {
+		h.Set("x-ms-meta-"+k, v)
+	}
+}
+
+// signAzureBlobURL returns an https URL of the blob readable without credentials, for as long as PresignDuration with
+// the account key. With a SAS token the URL carries that token instead.
+func signAzureBlobURL(osURL string) (string, error) {
+	loc, err := parseAzureBlobURL(osURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse OS url: %w", err)
+	}
+	return loc.presign(PresignDuration), nil
+}
+
+// presign returns an https URL of the blob readable for as long as expire with the account key, or carrying the SAS
+// token of the location
+func (l azureBlobLocation) presign(expire time.Duration) string {
+	if l.key == nil {
+		return l.blobURL().String()
+	}
+
+	expiry := time.Now().UTC().Add(expire).Format(time.RFC3339)
+	// Service SAS granting read access to the blob
+	stringToSign := strings.Join([]string{
+		"r",    // signed permissions
+		"",     // signed start
+		expiry, // signed expiry
+		"/blob/" + l.account + "/" + l.container + "/" + l.blob,
+		"", // signed identifier
+		"", // signed IP
+		"", // signed protocol
+		azureBlobAPIVersion,
+		"b", // signed resource
+		"",  // signed snapshot time
+		"",  // signed encryption scope
+		"",  // rscc
+		"",  // rscd
+		"",  // rsce
+		"",  // rscl
+		"",  // rsct
+	}, "\n")
+	l.sas = url.Values{
+		"sp":  {"r"},
+		"se":  {expiry},
+		"sv":  {azureBlobAPIVersion},
+		"sr":  {"b"},
+		"sig": {l.hmac(stringToSign)},
+	}
+	return l.blobURL().String()
+}
+
+// azureBlobDriver is the go-tools storage driver of an Azure Blob Storage URL, so that the listing and deleting code
+// written against drivers.OSSession works with Azure too. Its sessions are rooted at the blob path of the URL.
+type azureBlobDriver struct {
+	loc azureBlobLocation
+}
+
+func newAzureBlobDriver(osURL string) (*azureBlobDriver, error) {
+	loc, err := parseAzureBlobURL(osURL)
+	if err != nil {
+		return nil, err
+	}
+	return &azureBlobDriver{loc: *loc}, nil
+}
+
+func (d *azureBlobDriver) NewSession(path string) drivers.OSSession {
+	return &azureBlobSession{driver: d, loc: d.loc.join(path)}
+}
+
+func (d *azureBlobDriver) Description() string {
+	return "Azure Blob Storage"
+}
+
+func (d *azureBlobDriver) UriSchemes() []string {
+	return []string{SCHEME_AZURE_BLOB}
+}
+
+// Publish isn't supported, the blobs are played back from their URLs
+func (d *azureBlobDriver) Publish(ctx context.Context) (string, error) {
+	return "", drivers.ErrNotSupported
+}
+
+type azureBlobSession struct {
+	driver *azureBlobDriver
+	loc    azureBlobLocation
+}
+
+func (s *azureBlobSession) OS() drivers.OSDriver {
+	return s.driver
+}
+
+// blob returns the location of a blob named relative to the session, or by its full name as listed
+func (s *azureBlobSession) blob(name string) azureBlobLocation {
+	if s.loc.blob != "" && (name == s.loc.blob || strings.HasPrefix(name, s.loc.blob+"/")) {
+		l := s.loc
+		l.blob = name
+		return l
+	}
+	return s.loc.join(name)
+}
+
+func (s *azureBlobSession) SaveData(ctx context.Context, name string, data io.Reader, fields *drivers.FileProperties, timeout time.Duration) (*drivers.SaveDataOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	loc := s.blob(name)
+	if err := loc.upload(ctx, data, fields); err != nil {
+		return nil, err
+	}
+	return &drivers.SaveDataOutput{URL: loc.blobURL().String()}, nil
+}
+
+func (s *azureBlobSession) EndSession() {}
+
+func (s *azureBlobSession) GetInfo() *drivers.OSInfo {
+	return nil
+}
+
+func (s *azureBlobSession) IsExternal() bool {
+	return true
+}
+
+func (s *azureBlobSession) IsOwn(url string) bool {
+	return strings.HasPrefix(url, s.loc.blobURL().String())
+}
+
+// ListFiles lists the blobs whose name starts with the prefix, under the session's path. The names are the full names
+// of the blobs in the container, as with the S3 driver.
+func (s *azureBlobSession) ListFiles(ctx context.Context, prefix, delim string) (drivers.PageInfo, error) {
+	listPrefix := s.blob(prefix).blob
+	if prefix == "" && listPrefix != "" {
+		listPrefix += "/"
+	}
+	page := &azureBlobPage{ctx: ctx, loc: s.loc, prefix: listPrefix, delim: delim}
+	if err := page.list(""); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (s *azureBlobSession) DeleteFile(ctx context.Context, name string) error {
+	loc := s.blob(name)
+	resp, err := loc.do(ctx, func() (*http.Request, error) {
+		return loc.newRequest(ctx, http.MethodDelete, nil, nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *azureBlobSession) ReadData(ctx context.Context, name string) (*drivers.FileInfoReader, error) {
+	return s.ReadDataRange(ctx, name, "")
+}
+
+func (s *azureBlobSession) ReadDataRange(ctx context.Context, name, byteRange string) (*drivers.FileInfoReader, error) {
+	return s.blob(name).get(ctx, byteRange)
+}
+
+func (s *azureBlobSession) Presign(name string, expire time.Duration) (string, error) {
+	return s.blob(name).presign(expire), nil
+}
+
+// azureBlobPage is a page of the List Blobs results of a container
+type azureBlobPage struct {
+	ctx    context.Context
+	loc    azureBlobLocation
+	prefix string
+	delim  string
+
+	files      []drivers.FileInfo
+	dirs       []string
+	nextMarker string
+}
+
+type azureBlobListing struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				LastModified  string `xml:"Last-Modified"`
+				Etag          string `xml:"Etag"`
+				ContentLength int64  `xml:"Content-Length"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+		BlobPrefix []struct {
+			Name string `xml:"Name"`
+		} `xml:"BlobPrefix"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (p *azureBlobPage) list(marker string) error {
+	container := p.loc
+	container.blob = ""
+	query := url.Values{"restype": {"container"}, "comp": {"list"}}
+	if p.prefix != "" {
+		query.Set("prefix", p.prefix)
+	}
+	if p.delim != "" {
+		query.Set("delimiter", p.delim)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	resp, err := container.do(p.ctx, func() (*http.Request, error) {
+		return container.newRequest(p.ctx, http.MethodGet, query, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var listing azureBlobListing
+	if err := xml.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return fmt.Errorf("invalid blob listing: %w", err)
+	}
+	for _, b := range listing.Blobs.Blob {
+		size := b.Properties.ContentLength
+		f := drivers.FileInfo{Name: b.Name, ETag: b.Properties.Etag, Size: &size}
+		if lastModified, err := http.ParseTime(b.Properties.LastModified); err == nil {
+			f.LastModified = lastModified
+		}
+		p.files = append(p.files, f)
+	}
+	for _, d := range listing.Blobs.BlobPrefix {
+		p.dirs = append(p.dirs, d.Name)
+	}
+	p.nextMarker = listing.NextMarker
+	return nil
+}
+
+func (p *azureBlobPage) Files() []drivers.FileInfo {
+	return p.files
+}
+
+func (p *azureBlobPage) Directories() []string {
+	return p.dirs
+}
+
+func (p *azureBlobPage) HasNextPage() bool {
+	return p.nextMarker != ""
+}
+
+func (p *azureBlobPage) NextPage() (drivers.PageInfo, error) {
+	if p.nextMarker == "" {
+		return nil, drivers.ErrNoNextPage
+	}
+	next := &azureBlobPage{ctx: p.ctx, loc: p.loc, prefix: p.prefix, delim: p.delim}
+	if err := next.list(p.nextMarker); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
@@ -1,14 +1,21 @@
 package clients
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/livepeer/catalyst-api/video"
 )
@@ -52,19 +59,55 @@ type StreamAllocResponse struct {
 	ManifestId string `json:"id"`
 }
 
-var client = newRetryableClient(&http.Client{Timeout: TRANSCODE_TIMEOUT})
+// segmentClient posts the segments to the broadcasters. It's only set up on first use, once the flags configuring its
+// transport are parsed.
+var segmentClient = sync.OnceValue(func() *http.Client {
+	return newRetryableClient(&http.Client{Timeout: TRANSCODE_TIMEOUT, Transport: newBroadcasterTransport()})
+})
+
+func newBroadcasterTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.BroadcasterMaxIdleConnsPerHost
+	if transport.MaxIdleConns > 0 && transport.MaxIdleConns < config.BroadcasterMaxIdleConnsPerHost {
+		transport.MaxIdleConns = config.BroadcasterMaxIdleConnsPerHost
+	}
+	transport.ForceAttemptHTTP2 = config.BroadcasterHTTP2
+	if !config.BroadcasterHTTP2 {
+		// a non-nil empty map disables HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return connReuseTransport{transport}
+}
+
+// connReuseTransport counts the connections used for each request by whether they were reused, to tell how well the
+// idle connections to the broadcasters are kept
+type connReuseTransport struct {
+	http.RoundTripper
+}
+
+func (t connReuseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.Metrics.BroadcasterConnections.WithLabelValues(req.URL.Host, strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.RoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
 
 // TranscodeSegment sends media to Livepeer network and returns rendition segments
 // If manifestId == "" one will be created and deleted after use, pass real value to reuse across multiple calls
-func transcodeSegment(inputSegment io.Reader, sequenceNumber, mediaDurationMillis int64, broadcasterURL url.URL, manifestId string, transcodeConfigHeader string) (TranscodeResult, error) {
-	t := TranscodeResult{}
+func transcodeSegment(ctx context.Context, inputSegment io.Reader, sequenceNumber, mediaDurationMillis int64, broadcasterURL url.URL, manifestId string, transcodeConfigHeader string) (t TranscodeResult, err error) {
+	defer func() { err = catErrs.WithClass(catErrs.ClassBroadcaster, err) }()
+	if err := injectFault("broadcaster"); err != nil {
+		return t, err
+	}
 
 	// Send segment to be transcoded
 	requestURL, err := broadcasterURL.Parse(fmt.Sprintf("live/%s/%d.ts", manifestId, sequenceNumber))
 	if err != nil {
 		return t, fmt.Errorf("appending stream to broadcaster url %s: %v", broadcasterURL.String(), err)
 	}
-	req, err := http.NewRequest(http.MethodPost, requestURL.String(), inputSegment)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL.String(), inputSegment)
 	if err != nil {
 		return t, fmt.Errorf("NewRequest POST for url %s: %v", requestURL.String(), err)
 	}
@@ -76,7 +119,7 @@ func transcodeSegment(inputSegment io.Reader, sequenceNumber, mediaDurationMilli
 		req.Header.Add("Livepeer-Transcode-Configuration", transcodeConfigHeader)
 
 	}
-	res, err := metrics.MonitorRequest(metrics.Metrics.BroadcasterClient, client, req)
+	res, err := metrics.MonitorRequest(metrics.Metrics.BroadcasterClient, segmentClient(), req)
 	if err != nil {
 		return t, fmt.Errorf("http do(%s): %v", requestURL, err)
 	}
@@ -141,7 +184,7 @@ func transcodeSegment(inputSegment io.Reader, sequenceNumber, mediaDurationMilli
 func validateProfiles(profiles []video.EncodedProfile) error {
 	for _, profile := range profiles {
 		if profile.Copy {
-			return fmt.Errorf("copy profile not supported on transcode pipeline")
+			return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, fmt.Errorf("copy profile not supported on transcode pipeline")))
 		}
 	}
 	return nil
@@ -0,0 +1,35 @@
+package clients
+
+import (
+	"context"
+	"io"
+)
+
+// DiscoveredBroadcasterClient sends segments to the broadcasters discovered through DNS. All of the segments of a
+// manifest go to the same broadcaster while it's healthy, so that they're transcoded by the same orchestrator session.
+type DiscoveredBroadcasterClient struct {
+	discovery *ServiceDiscovery
+}
+
+func NewDiscoveredBroadcasterClient(discovery *ServiceDiscovery) *DiscoveredBroadcasterClient {
+	return &DiscoveredBroadcasterClient{discovery: discovery}
+}
+
+// String lists the redacted discovered broadcaster URLs
+func (c *DiscoveredBroadcasterClient) String() string {
+	return c.discovery.String()
+}
+
+func (c *DiscoveredBroadcasterClient) TranscodeSegment(ctx context.Context, segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error) {
+	broadcasterURL, err := c.discovery.Pick(manifestID)
+	if err != nil {
+		return TranscodeResult{}, err
+	}
+	b, err := NewLocalBroadcasterClient(broadcasterURL)
+	if err != nil {
+		return TranscodeResult{}, err
+	}
+	res, err := b.TranscodeSegment(ctx, segment, sequenceNumber, durationMillis, manifestID, conf)
+	c.discovery.RecordResult(broadcasterURL, err)
+	return res, err
+}
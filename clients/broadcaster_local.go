@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,7 +13,7 @@ import (
 // Currently only implemented by LocalBroadcasterClient
 // TODO: Try to come up with a unified interface across Local and Remote
 type BroadcasterClient interface {
-	TranscodeSegment(segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error)
+	TranscodeSegment(ctx context.Context, segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error)
 }
 
 type LocalBroadcasterClient struct {
@@ -29,7 +30,12 @@ func NewLocalBroadcasterClient(broadcasterURL string) (BroadcasterClient, error)
 	}, nil
 }
 
-func (c *LocalBroadcasterClient) TranscodeSegment(segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error) {
+// String returns the redacted broadcaster URL
+func (c *LocalBroadcasterClient) String() string {
+	return c.broadcasterURL.Redacted()
+}
+
+func (c *LocalBroadcasterClient) TranscodeSegment(ctx context.Context, segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error) {
 	if err := validateProfiles(conf.Profiles); err != nil {
 		return TranscodeResult{}, err
 	}
@@ -37,5 +43,5 @@ func (c *LocalBroadcasterClient) TranscodeSegment(segment io.Reader, sequenceNum
 	if err != nil {
 		return TranscodeResult{}, fmt.Errorf("for local B, profiles json encode failed: %v", err)
 	}
-	return transcodeSegment(segment, sequenceNumber, durationMillis, c.broadcasterURL, manifestID, string(transcodeConfig))
+	return transcodeSegment(ctx, segment, sequenceNumber, durationMillis, c.broadcasterURL, manifestID, string(transcodeConfig))
 }
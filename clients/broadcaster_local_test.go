@@ -1,11 +1,16 @@
 package clients
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/livepeer/catalyst-api/video"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,12 +29,35 @@ func TestLocalBroadcasterValidatesProfiles(t *testing.T) {
 	client, err := NewLocalBroadcasterClient(testserver.URL)
 	require.NoError(err)
 
-	_, err = client.TranscodeSegment(nil, 0, 0, "", LivepeerTranscodeConfiguration{Profiles: []video.EncodedProfile{{Name: "bad", Copy: true}}})
+	_, err = client.TranscodeSegment(context.Background(), nil, 0, 0, "", LivepeerTranscodeConfiguration{Profiles: []video.EncodedProfile{{Name: "bad", Copy: true}}})
 	require.ErrorContains(err, "copy profile not supported on transcode pipeline")
 	require.Equal(0, called)
 
-	_, err = client.TranscodeSegment(nil, 0, 0, "", LivepeerTranscodeConfiguration{Profiles: []video.EncodedProfile{{Name: "360p0", Width: 640, Height: 360, Bitrate: 900_000, Quality: video.DefaultQuality}}})
+	_, err = client.TranscodeSegment(context.Background(), nil, 0, 0, "", LivepeerTranscodeConfiguration{Profiles: []video.EncodedProfile{{Name: "360p0", Width: 640, Height: 360, Bitrate: 900_000, Quality: video.DefaultQuality}}})
 	require.ErrorContains(err, "418 I'm a teapot")
 	require.ErrorContains(err, "hissss")
 	require.Equal(1, called)
 }
+
+func TestLocalBroadcasterReusesConnections(t *testing.T) {
+	require := require.New(t)
+
+	testserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary=xyz")
+		_, _ = w.Write([]byte("--xyz--\r\n"))
+	}))
+	defer testserver.Close()
+	host := strings.TrimPrefix(testserver.URL, "http://")
+
+	client, err := NewLocalBroadcasterClient(testserver.URL)
+	require.NoError(err)
+	conf := LivepeerTranscodeConfiguration{Profiles: []video.EncodedProfile{{Name: "360p0", Width: 640, Height: 360, Bitrate: 900_000}}}
+	for i := 0; i < 3; i++ {
+		_, err = client.TranscodeSegment(context.Background(), strings.NewReader("segment"), int64(i), 2000, "manifest", conf)
+		require.NoError(err)
+	}
+
+	require.Equal(1.0, testutil.ToFloat64(metrics.Metrics.BroadcasterConnections.WithLabelValues(host, "false")))
+	require.Equal(2.0, testutil.ToFloat64(metrics.Metrics.BroadcasterConnections.WithLabelValues(host, "true")))
+}
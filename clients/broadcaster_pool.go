@@ -0,0 +1,203 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// Number of consecutive failed segments after which a broadcaster is excluded until it passes a health check
+const BroadcasterMaxConsecutiveFailures = 3
+
+type BroadcasterEndpoint struct {
+	URL    string            `json:"url"`
+	Region string            `json:"region"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// ParseBroadcasterEndpoints parses the list of broadcasters in the format:
+// [{"url": "http://b-fra-1:8935", "region": "fra", "tags": {"gpu": "nvidia"}}]
+func ParseBroadcasterEndpoints(endpointsJSON string) ([]BroadcasterEndpoint, error) {
+	var endpoints []BroadcasterEndpoint
+	if err := json.Unmarshal([]byte(endpointsJSON), &endpoints); err != nil {
+		return nil, err
+	}
+	for i, e := range endpoints {
+		if _, err := url.Parse(e.URL); err != nil || e.URL == "" {
+			return nil, fmt.Errorf("invalid URL for broadcaster at index %d", i)
+		}
+	}
+	return endpoints, nil
+}
+
+type pooledBroadcaster struct {
+	BroadcasterEndpoint
+	client              BroadcasterClient
+	score               int
+	consecutiveFailures int
+	excluded            bool
+}
+
+// BroadcasterPool sends segments to the best broadcaster out of a list, preferring the healthy ones in the same
+// region as this node and with the most matching tags. Broadcasters failing several segments in a row are excluded
+// until they pass a health check again.
+type BroadcasterPool struct {
+	mu           sync.Mutex
+	broadcasters []*pooledBroadcaster
+	healthClient *http.Client
+}
+
+func NewBroadcasterPool(endpoints []BroadcasterEndpoint, ownRegion string, ownTags map[string]string) (*BroadcasterPool, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no broadcaster endpoints configured")
+	}
+	p := &BroadcasterPool{healthClient: withRequestHeaders(&http.Client{Timeout: 5 * time.Second})}
+	for _, e := range endpoints {
+		c, err := NewLocalBroadcasterClient(e.URL)
+		if err != nil {
+			return nil, err
+		}
+		p.broadcasters = append(p.broadcasters, &pooledBroadcaster{BroadcasterEndpoint: e, client: c, score: matchScore(e, ownRegion, ownTags)})
+	}
+
+	// Order by preference once, so that selection only needs to skip over the excluded broadcasters
+	sort.SliceStable(p.broadcasters, func(i, j int) bool {
+		return p.broadcasters[i].score > p.broadcasters[j].score
+	})
+	return p, nil
+}
+
+// matchScore ranks a broadcaster by how close it is to this node. Being in the same region always outweighs tags.
+func matchScore(e BroadcasterEndpoint, ownRegion string, ownTags map[string]string) int {
+	score := 0
+	if ownRegion != "" && e.Region == ownRegion {
+		score += 1000
+	}
+	for k, v := range e.Tags {
+		if ownTags[k] == v {
+			score++
+		}
+	}
+	return score
+}
+
+// String lists the redacted broadcaster URLs in order of preference, marking the excluded ones
+func (p *BroadcasterPool) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var urls []string
+	for _, b := range p.broadcasters {
+		u := log.RedactURL(b.URL)
+		if b.excluded {
+			u += " (excluded)"
+		}
+		urls = append(urls, u)
+	}
+	return strings.Join(urls, ", ")
+}
+
+func (p *BroadcasterPool) TranscodeSegment(ctx context.Context, segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error) {
+	b := p.pick(manifestID)
+	res, err := b.client.TranscodeSegment(ctx, segment, sequenceNumber, durationMillis, manifestID, conf)
+	p.recordResult(b, err)
+	return res, err
+}
+
+// pick returns the broadcaster for the manifest. All of the segments of a manifest go to the same broadcaster while
+// it's healthy, so that they're transcoded by the same orchestrator session.
+func (p *BroadcasterPool) pick(manifestID string) *pooledBroadcaster {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var candidates []*pooledBroadcaster
+	for _, b := range p.broadcasters {
+		if !b.excluded {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		// Better to try an unhealthy broadcaster than to fail the segment outright
+		candidates = p.broadcasters
+	}
+
+	// Spread manifests across the broadcasters that are as good as the best one
+	best := candidates[:1]
+	for _, b := range candidates[1:] {
+		if b.score == best[0].score {
+			best = append(best, b)
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(manifestID)) // nolint:errcheck
+	return best[h.Sum32()%uint32(len(best))]
+}
+
+func (p *BroadcasterPool) recordResult(b *pooledBroadcaster, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		b.consecutiveFailures = 0
+		return
+	}
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= BroadcasterMaxConsecutiveFailures && !b.excluded {
+		b.excluded = true
+		log.LogNoRequestID("excluding broadcaster after consecutive failures", "broadcaster", log.RedactURL(b.URL), "failures", b.consecutiveFailures, "err", err)
+	}
+}
+
+// RunHealthChecks periodically checks that the broadcasters are reachable, excluding the ones that aren't and
+// bringing back the excluded ones once they respond again
+func (p *BroadcasterPool) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, b := range p.broadcasters {
+			healthy := p.isHealthy(ctx, b.URL)
+			if ctx.Err() != nil {
+				return
+			}
+			p.mu.Lock()
+			if healthy && b.excluded {
+				log.LogNoRequestID("broadcaster healthy again", "broadcaster", log.RedactURL(b.URL))
+			} else if !healthy && !b.excluded {
+				log.LogNoRequestID("excluding broadcaster after failed health check", "broadcaster", log.RedactURL(b.URL))
+			}
+			b.excluded = !healthy
+			if healthy {
+				b.consecutiveFailures = 0
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// isHealthy checks that the broadcaster's HTTP server is up. Any response that isn't a server error is good enough,
+// since the broadcaster doesn't serve anything on the root path.
+func (p *BroadcasterPool) isHealthy(ctx context.Context, broadcasterURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, broadcasterURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := p.healthClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
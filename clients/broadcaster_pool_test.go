@@ -0,0 +1,101 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubBroadcaster struct {
+	calls int
+	err   error
+}
+
+func (s *stubBroadcaster) TranscodeSegment(ctx context.Context, segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf LivepeerTranscodeConfiguration) (TranscodeResult, error) {
+	s.calls++
+	return TranscodeResult{}, s.err
+}
+
+func newStubPool(t *testing.T, endpoints []BroadcasterEndpoint) (*BroadcasterPool, map[string]*stubBroadcaster) {
+	pool, err := NewBroadcasterPool(endpoints, "fra", map[string]string{"gpu": "nvidia"})
+	require.NoError(t, err)
+	stubs := map[string]*stubBroadcaster{}
+	for _, b := range pool.broadcasters {
+		stub := &stubBroadcaster{}
+		stubs[b.URL] = stub
+		b.client = stub
+	}
+	return pool, stubs
+}
+
+func TestBroadcasterPoolPrefersOwnRegionAndTags(t *testing.T) {
+	pool, stubs := newStubPool(t, []BroadcasterEndpoint{
+		{URL: "http://b-lax", Region: "lax", Tags: map[string]string{"gpu": "nvidia"}},
+		{URL: "http://b-fra-cpu", Region: "fra"},
+		{URL: "http://b-fra-gpu", Region: "fra", Tags: map[string]string{"gpu": "nvidia"}},
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := pool.TranscodeSegment(context.Background(), nil, int64(i), 1000, fmt.Sprintf("manifest-%d", i), LivepeerTranscodeConfiguration{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, stubs["http://b-fra-gpu"].calls)
+
+	// Excluded after consecutive failures, the next best broadcaster is used
+	stubs["http://b-fra-gpu"].err = fmt.Errorf("transcode failed")
+	for i := 0; i < BroadcasterMaxConsecutiveFailures; i++ {
+		_, err := pool.TranscodeSegment(context.Background(), nil, int64(i), 1000, "manifest", LivepeerTranscodeConfiguration{})
+		require.Error(t, err)
+	}
+	_, err := pool.TranscodeSegment(context.Background(), nil, 0, 1000, "manifest", LivepeerTranscodeConfiguration{})
+	require.NoError(t, err)
+	require.Equal(t, 1, stubs["http://b-fra-cpu"].calls)
+}
+
+func TestBroadcasterPoolSpreadsManifestsOverEquivalentBroadcasters(t *testing.T) {
+	pool, stubs := newStubPool(t, []BroadcasterEndpoint{
+		{URL: "http://b-fra-1", Region: "fra"},
+		{URL: "http://b-fra-2", Region: "fra"},
+	})
+
+	// Segments of the same manifest stick to one broadcaster
+	b := pool.pick("manifest")
+	for i := 0; i < 5; i++ {
+		require.Equal(t, b, pool.pick("manifest"))
+	}
+
+	for i := 0; i < 50; i++ {
+		_, err := pool.TranscodeSegment(context.Background(), nil, 0, 1000, fmt.Sprintf("manifest-%d", i), LivepeerTranscodeConfiguration{})
+		require.NoError(t, err)
+	}
+	require.Greater(t, stubs["http://b-fra-1"].calls, 0)
+	require.Greater(t, stubs["http://b-fra-2"].calls, 0)
+}
+
+func TestBroadcasterPoolHealthChecks(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	pool, err := NewBroadcasterPool([]BroadcasterEndpoint{{URL: healthy.URL}, {URL: unhealthy.URL}}, "", nil)
+	require.NoError(t, err)
+	pool.broadcasters[0].excluded = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	pool.RunHealthChecks(ctx, 10*time.Millisecond)
+
+	require.False(t, pool.broadcasters[0].excluded)
+	require.True(t, pool.broadcasters[1].excluded)
+}
@@ -2,6 +2,7 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -39,19 +40,20 @@ func NewRemoteBroadcasterClient(credentials Credentials) (RemoteBroadcasterClien
 	}, nil
 }
 
-func (c *RemoteBroadcasterClient) TranscodeSegmentWithRemoteBroadcaster(segment io.Reader, sequenceNumber int64, profiles []video.EncodedProfile, streamName string, durationMillis int64) (TranscodeResult, error) {
+func (c *RemoteBroadcasterClient) TranscodeSegmentWithRemoteBroadcaster(ctx context.Context, segment io.Reader, sequenceNumber int64, profiles []video.EncodedProfile, streamName string, durationMillis int64) (TranscodeResult, error) {
 	// Get available broadcasters
-	bList, err := findBroadcaster(c.credentials)
+	bList, err := findBroadcaster(ctx, c.credentials)
 	if err != nil {
 		return TranscodeResult{}, fmt.Errorf("findBroadcaster failed %v", err)
 	}
 
-	manifestId, err := CreateStream(c.credentials, streamName, profiles)
+	manifestId, err := CreateStream(ctx, c.credentials, streamName, profiles)
 	if err != nil {
 		return TranscodeResult{}, fmt.Errorf("CreateStream(): %v", err)
 	}
 	defer func() {
-		err := ReleaseManifestID(c.credentials, manifestId)
+		// released even when the transcode was cancelled
+		err := ReleaseManifestID(context.WithoutCancel(ctx), c.credentials, manifestId)
 		if err != nil {
 			log.LogNoRequestID("Error calling ReleaseManifestID", "error", err)
 		}
@@ -63,11 +65,11 @@ func (c *RemoteBroadcasterClient) TranscodeSegmentWithRemoteBroadcaster(segment
 		return TranscodeResult{}, fmt.Errorf("pickRandomBroadcaster failed %v", err)
 	}
 
-	return transcodeSegment(segment, sequenceNumber, durationMillis, broadcasterURL, manifestId, "")
+	return transcodeSegment(ctx, segment, sequenceNumber, durationMillis, broadcasterURL, manifestId, "")
 }
 
 // findBroadcaster contacts Livepeer API for a broadcaster to use if localBroadcaster is not defined
-func findBroadcaster(c Credentials) (BroadcasterList, error) {
+func findBroadcaster(ctx context.Context, c Credentials) (BroadcasterList, error) {
 	if c.AccessToken == "" || c.CustomAPIURL == "" {
 		return BroadcasterList{}, fmt.Errorf("empty credentials")
 	}
@@ -75,7 +77,7 @@ func findBroadcaster(c Credentials) (BroadcasterList, error) {
 	if err != nil {
 		return BroadcasterList{}, fmt.Errorf("appending broadcaster to api url %s: %v", c.CustomAPIURL, err)
 	}
-	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
 	if err != nil {
 		return BroadcasterList{}, fmt.Errorf("NewRequest GET for url %s: %v", requestURL, err)
 	}
@@ -104,7 +106,7 @@ func findBroadcaster(c Credentials) (BroadcasterList, error) {
 
 // CreateStream registers new stream on Livepeer infra and returns manifestId
 // Call `ReleaseManifestId(manifestId)` after use
-func CreateStream(c Credentials, streamName string, profiles []video.EncodedProfile) (string, error) {
+func CreateStream(ctx context.Context, c Credentials, streamName string, profiles []video.EncodedProfile) (string, error) {
 	requestURL, err := url.JoinPath(c.CustomAPIURL, "stream")
 	if err != nil {
 		return "", fmt.Errorf("appending stream to api url %s: %v", c.CustomAPIURL, err)
@@ -120,7 +122,7 @@ func CreateStream(c Credentials, streamName string, profiles []video.EncodedProf
 	if err != nil {
 		return "", fmt.Errorf("POST url=%s json encode error %v struct=%v", requestURL, err, payload)
 	}
-	req, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(payloadBytes))
 	if err != nil {
 		return "", fmt.Errorf("NewRequest POST for url %s: %v", requestURL, err)
 	}
@@ -148,13 +150,13 @@ func CreateStream(c Credentials, streamName string, profiles []video.EncodedProf
 }
 
 // ReleaseManifestID deletes manifestId created by prior call to CreateStream()
-func ReleaseManifestID(c Credentials, manifestId string) error {
+func ReleaseManifestID(ctx context.Context, c Credentials, manifestId string) error {
 	requestURL, err := url.JoinPath(c.CustomAPIURL, fmt.Sprintf("stream/%s", manifestId))
 	if err != nil {
 		return fmt.Errorf("Error construction API URL. API: %s, manifestID: %s", c.CustomAPIURL, manifestId)
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, requestURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, requestURL, nil)
 	if err != nil {
 		return fmt.Errorf("Creating HTTP request to release manifest ID failed. URL: %s, manifestID: %s", requestURL, manifestId)
 	}
@@ -191,6 +193,7 @@ func newRetryableClient(httpClient *http.Client) *http.Client {
 	if httpClient != nil {
 		client.HTTPClient = httpClient
 	}
+	client.HTTPClient = withRequestHeaders(client.HTTPClient)
 	client.Logger = log.NewRetryableHTTPLogger()
 
 	return client.StandardClient()
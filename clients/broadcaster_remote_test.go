@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -30,11 +31,11 @@ func TestRemoteBroadcasterValidatesProfiles(t *testing.T) {
 	client, err := NewRemoteBroadcasterClient(Credentials{CustomAPIURL: testserver.URL, AccessToken: "test"})
 	require.NoError(err)
 
-	_, err = client.TranscodeSegmentWithRemoteBroadcaster(nil, 0, []video.EncodedProfile{{Name: "bad", Copy: true}}, "", 0)
+	_, err = client.TranscodeSegmentWithRemoteBroadcaster(context.Background(), nil, 0, []video.EncodedProfile{{Name: "bad", Copy: true}}, "", 0)
 	require.ErrorContains(err, "copy profile not supported on transcode pipeline")
 	require.Equal(0, called)
 
-	_, err = client.TranscodeSegmentWithRemoteBroadcaster(nil, 0, []video.EncodedProfile{{Name: "360p0", Width: 640, Height: 360, Bitrate: 900_000, Quality: video.DefaultQuality}}, "", 0)
+	_, err = client.TranscodeSegmentWithRemoteBroadcaster(context.Background(), nil, 0, []video.EncodedProfile{{Name: "360p0", Width: 640, Height: 360, Bitrate: 900_000, Quality: video.DefaultQuality}}, "", 0)
 	require.ErrorContains(err, "418 I'm a teapot")
 	require.Equal(1, called)
 }
@@ -2,6 +2,7 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -41,9 +42,9 @@ func NewPeriodicCallbackClient(callbackInterval time.Duration, headers map[strin
 	client.RetryWaitMin = 200 * time.Millisecond // Wait at least this long between retries
 	client.RetryWaitMax = 1 * time.Second        // Wait at most this long between retries (exponential backoff)
 	client.CheckRetry = metrics.HttpRetryHook
-	client.HTTPClient = &http.Client{
+	client.HTTPClient = withRequestHeaders(&http.Client{
 		Timeout: 5 * time.Second, // Give up on requests that take more than this long
-	}
+	})
 	client.Logger = log.NewRetryableHTTPLogger()
 
 	return &PeriodicCallbackClient{
@@ -85,6 +86,11 @@ func (pcc *PeriodicCallbackClient) SendTranscodeStatus(tsm TranscodeStatusMessag
 	if tsm.URL == "" {
 		return nil
 	}
+	if tsm.Milestone != nil {
+		// Milestones are sent once as they're reached, rather than kept as the latest status of the job that is sent
+		// periodically
+		return pcc.sendCallback(tsm)
+	}
 	pcc.updateTranscodeStatus(tsm)
 
 	// Terminal callbacks are sent here in a sync manner
@@ -155,7 +161,7 @@ func (pcc *PeriodicCallbackClient) sendCallback(tsm TranscodeStatusMessage) erro
 		return err
 	}
 
-	r, err := http.NewRequest(http.MethodPost, tsm.URL, bytes.NewReader(j))
+	r, err := http.NewRequestWithContext(log.WithRequestID(context.Background(), tsm.RequestID), http.MethodPost, tsm.URL, bytes.NewReader(j))
 	if err != nil {
 		log.LogError(tsm.RequestID, "failed to create callback HTTP request", err)
 		return err
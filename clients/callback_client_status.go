@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/livepeer/catalyst-api/c2pa"
 	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/video"
 )
 
@@ -18,6 +20,9 @@ const (
 	TranscodeStatusTranscoding
 	TranscodeStatusCompleted
 	TranscodeStatusError
+	// Statuses of the requests deleting the outputs of a job
+	TranscodeStatusDeleting
+	TranscodeStatusDeleted
 )
 
 func (ts TranscodeStatus) String() string {
@@ -32,6 +37,10 @@ func (ts TranscodeStatus) String() string {
 		return "success"
 	case TranscodeStatusError:
 		return "error"
+	case TranscodeStatusDeleting:
+		return "deleting"
+	case TranscodeStatusDeleted:
+		return "deleted"
 	}
 	return "unknown"
 }
@@ -52,6 +61,10 @@ func (ts *TranscodeStatus) UnmarshalJSON(b []byte) error {
 		*ts = TranscodeStatusCompleted
 	case "\"error\"":
 		*ts = TranscodeStatusError
+	case "\"deleting\"":
+		*ts = TranscodeStatusDeleting
+	case "\"deleted\"":
+		*ts = TranscodeStatusDeleted
 	default:
 		return fmt.Errorf("invalid transcode status %q", string(b))
 	}
@@ -69,10 +82,16 @@ type TranscodeStatusMessage struct {
 	CompletionRatio float64         `json:"completion_ratio"` // No omitempty or we lose this for 0% completion case
 	Status          TranscodeStatus `json:"status"`
 	Timestamp       int64           `json:"timestamp"`
+	// Metadata set by the caller in the job request, echoed back as is
+	Metadata map[string]string `json:"metadata,omitempty"`
 
 	// Only used for the "Error" status message
 	Error       string `json:"error,omitempty"`
 	Unretriable bool   `json:"unretriable,omitempty"`
+	// Stable code of the error, see errors.Code
+	ErrorCode string `json:"error_code,omitempty"`
+	// Snapshot of the runtime config the job ran with, for debugging
+	Environment json.RawMessage `json:"environment,omitempty"`
 
 	// Only used for the "Completed" status message
 	Type       string              `json:"type,omitempty"`
@@ -80,6 +99,58 @@ type TranscodeStatusMessage struct {
 	Outputs    []video.OutputVideo `json:"outputs,omitempty"`
 
 	SourcePlayback *video.OutputVideo `json:"source_playback,omitempty"`
+	// Milliseconds from the job being accepted until the source playback was published, in the message announcing it
+	// and the final message
+	SourcePlaybackLatencyMs int64 `json:"source_playback_latency_ms,omitempty"`
+
+	// Black frames and silence found in the source, in the final message of the jobs that looked for them
+	BlankReport *video.BlankReport `json:"blank_report,omitempty"`
+	// The spoken language of the primary audio track of the source, for jobs asking for it
+	LanguageDetection *LanguageDetection `json:"language_detection,omitempty"`
+	// Outputs signed with C2PA manifests, for jobs asking for C2PA signing
+	C2PA *c2pa.Summary `json:"c2pa,omitempty"`
+	// The corrupt segments of the source, for jobs asking for its segments to be checked
+	SegmentIntegrity *video.SegmentIntegrityReport `json:"segment_integrity,omitempty"`
+	// Bytes the job moved over the network, in its final message
+	Transfer *TransferReport `json:"transfer,omitempty"`
+
+	// Set on the messages sent as the job reaches a milestone, on top of its regular status messages
+	Milestone *Milestone `json:"milestone,omitempty"`
+
+	// Only used for the "Deleted" status message
+	DeletedFiles int `json:"deleted_files,omitempty"`
+
+	// Only used for the status messages of a batch of clips, with the status of each of its clips
+	Clips []ClipStatus `json:"clips,omitempty"`
+}
+
+// Milestones of a job, for the jobs asking for them
+const (
+	// Percent of the source segments was transcoded
+	MilestoneSegments = "segments"
+	// The playback manifest of the source segments was published, before they're transcoded
+	MilestoneSourcePlayable = "source_playable"
+	// The HLS manifests of the renditions were published, before the MP4 outputs are generated
+	MilestonePlayable = "playable"
+)
+
+// Milestone is an intermediate event of a job, e.g. for showing the progress of a recording being processed
+type Milestone struct {
+	Event   string `json:"event"`
+	Percent int    `json:"percent,omitempty"`
+	// URL of the manifest the job can be previewed with, for the playable milestones
+	PreviewURL string `json:"preview_url,omitempty"`
+}
+
+// ClipStatus is the status of one of the clips of a batch, as of its latest status message
+type ClipStatus struct {
+	RequestID       string              `json:"request_id"`
+	Title           string              `json:"title,omitempty"`
+	Status          TranscodeStatus     `json:"status"`
+	CompletionRatio float64             `json:"completion_ratio"`
+	Error           string              `json:"error,omitempty"`
+	ErrorCode       string              `json:"error_code,omitempty"`
+	Outputs         []video.OutputVideo `json:"outputs,omitempty"`
 }
 
 // This method will accept the completion ratio of the current stage and will translate that into the overall ratio
@@ -98,12 +169,20 @@ func NewTranscodeStatusSourcePlayback(url, requestID string, status TranscodeSta
 	}
 }
 
+func NewTranscodeStatusMilestone(url, requestID string, status TranscodeStatus, currentStageCompletionRatio float64, milestone Milestone) TranscodeStatusMessage {
+	tsm := NewTranscodeStatusProgress(url, requestID, status, currentStageCompletionRatio)
+	tsm.Milestone = &milestone
+	return tsm
+}
+
+// NewTranscodeStatusError is an error status message, of an unknown error code until the caller sets it
 func NewTranscodeStatusError(url, requestID, errorMsg string, unretriable bool) TranscodeStatusMessage {
 	return TranscodeStatusMessage{
 		URL:         url,
 		RequestID:   requestID,
 		Error:       errorMsg,
 		Unretriable: unretriable,
+		ErrorCode:   catErrs.CodeUnknown,
 		Status:      TranscodeStatusError,
 		Timestamp:   config.Clock.GetTimestampUTC(),
 	}
@@ -123,11 +202,24 @@ func NewTranscodeStatusCompleted(url, requestID string, iv video.InputVideo, ov
 	}
 }
 
+// NewTranscodeStatusDeleted confirms that the outputs of a job have been deleted
+func NewTranscodeStatusDeleted(url, requestID string, deletedFiles int) TranscodeStatusMessage {
+	return TranscodeStatusMessage{
+		URL:             url,
+		CompletionRatio: 1,
+		RequestID:       requestID,
+		Status:          TranscodeStatusDeleted,
+		Timestamp:       config.Clock.GetTimestampUTC(),
+		DeletedFiles:    deletedFiles,
+	}
+}
+
 // IsTerminal returns whether the given status message is a terminal state,
 // meaning no other updates will be sent for this request.
 func (tsm TranscodeStatusMessage) IsTerminal() bool {
 	return tsm.Status == TranscodeStatusError ||
-		tsm.Status == TranscodeStatusCompleted
+		tsm.Status == TranscodeStatusCompleted ||
+		tsm.Status == TranscodeStatusDeleted
 }
 
 // Calculate the overall completion ratio based on the completion ratio of the current stage.
@@ -154,6 +246,10 @@ func OverallCompletionRatio(status TranscodeStatus, currentStageCompletionRatio
 		return scaleProgress(currentStageCompletionRatio, 0.4, 0.9)
 	case TranscodeStatusCompleted:
 		return scaleProgress(currentStageCompletionRatio, 0.9, 1)
+	case TranscodeStatusDeleting:
+		return currentStageCompletionRatio
+	case TranscodeStatusDeleted:
+		return 1
 	}
 
 	// Either unhandled or an error
@@ -26,6 +26,7 @@ func TestItRetriesOnFailedCallbacks(t *testing.T) {
 
 		// Check that the expected headers were passed through
 		require.Equal(t, "bar", r.Header["Foo"][0])
+		require.Equal(t, "example-request-id", r.Header.Get(RequestIDHeader))
 
 		// Check we got a valid callback message of the type we'd expect
 		var actualMsg TranscodeStatusMessage
@@ -166,6 +167,37 @@ func TestItDoesntSendOutOfOrderUpdates(t *testing.T) {
 	require.Equal(t, 0.9, latestCompletion)
 }
 
+func TestItSendsMilestonesOnce(t *testing.T) {
+	var received []TranscodeStatusMessage
+	var mu sync.Mutex
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg TranscodeStatusMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		mu.Lock()
+		received = append(received, msg)
+		mu.Unlock()
+	}))
+	defer svr.Close()
+
+	// Create a client that sends heartbeats very irregularly, so that only the milestone is sent right away
+	client := NewPeriodicCallbackClient(100*time.Hour, map[string]string{})
+	err := client.SendTranscodeStatus(NewTranscodeStatusMilestone(svr.URL, "example-request-id", TranscodeStatusTranscoding, 0.5, Milestone{Event: MilestoneSegments, Percent: 50}))
+	require.NoError(t, err)
+
+	mu.Lock()
+	require.Len(t, received, 1)
+	require.Equal(t, &Milestone{Event: MilestoneSegments, Percent: 50}, received[0].Milestone)
+	require.Equal(t, TranscodeStatusTranscoding, received[0].Status)
+	mu.Unlock()
+
+	// The milestone isn't kept as the latest status of the job, so it isn't sent again
+	client.SendCallbacks()
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	require.Len(t, received, 1)
+	mu.Unlock()
+}
+
 func TestItCalculatesTheOverallCompletionRatioCorrectly(t *testing.T) {
 	testCases := []struct {
 		status                         TranscodeStatus
@@ -0,0 +1,144 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+var cdnWarmUpClient = withRequestHeaders(&http.Client{Timeout: 30 * time.Second})
+
+// CDNWarmUpResult counts the warm-up requests of a job's outputs
+type CDNWarmUpResult struct {
+	Requested int
+	Failed    int
+	Duration  time.Duration
+}
+
+// WarmUpCDN requests the master playlists of the outputs, the playlists and first segments of each of their
+// renditions and the poster image through the CDN they're served from, so that the first viewers don't wait for the
+// CDN to fetch them from the origin. The outputs are addressed on the CDN by their path within their bucket, appended
+// to cdnPrefix, e.g. s3+https://k:s@storage.example.com/bucket/hls/abc/index.m3u8 as https://cdn.example.com/hls/abc/index.m3u8
+// for a https://cdn.example.com/ prefix. Failures are only counted, the outputs are already published.
+func WarmUpCDN(ctx context.Context, requestID string, cdnPrefix *url.URL, outputs []video.OutputVideo, poster string, segments int) CDNWarmUpResult {
+	ctx = log.WithRequestID(ctx, requestID)
+	start := time.Now()
+	var res CDNWarmUpResult
+	warm := func(objectType, objectURL string) []byte {
+		u, err := cdnURL(cdnPrefix, objectURL)
+		if err != nil {
+			log.Log(requestID, "skipping CDN warm-up", "type", objectType, "err", err)
+			return nil
+		}
+		res.Requested++
+		b, err := warmUpCDNObject(ctx, u, objectType == "playlist")
+		result := "success"
+		if err != nil {
+			res.Failed++
+			result = "failure"
+			log.Log(requestID, "CDN warm-up request failed", "type", objectType, "url", u, "err", err)
+		}
+		metrics.Metrics.CDNWarmUpRequests.WithLabelValues(objectType, result).Inc()
+		return b
+	}
+
+	for _, output := range outputs {
+		if output.Manifest != "" {
+			warm("playlist", output.Manifest)
+		}
+		for _, rendition := range output.Videos {
+			if path.Ext(rendition.Location) != ".m3u8" {
+				continue
+			}
+			playlist := warm("playlist", rendition.Location)
+			for _, segmentURL := range firstSegments(rendition.Location, playlist, segments) {
+				warm("segment", segmentURL)
+			}
+		}
+	}
+	if poster != "" {
+		warm("poster", poster)
+	}
+
+	res.Duration = time.Since(start)
+	metrics.Metrics.CDNWarmUpDurationSec.Observe(res.Duration.Seconds())
+	log.Log(requestID, "CDN warm-up finished", "requested", res.Requested, "failed", res.Failed, "duration", res.Duration)
+	return res
+}
+
+// cdnURL returns the URL an object is served from through the CDN, its path without the bucket appended to the prefix
+func cdnURL(cdnPrefix *url.URL, objectURL string) (string, error) {
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "ipfs" {
+		return "", fmt.Errorf("IPFS outputs aren't served through the CDN")
+	}
+	// assume the bucket is the first element of the path, as for source playback
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		return "", fmt.Errorf("no bucket in the path of %s", log.RedactURL(objectURL))
+	}
+	return cdnPrefix.JoinPath(parts[1]).String(), nil
+}
+
+// warmUpCDNObject requests an object through the CDN, reading it all so that the CDN caches all of it. The body is only
+// returned when keep is set.
+func warmUpCDNObject(ctx context.Context, u string, keep bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cdnWarmUpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if keep {
+		return io.ReadAll(resp.Body)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return nil, err
+}
+
+// firstSegments returns the object URLs of the first segments of a media playlist, resolved against its URL
+func firstSegments(playlistURL string, playlist []byte, count int) []string {
+	if len(playlist) == 0 || count <= 0 {
+		return nil
+	}
+	p, listType, err := m3u8.DecodeFrom(bytes.NewReader(playlist), true)
+	if err != nil || listType != m3u8.MEDIA {
+		return nil
+	}
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil
+	}
+	var res []string
+	for _, segment := range p.(*m3u8.MediaPlaylist).GetAllSegments() {
+		if len(res) == count {
+			break
+		}
+		u, err := base.Parse(segment.URI)
+		if err != nil {
+			continue
+		}
+		res = append(res, u.String())
+	}
+	return res
+}
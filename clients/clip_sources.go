@@ -0,0 +1,127 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// ClipSources holds the source files of a recording cut into several clips at once, e.g. the chapters of a batch of
+// clips, so that its manifest and segments are downloaded once however many of the clips need them. Unlike the
+// artifact cache it's always on, and only lives as long as the clips: the files are removed by Close.
+type ClipSources struct {
+	dir string
+	key *video.ScratchKey
+
+	mu    sync.Mutex
+	files map[string]*clipSource
+	count int
+}
+
+type clipSource struct {
+	// closed once the file is downloaded or failed to
+	done chan struct{}
+	name string
+	err  error
+}
+
+func NewClipSources() (*ClipSources, error) {
+	dir, err := os.MkdirTemp(os.TempDir(), "clip_sources_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clip sources dir: %w", err)
+	}
+	key, err := video.NewScratchKey()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &ClipSources{dir: dir, key: key, files: map[string]*clipSource{}}, nil
+}
+
+// GetFile has the signature of the package's GetFile, to download manifests through the sources
+func (s *ClipSources) GetFile(ctx context.Context, requestID, url string, dStorage *DStorageDownload) (io.ReadCloser, error) {
+	return s.GetFileRange(ctx, requestID, url, dStorage, 0, 0)
+}
+
+// GetFileRange is GetFileRange reading the file from the sources, downloading it first if no other clip did. Failed
+// downloads aren't kept, so that they can be retried.
+func (s *ClipSources) GetFileRange(ctx context.Context, requestID, url string, dStorage *DStorageDownload, offset, length int64) (io.ReadCloser, error) {
+	key := url
+	if length > 0 {
+		key += "|" + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(length, 10)
+	}
+
+	s.mu.Lock()
+	if s.files == nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("clip sources are closed")
+	}
+	f, ok := s.files[key]
+	if !ok {
+		s.count++
+		f = &clipSource{done: make(chan struct{}), name: filepath.Join(s.dir, strconv.Itoa(s.count))}
+		s.files[key] = f
+	}
+	s.mu.Unlock()
+
+	if ok {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		log.Log(requestID, "using clip source downloaded already", "url", log.RedactURL(url))
+		return s.key.Open(f.name)
+	}
+
+	f.err = s.download(ctx, requestID, url, dStorage, offset, length, f.name)
+	if f.err != nil {
+		s.mu.Lock()
+		if s.files != nil {
+			delete(s.files, key)
+		}
+		s.mu.Unlock()
+	}
+	close(f.done)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return s.key.Open(f.name)
+}
+
+func (s *ClipSources) download(ctx context.Context, requestID, url string, dStorage *DStorageDownload, offset, length int64, name string) error {
+	rc, err := GetFileRange(ctx, requestID, url, dStorage, offset, length)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := s.key.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Close()
+		os.Remove(name)
+		return fmt.Errorf("failed to write clip source: %w", err)
+	}
+	return w.Close()
+}
+
+// Close removes the downloaded files. Files already opened stay readable until they're closed.
+func (s *ClipSources) Close() error {
+	s.mu.Lock()
+	s.files = nil
+	s.mu.Unlock()
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,67 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClipSourcesDownloadOnce(t *testing.T) {
+	content := "0123456789abcdefghij"
+	var requests, failures atomic.Int32
+	failures.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if strings.HasSuffix(r.URL.Path, "flaky.ts") && failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "all.ts", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	sources, err := NewClipSources()
+	require.NoError(t, err)
+	read := func(url string, offset, length int64) string {
+		rc, err := sources.GetFileRange(context.Background(), "requestID", url, nil, offset, length)
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// The clips of a batch ask for the same files at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Equal(t, content, read(server.URL+"/all.ts", 0, 0))
+			require.Equal(t, "abcde", read(server.URL+"/all.ts", 10, 5))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), requests.Load())
+
+	// Failed downloads are tried again
+	_, err = sources.GetFile(context.Background(), "requestID", server.URL+"/flaky.ts", nil)
+	require.Error(t, err)
+	require.Equal(t, content, read(server.URL+"/flaky.ts", 0, 0))
+	require.Equal(t, int32(4), requests.Load())
+
+	require.NoError(t, sources.Close())
+	_, err = os.Stat(sources.dir)
+	require.True(t, os.IsNotExist(err))
+	_, err = sources.GetFile(context.Background(), "requestID", server.URL+"/all.ts", nil)
+	require.Error(t, err)
+}
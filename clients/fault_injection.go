@@ -0,0 +1,25 @@
+package clients
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// ErrInjectedFault is returned by the calls failed on purpose with -fault-inject
+var ErrInjectedFault = fmt.Errorf("injected fault")
+
+// injectFault randomly fails a call of the given type at the rate configured in config.FaultInjectionRates. The error
+// is retriable, like the transient failures it simulates.
+func injectFault(target string) error {
+	rate := config.FaultInjectionRates[target]
+	if rate <= 0 || rand.Float64() >= rate {
+		return nil
+	}
+	log.LogNoRequestID("injecting fault", "target", target, "rate", rate)
+	metrics.Metrics.InjectedFaults.WithLabelValues(target).Inc()
+	return fmt.Errorf("%s: %w", target, ErrInjectedFault)
+}
@@ -0,0 +1,23 @@
+package clients
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectFault(t *testing.T) {
+	defer func(rates map[string]float64) { config.FaultInjectionRates = rates }(config.FaultInjectionRates)
+
+	config.FaultInjectionRates = map[string]float64{"upload": 1}
+	err := UploadToOSURL("file://"+t.TempDir(), "file.txt", bytes.NewReader([]byte("data")), time.Second)
+	require.True(t, errors.Is(err, ErrInjectedFault))
+	require.NoError(t, injectFault("mist"))
+
+	config.FaultInjectionRates = map[string]float64{"upload": 0}
+	require.NoError(t, UploadToOSURL("file://"+t.TempDir(), "file.txt", bytes.NewReader([]byte("data")), time.Second))
+}
@@ -2,8 +2,11 @@ package clients
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/livepeer/catalyst-api/cache"
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/crypto"
 	catErrs "github.com/livepeer/catalyst-api/errors"
@@ -32,7 +36,7 @@ var IgnoreProbeErrs = []string{
 }
 
 type InputCopier interface {
-	CopyInputToS3(requestID string, inputFile, osTransferURL *url.URL, decryptor *crypto.DecryptionKeys) (video.InputVideo, string, error)
+	CopyInputToS3(ctx context.Context, requestID string, inputFile, osTransferURL *url.URL, decryptor *crypto.DecryptionKeys) (video.InputVideo, string, error)
 }
 
 type InputCopy struct {
@@ -47,14 +51,15 @@ func NewInputCopy() *InputCopy {
 }
 
 // CopyInputToS3 copies the input video to our S3 transfer bucket and probes the file.
-func (s *InputCopy) CopyInputToS3(requestID string, inputFile, osTransferURL *url.URL, decryptor *crypto.DecryptionKeys) (video.InputVideo, string, error) {
-	var signedURL string
+func (s *InputCopy) CopyInputToS3(ctx context.Context, requestID string, inputFile, osTransferURL *url.URL, decryptor *crypto.DecryptionKeys) (video.InputVideo, string, error) {
+	var signedURL, checksum string
 	var err error
 	if IsHLSInput(inputFile) {
 		log.Log(requestID, "skipping copy for hls")
 		signedURL = inputFile.String()
 	} else {
-		if err := CopyAllInputFiles(requestID, inputFile, osTransferURL, decryptor); err != nil {
+		checksum, err = CopyAllInputFiles(ctx, requestID, inputFile, osTransferURL, decryptor)
+		if err != nil {
 			return video.InputVideo{}, "", fmt.Errorf("failed to copy file(s): %w", err)
 		}
 
@@ -64,12 +69,21 @@ func (s *InputCopy) CopyInputToS3(requestID string, inputFile, osTransferURL *ur
 		}
 	}
 
+	// The sources normalized with -normalize-sources are checked once converted, the codecs and framerates the
+	// pipelines can't transcode being what's normalized
+	normalizable := config.NormalizeSources && !IsHLSInput(inputFile)
+	prober := s.Probe
+	if p, ok := prober.(video.Probe); ok && normalizable {
+		p.Normalizable = true
+		prober = p
+	}
 	log.Log(requestID, "starting probe", "source", inputFile.Redacted(), "dest", osTransferURL.Redacted())
-	inputFileProbe, err := s.Probe.ProbeFile(requestID, signedURL, "-analyzeduration", "15000000")
+	inputFileProbe, err := prober.ProbeFile(requestID, signedURL, "-analyzeduration", "15000000")
 	if err != nil {
 		log.Log(requestID, "probe failed", "err", err, "source", inputFile.Redacted(), "dest", osTransferURL.Redacted())
 		return video.InputVideo{}, "", fmt.Errorf("error probing MP4 input file from S3: %w", err)
 	}
+	inputFileProbe.Checksum = checksum
 
 	log.Log(requestID, "probe succeeded", "source", inputFile.Redacted(), "dest", osTransferURL.Redacted())
 	videoTrack, err := inputFileProbe.GetTrack(video.TrackTypeVideo)
@@ -94,12 +108,12 @@ func (s *InputCopy) CopyInputToS3(requestID string, inputFile, osTransferURL *ur
 	if hasVideoTrack {
 		log.Log(requestID, "probed video track:", "container", inputFileProbe.Format, "codec", videoTrack.Codec, "bitrate", videoTrack.Bitrate, "duration", videoTrack.DurationSec, "w", videoTrack.Width, "h", videoTrack.Height, "pix-format", videoTrack.PixelFormat, "FPS", videoTrack.FPS)
 	}
-	if hasVideoTrack && videoTrack.FPS <= 0 {
+	if hasVideoTrack && videoTrack.FPS <= 0 && !normalizable {
 		// unsupported, includes things like motion jpegs
-		return video.InputVideo{}, "", fmt.Errorf("invalid framerate: %f", videoTrack.FPS)
+		return video.InputVideo{}, "", catErrs.Unretriable(catErrs.WithClass(catErrs.ClassUnsupportedInput, fmt.Errorf("invalid framerate: %f", videoTrack.FPS)))
 	}
 	if inputFileProbe.SizeBytes > config.MaxInputFileSizeBytes {
-		return video.InputVideo{}, "", fmt.Errorf("input file %d bytes was greater than %d bytes", inputFileProbe.SizeBytes, config.MaxInputFileSizeBytes)
+		return video.InputVideo{}, "", catErrs.Unretriable(catErrs.WithClass(catErrs.ClassUnsupportedInput, fmt.Errorf("input file %d bytes was greater than %d bytes", inputFileProbe.SizeBytes, config.MaxInputFileSizeBytes)))
 	}
 
 	audioTrack, _ := inputFileProbe.GetTrack(video.TrackTypeAudio)
@@ -116,7 +130,13 @@ func getVideoTrackDuration(requestID, manifestUrl string) float64 {
 	return manifestDuration
 }
 
+var storageHeadClient = withRequestHeaders(&http.Client{})
+
 func getSignedURL(osTransferURL *url.URL) (string, error) {
+	if storageQuirksFor(osTransferURL.String()).noAnonymousAccess {
+		return SignURL(osTransferURL)
+	}
+
 	// check if plain https is accessible, if not then the bucket must be private and we need to generate a signed url
 	// in most cases signed urls work fine as input but in the edge case where we have to fall back to mediaconvert
 	// for an hls input (for recordings) the signed url will fail because mediaconvert tries to append the same
@@ -126,7 +146,7 @@ func getSignedURL(osTransferURL *url.URL) (string, error) {
 	httpURL.Scheme = "https"
 	signedURL := httpURL.String()
 
-	resp, err := http.Head(signedURL)
+	resp, err := storageHeadClient.Head(signedURL)
 	if resp != nil {
 		resp.Body.Close()
 	}
@@ -181,59 +201,70 @@ func getSegmentTransferLocation(srcManifestUrl, dstTransferUrl *url.URL, srcSegm
 }
 
 // CopyAllInputFiles will copy the m3u8 manifest and all ts segments for HLS input whereas
-// it will copy just the single video file for MP4/MOV input
-func CopyAllInputFiles(requestID string, srcInputUrl, dstOutputUrl *url.URL, decryptor *crypto.DecryptionKeys) (err error) {
+// it will copy just the single video file for MP4/MOV input. The sha256 checksum of the
+// (decrypted) video file is returned for the latter, identifying its content.
+func CopyAllInputFiles(ctx context.Context, requestID string, srcInputUrl, dstOutputUrl *url.URL, decryptor *crypto.DecryptionKeys) (checksum string, err error) {
 	fileList := make(map[string]string)
+	var sum hash.Hash
 	if IsHLSInput(srcInputUrl) {
 		// Download the m3u8 manifest using the input url
 		playlist, err := DownloadRenditionManifest(requestID, srcInputUrl.String())
 		if err != nil {
-			return fmt.Errorf("error downloading HLS input manifest: %s", err)
+			return "", fmt.Errorf("error downloading HLS input manifest: %s", err)
 		}
 		// Save the mapping between the input m3u8 manifest file to its corresponding OS-transfer destination url
 		fileList[srcInputUrl.String()] = dstOutputUrl.String()
 		// Now get a list of the OS-compatible segment URLs from the input manifest file
 		sourceSegmentUrls, err := GetSourceSegmentURLs(srcInputUrl.String(), playlist)
 		if err != nil {
-			return fmt.Errorf("error generating source segment URLs for HLS input manifest: %s", err)
+			return "", fmt.Errorf("error generating source segment URLs for HLS input manifest: %s", err)
 		}
 		// Then save the mapping between the OS-compatible segment URLs to its OS-transfer destination url
 		for _, srcSegmentUrl := range sourceSegmentUrls {
 			u, err := getSegmentTransferLocation(srcInputUrl, dstOutputUrl, srcSegmentUrl.URL.String())
 			if err != nil {
-				return fmt.Errorf("error generating an OS compatible transfer location for each segment: %s", err)
+				return "", fmt.Errorf("error generating an OS compatible transfer location for each segment: %s", err)
 			}
 			fileList[srcSegmentUrl.URL.String()] = u
 		}
 
 	} else {
 		fileList[srcInputUrl.String()] = dstOutputUrl.String()
+		sum = sha256.New()
 	}
 
 	var byteCount int64
 	for inFile, outFile := range fileList {
 		log.Log(requestID, "Copying input file to S3", "source", inFile, "dest", outFile)
 
-		size, err := CopyFileWithDecryption(context.Background(), inFile, outFile, "", requestID, decryptor)
+		size, err := copyFile(ctx, inFile, outFile, "", requestID, decryptor, sum)
 
 		if err != nil {
 			err = fmt.Errorf("error copying input file to S3: %w", err)
-			return err
+			return "", err
 		}
 		if size <= 0 {
 			if len(fileList) <= 1 {
-				return fmt.Errorf("zero bytes found for source: %s", inFile)
+				return "", fmt.Errorf("zero bytes found for source: %s", inFile)
 			} else {
 				log.Log(requestID, "zero bytes found for file", "file", inFile)
 			}
 		}
 		byteCount += size
 	}
-	log.Log(requestID, "Copied", "bytes", byteCount, "source", srcInputUrl.Redacted(), "dest", dstOutputUrl.Redacted())
-	return nil
+	if sum != nil {
+		checksum = hex.EncodeToString(sum.Sum(nil))
+	}
+	log.Log(requestID, "Copied", "bytes", byteCount, "source", srcInputUrl.Redacted(), "dest", dstOutputUrl.Redacted(), "checksum", checksum)
+	return checksum, nil
 }
 
 func CopyFileWithDecryption(ctx context.Context, sourceURL, destOSBaseURL, filename, requestID string, decryptor *crypto.DecryptionKeys) (writtenBytes int64, err error) {
+	return copyFile(ctx, sourceURL, destOSBaseURL, filename, requestID, decryptor, nil)
+}
+
+// copyFile also writes the copied content to sum when it's set, resetting it on every attempt
+func copyFile(ctx context.Context, sourceURL, destOSBaseURL, filename, requestID string, decryptor *crypto.DecryptionKeys, sum hash.Hash) (writtenBytes int64, err error) {
 	dStorage := NewDStorageDownload()
 	err = backoff.Retry(func() error {
 		// currently this timeout is only used for http downloads in the getFileHTTP function when it calls http.NewRequestWithContext
@@ -261,14 +292,18 @@ func CopyFileWithDecryption(ctx context.Context, sourceURL, destOSBaseURL, filen
 			c = decryptedFile
 		}
 
-		content := io.TeeReader(c, &byteAccWriter)
+		var content io.Reader = io.TeeReader(c, &byteAccWriter)
+		if sum != nil {
+			sum.Reset()
+			content = io.TeeReader(content, sum)
+		}
 
-		err = UploadToOSURL(destOSBaseURL, filename, content, MaxCopyFileDuration)
+		err = UploadToOSURLFields(ctx, destOSBaseURL, filename, content, MaxCopyFileDuration, nil)
 		if err != nil {
 			log.Log(requestID, "Copy attempt failed", "source", sourceURL, "dest", path.Join(destOSBaseURL, filename), "err", err)
 		}
 		return err
-	}, UploadRetryBackoff())
+	}, backoff.WithContext(UploadRetryBackoff(), ctx))
 	return
 }
 
@@ -276,15 +311,107 @@ func CopyFile(ctx context.Context, sourceURL, destOSBaseURL, filename, requestID
 	return CopyFileWithDecryption(ctx, sourceURL, destOSBaseURL, filename, requestID, nil)
 }
 
-func GetFile(ctx context.Context, requestID, url string, dStorage *DStorageDownload) (io.ReadCloser, error) {
-	_, err := drivers.ParseOSURL(url, true)
-	if err == nil {
+func GetFile(ctx context.Context, requestID, url string, dStorage *DStorageDownload) (rc io.ReadCloser, err error) {
+	defer func(start time.Time) { observeStorageOperation("get", url, start, err) }(time.Now())
+	defer func() {
+		if err == nil {
+			rc = countDownload(ctx, url, rc)
+		}
+	}()
+
+	if IsOSURL(url) {
 		return DownloadOSURL(url)
 	} else if IsDStorageResource(url) && dStorage != nil {
 		return dStorage.DownloadDStorageFromGatewayList(url, requestID)
 	} else {
-		return getFileHTTP(ctx, url)
+		return getFileHTTP(log.WithRequestID(ctx, requestID), url)
+	}
+}
+
+// GetFileRange is GetFile reading only the given byte range of the file, e.g. a segment of an HLS playlist with
+// EXT-X-BYTERANGE tags. A zero length reads the whole file. Object stores and HTTP servers are sent a ranged GET, the
+// rest of the file is skipped locally when the server doesn't honour it.
+func GetFileRange(ctx context.Context, requestID, url string, dStorage *DStorageDownload, offset, length int64) (rc io.ReadCloser, err error) {
+	if length <= 0 {
+		return GetFile(ctx, requestID, url, dStorage)
+	}
+	defer func(start time.Time) { observeStorageOperation("get", url, start, err) }(time.Now())
+	defer func() {
+		if err == nil {
+			rc = countDownload(ctx, url, rc)
+		}
+	}()
+
+	byteRange := fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	if IsOSURL(url) {
+		info, err := GetOSURL(url, byteRange)
+		if errors.Is(err, drivers.ErrNotSupported) {
+			// Only some object stores can read a range
+			rc, err := DownloadOSURL(url)
+			if err != nil {
+				return nil, err
+			}
+			return sliceReader(rc, offset, length)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.ContentRange == "" {
+			return sliceReader(info.Body, offset, length)
+		}
+		return info.Body, nil
+	} else if IsDStorageResource(url) && dStorage != nil {
+		rc, err := dStorage.DownloadDStorageFromGatewayList(url, requestID)
+		if err != nil {
+			return nil, err
+		}
+		return sliceReader(rc, offset, length)
+	}
+	return getFileHTTPRange(log.WithRequestID(ctx, requestID), url, offset, length)
+}
+
+// sliceReader skips to the offset of a file being read from its start and stops reading after length bytes
+func sliceReader(rc io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("error skipping to byte range offset %d: %w", offset, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, length), rc}, nil
+}
+
+// GetFileCached is GetFile going through the node's artifact cache, for files downloaded repeatedly such as the
+// manifests and segments of a recording clipped several times. Files whose version can't be told aren't cached.
+func GetFileCached(ctx context.Context, requestID, url string, dStorage *DStorageDownload) (io.ReadCloser, error) {
+	if cache.Artifacts == nil {
+		return GetFile(ctx, requestID, url, dStorage)
+	}
+	key := video.SourceVersionKey(ctx, url)
+	if key == "" {
+		return GetFile(ctx, requestID, url, dStorage)
+	}
+	if f, ok := cache.Artifacts.Open(key); ok {
+		log.Log(requestID, "using cached file", "url", log.RedactURL(url))
+		return f, nil
+	}
+
+	rc, err := GetFile(ctx, requestID, url, dStorage)
+	if err != nil {
+		return nil, err
+	}
+	err = cache.Artifacts.Put(key, rc)
+	rc.Close()
+	if err != nil {
+		log.LogError(requestID, "failed to write file to the artifact cache", err, "url", log.RedactURL(url))
+		return GetFile(ctx, requestID, url, dStorage)
+	}
+	if f, ok := cache.Artifacts.Open(key); ok {
+		return f, nil
 	}
+	// Too large for the cache
+	return GetFile(ctx, requestID, url, dStorage)
 }
 
 func GetFileWithBackup(ctx context.Context, requestID, url string, dStorage *DStorageDownload) (io.ReadCloser, string, error) {
@@ -311,17 +438,23 @@ func GetFileWithBackup(ctx context.Context, requestID, url string, dStorage *DSt
 	return nil, url, err
 }
 
-var retryableHttpClient = newRetryableHttpClient()
+var retryableHttpClient = newRetryableHttpClient(&http.Client{
+	// Give up on requests that take more than this long - the file is probably too big for us to process locally if it takes this long
+	// or something else has gone wrong and the request is hanging
+	Timeout: MaxCopyFileDuration,
+})
 
-func newRetryableHttpClient() *http.Client {
+func newRetryableHttpClient(httpClient *http.Client) *http.Client {
 	client := retryablehttp.NewClient()
 	client.RetryMax = 5                          // Retry a maximum of this+1 times
 	client.RetryWaitMin = 200 * time.Millisecond // Wait at least this long between retries
 	client.RetryWaitMax = 5 * time.Second        // Wait at most this long between retries (exponential backoff)
-	client.HTTPClient = &http.Client{
-		// Give up on requests that take more than this long - the file is probably too big for us to process locally if it takes this long
-		// or something else has gone wrong and the request is hanging
-		Timeout: MaxCopyFileDuration,
+	client.HTTPClient = withRequestHeaders(httpClient)
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if errors.Is(err, ErrNonPublicAddress) {
+			return false, err
+		}
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
 	}
 	client.Logger = log.NewRetryableHTTPLogger()
 
@@ -329,31 +462,51 @@ func newRetryableHttpClient() *http.Client {
 }
 
 func getFileHTTP(ctx context.Context, url string) (io.ReadCloser, error) {
+	return getFileHTTPRange(ctx, url, 0, 0)
+}
+
+func getFileHTTPRange(ctx context.Context, url string, offset, length int64) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, catErrs.Unretriable(fmt.Errorf("error creating http request: %w", err))
+		return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, fmt.Errorf("error creating http request: %w", err)))
+	}
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	client := retryableHttpClient
+	if publicAddressesOnly(ctx) {
+		client = publicRetryableHttpClient
+	}
+	resp, err := client.Do(req)
+	if errors.Is(err, ErrNonPublicAddress) {
+		return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, fmt.Errorf("error on import request: %w", err)))
 	}
-	resp, err := retryableHttpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error on import request: %w", err)
+		return nil, catErrs.WithClass(catErrs.ClassStorage, fmt.Errorf("error on import request: %w", err))
 	}
 
 	if resp.StatusCode >= 300 {
 		resp.Body.Close()
 
 		msg := fmt.Sprintf("bad status code from import request: %d %s", resp.StatusCode, resp.Status)
+		statusErr := HTTPStatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")), msg: msg}
 		if resp.StatusCode == 404 {
 			return nil, catErrs.NewObjectNotFoundError(msg, nil)
-		} else if resp.StatusCode < 500 {
-			return nil, catErrs.Unretriable(errors.New(msg))
+		} else if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassAccessDenied, statusErr))
+		} else if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, statusErr))
 		}
-		return nil, errors.New(msg)
+		return nil, catErrs.WithClass(catErrs.ClassStorage, statusErr)
+	}
+	if length > 0 && resp.StatusCode != http.StatusPartialContent {
+		return sliceReader(resp.Body, offset, length)
 	}
 	return resp.Body, nil
 }
 
 type StubInputCopy struct{}
 
-func (s *StubInputCopy) CopyInputToS3(requestID string, inputFile, osTransferURL *url.URL, decryptor *crypto.DecryptionKeys) (video.InputVideo, string, error) {
+func (s *StubInputCopy) CopyInputToS3(ctx context.Context, requestID string, inputFile, osTransferURL *url.URL, decryptor *crypto.DecryptionKeys) (video.InputVideo, string, error) {
 	return video.InputVideo{}, "", nil
 }
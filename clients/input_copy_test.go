@@ -1,9 +1,18 @@
 package clients
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/video"
 	"github.com/stretchr/testify/require"
 )
@@ -101,8 +110,68 @@ func TestHLSDurationSet(t *testing.T) {
 		Probe: video.Probe{},
 	}
 	inputFile, _ := url.Parse("../test/fixtures/tiny.m3u8")
-	iv, _, err := i.CopyInputToS3("requestID", inputFile, &url.URL{}, nil)
+	iv, _, err := i.CopyInputToS3(context.Background(), "requestID", inputFile, &url.URL{}, nil)
 	require.NoError(t, err)
 	videoTrack, _ := iv.GetTrack(video.TrackTypeVideo)
 	require.Equal(t, 30.0, videoTrack.DurationSec)
 }
+
+func TestGetFileRange(t *testing.T) {
+	content := "0123456789abcdefghij"
+	read := func(url string, offset, length int64) string {
+		rc, err := GetFileRange(context.Background(), "requestID", url, nil, offset, length)
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	var ranges []string
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "all.ts", time.Time{}, strings.NewReader(content))
+	}))
+	defer ranged.Close()
+	require.Equal(t, "abcde", read(ranged.URL, 10, 5))
+	require.Equal(t, content, read(ranged.URL, 0, 0))
+	require.Equal(t, []string{"bytes=10-14", ""}, ranges)
+
+	// Servers ignoring the range send the whole file
+	unranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(content))
+	}))
+	defer unranged.Close()
+	require.Equal(t, "abcde", read(unranged.URL, 10, 5))
+
+	// So do the object stores that can't read a range
+	file := filepath.Join(t.TempDir(), "all.ts")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	require.Equal(t, "34567", read(file, 3, 5))
+}
+
+func TestGetFileHTTPErrorClasses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/denied":
+			w.WriteHeader(http.StatusForbidden)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	_, err := GetFile(context.Background(), "requestID", server.URL+"/denied", nil)
+	require.True(t, catErrs.IsUnretriable(err))
+	require.Equal(t, catErrs.ClassAccessDenied, catErrs.Class(err))
+
+	_, err = GetFile(context.Background(), "requestID", server.URL+"/gone", nil)
+	require.True(t, catErrs.IsUnretriable(err))
+	require.Equal(t, catErrs.ClassInvalidRequest, catErrs.Class(err))
+
+	_, err = GetFile(context.Background(), "requestID", server.URL+"/missing", nil)
+	require.True(t, catErrs.IsUnretriable(err))
+	require.Equal(t, catErrs.ClassNotFound, catErrs.Class(err))
+}
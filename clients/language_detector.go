@@ -0,0 +1,98 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+)
+
+const languageDetectionTimeout = 5 * time.Minute
+
+// LanguageDetection is the spoken language of the primary audio track of a source
+type LanguageDetection struct {
+	// BCP 47 language tag, e.g. "en" or "pt-BR"
+	Language string `json:"language"`
+	// From 0 to 1, when the detector reports it
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// LanguageDetector identifies the spoken language of the primary audio track of a source. Pluggable so that other
+// services or a local model can be used instead of the external API.
+type LanguageDetector interface {
+	DetectLanguage(ctx context.Context, requestID, sourceURL string) (LanguageDetection, error)
+}
+
+// ParseLanguageDetectorURL returns the detector for a given URL, e.g. https://<token>@detector.example.com/v1/detect
+// for the external API
+func ParseLanguageDetectorURL(input string) (LanguageDetector, error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing host in language detector url")
+		}
+		token := u.User.Username()
+		u.User = nil
+		return &ExternalLanguageDetector{
+			Endpoint:   u.String(),
+			Token:      token,
+			HTTPClient: withRequestHeaders(&http.Client{Timeout: languageDetectionTimeout}),
+		}, nil
+	}
+	return nil, fmt.Errorf("unrecognized language detector scheme: %s", u.Scheme)
+}
+
+// ExternalLanguageDetector asks an external API for the language, which downloads and analyses the source itself
+type ExternalLanguageDetector struct {
+	Endpoint   string
+	Token      string
+	HTTPClient *http.Client
+}
+
+type languageDetectionRequest struct {
+	RequestID string `json:"request_id"`
+	URL       string `json:"url"`
+}
+
+func (d *ExternalLanguageDetector) DetectLanguage(ctx context.Context, requestID, sourceURL string) (LanguageDetection, error) {
+	body, err := json.Marshal(languageDetectionRequest{RequestID: requestID, URL: sourceURL})
+	if err != nil {
+		return LanguageDetection{}, fmt.Errorf("error marshalling language detection request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(log.WithRequestID(ctx, requestID), http.MethodPost, d.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return LanguageDetection{}, fmt.Errorf("error creating language detection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.Token)
+	}
+
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return LanguageDetection{}, fmt.Errorf("error calling language detector: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return LanguageDetection{}, fmt.Errorf("language detector returned status %d: %s", resp.StatusCode, respBody)
+	}
+
+	var detection LanguageDetection
+	if err := json.NewDecoder(resp.Body).Decode(&detection); err != nil {
+		return LanguageDetection{}, fmt.Errorf("error parsing language detection response: %w", err)
+	}
+	if detection.Language == "" {
+		return LanguageDetection{}, fmt.Errorf("language detector returned no language")
+	}
+	return detection, nil
+}
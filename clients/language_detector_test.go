@@ -0,0 +1,56 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalLanguageDetector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/detect", r.URL.Path)
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var req languageDetectionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		switch req.URL {
+		case "https://example.com/speech.mp4":
+			_, _ = w.Write([]byte(`{"language": "pt-BR", "confidence": 0.87}`))
+		case "https://example.com/music.mp4":
+			_, _ = w.Write([]byte(`{"language": ""}`))
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte("no audio"))
+		}
+	}))
+	defer server.Close()
+
+	detector, err := ParseLanguageDetectorURL(strings.Replace(server.URL, "http://", "http://secret@", 1) + "/v1/detect")
+	require.NoError(t, err)
+
+	detection, err := detector.DetectLanguage(context.Background(), "req", "https://example.com/speech.mp4")
+	require.NoError(t, err)
+	require.Equal(t, LanguageDetection{Language: "pt-BR", Confidence: 0.87}, detection)
+
+	_, err = detector.DetectLanguage(context.Background(), "req", "https://example.com/music.mp4")
+	require.EqualError(t, err, "language detector returned no language")
+
+	_, err = detector.DetectLanguage(context.Background(), "req", "https://example.com/silent.mp4")
+	require.EqualError(t, err, "language detector returned status 422: no audio")
+}
+
+func TestParseLanguageDetectorURL(t *testing.T) {
+	d, err := ParseLanguageDetectorURL("https://token@detector.example.com/v1/detect")
+	require.NoError(t, err)
+	require.Equal(t, "https://detector.example.com/v1/detect", d.(*ExternalLanguageDetector).Endpoint)
+	require.Equal(t, "token", d.(*ExternalLanguageDetector).Token)
+
+	_, err = ParseLanguageDetectorURL("ftp://detector.example.com")
+	require.Error(t, err)
+	_, err = ParseLanguageDetectorURL("https:///v1/detect")
+	require.Error(t, err)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/grafov/m3u8"
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
 	"github.com/livepeer/catalyst-api/video"
 )
 
@@ -45,6 +46,15 @@ func DownloadRenditionManifest(requestID, sourceManifestOSURL string) (m3u8.Medi
 	return convertToMediaPlaylist(playlist, playlistType)
 }
 
+// downloadRenditionManifestCached is DownloadRenditionManifest going through the node's artifact cache
+func downloadRenditionManifestCached(requestID, sourceManifestOSURL string) (m3u8.MediaPlaylist, error) {
+	playlist, playlistType, _, err := downloadManifestWith(requestID, sourceManifestOSURL, GetFileCached)
+	if err != nil {
+		return m3u8.MediaPlaylist{}, err
+	}
+	return convertToMediaPlaylist(playlist, playlistType)
+}
+
 // RecordingBackupCheck checks whether manifests and segments are available on the primary or
 // the backup store and returns a URL to new manifest with absolute segment URLs pointing to either primary or
 // backup locations depending on where the segments are available.
@@ -67,7 +77,13 @@ func RecordingBackupCheck(requestID string, primaryManifestURL, osTransferURL *u
 
 	// Check whether segments are available from primary or backup storage
 	dStorage := NewDStorageDownload()
+	// The segments of a playlist with byte ranges share their files, which only need checking once
+	checked := map[string]string{}
 	for _, segment := range mediaPlaylist.GetAllSegments() {
+		if actualSegURL, ok := checked[segment.URI]; ok {
+			segment.URI = actualSegURL
+			continue
+		}
 		segURL, err := ManifestURLToSegmentURL(primaryManifestURL.String(), segment.URI)
 		if err != nil {
 			return nil, fmt.Errorf("error getting segment URL: %w", err)
@@ -89,6 +105,7 @@ func RecordingBackupCheck(requestID string, primaryManifestURL, osTransferURL *u
 			// pointing to wherever they are found, primary or backup
 			newPlaylistRequired = true
 		}
+		checked[segment.URI] = actualSegURL
 		segment.URI = actualSegURL
 	}
 
@@ -97,23 +114,58 @@ func RecordingBackupCheck(requestID string, primaryManifestURL, osTransferURL *u
 	}
 
 	// write the updated manifest to storage and update the manifestURL variable
-	outputStorageURL := osTransferURL.JoinPath("input.m3u8")
+	return uploadInputPlaylist(osTransferURL.JoinPath("input.m3u8"), mediaPlaylist.String())
+}
+
+// SnapshotLiveManifest makes a bounded source of an HLS playlist that is still being written, i.e. an event or live
+// playlist without an EXT-X-ENDLIST, so that a VOD can be made of a stream while it's still running. The segments the
+// playlist lists so far are written to a closed playlist in the transfer location, whose URL is returned with true.
+// Playlists that are already closed are returned as is, with false.
+func SnapshotLiveManifest(requestID string, manifestURL, osTransferURL *url.URL) (*url.URL, bool, error) {
+	playlist, playlistType, _, err := downloadManifest(requestID, manifestURL.String())
+	if err != nil {
+		return nil, false, fmt.Errorf("error downloading manifest: %w", err)
+	}
+	mediaPlaylist, err := convertToMediaPlaylist(playlist, playlistType)
+	if err != nil {
+		return nil, false, err
+	}
+	if mediaPlaylist.Closed {
+		return manifestURL, false, nil
+	}
+
+	segments := mediaPlaylist.GetAllSegments()
+	if len(segments) == 0 {
+		return nil, false, fmt.Errorf("live playlist has no segments yet")
+	}
+	// The snapshot is stored elsewhere, so its segments are referenced by their absolute URLs
+	for _, segment := range segments {
+		segURL, err := ManifestURLToSegmentURL(manifestURL.String(), segment.URI)
+		if err != nil {
+			return nil, false, fmt.Errorf("error getting segment URL: %w", err)
+		}
+		segment.URI = segURL.String()
+	}
+	mediaPlaylist.MediaType = m3u8.VOD
+	mediaPlaylist.Closed = true
+	log.Log(requestID, "snapshotting live source playlist", "segments", len(segments), "media_sequence", mediaPlaylist.SeqNo)
+
+	snapshotURL := osTransferURL.JoinPath("snapshot.m3u8")
 	err = backoff.Retry(func() error {
-		return UploadToOSURL(outputStorageURL.String(), "", strings.NewReader(mediaPlaylist.String()), ManifestUploadTimeout)
+		return UploadToOSURL(snapshotURL.String(), "", strings.NewReader(mediaPlaylist.String()), ManifestUploadTimeout)
 	}, UploadRetryBackoff())
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload rendition playlist: %w", err)
+		return nil, false, fmt.Errorf("failed to upload live playlist snapshot: %w", err)
 	}
-	manifestURL, err := SignURL(outputStorageURL)
+	signedURL, err := SignURL(snapshotURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign manifest url: %w", err)
+		return nil, false, fmt.Errorf("failed to sign live playlist snapshot url: %w", err)
 	}
-
-	newURL, err := url.Parse(manifestURL)
+	newURL, err := url.Parse(signedURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse new manifest URL: %w", err)
+		return nil, false, fmt.Errorf("failed to parse live playlist snapshot URL: %w", err)
 	}
-	return newURL, nil
+	return newURL, true, nil
 }
 
 func convertToMediaPlaylist(playlist m3u8.Playlist, playlistType m3u8.ListType) (m3u8.MediaPlaylist, error) {
@@ -127,9 +179,23 @@ func convertToMediaPlaylist(playlist m3u8.Playlist, playlistType m3u8.ListType)
 	if !ok || mediaPlaylist == nil {
 		return m3u8.MediaPlaylist{}, fmt.Errorf("failed to parse playlist as MediaPlaylist")
 	}
+	resolveByteRangeOffsets(mediaPlaylist)
 	return *mediaPlaylist, nil
 }
 
+// resolveByteRangeOffsets fills in the offsets left out of EXT-X-BYTERANGE tags, which the parser reads as 0, so that
+// the segments can be downloaded and the playlist written back with explicit offsets. A sub-range without an offset
+// starts right after the previous segment's, which is of the same file.
+func resolveByteRangeOffsets(playlist *m3u8.MediaPlaylist) {
+	var prev *m3u8.MediaSegment
+	for _, segment := range playlist.GetAllSegments() {
+		if segment.Limit > 0 && segment.Offset == 0 && prev != nil && prev.Limit > 0 && prev.URI == segment.URI {
+			segment.Offset = prev.Offset + prev.Limit
+		}
+		prev = segment
+	}
+}
+
 func downloadManifestWithBackup(requestID, sourceManifestOSURL string) (string, m3u8.Playlist, m3u8.ListType, error) {
 	var playlist, playlistBackup m3u8.Playlist
 	var playlistType, playlistTypeBackup m3u8.ListType
@@ -176,10 +242,14 @@ func downloadManifestWithBackup(requestID, sourceManifestOSURL string) (string,
 }
 
 func downloadManifest(requestID, sourceManifestOSURL string) (playlist m3u8.Playlist, playlistType m3u8.ListType, size int, err error) {
+	return downloadManifestWith(requestID, sourceManifestOSURL, GetFile)
+}
+
+func downloadManifestWith(requestID, sourceManifestOSURL string, getFile func(ctx context.Context, requestID, url string, dStorage *DStorageDownload) (io.ReadCloser, error)) (playlist m3u8.Playlist, playlistType m3u8.ListType, size int, err error) {
 	dStorage := NewDStorageDownload()
 	start := time.Now()
 	err = backoff.Retry(func() error {
-		rc, err := GetFile(context.Background(), requestID, sourceManifestOSURL, dStorage)
+		rc, err := getFile(context.Background(), requestID, sourceManifestOSURL, dStorage)
 		if err != nil {
 			if time.Since(start) > manifestNotFoundTolerance && errors.IsObjectNotFound(err) {
 				// bail out of the retries earlier for not found errors because it will be quite a common scenario
@@ -210,6 +280,9 @@ func downloadManifest(requestID, sourceManifestOSURL string) (playlist m3u8.Play
 type SourceSegment struct {
 	URL            *url.URL
 	DurationMillis int64
+	// The byte range of the segment within the file at URL, from its EXT-X-BYTERANGE tag. The whole file when the
+	// length is 0.
+	Offset, Length int64
 }
 
 // Loop over each segment in a given manifest and convert it from a relative path to a full ObjectStore-compatible URL
@@ -225,6 +298,8 @@ func GetSourceSegmentURLs(sourceManifestURL string, manifest m3u8.MediaPlaylist)
 			SourceSegment{
 				URL:            u,
 				DurationMillis: int64(segment.Duration * 1000),
+				Offset:         segment.Offset,
+				Length:         segment.Limit,
 			},
 		)
 	}
@@ -233,7 +308,8 @@ func GetSourceSegmentURLs(sourceManifestURL string, manifest m3u8.MediaPlaylist)
 
 // Generate a Master manifest, plus one Rendition manifest for each Profile we're transcoding, then write them to storage
 // Returns the master manifest URL on success
-func GenerateAndUploadManifests(sourceManifest m3u8.MediaPlaylist, targetOSURL string, transcodedStats []*video.RenditionStats, isClip bool) (string, error) {
+// audioLanguage, when known, is the language of the audio muxed into the renditions, tagged with an EXT-X-MEDIA entry
+func GenerateAndUploadManifests(ctx context.Context, sourceManifest m3u8.MediaPlaylist, targetOSURL string, transcodedStats []*video.RenditionStats, isClip bool, cueSegments map[int]bool, audioLanguage string) (string, error) {
 	// Generate the master + rendition output manifests
 	masterPlaylist := m3u8.NewMasterPlaylist()
 
@@ -246,6 +322,21 @@ func GenerateAndUploadManifests(sourceManifest m3u8.MediaPlaylist, targetOSURL s
 		transcodedStats[0], transcodedStats[1] = transcodedStats[1], transcodedStats[0]
 	}
 
+	var audioAlternatives []*m3u8.Alternative
+	var audioGroup string
+	if audioLanguage != "" {
+		// Without a URI, the audio of the rendition is the one in the group
+		audioGroup = "audio"
+		audioAlternatives = []*m3u8.Alternative{{
+			Type:       "AUDIO",
+			GroupId:    audioGroup,
+			Name:       audioLanguage,
+			Language:   audioLanguage,
+			Default:    true,
+			Autoselect: "YES",
+		}}
+	}
+
 	for i, profile := range transcodedStats {
 		// For each profile, add a new entry to the master manifest
 		masterPlaylist.Append(
@@ -254,62 +345,28 @@ func GenerateAndUploadManifests(sourceManifest m3u8.MediaPlaylist, targetOSURL s
 				TargetDuration: sourceManifest.TargetDuration,
 			},
 			m3u8.VariantParams{
-				Name:       fmt.Sprintf("%d-%s", i, profile.Name),
-				Bandwidth:  profile.BitsPerSecond,
-				FrameRate:  float64(profile.FPS),
-				Resolution: fmt.Sprintf("%dx%d", profile.Width, profile.Height),
+				Name:         fmt.Sprintf("%d-%s", i, profile.Name),
+				Bandwidth:    profile.BitsPerSecond,
+				FrameRate:    float64(profile.FPS),
+				Resolution:   fmt.Sprintf("%dx%d", profile.Width, profile.Height),
+				Audio:        audioGroup,
+				Alternatives: audioAlternatives,
 			},
 		)
 
-		// For each profile, create and upload a new rendition manifest
-		renditionPlaylist, err := m3u8.NewMediaPlaylist(sourceManifest.WinSize(), sourceManifest.Count())
-		if err != nil {
-			return "", fmt.Errorf("failed to create rendition manifest for profile %q: %s", profile.Name, err)
-		}
-
-		// Add segments to the manifest
-		for i, sourceSegment := range sourceManifest.Segments {
-			// The segments list is a ring buffer - see https://github.com/grafov/m3u8/issues/140
-			// and so we only know we've hit the end of the list when we find a nil element
-			if sourceSegment == nil {
-				break
-			}
-			err := renditionPlaylist.Append(fmt.Sprintf("%d.ts", i), sourceSegment.Duration, "")
-			if err != nil {
-				return "", fmt.Errorf("failed to append to rendition playlist number %d: %s", i, err)
-			}
-		}
-
-		if isClip {
-			_, totalSegs := video.GetTotalDurationAndSegments(renditionPlaylist)
-			// Only add DISCONTINUITY tag if more than one segment exists in clipped playlist
-			if totalSegs > 1 {
-				renditionPlaylist.Segments[1].Discontinuity = true
-				renditionPlaylist.Segments[totalSegs-1].Discontinuity = true
-			}
-		}
-
-		// Write #EXT-X-ENDLIST
-		renditionPlaylist.Close()
-
-		manifestFilename := "index.m3u8"
-		renditionManifestBaseURL := fmt.Sprintf("%s/%s", targetOSURL, profile.Name)
-		err = backoff.Retry(func() error {
-			return UploadToOSURL(renditionManifestBaseURL, manifestFilename, strings.NewReader(renditionPlaylist.String()), ManifestUploadTimeout)
-		}, UploadRetryBackoff())
-		if err != nil {
-			return "", fmt.Errorf("failed to upload rendition playlist: %s", err)
+		// For each profile, stream a new rendition manifest to storage
+		if err := uploadRenditionManifest(ctx, sourceManifest, targetOSURL, profile, isClip, cueSegments); err != nil {
+			return "", err
 		}
-		// update manifest location
-		transcodedStats[i].ManifestLocation, err = url.JoinPath(renditionManifestBaseURL, manifestFilename)
-		if err != nil {
-			// should not block the ingestion flow or make it fail on error.
-			transcodedStats[i].ManifestLocation = ""
+	}
+	if config.RelativePlaylistURIs {
+		if err := LintRelativePlaylist(masterPlaylist.String()); err != nil {
+			return "", fmt.Errorf("invalid master playlist: %w", err)
 		}
 	}
 	err := backoff.Retry(func() error {
-		return UploadToOSURL(targetOSURL, MasterManifestFilename, strings.NewReader(masterPlaylist.String()), ManifestUploadTimeout)
-	}, UploadRetryBackoff())
+		return UploadToOSURLFields(ctx, targetOSURL, MasterManifestFilename, strings.NewReader(masterPlaylist.String()), ManifestUploadTimeout, nil)
+	}, backoff.WithContext(UploadRetryBackoff(), ctx))
 	if err != nil {
 		return "", fmt.Errorf("failed to upload master playlist: %s", err)
 	}
@@ -322,6 +379,43 @@ func GenerateAndUploadManifests(sourceManifest m3u8.MediaPlaylist, targetOSURL s
 	return res, nil
 }
 
+// UploadRenditionManifests writes the rendition manifests of a partial re-transcode, leaving the master manifest the
+// original job wrote as it is. Returns the master manifest URL.
+func UploadRenditionManifests(ctx context.Context, sourceManifest m3u8.MediaPlaylist, targetOSURL string, transcodedStats []*video.RenditionStats, isClip bool, cueSegments map[int]bool) (string, error) {
+	for _, profile := range transcodedStats {
+		if err := uploadRenditionManifest(ctx, sourceManifest, targetOSURL, profile, isClip, cueSegments); err != nil {
+			return "", err
+		}
+	}
+	res, err := url.JoinPath(targetOSURL, MasterManifestFilename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create URL for master playlist: %s", err)
+	}
+	return res, nil
+}
+
+// uploadRenditionManifest streams the manifest of a rendition to storage and updates its location. Its segment URIs
+// are always relative, so it doesn't need linting for config.RelativePlaylistURIs.
+func uploadRenditionManifest(ctx context.Context, sourceManifest m3u8.MediaPlaylist, targetOSURL string, profile *video.RenditionStats, isClip bool, cueSegments map[int]bool) error {
+	manifestFilename := "index.m3u8"
+	renditionManifestBaseURL := fmt.Sprintf("%s/%s", targetOSURL, profile.Name)
+	err := backoff.Retry(func() error {
+		return uploadStreamed(ctx, renditionManifestBaseURL, manifestFilename, ManifestUploadTimeout, func(w io.Writer) error {
+			return writeRenditionPlaylist(w, sourceManifest, isClip, cueSegments)
+		})
+	}, backoff.WithContext(UploadRetryBackoff(), ctx))
+	if err != nil {
+		return fmt.Errorf("failed to upload rendition playlist: %s", err)
+	}
+	// update manifest location
+	profile.ManifestLocation, err = url.JoinPath(renditionManifestBaseURL, manifestFilename)
+	if err != nil {
+		// should not block the ingestion flow or make it fail on error.
+		profile.ManifestLocation = ""
+	}
+	return nil
+}
+
 func ManifestURLToSegmentURL(manifestURL, segmentFilename string) (*url.URL, error) {
 	base, err := url.Parse(manifestURL)
 	if err != nil {
@@ -350,9 +444,23 @@ func SortTranscodedStats(transcodedStats []*video.RenditionStats) {
 	})
 }
 
-func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnixMillis, endTimeUnixMillis int64) (clippedManifestUrl *url.URL, err error) {
-	// Get the source manifest that will be clipped
-	origManifest, err := DownloadRenditionManifest(requestID, sourceURL)
+// ClipInputManifest cuts the recording at sourceURL between the given times, uploading the clipped manifest and its
+// re-encoded first and last segments to clipTargetUrl. The sources are shared by a batch of clips of the recording,
+// nil to go through the node's artifact cache instead.
+func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnixMillis, endTimeUnixMillis, sessionStartUnixMillis int64, tracks video.TrackSelection, sources *ClipSources) (clippedManifestUrl *url.URL, err error) {
+	// Get the source manifest that will be clipped. Consecutive clips of the same recording reuse the manifest and
+	// segments downloaded to the node's artifact cache.
+	var origManifest m3u8.MediaPlaylist
+	if sources != nil {
+		var playlist m3u8.Playlist
+		var playlistType m3u8.ListType
+		playlist, playlistType, _, err = downloadManifestWith(requestID, sourceURL, sources.GetFile)
+		if err == nil {
+			origManifest, err = convertToMediaPlaylist(playlist, playlistType)
+		}
+	} else {
+		origManifest, err = downloadRenditionManifestCached(requestID, sourceURL)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error clipping: failed to download original manifest: %w", err)
 	}
@@ -365,7 +473,7 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 	}
 
 	// Convert start/end time specified in UNIX time (milliseconds) to seconds wrt the first segment
-	startTime, endTime, err := video.ConvertUnixMillisToSeconds(requestID, origManifest.Segments[0], startTimeUnixMillis, endTimeUnixMillis)
+	startTime, endTime, err := clipOffsets(requestID, origManifest, sourceSegmentURLs, startTimeUnixMillis, endTimeUnixMillis, sessionStartUnixMillis, probeSegmentStart)
 	if err != nil {
 		return nil, fmt.Errorf("error clipping: failed to get start/end time offsets in seconds: %w", err)
 	}
@@ -391,23 +499,36 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 		return nil, fmt.Errorf("error clipping: failed to create temp clipping storage dir: %w", err)
 	}
 	defer os.RemoveAll(clipStorageDir)
+	scratchKey, err := video.NewScratchKey()
+	if err != nil {
+		return nil, fmt.Errorf("error clipping: %w", err)
+	}
 
 	// Download start/end segments and clip
 	for i, v := range segsToClip {
 		// Create temp local file to store the segments:
 		clipSegmentFileName := filepath.Join(clipStorageDir, requestID+"_"+strconv.FormatUint(v.SeqId, 10)+".ts")
 		defer os.Remove(clipSegmentFileName)
-		clipSegmentFile, err := os.Create(clipSegmentFileName)
+		clipSegmentFile, err := scratchKey.Create(clipSegmentFileName)
 		if err != nil {
 			return nil, err
 		}
 		defer clipSegmentFile.Close()
 
 		// Download the segment from OS and write to the temp local file
-		segmentURL := sourceSegmentURLs[v.SeqId].URL
+		sourceSegment := sourceSegmentURLs[v.SeqId]
 		dStorage := NewDStorageDownload()
 		err = backoff.Retry(func() error {
-			rc, err := GetFile(context.Background(), requestID, segmentURL.String(), dStorage)
+			var rc io.ReadCloser
+			var err error
+			if sources != nil {
+				rc, err = sources.GetFileRange(context.Background(), requestID, sourceSegment.URL.String(), dStorage, sourceSegment.Offset, sourceSegment.Length)
+			} else if sourceSegment.Length > 0 {
+				// Only caching whole files, a byte range is of a file holding the whole recording
+				rc, err = GetFileRange(context.Background(), requestID, sourceSegment.URL.String(), dStorage, sourceSegment.Offset, sourceSegment.Length)
+			} else {
+				rc, err = GetFileCached(context.Background(), requestID, sourceSegment.URL.String(), dStorage)
+			}
 			if err != nil {
 				return fmt.Errorf("error clipping: failed to download segment %d: %w", v.SeqId, err)
 			}
@@ -429,7 +550,7 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 		if len(segs) == 1 {
 			// If start/end times fall within same segment, then clip just that single segment
 			duration := endTime - startTime
-			err = video.ClipSegment(requestID, clipSegmentFileName, clippedSegmentFileName, clipsegs[0].ClipOffsetSecs, clipsegs[0].ClipOffsetSecs+duration)
+			err = video.ClipSegment(requestID, clipSegmentFileName, clippedSegmentFileName, clipsegs[0].ClipOffsetSecs, clipsegs[0].ClipOffsetSecs+duration, tracks, scratchKey)
 			if err != nil {
 				return nil, fmt.Errorf("error clipping: failed to clip segment %d: %w", v.SeqId, err)
 			}
@@ -437,9 +558,9 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 			// If start/end times fall within different segments, then clip segment from start-time to end of segment
 			// or clip from beginning of segment to end-time.
 			if i == 0 {
-				err = video.ClipSegment(requestID, clipSegmentFileName, clippedSegmentFileName, clipsegs[0].ClipOffsetSecs, -1)
+				err = video.ClipSegment(requestID, clipSegmentFileName, clippedSegmentFileName, clipsegs[0].ClipOffsetSecs, -1, tracks, scratchKey)
 			} else {
-				err = video.ClipSegment(requestID, clipSegmentFileName, clippedSegmentFileName, -1, clipsegs[1].ClipOffsetSecs)
+				err = video.ClipSegment(requestID, clipSegmentFileName, clippedSegmentFileName, -1, clipsegs[1].ClipOffsetSecs, tracks, scratchKey)
 			}
 			if err != nil {
 				return nil, fmt.Errorf("error clipping: failed to clip segment %d: %w", v.SeqId, err)
@@ -447,7 +568,7 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 		}
 
 		// Upload clipped segment to OS
-		clippedSegmentFile, err := os.Open(clippedSegmentFileName)
+		clippedSegmentFile, err := scratchKey.Open(clippedSegmentFileName)
 		if err != nil {
 			return nil, fmt.Errorf("error clipping: failed to open clipped segment %d: %w", v.SeqId, err)
 		}
@@ -460,8 +581,16 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 		}
 
 		// Get duration of clipped segment(s) to use in the clipped manifest
-		p := video.Probe{}
-		clipSegProbe, err := p.ProbeFile(requestID, clippedSegmentFileName)
+		// ffprobe can't tell the duration of a piped segment, so an encrypted one is probed from the upload instead
+		clippedSegmentProbeURL := clippedSegmentFileName
+		if scratchKey != nil {
+			clippedSegmentProbeURL, err = clippedSegmentUploadURL(clipTargetUrl, clippedSegmentOSFilename)
+			if err != nil {
+				return nil, fmt.Errorf("error clipping: failed to sign URL of clipped segment %d: %w", v.SeqId, err)
+			}
+		}
+		p := video.Probe{NoCache: true}
+		clipSegProbe, err := p.ProbeFile(requestID, clippedSegmentProbeURL)
 		if err != nil {
 			return nil, fmt.Errorf("error clipping: failed to probe file: %w", err)
 		}
@@ -473,6 +602,7 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 		// so the start/end segments in original segs slice are directly modified
 		v.Duration = vidTrack.DurationSec
 		v.URI = clippedSegmentOSFilename
+		v.Limit, v.Offset = 0, 0
 	}
 
 	// Generate the new clipped manifest
@@ -507,6 +637,58 @@ func ClipInputManifest(requestID, sourceURL, clipTargetUrl string, startTimeUnix
 	return source.JoinPath("..", clipPlaybackRelPath, ClipManifestFilename), nil
 }
 
+func clippedSegmentUploadURL(clipTargetUrl, clippedSegmentOSFilename string) (string, error) {
+	u, err := url.Parse(clipTargetUrl)
+	if err != nil {
+		return "", err
+	}
+	return SignURL(u.JoinPath(clippedSegmentOSFilename))
+}
+
+// clipOffsets converts the clip start/end times to offsets in seconds from the start of the manifest. The manifest
+// start is the PROGRAM-DATE-TIME of the first segment, but older recordings don't have it. For those the segment
+// timestamps, which count from the start of the recording session, are mapped to wall-clock time with the session
+// start time.
+func clipOffsets(requestID string, manifest m3u8.MediaPlaylist, segmentURLs []SourceSegment, startTimeUnixMillis, endTimeUnixMillis, sessionStartUnixMillis int64, segmentStart func(requestID string, segmentURL *url.URL) (float64, error)) (float64, float64, error) {
+	if !manifest.Segments[0].ProgramDateTime.IsZero() {
+		return video.ConvertUnixMillisToSeconds(requestID, manifest.Segments[0], startTimeUnixMillis, endTimeUnixMillis)
+	}
+	if sessionStartUnixMillis <= 0 {
+		return 0, 0, fmt.Errorf("error clipping: PROGRAM-DATE-TIME of first segment is not set and the session start time is unknown")
+	}
+
+	segments := manifest.GetAllSegments()
+	if len(segments) != len(segmentURLs) {
+		return 0, 0, fmt.Errorf("error clipping: found %d segment URLs for %d segments", len(segmentURLs), len(segments))
+	}
+	probedSegmentStart := func(i int) (float64, error) {
+		return segmentStart(requestID, segmentURLs[i].URL)
+	}
+	startTime, err := video.MediaTimeToManifestOffset(segments, float64(startTimeUnixMillis-sessionStartUnixMillis)/1000, probedSegmentStart)
+	if err != nil {
+		return 0, 0, err
+	}
+	endTime, err := video.MediaTimeToManifestOffset(segments, float64(endTimeUnixMillis-sessionStartUnixMillis)/1000, probedSegmentStart)
+	if err != nil {
+		return 0, 0, err
+	}
+	log.Log(requestID, "clipping timestamps from session start", "session-start-unix-milliseconds", sessionStartUnixMillis, "start-offset-seconds", startTime, "end-offset-seconds", endTime)
+	return startTime, endTime, nil
+}
+
+// probeSegmentStart returns the start timestamp of the segment's video track in seconds
+func probeSegmentStart(requestID string, segmentURL *url.URL) (float64, error) {
+	iv, err := video.Probe{IgnoreErrMessages: IgnoreProbeErrs}.ProbeFile(requestID, segmentURL.String())
+	if err != nil {
+		return 0, err
+	}
+	track, err := iv.GetTrack(video.TrackTypeVideo)
+	if err != nil {
+		return 0, err
+	}
+	return track.StartTimeSec, nil
+}
+
 func CreateClippedPlaylist(origManifest m3u8.MediaPlaylist, segs []*m3u8.MediaSegment) (*m3u8.MediaPlaylist, error) {
 	totalSegs := len(segs)
 	clippedPlaylist, err := m3u8.NewMediaPlaylist(origManifest.WinSize(), uint(totalSegs))
@@ -533,6 +715,11 @@ func CreateClippedPlaylist(origManifest m3u8.MediaPlaylist, segs []*m3u8.MediaSe
 			s.Discontinuity = true
 		}
 
+		// The segments in between keep the byte ranges of the source, which need version 4
+		if s.Limit > 0 && clippedPlaylist.Version() < 4 {
+			clippedPlaylist.SetVersion(4)
+		}
+
 		// Add segment to clipped manifest
 		err := clippedPlaylist.AppendSegment(s)
 		if err != nil {
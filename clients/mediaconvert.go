@@ -14,6 +14,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/mediaconvert"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/log"
 	"github.com/livepeer/catalyst-api/video"
 	"golang.org/x/sync/errgroup"
@@ -40,6 +42,21 @@ var errCodesAcceleration = []int64{
 }
 var ErrJobAcceleration = errors.New("job should not have acceleration")
 
+// mediaConvertJobError classifies the error of a failed job from its code. The 10xx codes are errors about the input,
+// which resubmitting the job can't fix.
+func mediaConvertJobError(code int64, msg string) error {
+	err := fmt.Errorf("job failed: %s", msg)
+	switch {
+	case code == 1401 || code == 1432 || code == 1433:
+		return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassAccessDenied, err))
+	case code == 1404:
+		return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassNotFound, err))
+	case code >= 1010 && code < 1100:
+		return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassUnsupportedInput, err))
+	}
+	return catErrs.WithClass(catErrs.ClassTranscode, err)
+}
+
 type ByteAccumulatorWriter struct {
 	count int64
 }
@@ -59,6 +76,9 @@ type MediaConvertOptions struct {
 	// path) and the OS URL will be created internally from it using the same
 	// region and credentials above.
 	S3TransferBucket *url.URL
+	// SQS queue that an EventBridge rule sends the MediaConvert job state changes to. Optional, the jobs are only
+	// polled without it.
+	StatusQueueURL string
 }
 
 type AWSMediaConvertClient interface {
@@ -73,6 +93,7 @@ type MediaConvert struct {
 	client                                AWSMediaConvertClient
 	s3                                    S3
 	probe                                 video.Prober
+	events                                *mediaConvertEvents
 }
 
 func NewMediaConvertClient(opts MediaConvertOptions) (TranscodeProvider, error) {
@@ -102,6 +123,12 @@ func NewMediaConvertClient(opts MediaConvertOptions) (TranscodeProvider, error)
 	client := mediaconvert.New(sess)
 	s3Client := &S3Client{s3.New(s3Sess)}
 	probe := video.Probe{}
+	var events *mediaConvertEvents
+	if opts.StatusQueueURL != "" {
+		// The queue is on the regular AWS endpoint of the region, like the S3 transfer bucket
+		events = newMediaConvertEvents(sqs.New(s3Sess), opts.StatusQueueURL)
+		go events.Run(context.Background())
+	}
 	return &MediaConvert{
 		role:                opts.Role,
 		s3TransferBucket:    opts.S3TransferBucket,
@@ -109,6 +136,7 @@ func NewMediaConvertClient(opts MediaConvertOptions) (TranscodeProvider, error)
 		client:              client,
 		s3:                  s3Client,
 		probe:               probe,
+		events:              events,
 	}, nil
 }
 
@@ -121,7 +149,7 @@ func NewMediaConvertClient(opts MediaConvertOptions) (TranscodeProvider, error)
 func (mc *MediaConvert) Transcode(ctx context.Context, args TranscodeJobArgs) (outs []video.OutputVideo, err error) {
 	inputDuration := args.InputFileInfo.Duration
 	if inputDuration <= 0 || inputDuration > maxMediaconvertDuration.Seconds() {
-		return nil, fmt.Errorf("input too long for mediaconvert: %v", inputDuration)
+		return nil, catErrs.WithClass(catErrs.ClassUnsupportedInput, fmt.Errorf("input too long for mediaconvert: %v", inputDuration))
 	}
 
 	var (
@@ -132,6 +160,7 @@ func (mc *MediaConvert) Transcode(ctx context.Context, args TranscodeJobArgs) (o
 
 	videoTrack, err := mcArgs.InputFileInfo.GetTrack(video.TrackTypeVideo)
 	hasVideoTrack := err == nil
+	deinterlace := hasVideoTrack && videoTrack.Interlaced()
 
 	if hasVideoTrack {
 		if len(mcArgs.Profiles) == 0 {
@@ -142,6 +171,7 @@ func (mc *MediaConvert) Transcode(ctx context.Context, args TranscodeJobArgs) (o
 		} else if len(mcArgs.Profiles) == 1 && mcArgs.Profiles[0].Bitrate != 0 && mcArgs.Profiles[0].Height == 0 && mcArgs.Profiles[0].Width == 0 {
 			mcArgs.Profiles[0].Height = videoTrack.Height
 		}
+		mcArgs.Profiles = video.NameRenditions(mcArgs.Profiles, mcArgs.RenditionNaming)
 	}
 
 	// If we don't have a video track then ignore any profiles that have been passed in
@@ -166,9 +196,9 @@ func (mc *MediaConvert) Transcode(ctx context.Context, args TranscodeJobArgs) (o
 	}
 
 	// Do the actual transcode
-	err = mc.coreAwsTranscode(ctx, mcArgs, true)
+	err = mc.coreAwsTranscode(ctx, mcArgs, true, deinterlace)
 	if err == ErrJobAcceleration {
-		err = mc.coreAwsTranscode(ctx, mcArgs, false)
+		err = mc.coreAwsTranscode(ctx, mcArgs, false, deinterlace)
 	}
 	if err != nil {
 		return nil, err
@@ -196,7 +226,8 @@ func (mc *MediaConvert) Transcode(ctx context.Context, args TranscodeJobArgs) (o
 	}
 
 	outputVideo := video.OutputVideo{
-		Type: "object_store",
+		Type:         "object_store",
+		Deinterlaced: deinterlace,
 	}
 	if hlsTarget != nil {
 		hlsPlaybackDirURL, err := url.Parse(hlsPlaybackBaseURL)
@@ -254,15 +285,15 @@ func (mc *MediaConvert) outputVideoFiles(mcArgs TranscodeJobArgs, ourOutputBaseD
 
 // This is the function that does the core AWS workflow for transcoding a file.
 // It expects args to be directly compatible with AWS (i.e. S3-only files).
-func (mc *MediaConvert) coreAwsTranscode(ctx context.Context, args TranscodeJobArgs, accelerated bool) (err error) {
-	log.Log(args.RequestID, "Creating AWS MediaConvert job", "input", args.InputFile, "output", args.HLSOutputLocation, "accelerated", accelerated)
+func (mc *MediaConvert) coreAwsTranscode(ctx context.Context, args TranscodeJobArgs, accelerated, deinterlace bool) (err error) {
+	log.Log(args.RequestID, "Creating AWS MediaConvert job", "input", args.InputFile, "output", args.HLSOutputLocation, "accelerated", accelerated, "deinterlace", deinterlace)
 
 	var mp4OutputLocation string
 	if args.GenerateMP4 {
 		mp4OutputLocation = toStr(args.MP4OutputLocation)
 	}
 
-	payload := createJobPayload(args.InputFile.String(), toStr(args.HLSOutputLocation), mp4OutputLocation, mc.role, accelerated, args.Profiles, args.SegmentSizeSecs)
+	payload := createJobPayload(args.InputFile.String(), toStr(args.HLSOutputLocation), mp4OutputLocation, mc.role, accelerated, deinterlace, args.Profiles, args.SegmentSizeSecs)
 	job, err := mc.client.CreateJob(payload)
 	if err != nil {
 		return fmt.Errorf("error creating mediaconvert job: %w", err)
@@ -271,23 +302,33 @@ func (mc *MediaConvert) coreAwsTranscode(ctx context.Context, args TranscodeJobA
 	log.AddContext(args.RequestID, "mediaconvert_job_id", aws.StringValue(jobID))
 	log.Log(args.RequestID, "Created MediaConvert job")
 
-	// poll the job until completion or error
-	ticker := time.NewTicker(pollDelay)
-	defer ticker.Stop()
+	// poll the job until completion or error, or until its state changes when they're pushed to us
+	stateChanged, stopWaiting := mc.events.wait(aws.StringValue(jobID))
+	defer stopWaiting()
+	createdAt := time.Now()
+	var minDelay time.Duration
+	var progress float64
+	timer := time.NewTimer(mediaConvertPollDelay(0, 0, stateChanged != nil))
+	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			// don't cancel the job let it finish on AWS
 			return ctx.Err()
-		case <-ticker.C:
+		case <-timer.C:
 			// continue below
+		case <-stateChanged:
+			if !timer.Stop() {
+				<-timer.C
+			}
 		}
 		job, err := mc.client.GetJob(&mediaconvert.GetJobInput{Id: jobID})
 		if err != nil {
-			// If we got rate limited then try again, but start polling on a longer interval
+			// If we got rate limited then try again, but poll on a longer interval from now on
 			if _, ok := err.(*mediaconvert.TooManyRequestsException); ok {
-				log.Log(args.RequestID, "Received mediaconvert TooManyRequestsException. Switching polling to longer interval")
-				ticker.Reset(rateLimitedPollDelay)
+				minDelay = min(max(2*minDelay, rateLimitedPollDelay), 4*rateLimitedPollDelay)
+				log.Log(args.RequestID, "Received mediaconvert TooManyRequestsException. Switching polling to longer interval", "min_delay", minDelay)
+				timer.Reset(minDelay)
 				continue
 			}
 
@@ -298,7 +339,7 @@ func (mc *MediaConvert) coreAwsTranscode(ctx context.Context, args TranscodeJobA
 		status := aws.StringValue(job.Job.Status)
 		switch status {
 		case mediaconvert.JobStatusSubmitted, mediaconvert.JobStatusProgressing:
-			progress := float64(aws.Int64Value(job.Job.JobPercentComplete)) / 100
+			progress = float64(aws.Int64Value(job.Job.JobPercentComplete)) / 100
 			log.Log(args.RequestID, "Got mediaconvert job progress", "progress", progress, "status")
 
 			// cancel job if taking too long
@@ -324,15 +365,16 @@ func (mc *MediaConvert) coreAwsTranscode(ctx context.Context, args TranscodeJobA
 			if contains(code, errCodesAcceleration) {
 				return ErrJobAcceleration
 			}
-			return fmt.Errorf("job failed: %s", errMsg)
+			return mediaConvertJobError(code, errMsg)
 		case mediaconvert.JobStatusCanceled:
 			log.Log(args.RequestID, "Mediaconvert job unexpectedly canceled")
 			return errors.New("job unexpectedly canceled")
 		}
+		timer.Reset(max(mediaConvertPollDelay(progress, time.Since(createdAt), stateChanged != nil), minDelay))
 	}
 }
 
-func createJobPayload(inputFile, hlsOutputFile, mp4OutputFile, role string, accelerated bool, profiles []video.EncodedProfile, segmentSizeSecs int64) *mediaconvert.CreateJobInput {
+func createJobPayload(inputFile, hlsOutputFile, mp4OutputFile, role string, accelerated, deinterlace bool, profiles []video.EncodedProfile, segmentSizeSecs int64) *mediaconvert.CreateJobInput {
 	var acceleration *mediaconvert.AccelerationSettings
 	if accelerated {
 		acceleration = &mediaconvert.AccelerationSettings{
@@ -359,7 +401,7 @@ func createJobPayload(inputFile, hlsOutputFile, mp4OutputFile, role string, acce
 					},
 				},
 			},
-			OutputGroups: outputGroups(hlsOutputFile, mp4OutputFile, profiles, segmentSizeSecs),
+			OutputGroups: outputGroups(hlsOutputFile, mp4OutputFile, profiles, segmentSizeSecs, deinterlace),
 			TimecodeConfig: &mediaconvert.TimecodeConfig{
 				Source: aws.String("ZEROBASED"),
 			},
@@ -369,7 +411,7 @@ func createJobPayload(inputFile, hlsOutputFile, mp4OutputFile, role string, acce
 	}
 }
 
-func outputGroups(hlsOutputFile, mp4OutputFile string, profiles []video.EncodedProfile, segmentSizeSecs int64) []*mediaconvert.OutputGroup {
+func outputGroups(hlsOutputFile, mp4OutputFile string, profiles []video.EncodedProfile, segmentSizeSecs int64, deinterlace bool) []*mediaconvert.OutputGroup {
 	var groups []*mediaconvert.OutputGroup
 	if hlsOutputFile != "" {
 		groups = append(groups, &mediaconvert.OutputGroup{
@@ -382,7 +424,7 @@ func outputGroups(hlsOutputFile, mp4OutputFile string, profiles []video.EncodedP
 				},
 				Type: aws.String("HLS_GROUP_SETTINGS"),
 			},
-			Outputs:    outputs("M3U8", profiles),
+			Outputs:    outputs("M3U8", profiles, deinterlace),
 			CustomName: aws.String("hls"),
 		})
 	}
@@ -398,21 +440,21 @@ func outputGroups(hlsOutputFile, mp4OutputFile string, profiles []video.EncodedP
 				},
 				Type: aws.String("FILE_GROUP_SETTINGS"),
 			},
-			Outputs:    outputs("MP4", profiles),
+			Outputs:    outputs("MP4", profiles, deinterlace),
 			CustomName: aws.String("mp4"),
 		})
 	}
 	return groups
 }
 
-func outputs(container string, profiles []video.EncodedProfile) []*mediaconvert.Output {
+func outputs(container string, profiles []video.EncodedProfile, deinterlace bool) []*mediaconvert.Output {
 	// If we don't have any video profiles, it means we're in audio-only mode
 	if len(profiles) == 0 {
 		return audioOnlyOutputs(container, "audioonly")
 	} else {
 		outs := make([]*mediaconvert.Output, 0, len(profiles))
 		for _, profile := range profiles {
-			outs = append(outs, output(container, profile.Name, profile.Height, profile.Bitrate))
+			outs = append(outs, output(container, profile.Name, profile.Height, profile.Bitrate, deinterlace))
 		}
 		return outs
 	}
@@ -441,7 +483,17 @@ func audioOnlyOutputs(container, name string) []*mediaconvert.Output {
 	}
 }
 
-func output(container, name string, height, maxBitrate int64) *mediaconvert.Output {
+func output(container, name string, height, maxBitrate int64, deinterlace bool) *mediaconvert.Output {
+	var preprocessors *mediaconvert.VideoPreprocessor
+	if deinterlace {
+		preprocessors = &mediaconvert.VideoPreprocessor{
+			Deinterlacer: &mediaconvert.Deinterlacer{
+				Algorithm: aws.String(mediaconvert.DeinterlaceAlgorithmInterpolate),
+				Control:   aws.String(mediaconvert.DeinterlacerControlNormal),
+				Mode:      aws.String(mediaconvert.DeinterlacerModeDeinterlace),
+			},
+		}
+	}
 	return &mediaconvert.Output{
 		VideoDescription: &mediaconvert.VideoDescription{
 			Height: aws.Int64(height),
@@ -454,7 +506,9 @@ func output(container, name string, height, maxBitrate int64) *mediaconvert.Outp
 					SceneChangeDetect:  aws.String("TRANSITION_DETECTION"),
 					QualityTuningLevel: aws.String("SINGLE_PASS"),
 					FramerateControl:   aws.String("INITIALIZE_FROM_SOURCE"),
-				}}},
+				}},
+			VideoPreprocessors: preprocessors,
+		},
 		AudioDescriptions: []*mediaconvert.AudioDescription{
 			{
 				CodecSettings: &mediaconvert.AudioCodecSettings{
@@ -0,0 +1,146 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// How long to wait before receiving from the queue again after an error
+const mediaConvertEventsRetryDelay = 10 * time.Second
+
+// How many times an event of a job no node waits for, e.g. one that finished already, is received before it's deleted
+const mediaConvertEventMaxReceives = 5
+
+type SQSClient interface {
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
+}
+
+// mediaConvertEvent is the part we use of the "MediaConvert Job State Change" events EventBridge sends to the queue
+type mediaConvertEvent struct {
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		JobID  string `json:"jobId"`
+		Status string `json:"status"`
+	} `json:"detail"`
+}
+
+// mediaConvertEvents receives the state changes of the MediaConvert jobs, routed by an EventBridge rule to an SQS
+// queue, and wakes up the jobs waiting for them so that they don't have to poll GetJob as often.
+//
+// Events of jobs this node doesn't wait for are left in the queue, where they'll be received again by the node that
+// created the job once their visibility timeout expires. A queue per node avoids the delay. The events received
+// mediaConvertEventMaxReceives times without any node waiting for them are deleted, as are the invalid ones, so that
+// they don't circulate until the retention period of the queue; the jobs fall back to polling GetJob.
+type mediaConvertEvents struct {
+	sqs      SQSClient
+	queueURL string
+
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+func newMediaConvertEvents(sqs SQSClient, queueURL string) *mediaConvertEvents {
+	return &mediaConvertEvents{sqs: sqs, queueURL: queueURL, waiting: map[string]chan struct{}{}}
+}
+
+// wait returns a channel that receives whenever the job's state changes, and a func to stop waiting. A nil channel,
+// which never receives, is returned without a queue.
+func (e *mediaConvertEvents) wait(jobID string) (<-chan struct{}, func()) {
+	if e == nil {
+		return nil, func() {}
+	}
+	ch := make(chan struct{}, 1)
+	e.mu.Lock()
+	e.waiting[jobID] = ch
+	e.mu.Unlock()
+	return ch, func() {
+		e.mu.Lock()
+		delete(e.waiting, jobID)
+		e.mu.Unlock()
+	}
+}
+
+// Run receives the events until the context is cancelled
+func (e *mediaConvertEvents) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := e.receive(ctx); err != nil && ctx.Err() == nil {
+			log.LogNoRequestID("failed to receive MediaConvert events", "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(mediaConvertEventsRetryDelay):
+			}
+		}
+	}
+}
+
+func (e *mediaConvertEvents) receive(ctx context.Context) error {
+	out, err := e.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(e.queueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+		AttributeNames:      aws.StringSlice([]string{sqs.MessageSystemAttributeNameApproximateReceiveCount}),
+	})
+	if err != nil {
+		return err
+	}
+	for _, msg := range out.Messages {
+		var event mediaConvertEvent
+		if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &event); err != nil {
+			log.LogNoRequestID("invalid MediaConvert event, deleting it", "err", err)
+		} else if !e.notify(event.Detail.JobID) {
+			receives, _ := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+			if receives < mediaConvertEventMaxReceives {
+				continue
+			}
+			log.LogNoRequestID("MediaConvert event of a job no node waits for, deleting it", "job_id", event.Detail.JobID, "status", event.Detail.Status, "receives", receives)
+		}
+		_, err := e.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(e.queueURL),
+			ReceiptHandle: msg.ReceiptHandle,
+		})
+		if err != nil {
+			log.LogNoRequestID("failed to delete MediaConvert event", "err", err, "job_id", event.Detail.JobID)
+		}
+	}
+	return nil
+}
+
+// notify wakes up the job waiting for the event, returning false when no job of this node is
+func (e *mediaConvertEvents) notify(jobID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, ok := e.waiting[jobID]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// mediaConvertPollDelay returns how long to wait before getting the status of a job again. The job is polled more
+// often as it gets closer to completion, estimated from its progress so far, and rarely when its state changes are
+// pushed to us.
+func mediaConvertPollDelay(progress float64, elapsed time.Duration, pushed bool) time.Duration {
+	minDelay, maxDelay := pollDelay, 6*pollDelay
+	if pushed {
+		return maxDelay
+	}
+	if progress <= 0 {
+		// Still queued or starting up
+		return 2 * minDelay
+	}
+	remaining := time.Duration(float64(elapsed) * (1 - progress) / progress)
+	return min(max(remaining/4, minDelay), maxDelay)
+}
@@ -11,9 +11,12 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/mediaconvert"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/video"
 	"github.com/stretchr/testify/require"
 )
@@ -216,6 +219,7 @@ func Test_createJobPayload(t *testing.T) {
 	type args struct {
 		mp4OutputFile string
 		accelerated   bool
+		deinterlace   bool
 		profiles      []video.EncodedProfile
 	}
 	tests := []struct {
@@ -249,10 +253,19 @@ func Test_createJobPayload(t *testing.T) {
 			},
 			want: "fixtures/mediaconvert_payloads/no-mp4.txt",
 		},
+		{
+			name: "deinterlace",
+			args: args{
+				accelerated: false,
+				deinterlace: true,
+				profiles:    video.DefaultTranscodeProfiles,
+			},
+			want: "fixtures/mediaconvert_payloads/deinterlace.txt",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual := createJobPayload(inputFile, hlsOutputFile, tt.args.mp4OutputFile, role, tt.args.accelerated, tt.args.profiles, config.DefaultSegmentSizeSecs)
+			actual := createJobPayload(inputFile, hlsOutputFile, tt.args.mp4OutputFile, role, tt.args.accelerated, tt.args.deinterlace, tt.args.profiles, config.DefaultSegmentSizeSecs)
 			require.NotNil(t, actual)
 			require.Equal(t, loadFixture(t, tt.want, actual.String()), actual.String())
 		})
@@ -403,3 +416,110 @@ func (s *stubS3Client) GetObject(bucket, key string) (*s3.GetObjectOutput, error
 		ContentLength: aws.Int64(123),
 	}, nil
 }
+
+type stubSQSClient struct {
+	messages chan *sqs.Message
+	deleted  chan string
+}
+
+func (s *stubSQSClient) ReceiveMessageWithContext(ctx aws.Context, _ *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg := <-s.messages:
+		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{msg}}, nil
+	}
+}
+
+func (s *stubSQSClient) DeleteMessageWithContext(_ aws.Context, input *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	s.deleted <- aws.StringValue(input.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestMediaConvertEventsWakeUpPolling(t *testing.T) {
+	require := require.New(t)
+
+	stubSQS := &stubSQSClient{messages: make(chan *sqs.Message, 4), deleted: make(chan string, 4)}
+	events := newMediaConvertEvents(stubSQS, "queue")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go events.Run(ctx)
+
+	// Invalid events and the ones no node waited for in time are deleted
+	stubSQS.messages <- &sqs.Message{ReceiptHandle: aws.String("invalid"), Body: aws.String(`{`)}
+	stubSQS.messages <- &sqs.Message{
+		ReceiptHandle: aws.String("stale"),
+		Body:          aws.String(`{"detail-type": "MediaConvert Job State Change", "detail": {"jobId": "9", "status": "COMPLETE"}}`),
+		Attributes:    map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("5")},
+	}
+	require.Equal("invalid", <-stubSQS.deleted)
+	require.Equal("stale", <-stubSQS.deleted)
+
+	getJobCalls := 0
+	awsStub := &stubMediaConvertClient{
+		createJob: func(input *mediaconvert.CreateJobInput) (*mediaconvert.CreateJobOutput, error) {
+			return &mediaconvert.CreateJobOutput{Job: &mediaconvert.Job{Id: aws.String("10")}}, nil
+		},
+		getJob: func(input *mediaconvert.GetJobInput) (*mediaconvert.GetJobOutput, error) {
+			getJobCalls++
+			return nil, errors.New("done with this test")
+		},
+	}
+	mc, f, _, cleanup := setupTestMediaConvert(t, awsStub)
+	defer cleanup()
+	mc.events = events
+	// Only the events can wake up the job in time
+	pollDelay = time.Hour
+
+	// The event of another node's job is left in the queue
+	stubSQS.messages <- &sqs.Message{
+		ReceiptHandle: aws.String("other"),
+		Body:          aws.String(`{"detail-type": "MediaConvert Job State Change", "detail": {"jobId": "11", "status": "COMPLETE"}}`),
+		Attributes:    map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("1")},
+	}
+	go func() {
+		require.Eventually(func() bool {
+			events.mu.Lock()
+			defer events.mu.Unlock()
+			return len(events.waiting) == 1
+		}, 5*time.Second, time.Millisecond)
+		stubSQS.messages <- &sqs.Message{ReceiptHandle: aws.String("ours"), Body: aws.String(`{"detail-type": "MediaConvert Job State Change", "detail": {"jobId": "10", "status": "COMPLETE"}}`)}
+	}()
+
+	_, err := mc.Transcode(context.Background(), TranscodeJobArgs{
+		InputFile:         mustParseURL(t, "file://"+f.Name()),
+		HLSOutputLocation: mustParseURL(t, "s3+https://endpoint.com/bucket/1234"),
+		InputFileInfo:     inputVideo,
+	})
+	require.ErrorContains(err, "done with this test")
+	require.Equal(1, getJobCalls)
+	require.Equal("ours", <-stubSQS.deleted)
+	require.Empty(stubSQS.deleted)
+}
+
+func TestMediaConvertPollDelay(t *testing.T) {
+	require := require.New(t)
+	require.Equal(2*pollDelay, mediaConvertPollDelay(0, 0, false))
+	// 10 minutes left
+	require.Equal(6*pollDelay, mediaConvertPollDelay(0.5, 10*time.Minute, false))
+	// 1 minute left
+	require.Equal(15*time.Second, mediaConvertPollDelay(0.5, time.Minute, false))
+	// Nearly done
+	require.Equal(pollDelay, mediaConvertPollDelay(0.99, time.Minute, false))
+	require.Equal(6*pollDelay, mediaConvertPollDelay(0.99, time.Minute, true))
+}
+
+func TestMediaConvertJobErrorClasses(t *testing.T) {
+	err := mediaConvertJobError(1030, "unsupported codec")
+	require.EqualError(t, err, "job failed: unsupported codec")
+	require.True(t, catErrs.IsUnretriable(err))
+	require.Equal(t, catErrs.ClassUnsupportedInput, catErrs.Class(err))
+
+	err = mediaConvertJobError(1401, "access denied")
+	require.True(t, catErrs.IsUnretriable(err))
+	require.Equal(t, catErrs.ClassAccessDenied, catErrs.Class(err))
+
+	err = mediaConvertJobError(1999, "internal error")
+	require.False(t, catErrs.IsUnretriable(err))
+	require.Equal(t, catErrs.ClassTranscode, catErrs.Class(err))
+}
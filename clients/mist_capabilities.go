@@ -0,0 +1,216 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// MistCapabilities is what a Mist server reports it supports
+type MistCapabilities struct {
+	// Version of Mist, e.g. "Generic_x86_64 3.2"
+	Version string
+	// Whether Mist answered the push_auto_list command, which older versions ignore
+	PushAutoList bool
+	// The trigger types Mist can fire
+	Triggers []string
+}
+
+type capabilitiesCommand struct {
+	Capabilities bool     `json:"capabilities"`
+	Config       struct{} `json:"config"`
+	PushAutoList bool     `json:"push_auto_list"`
+}
+
+// GetCapabilities asks Mist for its version, capabilities and the push_auto_list command, which tells the commands it
+// supports apart from those it ignores
+func (mc *MistClient) GetCapabilities() (MistCapabilities, error) {
+	resp, err := mc.sendCommand(capabilitiesCommand{Capabilities: true, PushAutoList: true})
+	if err := validateAuth(resp, err); err != nil {
+		return MistCapabilities{}, err
+	}
+
+	var r struct {
+		Capabilities struct {
+			Triggers map[string]json.RawMessage `json:"triggers"`
+		} `json:"capabilities"`
+		Config struct {
+			Version string `json:"version"`
+		} `json:"config"`
+		PushAutoList json.RawMessage `json:"push_auto_list"`
+	}
+	if err := json.Unmarshal([]byte(resp), &r); err != nil {
+		return MistCapabilities{}, fmt.Errorf("error parsing Mist capabilities: %w", err)
+	}
+	caps := MistCapabilities{Version: r.Config.Version, PushAutoList: r.PushAutoList != nil}
+	for trigger := range r.Capabilities.Triggers {
+		caps.Triggers = append(caps.Triggers, trigger)
+	}
+	sort.Strings(caps.Triggers)
+	return caps, nil
+}
+
+// MistFeatureCheck is whether Mist has a feature catalyst-api relies on
+type MistFeatureCheck struct {
+	Name      string `json:"name"`
+	Supported bool   `json:"supported"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// MistCapabilityReport is the outcome of the handshake with Mist at startup, or of the last one after it failed
+type MistCapabilityReport struct {
+	Version   string             `json:"version,omitempty"`
+	CheckedAt time.Time          `json:"checked_at"`
+	OK        bool               `json:"ok"`
+	Features  []MistFeatureCheck `json:"features,omitempty"`
+	// Set when Mist couldn't be asked for its capabilities
+	Error string `json:"error,omitempty"`
+}
+
+// MistCommandsByVersion are the commands catalyst-api sends that Mist can't be asked whether it supports, as it ignores
+// those it doesn't know. They're checked against the version of Mist instead.
+var MistCommandsByVersion = []string{"stop_sessions", "nuke_stream", "invalidate_sessions"}
+
+var mistVersionRegex = regexp.MustCompile(`\b\d+(\.\d+)*\b`)
+
+// CheckMistCapabilities checks that Mist has the commands and triggers catalyst-api relies on, so that a Mist too old
+// for it is reported at startup rather than failing obscurely later. minVersion is the version the commands that
+// can't be asked about are expected from, not checked when empty.
+func CheckMistCapabilities(mist MistAPIClient, triggers []string, minVersion string) MistCapabilityReport {
+	report := MistCapabilityReport{CheckedAt: time.Now()}
+	caps, err := mist.GetCapabilities()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Version = caps.Version
+	report.OK = true
+	check := func(name string, supported bool, detail string) {
+		report.Features = append(report.Features, MistFeatureCheck{Name: name, Supported: supported, Detail: detail})
+		report.OK = report.OK && supported
+	}
+
+	check("push_auto_list", caps.PushAutoList, "")
+
+	supportedTriggers := map[string]bool{}
+	for _, t := range caps.Triggers {
+		supportedTriggers[t] = true
+	}
+	for _, t := range triggers {
+		if len(caps.Triggers) == 0 {
+			check("trigger "+t, false, "Mist didn't list its triggers")
+		} else {
+			check("trigger "+t, supportedTriggers[t], "")
+		}
+	}
+
+	if minVersion != "" {
+		newEnough, detail := mistVersionAtLeast(caps.Version, minVersion)
+		for _, command := range MistCommandsByVersion {
+			check(command, newEnough, detail)
+		}
+	}
+	return report
+}
+
+// RecheckMistCapabilities checks the capabilities of Mist again every interval until the check passes or the context
+// is done, for a Mist that wasn't reachable yet when catalyst-api started or that was upgraded since. The report of
+// the healthcheck is updated with every check.
+func RecheckMistCapabilities(ctx context.Context, mist MistAPIClient, triggers []string, minVersion string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := ""
+	if r := GetMistCapabilityReport(); r != nil {
+		last = r.String()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report := CheckMistCapabilities(mist, triggers, minVersion)
+		SetMistCapabilityReport(report)
+		if report.OK {
+			glog.Info(report.String())
+			return
+		}
+		// Only the changes are logged, not every failed check
+		if s := report.String(); s != last {
+			glog.Warning(s)
+			last = s
+		}
+	}
+}
+
+// mistVersionAtLeast compares the first version number of a Mist version string, e.g. "Generic_x86_64 3.2.1",
+// with a minimum version
+func mistVersionAtLeast(version, minVersion string) (bool, string) {
+	v := mistVersionRegex.FindString(version)
+	if v == "" {
+		return false, fmt.Sprintf("unknown Mist version %q, %s or later is required", version, minVersion)
+	}
+	parts, minParts := strings.Split(v, "."), strings.Split(minVersion, ".")
+	for i := 0; i < max(len(parts), len(minParts)); i++ {
+		var a, b int
+		if i < len(parts) {
+			a, _ = strconv.Atoi(parts[i])
+		}
+		if i < len(minParts) {
+			b, _ = strconv.Atoi(minParts[i])
+		}
+		if a != b {
+			if a < b {
+				return false, fmt.Sprintf("Mist %s or later is required", minVersion)
+			}
+			return true, ""
+		}
+	}
+	return true, ""
+}
+
+// String is the report as logged at startup, a line per feature that's missing
+func (r MistCapabilityReport) String() string {
+	if r.Error != "" {
+		return "could not check Mist capabilities: " + r.Error
+	}
+	if r.OK {
+		return fmt.Sprintf("Mist %q supports all %d features catalyst-api relies on", r.Version, len(r.Features))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Mist %q lacks features catalyst-api relies on:", r.Version)
+	for _, f := range r.Features {
+		if !f.Supported {
+			fmt.Fprintf(&b, "\n  - %s", f.Name)
+			if f.Detail != "" {
+				fmt.Fprintf(&b, ": %s", f.Detail)
+			}
+		}
+	}
+	return b.String()
+}
+
+var mistCapabilityReport atomic.Pointer[MistCapabilityReport]
+
+// SetMistCapabilityReport keeps the report of the handshake with Mist, for the healthcheck
+func SetMistCapabilityReport(r MistCapabilityReport) {
+	mistCapabilityReport.Store(&r)
+}
+
+// GetMistCapabilityReport returns the report of the handshake with Mist, nil when there was none, e.g. without Mist
+func GetMistCapabilityReport() *MistCapabilityReport {
+	return mistCapabilityReport.Load()
+}
+
+// ResetMistCapabilityReport forgets the report of the handshake with Mist, for tests
+func ResetMistCapabilityReport() {
+	mistCapabilityReport.Store(nil)
+}
@@ -0,0 +1,26 @@
+package clients
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMistVersionAtLeast(t *testing.T) {
+	for _, tt := range []struct {
+		version, minVersion string
+		ok                  bool
+	}{
+		{"3.2", "3.0", true},
+		{"Generic_x86_64 3.2.1", "3.2.1", true},
+		{"3.10", "3.9", true},
+		{"3", "3.0", true},
+		{"2.18.2", "3.0", false},
+		{"3.2", "3.2.1", false},
+		{"Unknown", "3.0", false},
+		{"", "3.0", false},
+	} {
+		ok, _ := mistVersionAtLeast(tt.version, tt.minVersion)
+		require.Equal(t, tt.ok, ok, "%s >= %s", tt.version, tt.minVersion)
+	}
+}
@@ -11,13 +11,14 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/cache"
 	"github.com/livepeer/catalyst-api/metrics"
-	"github.com/patrickmn/go-cache"
 )
 
 //go:generate mockgen -source=./mist_client.go -destination=../mocks/clients/mist_client.go
@@ -25,11 +26,11 @@ import (
 const (
 	stateCacheKey          = "stateCacheKey"
 	defaultCacheExpiration = time.Second
-	cacheCleanupInterval   = 10 * time.Minute
 )
 
 type MistAPIClient interface {
 	AddStream(streamName, sourceUrl string) error
+	AddStreamConfig(streamName string, config MistStreamConfig) error
 	PushAutoAdd(streamName, targetURL string) error
 	PushAutoRemove(streamParams []interface{}) error
 	PushStop(id int64) error
@@ -37,10 +38,17 @@ type MistAPIClient interface {
 	DeleteStream(streamName string) error
 	NukeStream(streamName string) error
 	StopSessions(streamName string) error
+	GetSessions(streamName string) ([]MistSession, error)
+	StopSessionIDs(ids []string) error
 	AddTrigger(streamName []string, triggerName, triggerCallback string, sync bool) error
 	DeleteTrigger(streamName []string, triggerName string) error
 	GetStreamInfo(streamName string) (MistStreamInfo, error)
 	GetState() (MistState, error)
+	GetConfig() (MistConfigState, error)
+	SetTriggers(triggers Triggers) error
+	AddProtocol(protocol MistProtocol) error
+	DeleteProtocol(protocol MistProtocol) error
+	GetCapabilities() (MistCapabilities, error)
 }
 
 type MistClient struct {
@@ -49,7 +57,7 @@ type MistClient struct {
 	Password   string
 	HttpReqUrl string
 	configMu   sync.Mutex
-	cache      *cache.Cache
+	cache      *cache.Cache[MistState]
 }
 
 func NewMistAPIClient(user, password, host string, port int) MistAPIClient {
@@ -57,7 +65,7 @@ func NewMistAPIClient(user, password, host string, port int) MistAPIClient {
 		ApiUrl:   fmt.Sprintf("http://%s:%d", host, port),
 		Username: user,
 		Password: password,
-		cache:    cache.New(defaultCacheExpiration, cacheCleanupInterval),
+		cache:    cache.NewWithOptions[MistState](cache.Options{Name: "mist_state", TTL: defaultCacheExpiration}),
 	}
 	return mist
 }
@@ -118,6 +126,67 @@ type MistState struct {
 	StreamsStats  map[string]*MistStreamStats `json:"stats_streams"`
 	PushList      []*MistPush                 `json:"push_list"`
 	PushAutoList  []*MistPushAuto             `json:"push_auto_list"`
+	// The configured streams, which Mist returns with every API response. Nil if missing from the response.
+	Streams *MistStreamConfigs `json:"streams"`
+}
+
+// MistStreamConfig is the config of a stream, e.g. {"name": "video", "source": "push://"}. Kept as a map so that the
+// fields we don't know about are preserved when the config is updated.
+type MistStreamConfig map[string]interface{}
+
+func (c MistStreamConfig) Source() string {
+	source, _ := c["source"].(string)
+	return source
+}
+
+// MistProtocol is the config of a connector Mist listens with, e.g. {"connector": "TSSRT", "port": 8889}. Kept as a
+// map so that the fields we don't know about are preserved, which matters as Mist only deletes exact matches.
+type MistProtocol map[string]interface{}
+
+func (p MistProtocol) Connector() string {
+	connector, _ := p["connector"].(string)
+	return connector
+}
+
+// Port returns the port the connector listens on, 0 if it's the default port of the connector
+func (p MistProtocol) Port() int {
+	switch port := p["port"].(type) {
+	case float64:
+		return int(port)
+	case int:
+		return port
+	case string:
+		n, _ := strconv.Atoi(port)
+		return n
+	}
+	return 0
+}
+
+type MistStreamConfigs struct {
+	Configs map[string]MistStreamConfig
+	// Mist only returns some of the streams when there are many of them, so a stream missing from Configs may still
+	// be configured
+	Incomplete bool
+}
+
+func (s *MistStreamConfigs) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	s.Configs = make(map[string]MistStreamConfig, len(raw))
+	for name, r := range raw {
+		if name == "incomplete list" {
+			s.Incomplete = true
+			continue
+		}
+		var config MistStreamConfig
+		if err := json.Unmarshal(r, &config); err != nil {
+			return fmt.Errorf("invalid config of stream %s: %w", name, err)
+		}
+		s.Configs[name] = config
+	}
+	return nil
 }
 
 type AuthorizationResponse struct {
@@ -247,6 +316,12 @@ func (mc *MistClient) AddStream(streamName, sourceUrl string) error {
 	return wrapErr(validateAddStream(mc.sendCommand(c)), streamName)
 }
 
+// AddStreamConfig adds a stream or replaces the whole config of an existing one
+func (mc *MistClient) AddStreamConfig(streamName string, config MistStreamConfig) error {
+	c := addStreamCommand{Addstream: map[string]interface{}{streamName: config}}
+	return wrapErr(validateAddStream(mc.sendCommand(c)), streamName)
+}
+
 func (mc *MistClient) PushAutoAdd(streamName, targetURL string) error {
 	c := commandPushAutoAdd(streamName, targetURL)
 	return wrapErr(validatePushAutoAdd(mc.sendCommand(c)), streamName)
@@ -303,6 +378,55 @@ func (mc *MistClient) StopSessions(streamName string) error {
 	return nil
 }
 
+// MistSession is a session of a stream, e.g. a viewer, the ingest or a process such as the Livepeer transcode
+type MistSession struct {
+	ID       string
+	Protocol string
+}
+
+// GetSessions lists the current sessions of the stream
+func (mc *MistClient) GetSessions(streamName string) ([]MistSession, error) {
+	resp, err := mc.sendCommand(commandClients(streamName))
+	if err := validateAuth(resp, err); err != nil {
+		return nil, wrapErr(err, streamName)
+	}
+	var r clientsResponse
+	if err := json.Unmarshal([]byte(resp), &r); err != nil {
+		return nil, wrapErr(err, streamName)
+	}
+	idIdx, protocolIdx := -1, -1
+	for i, field := range r.Clients.Fields {
+		switch field {
+		case "sessid":
+			idIdx = i
+		case "protocol":
+			protocolIdx = i
+		}
+	}
+	if idIdx < 0 || protocolIdx < 0 {
+		return nil, wrapErr(errors.New("sessid or protocol missing from the clients fields"), streamName)
+	}
+	var sessions []MistSession
+	for _, row := range r.Clients.Data {
+		if len(row) <= idIdx || len(row) <= protocolIdx {
+			continue
+		}
+		id, _ := row[idIdx].(string)
+		protocol, _ := row[protocolIdx].(string)
+		sessions = append(sessions, MistSession{ID: id, Protocol: protocol})
+	}
+	return sessions, nil
+}
+
+// StopSessionIDs disconnects the sessions with the given IDs, see GetSessions
+func (mc *MistClient) StopSessionIDs(ids []string) error {
+	c := commandStopSessionIDs(ids)
+	if err := validateAuth(mc.sendCommand(c)); err != nil {
+		return err
+	}
+	return nil
+}
+
 // AddTrigger adds a trigger `triggerName` for the stream `streamName`.
 // Note that Mist API supports only overriding the whole trigger configuration, therefore this function needs to:
 // 1. Acquire a lock
@@ -343,6 +467,66 @@ func (mc *MistClient) DeleteTrigger(streamNames []string, triggerName string) er
 	return validateDeleteTrigger(streamNames, triggerName, resp, err)
 }
 
+// MistConfigState is the part of the Mist config that catalyst-api changes: the triggers, the protocols and the stream
+// configs
+type MistConfigState struct {
+	Triggers  Triggers
+	Protocols []MistProtocol
+	// Nil if missing from the response
+	Streams *MistStreamConfigs
+}
+
+// GetConfig returns the current triggers and stream configs of Mist
+func (mc *MistClient) GetConfig() (MistConfigState, error) {
+	resp, err := mc.sendCommand(commandGetTriggers())
+	if err := validateAuth(resp, err); err != nil {
+		return MistConfigState{}, err
+	}
+
+	cc := struct {
+		MistConfig
+		Streams *MistStreamConfigs `json:"streams"`
+	}{}
+	if err := json.Unmarshal([]byte(resp), &cc); err != nil {
+		return MistConfigState{}, err
+	}
+	triggers := cc.Config.Triggers
+	if triggers == nil {
+		triggers = Triggers{}
+	}
+	return MistConfigState{Triggers: triggers, Protocols: cc.Config.Protocols, Streams: cc.Streams}, nil
+}
+
+// AddProtocol makes Mist listen with one more connector
+func (mc *MistClient) AddProtocol(protocol MistProtocol) error {
+	mc.configMu.Lock()
+	defer mc.configMu.Unlock()
+	resp, err := mc.sendCommand(protocolCommand{AddProtocol: protocol})
+	// nothing other than auth to validate, the protocol shows in the config once it's added
+	return validateAuth(resp, err)
+}
+
+// DeleteProtocol stops the connector Mist listens with whose config is exactly the given one
+func (mc *MistClient) DeleteProtocol(protocol MistProtocol) error {
+	mc.configMu.Lock()
+	defer mc.configMu.Unlock()
+	resp, err := mc.sendCommand(protocolCommand{DeleteProtocol: protocol})
+	return validateAuth(resp, err)
+}
+
+// SetTriggers replaces all the triggers configured in Mist, e.g. to restore a backup of them
+func (mc *MistClient) SetTriggers(triggers Triggers) error {
+	if len(triggers) == 0 {
+		// an empty config is how the current config is requested, it doesn't clear the triggers
+		return errors.New("cannot remove all the Mist triggers")
+	}
+	mc.configMu.Lock()
+	defer mc.configMu.Unlock()
+
+	resp, err := mc.sendCommand(MistConfig{Config{Triggers: triggers}})
+	return validateSetTriggers(triggers, resp, err)
+}
+
 func (mc *MistClient) getCurrentTriggers() (Triggers, error) {
 	c := commandGetTriggers()
 	resp, err := mc.sendCommand(c)
@@ -395,6 +579,9 @@ func (mc *MistClient) authorize(unauthResp string) error {
 }
 
 func (mc *MistClient) sendCommandToMist(command interface{}) (string, error) {
+	if err := injectFault("mist"); err != nil {
+		return "", err
+	}
 	c, err := commandToString(command)
 	if err != nil {
 		return "", err
@@ -471,10 +658,9 @@ func (mc *MistClient) GetStreamInfo(streamName string) (MistStreamInfo, error) {
 }
 
 func (mc *MistClient) GetState() (MistState, error) {
-	cachedState, found := mc.cache.Get(stateCacheKey)
-	if found {
+	if cachedState, found := mc.cache.Lookup(stateCacheKey); found {
 		glog.V(6).Info("returning mist GetState from cache")
-		return *cachedState.(*MistState), nil
+		return cachedState, nil
 	}
 
 	c := commandState()
@@ -488,7 +674,7 @@ func (mc *MistClient) GetState() (MistState, error) {
 		return MistState{}, err
 	}
 
-	mc.cache.SetDefault(stateCacheKey, &stats)
+	mc.cache.Store(stateCacheKey, stats)
 
 	return stats, nil
 }
@@ -512,7 +698,7 @@ func commandAuthorize(username, password string) interface{} {
 }
 
 type addStreamCommand struct {
-	Addstream map[string]Stream `json:"addstream"`
+	Addstream map[string]interface{} `json:"addstream"`
 }
 
 type Stream struct {
@@ -521,8 +707,8 @@ type Stream struct {
 
 func commandAddStream(name, url string) interface{} {
 	return addStreamCommand{
-		Addstream: map[string]Stream{
-			name: {
+		Addstream: map[string]interface{}{
+			name: Stream{
 				Source: url,
 			},
 		},
@@ -569,6 +755,41 @@ func commandStopSessions(name string) stopSessionsCommand {
 	}
 }
 
+type clientsCommand struct {
+	Clients clientsRequest `json:"clients"`
+}
+
+type clientsRequest struct {
+	Streams []string `json:"streams"`
+	Fields  []string `json:"fields"`
+}
+
+func commandClients(streamName string) clientsCommand {
+	return clientsCommand{
+		Clients: clientsRequest{
+			Streams: []string{streamName},
+			Fields:  []string{"sessid", "protocol"},
+		},
+	}
+}
+
+type clientsResponse struct {
+	Clients struct {
+		Fields []string        `json:"fields"`
+		Data   [][]interface{} `json:"data"`
+	} `json:"clients"`
+}
+
+type stopSessionIDsCommand struct {
+	StopSessID []string `json:"stop_sessid"`
+}
+
+func commandStopSessionIDs(ids []string) stopSessionIDsCommand {
+	return stopSessionIDsCommand{
+		StopSessID: ids,
+	}
+}
+
 type pushAutoAddCommand struct {
 	PushAutoAdd PushAutoAdd `json:"push_auto_add"`
 }
@@ -620,7 +841,13 @@ type ConfigTrigger struct {
 type Triggers map[string][]ConfigTrigger
 
 type Config struct {
-	Triggers map[string][]ConfigTrigger `json:"triggers,omitempty"`
+	Triggers  map[string][]ConfigTrigger `json:"triggers,omitempty"`
+	Protocols []MistProtocol             `json:"protocols,omitempty"`
+}
+
+type protocolCommand struct {
+	AddProtocol    MistProtocol `json:"addprotocol,omitempty"`
+	DeleteProtocol MistProtocol `json:"deleteprotocol,omitempty"`
 }
 
 func commandAddTrigger(streamNames []string, triggerName, handlerUrl string, currentTriggers Triggers, sync bool) MistConfig {
@@ -756,6 +983,28 @@ func validateAddTrigger(streamNames []string, triggerName, resp string, err erro
 	return fmt.Errorf("adding trigger failed, no stream '%v' found in trigger '%s'", streamNames, triggerName)
 }
 
+func validateSetTriggers(triggers Triggers, resp string, err error) error {
+	if err := validateAuth(resp, err); err != nil {
+		return err
+	}
+
+	r := MistConfig{}
+	if err := json.Unmarshal([]byte(resp), &r); err != nil {
+		return err
+	}
+	for name, ts := range triggers {
+		if len(r.Config.Triggers[name]) != len(ts) {
+			return fmt.Errorf("setting triggers failed, expected %d '%s' triggers in response, got %d", len(ts), name, len(r.Config.Triggers[name]))
+		}
+	}
+	for name := range r.Config.Triggers {
+		if _, ok := triggers[name]; !ok && len(r.Config.Triggers[name]) > 0 {
+			return fmt.Errorf("setting triggers failed, unexpected trigger '%s' in response", name)
+		}
+	}
+	return nil
+}
+
 func validateDeleteTrigger(streamNames []string, triggerName, resp string, err error) error {
 	if err := validateAuth(resp, err); err != nil {
 		return err
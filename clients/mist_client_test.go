@@ -10,7 +10,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/patrickmn/go-cache"
+	"github.com/livepeer/catalyst-api/cache"
 	"github.com/stretchr/testify/require"
 )
 
@@ -49,6 +49,14 @@ func TestRequestPayload(t *testing.T) {
 			"command=%7B%22stop_sessions%22%3A%22somestream%22%7D",
 			commandStopSessions("somestream"),
 		},
+		{
+			"command=%7B%22clients%22%3A%7B%22streams%22%3A%5B%22somestream%22%5D%2C%22fields%22%3A%5B%22sessid%22%2C%22protocol%22%5D%7D%7D",
+			commandClients("somestream"),
+		},
+		{
+			"command=%7B%22stop_sessid%22%3A%5B%22abc%22%5D%7D",
+			commandStopSessionIDs([]string{"abc"}),
+		},
 		{
 			"command=%7B%22config%22%3A%7B%22triggers%22%3A%7B%22PUSH_END%22%3A%5B%7B%22handler%22%3A%22http%3A%2F%2Flocalhost%2Fapi%22%2C%22streams%22%3A%5B%22somestream%22%5D%2C%22sync%22%3Afalse%7D%5D%7D%7D%7D",
 			commandAddTrigger([]string{"somestream"}, "PUSH_END", "http://localhost/api", Triggers{}, false),
@@ -386,7 +394,7 @@ func TestItCanGetStreamStats(t *testing.T) {
 
 	mc := &MistClient{
 		ApiUrl: svr.URL,
-		cache:  cache.New(200*time.Millisecond, time.Minute),
+		cache:  cache.NewWithOptions[MistState](cache.Options{TTL: 200 * time.Millisecond}),
 	}
 
 	status, err := mc.GetState()
@@ -416,6 +424,24 @@ func TestItCanGetStreamStats(t *testing.T) {
 	require.Equal(t, 2, callCount)
 }
 
+func TestItCanGetStreamSessions(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{
+			"authorize": {"status": "OK"},
+			"clients": {
+				"fields": ["protocol", "sessid"],
+				"data": [["INPUT:RTMP", "s1"], ["OUTPUT:Livepeer", "s2"], ["HLS", "s3"]]
+			}
+		}`))
+	}))
+	defer svr.Close()
+
+	mc := &MistClient{ApiUrl: svr.URL}
+	sessions, err := mc.GetSessions("video+abc")
+	require.NoError(t, err)
+	require.Equal(t, []MistSession{{ID: "s1", Protocol: "INPUT:RTMP"}, {ID: "s2", Protocol: "OUTPUT:Livepeer"}, {ID: "s3", Protocol: "HLS"}}, sessions)
+}
+
 func TestUnmarshalJSONArray(t *testing.T) {
 	var str string
 	var num int
@@ -851,3 +877,54 @@ func TestIsIngestStream(t *testing.T) {
 		require.Equal(t, tt.wantIsIngest, state.IsIngestStream(tt.stream))
 	}
 }
+
+func TestParseStreamConfigs(t *testing.T) {
+	var state MistState
+	err := json.Unmarshal([]byte(`{"streams":{"video":{"name":"video","source":"push://","processes":[{"process":"Livepeer"}]},"incomplete list":1}}`), &state)
+	require.NoError(t, err)
+	require.True(t, state.Streams.Incomplete)
+	require.Len(t, state.Streams.Configs, 1)
+	require.Equal(t, "push://", state.Streams.Configs["video"].Source())
+	require.Contains(t, state.Streams.Configs["video"], "processes")
+
+	state = MistState{}
+	require.NoError(t, json.Unmarshal([]byte(`{"active_streams":{}}`), &state))
+	require.Nil(t, state.Streams)
+}
+
+func TestValidateSetTriggers(t *testing.T) {
+	require := require.New(t)
+	triggers := Triggers{"PUSH_END": {{Handler: "http://127.0.0.1:7979/api/mist/trigger", Streams: []string{}}}}
+
+	require.NoError(validateSetTriggers(triggers, `{"authorize":{"status":"OK"},"config":{"triggers":{"PUSH_END":[{"handler":"http://127.0.0.1:7979/api/mist/trigger","streams":[],"sync":false}],"RECORDING_END":null}}}`, nil))
+	require.Error(validateSetTriggers(triggers, `{"authorize":{"status":"OK"},"config":{"triggers":{}}}`, nil))
+	require.Error(validateSetTriggers(triggers, `{"authorize":{"status":"OK"},"config":{"triggers":{"PUSH_END":[{"handler":"http://127.0.0.1:7979/api/mist/trigger","streams":[],"sync":false}],"USER_NEW":[{"handler":"http://other","streams":[],"sync":true}]}}}`, nil))
+	require.Error(validateSetTriggers(triggers, `{"authorize":{"status":"CHALL"}}`, nil))
+}
+
+func TestDiffMistState(t *testing.T) {
+	prev := MistState{
+		ActiveStreams: map[string]*ActiveStream{"video+a": {}, "video+b": {}},
+		StreamsStats:  map[string]*MistStreamStats{"video+a": {Clients: 2}, "video+b": {Clients: 1}},
+		PushList: []*MistPush{
+			{ID: 1, Stream: "video+a", OriginalURL: "rtmp://rtmp.example.com/live/secret-key"},
+		},
+	}
+	cur := MistState{
+		ActiveStreams: map[string]*ActiveStream{"video+a": {}, "video+c": {}},
+		StreamsStats:  map[string]*MistStreamStats{"video+a": {Clients: 5}, "video+c": {Clients: 0}},
+		PushList: []*MistPush{
+			{ID: 2, Stream: "video+c", OriginalURL: "srt://srt.example.com:9000?streamid=secret"},
+		},
+	}
+
+	diff := DiffMistState(prev, cur)
+	require.Equal(t, []string{"video+c"}, diff.StreamsStarted)
+	require.Equal(t, []string{"video+b"}, diff.StreamsStopped)
+	require.Equal(t, []MistPushDiff{{ID: 2, Stream: "video+c", Target: "srt://srt.example.com:9000"}}, diff.PushesAdded)
+	require.Equal(t, []MistPushDiff{{ID: 1, Stream: "video+a", Target: "rtmp://rtmp.example.com"}}, diff.PushesRemoved)
+	require.Equal(t, map[string]int{"video+a": 5, "video+b": 0, "video+c": 0}, diff.ClientCounts)
+	require.False(t, diff.IsEmpty())
+
+	require.True(t, DiffMistState(cur, cur).IsEmpty())
+}
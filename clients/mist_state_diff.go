@@ -0,0 +1,95 @@
+package clients
+
+import (
+	"net/url"
+	"sort"
+	"time"
+)
+
+// MistStateDiff is what changed in Mist between two MistStates, e.g. for live ops dashboards following the churn of
+// streams and pushes on a node
+type MistStateDiff struct {
+	Timestamp      time.Time      `json:"timestamp"`
+	StreamsStarted []string       `json:"streams_started,omitempty"`
+	StreamsStopped []string       `json:"streams_stopped,omitempty"`
+	PushesAdded    []MistPushDiff `json:"pushes_added,omitempty"`
+	PushesRemoved  []MistPushDiff `json:"pushes_removed,omitempty"`
+	// New client count of the streams whose count changed, 0 for the streams that stopped
+	ClientCounts map[string]int `json:"client_counts,omitempty"`
+}
+
+type MistPushDiff struct {
+	ID     int64  `json:"id"`
+	Stream string `json:"stream"`
+	// Only the scheme and host of the push target, since the rest of it usually holds the stream key
+	Target string `json:"target"`
+}
+
+func (d MistStateDiff) IsEmpty() bool {
+	return len(d.StreamsStarted) == 0 && len(d.StreamsStopped) == 0 && len(d.PushesAdded) == 0 &&
+		len(d.PushesRemoved) == 0 && len(d.ClientCounts) == 0
+}
+
+// DiffMistState returns what changed from prev to cur
+func DiffMistState(prev, cur MistState) MistStateDiff {
+	diff := MistStateDiff{Timestamp: time.Now(), ClientCounts: map[string]int{}}
+
+	for stream := range cur.ActiveStreams {
+		if _, ok := prev.ActiveStreams[stream]; !ok {
+			diff.StreamsStarted = append(diff.StreamsStarted, stream)
+		}
+	}
+	for stream := range prev.ActiveStreams {
+		if _, ok := cur.ActiveStreams[stream]; !ok {
+			diff.StreamsStopped = append(diff.StreamsStopped, stream)
+		}
+	}
+	sort.Strings(diff.StreamsStarted)
+	sort.Strings(diff.StreamsStopped)
+
+	prevPushes, curPushes := pushesByID(prev.PushList), pushesByID(cur.PushList)
+	for _, p := range cur.PushList {
+		if _, ok := prevPushes[p.ID]; !ok {
+			diff.PushesAdded = append(diff.PushesAdded, newMistPushDiff(p))
+		}
+	}
+	for _, p := range prev.PushList {
+		if _, ok := curPushes[p.ID]; !ok {
+			diff.PushesRemoved = append(diff.PushesRemoved, newMistPushDiff(p))
+		}
+	}
+
+	for stream, stats := range cur.StreamsStats {
+		if stats == nil {
+			continue
+		}
+		if prevStats := prev.StreamsStats[stream]; prevStats == nil || prevStats.Clients != stats.Clients {
+			diff.ClientCounts[stream] = stats.Clients
+		}
+	}
+	for stream, stats := range prev.StreamsStats {
+		if _, ok := cur.StreamsStats[stream]; !ok && stats != nil && stats.Clients != 0 {
+			diff.ClientCounts[stream] = 0
+		}
+	}
+	if len(diff.ClientCounts) == 0 {
+		diff.ClientCounts = nil
+	}
+	return diff
+}
+
+func pushesByID(pushes []*MistPush) map[int64]*MistPush {
+	byID := make(map[int64]*MistPush, len(pushes))
+	for _, p := range pushes {
+		byID[p.ID] = p
+	}
+	return byID
+}
+
+func newMistPushDiff(p *MistPush) MistPushDiff {
+	var target string
+	if u, err := url.Parse(p.OriginalURL); err == nil {
+		target = u.Scheme + "://" + u.Host
+	}
+	return MistPushDiff{ID: p.ID, Stream: p.Stream, Target: target}
+}
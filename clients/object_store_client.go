@@ -27,10 +27,27 @@ func DownloadOSURL(osURL string) (io.ReadCloser, error) {
 	return fileInfoReader.Body, nil
 }
 
+// ParseOSURL returns the storage driver of an object store URL, including the Azure Blob Storage URLs that go-tools
+// has no driver for
+func ParseOSURL(osURL string, useFullAPI bool) (drivers.OSDriver, error) {
+	if IsAzureBlobURL(osURL) {
+		return newAzureBlobDriver(osURL)
+	}
+	return drivers.ParseOSURL(osURL, useFullAPI)
+}
+
 func GetOSURL(osURL, byteRange string) (*drivers.FileInfoReader, error) {
+	return GetOSURLContext(context.Background(), osURL, byteRange)
+}
+
+// GetOSURLContext is GetOSURL aborting the read when ctx is done
+func GetOSURLContext(ctx context.Context, osURL, byteRange string) (*drivers.FileInfoReader, error) {
+	if IsAzureBlobURL(osURL) {
+		return getAzureBlob(ctx, osURL, byteRange)
+	}
 	storageDriver, err := drivers.ParseOSURL(osURL, true)
 	if err != nil {
-		return nil, catErrs.Unretriable(fmt.Errorf("failed to parse OS URL %q: %w", log.RedactURL(osURL), err))
+		return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, fmt.Errorf("failed to parse OS URL %q: %w", log.RedactURL(osURL), err)))
 	}
 
 	start := time.Now()
@@ -43,11 +60,15 @@ func GetOSURL(osURL, byteRange string) (*drivers.FileInfoReader, error) {
 		bucket = info.S3Info.Bucket
 	}
 	var fileInfoReader *drivers.FileInfoReader
-	if byteRange == "" {
-		fileInfoReader, err = sess.ReadData(context.Background(), "")
-	} else {
-		fileInfoReader, err = sess.ReadDataRange(context.Background(), "", byteRange)
-	}
+	err = storageQuirksFor(osURL).retryThrottled(ctx, func() error {
+		var err error
+		if byteRange == "" {
+			fileInfoReader, err = sess.ReadData(ctx, "")
+		} else {
+			fileInfoReader, err = sess.ReadDataRange(ctx, "", byteRange)
+		}
+		return err
+	})
 
 	if err != nil {
 		metrics.Metrics.ObjectStoreClient.FailureCount.WithLabelValues(host, "read", bucket).Inc()
@@ -55,7 +76,7 @@ func GetOSURL(osURL, byteRange string) (*drivers.FileInfoReader, error) {
 		if errors.Is(err, drivers.ErrNotExist) {
 			return nil, catErrs.NewObjectNotFoundError("not found in OS", err)
 		}
-		return nil, fmt.Errorf("failed to read from OS URL %q: %w", log.RedactURL(osURL), err)
+		return nil, storageFailure(fmt.Errorf("failed to read from OS URL %q: %w", log.RedactURL(osURL), err))
 	}
 
 	duration := time.Since(start)
@@ -66,13 +87,34 @@ func GetOSURL(osURL, byteRange string) (*drivers.FileInfoReader, error) {
 }
 
 func UploadToOSURL(osURL, filename string, data io.Reader, timeout time.Duration) error {
-	return UploadToOSURLFields(osURL, filename, data, timeout, nil)
+	return UploadToOSURLFields(context.Background(), osURL, filename, data, timeout, nil)
 }
 
-func UploadToOSURLFields(osURL, filename string, data io.Reader, timeout time.Duration, fields *drivers.FileProperties) error {
+// UploadToOSURLFields uploads with the given file properties. The upload is aborted when ctx is done, on top of the
+// timeout. Uploads to the primary stores of -storage-fallback-urls are copied to their backup store in the background
+// when write-through is enabled.
+func UploadToOSURLFields(ctx context.Context, osURL, filename string, data io.Reader, timeout time.Duration, fields *drivers.FileProperties) error {
+	if err := uploadToOSURLFields(ctx, osURL, filename, data, timeout, fields); err != nil {
+		return err
+	}
+	if mirror != nil {
+		mirror.enqueue(osURL, filename)
+	}
+	return nil
+}
+
+func uploadToOSURLFields(ctx context.Context, osURL, filename string, data io.Reader, timeout time.Duration, fields *drivers.FileProperties) (err error) {
+	defer func(start time.Time) { observeStorageOperation("upload", osURL, start, err) }(time.Now())
+	if err := injectFault("upload"); err != nil {
+		return fmt.Errorf("failed to write to OS URL %q: %w", log.RedactURL(osURL+"/"+filename), err)
+	}
+	if IsAzureBlobURL(osURL) {
+		return uploadToAzureBlob(ctx, osURL, filename, data, timeout, fields)
+	}
+
 	storageDriver, err := drivers.ParseOSURL(osURL, true)
 	if err != nil {
-		return fmt.Errorf("failed to parse OS URL %q: %s", log.RedactURL(osURL), err)
+		return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassInvalidRequest, fmt.Errorf("failed to parse OS URL %q: %s", log.RedactURL(osURL), err)))
 	}
 	start := time.Now()
 
@@ -84,11 +126,16 @@ func UploadToOSURLFields(osURL, filename string, data io.Reader, timeout time.Du
 		bucket = info.S3Info.Bucket
 	}
 
-	_, err = sess.SaveData(context.Background(), filename, data, fields, timeout)
+	limiter := uploadLimiterFor(osURL)
+	if err := limiter.acquire(ctx); err != nil {
+		return fmt.Errorf("failed to write to OS URL %q: %w", log.RedactURL(osURL+"/"+filename), err)
+	}
+	_, err = sess.SaveData(ctx, filename, countUpload(ctx, osURL, data), fields, timeout)
+	limiter.release(err == nil, isStorageThrottled(osURL, err), retryAfter(err))
 
 	if err != nil {
 		metrics.Metrics.ObjectStoreClient.FailureCount.WithLabelValues(host, "write", bucket).Inc()
-		return fmt.Errorf("failed to write to OS URL %q: %s", log.RedactURL(osURL+"/"+filename), err)
+		return storageFailure(fmt.Errorf("failed to write to OS URL %q: %w", log.RedactURL(osURL+"/"+filename), err))
 	}
 
 	duration := time.Since(start)
@@ -99,14 +146,29 @@ func UploadToOSURLFields(osURL, filename string, data io.Reader, timeout time.Du
 }
 
 func ListOSURL(ctx context.Context, osURL string) (drivers.PageInfo, error) {
-	osDriver, err := drivers.ParseOSURL(osURL, true)
+	osDriver, err := ParseOSURL(osURL, true)
 	if err != nil {
 		return nil, fmt.Errorf("unexpected error parsing internal driver URL: %w", err)
 	}
 	os := osDriver.NewSession("")
 
-	page, err := os.ListFiles(ctx, "", "")
-	if err != nil {
+	quirks := storageQuirksFor(osURL)
+	var page drivers.PageInfo
+	err = backoff.Retry(func() error {
+		err := quirks.retryThrottled(ctx, func() error {
+			var err error
+			page, err = os.ListFiles(ctx, "", "")
+			return err
+		})
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		if len(page.Files()) == 0 && len(page.Directories()) == 0 {
+			return errEmptyListing
+		}
+		return nil
+	}, backoff.WithContext(backoff.WithMaxRetries(newExponentialBackOffExecutor(), uint64(quirks.listRetries)), ctx))
+	if err != nil && !errors.Is(err, errEmptyListing) {
 		return nil, fmt.Errorf("error listing files: %w", err)
 	}
 
@@ -156,7 +218,7 @@ func Publish(hlsTarget string, mp4Target string) (string, string, error) {
 // If driver does not support `Publish()`, e.g. S3, then return the input osUrl, video should be accessible with osUrl.
 // In case of any other error, return an empty string and an error.
 func publishDriverSession(osUrl string, relPath string) (string, error) {
-	osDriver, err := drivers.ParseOSURL(osUrl, true)
+	osDriver, err := ParseOSURL(osUrl, true)
 	if err != nil {
 		return "", err
 	}
@@ -198,19 +260,50 @@ func UploadRetryBackoff() backoff.BackOff {
 	return backoff.WithMaxRetries(newExponentialBackOffExecutor(), 5)
 }
 
-func SignURL(u *url.URL) (string, error) {
+func SignURL(u *url.URL) (signedURL string, err error) {
 	if u.Scheme == "" || u.Scheme == "file" || u.Scheme == "http" || u.Scheme == "https" { // not an OS url
 		return u.String(), nil
 	}
+	defer func(start time.Time) { observeStorageOperation("sign", u.String(), start, err) }(time.Now())
+	if IsAzureBlobURL(u.String()) {
+		return signAzureBlobURL(u.String())
+	}
+
 	driver, err := drivers.ParseOSURL(u.String(), true)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse OS url: %w", err)
 	}
 
 	sess := driver.NewSession("")
-	signedURL, err := sess.Presign("", PresignDuration)
+	signedURL, err = sess.Presign("", PresignDuration)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate signed url: %w", err)
 	}
 	return signedURL, nil
 }
+
+// uploadToAzureBlob is UploadToOSURLFields to Azure Blob Storage, which isn't a go-tools driver
+func uploadToAzureBlob(ctx context.Context, osURL, filename string, data io.Reader, timeout time.Duration, fields *drivers.FileProperties) error {
+	limiter := uploadLimiterFor(osURL)
+	if err := limiter.acquire(ctx); err != nil {
+		return fmt.Errorf("failed to write to OS URL %q: %w", log.RedactURL(osURL+"/"+filename), err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := uploadAzureBlob(ctx, osURL, filename, countUpload(ctx, osURL, data), fields)
+	limiter.release(err == nil, isStorageThrottled(osURL, err), retryAfter(err))
+	if err != nil {
+		return fmt.Errorf("failed to write to OS URL %q: %w", log.RedactURL(osURL+"/"+filename), err)
+	}
+	return nil
+}
+
+// IsOSURL returns whether the URL is an object store URL, read and written with the storage clients rather than plain
+// HTTP
+func IsOSURL(osURL string) bool {
+	if IsAzureBlobURL(osURL) {
+		return true
+	}
+	_, err := drivers.ParseOSURL(osURL, true)
+	return err == nil
+}
@@ -0,0 +1,82 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// writeRenditionPlaylist writes the VOD playlist of a rendition transcoded from the source playlist, the segments
+// being named after their index. The lines are written as they're generated rather than building the whole playlist
+// in memory first, which matters for long recordings with tens of thousands of segments. The output matches what
+// m3u8.MediaPlaylist encodes for the same playlist.
+//
+// The segments in cueSegments are preceded by an empty ad break, the placeholder SSAI systems insert ads at, see
+// video.ConditionedOutput.
+func writeRenditionPlaylist(w io.Writer, sourceManifest m3u8.MediaPlaylist, isClip bool, cueSegments map[int]bool) error {
+	// The segments list is a ring buffer - see https://github.com/grafov/m3u8/issues/140
+	// and so we only know we've hit the end of the list when we find a nil element
+	totalSegs := 0
+	var targetDuration float64
+	for _, segment := range sourceManifest.Segments {
+		if segment == nil {
+			break
+		}
+		totalSegs++
+		if targetDuration < segment.Duration {
+			targetDuration = math.Ceil(segment.Duration)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-TARGETDURATION:")
+	bw.WriteString(strconv.FormatInt(int64(targetDuration), 10))
+	bw.WriteByte('\n')
+
+	durations := map[float64]string{}
+	for i := 0; i < totalSegs; i++ {
+		// Only add DISCONTINUITY tags if more than one segment exists in clipped playlist
+		if isClip && totalSegs > 1 && (i == 1 || i == totalSegs-1) {
+			bw.WriteString("#EXT-X-DISCONTINUITY\n")
+		} else if !isClip && sourceManifest.Segments[i].Discontinuity {
+			// Where segments of a repaired recording are missing
+			bw.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if cueSegments[i] {
+			bw.WriteString("#EXT-X-CUE-OUT:0\n#EXT-X-CUE-IN\n")
+		}
+		duration := sourceManifest.Segments[i].Duration
+		formatted, ok := durations[duration]
+		if !ok {
+			formatted = strconv.FormatFloat(duration, 'f', 3, 32)
+			durations[duration] = formatted
+		}
+		bw.WriteString("#EXTINF:")
+		bw.WriteString(formatted)
+		bw.WriteString(",\n")
+		bw.WriteString(strconv.Itoa(i))
+		if _, err := bw.WriteString(".ts\n"); err != nil {
+			return err
+		}
+	}
+	bw.WriteString("#EXT-X-ENDLIST\n")
+	return bw.Flush()
+}
+
+// uploadStreamed uploads whatever write produces while it's being produced, piping it straight into the storage
+// upload, which sends large files in parts
+func uploadStreamed(ctx context.Context, osURL, filename string, timeout time.Duration, write func(io.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	err := UploadToOSURLFields(ctx, osURL, filename, pr, timeout, nil)
+	// unblocks the writer if the upload stopped reading early
+	pr.Close()
+	return err
+}
@@ -0,0 +1,101 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/video"
+	"github.com/stretchr/testify/require"
+)
+
+// libraryRenditionPlaylist builds the rendition playlist in memory with the m3u8 library, which is what
+// writeRenditionPlaylist needs to match
+func libraryRenditionPlaylist(t *testing.T, sourceManifest m3u8.MediaPlaylist, isClip bool) string {
+	renditionPlaylist, err := m3u8.NewMediaPlaylist(sourceManifest.WinSize(), sourceManifest.Count())
+	require.NoError(t, err)
+	for i, sourceSegment := range sourceManifest.Segments {
+		if sourceSegment == nil {
+			break
+		}
+		require.NoError(t, renditionPlaylist.Append(fmt.Sprintf("%d.ts", i), sourceSegment.Duration, ""))
+	}
+	if isClip {
+		_, totalSegs := video.GetTotalDurationAndSegments(renditionPlaylist)
+		if totalSegs > 1 {
+			renditionPlaylist.Segments[1].Discontinuity = true
+			renditionPlaylist.Segments[totalSegs-1].Discontinuity = true
+		}
+	}
+	renditionPlaylist.Close()
+	return renditionPlaylist.String()
+}
+
+func TestRenditionPlaylistMatchesLibraryOutput(t *testing.T) {
+	for _, segments := range []int{1, 2, 5, 20000} {
+		source, err := m3u8.NewMediaPlaylist(0, uint(segments))
+		require.NoError(t, err)
+		for i := 0; i < segments; i++ {
+			require.NoError(t, source.Append(fmt.Sprintf("seg-%d.ts", i), 2+float64(i%7)/3, ""))
+		}
+
+		for _, isClip := range []bool{false, true} {
+			var buf bytes.Buffer
+			require.NoError(t, writeRenditionPlaylist(&buf, *source, isClip, nil))
+			require.Equal(t, libraryRenditionPlaylist(t, *source, isClip), buf.String(), "segments=%d clip=%v", segments, isClip)
+		}
+	}
+}
+
+func TestRenditionPlaylistCuePlaceholders(t *testing.T) {
+	source, err := m3u8.NewMediaPlaylist(0, 3)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, source.Append(fmt.Sprintf("seg-%d.ts", i), 10, ""))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeRenditionPlaylist(&buf, *source, false, map[int]bool{2: true}))
+	require.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-TARGETDURATION:10\n"+
+		"#EXTINF:10.000,\n0.ts\n#EXTINF:10.000,\n1.ts\n"+
+		"#EXT-X-CUE-OUT:0\n#EXT-X-CUE-IN\n#EXTINF:10.000,\n2.ts\n#EXT-X-ENDLIST\n", buf.String())
+}
+
+func TestRenditionPlaylistRepairedGaps(t *testing.T) {
+	source, err := m3u8.NewMediaPlaylist(0, 3)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, source.Append(fmt.Sprintf("seg-%d.ts", i), 10, ""))
+	}
+	source.Segments[2].Discontinuity = true
+
+	var buf bytes.Buffer
+	require.NoError(t, writeRenditionPlaylist(&buf, *source, false, nil))
+	require.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-TARGETDURATION:10\n"+
+		"#EXTINF:10.000,\n0.ts\n#EXTINF:10.000,\n1.ts\n"+
+		"#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n2.ts\n#EXT-X-ENDLIST\n", buf.String())
+}
+
+func TestUploadStreamed(t *testing.T) {
+	dir := t.TempDir()
+	err := uploadStreamed(context.Background(), dir, "index.m3u8", ManifestUploadTimeout, func(w io.Writer) error {
+		_, err := w.Write([]byte("#EXTM3U\n"))
+		return err
+	})
+	require.NoError(t, err)
+	contents, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n", string(contents))
+
+	// A failure to generate the file fails the upload
+	err = uploadStreamed(context.Background(), dir, "broken.m3u8", ManifestUploadTimeout, func(w io.Writer) error {
+		return errors.New("generation failed")
+	})
+	require.ErrorContains(t, err, "generation failed")
+}
@@ -0,0 +1,193 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// PreflightErrorType is a stable classification of why a source URL can't be fetched. The values are part of the API
+// response so they must not be changed.
+type PreflightErrorType string
+
+const (
+	PreflightNotFound          PreflightErrorType = "not_found"
+	PreflightForbidden         PreflightErrorType = "forbidden"
+	PreflightDNS               PreflightErrorType = "dns"
+	PreflightTimeout           PreflightErrorType = "timeout"
+	PreflightUnsupportedScheme PreflightErrorType = "unsupported_scheme"
+)
+
+type PreflightError struct {
+	Type PreflightErrorType
+	msg  string
+}
+
+func (e PreflightError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.msg)
+}
+
+// IsFinal returns whether the job can be rejected at admission time. Timeouts aren't final since the source may just
+// be slow to respond and the pipeline has its own retries.
+func (e PreflightError) IsFinal() bool {
+	return e.Type != PreflightTimeout
+}
+
+var preflightClient = withRequestHeaders(&http.Client{
+	// Don't follow redirects to unrelated hosts forever, but allow the usual CDN / signed URL redirects
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	},
+})
+
+// PreflightCheck does a fast check that the source URL is reachable before a job is admitted, so that the most common
+// failures (missing files, expired signed URLs, typos in the host) are reported in the API response instead of failing
+// deep in the pipeline. It returns a PreflightError when the failure could be classified and nil when the source looks
+// fine or the check was inconclusive.
+func PreflightCheck(ctx context.Context, requestID, sourceURL string) error {
+	if config.SourcePreflightTimeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(log.WithRequestID(ctx, requestID), config.SourcePreflightTimeout)
+	defer cancel()
+
+	err := preflightCheck(ctx, requestID, sourceURL)
+	var preflightErr PreflightError
+	if errors.As(err, &preflightErr) {
+		metrics.Metrics.SourcePreflightCount.WithLabelValues(string(preflightErr.Type)).Inc()
+		log.Log(requestID, "source pre-flight check failed", "err", err, "source", log.RedactURL(sourceURL))
+		return err
+	}
+	metrics.Metrics.SourcePreflightCount.WithLabelValues("ok").Inc()
+	return nil
+}
+
+func preflightCheck(ctx context.Context, requestID, sourceURL string) error {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return PreflightError{Type: PreflightUnsupportedScheme, msg: err.Error()}
+	}
+
+	// Object store URLs need credentials and are checked by the storage driver itself
+	if IsOSURL(sourceURL) {
+		return nil
+	}
+
+	if IsDStorageResource(sourceURL) {
+		return preflightDStorage(ctx, requestID, u)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return PreflightError{Type: PreflightUnsupportedScheme, msg: fmt.Sprintf("scheme %q is not supported", u.Scheme)}
+	}
+	return preflightHTTP(ctx, sourceURL)
+}
+
+// preflightHTTP sends a HEAD request and falls back to a single byte ranged GET for servers that don't support HEAD
+// (e.g. some pre-signed URLs are only valid for GET)
+func preflightHTTP(ctx context.Context, sourceURL string) error {
+	status, err := preflightRequest(ctx, http.MethodHead, sourceURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || status == http.StatusForbidden) {
+		status, err = preflightRequest(ctx, http.MethodGet, sourceURL)
+	}
+	if err != nil {
+		return classifyPreflightError(err)
+	}
+	return classifyPreflightStatus(status)
+}
+
+func preflightRequest(ctx context.Context, method, sourceURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, sourceURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := preflightClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// preflightDStorage races all of the configured gateways and succeeds as soon as any of them has the resource. The
+// resource is only reported as missing if every gateway says so.
+func preflightDStorage(ctx context.Context, requestID string, u *url.URL) error {
+	var gateways []*url.URL
+	var resourceID string
+	switch u.Scheme {
+	case SCHEME_ARWEAVE:
+		gateways, resourceID = config.ImportArweaveGatewayURLs, u.Host
+	case SCHEME_IPFS:
+		gateways, resourceID = config.ImportIPFSGatewayURLs, path.Join(u.Host, u.Path)
+	default:
+		// Gateway URLs are already plain HTTP(S)
+		return preflightHTTP(ctx, u.String())
+	}
+	if len(gateways) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan error, len(gateways))
+	for _, gateway := range gateways {
+		gatewayURL := gateway.JoinPath(resourceID).String()
+		go func() {
+			results <- preflightHTTP(ctx, gatewayURL)
+		}()
+	}
+
+	notFound := 0
+	for range gateways {
+		err := <-results
+		if err == nil {
+			return nil
+		}
+		var preflightErr PreflightError
+		if errors.As(err, &preflightErr) && preflightErr.Type == PreflightNotFound {
+			notFound++
+		}
+	}
+	if notFound == len(gateways) {
+		return PreflightError{Type: PreflightNotFound, msg: fmt.Sprintf("resource %s not found on any of the %d gateways", resourceID, len(gateways))}
+	}
+	log.Log(requestID, "dstorage pre-flight check inconclusive", "resourceID", resourceID)
+	return nil
+}
+
+func classifyPreflightStatus(status int) error {
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return PreflightError{Type: PreflightNotFound, msg: fmt.Sprintf("source returned HTTP %d", status)}
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return PreflightError{Type: PreflightForbidden, msg: fmt.Sprintf("source returned HTTP %d", status)}
+	}
+	// Anything else (including 5xx) is left for the pipeline to retry
+	return nil
+}
+
+func classifyPreflightError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return PreflightError{Type: PreflightDNS, msg: dnsErr.Error()}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return PreflightError{Type: PreflightTimeout, msg: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrNonPublicAddress is returned by the downloads of a context made with WithPublicAddressesOnly when the server is
+// on a private, loopback or link-local address
+var ErrNonPublicAddress = errors.New("non-public address")
+
+type publicAddressesOnlyKey struct{}
+
+// WithPublicAddressesOnly returns a context whose HTTP downloads (see GetFile) are only made to public addresses, for
+// the sources given by third parties, e.g. the enclosures of the imported feeds, that mustn't get the node to fetch
+// internal endpoints or the metadata server of the cloud provider. The address is checked when connecting, which
+// covers the redirects and the hosts resolving to another address than when they were first checked.
+func WithPublicAddressesOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, publicAddressesOnlyKey{}, true)
+}
+
+func publicAddressesOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(publicAddressesOnlyKey{}).(bool)
+	return only
+}
+
+// IsPublicIP returns false for the private, loopback, link-local and unspecified addresses
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified())
+}
+
+// checkPublicAddress is the Control hook of the dialer of the public downloads, called with the resolved address
+// of every connection, redirects included
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to %s: %w", host, ErrNonPublicAddress)
+	}
+	return nil
+}
+
+var publicRetryableHttpClient = newRetryableHttpClient(newPublicHttpClient())
+
+func newPublicHttpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be the address connected to instead of the server's
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkPublicAddress,
+	}).DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   MaxCopyFileDuration,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to a %q URL: %w", req.URL.Scheme, ErrNonPublicAddress)
+			}
+			if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !IsPublicIP(ip) {
+				return fmt.Errorf("redirect to %s: %w", ip, ErrNonPublicAddress)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	require.True(t, IsPublicIP(net.ParseIP("93.184.216.34")))
+	require.True(t, IsPublicIP(net.ParseIP("2606:2800:220:1::1")))
+	for _, ip := range []string{"10.0.0.5", "192.168.1.1", "127.0.0.1", "169.254.169.254", "0.0.0.0", "fd00::1", "::1"} {
+		require.False(t, IsPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestPublicAddressesOnlyDownloadsAreCheckedWhenConnecting(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	rc, err := GetFile(context.Background(), "req", server.URL+"/file.mp4", nil)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "internal", string(b))
+	rc.Close()
+
+	_, err = GetFile(WithPublicAddressesOnly(context.Background()), "req", server.URL+"/file.mp4", nil)
+	require.ErrorIs(t, err, ErrNonPublicAddress)
+	require.True(t, catErrs.IsUnretriable(err))
+	require.Equal(t, 1, requests)
+}
+
+func TestPublicAddressesOnlyRedirectsAreChecked(t *testing.T) {
+	client := newPublicHttpClient()
+	req := func(u string) *http.Request {
+		r, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		return r
+	}
+	require.NoError(t, client.CheckRedirect(req("https://cdn.example.com/ep1.mp4"), []*http.Request{req("https://example.com/ep1.mp4")}))
+	require.ErrorIs(t, client.CheckRedirect(req("http://169.254.169.254/latest/meta-data"), nil), ErrNonPublicAddress)
+	require.ErrorIs(t, client.CheckRedirect(req("http://[::1]:7979/api"), nil), ErrNonPublicAddress)
+	require.ErrorIs(t, client.CheckRedirect(req("file:///etc/passwd"), nil), ErrNonPublicAddress)
+	require.ErrorIs(t, checkPublicAddress("tcp", "10.0.0.5:443", nil), ErrNonPublicAddress)
+	require.NoError(t, checkPublicAddress("tcp", "93.184.216.34:443", nil))
+}
@@ -0,0 +1,115 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// How often the published manifests are requested again while they're not served yet
+const publishedManifestPollInterval = time.Second
+
+var publishedManifestClient = withRequestHeaders(&http.Client{Timeout: 10 * time.Second})
+
+// WaitForPublishedManifests requests the HLS manifests of the outputs from their playback URLs until they are all
+// served, with the content we uploaded, or the timeout expires. Some stores and CDNs keep serving 404s or stale
+// playlists for a few seconds after an upload, so the manifests are checked before the outputs are announced.
+// Outputs that aren't played back over HTTP can't be checked and are skipped.
+func WaitForPublishedManifests(ctx context.Context, requestID string, outputs []video.OutputVideo, timeout time.Duration) error {
+	ctx = log.WithRequestID(ctx, requestID)
+	var masters, renditions []string
+	for _, output := range outputs {
+		if isPublishedManifest(output.Manifest) {
+			masters = append(masters, output.Manifest)
+		}
+		for _, rendition := range output.Videos {
+			if isPublishedManifest(rendition.Location) {
+				renditions = append(renditions, rendition.Location)
+			}
+		}
+	}
+	if len(masters)+len(renditions) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	for _, u := range masters {
+		if err := waitForPublishedManifest(ctx, u, m3u8.MASTER); err != nil {
+			return err
+		}
+	}
+	for _, u := range renditions {
+		if err := waitForPublishedManifest(ctx, u, m3u8.MEDIA); err != nil {
+			return err
+		}
+	}
+	log.Log(requestID, "published manifests are served", "count", len(masters)+len(renditions), "duration", time.Since(start))
+	return nil
+}
+
+func isPublishedManifest(manifestURL string) bool {
+	u, err := url.Parse(manifestURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && path.Ext(u.Path) == ".m3u8"
+}
+
+func waitForPublishedManifest(ctx context.Context, manifestURL string, listType m3u8.ListType) error {
+	var lastErr error
+	err := backoff.Retry(func() error {
+		lastErr = checkPublishedManifest(ctx, manifestURL, listType)
+		return lastErr
+	}, backoff.WithContext(backoff.NewConstantBackOff(publishedManifestPollInterval), ctx))
+	if err != nil {
+		if lastErr == nil {
+			lastErr = err
+		}
+		return fmt.Errorf("manifest %s not served: %w", log.RedactURL(manifestURL), lastErr)
+	}
+	return nil
+}
+
+// checkPublishedManifest returns an error unless the manifest is served as a playlist of the type, a complete one for
+// media playlists
+func checkPublishedManifest(ctx context.Context, manifestURL string, listType m3u8.ListType) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	resp, err := publishedManifestClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	playlist, gotType, err := m3u8.DecodeFrom(resp.Body, true)
+	if err != nil {
+		return fmt.Errorf("invalid playlist: %w", err)
+	}
+	if gotType != listType {
+		return fmt.Errorf("unexpected playlist type %d", gotType)
+	}
+	switch p := playlist.(type) {
+	case *m3u8.MasterPlaylist:
+		if len(p.Variants) == 0 {
+			return fmt.Errorf("master playlist without variants")
+		}
+	case *m3u8.MediaPlaylist:
+		// Stale copies of the playlist uploaded while transcoding aren't closed yet
+		if !p.Closed {
+			return fmt.Errorf("media playlist not closed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// RecordingRepair is what had to be done to a recording with missing segments for it to be transcoded
+type RecordingRepair struct {
+	// Indices of the segments that couldn't be found on the primary nor the backup store, left out of the source
+	MissingSegments []int
+	// Number of segments missing from the primary playlist or store that were found in the backup store
+	RecoveredSegments int
+}
+
+// RepairRecording is RecordingBackupCheck for recordings that may have lost segments. The segments are taken from the
+// primary and backup playlists, recordings naming their segments after their index, and looked for on the primary and
+// backup stores. Instead of failing, the segments that can't be found anywhere are left out and a discontinuity is
+// marked where they were, for the outputs to skip over the gap. The repaired playlist is written to the transfer
+// location, whose URL is returned, unless the recording didn't need repairing.
+func RepairRecording(requestID string, primaryManifestURL, osTransferURL *url.URL) (*url.URL, RecordingRepair, error) {
+	var repair RecordingRepair
+	playlists, err := downloadRecordingPlaylists(requestID, primaryManifestURL.String())
+	if err != nil {
+		return nil, repair, err
+	}
+	segments, indexed := mergeRecordingSegments(playlists)
+	// The playlist we started from, the largest one, is listed first. It must be rewritten unless it's the primary.
+	newPlaylistRequired := playlists[0].url != primaryManifestURL.String()
+
+	dStorage := NewDStorageDownload()
+	checked := map[string]string{}
+	var kept []*m3u8.MediaSegment
+	gap := false
+	for i, s := range segments {
+		if indexed && i > 0 && s.index != segments[i-1].index+1 {
+			// listed in neither playlist
+			for missing := segments[i-1].index + 1; missing < s.index; missing++ {
+				repair.MissingSegments = append(repair.MissingSegments, missing)
+			}
+			gap = true
+		}
+
+		actualSegURL, ok := checked[s.segment.URI]
+		if !ok {
+			segURL, err := ManifestURLToSegmentURL(primaryManifestURL.String(), s.segment.URI)
+			if err != nil {
+				return nil, repair, fmt.Errorf("error getting segment URL: %w", err)
+			}
+			actualSegURL, err = findRecordingSegment(requestID, segURL.String(), dStorage)
+			if errors.IsObjectNotFound(err) {
+				log.Log(requestID, "recording segment not found on any store, leaving a gap", "segment", s.segment.URI)
+				repair.MissingSegments = append(repair.MissingSegments, s.index)
+				gap = true
+				newPlaylistRequired = true
+				continue
+			}
+			if err != nil {
+				return nil, repair, fmt.Errorf("failed to find segment file %s: %w", segURL.Redacted(), err)
+			}
+			checked[s.segment.URI] = actualSegURL
+			if actualSegURL != segURL.String() || s.recovered {
+				newPlaylistRequired = true
+			}
+		}
+		if s.recovered {
+			repair.RecoveredSegments++
+		}
+
+		segment := *s.segment
+		segment.URI = actualSegURL
+		segment.Discontinuity = segment.Discontinuity || (gap && len(kept) > 0)
+		gap = false
+		kept = append(kept, &segment)
+	}
+	if len(kept) == 0 {
+		return nil, repair, fmt.Errorf("none of the segments of the recording were found")
+	}
+	if len(repair.MissingSegments) > 0 || repair.RecoveredSegments > 0 {
+		log.Log(requestID, "repaired recording", "missing_segments", fmt.Sprint(repair.MissingSegments), "recovered_segments", repair.RecoveredSegments)
+	}
+	if !newPlaylistRequired {
+		return primaryManifestURL, repair, nil
+	}
+
+	repaired, err := m3u8.NewMediaPlaylist(0, uint(len(kept)))
+	if err != nil {
+		return nil, repair, fmt.Errorf("failed to create repaired playlist: %w", err)
+	}
+	for _, segment := range kept {
+		// The segments keep the byte ranges of the source, which need version 4
+		if segment.Limit > 0 && repaired.Version() < 4 {
+			repaired.SetVersion(4)
+		}
+		if err := repaired.AppendSegment(segment); err != nil {
+			return nil, repair, fmt.Errorf("failed to append segment to repaired playlist: %w", err)
+		}
+	}
+	repaired.MediaType = m3u8.VOD
+	repaired.Close()
+
+	newURL, err := uploadInputPlaylist(osTransferURL.JoinPath("input.m3u8"), repaired.String())
+	return newURL, repair, err
+}
+
+type recordingPlaylist struct {
+	url      string
+	playlist m3u8.MediaPlaylist
+}
+
+// downloadRecordingPlaylists returns the primary and backup playlists of a recording that could be found, the largest
+// one first
+func downloadRecordingPlaylists(requestID, primaryManifestURL string) ([]recordingPlaylist, error) {
+	urls := []string{primaryManifestURL}
+	if backupURL := config.GetStorageBackupURL(primaryManifestURL); backupURL != "" {
+		urls = append(urls, backupURL)
+	}
+	var playlists []recordingPlaylist
+	var sizes []int
+	var firstErr error
+	for _, u := range urls {
+		playlist, playlistType, size, err := downloadManifest(requestID, u)
+		if err == nil {
+			var mediaPlaylist m3u8.MediaPlaylist
+			mediaPlaylist, err = convertToMediaPlaylist(playlist, playlistType)
+			if err == nil {
+				playlists = append(playlists, recordingPlaylist{url: u, playlist: mediaPlaylist})
+				sizes = append(sizes, size)
+				continue
+			}
+		}
+		if !errors.IsObjectNotFound(err) {
+			return nil, fmt.Errorf("error downloading manifest: %w", err)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(playlists) == 0 {
+		return nil, fmt.Errorf("error downloading manifest: %w", firstErr)
+	}
+	if len(playlists) == 2 && sizes[1] > sizes[0] {
+		playlists[0], playlists[1] = playlists[1], playlists[0]
+	}
+	return playlists, nil
+}
+
+type recordingSegment struct {
+	index   int
+	segment *m3u8.MediaSegment
+	// Only listed in the other playlist than the one we started from
+	recovered bool
+}
+
+// mergeRecordingSegments returns the segments of the first playlist, plus those only listed in the others when the
+// segments are named after their index, sorted by index. The segments are only returned in the order of the first
+// playlist, without merging, when they aren't all named after a unique index.
+func mergeRecordingSegments(playlists []recordingPlaylist) ([]recordingSegment, bool) {
+	var segments []recordingSegment
+	indices := map[int]bool{}
+	indexed := true
+	for i, segment := range playlists[0].playlist.GetAllSegments() {
+		index, ok := recordingSegmentIndex(segment.URI)
+		if !ok || indices[index] {
+			indexed = false
+			index = i
+		}
+		indices[index] = true
+		segments = append(segments, recordingSegment{index: index, segment: segment})
+	}
+	if !indexed {
+		for i := range segments {
+			segments[i].index = i
+		}
+		return segments, false
+	}
+
+	for _, p := range playlists[1:] {
+		for _, segment := range p.playlist.GetAllSegments() {
+			index, ok := recordingSegmentIndex(segment.URI)
+			if !ok || indices[index] {
+				continue
+			}
+			indices[index] = true
+			segments = append(segments, recordingSegment{index: index, segment: segment, recovered: true})
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].index < segments[j].index
+	})
+	return segments, true
+}
+
+// recordingSegmentIndex returns the index of a segment named after it, e.g. 12 for 12.ts
+func recordingSegmentIndex(uri string) (int, bool) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return 0, false
+	}
+	name := path.Base(u.Path)
+	index, err := strconv.Atoi(strings.TrimSuffix(name, path.Ext(name)))
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// findRecordingSegment returns the URL a segment can be downloaded from, on the primary or the backup store. Segments
+// that are found on neither aren't retried for long, as they're expected to be missing sometimes.
+func findRecordingSegment(requestID, segURL string, dStorage *DStorageDownload) (string, error) {
+	var actualSegURL string
+	err := backoff.Retry(func() error {
+		var rc io.ReadCloser
+		var err error
+		rc, actualSegURL, err = GetFileWithBackup(context.Background(), requestID, segURL, dStorage)
+		if rc != nil {
+			rc.Close()
+		}
+		if errors.IsObjectNotFound(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, DownloadRetryBackoff())
+	return actualSegURL, err
+}
+
+// uploadInputPlaylist writes a source playlist to storage and returns its signed URL
+func uploadInputPlaylist(outputStorageURL *url.URL, playlist string) (*url.URL, error) {
+	err := backoff.Retry(func() error {
+		return UploadToOSURL(outputStorageURL.String(), "", strings.NewReader(playlist), ManifestUploadTimeout)
+	}, UploadRetryBackoff())
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload rendition playlist: %w", err)
+	}
+	manifestURL, err := SignURL(outputStorageURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign manifest url: %w", err)
+	}
+	newURL, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new manifest URL: %w", err)
+	}
+	return newURL, nil
+}
@@ -0,0 +1,97 @@
+package clients
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRecording(t *testing.T, dir, manifest string, segments ...string) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(manifest), 0644))
+	for _, segment := range segments {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, segment), []byte{}, 0644))
+	}
+}
+
+func TestRepairRecording(t *testing.T) {
+	dir := t.TempDir()
+	config.StorageFallbackURLs = map[string]string{filepath.Join(dir, "primary"): filepath.Join(dir, "backup")}
+	defer func() { config.StorageFallbackURLs = nil }()
+
+	// 1.ts is only on the backup store, 2.ts is lost, 4.ts is listed nowhere and 5.ts is only listed in the backup
+	writeRecording(t, filepath.Join(dir, "primary"), `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXTINF:2.000,
+0.ts
+#EXTINF:2.000,
+1.ts
+#EXTINF:2.000,
+2.ts
+#EXTINF:2.000,
+3.ts
+`, "0.ts", "3.ts")
+	writeRecording(t, filepath.Join(dir, "backup"), `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXTINF:2.000,
+0.ts
+#EXTINF:2.000,
+1.ts
+#EXTINF:2.000,
+5.ts
+`, "1.ts", "5.ts")
+
+	primary := toUrl(t, filepath.Join(dir, "primary", "index.m3u8"))
+	repairedURL, repair, err := RepairRecording("requestID", primary, toUrl(t, filepath.Join(dir, "transfer")))
+	require.NoError(t, err)
+	require.Equal(t, RecordingRepair{MissingSegments: []int{2, 4}, RecoveredSegments: 1}, repair)
+	require.NotEqual(t, primary, repairedURL)
+
+	file, err := os.Open(repairedURL.String())
+	require.NoError(t, err)
+	defer file.Close()
+	playlist, playlistType, err := m3u8.DecodeFrom(file, true)
+	require.NoError(t, err)
+	require.Equal(t, m3u8.MEDIA, playlistType)
+	segments := playlist.(*m3u8.MediaPlaylist).GetAllSegments()
+	require.Len(t, segments, 4)
+	expected := []struct {
+		uri           string
+		discontinuity bool
+	}{
+		{filepath.Join(dir, "primary", "0.ts"), false},
+		{filepath.Join(dir, "backup", "1.ts"), false},
+		{filepath.Join(dir, "primary", "3.ts"), true},
+		{filepath.Join(dir, "backup", "5.ts"), true},
+	}
+	for i, segment := range segments {
+		require.Equal(t, expected[i].uri, segment.URI)
+		require.Equal(t, expected[i].discontinuity, segment.Discontinuity, segment.URI)
+	}
+}
+
+func TestRepairRecordingUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	writeRecording(t, dir, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.000,\n0.ts\n#EXTINF:2.000,\n1.ts\n#EXT-X-ENDLIST\n", "0.ts", "1.ts")
+
+	primary := toUrl(t, filepath.Join(dir, "index.m3u8"))
+	repairedURL, repair, err := RepairRecording("requestID", primary, toUrl(t, filepath.Join(dir, "transfer")))
+	require.NoError(t, err)
+	require.Equal(t, primary, repairedURL)
+	require.Empty(t, repair.MissingSegments)
+}
+
+func TestRepairRecordingWithoutSegments(t *testing.T) {
+	dir := t.TempDir()
+	writeRecording(t, dir, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.000,\n0.ts\n#EXT-X-ENDLIST\n")
+
+	_, repair, err := RepairRecording("requestID", toUrl(t, filepath.Join(dir, "index.m3u8")), toUrl(t, filepath.Join(dir, "transfer")))
+	require.ErrorContains(t, err, "none of the segments")
+	require.Equal(t, []int{0}, repair.MissingSegments)
+}
@@ -0,0 +1,59 @@
+package clients
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var playlistURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// LintRelativePlaylist checks that all of the URIs in a playlist, i.e. the variant and segment lines and the URI
+// attributes of tags like EXT-X-KEY and EXT-X-MAP, are relative paths. Playlists that pass can be served from any
+// domain fronting the bucket.
+func LintRelativePlaylist(playlist string) error {
+	scanner := bufio.NewScanner(strings.NewReader(playlist))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		var uris []string
+		if strings.HasPrefix(line, "#") {
+			for _, match := range playlistURIAttribute.FindAllStringSubmatch(line, -1) {
+				uris = append(uris, match[1])
+			}
+		} else if line != "" {
+			uris = append(uris, line)
+		}
+
+		for _, uri := range uris {
+			if !isRelativeURI(uri) {
+				return fmt.Errorf("playlist line %d has a non-relative URI %q", lineNum, uri)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func isRelativeURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == "" && !strings.HasPrefix(u.Path, "/")
+}
+
+// RelativePlaylistURI returns the path of target relative to the dir of the playlist. Both URLs need to be on the
+// same host, since a relative URI can't point at a different domain.
+func RelativePlaylistURI(playlistURL, target *url.URL) (string, error) {
+	if playlistURL.Scheme != target.Scheme || playlistURL.Host != target.Host {
+		return "", fmt.Errorf("cannot reference %s relative to playlist on different host %s", target.Host, playlistURL.Host)
+	}
+	rel, err := filepath.Rel(path.Dir(playlistURL.Path), target.Path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
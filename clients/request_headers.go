@@ -0,0 +1,58 @@
+package clients
+
+import (
+	"net/http"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// RequestIDHeader carries the ID of the request an outgoing call is made for, to correlate our logs with those of the
+// services we call
+const RequestIDHeader = "X-Request-ID"
+
+// UserAgent identifies us and our version to the services we call
+func UserAgent() string {
+	return "catalyst-api/" + config.Version
+}
+
+// requestHeadersTransport sets the user agent and, when the context of the request carries one (see
+// log.WithRequestID), the request ID header of every outgoing request that doesn't set them itself
+type requestHeadersTransport struct {
+	next http.RoundTripper
+}
+
+// NewRequestHeadersTransport wraps a transport, http.DefaultTransport when nil, to set the request ID and user agent
+// headers of the outgoing requests
+func NewRequestHeadersTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if _, ok := next.(requestHeadersTransport); ok {
+		return next
+	}
+	return requestHeadersTransport{next: next}
+}
+
+func (t requestHeadersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestID := log.RequestIDFromContext(req.Context())
+	setRequestID := requestID != "" && req.Header.Get(RequestIDHeader) == ""
+	setUserAgent := req.Header.Get("User-Agent") == ""
+	if setRequestID || setUserAgent {
+		// a RoundTripper mustn't modify the request it's given
+		req = req.Clone(req.Context())
+		if setRequestID {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		if setUserAgent {
+			req.Header.Set("User-Agent", UserAgent())
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// withRequestHeaders makes a client set the request ID and user agent headers of its requests
+func withRequestHeaders(client *http.Client) *http.Client {
+	client.Transport = NewRequestHeadersTransport(client.Transport)
+	return client
+}
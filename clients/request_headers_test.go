@@ -0,0 +1,46 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestHeadersTransport(t *testing.T) {
+	var headers http.Header
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+	}))
+	defer svr.Close()
+	client := withRequestHeaders(&http.Client{})
+	defer func(version string) { config.Version = version }(config.Version)
+	config.Version = "1.2.3"
+
+	// The request ID is taken from the context
+	req, err := http.NewRequestWithContext(log.WithRequestID(context.Background(), "req-123"), http.MethodGet, svr.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "req-123", headers.Get(RequestIDHeader))
+	require.Equal(t, "catalyst-api/1.2.3", headers.Get("User-Agent"))
+	require.Empty(t, req.Header.Get(RequestIDHeader), "the request passed in shouldn't be modified")
+
+	// Headers set by the caller are kept, and there's no request ID header without one in the context
+	req, err = http.NewRequest(http.MethodGet, svr.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "custom")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Empty(t, headers.Get(RequestIDHeader))
+	require.Equal(t, "custom", headers.Get("User-Agent"))
+
+	// Wrapping twice doesn't set the headers twice
+	require.Equal(t, client.Transport, NewRequestHeadersTransport(client.Transport))
+}
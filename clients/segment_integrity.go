@@ -0,0 +1,185 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// How many segments are checked at once
+const segmentIntegrityWorkers = 8
+
+// CheckSourceSegments checks the integrity of the MPEG-TS segments of an HLS source before it's transcoded. Skipping the
+// corrupt segments writes a playlist without them, with a discontinuity where they were, to the transfer location and
+// returns its URL. Otherwise the source URL is returned as is, the caller deciding what to do with the corrupt
+// segments from the report.
+func CheckSourceSegments(ctx context.Context, requestID string, manifestURL, osTransferURL *url.URL, check video.SegmentIntegrityCheck) (*url.URL, *video.SegmentIntegrityReport, error) {
+	report := &video.SegmentIntegrityReport{Mode: video.SegmentIntegritySampled}
+	if check.Full() {
+		report.Mode = video.SegmentIntegrityFull
+	}
+	playlist, err := DownloadRenditionManifest(requestID, manifestURL.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error downloading manifest: %w", err)
+	}
+	if playlist.Map != nil {
+		// fMP4 segments, which aren't MPEG-TS
+		log.Log(requestID, "skipping the segment integrity check of a source with fMP4 segments")
+		return manifestURL, report, nil
+	}
+	segmentURLs, err := GetSourceSegmentURLs(manifestURL.String(), playlist)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting segment URLs: %w", err)
+	}
+	report.CheckedSegments = len(segmentURLs)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		indices  = make(chan int)
+	)
+	for w := 0; w < segmentIntegrityWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				reason, err := checkSourceSegment(ctx, requestID, segmentURLs[i], check.Full())
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("error checking segment %d: %w", i, err)
+				} else if reason != nil {
+					report.CorruptSegments = append(report.CorruptSegments, video.CorruptSegment{Index: i, Reason: reason.Error()})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range segmentURLs {
+		if ctx.Err() != nil {
+			break
+		}
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	sort.Slice(report.CorruptSegments, func(i, j int) bool {
+		return report.CorruptSegments[i].Index < report.CorruptSegments[j].Index
+	})
+	if len(report.CorruptSegments) == 0 {
+		return manifestURL, report, nil
+	}
+	log.Log(requestID, "found corrupt source segments", "corrupt_segments", len(report.CorruptSegments), "checked_segments", report.CheckedSegments, "first_reason", report.CorruptSegments[0].Reason)
+	if check.Action() != video.SegmentIntegritySkip {
+		return manifestURL, report, nil
+	}
+
+	if len(report.CorruptSegments) == len(segmentURLs) {
+		return nil, report, fmt.Errorf("all the %d segments of the source are corrupt", len(segmentURLs))
+	}
+	newURL, err := skipCorruptSegments(playlist, segmentURLs, report.CorruptSegments, osTransferURL)
+	if err != nil {
+		return nil, report, err
+	}
+	report.Skipped = true
+	return newURL, report, nil
+}
+
+// checkSourceSegment returns why a segment is corrupt, or an error when it couldn't be read
+func checkSourceSegment(ctx context.Context, requestID string, segment SourceSegment, full bool) (corrupt error, err error) {
+	offset, length := segment.Offset, segment.Length
+	if !full && (length <= 0 || length > video.SegmentIntegritySampleBytes) {
+		length = video.SegmentIntegritySampleBytes
+	}
+	err = backoff.Retry(func() error {
+		ctx, cancel := context.WithTimeout(ctx, MaxCopyFileDuration)
+		defer cancel()
+		rc, err := GetFileRange(ctx, requestID, segment.URL.String(), nil, offset, length)
+		if errors.IsObjectNotFound(err) {
+			corrupt = fmt.Errorf("segment not found")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if !full {
+			sample, err := io.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			// a sample shorter than asked for is the whole segment
+			corrupt = video.CheckTSIntegrity(bytes.NewReader(sample), len(sample) < video.SegmentIntegritySampleBytes)
+			return nil
+		}
+		r := &readErrTracker{r: rc}
+		corrupt = video.CheckTSIntegrity(r, true)
+		// the download failing isn't the segment being corrupt
+		return r.err
+	}, backoff.WithContext(DownloadRetryBackoff(), ctx))
+	return corrupt, err
+}
+
+// readErrTracker keeps the read errors other than the end of the file
+type readErrTracker struct {
+	r   io.Reader
+	err error
+}
+
+func (t *readErrTracker) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+// skipCorruptSegments writes the playlist without its corrupt segments to the transfer location and returns its URL
+func skipCorruptSegments(playlist m3u8.MediaPlaylist, segmentURLs []SourceSegment, corrupt []video.CorruptSegment, osTransferURL *url.URL) (*url.URL, error) {
+	skip := map[int]bool{}
+	for _, c := range corrupt {
+		skip[c.Index] = true
+	}
+	segments := playlist.GetAllSegments()
+	kept, err := m3u8.NewMediaPlaylist(0, uint(len(segments)-len(skip)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create playlist: %w", err)
+	}
+	gap := false
+	for i, s := range segments {
+		if skip[i] {
+			gap = true
+			continue
+		}
+		segment := *s
+		// The playlist is stored elsewhere, so its segments are referenced by their absolute URLs
+		segment.URI = segmentURLs[i].URL.String()
+		segment.Discontinuity = segment.Discontinuity || (gap && kept.Count() > 0)
+		gap = false
+		if segment.Limit > 0 && kept.Version() < 4 {
+			kept.SetVersion(4)
+		}
+		if err := kept.AppendSegment(&segment); err != nil {
+			return nil, fmt.Errorf("failed to append segment to playlist: %w", err)
+		}
+	}
+	kept.MediaType = m3u8.VOD
+	kept.Close()
+	return uploadInputPlaylist(osTransferURL.JoinPath("checked.m3u8"), kept.String())
+}
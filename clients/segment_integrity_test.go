@@ -0,0 +1,77 @@
+package clients
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/video"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSegmentsSource(t *testing.T, dir string) {
+	segment, err := os.ReadFile("../test/fixtures/seg-0.ts")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0.ts"), segment, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2.ts"), segment, 0644))
+	corrupt := append([]byte{}, segment...)
+	corrupt[188*3] = 0
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.ts"), corrupt, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXTINF:2.000,
+0.ts
+#EXTINF:2.000,
+1.ts
+#EXTINF:2.000,
+2.ts
+#EXT-X-ENDLIST
+`), 0644))
+}
+
+func TestCheckSourceSegments(t *testing.T) {
+	dir := t.TempDir()
+	writeSegmentsSource(t, dir)
+	source := toUrl(t, filepath.Join(dir, "index.m3u8"))
+	transfer := toUrl(t, filepath.Join(dir, "transfer"))
+
+	for _, mode := range []string{video.SegmentIntegritySampled, video.SegmentIntegrityFull} {
+		checkedURL, report, err := CheckSourceSegments(context.Background(), "requestID", source, transfer, video.SegmentIntegrityCheck{Mode: mode})
+		require.NoError(t, err)
+		require.Equal(t, source, checkedURL)
+		require.Equal(t, mode, report.Mode)
+		require.Equal(t, 3, report.CheckedSegments)
+		require.Equal(t, []video.CorruptSegment{{Index: 1, Reason: "lost sync at byte 564"}}, report.CorruptSegments)
+		require.False(t, report.Skipped)
+	}
+
+	checkedURL, report, err := CheckSourceSegments(context.Background(), "requestID", source, transfer, video.SegmentIntegrityCheck{OnCorrupt: video.SegmentIntegritySkip})
+	require.NoError(t, err)
+	require.True(t, report.Skipped)
+	require.NotEqual(t, source, checkedURL)
+
+	file, err := os.Open(checkedURL.String())
+	require.NoError(t, err)
+	defer file.Close()
+	playlist, _, err := m3u8.DecodeFrom(file, true)
+	require.NoError(t, err)
+	segments := playlist.(*m3u8.MediaPlaylist).GetAllSegments()
+	require.Len(t, segments, 2)
+	require.Equal(t, filepath.Join(dir, "0.ts"), segments[0].URI)
+	require.False(t, segments[0].Discontinuity)
+	require.Equal(t, filepath.Join(dir, "2.ts"), segments[1].URI)
+	require.True(t, segments[1].Discontinuity)
+}
+
+func TestCheckSourceSegmentsMissingSegment(t *testing.T) {
+	dir := t.TempDir()
+	writeSegmentsSource(t, dir)
+	require.NoError(t, os.Remove(filepath.Join(dir, "2.ts")))
+
+	_, report, err := CheckSourceSegments(context.Background(), "requestID", toUrl(t, filepath.Join(dir, "index.m3u8")), toUrl(t, filepath.Join(dir, "transfer")), video.SegmentIntegrityCheck{})
+	require.NoError(t, err)
+	require.Equal(t, []video.CorruptSegment{{Index: 1, Reason: "lost sync at byte 564"}, {Index: 2, Reason: "segment not found"}}, report.CorruptSegments)
+}
@@ -0,0 +1,377 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/miekg/dns"
+)
+
+// Prefixes of the schemes of the URLs of services discovered through DNS, e.g.
+// dnssrv+http://_http._tcp.gate.default.svc.cluster.local/api/access-control/gate looks up the SRV records of the host
+// and dns+http://broadcaster.default.svc.cluster.local:8935 its A and AAAA records, for the port of the URL
+const (
+	DNSSRVDiscoveryPrefix = "dnssrv+"
+	DNSDiscoveryPrefix    = "dns+"
+)
+
+const (
+	// The records are looked up again once their TTL expires, within these bounds
+	minDiscoveryRefresh = 5 * time.Second
+	maxDiscoveryRefresh = 5 * time.Minute
+	// Number of consecutive failed requests after which an endpoint is excluded until it passes a health check
+	DiscoveryMaxConsecutiveFailures = 3
+)
+
+var ErrNoDiscoveredEndpoints = errors.New("no endpoints discovered")
+
+// IsServiceDiscoveryURL returns whether the URL is of a service discovered through DNS
+func IsServiceDiscoveryURL(serviceURL string) bool {
+	return strings.HasPrefix(serviceURL, DNSSRVDiscoveryPrefix) || strings.HasPrefix(serviceURL, DNSDiscoveryPrefix)
+}
+
+// resolvedTarget is a host and port of a service, from a DNS record
+type resolvedTarget struct {
+	host     string
+	port     int
+	priority uint16
+}
+
+type resolveFunc func(ctx context.Context, srv bool, name string) ([]resolvedTarget, time.Duration, error)
+
+type discoveredEndpoint struct {
+	url                 string
+	priority            uint16
+	consecutiveFailures int
+	excluded            bool
+}
+
+// ServiceDiscovery keeps the endpoints of a service up to date from its DNS records, refreshed as their TTL expires,
+// and balances the requests across the healthy ones. Of SRV records, only the ones of the lowest priority are used
+// while any of them is healthy, their weights are ignored. Endpoints failing several requests in a row are excluded
+// until they pass a health check again.
+type ServiceDiscovery struct {
+	name     string
+	srv      bool
+	template url.URL
+	resolve  resolveFunc
+
+	healthClient *http.Client
+
+	mu        sync.Mutex
+	endpoints []*discoveredEndpoint
+	ttl       time.Duration
+	next      uint32
+}
+
+// NewServiceDiscovery returns the discovery of the service at the dnssrv+ or dns+ URL. The endpoints are the URL with
+// the host and port of each of the records, and its path and query. Refresh has to be called before picking one.
+func NewServiceDiscovery(name, serviceURL string) (*ServiceDiscovery, error) {
+	srv := strings.HasPrefix(serviceURL, DNSSRVDiscoveryPrefix)
+	if !srv && !strings.HasPrefix(serviceURL, DNSDiscoveryPrefix) {
+		return nil, fmt.Errorf("%s URL %q isn't discovered through DNS", name, log.RedactURL(serviceURL))
+	}
+	u, err := url.Parse(strings.TrimPrefix(strings.TrimPrefix(serviceURL, DNSSRVDiscoveryPrefix), DNSDiscoveryPrefix))
+	if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid %s URL %q", name, log.RedactURL(serviceURL))
+	}
+	return &ServiceDiscovery{
+		name:         name,
+		srv:          srv,
+		template:     *u,
+		resolve:      resolveDNS,
+		healthClient: withRequestHeaders(&http.Client{Timeout: 5 * time.Second}),
+	}, nil
+}
+
+// String lists the redacted discovered URLs, marking the excluded ones
+func (d *ServiceDiscovery) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var urls []string
+	for _, e := range d.endpoints {
+		u := log.RedactURL(e.url)
+		if e.excluded {
+			u += " (excluded)"
+		}
+		urls = append(urls, u)
+	}
+	return strings.Join(urls, ", ")
+}
+
+// Refresh looks up the records of the service again. The endpoints still listed keep their health, and the previous
+// ones are kept when the lookup fails or returns none.
+func (d *ServiceDiscovery) Refresh(ctx context.Context) error {
+	targets, ttl, err := d.resolve(ctx, d.srv, d.template.Hostname())
+	if err == nil && len(targets) == 0 {
+		err = ErrNoDiscoveredEndpoints
+	}
+	if err != nil {
+		return fmt.Errorf("error discovering the %s endpoints: %w", d.name, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	known := map[string]*discoveredEndpoint{}
+	for _, e := range d.endpoints {
+		known[e.url] = e
+	}
+	var endpoints []*discoveredEndpoint
+	for _, t := range targets {
+		u := d.endpointURL(t)
+		e, ok := known[u]
+		if !ok {
+			e = &discoveredEndpoint{url: u}
+			log.LogNoRequestID("discovered endpoint", "service", d.name, "url", log.RedactURL(u))
+		}
+		e.priority = t.priority
+		endpoints = append(endpoints, e)
+		delete(known, u)
+	}
+	for u := range known {
+		log.LogNoRequestID("endpoint gone from the records", "service", d.name, "url", log.RedactURL(u))
+	}
+	// Ordered, so that the same keys go to the same endpoints on every node
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		if endpoints[i].priority != endpoints[j].priority {
+			return endpoints[i].priority < endpoints[j].priority
+		}
+		return endpoints[i].url < endpoints[j].url
+	})
+	d.endpoints = endpoints
+	d.ttl = ttl
+	return nil
+}
+
+func (d *ServiceDiscovery) endpointURL(t resolvedTarget) string {
+	u := d.template
+	port := t.port
+	if port == 0 {
+		port, _ = strconv.Atoi(d.template.Port())
+	}
+	if port == 0 {
+		u.Host = t.host
+		if strings.Contains(t.host, ":") {
+			u.Host = "[" + t.host + "]"
+		}
+	} else {
+		u.Host = net.JoinHostPort(t.host, strconv.Itoa(port))
+	}
+	return u.String()
+}
+
+// Pick returns the URL of the endpoint to send a request to. Requests with the same key go to the same endpoint
+// while it's healthy, the others are spread across the healthy endpoints in turn.
+func (d *ServiceDiscovery) Pick(key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.endpoints) == 0 {
+		return "", fmt.Errorf("%s: %w", d.name, ErrNoDiscoveredEndpoints)
+	}
+
+	var candidates []*discoveredEndpoint
+	for _, e := range d.endpoints {
+		if !e.excluded && (len(candidates) == 0 || e.priority == candidates[0].priority) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		// Better to try an unhealthy endpoint than to fail the request outright
+		candidates = d.endpoints
+	}
+
+	var i uint32
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key)) // nolint:errcheck
+		i = h.Sum32()
+	} else {
+		i = d.next
+		d.next++
+	}
+	return candidates[i%uint32(len(candidates))].url, nil
+}
+
+// RecordResult records the outcome of a request to an endpoint, excluding it after consecutive failures
+func (d *ServiceDiscovery) RecordResult(endpointURL string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.endpoints {
+		if e.url != endpointURL {
+			continue
+		}
+		if err == nil {
+			e.consecutiveFailures = 0
+			return
+		}
+		e.consecutiveFailures++
+		if e.consecutiveFailures >= DiscoveryMaxConsecutiveFailures && !e.excluded {
+			e.excluded = true
+			log.LogNoRequestID("excluding endpoint after consecutive failures", "service", d.name, "url", log.RedactURL(e.url), "failures", e.consecutiveFailures, "err", err)
+		}
+		return
+	}
+}
+
+// Run refreshes the endpoints as their records expire and periodically checks that they're reachable, excluding the
+// ones that aren't and bringing back the excluded ones once they respond again
+func (d *ServiceDiscovery) Run(ctx context.Context, healthInterval time.Duration) {
+	refresh := time.NewTimer(d.refreshIn(nil))
+	defer refresh.Stop()
+	// no health checks without an interval
+	var health <-chan time.Time
+	if healthInterval > 0 {
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+		health = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh.C:
+			err := d.Refresh(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.LogNoRequestID("failed to refresh the discovered endpoints, keeping the previous ones", "service", d.name, "err", err)
+			}
+			refresh.Reset(d.refreshIn(err))
+		case <-health:
+			d.checkHealth(ctx)
+		}
+	}
+}
+
+// refreshIn returns when the records are next looked up, once their TTL expires or soon after a failed lookup
+func (d *ServiceDiscovery) refreshIn(err error) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		return minDiscoveryRefresh
+	}
+	return min(max(d.ttl, minDiscoveryRefresh), maxDiscoveryRefresh)
+}
+
+func (d *ServiceDiscovery) checkHealth(ctx context.Context) {
+	d.mu.Lock()
+	endpoints := append([]*discoveredEndpoint{}, d.endpoints...)
+	d.mu.Unlock()
+	for _, e := range endpoints {
+		healthy := d.isHealthy(ctx, e.url)
+		if ctx.Err() != nil {
+			return
+		}
+		d.mu.Lock()
+		if healthy && e.excluded {
+			log.LogNoRequestID("endpoint healthy again", "service", d.name, "url", log.RedactURL(e.url))
+		} else if !healthy && !e.excluded {
+			log.LogNoRequestID("excluding endpoint after failed health check", "service", d.name, "url", log.RedactURL(e.url))
+		}
+		e.excluded = !healthy
+		if healthy {
+			e.consecutiveFailures = 0
+		}
+		d.mu.Unlock()
+	}
+}
+
+// isHealthy checks that the endpoint's HTTP server is up. Any response that isn't a server error is good enough, as
+// the endpoints may only serve other methods than GET.
+func (d *ServiceDiscovery) isHealthy(ctx context.Context, endpointURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpointURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := d.healthClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+// Where the nameservers and search domains are read from, like the system resolver
+const resolvConfPath = "/etc/resolv.conf"
+
+// resolveDNS looks up the SRV, or A and AAAA, records of the name with the nameservers of the system, returning them
+// with the lowest of their TTLs. The name is looked up with the search domains like the system resolver does.
+func resolveDNS(ctx context.Context, srv bool, name string) ([]resolvedTarget, time.Duration, error) {
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading the DNS config: %w", err)
+	}
+	return resolveDNSWith(ctx, conf, srv, name)
+}
+
+func resolveDNSWith(ctx context.Context, conf *dns.ClientConfig, srv bool, name string) ([]resolvedTarget, time.Duration, error) {
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	if srv {
+		qtypes = []uint16{dns.TypeSRV}
+	}
+
+	var lastErr error = fmt.Errorf("no records for %s", name)
+	for _, fqdn := range conf.NameList(name) {
+		var targets []resolvedTarget
+		var ttl uint32
+		for _, qtype := range qtypes {
+			answers, err := exchangeDNS(ctx, conf, fqdn, qtype)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			for _, rr := range answers {
+				var t resolvedTarget
+				switch r := rr.(type) {
+				case *dns.SRV:
+					t = resolvedTarget{host: strings.TrimSuffix(r.Target, "."), port: int(r.Port), priority: r.Priority}
+				case *dns.A:
+					t = resolvedTarget{host: r.A.String()}
+				case *dns.AAAA:
+					t = resolvedTarget{host: r.AAAA.String()}
+				default:
+					continue
+				}
+				if ttl == 0 || rr.Header().Ttl < ttl {
+					ttl = rr.Header().Ttl
+				}
+				targets = append(targets, t)
+			}
+		}
+		if len(targets) > 0 {
+			return targets, time.Duration(ttl) * time.Second, nil
+		}
+	}
+	return nil, 0, lastErr
+}
+
+func exchangeDNS(ctx context.Context, conf *dns.ClientConfig, fqdn string, qtype uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, qtype)
+	lastErr := errors.New("no nameservers configured")
+	for _, server := range conf.Servers {
+		addr := net.JoinHostPort(server, conf.Port)
+		resp, _, err := (&dns.Client{Net: "udp"}).ExchangeContext(ctx, m, addr)
+		if err == nil && resp.Truncated {
+			resp, _, err = (&dns.Client{Net: "tcp"}).ExchangeContext(ctx, m, addr)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("looking up %s: %s", fqdn, dns.RcodeToString[resp.Rcode])
+		}
+		return resp.Answer, nil
+	}
+	return nil, fmt.Errorf("looking up %s: %w", fqdn, lastErr)
+}
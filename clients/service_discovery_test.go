@@ -0,0 +1,172 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiscovery(t *testing.T, serviceURL string, targets *[]resolvedTarget, resolveErr *error) *ServiceDiscovery {
+	d, err := NewServiceDiscovery("test", serviceURL)
+	require.NoError(t, err)
+	d.resolve = func(_ context.Context, _ bool, _ string) ([]resolvedTarget, time.Duration, error) {
+		return *targets, 30 * time.Second, *resolveErr
+	}
+	return d
+}
+
+func TestServiceDiscoveryURLs(t *testing.T) {
+	require.True(t, IsServiceDiscoveryURL("dnssrv+http://_http._tcp.gate/api/gate"))
+	require.True(t, IsServiceDiscoveryURL("dns+http://broadcaster:8935"))
+	require.False(t, IsServiceDiscoveryURL("http://127.0.0.1:8935"))
+
+	_, err := NewServiceDiscovery("test", "http://127.0.0.1:8935")
+	require.Error(t, err)
+	_, err = NewServiceDiscovery("test", "dns+ftp://broadcaster")
+	require.Error(t, err)
+
+	var resolveErr error
+	targets := []resolvedTarget{{host: "10.0.0.1"}, {host: "fd00::1"}}
+	d := newTestDiscovery(t, "dns+http://broadcaster:8935/live?x=1", &targets, &resolveErr)
+	require.NoError(t, d.Refresh(context.Background()))
+	require.Equal(t, "http://10.0.0.1:8935/live?x=1, http://[fd00::1]:8935/live?x=1", d.String())
+
+	// the ports of SRV records are used
+	targets = []resolvedTarget{{host: "gate-1.gate.svc", port: 3004}}
+	d = newTestDiscovery(t, "dnssrv+https://_http._tcp.gate.svc/api/access-control/gate", &targets, &resolveErr)
+	require.NoError(t, d.Refresh(context.Background()))
+	require.Equal(t, "https://gate-1.gate.svc:3004/api/access-control/gate", d.String())
+}
+
+func TestServiceDiscoveryPick(t *testing.T) {
+	var resolveErr error
+	targets := []resolvedTarget{
+		{host: "b", port: 80, priority: 10},
+		{host: "a", port: 80, priority: 10},
+		{host: "backup", port: 80, priority: 20},
+	}
+	d := newTestDiscovery(t, "dnssrv+http://_http._tcp.broadcaster", &targets, &resolveErr)
+	_, err := d.Pick("")
+	require.ErrorIs(t, err, ErrNoDiscoveredEndpoints)
+	require.NoError(t, d.Refresh(context.Background()))
+
+	// spread across the endpoints of the lowest priority in turn
+	first, err := d.Pick("")
+	require.NoError(t, err)
+	second, err := d.Pick("")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"http://a:80", "http://b:80"}, []string{first, second})
+
+	// the same key goes to the same endpoint
+	sticky, err := d.Pick("manifest-1")
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		u, _ := d.Pick("manifest-1")
+		require.Equal(t, sticky, u)
+	}
+
+	// excluded after consecutive failures, falling back to the next priority
+	for _, u := range []string{"http://a:80", "http://b:80"} {
+		for i := 0; i < DiscoveryMaxConsecutiveFailures; i++ {
+			d.RecordResult(u, errors.New("failed"))
+		}
+	}
+	u, err := d.Pick("manifest-1")
+	require.NoError(t, err)
+	require.Equal(t, "http://backup:80", u)
+
+	// the health of the endpoints is kept across refreshes, and the endpoints across failed ones
+	require.NoError(t, d.Refresh(context.Background()))
+	u, _ = d.Pick("")
+	require.Equal(t, "http://backup:80", u)
+	resolveErr = errors.New("SERVFAIL")
+	require.Error(t, d.Refresh(context.Background()))
+	targets, resolveErr = nil, nil
+	require.ErrorIs(t, d.Refresh(context.Background()), ErrNoDiscoveredEndpoints)
+	require.Contains(t, d.String(), "http://a:80 (excluded)")
+
+	// all excluded, still tried
+	d.RecordResult("http://backup:80", errors.New("failed"))
+	d.RecordResult("http://backup:80", errors.New("failed"))
+	d.RecordResult("http://backup:80", errors.New("failed"))
+	_, err = d.Pick("")
+	require.NoError(t, err)
+}
+
+func TestServiceDiscoveryHealthChecks(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	var resolveErr error
+	targets := []resolvedTarget{}
+	for _, s := range []*httptest.Server{healthy, unhealthy} {
+		host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+		p, _ := net.LookupPort("tcp", port)
+		targets = append(targets, resolvedTarget{host: host, port: p})
+	}
+	d := newTestDiscovery(t, "dnssrv+http://_http._tcp.gate/gate", &targets, &resolveErr)
+	require.NoError(t, d.Refresh(context.Background()))
+	d.checkHealth(context.Background())
+	for i := 0; i < 4; i++ {
+		u, err := d.Pick("")
+		require.NoError(t, err)
+		require.Equal(t, healthy.URL+"/gate", u)
+	}
+}
+
+func TestResolveDNS(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Name == "_http._tcp.gate.svc.cluster.local." && q.Qtype == dns.TypeSRV:
+			m.Answer = append(m.Answer,
+				&dns.SRV{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30}, Priority: 10, Weight: 1, Port: 3004, Target: "gate-1.gate.svc.cluster.local."},
+				&dns.SRV{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 10}, Priority: 20, Weight: 1, Port: 3004, Target: "gate-2.gate.svc.cluster.local."},
+			)
+		case q.Name == "broadcaster.svc.cluster.local." && q.Qtype == dns.TypeA:
+			m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("10.0.0.1")})
+		case q.Name == "broadcaster.svc.cluster.local.":
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m) // nolint:errcheck
+	})}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe() // nolint:errcheck
+	defer server.Shutdown()      // nolint:errcheck
+	<-started
+
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	conf := &dns.ClientConfig{Servers: []string{"127.0.0.1"}, Port: port, Search: []string{"svc.cluster.local"}, Ndots: 5, Timeout: 1, Attempts: 1}
+
+	targets, ttl, err := resolveDNSWith(context.Background(), conf, true, "_http._tcp.gate")
+	require.NoError(t, err)
+	require.Equal(t, []resolvedTarget{{host: "gate-1.gate.svc.cluster.local", port: 3004, priority: 10}, {host: "gate-2.gate.svc.cluster.local", port: 3004, priority: 20}}, targets)
+	require.Equal(t, 10*time.Second, ttl)
+
+	targets, ttl, err = resolveDNSWith(context.Background(), conf, false, "broadcaster")
+	require.NoError(t, err)
+	require.Equal(t, []resolvedTarget{{host: "10.0.0.1"}}, targets)
+	require.Equal(t, time.Minute, ttl)
+
+	_, _, err = resolveDNSWith(context.Background(), conf, false, "missing")
+	require.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// StorageProblem is a stable classification of why an output location can't be written to. The values are part of
+// the API response so they must not be changed.
+type StorageProblem string
+
+const (
+	StorageProblemInvalidURL     StorageProblem = "invalid_url"
+	StorageProblemAuth           StorageProblem = "auth"
+	StorageProblemPermissions    StorageProblem = "permissions"
+	StorageProblemRegionMismatch StorageProblem = "region_mismatch"
+	StorageProblemNoBucket       StorageProblem = "bucket_not_found"
+	StorageProblemUnreachable    StorageProblem = "unreachable"
+	StorageProblemUnknown        StorageProblem = "unknown"
+)
+
+// Provider error codes, by the problem they point at
+var storageProblemCodes = map[string]StorageProblem{
+	"InvalidAccessKeyId":                 StorageProblemAuth,
+	"SignatureDoesNotMatch":              StorageProblemAuth,
+	"ExpiredToken":                       StorageProblemAuth,
+	"InvalidToken":                       StorageProblemAuth,
+	"NoCredentialProviders":              StorageProblemAuth,
+	"AccessDenied":                       StorageProblemPermissions,
+	"AllAccessDisabled":                  StorageProblemPermissions,
+	"AuthorizationHeaderMalformed":       StorageProblemRegionMismatch,
+	"PermanentRedirect":                  StorageProblemRegionMismatch,
+	"IllegalLocationConstraintException": StorageProblemRegionMismatch,
+	"NoSuchBucket":                       StorageProblemNoBucket,
+}
+
+const storageCheckTimeout = 30 * time.Second
+
+// Remote object stores that API requests can point at, by whether the bucket is the first path segment (S3) or the
+// host (GCS, Azure). Local files are left out so that a request can't touch the filesystem of the node.
+var objectStoreSchemes = map[string]bool{
+	"s3":              true,
+	"s3+http":         true,
+	"s3+https":        true,
+	"gs":              false,
+	SCHEME_AZURE_BLOB: false,
+}
+
+// ObjectStoreKey checks that the URL points to a remote object store and returns the path inside its bucket
+func ObjectStoreKey(osURL string) (string, error) {
+	u, err := url.Parse(osURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid storage URL %q: %w", log.RedactURL(osURL), err)
+	}
+	bucketInPath, ok := objectStoreSchemes[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("storage URL %q is not an object store URL", log.RedactURL(osURL))
+	}
+	key := strings.Trim(u.Path, "/")
+	if bucketInPath {
+		_, key, _ = strings.Cut(key, "/")
+	}
+	return key, nil
+}
+
+// StorageCheck is the outcome of checking that an output location can be written to
+type StorageCheck struct {
+	URL string `json:"url"`
+	OK  bool   `json:"ok"`
+	// Operation that failed, write or delete
+	Operation string         `json:"operation,omitempty"`
+	Problem   StorageProblem `json:"problem,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// CheckStorage writes a tiny test object to the output location and deletes it again, so that bad credentials or
+// permissions are reported before a job is started instead of when it uploads its first outputs. Only object store
+// URLs are accepted, see ObjectStoreKey.
+func CheckStorage(ctx context.Context, requestID, osURL string) StorageCheck {
+	check := StorageCheck{URL: log.RedactURL(osURL)}
+	fail := func(operation string, err error) StorageCheck {
+		check.Operation, check.Problem, check.Error = operation, ClassifyStorageProblem(err), err.Error()
+		log.Log(requestID, "storage check failed", "url", check.URL, "operation", operation, "problem", check.Problem, "err", err)
+		return check
+	}
+
+	if _, err := ObjectStoreKey(osURL); err != nil {
+		check.Problem, check.Error = StorageProblemInvalidURL, err.Error()
+		return check
+	}
+	storageDriver, err := ParseOSURL(osURL, true)
+	if err != nil {
+		check.Problem, check.Error = StorageProblemInvalidURL, err.Error()
+		return check
+	}
+	deleteFile := storageDriver.NewSession("").DeleteFile
+	name := ".catalyst-storage-check-" + config.RandomTrailer(8)
+	if err := UploadToOSURLFields(ctx, osURL, name, strings.NewReader("ok"), storageCheckTimeout, nil); err != nil {
+		return fail("write", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, storageCheckTimeout)
+	defer cancel()
+	if err := deleteFile(ctx, name); err != nil {
+		return fail("delete", fmt.Errorf("failed to delete storage check object: %w", err))
+	}
+	check.OK = true
+	return check
+}
+
+// ClassifyStorageProblem tells what's wrong with an output location from the error of a storage operation
+func ClassifyStorageProblem(err error) StorageProblem {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		if problem, ok := storageProblemCodes[awsErr.Code()]; ok {
+			return problem
+		}
+	}
+
+	switch storageErrorStatusCode(err) {
+	case http.StatusUnauthorized:
+		return StorageProblemAuth
+	case http.StatusForbidden:
+		return StorageProblemPermissions
+	case http.StatusMovedPermanently, http.StatusTemporaryRedirect:
+		return StorageProblemRegionMismatch
+	case http.StatusNotFound:
+		return StorageProblemNoBucket
+	}
+
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || errors.As(err, &opErr) || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return StorageProblemUnreachable
+	}
+	return StorageProblemUnknown
+}
@@ -0,0 +1,177 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// HTTPStatusError is returned when a storage or import request responds with an unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	// How long the server asked us to wait before retrying, 0 if it didn't say
+	RetryAfter time.Duration
+	msg        string
+}
+
+func (e HTTPStatusError) Error() string {
+	return e.msg
+}
+
+// Providers that need special handling, see storageQuirks
+const (
+	ProviderR2 = "r2"
+	ProviderB2 = "b2"
+	// Azure Blob Storage, see azureBlobLocation
+	ProviderAzure = "azure"
+)
+
+// StorageProvider returns a low cardinality name for the storage backend behind the URL, used to label metrics
+func StorageProvider(u *url.URL) string {
+	switch u.Scheme {
+	case "s3":
+		return "aws"
+	case "gs":
+		return "gcs"
+	case "s3+http", "s3+https":
+		host := u.Hostname()
+		switch {
+		case strings.HasSuffix(host, "amazonaws.com"):
+			return "aws"
+		case strings.HasSuffix(host, "storage.googleapis.com"):
+			return "gcs"
+		case strings.Contains(host, "storj"):
+			return "storj"
+		case strings.HasSuffix(host, ".r2.cloudflarestorage.com"):
+			return ProviderR2
+		case strings.HasSuffix(host, ".backblazeb2.com"):
+			return ProviderB2
+		default:
+			return "s3-compatible"
+		}
+	case SCHEME_IPFS:
+		return "ipfs"
+	case SCHEME_ARWEAVE:
+		return "arweave"
+	case SCHEME_AZURE_BLOB:
+		return ProviderAzure
+	case "", "file":
+		return "file"
+	case "http", "https":
+		if IsDStorageResource(u.String()) {
+			return "dstorage-gateway"
+		}
+		return "http"
+	}
+	return u.Scheme
+}
+
+// storageLabels returns the provider, host and bucket of the storage URL
+func storageLabels(osURL string) (string, string, string) {
+	u, err := url.Parse(osURL)
+	if err != nil {
+		return "unknown", "", ""
+	}
+	provider := StorageProvider(u)
+	switch u.Scheme {
+	case "s3", "s3+http", "s3+https":
+		bucket, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		return provider, u.Host, bucket
+	case "gs":
+		return provider, "storage.googleapis.com", u.Host
+	case SCHEME_AZURE_BLOB:
+		// the storage account and the container
+		return provider, u.User.Username(), u.Host
+	case "", "file":
+		return provider, "", ""
+	}
+	return provider, u.Host, ""
+}
+
+// storageMetricLabels returns the provider and bucket labels of the storage metrics of the URL. The host isn't a
+// label, the provider telling the known ones apart and the hosts of arbitrary source and output URLs adding series.
+func storageMetricLabels(osURL string) (string, string) {
+	provider, _, bucket := storageLabels(osURL)
+	return provider, bucket
+}
+
+// classifyStorageError returns the error class used to label the storage error metrics
+func classifyStorageError(err error) string {
+	if errors.Is(err, drivers.ErrNotExist) || catErrs.IsObjectNotFound(err) {
+		return "404"
+	}
+
+	statusCode := storageErrorStatusCode(err)
+	switch {
+	case statusCode == http.StatusForbidden || statusCode == http.StatusUnauthorized:
+		return "403"
+	case statusCode == http.StatusNotFound:
+		return "404"
+	case statusCode >= 500:
+		return "5xx"
+	case statusCode >= 400:
+		return "4xx"
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	return "other"
+}
+
+// storageFailure classifies the error of a failed storage operation, marking the ones retrying can't fix as
+// unretriable: denied access, a missing bucket or a malformed request
+func storageFailure(err error) error {
+	if err == nil || catErrs.IsObjectNotFound(err) {
+		return err
+	}
+	var awsErr awserr.Error
+	statusCode := storageErrorStatusCode(err)
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassAccessDenied, err))
+	case statusCode == http.StatusBadRequest && errors.As(err, &awsErr) && awsErr.Code() == "RequestTimeout":
+		// S3 answers a client that was too slow to send its data with a 400
+		return catErrs.WithClass(catErrs.ClassStorage, err)
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusNotFound || statusCode == http.StatusMethodNotAllowed:
+		return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassStorage, err))
+	}
+	return catErrs.WithClass(catErrs.ClassStorage, err)
+}
+
+// storageErrorStatusCode returns the HTTP status code of a failed storage request, or 0 if it's not known
+func storageErrorStatusCode(err error) int {
+	var statusErr HTTPStatusError
+	var reqFailure awserr.RequestFailure
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	} else if errors.As(err, &reqFailure) {
+		return reqFailure.StatusCode()
+	}
+	return 0
+}
+
+// observeStorageOperation records the duration and, in case of failure, the error class of a storage operation
+func observeStorageOperation(operation, osURL string, start time.Time, err error) {
+	provider, bucket := storageMetricLabels(osURL)
+	metrics.Metrics.StorageMetrics.OperationDuration.
+		WithLabelValues(operation, provider, bucket, fmt.Sprint(err == nil)).
+		Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.Metrics.StorageMetrics.OperationErrors.
+			WithLabelValues(operation, provider, bucket, classifyStorageError(err)).
+			Inc()
+		observeThrottle(operation, osURL, err)
+	}
+}
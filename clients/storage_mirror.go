@@ -0,0 +1,207 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/golang/glog"
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// Time allowed to copy an object to its backup store, per attempt
+const mirrorTimeout = 5 * time.Minute
+
+// storageMirror copies the objects uploaded to a primary store of -storage-fallback-urls to the backup store, in the
+// background, so that the backup can serve them if the primary fails. The objects are read back from the primary
+// when they're copied, so an object uploaded several times in a row, like a playlist, is copied once in its latest
+// version.
+type storageMirror struct {
+	queue chan mirrorTask
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// mirrorTask is the copy of an object to its backup store. The object of a recording, its playlist, is only copied
+// once all of its segments were, for the backup not to list segments it doesn't have.
+type mirrorTask struct {
+	primary  string
+	segments []string
+}
+
+// mirror is nil while write-through to the backup stores is disabled
+var mirror *storageMirror
+
+// StartStorageMirror starts copying the uploads to the primary stores to their backup store, with up to queueSize
+// objects waiting to be copied by the given number of workers. The objects uploaded while the queue is full aren't
+// copied.
+func StartStorageMirror(ctx context.Context, queueSize, workers int) {
+	m := &storageMirror{queue: make(chan mirrorTask, queueSize), pending: map[string]bool{}}
+	for i := 0; i < workers; i++ {
+		go m.run(ctx)
+	}
+	mirror = m
+}
+
+// enqueue queues the copy of an object just uploaded to the given location, if its store has a backup
+func (m *storageMirror) enqueue(osURL, filename string) {
+	primary, err := objectURL(osURL, filename)
+	if err != nil {
+		return
+	}
+	m.enqueueObject(primary)
+}
+
+// enqueueObject queues the copy of an object, if its store has a backup
+func (m *storageMirror) enqueueObject(primary string) {
+	m.enqueueTask(mirrorTask{primary: primary})
+}
+
+// enqueueTask queues the copy of an object along with the segments copied before it, if its store has a backup
+func (m *storageMirror) enqueueTask(task mirrorTask) {
+	primary := task.primary
+	if config.GetStorageBackupURL(primary) == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending[primary] {
+		// the copy still to come reads the latest version
+		return
+	}
+	select {
+	case m.queue <- task:
+		m.pending[primary] = true
+		metrics.Metrics.StorageMetrics.MirrorQueueDepth.Inc()
+	default:
+		metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("dropped", mirrorStore(primary)).Inc()
+		glog.Warningf("Storage mirror queue full, not copying %s to its backup store", log.RedactURL(primary))
+	}
+}
+
+func (m *storageMirror) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-m.queue:
+			m.mu.Lock()
+			delete(m.pending, task.primary)
+			m.mu.Unlock()
+			metrics.Metrics.StorageMetrics.MirrorQueueDepth.Dec()
+			m.copy(ctx, task)
+		}
+	}
+}
+
+// copy copies the segments of the task and then its object, which is skipped if any segment failed to be copied
+func (m *storageMirror) copy(ctx context.Context, task mirrorTask) {
+	for _, segment := range task.segments {
+		if !mirrorObject(ctx, segment) {
+			metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("skipped", mirrorStore(task.primary)).Inc()
+			glog.Errorf("Not copying %s to its backup store, its segment %s failed to be copied", log.RedactURL(task.primary), log.RedactURL(segment))
+			return
+		}
+	}
+	mirrorObject(ctx, task.primary)
+}
+
+// mirrorObject copies an object to its backup store, returning whether it was copied
+func mirrorObject(ctx context.Context, primary string) bool {
+	if err := copyToBackup(ctx, primary); err != nil {
+		metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("failed", mirrorStore(primary)).Inc()
+		glog.Errorf("Failed to copy %s to its backup store: %v", log.RedactURL(primary), err)
+		return false
+	}
+	metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("mirrored", mirrorStore(primary)).Inc()
+	return true
+}
+
+// copyToBackup copies an object from its primary store to its backup store, retrying on failures
+func copyToBackup(ctx context.Context, primary string) error {
+	backup := config.GetStorageBackupURL(primary)
+	i := strings.LastIndex(backup, "/")
+	if i < 0 {
+		return fmt.Errorf("invalid backup URL %q", log.RedactURL(backup))
+	}
+	backupDir, filename := backup[:i], backup[i+1:]
+
+	return backoff.Retry(func() error {
+		attemptCtx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+		defer cancel()
+		f, err := GetOSURLContext(attemptCtx, primary, "")
+		if errors.IsObjectNotFound(err) {
+			return backoff.Permanent(err)
+		} else if err != nil {
+			return err
+		}
+		defer f.Body.Close()
+		return uploadToOSURLFields(attemptCtx, backupDir, filename, f.Body, mirrorTimeout, nil)
+	}, backoff.WithContext(UploadRetryBackoff(), ctx))
+}
+
+// MirrorRecording queues the copy of a recording Mist wrote to a primary store, its playlist and segments, to the
+// backup store once the recording ended. Mist writes them to the store itself, they don't go through
+// UploadToOSURLFields. They're copied as one task: the playlist is only copied once all of its segments were, and
+// not at all if the task is dropped because the queue is full.
+func MirrorRecording(ctx context.Context, playlistURL string) error {
+	if mirror == nil || config.GetStorageBackupURL(playlistURL) == "" {
+		return nil
+	}
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return fmt.Errorf("invalid recording URL %q: %w", log.RedactURL(playlistURL), err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+	defer cancel()
+	f, err := GetOSURLContext(ctx, playlistURL, "")
+	if err != nil {
+		return fmt.Errorf("failed to read recording playlist %q: %w", log.RedactURL(playlistURL), err)
+	}
+	defer f.Body.Close()
+	playlist, listType, err := m3u8.DecodeFrom(f.Body, true)
+	if err != nil {
+		return fmt.Errorf("failed to parse recording playlist %q: %w", log.RedactURL(playlistURL), err)
+	}
+	if listType != m3u8.MEDIA {
+		return fmt.Errorf("recording playlist %q isn't a media playlist", log.RedactURL(playlistURL))
+	}
+	task := mirrorTask{primary: playlistURL}
+	for _, segment := range playlist.(*m3u8.MediaPlaylist).GetAllSegments() {
+		segmentURL, err := base.Parse(segment.URI)
+		if err != nil {
+			return fmt.Errorf("invalid segment URI %q in recording playlist: %w", segment.URI, err)
+		}
+		task.segments = append(task.segments, segmentURL.String())
+	}
+	mirror.enqueueTask(task)
+	return nil
+}
+
+// mirrorStore is the primary store of -storage-fallback-urls the object is in, to label the metrics
+func mirrorStore(primary string) string {
+	for store := range config.StorageFallbackURLs {
+		if strings.HasPrefix(primary, store) {
+			return log.RedactURL(store)
+		}
+	}
+	return "unknown"
+}
+
+// objectURL is the URL of an object uploaded to a location with a filename
+func objectURL(osURL, filename string) (string, error) {
+	u, err := url.Parse(osURL)
+	if err != nil {
+		return "", err
+	}
+	return u.JoinPath(filename).String(), nil
+}
@@ -0,0 +1,113 @@
+package clients
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestItMirrorsUploadsToTheBackupStore(t *testing.T) {
+	primary, backup := t.TempDir(), t.TempDir()
+	config.StorageFallbackURLs = map[string]string{primary: backup}
+	defer func() { config.StorageFallbackURLs = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartStorageMirror(ctx, 10, 1)
+	defer func() { mirror = nil }()
+
+	mirrored := testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("mirrored", primary))
+	require.NoError(t, UploadToOSURL(primary, "hls/360p0/0.ts", strings.NewReader("segment"), time.Second))
+	// Uploads to the stores without a backup aren't copied
+	require.NoError(t, UploadToOSURL(t.TempDir(), "0.ts", strings.NewReader("segment"), time.Second))
+
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(filepath.Join(backup, "hls", "360p0", "0.ts"))
+		return err == nil && string(data) == "segment"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("mirrored", primary)) == mirrored+1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStorageMirrorQueue(t *testing.T) {
+	primary := t.TempDir()
+	config.StorageFallbackURLs = map[string]string{primary: t.TempDir()}
+	defer func() { config.StorageFallbackURLs = nil }()
+
+	m := &storageMirror{queue: make(chan mirrorTask, 1), pending: map[string]bool{}}
+	dropped := testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("dropped", primary))
+
+	m.enqueue(primary, "index.m3u8")
+	// Uploading an object again before it's copied doesn't queue it again
+	m.enqueue(primary, "index.m3u8")
+	require.Len(t, m.queue, 1)
+	require.Equal(t, dropped, testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("dropped", primary)))
+
+	// The objects uploaded while the queue is full aren't copied
+	m.enqueue(primary, "0.ts")
+	require.Len(t, m.queue, 1)
+	// Counted for the store they're in
+	require.Equal(t, dropped+1, testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("dropped", primary)))
+	require.Equal(t, mirrorTask{primary: filepath.Join(primary, "index.m3u8")}, <-m.queue)
+}
+
+func TestItMirrorsRecordings(t *testing.T) {
+	primary, backup := t.TempDir(), t.TempDir()
+	config.StorageFallbackURLs = map[string]string{primary: backup}
+	defer func() { config.StorageFallbackURLs = nil }()
+	recording := filepath.Join(primary, "recordings", "abc")
+	require.NoError(t, os.MkdirAll(recording, 0755))
+	for _, segment := range []string{"0.ts", "1.ts"} {
+		require.NoError(t, os.WriteFile(filepath.Join(recording, segment), []byte("segment "+segment), 0644))
+	}
+	playlist := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.0,\n0.ts\n#EXTINF:2.0,\n1.ts\n#EXT-X-ENDLIST\n"
+	require.NoError(t, os.WriteFile(filepath.Join(recording, "index.m3u8"), []byte(playlist), 0644))
+
+	// Nothing to do while write-through is disabled
+	require.NoError(t, MirrorRecording(context.Background(), filepath.Join(recording, "index.m3u8")))
+
+	m := &storageMirror{queue: make(chan mirrorTask, 10), pending: map[string]bool{}}
+	mirror = m
+	defer func() { mirror = nil }()
+	require.NoError(t, MirrorRecording(context.Background(), filepath.Join(recording, "index.m3u8")))
+	require.Len(t, m.queue, 1)
+	task := <-m.queue
+	require.Equal(t, mirrorTask{
+		primary:  filepath.Join(recording, "index.m3u8"),
+		segments: []string{filepath.Join(recording, "0.ts"), filepath.Join(recording, "1.ts")},
+	}, task)
+
+	m.copy(context.Background(), task)
+	for _, object := range []string{"0.ts", "1.ts", "index.m3u8"} {
+		require.FileExists(t, filepath.Join(backup, "recordings", "abc", object))
+	}
+
+	require.Error(t, MirrorRecording(context.Background(), filepath.Join(recording, "missing.m3u8")))
+}
+
+func TestItSkipsRecordingPlaylistsWithMissingSegments(t *testing.T) {
+	primary, backup := t.TempDir(), t.TempDir()
+	config.StorageFallbackURLs = map[string]string{primary: backup}
+	defer func() { config.StorageFallbackURLs = nil }()
+	require.NoError(t, os.WriteFile(filepath.Join(primary, "0.ts"), []byte("segment"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(primary, "index.m3u8"), []byte("playlist"), 0644))
+	skipped := testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("skipped", primary))
+
+	m := &storageMirror{queue: make(chan mirrorTask, 1), pending: map[string]bool{}}
+	m.copy(context.Background(), mirrorTask{
+		primary:  filepath.Join(primary, "index.m3u8"),
+		segments: []string{filepath.Join(primary, "0.ts"), filepath.Join(primary, "1.ts")},
+	})
+	require.FileExists(t, filepath.Join(backup, "0.ts"))
+	require.NoFileExists(t, filepath.Join(backup, "index.m3u8"))
+	require.Equal(t, skipped+1, testutil.ToFloat64(metrics.Metrics.StorageMetrics.MirrorWrites.WithLabelValues("skipped", primary)))
+}
@@ -0,0 +1,88 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// storageQuirks describes how an S3 compatible provider differs from AWS S3 in ways that we need to work around.
+//
+// Multipart uploads are done by the go-tools driver with a fixed part size of 63MiB and equally sized parts, which is
+// within both the R2 and B2 limits (5MiB to 5GiB per part), so the part size doesn't need tailoring per provider.
+type storageQuirks struct {
+	// Status codes the provider returns to ask clients to back off, on top of the ones the AWS SDK already retries.
+	// Reads failing with these are retried in place.
+	throttleStatusCodes []int
+	// Listings can lag behind recent writes, so an empty listing is retried this many times before it's trusted
+	listRetries int
+	// The S3 endpoint of the provider never serves objects anonymously (public access goes through a separate
+	// domain), so there is no point checking whether the object is publicly accessible before signing its URL
+	noAnonymousAccess bool
+}
+
+var providerQuirks = map[string]storageQuirks{
+	ProviderR2: {
+		throttleStatusCodes: []int{429, 503},
+		listRetries:         3,
+		noAnonymousAccess:   true,
+	},
+	ProviderAzure: {
+		// The blobs are read through the account's own endpoint, not the container host of the azblob URL
+		noAnonymousAccess: true,
+	},
+	ProviderB2: {
+		// B2 returns 503s as a normal part of operation when a storage pod is busy and expects clients to retry
+		throttleStatusCodes: []int{429, 500, 503},
+		listRetries:         3,
+	},
+}
+
+func storageQuirksFor(osURL string) storageQuirks {
+	u, err := url.Parse(osURL)
+	if err != nil {
+		return storageQuirks{}
+	}
+	return providerQuirks[StorageProvider(u)]
+}
+
+func (q storageQuirks) isThrottled(err error) bool {
+	statusCode := storageErrorStatusCode(err)
+	for _, code := range q.throttleStatusCodes {
+		if statusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryThrottled retries the operation for as long as the provider keeps asking us to back off, up to a limit. Waits
+// at least as long as the provider's Retry-After between attempts.
+func (q storageQuirks) retryThrottled(ctx context.Context, op func() error) error {
+	if len(q.throttleStatusCodes) == 0 {
+		return op()
+	}
+	backOff := &retryAfterBackOff{BackOff: backoff.WithMaxRetries(newThrottleBackOff(), 5)}
+	return backoff.Retry(func() error {
+		err := op()
+		if err != nil && !q.isThrottled(err) {
+			return backoff.Permanent(err)
+		}
+		backOff.retryAfter = retryAfter(err)
+		return err
+	}, backoff.WithContext(backOff, ctx))
+}
+
+func newThrottleBackOff() backoff.BackOff {
+	backOff := backoff.NewExponentialBackOff()
+	backOff.InitialInterval = 500 * time.Millisecond
+	backOff.MaxInterval = maxRetryInterval
+	backOff.MaxElapsedTime = 0
+	backOff.Reset()
+	return backOff
+}
+
+var errEmptyListing = errors.New("empty listing")
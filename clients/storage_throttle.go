@@ -0,0 +1,195 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// Longest Retry-After we honor, so that a misbehaving provider can't stall uploads indefinitely
+const maxRetryAfter = time.Minute
+
+// Minimum time between two cuts of a bucket's upload parallelism, so that a burst of parallel uploads throttled at the
+// same time only counts once
+const throttleCutInterval = time.Second
+
+// isStorageThrottled returns whether the provider asked us to back off, whatever the provider
+func isStorageThrottled(osURL string, err error) bool {
+	if err == nil {
+		return false
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && (awsErr.Code() == "SlowDown" || awsErr.Code() == "Throttling") {
+		return true
+	}
+	statusCode := storageErrorStatusCode(err)
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable || storageQuirksFor(osURL).isThrottled(err)
+}
+
+// retryAfter returns how long the provider asked us to wait, 0 if it didn't say
+func retryAfter(err error) time.Duration {
+	var statusErr HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return min(statusErr.RetryAfter, maxRetryAfter)
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// retryAfterBackOff waits at least as long as the last throttled response asked for
+type retryAfterBackOff struct {
+	backoff.BackOff
+	retryAfter time.Duration
+}
+
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop {
+		return next
+	}
+	return max(next, b.retryAfter)
+}
+
+// uploadLimiter bounds the uploads running in parallel to a bucket. The limit is halved when the provider throttles
+// us and raised by one once a limit's worth of uploads succeeded, so that we back off quickly and recover gradually.
+type uploadLimiter struct {
+	// Labels of the metrics of the limiter, see storageMetricLabels
+	provider, bucket string
+	max              int
+
+	mu           sync.Mutex
+	limit        int
+	inFlight     int
+	successes    int
+	lastCut      time.Time
+	pausedUntil  time.Time
+	limitChanged chan struct{}
+}
+
+var (
+	uploadLimitersMu sync.Mutex
+	uploadLimiters   = map[string]*uploadLimiter{}
+)
+
+// uploadLimiterFor returns the limiter of the bucket, nil when uploads aren't limited
+func uploadLimiterFor(osURL string) *uploadLimiter {
+	if config.StorageUploadParallelism <= 0 {
+		return nil
+	}
+	provider, host, bucket := storageLabels(osURL)
+	if provider == "file" {
+		return nil
+	}
+	key := host + "/" + bucket
+
+	uploadLimitersMu.Lock()
+	defer uploadLimitersMu.Unlock()
+	l, ok := uploadLimiters[key]
+	if !ok {
+		l = newUploadLimiter(provider, bucket, config.StorageUploadParallelism)
+		uploadLimiters[key] = l
+	}
+	return l
+}
+
+func newUploadLimiter(provider, bucket string, maxParallel int) *uploadLimiter {
+	return &uploadLimiter{provider: provider, bucket: bucket, max: maxParallel, limit: maxParallel, limitChanged: make(chan struct{})}
+}
+
+// acquire waits for a free upload slot and for any Retry-After pause to be over
+func (l *uploadLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		pause := time.Until(l.pausedUntil)
+		if pause <= 0 && l.inFlight < l.limit {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		limitChanged := l.limitChanged
+		l.mu.Unlock()
+
+		var timer *time.Timer
+		var pauseOver <-chan time.Time
+		if pause > 0 {
+			timer = time.NewTimer(pause)
+			pauseOver = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		case <-limitChanged:
+		case <-pauseOver:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// release frees the upload slot, adapting the limit to the outcome of the upload
+func (l *uploadLimiter) release(success, throttled bool, retryAfter time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	switch {
+	case throttled:
+		l.successes = 0
+		if time.Since(l.lastCut) >= throttleCutInterval {
+			l.limit = max(l.limit/2, 1)
+			l.lastCut = time.Now()
+		}
+		if until := time.Now().Add(retryAfter); until.After(l.pausedUntil) {
+			l.pausedUntil = until
+		}
+	case success && l.limit < l.max:
+		l.successes++
+		if l.successes >= l.limit {
+			l.limit++
+			l.successes = 0
+		}
+	}
+	metrics.Metrics.StorageMetrics.UploadParallelism.WithLabelValues(l.provider, l.bucket).Set(float64(l.limit))
+	// Wake up the uploads waiting for a slot
+	close(l.limitChanged)
+	l.limitChanged = make(chan struct{})
+}
+
+// observeThrottle counts the operations the provider throttled
+func observeThrottle(operation, osURL string, err error) {
+	if !isStorageThrottled(osURL, err) {
+		return
+	}
+	provider, bucket := storageMetricLabels(osURL)
+	metrics.Metrics.StorageMetrics.ThrottleEvents.WithLabelValues(operation, provider, bucket).Inc()
+}
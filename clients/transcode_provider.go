@@ -22,6 +22,8 @@ type TranscodeJobArgs struct {
 	InputFileInfo video.InputVideo
 	Profiles      []video.EncodedProfile
 	GenerateMP4   bool
+	// How the generated renditions are named, config.RenditionNaming when unset
+	RenditionNaming video.RenditionNaming
 
 	// Collect size of an asset
 	CollectSourceSize        func(size int64)
@@ -46,7 +48,7 @@ func ParseTranscodeProviderURL(input string) (TranscodeProvider, error) {
 	if err != nil {
 		return nil, err
 	}
-	// mediaconvert://<key id>:<key secret>@<endpoint host>?region=<aws region>&role=<arn for role>&s3_aux_bucket=<s3 bucket url>
+	// mediaconvert://<key id>:<key secret>@<endpoint host>?region=<aws region>&role=<arn for role>&s3_aux_bucket=<s3 bucket url>[&status_queue=<sqs queue url>]
 	if u.Scheme == "mediaconvert" || u.Scheme == "mediaconverthttp" {
 		endpoint := fmt.Sprintf("https://%s", u.Host)
 
@@ -87,6 +89,7 @@ func ParseTranscodeProviderURL(input string) (TranscodeProvider, error) {
 			AccessKeyID:      accessKeyId,
 			AccessKeySecret:  accessKeySecret,
 			S3TransferBucket: s3AuxBucket,
+			StatusQueueURL:   u.Query().Get("status_queue"),
 		})
 	}
 	return nil, fmt.Errorf("unrecognized OS scheme: %s", u.Scheme)
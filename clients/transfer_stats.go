@@ -0,0 +1,151 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// TransferReport is the network transfer of a job, reported in its final status message for the cost of the job to be
+// attributed to its tenant
+type TransferReport struct {
+	// Bytes downloaded from the source and the other files read from object stores or over HTTP
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	// Bytes uploaded, by destination: the host and, for object stores, the bucket
+	UploadedBytes map[string]int64 `json:"uploaded_bytes,omitempty"`
+	// Bytes sent to the broadcaster to be transcoded and received back from it
+	BroadcasterBytes int64 `json:"broadcaster_bytes"`
+}
+
+// TotalUploadedBytes is the sum of the bytes uploaded to every destination
+func (r TransferReport) TotalUploadedBytes() int64 {
+	var total int64
+	for _, b := range r.UploadedBytes {
+		total += b
+	}
+	return total
+}
+
+// TransferStats counts the bytes moved over the network for a job. It's carried by the context of the job, see
+// WithTransferStats, for the downloads, uploads and broadcaster requests made with it to be counted. Local files aren't
+// counted.
+type TransferStats struct {
+	downloaded  atomic.Int64
+	broadcaster atomic.Int64
+
+	mu       sync.Mutex
+	uploaded map[string]int64
+}
+
+func NewTransferStats() *TransferStats {
+	return &TransferStats{uploaded: map[string]int64{}}
+}
+
+// Report returns the bytes counted so far
+func (s *TransferStats) Report() TransferReport {
+	if s == nil {
+		return TransferReport{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploaded := make(map[string]int64, len(s.uploaded))
+	for destination, b := range s.uploaded {
+		uploaded[destination] = b
+	}
+	return TransferReport{
+		DownloadedBytes:  s.downloaded.Load(),
+		UploadedBytes:    uploaded,
+		BroadcasterBytes: s.broadcaster.Load(),
+	}
+}
+
+func (s *TransferStats) addUploaded(destination string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploaded[destination] += n
+}
+
+type transferStatsKey struct{}
+
+// WithTransferStats returns a context counting the network transfer made with it into stats
+func WithTransferStats(ctx context.Context, stats *TransferStats) context.Context {
+	return context.WithValue(ctx, transferStatsKey{}, stats)
+}
+
+func transferStatsFromContext(ctx context.Context) *TransferStats {
+	stats, _ := ctx.Value(transferStatsKey{}).(*TransferStats)
+	return stats
+}
+
+// transferDestination returns what the transfer to or from a URL is counted against, "" for the local files
+func transferDestination(osURL string) string {
+	provider, host, bucket := storageLabels(osURL)
+	switch {
+	case provider == "file":
+		return ""
+	case host == "":
+		return provider
+	case bucket == "":
+		return host
+	}
+	return host + "/" + bucket
+}
+
+// countDownload counts the bytes read from a file downloaded from the URL
+func countDownload(ctx context.Context, url string, rc io.ReadCloser) io.ReadCloser {
+	stats := transferStatsFromContext(ctx)
+	if stats == nil || transferDestination(url) == "" {
+		return rc
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{countingReader{r: rc, count: stats.downloaded.Add}, rc}
+}
+
+// countUpload counts the bytes read from the data uploaded to the URL
+func countUpload(ctx context.Context, osURL string, data io.Reader) io.Reader {
+	stats := transferStatsFromContext(ctx)
+	destination := transferDestination(osURL)
+	if stats == nil || destination == "" {
+		return data
+	}
+	return countingReader{r: data, count: func(n int64) int64 {
+		stats.addUploaded(destination, n)
+		return 0
+	}}
+}
+
+// CountBroadcasterTransfer counts the bytes of a segment read to be sent to the broadcaster
+func CountBroadcasterTransfer(ctx context.Context, segment io.Reader) io.Reader {
+	stats := transferStatsFromContext(ctx)
+	if stats == nil {
+		return segment
+	}
+	return countingReader{r: segment, count: stats.broadcaster.Add}
+}
+
+// CountBroadcasterResult counts the bytes of the renditions received back from the broadcaster
+func CountBroadcasterResult(ctx context.Context, result TranscodeResult) {
+	stats := transferStatsFromContext(ctx)
+	if stats == nil {
+		return
+	}
+	for _, rendition := range result.Renditions {
+		stats.broadcaster.Add(int64(len(rendition.MediaData)))
+	}
+}
+
+type countingReader struct {
+	r     io.Reader
+	count func(int64) int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.count(int64(n))
+	}
+	return n, err
+}
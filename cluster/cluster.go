@@ -4,6 +4,7 @@ package cluster
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -20,12 +21,45 @@ import (
 
 const serfClusterInternalEventBuffer = 100000
 
+const (
+	// The size of the UDP packets memberlist gossips the user events in, its default
+	udpBufferSize = 1400
+	// Room taken out of a packet by memberlist, for the compound message header and the encryption
+	memberlistPacketOverhead = 64
+	// Room taken by the Serf encoding of a user event, on top of its name and payload
+	serfUserEventOverhead = 64
+
+	// MaxUserEventSize is the largest user event (name and payload) that fits in a gossip packet. Serf accepts events
+	// of up to 9KB but memberlist never sends the ones larger than a packet, so they are refused instead.
+	MaxUserEventSize = udpBufferSize - memberlistPacketOverhead - serfUserEventOverhead
+
+	// Room taken by the Serf encoding of a query response, on top of its payload, with the name of the answering node
+	serfQueryResponseOverhead = 128
+
+	// MaxQueryResponseSize is the largest query response payload that fits in a packet, which is how Serf sends them
+	MaxQueryResponseSize = udpBufferSize - memberlistPacketOverhead - serfQueryResponseOverhead
+)
+
+var ErrUserEventTooLarge = errors.New("user event too large for a gossip packet")
+
 type Cluster interface {
 	Start(ctx context.Context) error
 	MembersFiltered(filter map[string]string, status, name string) ([]Member, error)
 	MemberChan() chan []Member
 	EventChan() <-chan serf.UserEvent
 	BroadcastEvent(serf.UserEvent) error
+	Query(name string, payload []byte, timeout time.Duration) (QueryResult, error)
+	QueryNode(node, name string, payload []byte, timeout time.Duration) ([]byte, error)
+	QueryChan() <-chan *serf.Query
+	SetTags(tags map[string]string) error
+}
+
+// QueryResult is what the nodes of the cluster answered to a query
+type QueryResult struct {
+	// Nodes that received the query
+	Acks []string
+	// Answers of the nodes that handled the query, by node name
+	Responses map[string][]byte
 }
 
 type ClusterImpl struct {
@@ -39,6 +73,8 @@ type ClusterImpl struct {
 	// eventCh is used to receive custom user events
 	// This channel is intended to be used by the user of the ClusterImpl struct
 	eventCh chan serf.UserEvent
+	// queryCh is used to receive the queries to answer, like eventCh
+	queryCh chan *serf.Query
 	// membersCh is an internal channel to update the current membership list
 	memberCh chan []Member
 }
@@ -58,6 +94,7 @@ func NewCluster(config *config.Cli) Cluster {
 		serfCh:   make(chan serf.Event, serfClusterInternalEventBuffer),
 		memberCh: make(chan []Member),
 		eventCh:  make(chan serf.UserEvent, config.SerfQueueSize),
+		queryCh:  make(chan *serf.Query, config.SerfQueueSize),
 	}
 	return &c
 }
@@ -109,10 +146,12 @@ func (c *ClusterImpl) Start(ctx context.Context) error {
 	memberlistConfig.AdvertiseAddr = ahost
 	memberlistConfig.AdvertisePort = aport
 	memberlistConfig.EnableCompression = true
+	memberlistConfig.UDPBufferSize = udpBufferSize
 	memberlistConfig.SecretKey = encryptBytes
 	memberlistConfig.LogOutput = serfLogger{}
 	serfConfig := serf.DefaultConfig()
-	serfConfig.UserEventSizeLimit = 1024
+	serfConfig.UserEventSizeLimit = udpBufferSize - memberlistPacketOverhead
+	serfConfig.QueryResponseSizeLimit = udpBufferSize - memberlistPacketOverhead
 	serfConfig.MemberlistConfig = memberlistConfig
 	serfConfig.NodeName = c.config.NodeName
 	serfConfig.Tags = c.config.Tags
@@ -236,10 +275,136 @@ func (c *ClusterImpl) EventChan() <-chan serf.UserEvent {
 	return c.eventCh
 }
 
+// BroadcastEvent gossips a user event to the cluster. Events over MaxUserEventSize are refused with
+// ErrUserEventTooLarge, memberlist would otherwise never send them.
 func (c *ClusterImpl) BroadcastEvent(event serf.UserEvent) error {
+	if size := len(event.Name) + len(event.Payload); size > MaxUserEventSize {
+		metrics.Metrics.UserEventTooLargeCount.WithLabelValues(userEventMetricName(event.Name)).Inc()
+		glog.Errorf("Refusing to broadcast a serf user event too large for a gossip packet name=%s size=%d max=%d", event.Name, size, MaxUserEventSize)
+		return fmt.Errorf("%w: %s of %d bytes, at most %d", ErrUserEventTooLarge, event.Name, size, MaxUserEventSize)
+	}
 	return c.serf.UserEvent(event.Name, event.Payload, event.Coalesce)
 }
 
+// userEventMetricName is the kind of a user event for the metrics, the part of its name before the ID, e.g. nodeUpdate
+func userEventMetricName(name string) string {
+	kind, _, _ := strings.Cut(name, "-")
+	return kind
+}
+
+// Query sends a query to all the nodes of the cluster and collects their acks and answers until the timeout
+func (c *ClusterImpl) Query(name string, payload []byte, timeout time.Duration) (QueryResult, error) {
+	if c.serf == nil {
+		return QueryResult{}, fmt.Errorf("serf not initialized")
+	}
+	params := c.serf.DefaultQueryParams()
+	params.RequestAck = true
+	params.Timeout = timeout
+	resp, err := c.serf.Query(name, payload, params)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	result := QueryResult{Responses: map[string][]byte{}}
+	ackCh, respCh := resp.AckCh(), resp.ResponseCh()
+	for ackCh != nil || respCh != nil {
+		select {
+		case node, ok := <-ackCh:
+			if !ok {
+				ackCh = nil
+				continue
+			}
+			result.Acks = append(result.Acks, node)
+		case r, ok := <-respCh:
+			if !ok {
+				respCh = nil
+				continue
+			}
+			result.Responses[r.From] = r.Payload
+		}
+	}
+	return result, nil
+}
+
+// QueryNode sends a query to a single node of the cluster and returns its answer
+func (c *ClusterImpl) QueryNode(node, name string, payload []byte, timeout time.Duration) ([]byte, error) {
+	if c.serf == nil {
+		return nil, fmt.Errorf("serf not initialized")
+	}
+	params := c.serf.DefaultQueryParams()
+	params.FilterNodes = []string{node}
+	params.Timeout = timeout
+	resp, err := c.serf.Query(name, payload, params)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := <-resp.ResponseCh()
+	if !ok {
+		return nil, fmt.Errorf("no answer from node %s to query %s", node, name)
+	}
+	return r.Payload, nil
+}
+
+// Subscribe to the queries sent in the serf cluster, to answer them. Only call me once, like EventChan.
+func (c *ClusterImpl) QueryChan() <-chan *serf.Query {
+	return c.queryCh
+}
+
+// SetTags updates the Serf tags of the node, e.g. when it's draining for maintenance. Tags set to "" are removed, the
+// others are left as they are.
+func (c *ClusterImpl) SetTags(tags map[string]string) error {
+	if c.serf == nil {
+		return fmt.Errorf("serf not initialized")
+	}
+	newTags := map[string]string{}
+	for k, v := range c.serf.LocalMember().Tags {
+		newTags[k] = v
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(newTags, k)
+		} else {
+			newTags[k] = v
+		}
+	}
+	return c.serf.SetTags(newTags)
+}
+
+// WithoutTag returns the members that don't have a tag, e.g. leaving the nodes draining for maintenance out
+func WithoutTag(members []Member, tag string) []Member {
+	var nodes []Member
+	for _, member := range members {
+		if _, ok := member.Tags[tag]; !ok {
+			nodes = append(nodes, member)
+		}
+	}
+	return nodes
+}
+
+// FeedImportsTag marks the nodes running with -feed-imports, one of which is elected to import the feeds
+const FeedImportsTag = "feed-imports"
+
+// Elected tells whether the node is the one elected among the alive members with the tag to do a task that only a
+// single node of the cluster should, which is the one with the lowest name. Not elected when the members can't be
+// listed, e.g. before the node joined the cluster.
+func Elected(c Cluster, nodeName, tag string) bool {
+	members, err := c.MembersFiltered(map[string]string{tag: "true"}, "alive", "")
+	if err != nil {
+		return false
+	}
+	for _, m := range members {
+		if m.Name < nodeName {
+			return false
+		}
+	}
+	for _, m := range members {
+		if m.Name == nodeName {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *ClusterImpl) handleEvents(ctx context.Context) error {
 	inbox := make(chan serf.Event, c.config.SerfQueueSize)
 	go func() {
@@ -263,6 +428,15 @@ func (c *ClusterImpl) handleEvents(ctx context.Context) error {
 						// Overflow event gets dropped
 						glog.Infof("Overflow UserEvent, dropped: %v", evt)
 					}
+				case *serf.Query:
+					select {
+					case <-ctx.Done():
+						return
+					case c.queryCh <- evt:
+					default:
+						// Unanswered, the node shows as missing to the sender of the query
+						glog.Infof("Overflow Query, dropped: %v", evt)
+					}
 				case serf.MemberEvent:
 					select {
 					case <-ctx.Done():
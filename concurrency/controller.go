@@ -0,0 +1,139 @@
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// Tuner adjusts the VOD concurrency limits to the node's resource headroom. nil when the static limits of
+// -max-inflight-jobs and -parallel-transcode-jobs apply.
+var Tuner *Controller
+
+const (
+	// Usage percentage of the busiest resource above which VOD concurrency is cut, and below which it's raised again
+	highUsage = 85
+	lowUsage  = 60
+	// While the node ingests live streams, the thresholds are lowered by this much so that VOD work slows down before
+	// the streams are affected
+	ingestHeadroom = 15
+)
+
+// Usage is a sample of the node's resource usage and of the live streams it ingests
+type Usage struct {
+	CPUPercentage  float64
+	RAMPercentage  float64
+	DiskPercentage float64
+	IngestStreams  int
+}
+
+// Bounds are the smallest and largest values a limit is tuned between
+type Bounds struct {
+	Min int
+	Max int
+}
+
+func (b Bounds) validate(name string) error {
+	if b.Min < 1 || b.Max < b.Min {
+		return fmt.Errorf("invalid %s bounds: min %d, max %d", name, b.Min, b.Max)
+	}
+	return nil
+}
+
+type limit struct {
+	name    string
+	bounds  Bounds
+	current atomic.Int64
+}
+
+// tune halves the limit when the node is under pressure and raises it one step at a time once it has recovered, so
+// that load is shed quickly and picked up again gradually
+func (l *limit) tune(pressure, high, low float64) {
+	current := int(l.current.Load())
+	switch {
+	case pressure >= high:
+		current = max(current/2, l.bounds.Min)
+	case pressure < low:
+		current = min(current+1, l.bounds.Max)
+	}
+	l.current.Store(int64(current))
+	metrics.Metrics.ConcurrencyLimit.WithLabelValues(l.name).Set(float64(current))
+}
+
+// Controller tunes the number of VOD jobs accepted and of segments each job transcodes in parallel within their
+// configured bounds. The limits start at their maximum.
+type Controller struct {
+	inFlightJobs *limit
+	parallelJobs *limit
+	sample       func() (Usage, error)
+}
+
+func NewController(inFlightJobs, parallelJobs Bounds, sample func() (Usage, error)) (*Controller, error) {
+	if err := inFlightJobs.validate("in-flight jobs"); err != nil {
+		return nil, err
+	}
+	if err := parallelJobs.validate("parallel transcode jobs"); err != nil {
+		return nil, err
+	}
+	c := &Controller{
+		inFlightJobs: &limit{name: "inflight_jobs", bounds: inFlightJobs},
+		parallelJobs: &limit{name: "parallel_transcode_jobs", bounds: parallelJobs},
+		sample:       sample,
+	}
+	c.inFlightJobs.current.Store(int64(inFlightJobs.Max))
+	c.parallelJobs.current.Store(int64(parallelJobs.Max))
+	return c, nil
+}
+
+// MaxInFlightJobs returns the number of regular VOD jobs accepted at the same time
+func (c *Controller) MaxInFlightJobs() int {
+	if c == nil {
+		return config.MaxInFlightJobs
+	}
+	return int(c.inFlightJobs.current.Load())
+}
+
+// ParallelTranscodeJobs returns the number of segments a newly started transcode transcodes in parallel
+func (c *Controller) ParallelTranscodeJobs() int {
+	if c == nil {
+		return config.TranscodingParallelJobs
+	}
+	return int(c.parallelJobs.current.Load())
+}
+
+// Run samples the node's usage and tunes the limits every interval until the context is cancelled
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.adjust(); err != nil {
+				log.LogNoRequestID("failed to sample node usage for concurrency tuning", "err", err)
+			}
+		}
+	}
+}
+
+func (c *Controller) adjust() error {
+	usage, err := c.sample()
+	if err != nil {
+		return err
+	}
+	pressure := max(usage.CPUPercentage, usage.RAMPercentage, usage.DiskPercentage)
+	high, low := float64(highUsage), float64(lowUsage)
+	if usage.IngestStreams > 0 {
+		high -= ingestHeadroom
+		low -= ingestHeadroom
+	}
+	c.inFlightJobs.tune(pressure, high, low)
+	c.parallelJobs.tune(pressure, high, low)
+	return nil
+}
@@ -0,0 +1,76 @@
+package concurrency
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControllerTunesWithinBounds(t *testing.T) {
+	var usage Usage
+	c, err := NewController(Bounds{Min: 2, Max: 8}, Bounds{Min: 1, Max: 4}, func() (Usage, error) { return usage, nil })
+	require.NoError(t, err)
+	require.Equal(t, 8, c.MaxInFlightJobs())
+	require.Equal(t, 4, c.ParallelTranscodeJobs())
+
+	// The busiest resource is cut in half down to the minimum
+	usage = Usage{CPUPercentage: 20, RAMPercentage: 40, DiskPercentage: 90}
+	require.NoError(t, c.adjust())
+	require.Equal(t, 4, c.MaxInFlightJobs())
+	require.Equal(t, 2, c.ParallelTranscodeJobs())
+	require.NoError(t, c.adjust())
+	require.NoError(t, c.adjust())
+	require.Equal(t, 2, c.MaxInFlightJobs())
+	require.Equal(t, 1, c.ParallelTranscodeJobs())
+
+	// Held in between the thresholds
+	usage = Usage{CPUPercentage: 70}
+	require.NoError(t, c.adjust())
+	require.Equal(t, 2, c.MaxInFlightJobs())
+
+	// Raised one step at a time up to the maximum
+	usage = Usage{CPUPercentage: 10}
+	require.NoError(t, c.adjust())
+	require.Equal(t, 3, c.MaxInFlightJobs())
+	require.Equal(t, 2, c.ParallelTranscodeJobs())
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.adjust())
+	}
+	require.Equal(t, 8, c.MaxInFlightJobs())
+	require.Equal(t, 4, c.ParallelTranscodeJobs())
+}
+
+func TestControllerKeepsHeadroomForIngest(t *testing.T) {
+	usage := Usage{CPUPercentage: 75}
+	c, err := NewController(Bounds{Min: 1, Max: 8}, Bounds{Min: 1, Max: 2}, func() (Usage, error) { return usage, nil })
+	require.NoError(t, err)
+
+	require.NoError(t, c.adjust())
+	require.Equal(t, 8, c.MaxInFlightJobs())
+
+	usage.IngestStreams = 1
+	require.NoError(t, c.adjust())
+	require.Equal(t, 4, c.MaxInFlightJobs())
+}
+
+func TestControllerKeepsLimitsOnSampleError(t *testing.T) {
+	c, err := NewController(Bounds{Min: 1, Max: 8}, Bounds{Min: 1, Max: 2}, func() (Usage, error) { return Usage{}, fmt.Errorf("no stats") })
+	require.NoError(t, err)
+	require.Error(t, c.adjust())
+	require.Equal(t, 8, c.MaxInFlightJobs())
+}
+
+func TestControllerBounds(t *testing.T) {
+	_, err := NewController(Bounds{Min: 0, Max: 8}, Bounds{Min: 1, Max: 2}, nil)
+	require.Error(t, err)
+	_, err = NewController(Bounds{Min: 1, Max: 8}, Bounds{Min: 3, Max: 2}, nil)
+	require.Error(t, err)
+}
+
+func TestDisabledControllerUsesStaticLimits(t *testing.T) {
+	var c *Controller
+	require.Equal(t, config.MaxInFlightJobs, c.MaxInFlightJobs())
+	require.Equal(t, config.TranscodingParallelJobs, c.ParallelTranscodeJobs())
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Scopes that can be granted to API tokens
+const (
+	ScopeVODWrite      = "vod:write"
+	ScopeAdminRead     = "admin:read"
+	ScopeAdminWrite    = "admin:write"
+	ScopeTriggersWrite = "triggers:write"
+	// ScopeFederationRead is for the peer clusters of a balancer federation to read the summary of this cluster
+	ScopeFederationRead = "federation:read"
+	// ScopePlaybackSign is for minting signed playback URLs, see -playback-signing-key
+	ScopePlaybackSign = "playback:sign"
+	// ScopeAll grants access to every route. Used for the legacy -api-token value.
+	ScopeAll = "*"
+)
+
+// The identifier logged for requests authorized with the legacy -api-token value
+const DefaultAPITokenID = "default"
+
+type APIToken struct {
+	// ID identifies the token in logs and metrics, so that the secret itself is never exposed
+	ID     string   `json:"id"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == ScopeAll || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APITokens []APIToken
+
+type apiTokenIDKey struct{}
+
+// WithAPITokenID records the ID of the API token a request was authorized with in its context
+func WithAPITokenID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, apiTokenIDKey{}, id)
+}
+
+// APITokenID returns the ID of the API token a request was authorized with, e.g. to audit who did what
+func APITokenID(ctx context.Context) string {
+	id, _ := ctx.Value(apiTokenIDKey{}).(string)
+	return id
+}
+
+// Lookup returns the token matching the given secret, comparing in constant time
+func (ts APITokens) Lookup(secret string) (APIToken, bool) {
+	for _, t := range ts {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(secret)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// LoadAPITokens builds the list of tokens allowed to call the API. The legacy single token (if set) is granted all
+// scopes. Scoped tokens are read from a JSON file and/or a JSON string (usually passed in through the
+// CATALYST_API_API_TOKENS env var), both in the format: [{"id": "studio", "token": "secret", "scopes": ["vod:write"]}]
+func LoadAPITokens(legacyToken, tokensJSON, tokensFile string) (APITokens, error) {
+	var tokens APITokens
+	if legacyToken != "" {
+		tokens = append(tokens, APIToken{ID: DefaultAPITokenID, Token: legacyToken, Scopes: []string{ScopeAll}})
+	}
+
+	if tokensFile != "" {
+		b, err := os.ReadFile(tokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API tokens file %s: %w", tokensFile, err)
+		}
+		fileTokens, err := parseAPITokens(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse API tokens file %s: %w", tokensFile, err)
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+
+	if tokensJSON != "" {
+		jsonTokens, err := parseAPITokens([]byte(tokensJSON))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse API tokens: %w", err)
+		}
+		tokens = append(tokens, jsonTokens...)
+	}
+
+	ids := map[string]bool{}
+	for _, t := range tokens {
+		if ids[t.ID] {
+			return nil, fmt.Errorf("duplicate API token id %q", t.ID)
+		}
+		ids[t.ID] = true
+	}
+	return tokens, nil
+}
+
+func parseAPITokens(b []byte) (APITokens, error) {
+	var tokens APITokens
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, err
+	}
+	for i, t := range tokens {
+		if t.ID == "" || t.Token == "" {
+			return nil, fmt.Errorf("API token at index %d is missing an id or token", i)
+		}
+		if len(t.Scopes) == 0 {
+			return nil, fmt.Errorf("API token %q has no scopes", t.ID)
+		}
+	}
+	return tokens, nil
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +22,8 @@ type Cli struct {
 	ClusterAdvertiseAddress   string
 	MistEnabled               bool
 	MistTriggerSetup          bool
+	MistTriggerSecret         string
+	MistTriggerAllowedIPs     []*net.IPNet
 	MistHost                  string
 	MistUser                  string
 	MistPassword              string
@@ -35,18 +38,40 @@ type Cli struct {
 	MistLoadBalancerPort      int
 	MistLoadBalancerTemplate  string
 	MistCleanup               bool
+	MistConfigBackupURL       string
+	MistConfigBackupInterval  time.Duration
+	MistMinVersion            string
 	LogSysUsage               bool
+	PeriodicTasksMode         string
 	AMQPURL                   string
 	OwnRegion                 string
 	OwnRegionTagAdjust        int
 	APIToken                  string
+	APITokensJSON             string
+	APITokensFile             string
+	APITokens                 APITokens
 	APIServer                 string
+	IngestQuotas              bool
+	LiveProfileUpdates        bool
+	TenantIsolation           bool
+	TenantID                  string
+	SRTPortMin                int
+	SRTPortMax                int
+	SRTIngestHost             string
+	SRTPortsFile              string
 	SourceOutput              string
+	JanitorInterval           time.Duration
+	JanitorTTL                time.Duration
 	PrivateBucketURLs         []*url.URL
+	PrivateBucketRulesFile    string
 	ExternalTranscoder        string
+	LanguageDetector          string
 	VodPipelineStrategy       string
 	MetricsDBConnectionString string
+	MetricsExportURL          string
+	MetricsExportInterval     time.Duration
 	NodeStatsConnectionString string
+	NodeStatsBackend          string
 	ImportIPFSGatewayURLs     []*url.URL
 	ImportArweaveGatewayURLs  []*url.URL
 	NodeName                  string
@@ -60,21 +85,55 @@ type Cli struct {
 	EncryptKey                string
 	VodDecryptPublicKey       string
 	VodDecryptPrivateKey      string
+	// Private keys rotated out, still decrypting the sources encrypted for them
+	VodDecryptPreviousKeys    []string
 	StorageFallbackURLs       map[string]string
+	StorageWriteThrough       bool
+	StorageMirrorQueueSize    int
+	StorageMirrorWorkers      int
 	GateURL                   string
 	DataURL                   string
+	GeoIPURL                  string
+	TrustedProxies            []*net.IPNet
+	RecordingVOD              bool
+	RecordingVODProfiles      string
+	RecordingVODTargetURL     string
+	RecordingVODCallbackURL   string
+	FeedImports               string
+	FeedImportStateURL        string
+	FeedImportInterval        time.Duration
+	ArtifactCacheDir          string
+	ArtifactCacheSize         int64
+	RedisURL                  string
+	ConcurrencyTuningInterval time.Duration
+	MinInFlightJobs           int
+	MinParallelTranscodeJobs  int
+	// Semicolon separated cron specs and durations of the node's maintenance windows
+	MaintenanceWindows        string
+	MaintenanceDrainLead      time.Duration
 	StreamHealthHookURL       string
 	BroadcasterURL            string
+	BroadcasterURLs           string
+	BroadcasterHealthInterval time.Duration
+	DiscoveryHealthInterval   time.Duration
 	SourcePlaybackHosts       map[string]string
+	SourcePlaybackSecret      string
+	// Key of the signed playback URLs, see playback.URLSigner. Empty disables them.
+	PlaybackSigningKey        string
+	PlaybackRequireSignedURLs bool
 	DefaultQuality            int
 	MaxBitrateFactor          float64
 	BlockedJWTs               []string
+	BlockedJWTsList           string
+	BlockedJWTsRefresh        time.Duration
 	EnableAnalytics           string
 	KafkaBootstrapServers     string
 	KafkaUser                 string
 	KafkaPassword             string
 	AnalyticsKafkaTopic       string
 	UserEndKafkaTopic         string
+	AnalyticsHeatmapInterval  time.Duration
+	AnalyticsHeatmapTopic     string
 	SerfMembersEndpoint       string
 	EventsEndpoint            string
 	CatalystApiURL            string
@@ -83,14 +142,31 @@ type Cli struct {
 	CdnRedirectPlaybackPct             map[string]float64
 	CdnRedirectPrefix                  *url.URL
 	CdnRedirectPrefixCatalystSubdomain bool
+	CdnRedirectOverridesFile           string
+	VanityPathsFile                    string
 
 	C2PAPrivateKeyPath string
 	C2PACertsPath      string
 
+	FaultInjectionRates map[string]float64
+
+	// Fraction of the successful requests to high volume routes (e.g. playback) to write to the access logs
+	AccessLogSampleRate float64
+
+	// Limits of each of the public and internal API servers, 0 for none
+	HTTPMaxConnections   int
+	HTTPReadTimeout      time.Duration
+	HTTPWriteTimeout     time.Duration
+	HTTPIdleTimeout      time.Duration
+	HTTPRouteConcurrency map[string]int
+
 	CataBalancer                    string
 	CataBalancerMetricTimeout       time.Duration
 	CataBalancerIngestStreamTimeout time.Duration
 	CataBalancerCacheExpiry         time.Duration
+	CataBalancerIngestAffinity      time.Duration
+	FederationCluster               string
+	FederationPeers                 map[string]string
 	SerfQueueSize                   int
 	SerfEventBuffer                 int
 	SerfMaxQueueDepth               int
@@ -122,6 +198,11 @@ func (cli *Cli) ShouldMapic() bool {
 	return cli.APIServer != ""
 }
 
+// Is the analytics API receiving the logs of players enabled?
+func (cli *Cli) AnalyticsEnabled() bool {
+	return cli.EnableAnalytics == "true" || cli.EnableAnalytics == "enabled"
+}
+
 func (cli *Cli) IsClusterMode() bool {
 	return cli.Mode == "cluster-only" || cli.Mode == "all"
 }
@@ -130,6 +211,11 @@ func (cli *Cli) IsApiMode() bool {
 	return cli.Mode == "api-only" || cli.Mode == "all"
 }
 
+// Are the catabalancer node stats disseminated through Serf rather than a Postgres DB?
+func (cli *Cli) NodeStatsViaSerf() bool {
+	return cli.NodeStatsBackend == "serf"
+}
+
 // Should we enable mist-cleanup script to run periodically and delete leaky shm?
 func (cli *Cli) ShouldMistCleanup() bool {
 	return cli.MistCleanup
@@ -248,6 +334,38 @@ func CommaSliceFlag(fs *flag.FlagSet, dest *[]string, name string, value []strin
 	})
 }
 
+// handles -foo=10.0.0.0/8,192.168.1.1, bare IPs only matching themselves
+func IPNetSliceFlag(fs *flag.FlagSet, dest *[]*net.IPNet, name, usage string) {
+	fs.Func(name, usage, func(s string) error {
+		var nets []*net.IPNet
+		for _, str := range strings.Split(s, ",") {
+			str = strings.TrimSpace(str)
+			if str == "" {
+				continue
+			}
+			if !strings.Contains(str, "/") {
+				ip := net.ParseIP(str)
+				if ip == nil {
+					return fmt.Errorf("invalid IP %q", str)
+				}
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(str)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %q: %w", str, err)
+			}
+			nets = append(nets, ipNet)
+		}
+		*dest = nets
+		return nil
+	})
+}
+
 // handles -foo=value1:10.3,value2:99.9,value3:0.1,value4:100,value5:0
 func CommaWithPctSliceFlag(fs *flag.FlagSet, dest *map[string]float64, name string, value map[string]float64, usage string) {
 	*dest = value
@@ -278,6 +396,35 @@ func CommaWithPctSliceFlag(fs *flag.FlagSet, dest *map[string]float64, name stri
 	})
 }
 
+// FaultInjectionTargets are the types of calls that can be failed with -fault-inject
+var FaultInjectionTargets = []string{"upload", "broadcaster", "mist"}
+
+// handles -fault-inject=upload:0.05,broadcaster:0.1
+func FaultInjectionFlag(fs *flag.FlagSet, dest *map[string]float64, name string, usage string) {
+	*dest = map[string]float64{}
+	fs.Func(name, usage, func(s string) error {
+		rates := map[string]float64{}
+		if s != "" {
+			for _, pair := range strings.Split(s, ",") {
+				target, rateStr, ok := strings.Cut(pair, ":")
+				if !ok {
+					return fmt.Errorf("unexpected format of %s, expected target:rate", pair)
+				}
+				if !slices.Contains(FaultInjectionTargets, target) {
+					return fmt.Errorf("unknown fault injection target %q, expected one of %v", target, FaultInjectionTargets)
+				}
+				rate, err := strconv.ParseFloat(rateStr, 64)
+				if err != nil || rate < 0 || rate > 1 {
+					return fmt.Errorf("invalid fault injection rate %s - should be between 0 and 1", pair)
+				}
+				rates[target] = rate
+			}
+		}
+		*dest = rates
+		return nil
+	})
+}
+
 func parseCommaMap(s string) (map[string]string, error) {
 	output := map[string]string{}
 	if s == "" {
@@ -304,6 +451,27 @@ func CommaMapFlag(fs *flag.FlagSet, dest *map[string]string, name string, value
 	})
 }
 
+// handles -foo=key1=10,key2=20
+func CommaIntMapFlag(fs *flag.FlagSet, dest *map[string]int, name string, usage string) {
+	*dest = map[string]int{}
+	fs.Func(name, usage, func(s string) error {
+		pairs, err := parseCommaMap(s)
+		if err != nil {
+			return err
+		}
+		output := map[string]int{}
+		for k, v := range pairs {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid value %q for %s, must be a non-negative integer", v, k)
+			}
+			output[k] = n
+		}
+		*dest = output
+		return nil
+	})
+}
+
 type InvertedBool struct {
 	Value *bool
 }
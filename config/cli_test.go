@@ -2,6 +2,9 @@ package config
 
 import (
 	"flag"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -71,6 +74,28 @@ func TestCommaSlice(t *testing.T) {
 	require.Equal(t, setEmpty, []string{})
 }
 
+func TestIPNetSlice(t *testing.T) {
+	fs := flag.NewFlagSet("cli-test", flag.PanicOnError)
+	var nets, unset []*net.IPNet
+	IPNetSliceFlag(fs, &nets, "nets", "")
+	IPNetSliceFlag(fs, &unset, "unset", "")
+	err := fs.Parse([]string{
+		"-nets=10.0.0.0/8, 192.168.1.1,::1",
+	})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	require.True(t, nets[0].Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, nets[1].Contains(net.ParseIP("192.168.1.1")))
+	require.False(t, nets[1].Contains(net.ParseIP("192.168.1.2")))
+	require.True(t, nets[2].Contains(net.ParseIP("::1")))
+	require.Empty(t, unset)
+
+	fs = flag.NewFlagSet("cli-test", flag.ContinueOnError)
+	IPNetSliceFlag(fs, &nets, "nets", "")
+	require.Error(t, fs.Parse([]string{"-nets=10.0.0.0/33"}))
+	require.Error(t, fs.Parse([]string{"-nets=not-an-ip"}))
+}
+
 func TestCommaWithPctSliceFlag(t *testing.T) {
 	fs := flag.NewFlagSet("cli-test", flag.PanicOnError)
 	var single, multi, keepDefault, empty map[string]float64
@@ -129,6 +154,38 @@ func TestCommaMap(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestCommaIntMap(t *testing.T) {
+	fs := flag.NewFlagSet("cli-test", flag.ContinueOnError)
+	var caps, keepDefault map[string]int
+	CommaIntMapFlag(fs, &caps, "caps", "")
+	CommaIntMapFlag(fs, &keepDefault, "default", "")
+	require.NoError(t, fs.Parse([]string{"-caps=/api/mist/trigger=200,/api/vod/:requestID/priority=5"}))
+	require.Equal(t, map[string]int{"/api/mist/trigger": 200, "/api/vod/:requestID/priority": 5}, caps)
+	require.Equal(t, map[string]int{}, keepDefault)
+
+	for _, wrong := range []string{"/api/vod", "/api/vod=x", "/api/vod=-1"} {
+		fs := flag.NewFlagSet("cli-test", flag.ContinueOnError)
+		CommaIntMapFlag(fs, &caps, "caps", "")
+		require.Error(t, fs.Parse([]string{"-caps=" + wrong}), wrong)
+	}
+}
+
+func TestFaultInjectionFlag(t *testing.T) {
+	fs := flag.NewFlagSet("cli-test", flag.ContinueOnError)
+	var rates, keepDefault map[string]float64
+	FaultInjectionFlag(fs, &rates, "fault-inject", "")
+	FaultInjectionFlag(fs, &keepDefault, "default", "")
+	require.NoError(t, fs.Parse([]string{"-fault-inject=upload:0.05,broadcaster:0.1"}))
+	require.Equal(t, map[string]float64{"upload": 0.05, "broadcaster": 0.1}, rates)
+	require.Equal(t, map[string]float64{}, keepDefault)
+
+	for _, wrong := range []string{"upload", "upload:2", "upload:x", "database:0.1"} {
+		fs := flag.NewFlagSet("cli-test", flag.ContinueOnError)
+		FaultInjectionFlag(fs, &rates, "fault-inject", "")
+		require.Error(t, fs.Parse([]string{"-fault-inject=" + wrong}), wrong)
+	}
+}
+
 func TestInvertedBool(t *testing.T) {
 	fs := flag.NewFlagSet("cli-test", flag.PanicOnError)
 	var pen, pencil, crayon, marker, paintbrush bool
@@ -159,3 +216,34 @@ func TestInvertedBool(t *testing.T) {
 	require.Equal(t, falseFlag.String(), "false")
 	require.Equal(t, nilFlag.String(), "")
 }
+
+func TestLoadAPITokens(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(tokensFile, []byte(`[{"id": "studio", "token": "s3cr3t", "scopes": ["vod:write"]}]`), 0600))
+
+	tokens, err := LoadAPITokens("legacy", `[{"id": "ops", "token": "0ps", "scopes": ["admin:read", "admin:write"]}]`, tokensFile)
+	require.NoError(t, err)
+	require.Len(t, tokens, 3)
+
+	token, ok := tokens.Lookup("legacy")
+	require.True(t, ok)
+	require.Equal(t, DefaultAPITokenID, token.ID)
+	require.True(t, token.HasScope(ScopeTriggersWrite))
+
+	token, ok = tokens.Lookup("s3cr3t")
+	require.True(t, ok)
+	require.Equal(t, "studio", token.ID)
+	require.True(t, token.HasScope(ScopeVODWrite))
+	require.False(t, token.HasScope(ScopeAdminRead))
+
+	_, ok = tokens.Lookup("nope")
+	require.False(t, ok)
+
+	_, err = LoadAPITokens("", `[{"id": "ops", "token": "0ps"}]`, "")
+	require.ErrorContains(t, err, "has no scopes")
+
+	_, err = LoadAPITokens("", `[{"id": "default", "token": "0ps", "scopes": ["*"]}]`, "")
+	require.NoError(t, err)
+	_, err = LoadAPITokens("legacy", `[{"id": "default", "token": "0ps", "scopes": ["*"]}]`, "")
+	require.ErrorContains(t, err, "duplicate")
+}
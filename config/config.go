@@ -44,10 +44,138 @@ const MaxInputFileSizeBytes = 30 * 1024 * 1024 * 1024 // 30 GiB
 
 var TranscodingParallelJobs int = 2
 
+// Maximum number of workers added to a job on top of TranscodingParallelJobs when its priority is bumped, across all of
+// its bumps
+var TranscodingMaxExtraWorkers int = 8
+
+// Maximum number of segments transcoded at the same time across all jobs, handed out by job priority. Set to
+// MaxInFlightJobs * TranscodingParallelJobs by default, 0 or less means no limit.
+var TranscodingSegmentSlots int = 0
+
+// Number of outputs of a job signed with C2PA manifests at the same time
+var C2PASigningWorkers = 4
+
 var TranscodingParallelSleep time.Duration = 10 * time.Second
 
+// Idle connections kept open to each broadcaster, so that the segments of parallel transcodes are posted over reused
+// connections instead of setting up new ones
+var BroadcasterMaxIdleConnsPerHost = 32
+
+// Negotiates HTTP/2 with HTTPS broadcasters, multiplexing the segment posts over a single connection
+var BroadcasterHTTP2 = true
+
 var DownloadOSURLRetries uint64 = 10
 
+// Maximum number of uploads running in parallel to a single bucket. Lowered temporarily while the provider throttles
+// us, and raised back up as uploads succeed again. 0 means no limit.
+var StorageUploadParallelism = 64
+
+// How long to wait for the source pre-flight check done when a job is submitted. 0 disables the check.
+var SourcePreflightTimeout = 5 * time.Second
+
+// Forces the generated playlists to only reference their renditions and segments with relative URIs, so that the
+// outputs can be served from any domain fronting the bucket
+var RelativePlaylistURIs = false
+
+// How long to wait for a direct upload to complete when the request doesn't specify a timeout
+var DefaultDirectUploadTimeout = 24 * time.Hour
+
+// How long the retries of a completed job with the same Idempotency-Key return the completed job instead of starting a
+// new one. In-flight jobs are always deduplicated.
+var IdempotencyWindow = time.Hour
+
+// How long a job may run for when the request doesn't specify a deadline. Bounds all of its stages, from copying the
+// input to uploading the outputs. 0 is no deadline.
+var DefaultJobDeadline time.Duration
+
+// How long a successful probe of a source is reused for, as long as the source's ETag or size is unchanged. 0 disables
+// the cache.
+var ProbeCacheTTL = 15 * time.Minute
+
+// How long to send re-uploads of content that failed the ffmpeg pipeline straight to the external one, when the
+// fallback_external strategy is used. 0 disables remembering these outcomes.
+var PipelineDecisionTTL = 30 * 24 * time.Hour
+
+// How long the source playback sessions of private buckets let players renew their signed URLs for
+var SourcePlaybackSessionTTL = 7 * 24 * time.Hour
+
+// Rate (per second) and burst of the STREAM_BUFFER, LIVE_TRACK_LIST and PUSH_END triggers handled for each Mist stream,
+// so that a stream flapping doesn't delay the triggers of the others. Triggers over the limit are delayed, coalesced or
+// dropped. 0 disables it.
+var MistTriggerStreamRate = 2.0
+var MistTriggerStreamBurst = 20
+
+// How often to check whether a direct upload has completed, in case no storage event notification arrives
+var DirectUploadPollInterval = 10 * time.Second
+
+// How long to wait for the published HLS manifests of a job to be served before sending its completion callback anyway.
+// 0 disables the check.
+var PublishedManifestTimeout = 30 * time.Second
+
+// CDN that the published VOD outputs are requested through to warm its cache after a job completes, nil to not warm it
+var CDNWarmUpPrefix *url.URL
+
+// Number of segments of each rendition requested when warming the CDN cache
+var CDNWarmUpSegments = 3
+
+// How the renditions we generate are named when the request doesn't say, see video.RenditionNaming
+var RenditionNaming = "legacy"
+
+// Encrypts the segments and clips staged on the local disk while generating the MP4 renditions and clipping, with a
+// key generated for each job, see video.ScratchKey
+var EncryptScratchFiles = false
+
+// Checks the Mist headers (.dtsh) of the MP4 outputs once the VOD jobs complete, generating the missing and invalid ones
+// with MistInMP4
+var RepairDTSH = false
+
+// Converts the sources in containers or with codecs the pipelines don't support, e.g. AVI or WMV files, into MP4s with
+// local ffmpeg before transcoding them
+var NormalizeSources = false
+
+// How long the signed playback URLs are valid for, unless asked otherwise when they're minted
+var PlaybackSignedURLTTL = time.Hour
+
+// The longest the signed playback URLs can be asked to be valid for
+var PlaybackSignedURLMaxTTL = 7 * 24 * time.Hour
+
+// Tailors the master playlists served by the playback endpoint to the device playing them, see
+// playback.TailorMasterPlaylist
+var PlaybackDeviceTailoring = false
+
+// Resource limits of the ffmpeg subprocesses run to clip, mux, concatenate, segment and generate thumbnails, see
+// video.RunFFmpeg. 0 (or empty) leaves each of them unlimited.
+var (
+	// Niceness of the subprocesses, from -20 to 19
+	FFmpegNice = 0
+	// IO scheduling class of the subprocesses: 1 realtime, 2 best-effort or 3 idle, and the level within the
+	// best-effort class from 0 (highest) to 7
+	FFmpegIONiceClass = 0
+	FFmpegIONiceLevel = 4
+	// Data segment (heap) memory a subprocess can allocate
+	FFmpegMaxMemoryMB = 0
+	// CPU time a subprocess can use before it's killed
+	FFmpegMaxCPUTime time.Duration = 0
+	// Wall clock time a subprocess can run for before it's killed, overriding the timeout of each operation
+	FFmpegTimeout time.Duration = 0
+	// cgroup v2 directory the subprocesses are started in, e.g. with the cpu.max and memory.max set up by the deployment
+	FFmpegCgroup = ""
+)
+
+// Directory the log lines of each VOD job are written to as <request ID>.log, empty to not keep them
+var JobLogsDir = ""
+
+// How long the log lines of VOD jobs are kept in JobLogsDir when they aren't uploaded
+var JobLogsRetention = 72 * time.Hour
+
+// Object store URL the log lines of each VOD job are uploaded to as <request ID>.log once it finishes, empty to not
+// upload them. Not next to the outputs, which are usually public.
+var JobLogsURL = ""
+
+// Rates (0-1) at which calls are randomly failed, keyed by the type of call (upload, broadcaster or mist), to test
+// the retry and fallback logic in staging. Must stay empty in production.
+var FaultInjectionRates = map[string]float64{}
+
 var ImportIPFSGatewayURLs []*url.URL
 
 var ImportArweaveGatewayURLs []*url.URL
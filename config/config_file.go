@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/peterbourgon/ff/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// Config files set the flags of catalyst-api, keyed by flag name, e.g. `max-inflight-jobs: 8`. They're YAML (.yaml,
+// .yml), JSON (.json) or, with any other extension, the plain "name value" lines of ff. Lists are set as their
+// comma-separated items and maps as comma-separated key=value pairs. The precedence, highest first, is the command line
+// flags, the CATALYST_API_ environment variables, the config file and the flag defaults.
+
+// Flags that only make sense on the command line
+var cliOnlyFlags = map[string]bool{"config": true, "print-config": true, "print-config-schema": true, "version": true, "j": true}
+
+// ConfigFileParser parses the config file given with the fileFlag flag of the flag set by its extension, rejecting the
+// keys that aren't flags
+func ConfigFileParser(fs *flag.FlagSet, fileFlag string) ff.ConfigFileParser {
+	return func(r io.Reader, set func(name, value string) error) error {
+		var parse ff.ConfigFileParser
+		switch strings.ToLower(filepath.Ext(fs.Lookup(fileFlag).Value.String())) {
+		case ".yaml", ".yml":
+			parse = structuredParser(yaml.Unmarshal)
+		case ".json":
+			parse = structuredParser(unmarshalJSON)
+		default:
+			parse = ff.PlainParser
+		}
+		return parse(r, func(name, value string) error {
+			if cliOnlyFlags[name] {
+				return fmt.Errorf("config file flag %q can only be set on the command line", name)
+			}
+			return set(name, value)
+		})
+	}
+}
+
+func unmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// structuredParser parses the config files holding a map of flags
+func structuredParser(unmarshal func([]byte, interface{}) error) ff.ConfigFileParser {
+	return func(r io.Reader, set func(name, value string) error) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		flags := map[string]interface{}{}
+		if err := unmarshal(data, &flags); err != nil {
+			return fmt.Errorf("invalid config file: %w", err)
+		}
+		names := make([]string, 0, len(flags))
+		for name := range flags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, err := configFileValue(flags[name])
+			if err != nil {
+				return fmt.Errorf("invalid value for config file flag %q: %w", name, err)
+			}
+			if err := set(name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// configFileValue returns the flag value of a config file value
+func configFileValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = val
+		}
+		return mapValue(m)
+	case map[string]interface{}:
+		return mapValue(v)
+	}
+	return scalarValue(v)
+}
+
+func mapValue(m map[string]interface{}) (string, error) {
+	pairs := make([]string, 0, len(m))
+	for k, val := range m {
+		s, err := scalarValue(val)
+		if err != nil {
+			return "", err
+		}
+		pairs = append(pairs, k+"="+s)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ","), nil
+}
+
+func scalarValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, json.Number:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// FlagValues keeps the values the flags of a flag set are given, as the flags parsed by funcs don't return them
+type FlagValues map[string]string
+
+type recordedValue struct {
+	flag.Value
+	name   string
+	values FlagValues
+}
+
+func (v *recordedValue) Set(s string) error {
+	if err := v.Value.Set(s); err != nil {
+		return err
+	}
+	v.values[v.name] = s
+	return nil
+}
+
+func (v *recordedValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// RecordFlagValues records the values the flags of the flag set are given from now on
+func RecordFlagValues(fs *flag.FlagSet) FlagValues {
+	values := FlagValues{}
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Value = &recordedValue{Value: f.Value, name: f.Name, values: values}
+	})
+	return values
+}
+
+var secretFlagWords = []string{"password", "secret", "token", "key", "connection-string"}
+
+func isSecretFlag(name string) bool {
+	if strings.HasSuffix(name, "-file") {
+		return false
+	}
+	for _, word := range secretFlagWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// The URLs found in flag values, wherever they are: list items, the values of map pairs or in JSON strings
+var regexpConfigURL = regexp.MustCompile(`(?i)[a-z][a-z0-9+.-]*://[^\s",'<>]+`)
+
+// redactConfigValue hides the credentials of the URLs of a flag value, i.e. their passwords and the values of their
+// secret query parameters like the signatures of presigned URLs and SAS tokens
+func redactConfigValue(value string) string {
+	value = regexpConfigURL.ReplaceAllStringFunc(value, log.RedactURL)
+	return log.RedactLine(value)
+}
+
+// PrintConfig writes the effective config as a YAML config file, with the secrets redacted
+func PrintConfig(w io.Writer, fs *flag.FlagSet, values FlagValues) error {
+	config := yaml.MapSlice{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if cliOnlyFlags[f.Name] {
+			return
+		}
+		value, ok := values[f.Name]
+		if !ok {
+			value = f.Value.String()
+		}
+		if value != "" && isSecretFlag(f.Name) {
+			value = "REDACTED"
+		}
+		config = append(config, yaml.MapItem{Key: f.Name, Value: redactConfigValue(value)})
+	})
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+type configSchemaEntry struct {
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	Default string `yaml:"default,omitempty"`
+	Env     string `yaml:"env"`
+	Usage   string `yaml:"usage"`
+}
+
+// PrintConfigSchema writes the keys of the config file with their type, default, environment variable and usage
+func PrintConfigSchema(w io.Writer, fs *flag.FlagSet, envVarPrefix string) error {
+	var schema []configSchemaEntry
+	fs.VisitAll(func(f *flag.Flag) {
+		if cliOnlyFlags[f.Name] {
+			return
+		}
+		unwrapped := *f
+		if v, ok := f.Value.(*recordedValue); ok {
+			unwrapped.Value = v.Value
+		}
+		typ, usage := flag.UnquoteUsage(&unwrapped)
+		if typ == "" {
+			typ = "bool"
+		}
+		schema = append(schema, configSchemaEntry{
+			Name:    f.Name,
+			Type:    typ,
+			Default: f.DefValue,
+			Env:     envVarPrefix + "_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_")),
+			Usage:   usage,
+		})
+	})
+	out, err := yaml.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
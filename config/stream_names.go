@@ -1,8 +1,11 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"regexp"
 	"strings"
 )
 
@@ -13,12 +16,63 @@ const RENDITION_PREFIX = "tr_rend_+"
 const SEGMENTING_PREFIX = "catalyst_vod_"
 const RECORDING_PREFIX = "video"
 
+// Separates the tenant from the playback ID in the stream names of a tenant, e.g. video+tenant1-abcd1234. Playback IDs
+// don't contain it.
+const TENANT_SEPARATOR = "-"
+
+// StreamTenant is the tenant the stream names of this node are qualified with, so that the playback IDs of tenants
+// sharing Mist don't collide. The names aren't qualified when it's empty.
+var StreamTenant string
+
+var tenantInvalidChars = regexp.MustCompile(`[^a-z0-9]`)
+
+// TenantPrefix returns the tenant qualifying the stream names, the tenant ID with the characters not allowed in it
+// dropped or, without one, derived from the API token of the tenant
+func TenantPrefix(tenantID, apiToken string) string {
+	if tenantID != "" {
+		return tenantInvalidChars.ReplaceAllString(strings.ToLower(tenantID), "")
+	}
+	sum := sha256.Sum256([]byte(apiToken))
+	return hex.EncodeToString(sum[:4])
+}
+
+// WithTenant qualifies a playback or request ID with the tenant of the node, if any
+func WithTenant(id string) string {
+	if StreamTenant == "" || strings.HasPrefix(id, StreamTenant+TENANT_SEPARATOR) {
+		return id
+	}
+	return StreamTenant + TENANT_SEPARATOR + id
+}
+
+// SplitTenant strips the tenant of the node from a playback ID qualified with it. The IDs that aren't qualified, e.g.
+// those of the streams started before tenants were enabled, are returned as they are. Mist and its triggers are
+// shared by the tenants, so false is returned for the IDs qualified with another tenant, which must be left alone.
+func SplitTenant(id string) (playbackID string, ok bool) {
+	if StreamTenant == "" {
+		return id, true
+	}
+	tenant, playbackID, qualified := strings.Cut(id, TENANT_SEPARATOR)
+	if !qualified {
+		return id, true
+	}
+	if tenant != StreamTenant {
+		return "", false
+	}
+	return playbackID, true
+}
+
+// PlaybackIDFromStreamName returns the playback ID of a wildcard stream name, e.g. abcd1234 for video+abcd1234 or
+// video+tenant1-abcd1234. Returns false for the stream names of another tenant.
+func PlaybackIDFromStreamName(streamName string) (string, bool) {
+	return SplitTenant(streamName[strings.Index(streamName, "+")+1:])
+}
+
 func IsTranscodeStream(streamName string) bool {
 	return strings.HasPrefix(streamName, RENDITION_PREFIX)
 }
 
 func SegmentingStreamName(requestID string) string {
-	return fmt.Sprintf("%s%s", SEGMENTING_PREFIX, requestID)
+	return fmt.Sprintf("%s%s", SEGMENTING_PREFIX, WithTenant(requestID))
 }
 
 func RandomTrailer(length int) string {
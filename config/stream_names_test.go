@@ -16,3 +16,52 @@ func TestItCanGenerateRandomStreamNames(t *testing.T) {
 		require.Contains(t, r, string(char))
 	}
 }
+
+func TestTenantPrefix(t *testing.T) {
+	require.Equal(t, "tenant1", TenantPrefix("Tenant_1", "token"))
+	prefix := TenantPrefix("", "token")
+	require.Len(t, prefix, 8)
+	require.Equal(t, prefix, TenantPrefix("", "token"))
+	require.NotEqual(t, prefix, TenantPrefix("", "other-token"))
+}
+
+func TestStreamNamesWithTenant(t *testing.T) {
+	require.Equal(t, "abcd", WithTenant("abcd"))
+	playbackID, ok := PlaybackIDFromStreamName("video+abcd")
+	require.True(t, ok)
+	require.Equal(t, "abcd", playbackID)
+
+	StreamTenant = "tenant1"
+	defer func() { StreamTenant = "" }()
+
+	require.Equal(t, "tenant1-abcd", WithTenant("abcd"))
+	require.Equal(t, "tenant1-abcd", WithTenant("tenant1-abcd"))
+	require.Equal(t, "catalyst_vod_tenant1-abcd", SegmentingStreamName("abcd"))
+
+	playbackID, ok = SplitTenant("tenant1-abcd")
+	require.True(t, ok)
+	require.Equal(t, "abcd", playbackID)
+	playbackID, ok = SplitTenant("abcd")
+	require.True(t, ok)
+	require.Equal(t, "abcd", playbackID)
+
+	playbackID, ok = PlaybackIDFromStreamName("video+tenant1-abcd")
+	require.True(t, ok)
+	require.Equal(t, "abcd", playbackID)
+	playbackID, ok = PlaybackIDFromStreamName("video+abcd")
+	require.True(t, ok)
+	require.Equal(t, "abcd", playbackID)
+}
+
+func TestStreamNamesOfAnotherTenant(t *testing.T) {
+	StreamTenant = "tenant1"
+	defer func() { StreamTenant = "" }()
+
+	_, ok := SplitTenant("tenant2-abcd")
+	require.False(t, ok)
+	_, ok = PlaybackIDFromStreamName("video+tenant2-abcd")
+	require.False(t, ok)
+	// A tenant that's a prefix of ours is still another tenant
+	_, ok = PlaybackIDFromStreamName("video+tenant-abcd")
+	require.False(t, ok)
+}
@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// KeyRing holds the private keys VOD sources can be encrypted for, by key ID, so that the key of a node can be rotated
+// without breaking the uploads still encrypted for the previous ones. The current key is the one whose public key is
+// handed out to encrypt new uploads for.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[string]*rsa.PrivateKey
+	current string
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[string]*rsa.PrivateKey{}}
+}
+
+// KeyID identifies a key by its public key: the start of the SHA-256 of its PKIX encoding, in hex
+func KeyID(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// EncodePublicKey encodes a public key the way -catalyst-public-key is: a base64 encoded PKCS#1 PEM block
+func EncodePublicKey(pub *rsa.PublicKey) string {
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(pub)})
+	return base64.StdEncoding.EncodeToString(block)
+}
+
+// Add adds a key to the ring, making it the current one if current is set. Adding a key that's in the ring already
+// can still make it current. Returns the ID of the key.
+func (r *KeyRing) Add(key *rsa.PrivateKey, current bool) (string, error) {
+	keyID, err := KeyID(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[keyID] = key
+	if current {
+		r.current = keyID
+	}
+	return keyID, nil
+}
+
+// Get returns the key of a key ID. Payloads without a key ID, encrypted before keys could be rotated, get the current
+// key.
+func (r *KeyRing) Get(keyID string) (*rsa.PrivateKey, error) {
+	if r == nil {
+		return nil, fmt.Errorf("no decryption keys configured")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if keyID == "" {
+		keyID = r.current
+	}
+	key, ok := r.keys[keyID]
+	if !ok {
+		if keyID == "" {
+			return nil, fmt.Errorf("no decryption keys configured")
+		}
+		return nil, fmt.Errorf("unknown decryption key ID %q", keyID)
+	}
+	return key, nil
+}
+
+// Current returns the current key and its ID, or a nil key when the ring is empty
+func (r *KeyRing) Current() (string, *rsa.PrivateKey) {
+	if r == nil {
+		return "", nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, r.keys[r.current]
+}
+
+// IDs returns the IDs of the keys in the ring, sorted
+func (r *KeyRing) IDs() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.keys))
+	for id := range r.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
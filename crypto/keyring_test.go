@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRingSelectsKeysByID(t *testing.T) {
+	previous, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	current, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	keys := NewKeyRing()
+	_, err = keys.Get("")
+	require.ErrorContains(t, err, "no decryption keys configured")
+
+	previousID, err := keys.Add(previous, false)
+	require.NoError(t, err)
+	currentID, err := keys.Add(current, true)
+	require.NoError(t, err)
+	require.NotEqual(t, previousID, currentID)
+	require.Len(t, currentID, 16)
+
+	key, err := keys.Get(previousID)
+	require.NoError(t, err)
+	require.Equal(t, previous, key)
+	key, err = keys.Get(currentID)
+	require.NoError(t, err)
+	require.Equal(t, current, key)
+
+	// Payloads without a key ID are decrypted with the current key
+	key, err = keys.Get("")
+	require.NoError(t, err)
+	require.Equal(t, current, key)
+
+	_, err = keys.Get("0123456789abcdef")
+	require.ErrorContains(t, err, `unknown decryption key ID "0123456789abcdef"`)
+
+	// Adding a key again only makes it current
+	id, err := keys.Add(previous, true)
+	require.NoError(t, err)
+	require.Equal(t, previousID, id)
+	currentKeyID, key := keys.Current()
+	require.Equal(t, previousID, currentKeyID)
+	require.Equal(t, previous, key)
+	require.ElementsMatch(t, []string{previousID, currentID}, keys.IDs())
+}
+
+func TestKeyIDIsStable(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	id, err := KeyID(&key.PublicKey)
+	require.NoError(t, err)
+	again, err := KeyID(&key.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, id, again)
+
+	// The encoded public key is the format of -catalyst-public-key, which a loaded key must match
+	valid, err := ValidateKeyPair(EncodePublicKey(&key.PublicKey), *key)
+	require.NoError(t, err)
+	require.True(t, valid)
+}
+
+func TestNilKeyRing(t *testing.T) {
+	var keys *KeyRing
+	_, err := keys.Get("")
+	require.ErrorContains(t, err, "no decryption keys configured")
+	id, key := keys.Current()
+	require.Empty(t, id)
+	require.Nil(t, key)
+}
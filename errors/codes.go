@@ -0,0 +1,99 @@
+package errors
+
+import (
+	"net/http"
+)
+
+// Error codes are the stable identifiers of errors, set as "error_code" in the API error responses, the error status
+// callbacks of jobs and the vod_completed metrics, for callers to handle errors programmatically rather than by their
+// free-form message. Codes are never renamed or reused, only added.
+//
+// The errors failing jobs get the code of their class:
+//
+//	not_found          INPUT_NOT_FOUND      the source, or an object the job reads, doesn't exist
+//	access_denied      STORAGE_AUTH_FAILED  the credentials of a storage were rejected
+//	invalid_request    INVALID_REQUEST      the job request can't be processed as is
+//	unsupported_input  UNSUPPORTED_INPUT    the source can't be read or transcoded
+//	storage            STORAGE_FAILED       a storage failed reading or writing
+//	broadcaster        BROADCASTER_FAILED   the broadcaster failed transcoding segments
+//	transcode          TRANSCODE_FAILED     the transcoder failed
+//	timeout            TRANSCODE_TIMEOUT    the job ran past its deadline
+//	unknown            UNKNOWN_ERROR        any other error
+//
+// The API error responses get the code of the class of their error when it's known, like the errors failing jobs,
+// and of their HTTP status otherwise:
+//
+//	400  INVALID_REQUEST
+//	401  UNAUTHORIZED
+//	403  FORBIDDEN
+//	404  NOT_FOUND
+//	415  UNSUPPORTED_MEDIA_TYPE
+//	416  RANGE_NOT_SATISFIABLE
+//	429  TOO_MANY_REQUESTS
+//	451  UNAVAILABLE_FOR_LEGAL_REASONS
+//	500  INTERNAL_ERROR
+const (
+	CodeInputNotFound       = "INPUT_NOT_FOUND"
+	CodeStorageAuthFailed   = "STORAGE_AUTH_FAILED"
+	CodeInvalidRequest      = "INVALID_REQUEST"
+	CodeUnsupportedInput    = "UNSUPPORTED_INPUT"
+	CodeStorageFailed       = "STORAGE_FAILED"
+	CodeBroadcasterFailed   = "BROADCASTER_FAILED"
+	CodeTranscodeFailed     = "TRANSCODE_FAILED"
+	CodeTranscodeTimeout    = "TRANSCODE_TIMEOUT"
+	CodeUnknown             = "UNKNOWN_ERROR"
+	CodeUnauthorized        = "UNAUTHORIZED"
+	CodeForbidden           = "FORBIDDEN"
+	CodeNotFound            = "NOT_FOUND"
+	CodeUnsupportedMedia    = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRangeNotSatisfiable = "RANGE_NOT_SATISFIABLE"
+	CodeTooManyRequests     = "TOO_MANY_REQUESTS"
+	CodeUnavailableLegal    = "UNAVAILABLE_FOR_LEGAL_REASONS"
+	CodeInternal            = "INTERNAL_ERROR"
+)
+
+var classCodes = map[string]string{
+	ClassNotFound:         CodeInputNotFound,
+	ClassAccessDenied:     CodeStorageAuthFailed,
+	ClassInvalidRequest:   CodeInvalidRequest,
+	ClassUnsupportedInput: CodeUnsupportedInput,
+	ClassStorage:          CodeStorageFailed,
+	ClassBroadcaster:      CodeBroadcasterFailed,
+	ClassTranscode:        CodeTranscodeFailed,
+	ClassTimeout:          CodeTranscodeTimeout,
+	ClassUnknown:          CodeUnknown,
+}
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:                   CodeInvalidRequest,
+	http.StatusUnauthorized:                 CodeUnauthorized,
+	http.StatusForbidden:                    CodeForbidden,
+	http.StatusNotFound:                     CodeNotFound,
+	http.StatusUnsupportedMediaType:         CodeUnsupportedMedia,
+	http.StatusRequestedRangeNotSatisfiable: CodeRangeNotSatisfiable,
+	http.StatusTooManyRequests:              CodeTooManyRequests,
+	http.StatusUnavailableForLegalReasons:   CodeUnavailableLegal,
+	http.StatusInternalServerError:          CodeInternal,
+}
+
+// Code returns the code of an error failing a job, from its class
+func Code(err error) string {
+	if code, ok := classCodes[Class(err)]; ok {
+		return code
+	}
+	return CodeUnknown
+}
+
+// responseCode returns the code of an API error response
+func responseCode(status int, err error) string {
+	if err != nil && Class(err) != ClassUnknown {
+		return Code(err)
+	}
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 400 && status < 500 {
+		return CodeInvalidRequest
+	}
+	return CodeInternal
+}
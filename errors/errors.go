@@ -1,10 +1,12 @@
 package errors
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/livepeer/catalyst-api/log"
@@ -13,7 +15,9 @@ import (
 type APIError struct {
 	Msg    string `json:"message"`
 	Status int    `json:"status"`
-	Err    error  `json:"-"`
+	// Stable code of the error, see Code
+	Code string `json:"error_code"`
+	Err  error  `json:"-"`
 }
 
 func writeHttpError(w http.ResponseWriter, msg string, status int, err error) APIError {
@@ -29,10 +33,11 @@ func writeHttpError(w http.ResponseWriter, msg string, status int, err error) AP
 		}
 	}
 
-	if err := json.NewEncoder(w).Encode(map[string]string{"error": msg, "error_detail": errorDetail}); err != nil {
+	code := responseCode(status, err)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": msg, "error_detail": errorDetail, "error_code": code}); err != nil {
 		log.LogNoRequestID("error writing HTTP error", "http_error_msg", msg, "error", err)
 	}
-	return APIError{msg, status, err}
+	return APIError{Msg: msg, Status: status, Code: code, Err: err}
 }
 
 // HTTP Errors
@@ -40,6 +45,10 @@ func WriteHTTPUnauthorized(w http.ResponseWriter, msg string, err error) APIErro
 	return writeHttpError(w, msg, http.StatusUnauthorized, err)
 }
 
+func WriteHTTPForbidden(w http.ResponseWriter, msg string, err error) APIError {
+	return writeHttpError(w, msg, http.StatusForbidden, err)
+}
+
 func WriteHTTPBadRequest(w http.ResponseWriter, msg string, err error) APIError {
 	return writeHttpError(w, msg, http.StatusBadRequest, err)
 }
@@ -52,6 +61,57 @@ func WriteHTTPNotFound(w http.ResponseWriter, msg string, err error) APIError {
 	return writeHttpError(w, msg, http.StatusNotFound, err)
 }
 
+func WriteHTTPRangeNotSatisfiable(w http.ResponseWriter, msg string, err error) APIError {
+	return writeHttpError(w, msg, http.StatusRequestedRangeNotSatisfiable, err)
+}
+
+func WriteHTTPUnavailableForLegalReasons(w http.ResponseWriter, msg string, err error) APIError {
+	return writeHttpError(w, msg, http.StatusUnavailableForLegalReasons, err)
+}
+
+func WriteHTTPUnprocessableEntity(w http.ResponseWriter, msg string, err error) APIError {
+	return writeHttpError(w, msg, http.StatusUnprocessableEntity, err)
+}
+
+func WriteHTTPTooManyRequests(w http.ResponseWriter, msg string, err error) APIError {
+	return writeHttpError(w, msg, http.StatusTooManyRequests, err)
+}
+
+// Backpressure is how loaded a node is when it turns a job away, for callers to back off accordingly rather than retry
+// blindly
+type Backpressure struct {
+	JobType         string `json:"job_type"`
+	InFlightJobs    int    `json:"in_flight_jobs"`
+	MaxInFlightJobs int    `json:"max_in_flight_jobs"`
+	// Requests turned away earlier and still being retried by their callers
+	QueueDepth int `json:"queue_depth"`
+	// Estimated from the recent job throughput of the node, also set as the Retry-After header
+	RetryAfterSecs int `json:"retry_after_seconds"`
+}
+
+// WriteHTTPBackpressure turns a job away with a 429 whose body tells the caller how long to back off for
+func WriteHTTPBackpressure(w http.ResponseWriter, msg string, bp Backpressure) APIError {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(bp.RetryAfterSecs))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	body := struct {
+		Error       string `json:"error"`
+		ErrorDetail string `json:"error_detail"`
+		ErrorCode   string `json:"error_code"`
+		Backpressure
+	}{
+		Error:        msg,
+		ErrorDetail:  fmt.Sprintf("%d of %d %s jobs in flight", bp.InFlightJobs, bp.MaxInFlightJobs, bp.JobType),
+		ErrorCode:    CodeTooManyRequests,
+		Backpressure: bp,
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.LogNoRequestID("error writing HTTP error", "http_error_msg", msg, "error", err)
+	}
+	return APIError{Msg: msg, Status: http.StatusTooManyRequests, Code: CodeTooManyRequests, Err: errors.New(body.ErrorDetail)}
+}
+
 func WriteHTTPInternalServerError(w http.ResponseWriter, msg string, err error) APIError {
 	return writeHttpError(w, msg, http.StatusInternalServerError, err)
 }
@@ -76,6 +136,52 @@ func (e unretriableError) Unwrap() error {
 	return e.error
 }
 
+// Classes of the errors failing jobs, the causes tracked by the job error metric
+const (
+	ClassNotFound         = "not_found"
+	ClassAccessDenied     = "access_denied"
+	ClassInvalidRequest   = "invalid_request"
+	ClassUnsupportedInput = "unsupported_input"
+	ClassStorage          = "storage"
+	ClassBroadcaster      = "broadcaster"
+	ClassTranscode        = "transcode"
+	ClassTimeout          = "timeout"
+	ClassUnknown          = "unknown"
+)
+
+type classifiedError struct {
+	class string
+	error
+}
+
+// WithClass marks the error as being of the given class, see Class. Whether it's retriable is marked separately with
+// Unretriable.
+func WithClass(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return classifiedError{class, err}
+}
+
+// Class returns the class of the error, the outermost one it was marked with. Errors that weren't marked are
+// classified from their cause where possible.
+func Class(err error) string {
+	var classified classifiedError
+	switch {
+	case errors.As(err, &classified):
+		return classified.class
+	case IsObjectNotFound(err):
+		return ClassNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	}
+	return ClassUnknown
+}
+
+func (e classifiedError) Unwrap() error {
+	return e.error
+}
+
 type ObjectNotFoundError struct {
 	msg   string
 	cause error
@@ -109,4 +215,5 @@ func IsObjectNotFound(err error) bool {
 var (
 	UnauthorisedError = errors.New("UnauthorisedError")
 	InvalidJWT        = errors.New("InvalidJWTError")
+	GeoBlocked        = errors.New("GeoBlockedError")
 )
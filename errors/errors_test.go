@@ -1,8 +1,11 @@
 package errors
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/cenkalti/backoff/v4"
@@ -23,3 +26,55 @@ func TestUnretriable(t *testing.T) {
 	var permErr *backoff.PermanentError
 	require.True(t, errors.As(err, &permErr))
 }
+
+func TestClass(t *testing.T) {
+	require.Equal(t, ClassUnknown, Class(fmt.Errorf("bar")))
+	require.Equal(t, ClassNotFound, Class(fmt.Errorf("wrapped: %w", NewObjectNotFoundError("foo", nil))))
+	require.Equal(t, ClassTimeout, Class(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+
+	err := Unretriable(WithClass(ClassAccessDenied, fmt.Errorf("bar")))
+	require.Equal(t, ClassAccessDenied, Class(fmt.Errorf("wrapped: %w", err)))
+	require.True(t, IsUnretriable(err))
+	require.False(t, IsUnretriable(WithClass(ClassStorage, fmt.Errorf("bar"))))
+
+	// The outermost class wins
+	require.Equal(t, ClassTimeout, Class(WithClass(ClassTimeout, fmt.Errorf("wrapped: %w", err))))
+	require.Nil(t, WithClass(ClassStorage, nil))
+}
+
+func TestCode(t *testing.T) {
+	require.Equal(t, CodeUnknown, Code(fmt.Errorf("bar")))
+	require.Equal(t, CodeInputNotFound, Code(NewObjectNotFoundError("foo", nil)))
+	require.Equal(t, CodeTranscodeTimeout, Code(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	require.Equal(t, CodeStorageAuthFailed, Code(Unretriable(WithClass(ClassAccessDenied, fmt.Errorf("bar")))))
+
+	// Every class has a code
+	for _, class := range []string{ClassNotFound, ClassAccessDenied, ClassInvalidRequest, ClassUnsupportedInput, ClassStorage, ClassBroadcaster, ClassTranscode, ClassTimeout, ClassUnknown} {
+		require.NotEmpty(t, classCodes[class], class)
+	}
+}
+
+func TestHTTPErrorCodes(t *testing.T) {
+	rr := httptest.NewRecorder()
+	apiErr := WriteHTTPNotFound(rr, "Stream not found", fmt.Errorf("bar"))
+	require.Equal(t, CodeNotFound, apiErr.Code)
+	require.JSONEq(t, `{"error":"Stream not found", "error_detail":"bar", "error_code":"NOT_FOUND"}`, rr.Body.String())
+
+	// The code of a classified error is more specific than that of the status
+	rr = httptest.NewRecorder()
+	apiErr = WriteHTTPBadRequest(rr, "Invalid source", WithClass(ClassUnsupportedInput, fmt.Errorf("bar")))
+	require.Equal(t, CodeUnsupportedInput, apiErr.Code)
+
+	// As are the errors classified from their cause, the same as when they fail a job
+	rr = httptest.NewRecorder()
+	apiErr = WriteHTTPNotFound(rr, "Source not found", NewObjectNotFoundError("bar", nil))
+	require.Equal(t, CodeInputNotFound, apiErr.Code)
+
+	rr = httptest.NewRecorder()
+	apiErr = WriteHTTPBackpressure(rr, "Too many jobs", Backpressure{JobType: "vod", InFlightJobs: 8, MaxInFlightJobs: 8})
+	require.Equal(t, CodeTooManyRequests, apiErr.Code)
+	require.Contains(t, rr.Body.String(), `"error_code":"TOO_MANY_REQUESTS"`)
+
+	require.Equal(t, CodeInvalidRequest, responseCode(http.StatusConflict, nil))
+	require.Equal(t, CodeInternal, responseCode(http.StatusBadGateway, nil))
+}
@@ -3,11 +3,18 @@ package events
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/livepeer/catalyst-api/video"
 )
 
 const streamEventResource = "stream"
 const nukeEventResource = "nuke"
 const stopSessionsEventResource = "stopSessions"
+const cdnRedirectEventResource = "cdnRedirect"
+const nodeUpdateEventResource = "nodeUpdate"
+const recordingAutoVODEventResource = "recordingAutoVod"
+const vanityPathEventResource = "vanityPath"
+const blockJWTEventResource = "blockJWT"
 
 type Event interface{}
 
@@ -30,6 +37,81 @@ type StopSessionsEvent struct {
 	PlaybackID string `json:"playback_id"`
 }
 
+// CdnRedirectEvent sets the percentage of playback traffic for a playbackID that should be redirected to the CDN.
+// A nil Percentage removes the runtime rule for the playbackID.
+type CdnRedirectEvent struct {
+	Resource   string   `json:"resource"`
+	PlaybackID string   `json:"playback_id"`
+	Percentage *float64 `json:"percentage"`
+}
+
+// VanityPathEvent maps a vanity path, e.g. "my-event" for /v/my-event, to the playbackID it plays.
+// An empty PlaybackID removes the vanity path.
+type VanityPathEvent struct {
+	Resource   string `json:"resource"`
+	Path       string `json:"path"`
+	PlaybackID string `json:"playback_id,omitempty"`
+}
+
+// BlockJWTEvent blocks a playback JWT of the playbackID on every node, rejecting its existing sessions too
+type BlockJWTEvent struct {
+	Resource   string `json:"resource"`
+	PlaybackID string `json:"playback_id"`
+	JWT        string `json:"jwt"`
+}
+
+// RecordingAutoVODEvent sets whether the recordings of a playbackID get a VOD job enqueued once they end, overriding
+// the -recording-auto-vod default. Empty Profiles and TargetURL fall back to the -recording-auto-vod-* defaults.
+type RecordingAutoVODEvent struct {
+	Resource   string                 `json:"resource"`
+	PlaybackID string                 `json:"playback_id"`
+	Enabled    bool                   `json:"enabled"`
+	Profiles   []video.EncodedProfile `json:"profiles,omitempty"`
+	TargetURL  string                 `json:"target_url,omitempty"`
+}
+
+// NodeUpdateEvent carries the stats of a catalyst node for the catabalancer. The payload is kept as is, since it's
+// decoded by the catabalancer node stats store.
+type NodeUpdateEvent struct {
+	Payload []byte
+}
+
+func NewStreamEvent(playbackID string) *StreamEvent {
+	return &StreamEvent{Resource: streamEventResource, PlaybackID: playbackID}
+}
+
+func NewNukeEvent(playbackID string) *NukeEvent {
+	return &NukeEvent{Resource: nukeEventResource, PlaybackID: playbackID}
+}
+
+func NewStopSessionsEvent(playbackID string) *StopSessionsEvent {
+	return &StopSessionsEvent{Resource: stopSessionsEventResource, PlaybackID: playbackID}
+}
+
+func NewCdnRedirectEvent(playbackID string, percentage *float64) *CdnRedirectEvent {
+	return &CdnRedirectEvent{
+		Resource:   cdnRedirectEventResource,
+		PlaybackID: playbackID,
+		Percentage: percentage,
+	}
+}
+
+func NewVanityPathEvent(path, playbackID string) *VanityPathEvent {
+	return &VanityPathEvent{
+		Resource:   vanityPathEventResource,
+		Path:       path,
+		PlaybackID: playbackID,
+	}
+}
+
+func NewBlockJWTEvent(playbackID, jwt string) *BlockJWTEvent {
+	return &BlockJWTEvent{
+		Resource:   blockJWTEventResource,
+		PlaybackID: playbackID,
+		JWT:        jwt,
+	}
+}
+
 func Unmarshal(payload []byte) (Event, error) {
 	var generic GenericEvent
 	err := json.Unmarshal(payload, &generic)
@@ -58,6 +140,36 @@ func Unmarshal(payload []byte) (Event, error) {
 			return nil, err
 		}
 		return event, nil
+	case cdnRedirectEventResource:
+		event := &CdnRedirectEvent{}
+		err := json.Unmarshal(payload, event)
+		if err != nil {
+			return nil, err
+		}
+		return event, nil
+	case vanityPathEventResource:
+		event := &VanityPathEvent{}
+		err := json.Unmarshal(payload, event)
+		if err != nil {
+			return nil, err
+		}
+		return event, nil
+	case blockJWTEventResource:
+		event := &BlockJWTEvent{}
+		err := json.Unmarshal(payload, event)
+		if err != nil {
+			return nil, err
+		}
+		return event, nil
+	case recordingAutoVODEventResource:
+		event := &RecordingAutoVODEvent{}
+		err := json.Unmarshal(payload, event)
+		if err != nil {
+			return nil, err
+		}
+		return event, nil
+	case nodeUpdateEventResource:
+		return &NodeUpdateEvent{Payload: payload}, nil
 	}
 	return nil, fmt.Errorf("unable to unmarshal event, unknown resource '%s'", generic.Resource)
 }
@@ -24,6 +24,17 @@ func TestItCanHandleNukeEvents(t *testing.T) {
 	require.Equal(t, event.PlaybackID, "abc123")
 }
 
+func TestItCanHandleRecordingAutoVODEvents(t *testing.T) {
+	payload := []byte(`{"resource": "recordingAutoVod", "playback_id": "abc123", "enabled": true, "target_url": "s3+https://bucket/{playback_id}/"}`)
+	e, err := Unmarshal(payload)
+	require.NoError(t, err)
+	event, ok := e.(*RecordingAutoVODEvent)
+	require.True(t, ok)
+	require.Equal(t, "abc123", event.PlaybackID)
+	require.True(t, event.Enabled)
+	require.Equal(t, "s3+https://bucket/{playback_id}/", event.TargetURL)
+}
+
 func TestItFailsUnknownEvents(t *testing.T) {
 	payload := []byte(`{"resource": "not-real-thing"}`)
 	_, err := Unmarshal(payload)
@@ -46,3 +57,44 @@ func TestItFailsBadShapes(t *testing.T) {
 		require.Error(t, err)
 	}
 }
+
+func TestItCanHandleCdnRedirectEvents(t *testing.T) {
+	payload := []byte(`{"resource": "cdnRedirect", "playback_id": "abc123", "percentage": 12.5}`)
+	e, err := Unmarshal(payload)
+	require.NoError(t, err)
+	event, ok := e.(*CdnRedirectEvent)
+	require.True(t, ok)
+	require.Equal(t, event.PlaybackID, "abc123")
+	require.Equal(t, *event.Percentage, 12.5)
+
+	payload = []byte(`{"resource": "cdnRedirect", "playback_id": "abc123", "percentage": null}`)
+	e, err = Unmarshal(payload)
+	require.NoError(t, err)
+	require.Nil(t, e.(*CdnRedirectEvent).Percentage)
+}
+
+func TestItCanHandleVanityPathEvents(t *testing.T) {
+	payload := []byte(`{"resource": "vanityPath", "path": "my-event", "playback_id": "abc123"}`)
+	e, err := Unmarshal(payload)
+	require.NoError(t, err)
+	event, ok := e.(*VanityPathEvent)
+	require.True(t, ok)
+	require.Equal(t, "my-event", event.Path)
+	require.Equal(t, "abc123", event.PlaybackID)
+}
+
+func TestItCanHandleNodeUpdateEvents(t *testing.T) {
+	payload := []byte(`{"resource": "nodeUpdate", "n": "node1", "nm": {"c": 12.5}}`)
+	e, err := Unmarshal(payload)
+	require.NoError(t, err)
+	event, ok := e.(*NodeUpdateEvent)
+	require.True(t, ok)
+	require.Equal(t, payload, event.Payload)
+}
+
+func TestItCanHandleBlockJWTEvents(t *testing.T) {
+	payload := []byte(`{"resource": "blockJWT", "playback_id": "abc123", "jwt": "header.claims.signature"}`)
+	e, err := Unmarshal(payload)
+	require.NoError(t, err)
+	require.Equal(t, NewBlockJWTEvent("abc123", "header.claims.signature"), e)
+}
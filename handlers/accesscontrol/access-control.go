@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -17,7 +18,10 @@ import (
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/handlers/misttriggers"
 	"github.com/livepeer/catalyst-api/log"
 	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
@@ -27,14 +31,23 @@ import (
 
 const (
 	concurrentViewerCacheTimeout = 30 * time.Second
+	// Most gate decisions kept in memory, there's one per playback ID and viewer credentials
+	gateDecisionsCacheSize = 100_000
 )
 
 type AccessControlHandlersCollection struct {
-	cache       map[string]map[string]*PlaybackAccessControlEntry
+	// Gate decisions, keyed by playback ID and then cache key
+	cache       *cache.Cache[*PlaybackAccessControlEntry]
 	mutex       sync.RWMutex
 	gateClient  GateAPICaller
 	dataClient  DataAPICaller
-	blockedJWTs []string
+	mapic       mistapiconnector.IMac
+	blockedJWTs map[string]bool
+	// The blocked JWTs of -gate-blocked-jwts-list, on top of blockedJWTs. Nil when there's none.
+	blockedJWTList *blockedJWTList
+	geoLookup      GeoLookup
+	// The proxies whose X-Forwarded-For entries are skipped to find the viewer IPs with, see clientIP
+	trustedProxies []*net.IPNet
 }
 
 type PlaybackAccessControlEntry struct {
@@ -66,6 +79,27 @@ type GateAPICaller interface {
 type GateClient struct {
 	Client  *http.Client
 	gateURL string
+	// Set when the gate APIs are discovered through DNS, see clients.ServiceDiscovery
+	discovery *clients.ServiceDiscovery
+}
+
+// url returns the URL of the gate API to query, one of the discovered ones if they are
+func (g *GateClient) url() (string, error) {
+	if g.discovery == nil {
+		return g.gateURL, nil
+	}
+	return g.discovery.Pick("")
+}
+
+// recordResult excludes the discovered gate APIs that fail to respond, or respond with server errors
+func (g *GateClient) recordResult(gateURL string, res *http.Response, err error) {
+	if g.discovery == nil {
+		return
+	}
+	if err == nil && res.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("gate API responded with status %d", res.StatusCode)
+	}
+	g.discovery.RecordResult(gateURL, err)
 }
 
 // ViewerLimitCache comes from Gate API
@@ -102,6 +136,9 @@ type GateConfig struct {
 	RefreshInterval      int32  `json:"refresh_interval"`
 	UserViewerLimit      int32  `json:"user_viewer_limit"`
 	UserID               string `json:"user_id"`
+	// AllowedCountries and BlockedCountries are the playback ID's geo-restriction policy
+	AllowedCountries []string `json:"allowed_countries"`
+	BlockedCountries []string `json:"blocked_countries"`
 }
 
 var (
@@ -129,9 +166,6 @@ func (ac *AccessControlHandlersCollection) periodicRefreshIntervalCache(mapic mi
 				if time.Since(refreshIntervalCache.data[key].LastRefresh) > time.Duration(refreshIntervalCache.data[key].RefreshInterval)*time.Second {
 					refreshIntervalCache.data[key].LastRefresh = time.Now()
 					keysToInvalidate = append(keysToInvalidate, key)
-					for cachedAccessKey := range ac.cache[key] {
-						delete(ac.cache[key], cachedAccessKey)
-					}
 					break
 				}
 			}
@@ -141,6 +175,9 @@ func (ac *AccessControlHandlersCollection) periodicRefreshIntervalCache(mapic mi
 			if len(keysToInvalidate) > 0 {
 				glog.Infof("Invalidating sessions, count=%d", len(keysToInvalidate))
 				for _, key := range keysToInvalidate {
+					if err := ac.cache.RemovePrefix(gateDecisionsPrefix(key)); err != nil {
+						glog.Errorf("Error invalidating the shared gate decisions playbackID=%s err=%v", key, err)
+					}
 					mapic.InvalidateAllSessions(key)
 				}
 			}
@@ -148,6 +185,26 @@ func (ac *AccessControlHandlersCollection) periodicRefreshIntervalCache(mapic mi
 	}()
 }
 
+// newGateClient returns the client of the gate API, discovering its endpoints through DNS when -gate-url is a dnssrv+
+// or dns+ URL
+func newGateClient(cli config.Cli) *GateClient {
+	g := &GateClient{gateURL: cli.GateURL, Client: &http.Client{}}
+	if !clients.IsServiceDiscoveryURL(cli.GateURL) {
+		return g
+	}
+	discovery, err := clients.NewServiceDiscovery("gate", cli.GateURL)
+	if err != nil {
+		glog.Errorf("Invalid gate URL, gating every playback will fail err=%v", err)
+		return g
+	}
+	if err := discovery.Refresh(context.Background()); err != nil {
+		glog.Errorf("Starting without gate APIs, they'll be discovered again shortly err=%v", err)
+	}
+	go discovery.Run(context.Background(), cli.DiscoveryHealthInterval)
+	g.discovery = discovery
+	return g
+}
+
 // This is a singleton to avoid instantiating multiple handlers and having auth state
 // split across them
 var accessControlHandlersCollection *AccessControlHandlersCollection
@@ -158,18 +215,38 @@ func NewAccessControlHandlersCollection(cli config.Cli, mapic mistapiconnector.I
 	defer accessControlHandlersCollectionMutex.Unlock()
 
 	if accessControlHandlersCollection == nil {
-		accessControlCache := make(map[string]map[string]*PlaybackAccessControlEntry)
+		accessControlCache := cache.NewWithOptions[*PlaybackAccessControlEntry](cache.Options{
+			Name:    "gate_decisions",
+			MaxSize: gateDecisionsCacheSize,
+			Backend: cache.Shared,
+			Group:   gateDecisionsGroup,
+		})
+		blockedJWTs := make(map[string]bool)
+		for _, jwt := range cli.BlockedJWTs {
+			blockedJWTs[jwt] = true
+		}
 		accessControlHandlersCollection = &AccessControlHandlersCollection{
-			cache: accessControlCache,
-			gateClient: &GateClient{
-				gateURL: cli.GateURL,
-				Client:  &http.Client{},
-			},
+			cache:      accessControlCache,
+			gateClient: newGateClient(cli),
 			dataClient: &DataClient{
 				Endpoint:    cli.DataURL,
 				AccessToken: cli.APIToken,
 			},
-			blockedJWTs: cli.BlockedJWTs,
+			mapic:          mapic,
+			blockedJWTs:    blockedJWTs,
+			trustedProxies: cli.TrustedProxies,
+		}
+		if cli.GeoIPURL != "" {
+			accessControlHandlersCollection.geoLookup = NewGeoIPClient(cli.GeoIPURL)
+		}
+		if cli.BlockedJWTsList != "" {
+			accessControlHandlersCollection.blockedJWTList = newBlockedJWTList(cli.BlockedJWTsList)
+			if _, err := accessControlHandlersCollection.ReloadBlockedJWTs(context.Background()); err != nil {
+				glog.Errorf("Error loading the blocked JWTs list, starting without it: %s", err)
+			}
+			if cli.BlockedJWTsRefresh > 0 {
+				accessControlHandlersCollection.periodicBlockedJWTsReload(cli.BlockedJWTsRefresh)
+			}
 		}
 		accessControlHandlersCollection.periodicRefreshIntervalCache(mapic)
 	}
@@ -178,10 +255,18 @@ func NewAccessControlHandlersCollection(cli config.Cli, mapic mistapiconnector.I
 }
 
 func (ac *AccessControlHandlersCollection) HandleUserNew(ctx context.Context, payload *misttriggers.UserNewPayload) (bool, error) {
-	playbackID := payload.StreamName[strings.Index(payload.StreamName, "+")+1:]
+	playbackID, ok := config.PlaybackIDFromStreamName(payload.StreamName)
+	if !ok {
+		log.LogCtx(ctx, "Denying playback of the stream of another tenant", "stream", payload.StreamName)
+		return false, nil
+	}
 	ctx = log.WithLogValues(ctx, "playback_id", playbackID)
 
 	playbackAccessControlAllowed, err := ac.IsAuthorized(ctx, playbackID, payload)
+	if errors.Is(err, catErrs.GeoBlocked) {
+		log.LogCtx(ctx, "Playback geo-blocked")
+		return false, nil
+	}
 	if err != nil {
 		log.LogCtx(ctx, "Unable to get playback access control info", "error", err.Error())
 		return false, err
@@ -272,11 +357,9 @@ func (ac *AccessControlHandlersCollection) isAuthorized(ctx context.Context, pla
 		}
 		cacheKey = "accessKey_" + hashCacheKey
 	} else if jwt != "" {
-		for _, blocked := range ac.blockedJWTs {
-			if jwt == blocked {
-				log.LogCtx(ctx, "blocking JWT", "jwt", jwt)
-				return false, nil
-			}
+		if ac.isBlockedJWT(jwt) {
+			log.LogCtx(ctx, "blocking JWT", "jwt", jwt)
+			return false, nil
 		}
 
 		pub, err := extractKeyFromJwt(ctx, jwt, acReq.Stream)
@@ -304,10 +387,90 @@ func (ac *AccessControlHandlersCollection) isAuthorized(ctx context.Context, pla
 		return gateAllowed, err
 	}
 
+	if gateAllowed && !ac.checkGeoRestriction(ctx, playbackID, clientIP(payload.OriginIP, payload.Hostname, ac.trustedProxies)) {
+		return false, catErrs.GeoBlocked
+	}
+
 	viewerLimitPassed := ac.checkViewerLimit(playbackID)
 	return gateAllowed && viewerLimitPassed, nil
 }
 
+// checkGeoRestriction enforces the geo-restriction policy of the playback ID (as configured with Gate API). Viewers
+// are allowed when their country can't be found, so that a geo lookup outage doesn't take down playback.
+func (ac *AccessControlHandlersCollection) checkGeoRestriction(ctx context.Context, playbackID, ip string) bool {
+	geoRestrictionCache.mux.RLock()
+	restriction, ok := geoRestrictionCache.data[playbackID]
+	geoRestrictionCache.mux.RUnlock()
+	if !ok {
+		return true
+	}
+	if ac.geoLookup == nil {
+		log.LogCtx(ctx, "Unable to enforce geo-restriction without a geo lookup configured")
+		return true
+	}
+
+	country, err := ac.geoLookup.CountryCode(ip)
+	if err != nil {
+		log.LogCtx(ctx, "Unable to look up viewer country, allowing playback", "ip", ip, "err", err)
+		return true
+	}
+	if restriction.Allows(country) {
+		return true
+	}
+	log.LogCtx(ctx, "Viewer country is geo-restricted", "country", country)
+	metrics.Metrics.AccessControlGeoBlockedCount.WithLabelValues(playbackID).Inc()
+	return false
+}
+
+func (ac *AccessControlHandlersCollection) isBlockedJWT(jwt string) bool {
+	ac.mutex.RLock()
+	blocked := ac.blockedJWTs[jwt]
+	ac.mutex.RUnlock()
+	if blocked {
+		metrics.Metrics.AccessControlBlockedJWTMatchCount.WithLabelValues("static").Inc()
+		return true
+	}
+	if ac.blockedJWTList != nil && ac.blockedJWTList.contains(jwt) {
+		metrics.Metrics.AccessControlBlockedJWTMatchCount.WithLabelValues("list").Inc()
+		return true
+	}
+	return false
+}
+
+// BlockJWT rejects the given JWT on this node from now on, including the sessions already playing with it. Mist only
+// calls USER_NEW for new sessions, so the sessions of the JWT's playback ID are invalidated for Mist to evaluate them
+// again. Blocks are sent to every node as a blockJWT event, see handlers.EventsHandlersCollection.BlockJWT.
+func (ac *AccessControlHandlersCollection) BlockJWT(ctx context.Context, tokenString string) error {
+	// The JWT is blocked whether or not it is still valid, so only the playback ID is needed from it
+	playbackID, err := JWTPlaybackID(tokenString)
+	if err != nil {
+		return err
+	}
+
+	ac.mutex.Lock()
+	ac.blockedJWTs[tokenString] = true
+	ac.mutex.Unlock()
+
+	log.LogCtx(ctx, "Blocked JWT, invalidating sessions", "playback_id", playbackID)
+	ac.rejectSessions(playbackID)
+	return nil
+}
+
+// rejectSessions has the sessions of a playback ID evaluated again, after one of its JWTs was blocked
+func (ac *AccessControlHandlersCollection) rejectSessions(playbackID string) {
+	// Cached decisions are per session, drop them so that none is still allowing the blocked JWT
+	if err := ac.cache.RemovePrefix(gateDecisionsPrefix(playbackID)); err != nil {
+		glog.Errorf("Error invalidating the shared gate decisions playbackID=%s err=%v", playbackID, err)
+	}
+	ac.invalidateSessions(playbackID)
+}
+
+func (ac *AccessControlHandlersCollection) invalidateSessions(playbackID string) {
+	if ac.mapic != nil {
+		ac.mapic.InvalidateAllSessions(playbackID)
+	}
+}
+
 // checkViewerLimit is used to limit viewers per user globally (as configured with Gate API)
 func (ac *AccessControlHandlersCollection) checkViewerLimit(playbackID string) bool {
 	viewerLimitCache.mux.RLock()
@@ -381,10 +544,7 @@ func (ac *AccessControlHandlersCollection) refreshConcurrentViewerCache(playback
 }
 
 func (ac *AccessControlHandlersCollection) GetPlaybackAccessControlInfo(ctx context.Context, playbackID, cacheKey string, requestBody []byte) (bool, error) {
-	ac.mutex.RLock()
-	entry := ac.cache[playbackID][cacheKey]
-	ac.mutex.RUnlock()
-
+	entry := ac.cache.Get(gateDecisionsKey(playbackID, cacheKey))
 	if isExpired(entry) {
 		log.V(7).LogCtx(ctx, "Cache expired",
 			"cache_key", cacheKey)
@@ -396,10 +556,7 @@ func (ac *AccessControlHandlersCollection) GetPlaybackAccessControlInfo(ctx cont
 		log.V(7).LogCtx(ctx, "Cache stale",
 			"cache_key", cacheKey)
 		go func() {
-			ac.mutex.RLock()
-			stillStale := isStale(ac.cache[playbackID][cacheKey])
-			ac.mutex.RUnlock()
-			if stillStale {
+			if isStale(ac.cache.Get(gateDecisionsKey(playbackID, cacheKey))) {
 				err := ac.cachePlaybackAccessControlInfo(playbackID, cacheKey, requestBody)
 				if err != nil {
 					log.LogCtx(ctx, "Error caching playback access control info", "err", err)
@@ -408,13 +565,24 @@ func (ac *AccessControlHandlersCollection) GetPlaybackAccessControlInfo(ctx cont
 		}()
 	}
 
-	ac.mutex.RLock()
-	entry = ac.cache[playbackID][cacheKey]
-	ac.mutex.RUnlock()
-
+	entry = ac.cache.Get(gateDecisionsKey(playbackID, cacheKey))
 	return entry.Allow, nil
 }
 
+func gateDecisionsPrefix(playbackID string) string {
+	return playbackID + "/"
+}
+
+// gateDecisionsGroup returns the prefix of the decisions of the key's playback ID, which are invalidated together
+func gateDecisionsGroup(key string) string {
+	playbackID, _, _ := strings.Cut(key, "/")
+	return gateDecisionsPrefix(playbackID)
+}
+
+func gateDecisionsKey(playbackID, cacheKey string) string {
+	return gateDecisionsPrefix(playbackID) + cacheKey
+}
+
 func (ac *AccessControlHandlersCollection) ProduceHashCacheKey(cachePayload PlaybackAccessControlRequest) (string, error) {
 	jsonData, err := json.Marshal(cachePayload)
 	if err != nil {
@@ -470,14 +638,29 @@ func (ac *AccessControlHandlersCollection) cachePlaybackAccessControlInfo(playba
 	}
 	viewerLimitCache.mux.Unlock()
 
+	// cache geo-restriction policy
+	geoRestrictionCache.mux.Lock()
+	if len(gateConfig.AllowedCountries) > 0 || len(gateConfig.BlockedCountries) > 0 {
+		geoRestrictionCache.data[playbackID] = &GeoRestriction{
+			AllowedCountries: gateConfig.AllowedCountries,
+			BlockedCountries: gateConfig.BlockedCountries,
+		}
+	} else {
+		delete(geoRestrictionCache.data, playbackID)
+	}
+	geoRestrictionCache.mux.Unlock()
+
 	var maxAgeTime = time.Now().Add(time.Duration(maxAge) * time.Second)
 	var staleTime = time.Now().Add(time.Duration(stale) * time.Second)
-	ac.mutex.Lock()
-	defer ac.mutex.Unlock()
-	if ac.cache[playbackID] == nil {
-		ac.cache[playbackID] = make(map[string]*PlaybackAccessControlEntry)
+	key := gateDecisionsKey(playbackID, cacheKey)
+	previous := ac.cache.Get(key)
+	ac.cache.Store(key, &PlaybackAccessControlEntry{staleTime, maxAgeTime, allow})
+
+	// Access was revoked while the session was playing, have Mist evaluate the existing sessions again
+	if previous != nil && previous.Allow && !allow {
+		glog.Infof("Playback access revoked, invalidating sessions playbackID=%s", playbackID)
+		ac.invalidateSessions(playbackID)
 	}
-	ac.cache[playbackID][cacheKey] = &PlaybackAccessControlEntry{staleTime, maxAgeTime, allow}
 	return nil
 }
 
@@ -491,7 +674,11 @@ func (g *GateClient) QueryGate(body []byte) (bool, GateConfig, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "POST", g.gateURL, bytes.NewReader(body))
+	gateURL, err := g.url()
+	if err != nil {
+		return false, gateConfig, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", gateURL, bytes.NewReader(body))
 	if err != nil {
 		return false, gateConfig, err
 	}
@@ -499,6 +686,7 @@ func (g *GateClient) QueryGate(body []byte) (bool, GateConfig, error) {
 	req.Header.Set("Content-Type", "application/json")
 
 	res, err := g.Client.Do(req)
+	g.recordResult(gateURL, res, err)
 	if err != nil {
 		// If the timeout is exceeded, simulate a 2XX status code with 2 minute cache expiration
 		if err == context.DeadlineExceeded {
@@ -547,6 +735,12 @@ func (g *GateClient) QueryGate(body []byte) (bool, GateConfig, error) {
 			}
 			gateConfig.UserID = userID
 		}
+		if gateConfig.AllowedCountries, err = parseCountries(result, "allowed_countries"); err != nil {
+			return false, gateConfig, err
+		}
+		if gateConfig.BlockedCountries, err = parseCountries(result, "blocked_countries"); err != nil {
+			return false, gateConfig, err
+		}
 	}
 
 	gateConfig.MaxAge = int32(cc.MaxAge)
@@ -556,6 +750,26 @@ func (g *GateClient) QueryGate(body []byte) (bool, GateConfig, error) {
 	return res.StatusCode/100 == 2, gateConfig, nil
 }
 
+func parseCountries(result map[string]interface{}, key string) ([]string, error) {
+	ri, ok := result[key]
+	if !ok || ri == nil {
+		return nil, nil
+	}
+	list, ok := ri.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not a list", key)
+	}
+	countries := make([]string, 0, len(list))
+	for _, c := range list {
+		country, ok := c.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s contains a non-string value", key)
+		}
+		countries = append(countries, country)
+	}
+	return countries, nil
+}
+
 type PlaybackGateClaims struct {
 	PublicKey string `json:"pub"`
 	jwt.RegisteredClaims
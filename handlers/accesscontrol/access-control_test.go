@@ -6,11 +6,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
 	"github.com/stretchr/testify/require"
 )
 
@@ -70,7 +80,7 @@ var denyAccess = func(body []byte) (bool, GateConfig, error) {
 
 func testTriggerHandler() func(context.Context, *misttriggers.UserNewPayload) (bool, error) {
 	c := &AccessControlHandlersCollection{
-		cache:      make(map[string]map[string]*PlaybackAccessControlEntry),
+		cache:      cache.New[*PlaybackAccessControlEntry](),
 		gateClient: &stubGateClient{},
 		dataClient: &stubDataClient{},
 	}
@@ -97,6 +107,17 @@ func TestAllowedAccessValidTokenWithPrefix(t *testing.T) {
 	require.Equal(t, "true", result)
 }
 
+func TestDeniedAccessToStreamOfAnotherTenant(t *testing.T) {
+	config.StreamTenant = "tenant1"
+	defer func() { config.StreamTenant = "" }()
+	token, _ := craftToken(privateKey, publicKey, playbackID, expiration)
+	streamName := "video+tenant2-" + playbackID
+	payload := []byte(fmt.Sprint(streamName, "\n1\n2\n3\nhttp://localhost:8080/hls/", streamName, "/index.m3u8?stream=", streamName, "&jwt=", token, "\n5"))
+
+	result := executeFlow(payload, testTriggerHandler(), allowAccess)
+	require.Equal(t, "false", result)
+}
+
 func TestAllowedAccessAbsentToken(t *testing.T) {
 	token := ""
 	payload := []byte(fmt.Sprint(playbackID, "\n1\n2\n3\nhttp://localhost:8080/hls/", playbackID, "/index.m3u8?stream=", playbackID, "&jwt=", token, "\n5"))
@@ -273,6 +294,136 @@ func TestViewerLimit(t *testing.T) {
 	require.Equal(t, "false", result2)
 }
 
+type stubGeoLookup struct {
+	countries map[string]string
+}
+
+func (g *stubGeoLookup) CountryCode(ip string) (string, error) {
+	country, ok := g.countries[ip]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return country, nil
+}
+
+func TestGeoRestriction(t *testing.T) {
+	c := &AccessControlHandlersCollection{
+		cache:      cache.New[*PlaybackAccessControlEntry](),
+		gateClient: &stubGateClient{},
+		dataClient: &stubDataClient{},
+		geoLookup:  &stubGeoLookup{countries: map[string]string{"1.1.1.1": "US", "2.2.2.2": "FR", "3.3.3.3": "DE"}},
+	}
+	access := func(body []byte) (bool, GateConfig, error) {
+		return true, GateConfig{AllowedCountries: []string{"us", "FR"}, BlockedCountries: []string{"FR"}}, nil
+	}
+	authorize := func(ip string) (bool, error) {
+		payload, err := misttriggers.ParseUserNewPayload(misttriggers.MistTriggerBody(fmt.Sprint(playbackID, "\n", ip, "\n2\n3\nhttp://localhost:8080/hls/", playbackID, "/index.m3u8\n5")))
+		require.NoError(t, err)
+		original := queryGate
+		queryGate = access
+		defer func() { queryGate = original }()
+		return c.IsAuthorized(context.Background(), playbackID, &payload)
+	}
+
+	allowed, err := authorize("1.1.1.1")
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// blocked even though it's in the allow list
+	allowed, err = authorize("2.2.2.2")
+	require.ErrorIs(t, err, catErrs.GeoBlocked)
+	require.False(t, allowed)
+
+	// not in the allow list
+	_, err = authorize("3.3.3.3")
+	require.ErrorIs(t, err, catErrs.GeoBlocked)
+
+	// unknown country is allowed
+	allowed, err = authorize("4.4.4.4")
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// Mist just gets the session denied
+	payload := []byte(fmt.Sprint(playbackID, "\n3.3.3.3\n2\n3\nhttp://localhost:8080/hls/", playbackID, "/index.m3u8\n5"))
+	require.Equal(t, "false", executeFlow(payload, c.HandleUserNew, access))
+
+	// the policy is dropped once the gate API stops returning it
+	c.cache = cache.New[*PlaybackAccessControlEntry]()
+	require.Equal(t, "true", executeFlow(payload, c.HandleUserNew, allowAccess))
+}
+
+func TestClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	trusted := []*net.IPNet{proxies}
+
+	require.Equal(t, "1.1.1.1", clientIP("", "1.1.1.1", trusted))
+	require.Equal(t, "2.2.2.2", clientIP("2.2.2.2", "10.0.0.1", trusted))
+	// the entries left of the one set by the trusted proxies are the viewer's, who can forge them
+	require.Equal(t, "2.2.2.2", clientIP("3.3.3.3, 2.2.2.2", "10.0.0.1", trusted))
+	require.Equal(t, "2.2.2.2", clientIP("3.3.3.3, 2.2.2.2, 10.0.0.2", "10.0.0.1", trusted))
+	require.Equal(t, "2.2.2.2", clientIP("3.3.3.3,2.2.2.2", "10.0.0.1", nil))
+	// only proxies
+	require.Equal(t, "10.0.0.1", clientIP("10.0.0.2", "10.0.0.1", trusted))
+}
+
+type stubMapic struct {
+	mistapiconnector.IMac
+	invalidated []string
+}
+
+func (m *stubMapic) InvalidateAllSessions(playbackID string) {
+	m.invalidated = append(m.invalidated, playbackID)
+}
+
+func TestBlockJWTRejectsExistingSessions(t *testing.T) {
+	token, _ := craftToken(privateKey, publicKey, playbackID, expiration)
+	payload := []byte(fmt.Sprint(playbackID, "\n1\n2\n3\nhttp://localhost:8080/hls/", playbackID, "/index.m3u8?stream=", playbackID, "&jwt=", token, "\n5"))
+	mapic := &stubMapic{}
+	c := &AccessControlHandlersCollection{
+		cache:       cache.New[*PlaybackAccessControlEntry](),
+		gateClient:  &stubGateClient{},
+		dataClient:  &stubDataClient{},
+		mapic:       mapic,
+		blockedJWTs: make(map[string]bool),
+	}
+
+	require.Equal(t, "true", executeFlow(payload, c.HandleUserNew, allowAccess))
+
+	require.NoError(t, c.BlockJWT(context.Background(), token))
+	require.Equal(t, []string{playbackID}, mapic.invalidated)
+	// Mist calls USER_NEW again for the invalidated session, which now gets rejected
+	require.Equal(t, "false", executeFlow(payload, c.HandleUserNew, allowAccess))
+
+	require.Error(t, c.BlockJWT(context.Background(), "x"))
+}
+
+func TestRevokedAccessInvalidatesSessions(t *testing.T) {
+	token, _ := craftToken(privateKey, publicKey, playbackID, expiration)
+	payload := []byte(fmt.Sprint(playbackID, "\n1\n2\n3\nhttp://localhost:8080/hls/", playbackID, "/index.m3u8?stream=", playbackID, "&jwt=", token, "\n5"))
+	mapic := &stubMapic{}
+	c := &AccessControlHandlersCollection{
+		cache:      cache.New[*PlaybackAccessControlEntry](),
+		gateClient: &stubGateClient{},
+		dataClient: &stubDataClient{},
+		mapic:      mapic,
+	}
+	var expiredAllowAccess = func(body []byte) (bool, GateConfig, error) {
+		return true, GateConfig{MaxAge: -10, StaleWhileRevalidate: -20}, nil
+	}
+
+	require.Equal(t, "true", executeFlow(payload, c.HandleUserNew, expiredAllowAccess))
+	require.Empty(t, mapic.invalidated)
+
+	require.Equal(t, "false", executeFlow(payload, c.HandleUserNew, denyAccess))
+	require.Equal(t, []string{playbackID}, mapic.invalidated)
+
+	// Staying denied doesn't invalidate the sessions again
+	c.cache.RemovePrefix(gateDecisionsPrefix(playbackID))
+	require.Equal(t, "false", executeFlow(payload, c.HandleUserNew, denyAccess))
+	require.Equal(t, []string{playbackID}, mapic.invalidated)
+}
+
 func executeFlow(body []byte, handler func(context.Context, *misttriggers.UserNewPayload) (bool, error), request func(body []byte) (bool, GateConfig, error)) string {
 	original := queryGate
 	queryGate = request
@@ -312,3 +463,79 @@ func craftToken(sk, publicKey, playbackID string, expiration time.Time) (string,
 
 	return ss, nil
 }
+
+func TestBlockedJWTsList(t *testing.T) {
+	token, _ := craftToken(privateKey, publicKey, playbackID, expiration)
+	payload := []byte(fmt.Sprint(playbackID, "\n1\n2\n3\nhttp://localhost:8080/hls/", playbackID, "/index.m3u8?stream=", playbackID, "&jwt=", token, "\n5"))
+	list := filepath.Join(t.TempDir(), "blocked-jwts.txt")
+	require.NoError(t, os.WriteFile(list, []byte("# compromised tokens\n\nsome-other-token\n"), 0644))
+	mapic := &stubMapic{}
+	c := &AccessControlHandlersCollection{
+		cache:          cache.New[*PlaybackAccessControlEntry](),
+		gateClient:     &stubGateClient{},
+		dataClient:     &stubDataClient{},
+		mapic:          mapic,
+		blockedJWTs:    make(map[string]bool),
+		blockedJWTList: newBlockedJWTList(list),
+	}
+	reload, err := c.ReloadBlockedJWTs(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, BlockedJWTsReload{Changed: true, BlockedJWTs: 1, Added: 1}, reload)
+	require.Equal(t, "true", executeFlow(payload, c.HandleUserNew, allowAccess))
+
+	// The JWT is added to the list, rejecting its existing sessions. Changes are seen even when the modification time
+	// stays the same.
+	info, err := os.Stat(list)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(list, []byte(token+"\n"), 0644))
+	require.NoError(t, os.Chtimes(list, info.ModTime(), info.ModTime()))
+	router := httprouter.New()
+	router.POST("/reload", c.ReloadBlockedJWTsHandler())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/reload", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"changed": true, "blocked_jwts": 1, "added": 1, "removed": 1}`, rr.Body.String())
+	require.Equal(t, []string{playbackID}, mapic.invalidated)
+	require.Equal(t, "false", executeFlow(payload, c.HandleUserNew, allowAccess))
+
+	// Unchanged
+	reload, err = c.ReloadBlockedJWTs(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, BlockedJWTsReload{BlockedJWTs: 1}, reload)
+
+	// A list over the limit isn't truncated, the previous one is kept instead
+	defer func(limit int64) { maxBlockedJWTListBytes = limit }(maxBlockedJWTListBytes)
+	maxBlockedJWTListBytes = int64(len(token) + 1)
+	require.NoError(t, os.WriteFile(list, []byte(token+"\nsome-other-token\n"), 0644))
+	_, err = c.ReloadBlockedJWTs(context.Background())
+	require.ErrorContains(t, err, "larger than")
+	require.True(t, c.blockedJWTList.contains(token))
+	require.False(t, c.blockedJWTList.contains("some-other-token"))
+}
+
+func TestBlockedJWTsListFromURL(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("token-a\ntoken-b\n"))
+	}))
+	defer server.Close()
+
+	list := newBlockedJWTList(server.URL)
+	_, reload, err := list.load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, BlockedJWTsReload{Changed: true, BlockedJWTs: 2, Added: 2}, reload)
+	require.True(t, list.contains("token-b"))
+	require.False(t, list.contains("token-c"))
+
+	_, reload, err = list.load(context.Background())
+	require.NoError(t, err)
+	require.False(t, reload.Changed)
+	require.True(t, list.contains("token-a"))
+	require.Equal(t, 2, requests)
+}
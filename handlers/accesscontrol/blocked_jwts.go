@@ -0,0 +1,239 @@
+package accesscontrol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/glog"
+	"github.com/julienschmidt/httprouter"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// Largest blocked JWTs list read, the reloads of a larger list fail and keep the previous one
+var maxBlockedJWTListBytes int64 = 64 * 1024 * 1024
+
+// blockedJWTList is the list of blocked JWTs of -gate-blocked-jwts-list, a file or an http(s) URL with a JWT per line.
+// Blank lines and lines starting with # are ignored. It's reloaded periodically, when its content changed.
+type blockedJWTList struct {
+	source string
+	client *http.Client
+
+	mu   sync.RWMutex
+	jwts map[string]bool
+	etag string
+	// SHA-256 of the content the list was last loaded from
+	hash [sha256.Size]byte
+}
+
+// BlockedJWTsReload is the result of reloading the blocked JWTs list
+type BlockedJWTsReload struct {
+	// Whether the list changed since it was last loaded
+	Changed     bool `json:"changed"`
+	BlockedJWTs int  `json:"blocked_jwts"`
+	Added       int  `json:"added"`
+	Removed     int  `json:"removed"`
+}
+
+func newBlockedJWTList(source string) *blockedJWTList {
+	return &blockedJWTList{
+		source: source,
+		client: &http.Client{Timeout: 30 * time.Second},
+		jwts:   map[string]bool{},
+	}
+}
+
+func (l *blockedJWTList) contains(jwt string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.jwts[jwt]
+}
+
+// load reads the list again unless it's unchanged, and returns the JWTs that weren't blocked before
+func (l *blockedJWTList) load(ctx context.Context) ([]string, BlockedJWTsReload, error) {
+	var content []byte
+	var etag string
+	var err error
+	if strings.HasPrefix(l.source, "http://") || strings.HasPrefix(l.source, "https://") {
+		content, etag, err = l.fetch(ctx)
+	} else {
+		content, err = l.read()
+	}
+	var jwts map[string]bool
+	if err == nil && content != nil {
+		jwts, err = parseBlockedJWTs(content)
+	}
+	if err != nil {
+		metrics.Metrics.AccessControlBlockedJWTReloadCount.WithLabelValues("error").Inc()
+		return nil, BlockedJWTsReload{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hash := sha256.Sum256(content)
+	if content == nil || hash == l.hash {
+		metrics.Metrics.AccessControlBlockedJWTReloadCount.WithLabelValues("unchanged").Inc()
+		return nil, BlockedJWTsReload{BlockedJWTs: len(l.jwts)}, nil
+	}
+	var added []string
+	for jwt := range jwts {
+		if !l.jwts[jwt] {
+			added = append(added, jwt)
+		}
+	}
+	reload := BlockedJWTsReload{
+		Changed:     true,
+		BlockedJWTs: len(jwts),
+		Added:       len(added),
+		Removed:     len(l.jwts) + len(added) - len(jwts),
+	}
+	l.jwts, l.etag, l.hash = jwts, etag, hash
+	metrics.Metrics.AccessControlBlockedJWTReloadCount.WithLabelValues("changed").Inc()
+	metrics.Metrics.AccessControlBlockedJWTListSize.Set(float64(len(jwts)))
+	return added, reload, nil
+}
+
+// read returns the content of the list file
+func (l *blockedJWTList) read() ([]byte, error) {
+	f, err := os.Open(l.source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocked JWTs list: %w", err)
+	}
+	defer f.Close()
+	return readBlockedJWTs(f)
+}
+
+// fetch returns the content of the list URL, nil if the server says it wasn't modified since it was last fetched
+func (l *blockedJWTList) fetch(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.source, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create blocked JWTs list request: %w", err)
+	}
+	l.mu.RLock()
+	if l.etag != "" {
+		req.Header.Set("If-None-Match", l.etag)
+	}
+	l.mu.RUnlock()
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch blocked JWTs list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch blocked JWTs list: status %d", resp.StatusCode)
+	}
+	content, err := readBlockedJWTs(resp.Body)
+	return content, resp.Header.Get("ETag"), err
+}
+
+// readBlockedJWTs reads the whole list, failing rather than truncating it when it's larger than the limit
+func readBlockedJWTs(r io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, maxBlockedJWTListBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocked JWTs list: %w", err)
+	}
+	if int64(len(content)) > maxBlockedJWTListBytes {
+		return nil, fmt.Errorf("blocked JWTs list is larger than %d bytes", maxBlockedJWTListBytes)
+	}
+	return content, nil
+}
+
+func parseBlockedJWTs(content []byte) (map[string]bool, error) {
+	jwts := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		jwts[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse blocked JWTs list: %w", err)
+	}
+	return jwts, nil
+}
+
+// ReloadBlockedJWTs loads the blocked JWTs list again, rejecting the existing sessions of the JWTs newly blocked
+func (ac *AccessControlHandlersCollection) ReloadBlockedJWTs(ctx context.Context) (BlockedJWTsReload, error) {
+	if ac.blockedJWTList == nil {
+		return BlockedJWTsReload{}, errors.New("no blocked JWTs list configured")
+	}
+	added, reload, err := ac.blockedJWTList.load(ctx)
+	if err != nil {
+		return reload, err
+	}
+	if reload.Changed {
+		log.LogCtx(ctx, "Reloaded the blocked JWTs list", "blocked_jwts", reload.BlockedJWTs, "added", reload.Added, "removed", reload.Removed)
+	}
+	playbackIDs := map[string]bool{}
+	for _, token := range added {
+		playbackID, err := JWTPlaybackID(token)
+		if err != nil {
+			// still blocked, but there's no session of it to reject
+			continue
+		}
+		playbackIDs[playbackID] = true
+	}
+	for playbackID := range playbackIDs {
+		ac.rejectSessions(playbackID)
+	}
+	return reload, nil
+}
+
+func (ac *AccessControlHandlersCollection) periodicBlockedJWTsReload(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if _, err := ac.ReloadBlockedJWTs(context.Background()); err != nil {
+				glog.Errorf("Failed to reload the blocked JWTs list, keeping the previous one: %s", err)
+			}
+		}
+	}()
+}
+
+// ReloadBlockedJWTsHandler reloads the blocked JWTs list straight away, rather than waiting for its next refresh
+func (ac *AccessControlHandlersCollection) ReloadBlockedJWTsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		if ac.blockedJWTList == nil {
+			catErrs.WriteHTTPBadRequest(w, "No blocked JWTs list configured", nil)
+			return
+		}
+		reload, err := ac.ReloadBlockedJWTs(req.Context())
+		if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Failed to reload the blocked JWTs list", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(reload)
+	}
+}
+
+// JWTPlaybackID returns the playback ID a JWT grants access to, whether or not the JWT is still valid
+func JWTPlaybackID(tokenString string) (string, error) {
+	claims := &PlaybackGateClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return "", fmt.Errorf("unable to parse jwt: %w", err)
+	}
+	if claims.Subject == "" {
+		return "", errors.New("missing sub claim")
+	}
+	return claims.Subject, nil
+}
@@ -2,23 +2,30 @@ package accesscontrol
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/crypto"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
 )
 
 type EncryptionHandlersCollection struct {
 	publicKey     string
 	spkiPublicKey string
 	nodeName      string
+	// Private keys of the node, whose current key is served in place of the configured public key once one is loaded
+	keys *crypto.KeyRing
 }
 
-func NewEncryptionHandlersCollection(cli config.Cli, spkiPublicKey string) *EncryptionHandlersCollection {
+func NewEncryptionHandlersCollection(cli config.Cli, spkiPublicKey string, keys *crypto.KeyRing) *EncryptionHandlersCollection {
 	return &EncryptionHandlersCollection{
 		publicKey:     cli.VodDecryptPublicKey,
 		spkiPublicKey: spkiPublicKey,
 		nodeName:      cli.NodeName,
+		keys:          keys,
 	}
 }
 
@@ -32,6 +39,11 @@ func (ec *EncryptionHandlersCollection) PublicKeyHandler() httprouter.Handle {
 			"spki_public_key": ec.spkiPublicKey,
 			"node_name":       ec.nodeName,
 		}
+		if keyID, key := ec.keys.Current(); key != nil {
+			responseData["public_key"] = crypto.EncodePublicKey(&key.PublicKey)
+			responseData["spki_public_key"], _ = crypto.ConvertToSpki(responseData["public_key"])
+			responseData["key_id"] = keyID
+		}
 
 		res, err := json.Marshal(responseData)
 		if err != nil {
@@ -45,3 +57,65 @@ func (ec *EncryptionHandlersCollection) PublicKeyHandler() httprouter.Handle {
 		}
 	}
 }
+
+type AddDecryptionKeyRequest struct {
+	// Base64 encoded PKCS#1 PEM block, like -catalyst-private-key
+	PrivateKey string `json:"private_key"`
+	// Makes the key the one served by /api/pubkey, for new uploads to be encrypted for
+	Current bool `json:"current"`
+}
+
+type AddDecryptionKeyResponse struct {
+	KeyID        string   `json:"key_id"`
+	PublicKey    string   `json:"public_key"`
+	CurrentKeyID string   `json:"current_key_id"`
+	KeyIDs       []string `json:"key_ids"`
+}
+
+// AddDecryptionKeyHandler loads a private key without a restart, e.g. to rotate the node's key: the new key is added
+// first, then made current once it's loaded on all the nodes, keeping the previous one for the uploads still encrypted
+// for it. Loaded keys aren't persisted, they should be added to the flags too to survive a restart.
+func (ec *EncryptionHandlersCollection) AddDecryptionKeyHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		if ec.keys == nil {
+			catErrs.WriteHTTPNotFound(w, "VOD decryption is not enabled on this node", nil)
+			return
+		}
+		var body AddDecryptionKeyRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if body.PrivateKey == "" {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", errors.New("missing private_key"))
+			return
+		}
+		key, err := crypto.LoadPrivateKey(body.PrivateKey)
+		if err != nil {
+			catErrs.WriteHTTPBadRequest(w, "Invalid private key", err)
+			return
+		}
+		keyID, err := ec.keys.Add(key, body.Current)
+		if err != nil {
+			catErrs.WriteHTTPBadRequest(w, "Invalid private key", err)
+			return
+		}
+		currentKeyID, _ := ec.keys.Current()
+		log.LogNoRequestID("loaded VOD decryption key", "key_id", keyID, "current_key_id", currentKeyID)
+
+		res, err := json.Marshal(AddDecryptionKeyResponse{
+			KeyID:        keyID,
+			PublicKey:    crypto.EncodePublicKey(&key.PublicKey),
+			CurrentKeyID: currentKeyID,
+			KeyIDs:       ec.keys.IDs(),
+		})
+		if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Failed marshaling response", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(res); err != nil {
+			log.LogNoRequestID("failed to write add decryption key response", "err", err)
+		}
+	}
+}
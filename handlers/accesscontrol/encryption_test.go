@@ -0,0 +1,67 @@
+package accesscontrol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddDecryptionKeyRotatesPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	encodedKey := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	keys := crypto.NewKeyRing()
+	ec := NewEncryptionHandlersCollection(config.Cli{VodDecryptPublicKey: "configured-key", NodeName: "node"}, "configured-spki", keys)
+
+	getPublicKey := func() map[string]string {
+		rr := httptest.NewRecorder()
+		ec.PublicKeyHandler()(rr, httptest.NewRequest(http.MethodGet, "/api/pubkey", nil), nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var res map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		return res
+	}
+	addKey := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ec.AddDecryptionKeyHandler()(rr, httptest.NewRequest(http.MethodPost, "/api/encryption/keys", bytes.NewBufferString(body)), nil)
+		return rr
+	}
+
+	// The configured public key is served until a key is made current
+	require.Equal(t, map[string]string{"public_key": "configured-key", "spki_public_key": "configured-spki", "node_name": "node"}, getPublicKey())
+
+	require.Equal(t, http.StatusBadRequest, addKey(`{}`).Code)
+	require.Equal(t, http.StatusBadRequest, addKey(`{"private_key": "bm90IGEga2V5"}`).Code)
+
+	rr := addKey(`{"private_key": "` + encodedKey + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var added AddDecryptionKeyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &added))
+	keyID, err := crypto.KeyID(&key.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, AddDecryptionKeyResponse{KeyID: keyID, PublicKey: crypto.EncodePublicKey(&key.PublicKey), KeyIDs: []string{keyID}}, added)
+	require.Equal(t, "configured-key", getPublicKey()["public_key"])
+
+	// Making it current serves its public key, with its ID for the payloads encrypted for it
+	rr = addKey(`{"private_key": "` + encodedKey + `", "current": true}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	res := getPublicKey()
+	require.Equal(t, keyID, res["key_id"])
+	require.Equal(t, crypto.EncodePublicKey(&key.PublicKey), res["public_key"])
+	require.NotEmpty(t, res["spki_public_key"])
+	loaded, err := keys.Get(keyID)
+	require.NoError(t, err)
+	require.Equal(t, key, loaded)
+}
@@ -0,0 +1,147 @@
+package accesscontrol
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	geoLookupCacheTTL        = time.Hour
+	geoLookupCacheMaxEntries = 100_000
+)
+
+// GeoRestriction is the geo-restriction policy of a playback ID, as returned by the Gate API.
+// Countries are ISO 3166-1 alpha-2 codes.
+type GeoRestriction struct {
+	AllowedCountries []string
+	BlockedCountries []string
+}
+
+// Allows returns whether viewers from the given country can play back. A blocked country is always denied and, when
+// there's an allow list, any country not in it is denied too.
+func (g *GeoRestriction) Allows(country string) bool {
+	for _, c := range g.BlockedCountries {
+		if strings.EqualFold(c, country) {
+			return false
+		}
+	}
+	if len(g.AllowedCountries) == 0 {
+		return true
+	}
+	for _, c := range g.AllowedCountries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeoRestrictionCache comes from Gate API
+type GeoRestrictionCache struct {
+	data map[string]*GeoRestriction
+	mux  sync.RWMutex
+}
+
+var geoRestrictionCache = GeoRestrictionCache{data: make(map[string]*GeoRestriction)}
+
+type GeoLookup interface {
+	CountryCode(ip string) (string, error)
+}
+
+type geoLookupEntry struct {
+	country string
+	expires time.Time
+}
+
+// GeoIPClient looks up the country of client IPs with an ipapi compatible service, i.e. GET <endpoint>/<ip> returning
+// a JSON object with a country_code field
+type GeoIPClient struct {
+	Endpoint string
+	Client   *http.Client
+
+	cache map[string]geoLookupEntry
+	mux   sync.Mutex
+}
+
+type geoIPResponse struct {
+	CountryCode string `json:"country_code"`
+}
+
+func NewGeoIPClient(endpoint string) *GeoIPClient {
+	return &GeoIPClient{
+		Endpoint: endpoint,
+		Client:   &http.Client{Timeout: 2 * time.Second},
+		cache:    make(map[string]geoLookupEntry),
+	}
+}
+
+func (g *GeoIPClient) CountryCode(ip string) (string, error) {
+	g.mux.Lock()
+	entry, ok := g.cache[ip]
+	g.mux.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.country, nil
+	}
+
+	res, err := g.Client.Get(strings.TrimSuffix(g.Endpoint, "/") + "/" + url.PathEscape(ip))
+	if err != nil {
+		return "", fmt.Errorf("failed to perform geo lookup, err=%v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	var geoRes geoIPResponse
+	if err := json.NewDecoder(res.Body).Decode(&geoRes); err != nil {
+		return "", fmt.Errorf("failed to decode response body, err=%v", err)
+	}
+	if geoRes.CountryCode == "" {
+		return "", fmt.Errorf("no country found for ip=%s", ip)
+	}
+
+	g.mux.Lock()
+	if len(g.cache) >= geoLookupCacheMaxEntries {
+		g.cache = make(map[string]geoLookupEntry)
+	}
+	g.cache[ip] = geoLookupEntry{country: geoRes.CountryCode, expires: time.Now().Add(geoLookupCacheTTL)}
+	g.mux.Unlock()
+	return geoRes.CountryCode, nil
+}
+
+// clientIP returns the viewer's IP. Behind proxies, it's the right-most X-Forwarded-For address that isn't one of the
+// trusted proxies, i.e. the one the outermost trusted proxy saw the request from: the entries left of it are sent by
+// the viewer, who can forge them.
+func clientIP(originIP, hostname string, trustedProxies []*net.IPNet) string {
+	entries := strings.Split(originIP, ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		if !isTrustedProxy(entry, trustedProxies) {
+			return entry
+		}
+	}
+	return hostname
+}
+
+func isTrustedProxy(addr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
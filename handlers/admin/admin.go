@@ -1,17 +1,35 @@
 package admin
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/balancer"
+	"github.com/livepeer/catalyst-api/balancer/federation"
 	"github.com/livepeer/catalyst-api/cluster"
 	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/handlers/analytics"
+	"github.com/livepeer/catalyst-api/maintenance"
+	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
+	"github.com/livepeer/catalyst-api/mistbackup"
+	"github.com/livepeer/catalyst-api/pipeline"
 )
 
 // Admin handlers. To be replaced by signed events and GraphQL queries when we get there.
 type AdminHandlersCollection struct {
-	Cluster cluster.Cluster
+	Cluster          cluster.Cluster
+	VODEngine        *pipeline.Coordinator
+	Balancer         balancer.Balancer
+	RedirectPrefixes []string
+	MistBackups      *mistbackup.Backups
+	Mapic            mistapiconnector.IMac
+	MetricsDB        *sql.DB
+	// Drains the node for maintenance
+	Maintenance *maintenance.Scheduler
+	// Viewer heatmaps of the streams, nil without analytics
+	Heatmap *analytics.GeoHeatmap
 }
 
 func (c *AdminHandlersCollection) MembersHandler() httprouter.Handle {
@@ -21,12 +39,82 @@ func (c *AdminHandlersCollection) MembersHandler() httprouter.Handle {
 			errors.WriteHTTPInternalServerError(w, "Could not get list of cluster members", err)
 			return
 		}
-		b, err := json.Marshal(members)
-		if err != nil {
-			errors.WriteHTTPInternalServerError(w, "Could not marshal list of members", err)
-			return
+		writeJSON(w, members)
+	}
+}
+
+// JobsHandler lists the VOD jobs in flight on this node
+func (c *AdminHandlersCollection) JobsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(w, c.VODEngine.ListJobs())
+	}
+}
+
+// BalancerDecision is where a balancer sends a viewer of a playback ID
+type BalancerDecision struct {
+	Balancer string `json:"balancer"`
+	Active   bool   `json:"active"`
+	// The cluster, for the federation decision
+	Cluster string `json:"cluster,omitempty"`
+	// The nodes one of which is picked at random
+	Nodes          []string `json:"nodes,omitempty"`
+	FullPlaybackID string   `json:"full_playback_id,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// BalancerDecisionsHandler shows where a viewer of the playback ID would be sent. When catabalancer runs alongside
+// the Mist balancer, both decisions are shown, with the one used for playback marked as active. In a federation of
+// clusters, the cluster-level decision comes first. The optional `lat` and `lon` query parameters locate the viewer.
+//
+// The decisions are previewed rather than made, so that they don't count in the metrics or in the load of the nodes.
+// The Mist balancer can't preview its decisions, MistUtilLoad counts a viewer for the node it picks, so only an
+// error is shown for it.
+func (c *AdminHandlersCollection) BalancerDecisionsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		playbackID := params.ByName("playbackID")
+		lat, lon := r.URL.Query().Get("lat"), r.URL.Query().Get("lon")
+
+		decide := func(name string, bal balancer.Balancer, active bool) BalancerDecision {
+			decision := BalancerDecision{Balancer: name, Active: active}
+			previewer, ok := bal.(balancer.Previewer)
+			if !ok {
+				decision.Error = "the balancer can't preview its decisions"
+				return decision
+			}
+			nodes, fullPlaybackID, err := previewer.PreviewBestNode(r.Context(), c.RedirectPrefixes, playbackID, lat, lon)
+			decision.Nodes, decision.FullPlaybackID = nodes, fullPlaybackID
+			if err != nil {
+				decision.Error = err.Error()
+			}
+			return decision
+		}
+
+		var decisions []BalancerDecision
+		bal := c.Balancer
+		if fed, ok := bal.(*federation.FederatedBalancer); ok {
+			// The decisions of the local balancers are only used when the viewer is kept in this cluster
+			cluster, nodes, fullPlaybackID := fed.PreviewCluster(r.Context(), c.RedirectPrefixes, playbackID, lat, lon)
+			decisions = append(decisions, BalancerDecision{Balancer: "federation", Active: true, Cluster: cluster, Nodes: nodes, FullPlaybackID: fullPlaybackID})
+			bal = fed.LocalBalancer
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(b) // nolint:errcheck
+		if combined, ok := bal.(balancer.CombinedBalancer); ok {
+			decisions = append(decisions,
+				decide("catabalancer", combined.Catabalancer, combined.CatabalancerPlaybackEnabled),
+				decide("mist", combined.MistBalancer, !combined.CatabalancerPlaybackEnabled),
+			)
+		} else {
+			decisions = append(decisions, decide("mist", bal, true))
+		}
+		writeJSON(w, decisions)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		errors.WriteHTTPInternalServerError(w, "Could not marshal response", err)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b) // nolint:errcheck
 }
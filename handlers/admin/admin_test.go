@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/balancer"
+	"github.com/livepeer/catalyst-api/handlers/analytics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalancerDecisions(t *testing.T) {
+	stub := balancer.NewBalancerStub(nil)
+	for _, tc := range []struct {
+		name     string
+		bal      balancer.Balancer
+		expected []BalancerDecision
+	}{
+		{
+			name:     "single balancer",
+			bal:      stub,
+			expected: []BalancerDecision{{Balancer: "mist", Active: true, Nodes: []string{"localhost"}, FullPlaybackID: "abcd"}},
+		},
+		{
+			name: "catabalancer in the background",
+			bal:  balancer.NewCombinedBalancer(stub, stub, "background"),
+			expected: []BalancerDecision{
+				{Balancer: "catabalancer", Active: false, Nodes: []string{"localhost"}, FullPlaybackID: "abcd"},
+				{Balancer: "mist", Active: true, Nodes: []string{"localhost"}, FullPlaybackID: "abcd"},
+			},
+		},
+		{
+			name: "catabalancer enabled",
+			bal:  balancer.NewCombinedBalancer(stub, stub, "enabled"),
+			expected: []BalancerDecision{
+				{Balancer: "catabalancer", Active: true, Nodes: []string{"localhost"}, FullPlaybackID: "abcd"},
+				{Balancer: "mist", Active: false, Nodes: []string{"localhost"}, FullPlaybackID: "abcd"},
+			},
+		},
+		{
+			name: "balancer without previews",
+			// Hides the PreviewBestNode of the stub
+			bal: balancer.NewCombinedBalancer(stub, struct{ balancer.Balancer }{stub}, "enabled"),
+			expected: []BalancerDecision{
+				{Balancer: "catabalancer", Active: true, Nodes: []string{"localhost"}, FullPlaybackID: "abcd"},
+				{Balancer: "mist", Active: false, Error: "the balancer can't preview its decisions"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlers := &AdminHandlersCollection{Balancer: tc.bal, RedirectPrefixes: []string{"video"}}
+			router := httprouter.New()
+			router.GET("/api/admin/balancer/:playbackID", handlers.BalancerDecisionsHandler())
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/admin/balancer/abcd?lat=51.5&lon=-0.1", nil))
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			var decisions []BalancerDecision
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &decisions))
+			require.Equal(t, tc.expected, decisions)
+		})
+	}
+}
+
+func TestGeoHeatmapHandler(t *testing.T) {
+	router := httprouter.New()
+	router.GET("/api/admin/analytics/heatmap/:playbackID", (&AdminHandlersCollection{}).GeoHeatmapHandler())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/admin/analytics/heatmap/abcd", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	handlers := &AdminHandlersCollection{Heatmap: analytics.NewGeoHeatmap(time.Minute, "node", "", "", "", "")}
+	router = httprouter.New()
+	router.GET("/api/admin/analytics/heatmap/:playbackID", handlers.GeoHeatmapHandler())
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/admin/analytics/heatmap/abcd", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var heatmap analytics.Heatmap
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &heatmap))
+	require.Equal(t, analytics.Heatmap{PlaybackID: "abcd", Buckets: []analytics.GeoBucket{}}, heatmap)
+}
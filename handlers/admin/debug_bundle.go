@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/errors"
+)
+
+// DebugBundleHandler gathers what support needs to debug a job that ran on this node recently: its timeline, a
+// redacted snapshot of the config it ran with, the probe results of its source, the callbacks sent for it and the
+// latest log lines tagged with its request ID. The bundle is JSON, or a zip with a file per part with `?format=zip`.
+func (c *AdminHandlersCollection) DebugBundleHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		requestID := params.ByName("requestID")
+		bundle, ok := c.VODEngine.DebugBundle(requestID)
+		if !ok {
+			errors.WriteHTTPNotFound(w, "No recent job with this request ID on this node", nil)
+			return
+		}
+		if r.URL.Query().Get("format") != "zip" {
+			writeJSON(w, bundle)
+			return
+		}
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		files := []struct {
+			name    string
+			content any
+		}{
+			{"job.json", map[string]any{"request_id": bundle.RequestID, "in_flight": bundle.InFlight, "state": bundle.State}},
+			{"timeline.json", bundle.Timeline},
+			{"config.json", bundle.Config},
+			{"probe.json", bundle.Probe},
+			{"callbacks.json", bundle.Callbacks},
+		}
+		for _, f := range files {
+			b, err := json.MarshalIndent(f.content, "", "  ")
+			if err == nil {
+				err = writeZipFile(zw, f.name, b)
+			}
+			if err != nil {
+				errors.WriteHTTPInternalServerError(w, "Could not create debug bundle", err)
+				return
+			}
+		}
+		if err := writeZipFile(zw, "logs.txt", []byte(strings.Join(bundle.Logs, "\n"))); err != nil {
+			errors.WriteHTTPInternalServerError(w, "Could not create debug bundle", err)
+			return
+		}
+		if err := zw.Close(); err != nil {
+			errors.WriteHTTPInternalServerError(w, "Could not create debug bundle", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "debug-"+requestID+".zip"))
+		w.Write(buf.Bytes()) // nolint:errcheck
+	}
+}
+
+func writeZipFile(zw *zip.Writer, name string, content []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
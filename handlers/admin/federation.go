@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/balancer/federation"
+	"github.com/livepeer/catalyst-api/errors"
+)
+
+// FederationSummaryHandler returns the summary of the healthy nodes of this cluster, polled by the other clusters of
+// the federation to route viewers here
+func (c *AdminHandlersCollection) FederationSummaryHandler(local *federation.LocalCluster) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		summary, err := local.Summary(r.Context())
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Could not summarize the cluster", err)
+			return
+		}
+		writeJSON(w, summary)
+	}
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/maintenance"
+)
+
+type DrainRequest struct {
+	Draining bool `json:"draining"`
+}
+
+type MaintenanceWindowsRequest struct {
+	// Cron specs in UTC followed by durations, like -maintenance-windows, e.g. "0 3 * * 0 2h"
+	Windows []string `json:"windows"`
+}
+
+// MaintenanceHandler shows whether the node is draining, its maintenance windows and when the next one is
+func (c *AdminHandlersCollection) MaintenanceHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(w, c.Maintenance.Status())
+	}
+}
+
+// DrainHandler drains the node by hand, e.g. for unplanned maintenance, or stops draining it
+func (c *AdminHandlersCollection) DrainHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var body DrainRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if err := c.Maintenance.SetManual(body.Draining); err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Could not drain the node", err)
+			return
+		}
+		writeJSON(w, c.Maintenance.Status())
+	}
+}
+
+// MaintenanceWindowsHandler replaces the maintenance windows of the node until it restarts
+func (c *AdminHandlersCollection) MaintenanceWindowsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var body MaintenanceWindowsRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		var windows []maintenance.Window
+		for _, spec := range body.Windows {
+			window, err := maintenance.ParseWindow(spec)
+			if err != nil {
+				catErrs.WriteHTTPBadRequest(w, "Invalid maintenance window", err)
+				return
+			}
+			windows = append(windows, window)
+		}
+		if err := c.Maintenance.SetWindows(windows); err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Could not update the maintenance state of the node", err)
+			return
+		}
+		writeJSON(w, c.Maintenance.Status())
+	}
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/mistbackup"
+)
+
+// MistBackupsHandler lists the backups of the Mist config, newest first
+func (c *AdminHandlersCollection) MistBackupsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(w, c.MistBackups.List())
+	}
+}
+
+// BackupMistConfigHandler backs up the Mist config right away, e.g. before changing it by hand
+func (c *AdminHandlersCollection) BackupMistConfigHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		snapshot, _, err := c.MistBackups.Backup(r.Context())
+		if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Could not back up Mist config", err)
+			return
+		}
+		writeJSON(w, snapshot)
+	}
+}
+
+func (c *AdminHandlersCollection) MistBackupHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		snapshot, err := c.MistBackups.Get(params.ByName("id"))
+		if err != nil {
+			catErrs.WriteHTTPNotFound(w, "Backup not found", err)
+			return
+		}
+		writeJSON(w, snapshot)
+	}
+}
+
+// MistBackupDiffHandler shows the changes from a backup to the current Mist config, or to the backup given by the
+// `to` query parameter
+func (c *AdminHandlersCollection) MistBackupDiffHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		diff, err := c.MistBackups.Diff(params.ByName("id"), r.URL.Query().Get("to"))
+		if errors.Is(err, mistbackup.ErrSnapshotNotFound) {
+			catErrs.WriteHTTPNotFound(w, "Backup not found", err)
+			return
+		} else if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Could not diff Mist config", err)
+			return
+		}
+		writeJSON(w, diff)
+	}
+}
+
+// RestoreMistBackupHandler rolls the Mist triggers and stream configs back to a backup. The ones configured since are
+// only removed with ?prune=true.
+func (c *AdminHandlersCollection) RestoreMistBackupHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		prune := r.URL.Query().Get("prune") == "true"
+		result, err := c.MistBackups.Restore(r.Context(), params.ByName("id"), prune)
+		if errors.Is(err, mistbackup.ErrSnapshotNotFound) {
+			catErrs.WriteHTTPNotFound(w, "Backup not found", err)
+			return
+		} else if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Could not restore Mist config", err)
+			return
+		}
+		writeJSON(w, result)
+	}
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"golang.org/x/net/websocket"
+)
+
+// MistStateDiffsHandler streams the changes of the Mist state over a WebSocket as JSON messages, one per reconcile
+// that saw streams start or stop, pushes come or go, or client counts change
+func (c *AdminHandlersCollection) MistStateDiffsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if c.Mapic == nil {
+			catErrs.WriteHTTPNotFound(w, "Mist state diffs are only available on nodes running mapic", fmt.Errorf("mapic is disabled"))
+			return
+		}
+		// No Handshake so that clients which don't send an Origin, i.e. everything but browsers, are accepted. The
+		// endpoint is authenticated by token anyway.
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			diffs, unsubscribe := c.Mapic.SubscribeStateDiffs()
+			defer unsubscribe()
+			// The request context isn't cancelled once the connection is hijacked, so the client going away is only
+			// seen when reading from it. Nothing is expected from the client otherwise.
+			disconnected := make(chan struct{})
+			go func() {
+				defer close(disconnected)
+				var msg []byte
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+			}()
+			for {
+				select {
+				case <-disconnected:
+					return
+				case diff, ok := <-diffs:
+					if !ok {
+						return
+					}
+					if err := websocket.JSON.Send(ws, diff); err != nil {
+						log.LogNoRequestID("stopped sending Mist state diffs", "err", err)
+						return
+					}
+				}
+			}
+		}}.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/clients"
+	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type stubStateDiffs struct {
+	mistapiconnector.IMac
+	diffs        chan clients.MistStateDiff
+	unsubscribed chan struct{}
+}
+
+func (s *stubStateDiffs) SubscribeStateDiffs() (<-chan clients.MistStateDiff, func()) {
+	return s.diffs, func() { close(s.unsubscribed) }
+}
+
+func TestMistStateDiffsUnsubscribeOnDisconnect(t *testing.T) {
+	mapic := &stubStateDiffs{diffs: make(chan clients.MistStateDiff, 1), unsubscribed: make(chan struct{})}
+	router := httprouter.New()
+	router.GET("/api/admin/mist/state-diffs", (&AdminHandlersCollection{Mapic: mapic}).MistStateDiffsHandler())
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	ws, err := websocket.Dial(strings.Replace(srv.URL, "http://", "ws://", 1)+"/api/admin/mist/state-diffs", "", srv.URL)
+	require.NoError(t, err)
+	mapic.diffs <- clients.MistStateDiff{}
+	var diff clients.MistStateDiff
+	require.NoError(t, websocket.JSON.Receive(ws, &diff))
+
+	require.NoError(t, ws.Close())
+	select {
+	case <-mapic.unsubscribed:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the subscriber must be dropped once the client disconnects")
+	}
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/handlers/analytics"
+	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
+	"github.com/livepeer/go-api-client"
+)
+
+// StreamInfo is what the node knows of a live stream: GET /api/admin/streams/:playbackID
+type StreamInfo struct {
+	PlaybackID string `json:"playback_id"`
+	// Set when the stream is ingested or played back on the node
+	Active         bool       `json:"active"`
+	IngestNode     string     `json:"ingest_node,omitempty"`
+	IngestProtocol string     `json:"ingest_protocol,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	// The URL to push the stream to over SRT, on the port allocated to it. Unset when SRT ports aren't managed or no
+	// port was allocated to the stream yet, see POST /api/admin/streams/:playbackID/srt-ingest.
+	SRTIngestURL string `json:"srt_ingest_url,omitempty"`
+}
+
+// StreamInfoHandler returns what the node knows of a live stream
+func (c *AdminHandlersCollection) StreamInfoHandler() httprouter.Handle {
+	return c.streamInfoHandler(false)
+}
+
+// SRTIngestHandler allocates an SRT ingest port to a stream if it has none yet and returns what the node knows of the
+// stream, including the URL to push it to over SRT: POST /api/admin/streams/:playbackID/srt-ingest
+func (c *AdminHandlersCollection) SRTIngestHandler() httprouter.Handle {
+	return c.streamInfoHandler(true)
+}
+
+func (c *AdminHandlersCollection) streamInfoHandler(allocateSRTPort bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if c.Mapic == nil {
+			catErrs.WriteHTTPNotFound(w, "Stream info is only available on nodes running mapic", fmt.Errorf("mapic is disabled"))
+			return
+		}
+		info := StreamInfo{PlaybackID: params.ByName("playbackID")}
+		if session, ok := c.Mapic.GetStreamSession(info.PlaybackID); ok {
+			info.Active = true
+			info.IngestNode = session.IngestNode
+			info.IngestProtocol = session.IngestProtocol
+			if !session.StartedAt.IsZero() {
+				info.StartedAt = &session.StartedAt
+			}
+		}
+
+		srtURL, err := c.Mapic.SRTIngestURL(info.PlaybackID, allocateSRTPort)
+		switch {
+		case err == nil:
+			info.SRTIngestURL = srtURL
+		case errors.Is(err, mistapiconnector.ErrSRTIngestDisabled):
+			if allocateSRTPort {
+				catErrs.WriteHTTPNotFound(w, "SRT ingest ports aren't managed on this node", err)
+				return
+			}
+		case errors.Is(err, api.ErrNotExists):
+			catErrs.WriteHTTPNotFound(w, "Stream not found", err)
+			return
+		case errors.Is(err, mistapiconnector.ErrSRTPortsExhausted):
+			catErrs.WriteHTTPTooManyRequests(w, "No SRT ingest port left for the stream", err)
+			return
+		default:
+			catErrs.WriteHTTPInternalServerError(w, "Could not allocate an SRT ingest port to the stream", err)
+			return
+		}
+		writeJSON(w, info)
+	}
+}
+
+// MultistreamStatus is the health of the multistream targets of a stream: GET /api/stream/:playbackID/multistream/status
+type MultistreamStatus struct {
+	PlaybackID string                                     `json:"playback_id"`
+	Targets    []mistapiconnector.MultistreamTargetStatus `json:"targets"`
+}
+
+// MultistreamStatusHandler returns the state, last event, error counts and current push stats of each of the
+// multistream targets of a stream, as seen by this node
+func (c *AdminHandlersCollection) MultistreamStatusHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if c.Mapic == nil {
+			catErrs.WriteHTTPNotFound(w, "Multistream status is only available on nodes running mapic", fmt.Errorf("mapic is disabled"))
+			return
+		}
+		status := MultistreamStatus{PlaybackID: params.ByName("playbackID")}
+		targets, err := c.Mapic.MultistreamStatus(status.PlaybackID)
+		switch {
+		case err == nil:
+			status.Targets = targets
+		case errors.Is(err, api.ErrNotExists):
+			catErrs.WriteHTTPNotFound(w, "Stream not found", err)
+			return
+		default:
+			catErrs.WriteHTTPInternalServerError(w, "Could not get the multistream status of the stream", err)
+			return
+		}
+		writeJSON(w, status)
+	}
+}
+
+// GeoHeatmapHandler returns the viewers of a stream by region over the last window of the viewer heatmaps, from the
+// analytics heartbeats received by this node only: GET /api/admin/analytics/heatmap/:playbackID. The audience of
+// streams whose viewers are spread across nodes is the sum of the heatmaps of each node.
+func (c *AdminHandlersCollection) GeoHeatmapHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if c.Heatmap == nil {
+			catErrs.WriteHTTPNotFound(w, "Viewer heatmaps are only available on nodes with analytics enabled", fmt.Errorf("viewer heatmaps are disabled"))
+			return
+		}
+		playbackID := params.ByName("playbackID")
+		heatmap, ok := c.Heatmap.Get(playbackID)
+		if !ok {
+			heatmap = analytics.Heatmap{PlaybackID: playbackID, Buckets: []analytics.GeoBucket{}}
+		}
+		writeJSON(w, heatmap)
+	}
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/pipeline"
+)
+
+// VODHistoryHandler lists the past jobs recorded in the vod_completed metrics, newest first. The results can be
+// filtered with the `from` and `to` times the jobs finished between (unix seconds or RFC 3339), `state`, `pipeline`
+// and `external_id` query parameters, and are paged with `limit` and the `cursor` returned with the previous page.
+func (c *AdminHandlersCollection) VODHistoryHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if c.MetricsDB == nil {
+			errors.WriteHTTPNotFound(w, "No metrics DB configured", nil)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := pipeline.VODHistoryFilter{
+			State:      query.Get("state"),
+			Pipeline:   query.Get("pipeline"),
+			ExternalID: query.Get("external_id"),
+			Cursor:     query.Get("cursor"),
+		}
+		var err error
+		if filter.From, err = parseHistoryTime(query.Get("from")); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid from parameter", err)
+			return
+		}
+		if filter.To, err = parseHistoryTime(query.Get("to")); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid to parameter", err)
+			return
+		}
+		if limit := query.Get("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit <= 0 || filter.Limit > pipeline.MaxVODHistoryLimit {
+				errors.WriteHTTPBadRequest(w, fmt.Sprintf("Invalid limit parameter, must be between 1 and %d", pipeline.MaxVODHistoryLimit), err)
+				return
+			}
+		}
+
+		page, err := pipeline.QueryVODHistory(r.Context(), c.MetricsDB, filter)
+		if err == pipeline.ErrInvalidVODHistoryCursor {
+			errors.WriteHTTPBadRequest(w, "Invalid cursor parameter", err)
+			return
+		}
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Could not query the job history", err)
+			return
+		}
+		writeJSON(w, page)
+	}
+}
+
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/pipeline"
+	"github.com/stretchr/testify/require"
+)
+
+func historyRow(finishedAt int64, requestID string) []driver.Value {
+	return []driver.Value{
+		finishedAt, finishedAt - 60, requestID, "ext-" + requestID, "h264", "aac", "catalyst_ffmpeg", "lon", "completed",
+		3, 60000, 10, 30, 1000, 20000, "s3://source", "s3://target", false, 0, 0, 0, 0, false, false, nil, []byte(`{"a":"b"}`), "",
+	}
+}
+
+func TestVODHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := make([]string, 27)
+	for i := range columns {
+		columns[i] = "c"
+	}
+	mock.ExpectQuery(`from "vod_completed" where "finished_at" >= \$1 and "state" = \$2 and "external_id" = \$3 order by "finished_at" desc, "request_id" desc limit \$4`).
+		WithArgs(1700000000, "failed", "ext-1", 3).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(historyRow(1700000300, "c")...).AddRow(historyRow(1700000200, "b")...).AddRow(historyRow(1700000100, "a")...))
+
+	handlers := &AdminHandlersCollection{MetricsDB: db}
+	router := httprouter.New()
+	router.GET("/api/vod/history", handlers.VODHistoryHandler())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/vod/history?from=2023-11-14T22:13:20Z&state=failed&external_id=ext-1&limit=2", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var page pipeline.VODHistoryPage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Jobs, 2)
+	require.Equal(t, "c", page.Jobs[0].RequestID)
+	require.Equal(t, int64(60000), page.Jobs[0].JobDuration)
+	require.JSONEq(t, `{"a":"b"}`, string(page.Jobs[0].Metadata))
+	require.NotEmpty(t, page.NextCursor)
+
+	// The next page carries on after the last job of this one
+	mock.ExpectQuery(`from "vod_completed" where \("finished_at", "request_id"\) < \(\$1, \$2\) order by "finished_at" desc, "request_id" desc limit \$3`).
+		WithArgs(1700000200, "b", 3).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(historyRow(1700000100, "a")...))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/vod/history?limit=2&cursor="+page.NextCursor, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var next pipeline.VODHistoryPage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &next))
+	require.Len(t, next.Jobs, 1)
+	require.Empty(t, next.NextCursor)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVODHistoryInvalidParams(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	router := httprouter.New()
+	router.GET("/api/vod/history", (&AdminHandlersCollection{MetricsDB: db}).VODHistoryHandler())
+	for _, query := range []string{"from=yesterday", "to=2023-11-14", "limit=0", "limit=1000", "cursor=nope"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/vod/history?"+query, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+
+	router = httprouter.New()
+	router.GET("/api/vod/history", (&AdminHandlersCollection{}).VODHistoryHandler())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/vod/history", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
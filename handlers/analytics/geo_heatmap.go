@@ -0,0 +1,216 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/segmentio/kafka-go"
+)
+
+// GeoBucket is the audience of a stream from one region of a country over a heatmap window
+type GeoBucket struct {
+	CountryCode string `json:"country_code"`
+	CountryName string `json:"country_name"`
+	Subdivision string `json:"subdivision"`
+	// Distinct viewing sessions
+	Viewers    int `json:"viewers"`
+	Heartbeats int `json:"heartbeats"`
+}
+
+// Heatmap is the audience of a stream by region over a window of the heartbeats its viewers sent one node, the regions
+// with the most viewers first
+type Heatmap struct {
+	PlaybackID string `json:"playback_id"`
+	// Node that received the heartbeats, the audience of a stream is the sum of the heatmaps of all nodes
+	Node        string      `json:"node,omitempty"`
+	WindowStart int64       `json:"window_start"`
+	WindowEnd   int64       `json:"window_end"`
+	Viewers     int         `json:"viewers"`
+	Buckets     []GeoBucket `json:"buckets"`
+}
+
+type geoKey struct {
+	countryCode string
+	subdivision string
+}
+
+type geoCounts struct {
+	countryName string
+	sessions    map[string]bool
+	heartbeats  int
+}
+
+// GeoHeatmap aggregates the heartbeats of viewers into the audience of each stream by region, over windows of a fixed
+// interval. The last complete window of each stream is kept in memory until the next one ends, and sent to Kafka
+// when a topic is configured.
+//
+// Heatmaps are per node: each node only counts the heartbeats it receives, so the viewers of a stream whose
+// heartbeats are load balanced across nodes are split between their heatmaps. They aren't merged across the cluster,
+// the consumers of the Kafka topic or the Prometheus series sum them by stream instead.
+type GeoHeatmap struct {
+	interval time.Duration
+	node     string
+	writer   *kafka.Writer
+
+	mu          sync.Mutex
+	windowStart time.Time
+	current     map[string]map[geoKey]*geoCounts
+	last        map[string]Heatmap
+}
+
+// NewGeoHeatmap aggregates the heatmaps of the node over windows of the given interval, not sending them to Kafka
+// without a topic
+func NewGeoHeatmap(interval time.Duration, node, bootstrapServers, user, password, topic string) *GeoHeatmap {
+	h := &GeoHeatmap{
+		interval:    interval,
+		node:        node,
+		windowStart: time.Now(),
+		current:     map[string]map[geoKey]*geoCounts{},
+		last:        map[string]Heatmap{},
+	}
+	if topic != "" {
+		h.writer = newWriter(bootstrapServers, user, password, topic)
+	}
+	return h
+}
+
+// Add counts the heartbeat events of viewers in the current window
+func (h *GeoHeatmap) Add(d LogData) {
+	if d.EventType != "heartbeat" || d.PlaybackID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	regions, ok := h.current[d.PlaybackID]
+	if !ok {
+		regions = map[geoKey]*geoCounts{}
+		h.current[d.PlaybackID] = regions
+	}
+	key := geoKey{countryCode: d.PlaybackCountryCode, subdivision: d.PlaybackSubdivision}
+	counts, ok := regions[key]
+	if !ok {
+		counts = &geoCounts{countryName: d.PlaybackCountryName, sessions: map[string]bool{}}
+		regions[key] = counts
+	}
+	counts.sessions[d.SessionID] = true
+	counts.heartbeats++
+}
+
+// Get returns the heatmap of the last complete window of a stream on this node, false if it had no viewers here then
+func (h *GeoHeatmap) Get(playbackID string) (Heatmap, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	heatmap, ok := h.last[playbackID]
+	return heatmap, ok
+}
+
+// Run ends a window every interval until the context is done
+func (h *GeoHeatmap) Run(ctx context.Context) error {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			h.flush(now)
+		}
+	}
+}
+
+// flush ends the current window, replacing the heatmaps of the last one and publishing them
+func (h *GeoHeatmap) flush(now time.Time) {
+	h.mu.Lock()
+	heatmaps := make(map[string]Heatmap, len(h.current))
+	for playbackID, regions := range h.current {
+		heatmaps[playbackID] = toHeatmap(playbackID, h.node, h.windowStart, now, regions)
+	}
+	h.last = heatmaps
+	h.current = map[string]map[geoKey]*geoCounts{}
+	h.windowStart = now
+	h.mu.Unlock()
+
+	updateHeatmapMetrics(heatmaps)
+	if h.writer != nil {
+		h.send(heatmaps)
+	}
+}
+
+func toHeatmap(playbackID, node string, start, end time.Time, regions map[geoKey]*geoCounts) Heatmap {
+	heatmap := Heatmap{
+		PlaybackID:  playbackID,
+		Node:        node,
+		WindowStart: start.UnixMilli(),
+		WindowEnd:   end.UnixMilli(),
+	}
+	sessions := map[string]bool{}
+	for key, counts := range regions {
+		heatmap.Buckets = append(heatmap.Buckets, GeoBucket{
+			CountryCode: key.countryCode,
+			CountryName: counts.countryName,
+			Subdivision: key.subdivision,
+			Viewers:     len(counts.sessions),
+			Heartbeats:  counts.heartbeats,
+		})
+		for session := range counts.sessions {
+			sessions[session] = true
+		}
+	}
+	heatmap.Viewers = len(sessions)
+	sort.Slice(heatmap.Buckets, func(i, j int) bool {
+		a, b := heatmap.Buckets[i], heatmap.Buckets[j]
+		if a.Viewers != b.Viewers {
+			return a.Viewers > b.Viewers
+		}
+		if a.CountryCode != b.CountryCode {
+			return a.CountryCode < b.CountryCode
+		}
+		return a.Subdivision < b.Subdivision
+	})
+	return heatmap
+}
+
+// updateHeatmapMetrics replaces the viewers by country of the streams with those of the last window. Subdivisions are
+// left out to keep the number of series down.
+func updateHeatmapMetrics(heatmaps map[string]Heatmap) {
+	gauge := metrics.Metrics.AnalyticsMetrics.AnalyticsHeatmapViewers
+	gauge.Reset()
+	for playbackID, heatmap := range heatmaps {
+		byCountry := map[string]int{}
+		for _, b := range heatmap.Buckets {
+			country := b.CountryCode
+			if country == "" {
+				country = "unknown"
+			}
+			byCountry[country] += b.Viewers
+		}
+		for country, viewers := range byCountry {
+			gauge.WithLabelValues(playbackID, country).Set(float64(viewers))
+		}
+	}
+}
+
+func (h *GeoHeatmap) send(heatmaps map[string]Heatmap) {
+	defer logWriteMetrics(h.writer)
+
+	var msgs []kafka.Message
+	for playbackID, heatmap := range heatmaps {
+		value, err := json.Marshal(heatmap)
+		if err != nil {
+			glog.Errorf("invalid viewer heatmap, cannot send to Kafka, playbackID=%s, err=%v", playbackID, err)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(playbackID), Value: value})
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	glog.V(6).Infof("sending viewer heatmaps, count=%d", len(msgs))
+	sendWithRetries(h.writer, msgs)
+}
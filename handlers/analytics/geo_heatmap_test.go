@@ -0,0 +1,72 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func heartbeat(playbackID, sessionID, countryCode, subdivision string) LogData {
+	return LogData{
+		EventType:           "heartbeat",
+		PlaybackID:          playbackID,
+		SessionID:           sessionID,
+		PlaybackCountryCode: countryCode,
+		PlaybackCountryName: map[string]string{"US": "United States", "DE": "Germany"}[countryCode],
+		PlaybackSubdivision: subdivision,
+	}
+}
+
+func TestGeoHeatmap(t *testing.T) {
+	h := NewGeoHeatmap(time.Minute, "node", "", "", "", "")
+	start := h.windowStart
+
+	h.Add(heartbeat("abcd", "s1", "US", "California"))
+	h.Add(heartbeat("abcd", "s1", "US", "California"))
+	h.Add(heartbeat("abcd", "s2", "US", "California"))
+	h.Add(heartbeat("abcd", "s3", "DE", "Berlin"))
+	h.Add(heartbeat("abcd", "s4", "", ""))
+	h.Add(heartbeat("efgh", "s5", "DE", "Bavaria"))
+	h.Add(LogData{EventType: "error", PlaybackID: "abcd", SessionID: "s6", PlaybackCountryCode: "FR"})
+
+	// Nothing is served before the window ends
+	_, ok := h.Get("abcd")
+	require.False(t, ok)
+
+	end := start.Add(time.Minute)
+	h.flush(end)
+
+	heatmap, ok := h.Get("abcd")
+	require.True(t, ok)
+	require.Equal(t, Heatmap{
+		PlaybackID:  "abcd",
+		Node:        "node",
+		WindowStart: start.UnixMilli(),
+		WindowEnd:   end.UnixMilli(),
+		Viewers:     4,
+		Buckets: []GeoBucket{
+			{CountryCode: "US", CountryName: "United States", Subdivision: "California", Viewers: 2, Heartbeats: 3},
+			{CountryCode: "", CountryName: "", Subdivision: "", Viewers: 1, Heartbeats: 1},
+			{CountryCode: "DE", CountryName: "Germany", Subdivision: "Berlin", Viewers: 1, Heartbeats: 1},
+		},
+	}, heatmap)
+
+	gauge := metrics.Metrics.AnalyticsMetrics.AnalyticsHeatmapViewers
+	require.Equal(t, 2.0, testutil.ToFloat64(gauge.WithLabelValues("abcd", "US")))
+	require.Equal(t, 1.0, testutil.ToFloat64(gauge.WithLabelValues("abcd", "unknown")))
+	require.Equal(t, 1.0, testutil.ToFloat64(gauge.WithLabelValues("efgh", "DE")))
+
+	// The streams without viewers in the next window drop out of the heatmaps
+	h.Add(heartbeat("efgh", "s5", "DE", "Bavaria"))
+	h.flush(end.Add(time.Minute))
+
+	_, ok = h.Get("abcd")
+	require.False(t, ok)
+	heatmap, ok = h.Get("efgh")
+	require.True(t, ok)
+	require.Equal(t, 1, heatmap.Viewers)
+	require.Equal(t, 1, testutil.CollectAndCount(gauge))
+}
@@ -22,6 +22,8 @@ type LogProcessor struct {
 	logs   []LogData
 	writer *kafka.Writer
 	topic  string
+	// Aggregates the heartbeats into viewer heatmaps, if not nil
+	heatmap *GeoHeatmap
 }
 
 type LogDataEvent struct {
@@ -92,12 +94,13 @@ type KafkaKey struct {
 	EventType string `json:"event_type"`
 }
 
-func NewLogProcessor(bootstrapServers, user, password, topic string) *LogProcessor {
+func NewLogProcessor(bootstrapServers, user, password, topic string, heatmap *GeoHeatmap) *LogProcessor {
 	writer := newWriter(bootstrapServers, user, password, topic)
 	return &LogProcessor{
-		logs:   []LogData{},
-		writer: writer,
-		topic:  topic,
+		logs:    []LogData{},
+		writer:  writer,
+		topic:   topic,
+		heatmap: heatmap,
 	}
 }
 
@@ -118,6 +121,9 @@ func (lp *LogProcessor) Start(ch chan LogData) {
 
 func (p *LogProcessor) processLog(d LogData) {
 	updateMetrics(d)
+	if p.heatmap != nil {
+		p.heatmap.Add(d)
+	}
 	p.logs = append(p.logs, d)
 }
 
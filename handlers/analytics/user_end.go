@@ -11,6 +11,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
 )
 
 const (
@@ -20,10 +21,11 @@ const (
 )
 
 type AnalyticsHandler struct {
-	db     *sql.DB
-	dataCh chan userEndData
-	events []userEndData
-	writer *kafka.Writer
+	db       *sql.DB
+	dataCh   chan userEndData
+	events   []userEndData
+	writer   *kafka.Writer
+	sessions mistapiconnector.IStreamSessions
 }
 
 type userEndData struct {
@@ -40,9 +42,18 @@ type userEndData struct {
 	IPAddress       string `json:"ip_address"`
 	IPAddressCount  int    `json:"ip_address_count"`
 	Tags            string `json:"tags"`
+
+	// Context of the live stream session, when the node knows of it
+	LivestreamID      string `json:"livestream_id,omitempty"`
+	UserID            string `json:"user_id,omitempty"`
+	IngestNode        string `json:"ingest_node,omitempty"`
+	IngestProtocol    string `json:"ingest_protocol,omitempty"`
+	StreamStartedAtMs int64  `json:"stream_started_at_ms,omitempty"`
 }
 
-func NewAnalyticsHandler(cli config.Cli, db *sql.DB) AnalyticsHandler {
+// NewAnalyticsHandler creates the handler of USER_END events. The events sent to Kafka are joined with the stream
+// sessions, if any, so that consumers get the context of the live stream along with the viewer's session.
+func NewAnalyticsHandler(cli config.Cli, db *sql.DB, sessions mistapiconnector.IStreamSessions) AnalyticsHandler {
 	var writer *kafka.Writer
 	if cli.KafkaBootstrapServers == "" || cli.KafkaUser == "" || cli.KafkaPassword == "" || cli.UserEndKafkaTopic == "" {
 		glog.Warning("Invalid Kafka configuration for USER_END events, not using Kafka")
@@ -55,8 +66,9 @@ func NewAnalyticsHandler(cli config.Cli, db *sql.DB) AnalyticsHandler {
 		db: db,
 
 		// User to send USER_END events to Kafka
-		dataCh: make(chan userEndData, channelBufferSize),
-		writer: writer,
+		dataCh:   make(chan userEndData, channelBufferSize),
+		writer:   writer,
+		sessions: sessions,
 	}
 
 	a.startLoop()
@@ -68,7 +80,7 @@ func (a *AnalyticsHandler) HandleUserEnd(ctx context.Context, payload *misttrigg
 	if a.writer != nil {
 		// Using Kafka
 		select {
-		case a.dataCh <- toUserEndData(payload):
+		case a.dataCh <- a.enrich(toUserEndData(payload)):
 			// process data async
 		default:
 			glog.Warningf("error processing USER_END trigger event, too many triggers in the buffer")
@@ -173,6 +185,25 @@ func (a *AnalyticsHandler) sendEvents() {
 	sendWithRetries(a.writer, msgs)
 }
 
+// enrich joins the event with the session of the stream it's for
+func (a *AnalyticsHandler) enrich(d userEndData) userEndData {
+	if a.sessions == nil {
+		return d
+	}
+	session, ok := a.sessions.GetStreamSession(d.StreamID)
+	if !ok {
+		return d
+	}
+	d.LivestreamID = session.StreamID
+	d.UserID = session.UserID
+	d.IngestNode = session.IngestNode
+	d.IngestProtocol = session.IngestProtocol
+	if !session.StartedAt.IsZero() {
+		d.StreamStartedAtMs = session.StartedAt.UnixMilli()
+	}
+	return d
+}
+
 func toUserEndData(payload *misttriggers.UserEndPayload) userEndData {
 	return userEndData{
 		UUID:            payload.TriggerID,
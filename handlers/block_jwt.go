@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	errors2 "errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/events"
+	"github.com/livepeer/catalyst-api/handlers/accesscontrol"
+)
+
+type BlockJWTRequest struct {
+	JWT string `json:"jwt"`
+}
+
+// BlockJWT blocks a playback JWT at runtime on all nodes, on top of the ones configured with -gate-blocked-jwts and
+// -gate-blocked-jwts-list, rejecting its existing sessions too
+func (d *EventsHandlersCollection) BlockJWT() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var body BlockJWTRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if body.JWT == "" {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("missing jwt"))
+			return
+		}
+		// The JWT is blocked whether or not it is still valid, so only the playback ID is needed from it
+		playbackID, err := accesscontrol.JWTPlaybackID(body.JWT)
+		if err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid JWT", err)
+			return
+		}
+		event := events.NewBlockJWTEvent(playbackID, body.JWT)
+		d.propagateEvent(w, r, Event{Resource: event.Resource, PlaybackID: playbackID, JWT: body.JWT}.userEventName(), event)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/events"
+)
+
+type CdnRedirectRuleRequest struct {
+	Percentage float64 `json:"percentage"`
+}
+
+// CdnRedirectRules returns the CDN redirect rules currently in effect on this node, i.e. the ones from the
+// -cdn-redirect-playback-ids flag with the runtime rules applied on top.
+func (d *EventsHandlersCollection) CdnRedirectRules(cli config.Cli) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		b, err := json.Marshal(d.cdnRedirects.Effective(cli.CdnRedirectPlaybackPct))
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Could not marshal CDN redirect rules", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b) // nolint:errcheck
+	}
+}
+
+// SetCdnRedirectRule adds or updates the percentage of traffic redirected to the CDN for a playbackID on all nodes
+func (d *EventsHandlersCollection) SetCdnRedirectRule() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		var rule CdnRedirectRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if rule.Percentage < 0 || rule.Percentage > 100 {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("percentage should be between 0.0 and 100.0"))
+			return
+		}
+		event := events.NewCdnRedirectEvent(params.ByName("playbackID"), &rule.Percentage)
+		d.propagateEvent(w, r, fmt.Sprintf("%s-%s", event.Resource, event.PlaybackID), event)
+	}
+}
+
+// DeleteCdnRedirectRule removes the runtime rule of a playbackID on all nodes, falling back to
+// -cdn-redirect-playback-ids. Set a rule of 0% to stop redirecting a playbackID of the flag instead.
+func (d *EventsHandlersCollection) DeleteCdnRedirectRule() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		event := events.NewCdnRedirectEvent(params.ByName("playbackID"), nil)
+		d.propagateEvent(w, r, fmt.Sprintf("%s-%s", event.Resource, event.PlaybackID), event)
+	}
+}
+
+// propagateEvent sends the event to all nodes through Serf, coalesced by name. When running without the cluster
+// (e.g. api-only mode), the event is forwarded to the admin events endpoint of the Catalyst node instead, authorized
+// with the token of the request.
+func (d *EventsHandlersCollection) propagateEvent(w http.ResponseWriter, r *http.Request, name string, event events.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		errors.WriteHTTPInternalServerError(w, "Cannot marshal event", err)
+		return
+	}
+
+	if d.cluster != nil {
+		err = d.cluster.BroadcastEvent(serf.UserEvent{
+			Name:     name,
+			Payload:  payload,
+			Coalesce: true,
+		})
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Cannot process event", err)
+		}
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, AdminEventsEndpoint(d.eventsEndpoint), bytes.NewReader(payload))
+	if err != nil {
+		errors.WriteHTTPInternalServerError(w, "Cannot forward event", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		errors.WriteHTTPInternalServerError(w, "Cannot forward event", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body) // nolint:errcheck
+}
+
+// AdminEventsEndpoint is the admin events endpoint of the Catalyst node of an -events-endpoint, i.e. /api/admin/events
+// next to its /api/events
+func AdminEventsEndpoint(eventsEndpoint string) string {
+	return strings.TrimSuffix(eventsEndpoint, "/api/events") + "/api/admin/events"
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	errors2 "errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/pipeline"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ClipBatchMarker is one of the clips of a batch, between unix times in milliseconds
+type ClipBatchMarker struct {
+	StartTime int64 `json:"start_time"`
+	// Defaults to the start of the next marker
+	EndTime int64  `json:"end_time,omitempty"`
+	Title   string `json:"title,omitempty"`
+}
+
+// ClipBatchRequest cuts a recording into a clip per marker, e.g. the chapters of a stream. The options are those of a
+// clipping UploadVODRequest, shared by all of the clips.
+type ClipBatchRequest struct {
+	UploadVODRequest
+	Markers []ClipBatchMarker `json:"markers"`
+}
+
+type ClipBatchResponseClip struct {
+	RequestID string `json:"request_id"`
+	Title     string `json:"title,omitempty"`
+}
+
+type ClipBatchResponse struct {
+	RequestID string                  `json:"request_id"`
+	Clips     []ClipBatchResponseClip `json:"clips"`
+}
+
+// ClipBatch starts a clipping job per marker of the request, whose statuses are combined into the status callbacks
+// of the batch
+func (d *CatalystAPIHandlersCollection) ClipBatch() httprouter.Handle {
+	schema := inputSchemasCompiled["ClipBatch"]
+
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		d.handleClipBatch(w, req, schema)
+	}
+}
+
+func (d *CatalystAPIHandlersCollection) handleClipBatch(w http.ResponseWriter, req *http.Request, schema *gojsonschema.Schema) (bool, errors.APIError) {
+	var batchRequest ClipBatchRequest
+
+	if !HasContentType(req, "application/json") {
+		return false, errors.WriteHTTPUnsupportedMediaType(w, "Requires application/json content type", nil)
+	} else if payload, err := io.ReadAll(req.Body); err != nil {
+		return false, errors.WriteHTTPInternalServerError(w, "Cannot read payload", err)
+	} else if result, err := schema.Validate(gojsonschema.NewBytesLoader(payload)); err != nil {
+		return false, errors.WriteHTTPInternalServerError(w, "Cannot validate payload", err)
+	} else if !result.Valid() {
+		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("%s", result.Errors()))
+	} else if err := json.Unmarshal(payload, &batchRequest); err != nil {
+		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+
+	var batchID = config.RandomTrailer(8)
+	log.AddContext(batchID, "source", batchRequest.Url, "external_id", batchRequest.ExternalID, "markers", len(batchRequest.Markers))
+
+	if err := CheckSourceURLValid(batchRequest.Url); err != nil {
+		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	var preflightErr clients.PreflightError
+	if err := clients.PreflightCheck(req.Context(), batchID, batchRequest.Url); errors2.As(err, &preflightErr) && preflightErr.IsFinal() {
+		return false, errors.WriteHTTPBadRequest(w, "Source URL is not accessible", err)
+	}
+
+	if batchRequest.Encryption != nil {
+		if _, err := d.VODEngine.VodDecryptKeys.Get(batchRequest.Encryption.KeyID); err != nil {
+			return false, errors.WriteHTTPBadRequest(w, "Invalid encryption", err)
+		}
+	}
+
+	clipJobs := 0
+	for _, job := range d.VODEngine.Jobs.GetJobs() {
+		if job.ClipStrategy.Enabled {
+			clipJobs++
+		}
+	}
+	if clipJobs+len(batchRequest.Markers) > config.MaxInFlightClipJobs {
+		retryAfter := d.VODEngine.EstimateRetryAfter(true, clipJobs+len(batchRequest.Markers)-config.MaxInFlightClipJobs)
+		return false, errors.WriteHTTPBackpressure(w, "Not enough capacity for the clips", errors.Backpressure{
+			JobType:         "clip",
+			InFlightJobs:    clipJobs,
+			MaxInFlightJobs: config.MaxInFlightClipJobs,
+			RetryAfterSecs:  int(math.Ceil(retryAfter.Seconds())),
+		})
+	}
+
+	batch := pipeline.ClipBatchPayload{
+		RequestID:   batchID,
+		CallbackURL: batchRequest.CallbackUrl,
+		Metadata:    batchRequest.Metadata,
+	}
+	response := ClipBatchResponse{RequestID: batchID}
+	for i, marker := range batchRequest.Markers {
+		clipRequest := batchRequest.UploadVODRequest
+		clipRequest.ClipStrategy.StartTime = marker.StartTime
+		clipRequest.ClipStrategy.EndTime = marker.EndTime
+		if marker.EndTime == 0 && i+1 < len(batchRequest.Markers) {
+			clipRequest.ClipStrategy.EndTime = batchRequest.Markers[i+1].StartTime
+		}
+		if i > 0 && marker.StartTime < batchRequest.Markers[i-1].StartTime {
+			return false, errors.WriteHTTPBadRequest(w, "Invalid Clipping Request", fmt.Errorf("marker %d starts before marker %d", i+1, i))
+		}
+		if clipRequest.ClipStrategy.EndTime == 0 {
+			return false, errors.WriteHTTPBadRequest(w, "Invalid Clipping Request", fmt.Errorf("marker %d has no end time", i+1))
+		}
+		if err := clipRequest.ValidateClippingRequest(); err != nil {
+			return false, errors.WriteHTTPBadRequest(w, "Invalid Clipping Request", fmt.Errorf("marker %d: %w", i+1, err))
+		}
+		outputLocations, err := clipBatchOutputLocations(clipRequest.OutputLocations, i+1)
+		if err != nil {
+			return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		}
+		clipRequest.OutputLocations = outputLocations
+
+		requestID := config.RandomTrailer(8)
+		log.AddContext(requestID, "source", clipRequest.Url, "external_id", clipRequest.ExternalID, "clip_batch", batchID)
+		p, ok, apiError := newUploadJobPayload(w, clipRequest, requestID)
+		if !ok {
+			return false, apiError
+		}
+		batch.Clips = append(batch.Clips, pipeline.ClipBatchClip{Title: marker.Title, UploadJobPayload: p})
+		response.Clips = append(response.Clips, ClipBatchResponseClip{RequestID: requestID, Title: marker.Title})
+	}
+
+	log.Log(batchID, "Received clip batch request", "playback_id", batchRequest.ClipStrategy.PlaybackID, "clips", len(batch.Clips))
+	if err := d.VODEngine.StartClipBatch(batch); err != nil {
+		return false, errors.WriteHTTPInternalServerError(w, "Cannot start the clips", err)
+	}
+
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		return false, errors.WriteHTTPInternalServerError(w, "Failed marshaling response", err)
+	}
+	if _, err := w.Write(respBytes); err != nil {
+		log.LogError(batchID, "Failed to write a clip batch HTTP API response", err)
+	}
+	return true, errors.APIError{}
+}
+
+// clipBatchOutputLocations appends the number of a clip to the last path element of the output locations, e.g.
+// clips/abc-2 for the second clip. The clips stay next to where a single clip would be written, which is where the
+// playback URLs of the clips are derived from.
+func clipBatchOutputLocations(locations []UploadVODRequestOutputLocation, clip int) ([]UploadVODRequestOutputLocation, error) {
+	clipLocations := make([]UploadVODRequestOutputLocation, len(locations))
+	for i, location := range locations {
+		clipLocations[i] = location
+		if location.URL == "" {
+			continue
+		}
+		u, err := url.Parse(location.URL)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "-" + strconv.Itoa(clip)
+		u.RawPath = ""
+		clipLocations[i].URL = u.String()
+	}
+	return clipLocations, nil
+}
@@ -16,8 +16,7 @@ func DebugEndpointListenAndServe(port int, vodEngine *pipeline.Coordinator) erro
 	}
 	listen := fmt.Sprintf("0.0.0.0:%d", port)
 	http.Handle("/jobs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cache := vodEngine.Jobs.UnittestIntrospection()
-		bs, err := json.Marshal(cache)
+		bs, err := json.Marshal(vodEngine.Jobs.Snapshot())
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte("failed to marshal json: " + err.Error()))
@@ -27,10 +26,8 @@ func DebugEndpointListenAndServe(port int, vodEngine *pipeline.Coordinator) erro
 		_, _ = w.Write(bs)
 	}))
 	http.Handle("/jobscount", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cache := vodEngine.Jobs.UnittestIntrospection()
-
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(fmt.Sprintf("%d", len(*cache))))
+		_, _ = w.Write([]byte(fmt.Sprintf("%d", vodEngine.Jobs.Len())))
 	}))
 
 	return http.ListenAndServe(listen, nil)
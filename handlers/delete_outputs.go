@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/pipeline"
+)
+
+type DeleteVODOutputsRequest struct {
+	// ID used in the callbacks, e.g. the request ID of the job that produced the outputs. Generated if not set.
+	RequestID   string `json:"request_id,omitempty"`
+	CallbackUrl string `json:"callback_url"`
+	// The target URLs the outputs were written to, e.g. the HLS, MP4 and thumbnails output locations of the job
+	URLs []string `json:"urls"`
+}
+
+type DeleteVODOutputsResponse struct {
+	RequestID string `json:"request_id"`
+}
+
+// DeleteVODOutputs deletes everything under the given output locations, from the primary and the backup storage. The
+// deletion runs in the background, reporting its progress and completion to the callback URL.
+func (d *CatalystAPIHandlersCollection) DeleteVODOutputs() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		var body DeleteVODOutputsRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if err := validateDeleteOutputsURLs(body.URLs); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if _, err := url.ParseRequestURI(body.CallbackUrl); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("invalid callback_url: %w", err))
+			return
+		}
+
+		requestID := body.RequestID
+		if requestID == "" {
+			requestID = config.RandomTrailer(8)
+		}
+		err := d.VODEngine.DeleteOutputs(pipeline.DeleteOutputsPayload{
+			RequestID:   requestID,
+			CallbackURL: body.CallbackUrl,
+			URLs:        body.URLs,
+		})
+		if err != nil {
+			errors.WriteHTTPBadRequest(w, "Cannot delete outputs", err)
+			return
+		}
+		log.Log(requestID, "deleting outputs", "locations", len(body.URLs))
+
+		if err := json.NewEncoder(w).Encode(DeleteVODOutputsResponse{RequestID: requestID}); err != nil {
+			log.LogError(requestID, "Failed to write a delete outputs HTTP API response", err)
+		}
+	}
+}
+
+// validateDeleteOutputsURLs makes sure that every location is a directory inside an object store bucket, so that a bad
+// request can't wipe the whole bucket or delete files from the node itself
+func validateDeleteOutputsURLs(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("no output URLs to delete")
+	}
+	for _, u := range urls {
+		key, err := clients.ObjectStoreKey(u)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return fmt.Errorf("output URL %q must point to a directory inside a bucket", log.RedactURL(u))
+		}
+	}
+	return nil
+}
@@ -1,15 +1,20 @@
 package handlers
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	errors2 "errors"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/hashicorp/serf/serf"
 	"github.com/julienschmidt/httprouter"
 	"github.com/livepeer/catalyst-api/balancer"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
 	"github.com/livepeer/catalyst-api/cluster"
 	"github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/events"
+	"github.com/livepeer/catalyst-api/handlers/accesscontrol"
+	"github.com/livepeer/catalyst-api/handlers/geolocation"
 	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
 	"github.com/xeipuuv/gojsonschema"
 	"io"
@@ -22,20 +27,49 @@ type EventsHandlersCollection struct {
 	mapic mistapiconnector.IMac
 	bal   balancer.Balancer
 
+	cdnRedirects     *geolocation.CdnRedirectOverrides
+	vanityPaths      *geolocation.VanityPaths
+	recordingAutoVOD *RecordingAutoVOD
+	// nil unless the catabalancer node stats are disseminated through Serf
+	nodeStats *catabalancer.SerfNodeStats
+	// applies the JWTs blocked on any node
+	accessControl *accesscontrol.AccessControlHandlersCollection
+
 	eventsEndpoint string
 }
 
 type Event struct {
 	Resource   string `json:"resource"`
 	PlaybackID string `json:"playback_id"`
+	Path       string `json:"path,omitempty"`
+	JWT        string `json:"jwt,omitempty"`
+}
+
+// userEventName is the name of the Serf user event of an event, the events of the same name being coalesced. The
+// vanity path events are named after their path and the JWTs blocked for a playback ID are told apart by a hash of
+// the JWT.
+func (e Event) userEventName() string {
+	if e.JWT != "" {
+		hash := sha256.Sum256([]byte(e.JWT))
+		return fmt.Sprintf("%s-%s-%x", e.Resource, e.PlaybackID, hash[:8])
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("%s-%s", e.Resource, e.Path)
+	}
+	return fmt.Sprintf("%s-%s", e.Resource, e.PlaybackID)
 }
 
-func NewEventsHandlersCollection(cluster cluster.Cluster, mapic mistapiconnector.IMac, bal balancer.Balancer, eventsEndpoint string) *EventsHandlersCollection {
+func NewEventsHandlersCollection(cluster cluster.Cluster, mapic mistapiconnector.IMac, bal balancer.Balancer, cdnRedirects *geolocation.CdnRedirectOverrides, vanityPaths *geolocation.VanityPaths, recordingAutoVOD *RecordingAutoVOD, nodeStats *catabalancer.SerfNodeStats, accessControl *accesscontrol.AccessControlHandlersCollection, eventsEndpoint string) *EventsHandlersCollection {
 	return &EventsHandlersCollection{
-		cluster:        cluster,
-		mapic:          mapic,
-		bal:            bal,
-		eventsEndpoint: eventsEndpoint,
+		cluster:          cluster,
+		mapic:            mapic,
+		bal:              bal,
+		cdnRedirects:     cdnRedirects,
+		vanityPaths:      vanityPaths,
+		recordingAutoVOD: recordingAutoVOD,
+		nodeStats:        nodeStats,
+		accessControl:    accessControl,
+		eventsEndpoint:   eventsEndpoint,
 	}
 }
 
@@ -43,7 +77,17 @@ func NewEventsHandlersCollection(cluster cluster.Cluster, mapic mistapiconnector
 // Used to, e.g., refresh a stream or nuke a stream.
 // This event is then propagated to all Serf nodes and then forwarded to catalyst-api and handled by ReceiveUserEvent().
 func (d *EventsHandlersCollection) Events() httprouter.Handle {
-	schema := inputSchemasCompiled["Event"]
+	return d.broadcastEvents(inputSchemasCompiled["Event"])
+}
+
+// AdminEvents is the admin counterpart of Events(), for the events that change the playback rules of the nodes, i.e.
+// the CDN redirects, vanity paths, recording auto VOD settings and blocked JWTs. It's also where the admin endpoints
+// of an api-only node forward their events to.
+func (d *EventsHandlersCollection) AdminEvents() httprouter.Handle {
+	return d.broadcastEvents(inputSchemasCompiled["AdminEvent"])
+}
+
+func (d *EventsHandlersCollection) broadcastEvents(schema *gojsonschema.Schema) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 		payload, err := io.ReadAll(req.Body)
 		if err != nil {
@@ -66,12 +110,15 @@ func (d *EventsHandlersCollection) Events() httprouter.Handle {
 		}
 
 		err = d.cluster.BroadcastEvent(serf.UserEvent{
-			Name:     fmt.Sprintf("%s-%s", event.Resource, event.PlaybackID),
+			Name:     event.userEventName(),
 			Payload:  payload,
 			Coalesce: true,
 		})
 
-		if err != nil {
+		if errors2.Is(err, cluster.ErrUserEventTooLarge) {
+			errors.WriteHTTPBadRequest(w, "Event too large", err)
+			return
+		} else if err != nil {
 			errors.WriteHTTPInternalServerError(w, "Cannot process event", err)
 			return
 		}
@@ -102,12 +149,50 @@ func (c *EventsHandlersCollection) ReceiveUserEvent() httprouter.Handle {
 			c.mapic.RefreshStreamIfNeeded(event.PlaybackID)
 		case *events.NukeEvent:
 			glog.V(5).Infof("received serf NukeEvent: %v", event.PlaybackID)
+			// the answer to a cluster-wide nuke, ignored for the nuke events
+			_, hadStream := c.mapic.GetStreamSession(event.PlaybackID)
 			c.mapic.NukeStream(event.PlaybackID)
+			writeJSON(w, NukeAck{HadStream: hadStream})
 			return
 		case *events.StopSessionsEvent:
 			glog.V(5).Infof("received serf StopSessionsEvent: %v", event.PlaybackID)
 			c.mapic.StopSessions(event.PlaybackID)
 			return
+		case *events.CdnRedirectEvent:
+			glog.V(5).Infof("received serf CdnRedirectEvent: %v", event.PlaybackID)
+			if err := c.cdnRedirects.Set(event.PlaybackID, event.Percentage); err != nil {
+				glog.Errorf("cannot apply CDN redirect rule for playbackID=%s: %s", event.PlaybackID, err)
+			}
+			return
+		case *events.VanityPathEvent:
+			glog.V(5).Infof("received serf VanityPathEvent: %v", event.Path)
+			if err := c.vanityPaths.Set(event.Path, event.PlaybackID); err != nil {
+				glog.Errorf("cannot apply vanity path=%s: %s", event.Path, err)
+			}
+			return
+		case *events.BlockJWTEvent:
+			glog.V(5).Infof("received serf BlockJWTEvent: %v", event.PlaybackID)
+			if c.accessControl == nil {
+				return
+			}
+			if err := c.accessControl.BlockJWT(r.Context(), event.JWT); err != nil {
+				glog.Errorf("cannot block JWT of playbackID=%s: %s", event.PlaybackID, err)
+			}
+			return
+		case *events.RecordingAutoVODEvent:
+			glog.V(5).Infof("received serf RecordingAutoVODEvent: %v", event.PlaybackID)
+			if err := c.recordingAutoVOD.SetRule(event); err != nil {
+				glog.Errorf("cannot apply recording auto VOD rule for playbackID=%s: %s", event.PlaybackID, err)
+			}
+			return
+		case *events.NodeUpdateEvent:
+			if c.nodeStats == nil {
+				return
+			}
+			if err := c.nodeStats.ReceiveNodeUpdate(event.Payload); err != nil {
+				glog.Errorf("cannot apply serf node update: %s", err)
+			}
+			return
 		default:
 			glog.Errorf("unsupported serf event: %v", e)
 		}
@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/golang/mock/gomock"
 	"github.com/hashicorp/serf/serf"
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
+	"github.com/livepeer/catalyst-api/cluster"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/events"
+	mistapiconnector "github.com/livepeer/catalyst-api/mapic"
 	mockcluster "github.com/livepeer/catalyst-api/mocks/cluster"
 	mock_mistapiconnector "github.com/livepeer/catalyst-api/mocks/mistapiconnector"
 	"github.com/stretchr/testify/require"
@@ -55,6 +62,32 @@ func TestEventHandler(t *testing.T) {
 			}`,
 			wantHttpCode: 400,
 		},
+		{
+			// Vanity paths are only mapped through the admin API
+			requestBody: `{
+				"resource": "vanityPath",
+				"playback_id": "123456789",
+				"path": "my-event"
+			}`,
+			wantHttpCode: 400,
+		},
+		{
+			// The playback rules are only changed through the admin events
+			requestBody: `{
+				"resource": "cdnRedirect",
+				"playback_id": "123456789",
+				"percentage": 50
+			}`,
+			wantHttpCode: 400,
+		},
+		{
+			requestBody: `{
+				"resource": "recordingAutoVod",
+				"playback_id": "123456789",
+				"enabled": true
+			}`,
+			wantHttpCode: 400,
+		},
 	}
 
 	ctrl := gomock.NewController(t)
@@ -63,7 +96,7 @@ func TestEventHandler(t *testing.T) {
 		return nil
 	}).AnyTimes()
 
-	catalystApiHandlers := NewEventsHandlersCollection(mc, nil, nil, "")
+	catalystApiHandlers := NewEventsHandlersCollection(mc, nil, nil, nil, nil, nil, nil, nil, "")
 	router := httprouter.New()
 	router.POST("/events", catalystApiHandlers.Events())
 
@@ -76,6 +109,67 @@ func TestEventHandler(t *testing.T) {
 	}
 }
 
+func TestAdminEventHandler(t *testing.T) {
+	require := require.New(t)
+
+	tests := []struct {
+		requestBody   string
+		wantHttpCode  int
+		wantEventName string
+	}{
+		{
+			requestBody:   `{"resource": "cdnRedirect", "playback_id": "123456789", "percentage": 50}`,
+			wantHttpCode:  200,
+			wantEventName: "cdnRedirect-123456789",
+		},
+		{
+			requestBody:   `{"resource": "vanityPath", "path": "my-event", "playback_id": "123456789"}`,
+			wantHttpCode:  200,
+			wantEventName: "vanityPath-my-event",
+		},
+		{
+			requestBody:   `{"resource": "recordingAutoVod", "playback_id": "123456789", "enabled": true}`,
+			wantHttpCode:  200,
+			wantEventName: "recordingAutoVod-123456789",
+		},
+		{
+			// The stream events go through the public events endpoint
+			requestBody:  `{"resource": "stream", "playback_id": "123456789"}`,
+			wantHttpCode: 400,
+		},
+		{
+			requestBody:  `{"resource": "cdnRedirect"}`,
+			wantHttpCode: 400,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	mc := mockcluster.NewMockCluster(ctrl)
+	var eventName string
+	mc.EXPECT().BroadcastEvent(gomock.Any()).DoAndReturn(func(event serf.UserEvent) error {
+		eventName = event.Name
+		return nil
+	}).AnyTimes()
+
+	catalystApiHandlers := NewEventsHandlersCollection(mc, nil, nil, nil, nil, nil, nil, nil, "")
+	router := httprouter.New()
+	router.POST("/admin/events", catalystApiHandlers.AdminEvents())
+
+	for _, tt := range tests {
+		eventName = ""
+		req, _ := http.NewRequest("POST", "/admin/events", strings.NewReader(tt.requestBody))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		require.Equal(tt.wantHttpCode, rr.Result().StatusCode, tt.requestBody)
+		require.Equal(tt.wantEventName, eventName)
+	}
+}
+
+func TestAdminEventsEndpoint(t *testing.T) {
+	require.Equal(t, "http://127.0.0.1:8090/api/admin/events", AdminEventsEndpoint("http://127.0.0.1:8090/api/events"))
+}
+
 func TestReceiveUserEventHandler(t *testing.T) {
 	require := require.New(t)
 	playbackId := "123456789"
@@ -114,7 +208,7 @@ func TestReceiveUserEventHandler(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mac := mock_mistapiconnector.NewMockIMac(ctrl)
 
-	catalystApiHandlers := NewEventsHandlersCollection(nil, mac, nil, "")
+	catalystApiHandlers := NewEventsHandlersCollection(nil, mac, nil, nil, nil, nil, nil, nil, "")
 	router := httprouter.New()
 	router.POST("/receiveUserEvent", catalystApiHandlers.ReceiveUserEvent())
 
@@ -124,6 +218,7 @@ func TestReceiveUserEventHandler(t *testing.T) {
 			case "RefreshStreamIfNeeded":
 				mac.EXPECT().RefreshStreamIfNeeded(playbackId).Times(1)
 			case "NukeStream":
+				mac.EXPECT().GetStreamSession(playbackId).Return(mistapiconnector.StreamSession{}, true)
 				mac.EXPECT().NukeStream(playbackId).Times(1)
 			case "StopSessions":
 				mac.EXPECT().StopSessions(playbackId).Times(1)
@@ -137,3 +232,93 @@ func TestReceiveUserEventHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestReceiveNodeUpdateEvent(t *testing.T) {
+	nodeStats := catabalancer.NewSerfNodeStats(nil)
+	router := httprouter.New()
+	router.POST("/receiveUserEvent", NewEventsHandlersCollection(nil, nil, nil, nil, nil, nil, nodeStats, nil, "").ReceiveUserEvent())
+
+	req, _ := http.NewRequest("POST", "/receiveUserEvent", strings.NewReader(`{"resource":"nodeUpdate","n":"node1","nm":{"c":12.5}}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, 200, rr.Result().StatusCode)
+
+	updates, err := nodeStats.GetNodeUpdates(context.Background())
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	require.Equal(t, "node1", updates[0].NodeID)
+	require.Equal(t, 12.5, updates[0].NodeMetrics.CPUUsagePercentage)
+}
+
+func TestNukeStreamEverywhere(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := mockcluster.NewMockCluster(ctrl)
+	audit := NewStreamNukeAudit(nil)
+	router := httprouter.New()
+	router.POST("/api/admin/streams/:playbackID/nuke", NewEventsHandlersCollection(mc, nil, nil, nil, nil, nil, nil, nil, "").NukeStreamEverywhere(audit))
+	nuke := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/admin/streams/123456789/nuke", strings.NewReader(body))
+		req = req.WithContext(config.WithAPITokenID(req.Context(), "trust-and-safety"))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The playback ID must be confirmed and the nuke explained
+	require.Equal(t, 400, nuke(`{"confirm": "987654321", "reason": "abuse"}`).Code)
+	require.Equal(t, 400, nuke(`{"confirm": "123456789"}`).Code)
+
+	mc.EXPECT().Query(nukeQueryName, gomock.Any(), nukeQueryTimeout).Return(cluster.QueryResult{
+		Acks: []string{"node-a", "node-b", "node-c"},
+		Responses: map[string][]byte{
+			"node-a": []byte(`{"had_stream": true}`),
+			"node-b": []byte(`{"had_stream": false}`),
+		},
+	}, nil)
+	mc.EXPECT().MembersFiltered(cluster.MediaFilter, "alive", "").Return([]cluster.Member{{Name: "node-a"}, {Name: "node-b"}, {Name: "node-c"}}, nil)
+	rr := nuke(`{"confirm": "123456789", "reason": "abuse report 42", "requested_by": "alex"}`)
+	require.Equal(t, 200, rr.Code)
+
+	entries := audit.Entries("123456789")
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	require.Equal(t, "trust-and-safety", entry.TokenID)
+	require.Equal(t, "alex", entry.RequestedBy)
+	require.Equal(t, "abuse report 42", entry.Reason)
+	require.Equal(t, []string{"node-a", "node-b"}, entry.NukedNodes)
+	require.Equal(t, []string{"node-a"}, entry.StreamNodes)
+	require.Equal(t, []string{"node-c"}, entry.MissingNodes)
+	require.Empty(t, audit.Entries("987654321"))
+}
+
+func TestBlockJWTIsBroadcast(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := mockcluster.NewMockCluster(ctrl)
+	var broadcast []serf.UserEvent
+	mc.EXPECT().BroadcastEvent(gomock.Any()).DoAndReturn(func(event serf.UserEvent) error {
+		broadcast = append(broadcast, event)
+		return nil
+	}).Times(2)
+	router := httprouter.New()
+	router.POST("/api/access-control/blocked-jwts", NewEventsHandlersCollection(mc, nil, nil, nil, nil, nil, nil, nil, "").BlockJWT())
+	block := func(token string) int {
+		req, _ := http.NewRequest("POST", "/api/access-control/blocked-jwts", strings.NewReader(`{"jwt": "`+token+`"}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, id := range []string{"first", "second"} {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "abc", ID: id}).SignedString([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, block(token))
+
+		e, err := events.Unmarshal(broadcast[len(broadcast)-1].Payload)
+		require.NoError(t, err)
+		require.Equal(t, &events.BlockJWTEvent{Resource: "blockJWT", PlaybackID: "abc", JWT: token}, e)
+	}
+	// the blocks of the JWTs of a playback ID aren't coalesced
+	require.NotEqual(t, broadcast[0].Name, broadcast[1].Name)
+
+	require.Equal(t, http.StatusBadRequest, block("not a jwt"))
+}
@@ -0,0 +1,162 @@
+package geolocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// CdnRedirectOverrides holds CDN redirect rules that were set at runtime through the internal API. They take precedence
+// over the static -cdn-redirect-playback-ids flag, so traffic can be shifted to (or away from) the CDN without a restart.
+// A rule of 0% stops redirecting a playbackID of the static flag, removing the rule falls back to the static flag.
+type CdnRedirectOverrides struct {
+	mu    sync.RWMutex
+	path  string
+	rules map[string]float64
+	// Incremented on every change, for a sync from another node not to overwrite the changes made meanwhile
+	generation uint64
+}
+
+// NewCdnRedirectOverrides creates the runtime rule set. If path is not empty the rules are loaded from, and persisted to,
+// that file so they survive restarts.
+func NewCdnRedirectOverrides(path string) (*CdnRedirectOverrides, error) {
+	o := &CdnRedirectOverrides{
+		path:  path,
+		rules: map[string]float64{},
+	}
+	if path == "" {
+		return o, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return o, fmt.Errorf("failed to read CDN redirect overrides file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, &o.rules); err != nil {
+		return o, fmt.Errorf("failed to parse CDN redirect overrides file %s: %w", path, err)
+	}
+	glog.Infof("Loaded %d CDN redirect overrides from %s", len(o.rules), path)
+	return o, nil
+}
+
+// Get returns the runtime rule for the playbackID. The second return value is false if there's no runtime rule.
+func (o *CdnRedirectOverrides) Get(playbackID string) (float64, bool) {
+	if o == nil {
+		return 0, false
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	pct, ok := o.rules[playbackID]
+	return pct, ok
+}
+
+// Set stores the runtime rule for the playbackID and persists the full rule set. A nil percentage removes the rule.
+func (o *CdnRedirectOverrides) Set(playbackID string, percentage *float64) error {
+	if percentage != nil && (*percentage < 0 || *percentage > 100) {
+		return fmt.Errorf("invalid CDN redirect percentage %f - should be between 0.0 and 100.0", *percentage)
+	}
+	if o == nil {
+		return fmt.Errorf("CDN redirect overrides are not enabled")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if percentage == nil {
+		delete(o.rules, playbackID)
+	} else {
+		o.rules[playbackID] = *percentage
+	}
+	o.generation++
+	return o.persist()
+}
+
+// All returns a copy of the runtime rules, and the generation to replace them with Replace
+func (o *CdnRedirectOverrides) All() (map[string]float64, uint64) {
+	res := map[string]float64{}
+	if o == nil {
+		return res, 0
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for playbackID, pct := range o.rules {
+		res[playbackID] = pct
+	}
+	return res, o.generation
+}
+
+// Replace replaces all the runtime rules, unless they changed since the generation returned by All
+func (o *CdnRedirectOverrides) Replace(rules map[string]float64, generation uint64) (bool, error) {
+	if o == nil {
+		return true, nil
+	}
+	for playbackID, pct := range rules {
+		if pct < 0 || pct > 100 {
+			return false, fmt.Errorf("invalid CDN redirect percentage %f for playbackID=%s", pct, playbackID)
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.generation != generation {
+		return false, nil
+	}
+	o.rules = rules
+	o.generation++
+	return true, o.persist()
+}
+
+// Effective returns the combination of the static rules with the runtime ones applied on top
+func (o *CdnRedirectOverrides) Effective(static map[string]float64) map[string]float64 {
+	res := make(map[string]float64, len(static))
+	for playbackID, pct := range static {
+		res[playbackID] = pct
+	}
+	if o == nil {
+		return res
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for playbackID, pct := range o.rules {
+		res[playbackID] = pct
+	}
+	return res
+}
+
+// persist writes the rules to disk. Must be called with the lock held.
+func (o *CdnRedirectOverrides) persist() error {
+	if o.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(o.rules)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(o.path, b); err != nil {
+		return fmt.Errorf("failed to persist CDN redirect overrides: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file and renames it, so that a crash never leaves a partially written file
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -66,21 +66,31 @@ func (l *streamPullRateLimit) mark(playbackID string) {
 	l.pulls[playbackID] = time.Now()
 }
 
+// peerNodeResolver is implemented by the balancers that redirect to the nodes of other clusters, which aren't Serf
+// members of this one
+type peerNodeResolver interface {
+	ResolvePeerNodeURL(nodeURL string) (string, bool)
+}
+
 type GeolocationHandlersCollection struct {
 	Balancer            balancer.Balancer
 	Config              config.Cli
 	Lapi                *api.Client
 	LapiCached          *mistapiconnector.ApiClientCached
+	CdnRedirects        *CdnRedirectOverrides
+	VanityPaths         *VanityPaths
 	streamPullRateLimit *streamPullRateLimit
 	serfMembersEndpoint string
 }
 
-func NewGeolocationHandlersCollection(balancer balancer.Balancer, config config.Cli, lapi *api.Client, serfMembersEndpoint string) *GeolocationHandlersCollection {
+func NewGeolocationHandlersCollection(balancer balancer.Balancer, config config.Cli, lapi *api.Client, serfMembersEndpoint string, cdnRedirects *CdnRedirectOverrides, vanityPaths *VanityPaths) *GeolocationHandlersCollection {
 	return &GeolocationHandlersCollection{
 		Balancer:            balancer,
 		Config:              config,
 		Lapi:                lapi,
 		LapiCached:          mistapiconnector.NewApiClientCached(lapi),
+		CdnRedirects:        cdnRedirects,
+		VanityPaths:         vanityPaths,
 		streamPullRateLimit: newStreamPullRateLimit(streamSourceRetryInterval),
 		serfMembersEndpoint: serfMembersEndpoint,
 	}
@@ -95,6 +105,8 @@ func (c *GeolocationHandlersCollection) RedirectHandler() httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 		host := r.Host
 		pathType, prefix, playbackID, pathTmpl := parsePlaybackID(r.URL.Path)
+		// the streams of a tenant are named, and so balanced, with the playback IDs qualified with it
+		streamID := config.WithTenant(playbackID)
 		redirectPrefixes := c.Config.RedirectPrefixes
 		isStudioReq := false
 
@@ -117,7 +129,7 @@ func (c *GeolocationHandlersCollection) RedirectHandler() httprouter.Handle {
 		}
 
 		if c.Config.CdnRedirectPrefix != nil && (pathType == "hls" || pathType == "webrtc") {
-			cdnPercentage, toBeRedirected := c.Config.CdnRedirectPlaybackPct[playbackID]
+			cdnPercentage, toBeRedirected := c.cdnRedirectPercentage(playbackID)
 			if toBeRedirected && cdnPercentage > rand.Float64()*100 {
 				if pathType == "webrtc" {
 					// For webRTC streams on the `CdnRedirectPlaybackIDs` list we return `406`
@@ -129,7 +141,7 @@ func (c *GeolocationHandlersCollection) RedirectHandler() httprouter.Handle {
 					return
 				}
 
-				bestNode, fullPlaybackID, err := c.Balancer.GetBestNode(context.Background(), redirectPrefixes, playbackID, lat, lon, prefix, isStudioReq)
+				bestNode, fullPlaybackID, err := c.Balancer.GetBestNode(context.Background(), redirectPrefixes, streamID, lat, lon, prefix, isStudioReq)
 				if err != nil {
 					glog.Errorf("failed to find either origin or fallback server for playbackID=%s err=%s", playbackID, err)
 					w.WriteHeader(http.StatusBadGateway)
@@ -183,7 +195,7 @@ func (c *GeolocationHandlersCollection) RedirectHandler() httprouter.Handle {
 			return
 		}
 
-		bestNode, fullPlaybackID, err := c.Balancer.GetBestNode(context.Background(), redirectPrefixes, playbackID, lat, lon, prefix, isStudioReq)
+		bestNode, fullPlaybackID, err := c.Balancer.GetBestNode(context.Background(), redirectPrefixes, streamID, lat, lon, prefix, isStudioReq)
 
 		if err != nil {
 			glog.Errorf("failed to find either origin or fallback server for playbackID=%s err=%s", playbackID, err)
@@ -217,6 +229,16 @@ func (c *GeolocationHandlersCollection) RedirectHandler() httprouter.Handle {
 	}
 }
 
+// cdnRedirectPercentage returns the percentage of traffic for the playbackID to be redirected to the CDN. Rules set
+// at runtime take precedence over the ones from -cdn-redirect-playback-ids.
+func (c *GeolocationHandlersCollection) cdnRedirectPercentage(playbackID string) (float64, bool) {
+	if pct, ok := c.CdnRedirects.Get(playbackID); ok {
+		return pct, true
+	}
+	pct, ok := c.Config.CdnRedirectPlaybackPct[playbackID]
+	return pct, ok
+}
+
 // Given a dtsc:// or https:// url, resolve the proper address of the node via serf tags
 func (c *GeolocationHandlersCollection) resolveNodeURL(streamURL string) (string, error) {
 	u, err := url.Parse(streamURL)
@@ -226,6 +248,12 @@ func (c *GeolocationHandlersCollection) resolveNodeURL(streamURL string) (string
 	nodeName := u.Host
 	protocol := u.Scheme
 
+	if peers, ok := c.Balancer.(peerNodeResolver); ok {
+		if resolved, ok := peers.ResolvePeerNodeURL(streamURL); ok {
+			return resolved, nil
+		}
+	}
+
 	member, err := c.clusterMember(map[string]string{}, "alive", nodeName)
 	if err != nil {
 		return "", err
@@ -283,6 +311,11 @@ func (c *GeolocationHandlersCollection) HandleStreamSource(ctx context.Context,
 	if strings.HasPrefix(payload.StreamName, "catalyst_vod_") || strings.HasPrefix(payload.StreamName, "tr_src_") {
 		return "", nil
 	}
+	playbackID, ok := playbackIdFor(payload.StreamName)
+	if !ok {
+		// the stream of another tenant sharing Mist, left to the source it's configured with
+		return "", nil
+	}
 
 	latStr := fmt.Sprintf("%f", lat)
 	lonStr := fmt.Sprintf("%f", lon)
@@ -294,7 +327,6 @@ func (c *GeolocationHandlersCollection) HandleStreamSource(ctx context.Context,
 			return c.resolveReplicatedStream(dtscURL, payload.StreamName)
 		}
 
-		playbackID := playbackIdFor(payload.StreamName)
 		pullURL, err := c.getStreamPull(playbackID, i)
 		if err == nil || errors.Is(err, api.ErrNotExists) {
 			if pullURL == "" {
@@ -324,13 +356,13 @@ func (c *GeolocationHandlersCollection) HandleStreamSource(ctx context.Context,
 	return "push://", nil
 }
 
-func playbackIdFor(streamName string) string {
+func playbackIdFor(streamName string) (string, bool) {
 	res := streamName
 	parts := strings.Split(res, "+")
 	if len(parts) == 2 {
 		res = parts[1] // take the playbackID after the prefix e.g. 'video+'
 	}
-	return res
+	return config.SplitTenant(res)
 }
 
 func (c *GeolocationHandlersCollection) resolveReplicatedStream(dtscURL string, streamName string) (string, error) {
@@ -9,11 +9,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
+	"github.com/livepeer/catalyst-api/balancer/federation"
 	"github.com/livepeer/catalyst-api/cluster"
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/metrics"
@@ -614,3 +617,158 @@ func TestStreamPullRateLimit(t *testing.T) {
 	time.Sleep(2 * time.Second)
 	require.False(rateLimit.shouldLimit(playbackID1))
 }
+
+func TestCdnRedirectOverrides(t *testing.T) {
+	n := mockHandlers(t)
+	n.Config.NodeHost = closestNodeAddr
+	n.Config.CdnRedirectPrefix, _ = url.Parse("https://external-cdn.com/mist")
+	n.Config.CdnRedirectPlaybackPct = map[string]float64{CdnRedirectedPlaybackID: 100}
+
+	overridesFile := filepath.Join(t.TempDir(), "cdn-redirects.json")
+	overrides, err := NewCdnRedirectOverrides(overridesFile)
+	require.NoError(t, err)
+	n.CdnRedirects = overrides
+
+	// runtime rule of 0% stops redirecting the playbackID of the static config
+	zero := 0.0
+	require.NoError(t, overrides.Set(CdnRedirectedPlaybackID, &zero))
+	requireReq(t, fmt.Sprintf("/hls/%s/index.m3u8", CdnRedirectedPlaybackID)).
+		result(n).
+		hasStatus(http.StatusTemporaryRedirect).
+		hasHeader("Location", fmt.Sprintf("http://%s/hls/%s/index.m3u8", closestNodeAddr, CdnRedirectedPlaybackID))
+
+	// runtime rule adds a playbackID which isn't in the static config
+	hundred := 100.0
+	require.NoError(t, overrides.Set(playbackID, &hundred))
+	requireReq(t, fmt.Sprintf("/hls/%s/index.m3u8", playbackID)).
+		result(n).
+		hasStatus(http.StatusTemporaryRedirect).
+		hasHeader("Location", fmt.Sprintf("http://external-cdn.com/mist/hls/video+%s/index.m3u8", playbackID))
+
+	require.Equal(t, map[string]float64{CdnRedirectedPlaybackID: 0, playbackID: 100}, overrides.Effective(n.Config.CdnRedirectPlaybackPct))
+
+	// removing the runtime rule falls back to the static config
+	require.NoError(t, overrides.Set(CdnRedirectedPlaybackID, nil))
+	rules, _ := overrides.All()
+	require.Equal(t, map[string]float64{playbackID: 100}, rules)
+	require.Equal(t, map[string]float64{CdnRedirectedPlaybackID: 100, playbackID: 100}, overrides.Effective(n.Config.CdnRedirectPlaybackPct))
+
+	// rules survive a restart
+	reloaded, err := NewCdnRedirectOverrides(overridesFile)
+	require.NoError(t, err)
+	rules, _ = reloaded.All()
+	require.Equal(t, map[string]float64{playbackID: 100}, rules)
+
+	invalid := 101.0
+	require.Error(t, overrides.Set(playbackID, &invalid))
+}
+
+func TestVanityPathRedirect(t *testing.T) {
+	n := mockHandlers(t)
+	n.Config.NodeHost = closestNodeAddr
+	vanityPaths, err := NewVanityPaths(filepath.Join(t.TempDir(), "vanity.json"))
+	require.NoError(t, err)
+	n.VanityPaths = vanityPaths
+	require.NoError(t, vanityPaths.Set("My-Event", playbackID))
+
+	handle := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", VanityPathPrefix+path, nil)
+		require.NoError(t, err)
+		n.VanityPathHandler()(rr, req, httprouter.Params{{Key: "path", Value: path}})
+		return rr
+	}
+
+	// Routed like the HLS playlist of the playback ID, case-insensitively
+	rr := handle("my-event")
+	require.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	require.Equal(t, fmt.Sprintf("http://%s/hls/%s/index.m3u8", closestNodeAddr, playbackID), rr.Header().Get("Location"))
+	require.Equal(t, http.StatusTemporaryRedirect, handle("MY-EVENT").Code)
+
+	require.Equal(t, http.StatusNotFound, handle("other-event").Code)
+
+	// Survives a restart
+	reloaded, err := NewVanityPaths(vanityPaths.path)
+	require.NoError(t, err)
+	paths, _ := reloaded.All()
+	require.Equal(t, map[string]string{"my-event": playbackID}, paths)
+
+	require.NoError(t, vanityPaths.Set("my-event", ""))
+	require.Equal(t, http.StatusNotFound, handle("my-event").Code)
+
+	require.Error(t, vanityPaths.Set("../etc", playbackID))
+	require.Error(t, vanityPaths.Set("my-event", "abc/123"))
+}
+
+type federationNodes []catabalancer.ScoredNode
+
+func (n federationNodes) HealthyNodes(ctx context.Context) ([]catabalancer.ScoredNode, error) {
+	return n, nil
+}
+
+type federationMembers []cluster.Member
+
+func (m federationMembers) MembersFiltered(filter map[string]string, status, name string) ([]cluster.Member, error) {
+	return m, nil
+}
+
+func TestRedirectHandlerAcrossClusters(t *testing.T) {
+	// A cluster in Frankfurt, sharing its summary with this one
+	peer := &federation.LocalCluster{
+		Name: "eu",
+		Nodes: federationNodes{{
+			Node:        catabalancer.Node{Name: "fra-1"},
+			NodeMetrics: catabalancer.NodeMetrics{GeoLatitude: 50.11, GeoLongitude: 8.68, CPUUsagePercentage: 10},
+		}},
+		Members: federationMembers{{Name: "fra-1", Status: "alive", Tags: map[string]string{"https": "https://fra-1.example.com:8443"}}},
+	}
+	peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		summary, err := peer.Summary(r.Context())
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(w).Encode(summary))
+	}))
+	defer peerServer.Close()
+	peers, err := federation.ParsePeers(map[string]string{"eu": peerServer.URL})
+	require.NoError(t, err)
+
+	// This cluster in New York, with the local balancer of the mocked handlers
+	n := mockHandlers(t)
+	local := &federation.LocalCluster{
+		Name: "us",
+		Nodes: federationNodes{{
+			Node:        catabalancer.Node{Name: closestNodeAddr},
+			NodeMetrics: catabalancer.NodeMetrics{GeoLatitude: 40.71, GeoLongitude: -74.01, CPUUsagePercentage: 10},
+		}},
+	}
+	fed := federation.NewBalancer(n.Balancer, local, peers)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go fed.Run(ctx, time.Hour)
+	require.Eventually(t, func() bool {
+		_, ok := fed.ResolvePeerNodeURL("https://fra-1/")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	n.Balancer = fed
+
+	// A viewer in London is redirected to the address of the node in Frankfurt
+	path := fmt.Sprintf("/hls/%s/index.m3u8", playbackID)
+	requireReq(t, path).
+		withHeader("X-Latitude", "51.5").
+		withHeader("X-Longitude", "-0.1").
+		withHeader("X-Forwarded-Proto", "https").
+		result(n).
+		hasStatus(http.StatusTemporaryRedirect).
+		hasHeader("Location", getHLSURLs("https", "fra-1.example.com:8443", "")...)
+
+	// A viewer in Boston stays in this cluster
+	n.Balancer.(*federation.FederatedBalancer).LocalBalancer.(*mockbalancer.MockBalancer).EXPECT().
+		GetBestNode(context.Background(), prefixes[:], playbackID, "42.36", "-71.06", "", false).
+		Return(closestNodeAddr, fmt.Sprintf("%s+%s", prefixes[0], playbackID), nil)
+	requireReq(t, path).
+		withHeader("X-Latitude", "42.36").
+		withHeader("X-Longitude", "-71.06").
+		withHeader("X-Forwarded-Proto", "https").
+		result(n).
+		hasStatus(http.StatusTemporaryRedirect).
+		hasHeader("Location", getHLSURLs("https", closestNodeAddr, "")...)
+}
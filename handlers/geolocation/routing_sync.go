@@ -0,0 +1,157 @@
+package geolocation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RoutingSyncQueryName is the Serf query a node that joins the cluster sends another node for the playback routing
+// rules set at runtime, i.e. the CDN redirect rules and the vanity paths, which it missed the updates of while it was
+// down
+const RoutingSyncQueryName = "playbackRoutingSync"
+
+// Rough size of the JSON encoding of a rule on top of its key and value, to fill the pages of the sync
+const routingSyncEntryOverhead = 8
+
+// Prefixes of the keys of the CDN redirect rules and vanity paths in the pages of the sync
+const (
+	cdnRedirectSyncPrefix = "cdn/"
+	vanityPathSyncPrefix  = "vanity/"
+)
+
+var errRoutingRulesChanged = errors.New("routing rules changed during the sync")
+
+// RoutingRules are the playback routing rules set at runtime through the internal API and propagated to all nodes
+type RoutingRules struct {
+	CdnRedirects *CdnRedirectOverrides
+	VanityPaths  *VanityPaths
+}
+
+type routingSyncRequest struct {
+	// Key of the last rule of the previous page
+	After string `json:"after,omitempty"`
+}
+
+// routingSyncPage is a page of the rules, as large as a query response can be
+type routingSyncPage struct {
+	CdnRedirects map[string]float64 `json:"cdn_redirects,omitempty"`
+	VanityPaths  map[string]string  `json:"vanity_paths,omitempty"`
+	// Key of the last rule of the page when there are more, empty for the last page
+	Next string `json:"next,omitempty"`
+}
+
+// routingSyncEntries returns the rules by key, with the keys in order
+func (r RoutingRules) routingSyncEntries() (map[string]interface{}, []string) {
+	entries := map[string]interface{}{}
+	cdnRedirects, _ := r.CdnRedirects.All()
+	for playbackID, pct := range cdnRedirects {
+		entries[cdnRedirectSyncPrefix+playbackID] = pct
+	}
+	vanityPaths, _ := r.VanityPaths.All()
+	for path, playbackID := range vanityPaths {
+		entries[vanityPathSyncPrefix+path] = playbackID
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return entries, keys
+}
+
+func (p *routingSyncPage) add(key string, value interface{}) {
+	if playbackID, ok := strings.CutPrefix(key, cdnRedirectSyncPrefix); ok {
+		p.CdnRedirects[playbackID] = value.(float64)
+	} else if path, ok := strings.CutPrefix(key, vanityPathSyncPrefix); ok {
+		p.VanityPaths[path] = value.(string)
+	}
+}
+
+// SyncPage answers the query of a node syncing the rules with the page it asks for, of up to maxSize bytes
+func (r RoutingRules) SyncPage(query []byte, maxSize int) ([]byte, error) {
+	var req routingSyncRequest
+	if err := json.Unmarshal(query, &req); err != nil {
+		return nil, fmt.Errorf("invalid routing sync query: %w", err)
+	}
+	entries, keys := r.routingSyncEntries()
+
+	page := routingSyncPage{CdnRedirects: map[string]float64{}, VanityPaths: map[string]string{}}
+	size := len(`{"cdn_redirects":{},"vanity_paths":{},"next":""}`)
+	for _, key := range keys {
+		if key <= req.After {
+			continue
+		}
+		value, err := json.Marshal(entries[key])
+		if err != nil {
+			return nil, err
+		}
+		// Leaves room for the key in "next" too
+		entrySize := 2*len(key) + len(value) + routingSyncEntryOverhead
+		if size+entrySize > maxSize {
+			if page.Next == "" {
+				return nil, fmt.Errorf("routing rule %s too large for a page", key)
+			}
+			return json.Marshal(page)
+		}
+		size += entrySize - len(key)
+		page.add(key, entries[key])
+		page.Next = key
+	}
+	page.Next = ""
+	return json.Marshal(page)
+}
+
+// Sync replaces the rules with the ones of another node, fetching them page by page with query. Fails when the rules
+// of this node changed meanwhile, so that the changes broadcast during the sync aren't lost.
+func (r RoutingRules) Sync(query func(payload []byte) ([]byte, error)) error {
+	_, cdnRedirectsGeneration := r.CdnRedirects.All()
+	_, vanityPathsGeneration := r.VanityPaths.All()
+	cdnRedirects, vanityPaths := map[string]float64{}, map[string]string{}
+	req := routingSyncRequest{}
+	for {
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		b, err := query(payload)
+		if err != nil {
+			return err
+		}
+		var page routingSyncPage
+		if err := json.Unmarshal(b, &page); err != nil {
+			return fmt.Errorf("invalid routing sync page: %w", err)
+		}
+		for playbackID, pct := range page.CdnRedirects {
+			cdnRedirects[playbackID] = pct
+		}
+		for path, playbackID := range page.VanityPaths {
+			vanityPaths[path] = playbackID
+		}
+		if page.Next == "" {
+			break
+		}
+		if page.Next <= req.After {
+			return fmt.Errorf("routing sync page after %q doesn't move forward", req.After)
+		}
+		req.After = page.Next
+	}
+
+	replaced, err := r.CdnRedirects.Replace(cdnRedirects, cdnRedirectsGeneration)
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		return errRoutingRulesChanged
+	}
+	replaced, err = r.VanityPaths.Replace(vanityPaths, vanityPathsGeneration)
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		return errRoutingRulesChanged
+	}
+	return nil
+}
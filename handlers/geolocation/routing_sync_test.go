@@ -0,0 +1,58 @@
+package geolocation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingRulesSync(t *testing.T) {
+	source := RoutingRules{CdnRedirects: &CdnRedirectOverrides{rules: map[string]float64{}}, VanityPaths: &VanityPaths{paths: map[string]string{}}}
+	for i := 0; i < 50; i++ {
+		pct := float64(i)
+		require.NoError(t, source.CdnRedirects.Set(fmt.Sprintf("playback-%02d", i), &pct))
+		require.NoError(t, source.VanityPaths.Set(fmt.Sprintf("event-%02d", i), fmt.Sprintf("playback-%02d", i)))
+	}
+	target := RoutingRules{
+		CdnRedirects: &CdnRedirectOverrides{rules: map[string]float64{"stale": 100}},
+		VanityPaths:  &VanityPaths{paths: map[string]string{"deleted-event": "stale"}},
+	}
+
+	// Small pages for the rules to be synced in several of them
+	pages := 0
+	query := func(payload []byte) ([]byte, error) {
+		pages++
+		page, err := source.SyncPage(payload, 200)
+		require.LessOrEqual(t, len(page), 200)
+		return page, err
+	}
+	require.NoError(t, target.Sync(query))
+	require.Greater(t, pages, 10)
+	expected, _ := source.CdnRedirects.All()
+	synced, _ := target.CdnRedirects.All()
+	require.Equal(t, expected, synced)
+	expectedPaths, _ := source.VanityPaths.All()
+	syncedPaths, _ := target.VanityPaths.All()
+	require.Equal(t, expectedPaths, syncedPaths)
+
+	// The rules changed during the sync aren't overwritten
+	hundred := 100.0
+	err := target.Sync(func(payload []byte) ([]byte, error) {
+		require.NoError(t, target.CdnRedirects.Set("new", &hundred))
+		return source.SyncPage(payload, 10_000)
+	})
+	require.ErrorIs(t, err, errRoutingRulesChanged)
+	synced, _ = target.CdnRedirects.All()
+	require.Equal(t, 100.0, synced["new"])
+
+	// Nothing to sync from a node without rules
+	empty := RoutingRules{CdnRedirects: &CdnRedirectOverrides{rules: map[string]float64{}}, VanityPaths: &VanityPaths{paths: map[string]string{}}}
+	require.NoError(t, target.Sync(func(payload []byte) ([]byte, error) {
+		return empty.SyncPage(payload, 200)
+	}))
+	synced, _ = target.CdnRedirects.All()
+	require.Empty(t, synced)
+	syncedPaths, _ = target.VanityPaths.All()
+	require.Empty(t, syncedPaths)
+}
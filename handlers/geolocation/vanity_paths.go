@@ -0,0 +1,186 @@
+package geolocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/julienschmidt/httprouter"
+)
+
+// VanityPathPrefix is where the vanity paths are served, e.g. /v/my-event
+const VanityPathPrefix = "/v/"
+
+var regexpVanityPath = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+var regexpVanityPlaybackID = regexp.MustCompile(`^[\w-]+$`)
+
+// VanityPaths maps short, memorable paths to the playback IDs they play, e.g. /v/my-event for an event organizer to
+// hand out. They're set at runtime through the internal API and propagated to all nodes.
+type VanityPaths struct {
+	mu    sync.RWMutex
+	path  string
+	paths map[string]string
+	// Incremented on every change, for a sync from another node not to overwrite the changes made meanwhile
+	generation uint64
+}
+
+// NewVanityPaths creates the mapping. If path is not empty the mapping is loaded from, and persisted to, that file so
+// it survives restarts.
+func NewVanityPaths(path string) (*VanityPaths, error) {
+	v := &VanityPaths{
+		path:  path,
+		paths: map[string]string{},
+	}
+	if path == "" {
+		return v, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return v, fmt.Errorf("failed to read vanity paths file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(b, &v.paths); err != nil {
+		return v, fmt.Errorf("failed to parse vanity paths file %s: %w", path, err)
+	}
+	glog.Infof("Loaded %d vanity paths from %s", len(v.paths), path)
+	return v, nil
+}
+
+// NormalizeVanityPath returns the vanity path in the form it's stored, as paths are case-insensitive, or an error if
+// it isn't a valid one
+func NormalizeVanityPath(vanityPath string) (string, error) {
+	p := strings.ToLower(vanityPath)
+	if !regexpVanityPath.MatchString(p) {
+		return "", fmt.Errorf("invalid vanity path %q - should be up to 64 letters, digits, '-' or '_'", vanityPath)
+	}
+	return p, nil
+}
+
+// ValidateVanityPlaybackID returns an error if the playbackID can't be played through a vanity path
+func ValidateVanityPlaybackID(playbackID string) error {
+	if !regexpVanityPlaybackID.MatchString(playbackID) {
+		return fmt.Errorf("invalid playback ID %q", playbackID)
+	}
+	return nil
+}
+
+// Get returns the playback ID a vanity path is mapped to
+func (v *VanityPaths) Get(vanityPath string) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	p, err := NormalizeVanityPath(vanityPath)
+	if err != nil {
+		return "", false
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	playbackID, ok := v.paths[p]
+	return playbackID, ok
+}
+
+// All returns a copy of the whole mapping, and the generation to replace it with Replace
+func (v *VanityPaths) All() (map[string]string, uint64) {
+	res := map[string]string{}
+	if v == nil {
+		return res, 0
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for p, playbackID := range v.paths {
+		res[p] = playbackID
+	}
+	return res, v.generation
+}
+
+// Replace replaces the whole mapping, unless it changed since the generation returned by All
+func (v *VanityPaths) Replace(paths map[string]string, generation uint64) (bool, error) {
+	if v == nil {
+		return true, nil
+	}
+	normalized := make(map[string]string, len(paths))
+	for vanityPath, playbackID := range paths {
+		p, err := NormalizeVanityPath(vanityPath)
+		if err != nil {
+			return false, err
+		}
+		if err := ValidateVanityPlaybackID(playbackID); err != nil {
+			return false, err
+		}
+		normalized[p] = playbackID
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.generation != generation {
+		return false, nil
+	}
+	v.paths = normalized
+	v.generation++
+	return true, v.persist()
+}
+
+// Set maps a vanity path to a playback ID and persists the full mapping. An empty playbackID removes the vanity path.
+func (v *VanityPaths) Set(vanityPath, playbackID string) error {
+	p, err := NormalizeVanityPath(vanityPath)
+	if err != nil {
+		return err
+	}
+	if playbackID != "" {
+		if err := ValidateVanityPlaybackID(playbackID); err != nil {
+			return err
+		}
+	}
+	if v == nil {
+		return fmt.Errorf("vanity paths are not enabled")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if playbackID == "" {
+		delete(v.paths, p)
+	} else {
+		v.paths[p] = playbackID
+	}
+	v.generation++
+	return v.persist()
+}
+
+// persist writes the mapping to disk. Must be called with the lock held.
+func (v *VanityPaths) persist() error {
+	if v.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(v.paths)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(v.path, b); err != nil {
+		return fmt.Errorf("failed to persist vanity paths: %w", err)
+	}
+	return nil
+}
+
+// VanityPathHandler plays the stream or asset a vanity path is mapped to, routing the viewer like a request for its
+// HLS playlist would be, e.g. /v/my-event to the closest node's /hls/<playbackID>/index.m3u8
+func (c *GeolocationHandlersCollection) VanityPathHandler() httprouter.Handle {
+	redirect := c.RedirectHandler()
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		playbackID, ok := c.VanityPaths.Get(params.ByName("path"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.URL.Path = fmt.Sprintf("/hls/%s/index.m3u8", playbackID)
+		r.URL.RawPath = ""
+		redirect(w, r, params)
+	}
+}
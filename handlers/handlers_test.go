@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/pipeline"
 	"github.com/livepeer/go-tools/drivers"
 	"github.com/stretchr/testify/require"
@@ -72,6 +75,43 @@ func TestSuccessfulVODUploadHandler(t *testing.T) {
 	require.Greater(len(uvr.RequestID), 1) // Check that we got some value for Request ID
 }
 
+func TestVODUploadHandlerIdempotencyKeys(t *testing.T) {
+	require := require.New(t)
+
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer callbackServer.Close()
+
+	drivers.Testing = true
+	router := httprouter.New()
+	catalystApiHandlers := CatalystAPIHandlersCollection{VODEngine: pipeline.NewStubCoordinator()}
+	router.POST("/api/vod", catalystApiHandlers.UploadVOD())
+	upload := func(tokenID, source string) (int, UploadVODResponse) {
+		body := fmt.Sprintf(`{"url": %q, "callback_url": %q, "output_locations": [{"type": "object_store", "url": "memory://localhost/output.m3u8", "outputs": {"hls": "enabled"}}]}`, source, callbackServer.URL)
+		req, _ := http.NewRequestWithContext(config.WithAPITokenID(context.Background(), tokenID), "POST", "/api/vod", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, "retried")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var uvr UploadVODResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &uvr)
+		return rr.Code, uvr
+	}
+
+	status, first := upload("a", "http://localhost/input")
+	require.Equal(http.StatusOK, status)
+	status, retry := upload("a", "http://localhost/input")
+	require.Equal(http.StatusOK, status)
+	require.Equal(first.RequestID, retry.RequestID)
+
+	status, _ = upload("a", "http://localhost/other-input")
+	require.Equal(http.StatusUnprocessableEntity, status)
+
+	// The keys of another token don't collide
+	status, other := upload("b", "http://localhost/other-input")
+	require.Equal(http.StatusOK, status)
+	require.NotEqual(first.RequestID, other.RequestID)
+}
+
 func TestInvalidPayloadVODUploadHandler(t *testing.T) {
 	require := require.New(t)
 
@@ -167,5 +207,55 @@ func TestWrongContentTypeVODUploadHandler(t *testing.T) {
 	router.ServeHTTP(rr, req)
 
 	require.Equal(415, rr.Result().StatusCode)
-	require.JSONEq(rr.Body.String(), `{"error": "Requires application/json content type", "error_detail":""}`)
+	require.JSONEq(rr.Body.String(), `{"error": "Requires application/json content type", "error_detail":"", "error_code":"UNSUPPORTED_MEDIA_TYPE"}`)
+}
+
+func TestDirectUploadStartedByStorageNotification(t *testing.T) {
+	require := require.New(t)
+
+	drivers.Testing = true
+	catalystApiHandlers := CatalystAPIHandlersCollection{VODEngine: pipeline.NewStubCoordinator()}
+	router := httprouter.New()
+	router.POST("/api/vod", catalystApiHandlers.UploadVOD())
+	router.POST("/api/storage/notification", catalystApiHandlers.UploadComplete())
+
+	var jsonData = `{
+		"url": "https://storage.example.com/uploads/dir/my%20video.mp4",
+		"callback_url": "http://localhost/callback",
+		"wait_for_upload": {"timeout_secs": 60},
+		"output_locations": [
+			{
+				"type": "object_store",
+				"url": "memory://localhost/output.m3u8",
+				"outputs": {
+					"hls": "enabled"
+				}
+			}
+		]
+	}`
+	req, _ := http.NewRequest("POST", "/api/vod", bytes.NewBuffer([]byte(jsonData)))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(http.StatusOK, rr.Result().StatusCode)
+
+	var notification = `{
+		"Records": [
+			{"eventName": "s3:ObjectRemoved:Delete", "s3": {"bucket": {"name": "uploads"}, "object": {"key": "dir/my+video.mp4"}}},
+			{"eventName": "s3:ObjectCreated:Put", "s3": {"bucket": {"name": "uploads"}, "object": {"key": "dir/my+video.mp4", "size": 1024}}}
+		]
+	}`
+	req, _ = http.NewRequest("POST", "/api/storage/notification", bytes.NewBuffer([]byte(notification)))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(http.StatusBadRequest, rr.Result().StatusCode, "the host of the storage is required")
+
+	req, _ = http.NewRequest("POST", "/api/storage/notification?host=storage.example.com", bytes.NewBuffer([]byte(notification)))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(http.StatusOK, rr.Result().StatusCode)
+
+	var resp UploadCompleteResponse
+	require.NoError(json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(1, resp.JobsStarted)
 }
@@ -5,19 +5,27 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/log"
 )
 
 type HealthcheckResponse struct {
 	Status string `json:"status"`
+	// The outcome of the handshake with Mist at startup, when there was one
+	Mist *clients.MistCapabilityReport `json:"mist,omitempty"`
 }
 
 // Returns an HTTP 200 if Catalyst API and related services are running
 // Used by the load balancer to determine whether to route to a node
+// The status is "degraded" when Mist lacks features catalyst-api relies on, which doesn't stop the node from serving
 func (d *CatalystAPIHandlersCollection) Healthcheck() httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 		responseObject := HealthcheckResponse{
 			Status: "healthy",
+			Mist:   clients.GetMistCapabilityReport(),
+		}
+		if responseObject.Mist != nil && !responseObject.Mist.OK {
+			responseObject.Status = "degraded"
 		}
 
 		b, err := json.Marshal(responseObject)
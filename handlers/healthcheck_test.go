@@ -4,6 +4,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/livepeer/catalyst-api/clients"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,3 +18,19 @@ func TestItReturnsA200WithSuccessBody(t *testing.T) {
 	require.Equal(t, 200, resp.Code)
 	require.Equal(t, resp.Body.String(), `{"status":"healthy"}`)
 }
+
+func TestItReportsDegradedWhenMistLacksFeatures(t *testing.T) {
+	clients.SetMistCapabilityReport(clients.MistCapabilityReport{
+		Version:  "2.18",
+		Features: []clients.MistFeatureCheck{{Name: "push_auto_list", Supported: false}},
+	})
+	defer clients.ResetMistCapabilityReport()
+
+	handlers := CatalystAPIHandlersCollection{}
+	resp := httptest.NewRecorder()
+	handlers.Healthcheck()(resp, nil, nil)
+
+	require.Equal(t, 200, resp.Code)
+	require.Contains(t, resp.Body.String(), `"status":"degraded"`)
+	require.Contains(t, resp.Body.String(), `"features":[{"name":"push_auto_list","supported":false}]`)
+}
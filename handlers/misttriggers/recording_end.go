@@ -0,0 +1,88 @@
+package misttriggers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/errors"
+)
+
+type RecordingEndPayload struct {
+	StreamName      string
+	WrittenFile     string
+	Protocol        string
+	WrittenBytes    int64
+	WritingSecs     int64
+	StartTime       int64
+	EndTime         int64
+	MediaDurationMs int64
+	ExitReason      string
+}
+
+func ParseRecordingEndPayload(body MistTriggerBody) (RecordingEndPayload, error) {
+	lines := body.Lines()
+	// the exit reasons were added in later Mist versions
+	if len(lines) < 10 || len(lines) > 12 {
+		return RecordingEndPayload{}, fmt.Errorf("expected 10 to 12 lines in RECORDING_END payload but got %d. Payload: %s", len(lines), body)
+	}
+
+	var numbers [5]int64
+	for i, line := range []string{lines[3], lines[4], lines[5], lines[6], lines[7]} {
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return RecordingEndPayload{}, fmt.Errorf("error converting line %d to number value=%s err=%w", i+3, line, err)
+		}
+		numbers[i] = n
+	}
+
+	var exitReason string
+	if len(lines) > 10 {
+		exitReason = lines[10]
+	}
+
+	return RecordingEndPayload{
+		StreamName:      lines[0],
+		WrittenFile:     lines[1],
+		Protocol:        lines[2],
+		WrittenBytes:    numbers[0],
+		WritingSecs:     numbers[1],
+		StartTime:       numbers[2],
+		EndTime:         numbers[3],
+		MediaDurationMs: numbers[4],
+		ExitReason:      exitReason,
+	}, nil
+}
+
+// TriggerRecordingEnd responds to RECORDING_END trigger
+// This trigger is run whenever an output to file finishes writing, through either pushing or a standalone output.
+// This trigger is stream-specific and non-blocking. The payload for this trigger is multiple lines,
+// each separated by a single newline character (without an ending newline), containing data:
+//
+//	stream name (string)
+//	path to file that just finished writing (string)
+//	output protocol name (string)
+//	number of bytes written to file (integer)
+//	amount of seconds that writing took (integer)
+//	time of connection start (unix-time integer)
+//	time of connection end (unix-time integer)
+//	duration of stream media data (milliseconds, integer)
+//	first media timestamp (milliseconds, integer)
+//	last media timestamp (milliseconds, integer)
+//	machine-readable reason for exit (string, optional)
+//	human-readable reason for exit (string, optional)
+func (d *MistCallbackHandlersCollection) TriggerRecordingEnd(ctx context.Context, w http.ResponseWriter, req *http.Request, body MistTriggerBody) {
+	payload, err := ParseRecordingEndPayload(body)
+	if err != nil {
+		errors.WriteHTTPBadRequest(w, "Error parsing RECORDING_END payload", err)
+		return
+	}
+	err = d.broker.TriggerRecordingEnd(ctx, &payload)
+	if err != nil {
+		glog.Infof("Error handling RECORDING_END payload error=%q payload=%q", err, string(body))
+		errors.WriteHTTPInternalServerError(w, "Error handling RECORDING_END payload", err)
+		return
+	}
+}
@@ -0,0 +1,149 @@
+package misttriggers
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+const (
+	// Triggers of a stream waiting for a token, further ones are dropped
+	maxPendingTriggers = 100
+	// How often the buckets of quiet streams are forgotten
+	sweepInterval = 5 * time.Minute
+)
+
+// streamLimiter rate limits the non-blocking triggers of each stream with a token bucket, so that a stream generating a
+// storm of triggers (e.g. a flapping ingest) can't hold up the handling of the other streams' triggers. Triggers over
+// the limit are run in order as tokens become available. A pending trigger with the same key as a new one is replaced
+// by it, since only the latest state of e.g. a stream's buffer matters.
+type streamLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*streamBucket
+	lastSweep time.Time
+}
+
+type streamBucket struct {
+	tokens  float64
+	updated time.Time
+	pending []*pendingTrigger
+	// whether a flush of the pending triggers is scheduled
+	flushing bool
+}
+
+type pendingTrigger struct {
+	trigger string
+	key     string
+	run     func() error
+}
+
+// newStreamLimiter returns nil, i.e. no limit, when the rate isn't positive
+func newStreamLimiter(rate float64, burst int) *streamLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &streamLimiter{rate: rate, burst: math.Max(float64(burst), 1), buckets: map[string]*streamBucket{}}
+}
+
+// Do runs the trigger straight away if the stream is within its rate, returning its error, or later otherwise, logging
+// its error. An empty key never coalesces.
+func (l *streamLimiter) Do(stream, trigger, key string, run func() error) error {
+	if l == nil {
+		return run()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.sweep(now)
+	b, ok := l.buckets[stream]
+	if !ok {
+		b = &streamBucket{tokens: l.burst, updated: now}
+		l.buckets[stream] = b
+	}
+	l.refill(b, now)
+	if len(b.pending) == 0 && b.tokens >= 1 {
+		b.tokens--
+		l.mu.Unlock()
+		return run()
+	}
+	defer l.mu.Unlock()
+
+	if key != "" {
+		for _, p := range b.pending {
+			if p.key == key {
+				p.run = run
+				metrics.Metrics.MistTriggersThrottled.WithLabelValues(trigger, "coalesced").Inc()
+				return nil
+			}
+		}
+	}
+	if len(b.pending) >= maxPendingTriggers {
+		metrics.Metrics.MistTriggersThrottled.WithLabelValues(trigger, "dropped").Inc()
+		glog.Warningf("dropping %s trigger of stream %s, too many pending", trigger, stream)
+		return nil
+	}
+	b.pending = append(b.pending, &pendingTrigger{trigger: trigger, key: key, run: run})
+	metrics.Metrics.MistTriggersThrottled.WithLabelValues(trigger, "delayed").Inc()
+	if !b.flushing {
+		b.flushing = true
+		l.scheduleFlush(b)
+	}
+	return nil
+}
+
+// scheduleFlush runs the pending triggers of a bucket when its next token is available. Must be called with mu held.
+func (l *streamLimiter) scheduleFlush(b *streamBucket) {
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	time.AfterFunc(wait, func() { l.flush(b) })
+}
+
+func (l *streamLimiter) flush(b *streamBucket) {
+	l.mu.Lock()
+	l.refill(b, time.Now())
+	var ready []*pendingTrigger
+	for len(b.pending) > 0 && b.tokens >= 1 {
+		ready = append(ready, b.pending[0])
+		b.pending = b.pending[1:]
+		b.tokens--
+	}
+	if len(b.pending) > 0 {
+		l.scheduleFlush(b)
+	} else {
+		b.flushing = false
+	}
+	l.mu.Unlock()
+
+	for _, p := range ready {
+		if err := p.run(); err != nil {
+			glog.Errorf("error handling delayed %s trigger: %s", p.trigger, err)
+		}
+	}
+}
+
+func (l *streamLimiter) refill(b *streamBucket, now time.Time) {
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+}
+
+// sweep forgets the buckets that refilled completely, which is the state a new bucket starts in anyway. Must be called
+// with mu held.
+func (l *streamLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for stream, b := range l.buckets {
+		if b.flushing {
+			continue
+		}
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, stream)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package misttriggers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type triggerRecorder struct {
+	mu   sync.Mutex
+	runs []string
+}
+
+func (r *triggerRecorder) run(name string) func() error {
+	return func() error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.runs = append(r.runs, name)
+		return nil
+	}
+}
+
+func (r *triggerRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.runs...)
+}
+
+func TestStreamLimiterDelaysAndCoalescesNoisyStreams(t *testing.T) {
+	l := newStreamLimiter(20, 2)
+	r := &triggerRecorder{}
+
+	require.NoError(t, l.Do("noisy", TRIGGER_STREAM_BUFFER, TRIGGER_STREAM_BUFFER, r.run("buffer-1")))
+	require.NoError(t, l.Do("noisy", TRIGGER_STREAM_BUFFER, TRIGGER_STREAM_BUFFER, r.run("buffer-2")))
+	// over the burst, so these wait and the buffer states are coalesced into the latest one
+	require.NoError(t, l.Do("noisy", TRIGGER_STREAM_BUFFER, TRIGGER_STREAM_BUFFER, r.run("buffer-3")))
+	require.NoError(t, l.Do("noisy", TRIGGER_PUSH_END, "", r.run("push-end")))
+	require.NoError(t, l.Do("noisy", TRIGGER_STREAM_BUFFER, TRIGGER_STREAM_BUFFER, r.run("buffer-4")))
+	require.Equal(t, []string{"buffer-1", "buffer-2"}, r.get())
+
+	// other streams aren't held up
+	require.NoError(t, l.Do("quiet", TRIGGER_STREAM_BUFFER, TRIGGER_STREAM_BUFFER, r.run("quiet-buffer")))
+	require.Equal(t, []string{"buffer-1", "buffer-2", "quiet-buffer"}, r.get())
+
+	require.Eventually(t, func() bool { return len(r.get()) == 5 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"buffer-1", "buffer-2", "quiet-buffer", "buffer-4", "push-end"}, r.get())
+}
+
+func TestStreamLimiterDropsWhenTooManyPending(t *testing.T) {
+	l := newStreamLimiter(0.001, 1)
+	r := &triggerRecorder{}
+	require.NoError(t, l.Do("noisy", TRIGGER_PUSH_END, "", r.run("first")))
+	for i := 0; i < maxPendingTriggers+10; i++ {
+		require.NoError(t, l.Do("noisy", TRIGGER_PUSH_END, "", r.run("pending")))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.Len(t, l.buckets["noisy"].pending, maxPendingTriggers)
+}
+
+func TestStreamLimiterReturnsErrorsOfImmediateTriggers(t *testing.T) {
+	var l *streamLimiter
+	require.Nil(t, newStreamLimiter(0, 10))
+	require.EqualError(t, l.Do("stream", TRIGGER_PUSH_END, "", func() error { return errors.New("failed") }), "failed")
+
+	l = newStreamLimiter(1, 1)
+	require.EqualError(t, l.Do("stream", TRIGGER_PUSH_END, "", func() error { return errors.New("failed") }), "failed")
+}
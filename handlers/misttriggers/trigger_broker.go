@@ -3,10 +3,12 @@ package misttriggers
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/golang/glog"
 	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -55,17 +57,23 @@ type TriggerBroker interface {
 
 	OnStreamSource(func(context.Context, *StreamSourcePayload) (string, error))
 	TriggerStreamSource(context.Context, *StreamSourcePayload) (string, error)
+
+	OnRecordingEnd(func(context.Context, *RecordingEndPayload) error)
+	TriggerRecordingEnd(context.Context, *RecordingEndPayload) error
 }
 
 type TriggerPayload interface {
-	StreamBufferPayload | PushEndPayload | PushRewritePayload | LiveTrackListPayload | PushOutStartPayload | UserNewPayload | UserEndPayload | StreamSourcePayload
+	StreamBufferPayload | PushEndPayload | PushRewritePayload | LiveTrackListPayload | PushOutStartPayload | UserNewPayload | UserEndPayload | StreamSourcePayload | RecordingEndPayload
 }
 
 func NewTriggerBroker() TriggerBroker {
-	return &triggerBroker{}
+	return &triggerBroker{limiter: newStreamLimiter(config.MistTriggerStreamRate, config.MistTriggerStreamBurst)}
 }
 
 type triggerBroker struct {
+	// rate limits the non-blocking triggers of each stream, nil for no limit
+	limiter *streamLimiter
+
 	streamBufferFuncs  funcGroup[StreamBufferPayload]
 	pushRewriteFuncs   funcGroup[PushRewritePayload]
 	liveTrackListFuncs funcGroup[LiveTrackListPayload]
@@ -74,6 +82,7 @@ type triggerBroker struct {
 	userNewFuncs       funcGroup[UserNewPayload]
 	userEndFuncs       funcGroup[UserEndPayload]
 	streamSourceFuncs  funcGroup[StreamSourcePayload]
+	recordingEndFuncs  funcGroup[RecordingEndPayload]
 }
 
 var triggers = map[string]bool{
@@ -85,6 +94,17 @@ var triggers = map[string]bool{
 	TRIGGER_USER_NEW:        true,
 	TRIGGER_USER_END:        false,
 	TRIGGER_STREAM_SOURCE:   true,
+	TRIGGER_RECORDING_END:   false,
+}
+
+// Triggers returns the names of the triggers catalyst-api sets up in Mist, sorted
+func Triggers() []string {
+	var names []string
+	for name := range triggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func (b *triggerBroker) SetupMistTriggers(mist clients.MistAPIClient, triggerCallback string) error {
@@ -102,7 +122,11 @@ func (b *triggerBroker) OnStreamBuffer(cb func(context.Context, *StreamBufferPay
 }
 
 func (b *triggerBroker) TriggerStreamBuffer(ctx context.Context, payload *StreamBufferPayload) {
-	_, err := b.streamBufferFuncs.Trigger(ctx, payload)
+	// only the latest buffer state of a stream matters, so pending ones are replaced
+	err := b.limiter.Do(payload.StreamName, TRIGGER_STREAM_BUFFER, TRIGGER_STREAM_BUFFER, func() error {
+		_, err := b.streamBufferFuncs.Trigger(ctx, payload)
+		return err
+	})
 	if err != nil {
 		glog.Errorf("error handling STREAM_BUFFER trigger: %s", err)
 	}
@@ -121,8 +145,10 @@ func (b *triggerBroker) OnLiveTrackList(cb func(context.Context, *LiveTrackListP
 }
 
 func (b *triggerBroker) TriggerLiveTrackList(ctx context.Context, payload *LiveTrackListPayload) error {
-	_, err := b.liveTrackListFuncs.Trigger(ctx, payload)
-	return err
+	return b.limiter.Do(payload.StreamName, TRIGGER_LIVE_TRACK_LIST, TRIGGER_LIVE_TRACK_LIST, func() error {
+		_, err := b.liveTrackListFuncs.Trigger(ctx, payload)
+		return err
+	})
 }
 
 func (b *triggerBroker) OnPushOutStart(cb func(context.Context, *PushOutStartPayload) (string, error)) {
@@ -138,8 +164,10 @@ func (b *triggerBroker) OnPushEnd(cb func(context.Context, *PushEndPayload) erro
 }
 
 func (b *triggerBroker) TriggerPushEnd(ctx context.Context, payload *PushEndPayload) error {
-	_, err := b.pushEndFuncs.Trigger(ctx, payload)
-	return err
+	return b.limiter.Do(payload.StreamName, TRIGGER_PUSH_END, "", func() error {
+		_, err := b.pushEndFuncs.Trigger(ctx, payload)
+		return err
+	})
 }
 
 func (b *triggerBroker) OnUserNew(cb func(context.Context, *UserNewPayload) (bool, error)) {
@@ -169,6 +197,15 @@ func (b *triggerBroker) TriggerStreamSource(ctx context.Context, payload *Stream
 	return b.streamSourceFuncs.Trigger(ctx, payload)
 }
 
+func (b *triggerBroker) OnRecordingEnd(cb func(context.Context, *RecordingEndPayload) error) {
+	b.recordingEndFuncs.RegisterNoResponse(cb)
+}
+
+func (b *triggerBroker) TriggerRecordingEnd(ctx context.Context, payload *RecordingEndPayload) error {
+	_, err := b.recordingEndFuncs.Trigger(ctx, payload)
+	return err
+}
+
 // a funcGroup represents a collection of callback functions such that we can register new
 // callbacks in a thread-safe manner.
 type funcGroup[T TriggerPayload] struct {
@@ -2,9 +2,13 @@ package misttriggers
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
@@ -22,8 +26,21 @@ const (
 	TRIGGER_USER_NEW        = "USER_NEW"
 	TRIGGER_USER_END        = "USER_END"
 	TRIGGER_STREAM_SOURCE   = "STREAM_SOURCE"
+	TRIGGER_RECORDING_END   = "RECORDING_END"
 )
 
+// TriggerSecretParam is the query parameter of the trigger URLs registered with Mist holding the shared secret, since
+// Mist can't add headers to its trigger requests. Redacted from the access logs, see log.RedactQuery
+const TriggerSecretParam = "secret"
+
+// TriggerHandlerURL returns the URL of the trigger handler at the endpoint that Mist should send its triggers to
+func TriggerHandlerURL(endpoint, secret string) string {
+	if secret == "" {
+		return endpoint
+	}
+	return endpoint + "?" + url.Values{TriggerSecretParam: {secret}}.Encode()
+}
+
 type MistCallbackHandlersCollection struct {
 	cli    *config.Cli
 	broker TriggerBroker
@@ -39,6 +56,11 @@ func NewMistCallbackHandlersCollection(cli config.Cli, b TriggerBroker) *MistCal
 // If handler logic grows more complicated we may consider adding dispatch mechanism here.
 func (d *MistCallbackHandlersCollection) Trigger() httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		if err := d.authorizeTrigger(req); err != nil {
+			errors.WriteHTTPForbidden(w, "Trigger not allowed", err)
+			return
+		}
+
 		payload, err := io.ReadAll(req.Body)
 		if err != nil {
 			errors.WriteHTTPBadRequest(w, "Cannot read trigger payload", err)
@@ -76,6 +98,8 @@ func (d *MistCallbackHandlersCollection) Trigger() httprouter.Handle {
 			d.TriggerUserEnd(ctx, w, req, body)
 		case TRIGGER_STREAM_SOURCE:
 			d.TriggerStreamSource(ctx, w, req, body)
+		case TRIGGER_RECORDING_END:
+			d.TriggerRecordingEnd(ctx, w, req, body)
 		default:
 			errors.WriteHTTPBadRequest(w, "Unsupported X-Trigger", fmt.Errorf("unknown trigger '%s'", triggerName))
 			return
@@ -83,6 +107,36 @@ func (d *MistCallbackHandlersCollection) Trigger() httprouter.Handle {
 	}
 }
 
+// authorizeTrigger checks that the trigger comes from an allowed address and carries the shared secret, when they're
+// configured. An API token with the triggers:write scope is accepted instead of the secret, for the senders of
+// triggers that can set headers.
+func (d *MistCallbackHandlersCollection) authorizeTrigger(req *http.Request) error {
+	if len(d.cli.MistTriggerAllowedIPs) > 0 {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !slices.ContainsFunc(d.cli.MistTriggerAllowedIPs, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+			return fmt.Errorf("address %s is not allowed to send triggers", host)
+		}
+	}
+	if authHeader := req.Header.Get("Authorization"); authHeader != "" {
+		token, ok := d.cli.APITokens.Lookup(strings.TrimPrefix(authHeader, "Bearer "))
+		if !ok || !token.HasScope(config.ScopeTriggersWrite) {
+			return fmt.Errorf("invalid token or token without the %s scope", config.ScopeTriggersWrite)
+		}
+		return nil
+	}
+	if d.cli.MistTriggerSecret != "" {
+		secret := req.URL.Query().Get(TriggerSecretParam)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(d.cli.MistTriggerSecret)) != 1 {
+			return fmt.Errorf("missing or invalid trigger secret")
+		}
+	}
+	return nil
+}
+
 type MistTriggerBody string
 
 func (b MistTriggerBody) Lines() []string {
@@ -0,0 +1,87 @@
+package misttriggers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func doAuthorizedTriggerRequest(t *testing.T, cli config.Cli, target, remoteAddr string, headers ...string) (*httptest.ResponseRecorder, bool) {
+	called := false
+	broker := NewTriggerBroker()
+	broker.OnRecordingEnd(func(ctx context.Context, payload *RecordingEndPayload) error {
+		called = true
+		return nil
+	})
+	d := NewMistCallbackHandlersCollection(cli, broker)
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(string(recordingEndPayload)))
+	req.Header.Set("X-Trigger", TRIGGER_RECORDING_END)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	d.Trigger()(rr, req, nil)
+	return rr, called
+}
+
+func TestTriggerHandlerURL(t *testing.T) {
+	require.Equal(t, "http://127.0.0.1:7979/api/mist/trigger", TriggerHandlerURL("http://127.0.0.1:7979/api/mist/trigger", ""))
+	require.Equal(t, "http://127.0.0.1:7979/api/mist/trigger?secret=s3cr%2Ft", TriggerHandlerURL("http://127.0.0.1:7979/api/mist/trigger", "s3cr/t"))
+}
+
+func TestItChecksTheTriggerSecret(t *testing.T) {
+	cli := config.Cli{MistTriggerSecret: "s3cr/t"}
+
+	rr, called := doAuthorizedTriggerRequest(t, cli, TriggerHandlerURL("/api/mist/trigger", "s3cr/t"), "10.0.0.1:1234")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, called)
+
+	rr, called = doAuthorizedTriggerRequest(t, cli, TriggerHandlerURL("/api/mist/trigger", "wrong"), "10.0.0.1:1234")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.False(t, called)
+
+	rr, called = doAuthorizedTriggerRequest(t, cli, "/api/mist/trigger", "10.0.0.1:1234")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.False(t, called)
+}
+
+func TestItAcceptsATriggersTokenInsteadOfTheSecret(t *testing.T) {
+	cli := config.Cli{MistTriggerSecret: "s3cr/t", APITokens: config.APITokens{
+		{ID: "mist", Token: "mist-token", Scopes: []string{config.ScopeTriggersWrite}},
+		{ID: "vod", Token: "vod-token", Scopes: []string{config.ScopeVODWrite}},
+	}}
+
+	rr, called := doAuthorizedTriggerRequest(t, cli, "/api/mist/trigger", "10.0.0.1:1234", "Authorization", "Bearer mist-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, called)
+
+	rr, called = doAuthorizedTriggerRequest(t, cli, "/api/mist/trigger", "10.0.0.1:1234", "Authorization", "Bearer vod-token")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.False(t, called)
+
+	// an invalid token isn't made up for by the secret
+	rr, called = doAuthorizedTriggerRequest(t, cli, TriggerHandlerURL("/api/mist/trigger", "s3cr/t"), "10.0.0.1:1234", "Authorization", "Bearer wrong")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.False(t, called)
+}
+
+func TestItChecksTheTriggerSourceIP(t *testing.T) {
+	_, private, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	cli := config.Cli{MistTriggerAllowedIPs: []*net.IPNet{private}}
+
+	rr, called := doAuthorizedTriggerRequest(t, cli, "/api/mist/trigger", "10.1.2.3:1234")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, called)
+
+	rr, called = doAuthorizedTriggerRequest(t, cli, "/api/mist/trigger", "192.168.1.1:1234")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.False(t, called)
+}
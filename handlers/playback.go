@@ -6,8 +6,10 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
 	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/log"
 	"github.com/livepeer/catalyst-api/playback"
@@ -16,11 +18,14 @@ import (
 
 type PlaybackHandler struct {
 	PrivateBucketURLs []*url.URL
+	// Chooses the buckets each request is served from, all of PrivateBucketURLs in order when nil
+	BucketRouter *playback.BucketRouter
 }
 
-func NewPlaybackHandler(urls []*url.URL) *PlaybackHandler {
+func NewPlaybackHandler(urls []*url.URL, bucketRouter *playback.BucketRouter) *PlaybackHandler {
 	return &PlaybackHandler{
 		PrivateBucketURLs: urls,
+		BucketRouter:      bucketRouter,
 	}
 }
 
@@ -39,6 +44,10 @@ func (p *PlaybackHandler) Handle(w http.ResponseWriter, req *http.Request, param
 		gatingParamName = "jwt"
 		gatingParam = req.URL.Query().Get(gatingParamName)
 	}
+	if gatingParam == "" {
+		gatingParamName = playback.SignedURLParam
+		gatingParam = req.URL.Query().Get(gatingParamName)
+	}
 
 	playbackReq := playback.Request{
 		RequestID:       requestID,
@@ -47,8 +56,21 @@ func (p *PlaybackHandler) Handle(w http.ResponseWriter, req *http.Request, param
 		GatingParam:     gatingParam,
 		GatingParamName: gatingParamName,
 		Range:           req.Header.Get("range"),
+		IfNoneMatch:     req.Header.Get("if-none-match"),
+		IfModifiedSince: req.Header.Get("if-modified-since"),
+		IfRange:         req.Header.Get("if-range"),
+		Router:          p.BucketRouter,
+	}
+	tailored := config.PlaybackDeviceTailoring && playback.IsManifest(playbackReq.File)
+	if tailored {
+		device := playback.DetectDeviceCapabilities(req.Header)
+		playbackReq.Device = &device
 	}
-	response, err := playback.Handle(p.PrivateBucketURLs, playbackReq)
+	buckets := p.PrivateBucketURLs
+	if p.BucketRouter != nil {
+		buckets = p.BucketRouter.Route(playbackReq.PlaybackID)
+	}
+	response, err := playback.Handle(buckets, playbackReq)
 	if err != nil {
 		handleError(err, req, requestID, w)
 		return
@@ -56,12 +78,25 @@ func (p *PlaybackHandler) Handle(w http.ResponseWriter, req *http.Request, param
 	defer response.Body.Close()
 
 	w.Header().Set("accept-ranges", "bytes")
-	w.Header().Set("content-type", response.ContentType)
 	w.Header().Set("cache-control", "max-age=0")
+	w.Header().Set("etag", response.ETag)
+	if !response.LastModified.IsZero() {
+		w.Header().Set("last-modified", response.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if response.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("content-type", response.ContentType)
 	if response.ContentLength != nil {
 		w.Header().Set("content-length", fmt.Sprintf("%d", *response.ContentLength))
 	}
-	w.Header().Set("etag", response.ETag)
+	if tailored {
+		// the playlist depends on the device, which is told by these headers
+		hints := strings.Join(playback.DeviceClientHints, ", ")
+		w.Header().Set("vary", "User-Agent, "+hints)
+		w.Header().Set("accept-ch", hints)
+	}
 
 	if response.ContentRange != "" {
 		w.Header().Set("content-range", response.ContentRange)
@@ -81,7 +116,13 @@ func (p *PlaybackHandler) Handle(w http.ResponseWriter, req *http.Request, param
 
 func handleError(err error, req *http.Request, requestID string, w http.ResponseWriter) {
 	log.LogError(requestID, "error in playback handler", err, "url", req.URL)
+	var notSatisfiable playback.RangeNotSatisfiableError
 	switch {
+	case errors.As(err, &notSatisfiable):
+		if contentRange := notSatisfiable.ContentRange(); contentRange != "" {
+			w.Header().Set("content-range", contentRange)
+		}
+		catErrs.WriteHTTPRangeNotSatisfiable(w, "range not satisfiable", nil)
 	case catErrs.IsObjectNotFound(err):
 		catErrs.WriteHTTPNotFound(w, "not found", nil)
 	case errors.Is(err, catErrs.UnauthorisedError):
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
 	"github.com/stretchr/testify/require"
 )
 
@@ -144,3 +145,82 @@ func TestManifest(t *testing.T) {
 		})
 	}
 }
+
+func TestManifestTailoredToDevice(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	privateBucket, err := url.Parse("file://" + path.Join(wd, "../test/fixtures/playback-bucket"))
+	require.NoError(t, err)
+	config.PlaybackDeviceTailoring = true
+	defer func() { config.PlaybackDeviceTailoring = false }()
+
+	p := &PlaybackHandler{PrivateBucketURLs: []*url.URL{privateBucket}}
+	writer := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/index.m3u8?accessKey=secretlpkey", strings.NewReader(""))
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Mobile; LYF/F300B; Android; rv:48.0) Gecko/48.0 Firefox/48.0 KAIOS/2.5")
+	p.Handle(writer, req, []httprouter.Param{
+		{Key: "playbackID", Value: "dbe3q3g6q2kia036"},
+		{Key: "file", Value: "index.m3u8"},
+	})
+
+	require.Equal(t, http.StatusOK, writer.Code)
+	require.Equal(t, "User-Agent, Sec-CH-UA-Mobile, Device-Memory", writer.Header().Get("Vary"))
+	require.Equal(t, "Sec-CH-UA-Mobile, Device-Memory", writer.Header().Get("Accept-CH"))
+	body := writer.Body.String()
+	require.Contains(t, body, "360p0/index.m3u8")
+	require.NotContains(t, body, "720p0")
+}
+
+func TestPlaybackRanges(t *testing.T) {
+	bucket := t.TempDir()
+	objectDir := path.Join(bucket, "hls", "rangesplaybackid")
+	require.NoError(t, os.MkdirAll(objectDir, 0755))
+	require.NoError(t, os.WriteFile(path.Join(objectDir, "video.mp4"), []byte("0123456789"), 0644))
+	bucketURL, err := url.Parse("file://" + bucket)
+	require.NoError(t, err)
+	p := &PlaybackHandler{PrivateBucketURLs: []*url.URL{bucketURL}}
+
+	get := func(byteRange string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/video.mp4", nil)
+		require.NoError(t, err)
+		if byteRange != "" {
+			req.Header.Set("Range", byteRange)
+		}
+		p.Handle(writer, req, []httprouter.Param{{Key: "playbackID", Value: "rangesplaybackid"}, {Key: "file", Value: "video.mp4"}})
+		return writer
+	}
+
+	res := get("")
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "0123456789", res.Body.String())
+	require.Empty(t, res.Header().Get("content-range"))
+
+	res = get("bytes=2-5")
+	require.Equal(t, http.StatusPartialContent, res.Code)
+	require.Equal(t, "bytes 2-5/10", res.Header().Get("content-range"))
+	require.Equal(t, "4", res.Header().Get("content-length"))
+	require.Equal(t, "2345", res.Body.String())
+
+	res = get("bytes=-3")
+	require.Equal(t, http.StatusPartialContent, res.Code)
+	require.Equal(t, "bytes 7-9/10", res.Header().Get("content-range"))
+	require.Equal(t, "789", res.Body.String())
+
+	res = get("bytes=20-")
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.Code)
+	require.Equal(t, "bytes */10", res.Header().Get("content-range"))
+
+	// Multiple ranges are served the whole object
+	res = get("bytes=0-1,4-5")
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "0123456789", res.Body.String())
+
+	// Small ranges are served from memory once read
+	require.NoError(t, os.Remove(path.Join(objectDir, "video.mp4")))
+	res = get("bytes=2-5")
+	require.Equal(t, http.StatusPartialContent, res.Code)
+	require.Equal(t, "2345", res.Body.String())
+	require.Equal(t, http.StatusNotFound, get("bytes=0-1").Code)
+}
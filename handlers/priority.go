@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/transcode"
+)
+
+type PrioritizeJobRequest struct {
+	// Number of extra segments of the job to transcode in parallel. Defaults to -parallel-transcode-jobs, i.e.
+	// doubling the job's allocation, and is capped by -max-extra-transcode-workers across all of the job's bumps.
+	ExtraWorkers *int `json:"extra_workers,omitempty"`
+}
+
+// PrioritizeJob bumps the priority of a running transcode job, e.g. when the user starts watching the asset of a job
+// that was running in the background. The remaining segments of the job are scheduled ahead of lower priority jobs
+// by -transcode-segment-slots, which limits the segments transcoded at once on the node. When that limit is disabled no
+// segment waits, so the bump only adds workers to the job.
+func (d *CatalystAPIHandlersCollection) PrioritizeJob() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		requestID := params.ByName("requestID")
+
+		var body PrioritizeJobRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil && err != io.EOF {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		extraWorkers := config.TranscodingParallelJobs
+		if body.ExtraWorkers != nil {
+			extraWorkers = *body.ExtraWorkers
+		}
+		if extraWorkers < 0 {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", nil)
+			return
+		}
+
+		added, err := transcode.BumpPriority(requestID, transcode.PriorityHigh, extraWorkers)
+		if err != nil {
+			errors.WriteHTTPNotFound(w, "Job not found", err)
+			return
+		}
+		log.Log(requestID, "bumped transcode job priority", "extra_workers", added, "requested_extra_workers", extraWorkers, "segment_slots", config.TranscodingSegmentSlots)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -12,8 +12,12 @@ import (
 // ProxyRequest proxies a request to a target endpoint
 func ProxyRequest(targetEndpoint string) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-		// Create a new request to the target endpoint
-		proxyReq, err := http.NewRequest(req.Method, targetEndpoint, req.Body)
+		// Create a new request to the target endpoint, passing the query on, e.g. the Mist trigger secret
+		target := targetEndpoint
+		if req.URL.RawQuery != "" {
+			target += "?" + req.URL.RawQuery
+		}
+		proxyReq, err := http.NewRequest(req.Method, target, req.Body)
 		if err != nil {
 			glog.Errorf("Cannot create proxy request: %s", err)
 			errors.WriteHTTPInternalServerError(w, "Cannot create proxy request", err)
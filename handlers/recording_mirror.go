@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// MirrorRecording handles the RECORDING_END triggers, copying the recordings Mist wrote to a primary store of
+// -storage-fallback-urls to its backup store with -storage-fallback-write-through
+func MirrorRecording(ctx context.Context, payload *misttriggers.RecordingEndPayload) error {
+	if err := clients.MirrorRecording(ctx, payload.WrittenFile); err != nil {
+		// Not failing the trigger, which would cancel the other RECORDING_END handlers
+		log.LogCtx(ctx, "Failed to mirror the recording to its backup store", "recording", log.RedactURL(payload.WrittenFile), "err", err)
+	}
+	return nil
+}
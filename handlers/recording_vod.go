@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/events"
+	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/pipeline"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// The milestones of the recording VOD jobs, so that the progress of a recording being processed can be shown
+var recordingMilestones = []int{25, 50, 75}
+
+// How long the per stream settings are kept, they're sent again whenever the stream is updated
+const recordingRuleTTL = 30 * 24 * time.Hour
+
+// How long a recording VOD job can wait for a free job slot before it's given up on
+var recordingAdmissionTimeout = 1 * time.Hour
+
+// RecordingAutoVOD enqueues a VOD job for a recording as soon as Mist finishes writing it, so that its MP4s are
+// available without Studio calling /api/vod. It's enabled for every recording with -recording-auto-vod, or per stream
+// with the recordingAutoVod event of /api/admin/events.
+type RecordingAutoVOD struct {
+	vodEngine *pipeline.Coordinator
+
+	enabled           bool
+	profiles          []video.EncodedProfile
+	targetURLTemplate string
+	callbackURL       string
+
+	// Per stream settings by playback ID, shared by the nodes through the cache backend when there's one so that
+	// they're known to the nodes that join later or restart
+	rules *cache.Cache[*events.RecordingAutoVODEvent]
+}
+
+func NewRecordingAutoVOD(cli config.Cli, vodEngine *pipeline.Coordinator) (*RecordingAutoVOD, error) {
+	var profiles []video.EncodedProfile
+	if cli.RecordingVODProfiles != "" {
+		if err := json.Unmarshal([]byte(cli.RecordingVODProfiles), &profiles); err != nil {
+			return nil, fmt.Errorf("invalid -recording-auto-vod-profiles: %w", err)
+		}
+	}
+	if cli.RecordingVOD && cli.RecordingVODTargetURL == "" {
+		return nil, errors.New("-recording-auto-vod requires -recording-auto-vod-target-url")
+	}
+	return &RecordingAutoVOD{
+		vodEngine:         vodEngine,
+		enabled:           cli.RecordingVOD,
+		profiles:          profiles,
+		targetURLTemplate: cli.RecordingVODTargetURL,
+		callbackURL:       cli.RecordingVODCallbackURL,
+		rules: cache.NewWithOptions[*events.RecordingAutoVODEvent](cache.Options{
+			Name:    "recording_auto_vod",
+			TTL:     recordingRuleTTL,
+			Backend: cache.Shared,
+		}),
+	}, nil
+}
+
+// SetRule stores the per stream setting of the event's playbackID, which takes precedence over the flags. Its target
+// URL has to be in one of the stores the node is configured with, since the event comes from outside the cluster.
+func (r *RecordingAutoVOD) SetRule(event *events.RecordingAutoVODEvent) error {
+	if r == nil {
+		return errors.New("recording auto VOD is not available")
+	}
+	if event.TargetURL != "" && !r.isConfiguredStore(event.TargetURL) {
+		return fmt.Errorf("target URL %q is not in a configured store", log.RedactURL(event.TargetURL))
+	}
+	r.rules.Store(event.PlaybackID, event)
+	return nil
+}
+
+// isConfiguredStore tells whether the target URL is below the store of -recording-auto-vod-target-url or a primary
+// store of -storage-fallback-urls
+func (r *RecordingAutoVOD) isConfiguredStore(targetURL string) bool {
+	stores := []string{strings.SplitN(r.targetURLTemplate, "{", 2)[0]}
+	for primary := range config.StorageFallbackURLs {
+		stores = append(stores, primary)
+	}
+	for _, store := range stores {
+		if store != "" && strings.HasPrefix(targetURL, store) {
+			return true
+		}
+	}
+	return false
+}
+
+// settings returns whether the recordings of the playbackID get a VOD job, with which profiles and target URL template
+func (r *RecordingAutoVOD) settings(playbackID string) (bool, []video.EncodedProfile, string) {
+	enabled, profiles, targetURLTemplate := r.enabled, r.profiles, r.targetURLTemplate
+
+	if rule, ok := r.rules.Lookup(playbackID); ok {
+		enabled = rule.Enabled
+		if len(rule.Profiles) > 0 {
+			profiles = rule.Profiles
+		}
+		if rule.TargetURL != "" {
+			targetURLTemplate = rule.TargetURL
+		}
+	}
+	return enabled, profiles, targetURLTemplate
+}
+
+// HandleRecordingEnd responds to the RECORDING_END trigger
+func (r *RecordingAutoVOD) HandleRecordingEnd(ctx context.Context, payload *misttriggers.RecordingEndPayload) error {
+	playbackID, ok := config.PlaybackIDFromStreamName(payload.StreamName)
+	if !ok {
+		// the recording of another tenant sharing Mist
+		return nil
+	}
+	enabled, profiles, targetURLTemplate := r.settings(playbackID)
+	if !enabled {
+		return nil
+	}
+	ctx = log.WithLogValues(ctx, "playback_id", playbackID, "recording", log.RedactURL(payload.WrittenFile))
+	if targetURLTemplate == "" {
+		log.LogCtx(ctx, "No target URL for the recording VOD job, skipping it")
+		return nil
+	}
+	if r.vodEngine == nil {
+		return errors.New("VOD engine is not available")
+	}
+
+	if err := CheckSourceURLValid(payload.WrittenFile); err != nil {
+		return fmt.Errorf("invalid recording URL: %w", err)
+	}
+	if u, _ := url.Parse(payload.WrittenFile); u.Scheme == "" {
+		return fmt.Errorf("recording was written to a local file, cannot enqueue a VOD job for it")
+	}
+
+	requestID := config.RandomTrailer(8)
+	targetURL, err := url.Parse(strings.NewReplacer("{playback_id}", playbackID, "{request_id}", requestID).Replace(targetURLTemplate))
+	if err != nil {
+		return fmt.Errorf("invalid recording VOD target URL: %w", err)
+	}
+	log.AddContext(requestID, "source", payload.WrittenFile, "playback_id", playbackID)
+	log.LogCtx(ctx, "Enqueuing VOD job for the recording", "request_id", requestID, "target", log.RedactURL(targetURL.String()))
+
+	p := pipeline.UploadJobPayload{
+		SourceFile:            payload.WrittenFile,
+		CallbackURL:           r.callbackURL,
+		HlsTargetURL:          targetURL,
+		Mp4TargetURL:          targetURL,
+		RequestID:             requestID,
+		Profiles:              profiles,
+		TargetSegmentSizeSecs: config.DefaultSegmentSizeSecs,
+		RepairRecording:       true,
+		Milestones:            recordingMilestones,
+		Metadata: map[string]string{
+			"playback_id": playbackID,
+			"recording":   payload.WrittenFile,
+		},
+	}
+	// Enqueued under the same limits as the jobs of /api/vod, waiting for a free job slot if needed
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), recordingAdmissionTimeout)
+		defer cancel()
+		if err := r.vodEngine.StartUploadJobWhenAdmitted(ctx, p); err != nil {
+			log.LogError(requestID, "Gave up on the VOD job of the recording, no free job slot", err)
+		}
+	}()
+	return nil
+}
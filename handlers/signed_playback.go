@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/playback"
+	"github.com/livepeer/catalyst-api/requests"
+)
+
+type SignedPlaybackHandler struct {
+	Signer *playback.URLSigner
+}
+
+func NewSignedPlaybackHandler(signer *playback.URLSigner) *SignedPlaybackHandler {
+	return &SignedPlaybackHandler{Signer: signer}
+}
+
+type SignPlaybackRequest struct {
+	PlaybackID string `json:"playback_id"`
+	// File of the playback ID the URL is returned for, the master playlist by default. The token is valid for all of
+	// its files either way.
+	File string `json:"file,omitempty"`
+	// How long the URL is valid for in seconds, -playback-signed-url-ttl by default and at most
+	// -playback-signed-url-max-ttl
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+type SignPlaybackResponse struct {
+	// Path of the playback endpoint with the token, to be prefixed with the playback host
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Sign mints a signed playback URL for the playlists and segments of a playback ID
+func (h *SignedPlaybackHandler) Sign() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		requestID := requests.GetRequestId(req)
+
+		payload, err := io.ReadAll(req.Body)
+		if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Cannot read payload", err)
+			return
+		}
+		var signReq SignPlaybackRequest
+		if err := json.Unmarshal(payload, &signReq); err != nil {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if signReq.PlaybackID == "" || strings.ContainsAny(signReq.PlaybackID, "/?#") {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", errors.New("missing or invalid playback_id"))
+			return
+		}
+		file := strings.TrimPrefix(signReq.File, "/")
+		if file == "" {
+			file = "index.m3u8"
+		}
+		if path.Clean("/"+file) != "/"+file {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", errors.New("invalid file"))
+			return
+		}
+		if signReq.ExpiresIn < 0 {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", errors.New("expires_in must be positive"))
+			return
+		}
+		// Compared in seconds, as large values overflow a Duration
+		if maxExpiresIn := int64(config.PlaybackSignedURLMaxTTL / time.Second); signReq.ExpiresIn > maxExpiresIn {
+			catErrs.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("expires_in must be at most %d", maxExpiresIn))
+			return
+		}
+		ttl := config.PlaybackSignedURLTTL
+		if signReq.ExpiresIn > 0 {
+			ttl = time.Duration(signReq.ExpiresIn) * time.Second
+		}
+
+		expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+		playbackIDPath := playback.PlaybackIDPath(signReq.PlaybackID)
+		token, err := h.Signer.Token(playbackIDPath, expiresAt)
+		if err != nil {
+			catErrs.WriteHTTPInternalServerError(w, "Failed to sign the playback URL", err)
+			return
+		}
+		resp := SignPlaybackResponse{
+			URL:       playbackIDPath + file + "?" + url.Values{playback.SignedURLParam: {token}}.Encode(),
+			Token:     token,
+			ExpiresAt: expiresAt,
+		}
+		log.Log(requestID, "Signed a playback URL", "playback_id", signReq.PlaybackID, "expires_at", expiresAt)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.LogError(requestID, "failed to write response", err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/playback"
+	"github.com/livepeer/catalyst-api/requests"
+)
+
+type SourcePlaybackHandler struct {
+	Sessions *playback.SourceSessions
+}
+
+func NewSourcePlaybackHandler(sessions *playback.SourceSessions) *SourcePlaybackHandler {
+	return &SourcePlaybackHandler{Sessions: sessions}
+}
+
+type RenewSourcePlaybackRequest struct {
+	URL     string `json:"url"`
+	Session string `json:"session"`
+}
+
+type RenewSourcePlaybackResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Renew exchanges a signed source playback URL for a fresh one. The session can be passed in the body or is taken from
+// the URL when it's a renew endpoint URL from a source playlist.
+func (h *SourcePlaybackHandler) Renew(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	requestID := requests.GetRequestId(req)
+
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		catErrs.WriteHTTPInternalServerError(w, "Cannot read payload", err)
+		return
+	}
+	var renewReq RenewSourcePlaybackRequest
+	if err := json.Unmarshal(payload, &renewReq); err != nil {
+		catErrs.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		return
+	}
+	signedURL, session := renewReq.URL, renewReq.Session
+	if u, err := url.Parse(signedURL); err == nil && u.Path == playback.SourceRenewPath {
+		signedURL, session = u.Query().Get("url"), u.Query().Get("session")
+		if renewReq.Session != "" {
+			session = renewReq.Session
+		}
+	}
+
+	renewed, expiresAt, err := h.Sessions.Renew(session, signedURL)
+	if err != nil {
+		handleRenewError(err, requestID, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(RenewSourcePlaybackResponse{URL: renewed, ExpiresAt: expiresAt}); err != nil {
+		log.LogError(requestID, "failed to write response", err)
+	}
+}
+
+// RenewPlayback is what the source playlists point players at. Playlists are served with their segments signed again,
+// other files are redirected to a freshly signed URL.
+func (h *SourcePlaybackHandler) RenewPlayback(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	requestID := requests.GetRequestId(req)
+	session := req.URL.Query().Get("session")
+	signedURL := req.URL.Query().Get("url")
+
+	u, err := url.Parse(signedURL)
+	if err == nil && playback.IsManifest(path.Base(u.Path)) {
+		playlist, err := h.Sessions.RenewPlaylist(session, signedURL)
+		if err != nil {
+			handleRenewError(err, requestID, w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "max-age=0")
+		if _, err := io.Copy(w, playlist); err != nil {
+			log.LogError(requestID, "failed to write response", err)
+		}
+		return
+	}
+
+	renewed, _, err := h.Sessions.Renew(session, signedURL)
+	if err != nil {
+		handleRenewError(err, requestID, w)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=0")
+	http.Redirect(w, req, renewed, http.StatusFound)
+}
+
+func handleRenewError(err error, requestID string, w http.ResponseWriter) {
+	log.LogError(requestID, "failed to renew source playback url", err)
+	switch {
+	case errors.Is(err, catErrs.UnauthorisedError):
+		catErrs.WriteHTTPUnauthorized(w, "invalid source playback session", nil)
+	case catErrs.IsObjectNotFound(err):
+		catErrs.WriteHTTPNotFound(w, "not found", nil)
+	default:
+		catErrs.WriteHTTPInternalServerError(w, "internal server error", nil)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/cluster"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/events"
+)
+
+const (
+	// Name of the Serf query nuking a stream on every node
+	nukeQueryName = "nuke"
+	// How long the nodes have to acknowledge and nuke the stream
+	nukeQueryTimeout = 10 * time.Second
+	// How many nukes are kept in memory for GET /api/admin/nukes
+	maxNukeAuditEntries = 1000
+)
+
+// StreamNukeRequest is the body of POST /api/admin/streams/:playbackID/nuke
+type StreamNukeRequest struct {
+	// Must repeat the playback ID, to confirm which stream is nuked
+	Confirm string `json:"confirm"`
+	Reason  string `json:"reason"`
+	// Who asked for the nuke, e.g. the operator handling an abuse report. The ID of the API token is recorded too.
+	RequestedBy string `json:"requested_by,omitempty"`
+}
+
+// NukeAck is how a node answers the nuke of a stream
+type NukeAck struct {
+	// Whether the node ingested or played back the stream
+	HadStream bool `json:"had_stream"`
+}
+
+// StreamNukeAuditEntry records a cluster-wide nuke of a stream: who asked for it, when, why and which nodes acted
+type StreamNukeAuditEntry struct {
+	At          time.Time `json:"at"`
+	PlaybackID  string    `json:"playback_id"`
+	TokenID     string    `json:"token_id"`
+	RequestedBy string    `json:"requested_by,omitempty"`
+	Reason      string    `json:"reason"`
+	// Nodes that nuked the stream
+	NukedNodes []string `json:"nuked_nodes"`
+	// Nodes among those that had the stream
+	StreamNodes []string `json:"stream_nodes"`
+	// Alive nodes that didn't nuke the stream in time
+	MissingNodes []string `json:"missing_nodes"`
+}
+
+// StreamNukeAudit keeps the audit trail of the cluster-wide nukes. The latest entries are kept in memory and, with a
+// metrics DB, every entry is also written to its stream_nuke_audit table (see migrations/metrics).
+type StreamNukeAudit struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	entries []StreamNukeAuditEntry
+}
+
+func NewStreamNukeAudit(db *sql.DB) *StreamNukeAudit {
+	return &StreamNukeAudit{db: db}
+}
+
+func (a *StreamNukeAudit) record(ctx context.Context, entry StreamNukeAuditEntry) {
+	glog.Infof("Nuked stream across the cluster playbackID=%s token=%s requested_by=%q reason=%q nuked=%v missing=%v",
+		entry.PlaybackID, entry.TokenID, entry.RequestedBy, entry.Reason, entry.NukedNodes, entry.MissingNodes)
+
+	a.mu.Lock()
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > maxNukeAuditEntries {
+		a.entries = a.entries[len(a.entries)-maxNukeAuditEntries:]
+	}
+	a.mu.Unlock()
+
+	if a.db == nil {
+		return
+	}
+	nodes, _ := json.Marshal(map[string][]string{"nuked": entry.NukedNodes, "stream": entry.StreamNodes, "missing": entry.MissingNodes})
+	_, err := a.db.ExecContext(ctx,
+		`insert into "stream_nuke_audit"("at", "playback_id", "token_id", "requested_by", "reason", "nodes") values($1, $2, $3, $4, $5, $6)`,
+		entry.At, entry.PlaybackID, entry.TokenID, entry.RequestedBy, entry.Reason, string(nodes),
+	)
+	if err != nil {
+		glog.Errorf("error writing the stream nuke audit entry to the metrics DB playbackID=%s err=%v", entry.PlaybackID, err)
+	}
+}
+
+// Entries returns the nukes recorded by this node, newest first, only those of a playback ID if one is given
+func (a *StreamNukeAudit) Entries(playbackID string) []StreamNukeAuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := []StreamNukeAuditEntry{}
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if playbackID == "" || a.entries[i].PlaybackID == playbackID {
+			entries = append(entries, a.entries[i])
+		}
+	}
+	return entries
+}
+
+// NukeStreamEverywhere nukes a stream on every node of the cluster, which answer whether they had it, and records who
+// asked for it and why. Unlike the nuke events, which are fire and forget, it waits for the nodes to act and reports
+// the alive nodes that didn't.
+func (d *EventsHandlersCollection) NukeStreamEverywhere(audit *StreamNukeAudit) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		playbackID := params.ByName("playbackID")
+		var req StreamNukeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if req.Confirm != playbackID {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("confirm must repeat the playback ID"))
+			return
+		}
+		if req.Reason == "" {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("a reason is required"))
+			return
+		}
+
+		payload, err := json.Marshal(events.NewNukeEvent(playbackID))
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Cannot marshal event", err)
+			return
+		}
+		result, err := d.cluster.Query(nukeQueryName, payload, nukeQueryTimeout)
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Cannot nuke the stream across the cluster", err)
+			return
+		}
+
+		entry := StreamNukeAuditEntry{
+			At:           time.Now(),
+			PlaybackID:   playbackID,
+			TokenID:      config.APITokenID(r.Context()),
+			RequestedBy:  req.RequestedBy,
+			Reason:       req.Reason,
+			NukedNodes:   []string{},
+			StreamNodes:  []string{},
+			MissingNodes: []string{},
+		}
+		for node, resp := range result.Responses {
+			var ack NukeAck
+			if err := json.Unmarshal(resp, &ack); err != nil {
+				glog.Errorf("invalid nuke answer from node=%s err=%v", node, err)
+				continue
+			}
+			entry.NukedNodes = append(entry.NukedNodes, node)
+			if ack.HadStream {
+				entry.StreamNodes = append(entry.StreamNodes, node)
+			}
+		}
+		members, err := d.cluster.MembersFiltered(cluster.MediaFilter, "alive", "")
+		if err != nil {
+			glog.Errorf("cannot list the cluster members to find the nodes missing the nuke err=%v", err)
+		}
+		for _, m := range members {
+			if _, ok := result.Responses[m.Name]; !ok {
+				entry.MissingNodes = append(entry.MissingNodes, m.Name)
+			}
+		}
+		sort.Strings(entry.NukedNodes)
+		sort.Strings(entry.StreamNodes)
+		sort.Strings(entry.MissingNodes)
+
+		audit.record(r.Context(), entry)
+		writeJSON(w, entry)
+	}
+}
+
+// NukeAuditLog lists the cluster-wide nukes sent from this node, newest first, optionally filtered by `playback_id`
+func (d *EventsHandlersCollection) NukeAuditLog(audit *StreamNukeAudit) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJSON(w, audit.Entries(r.URL.Query().Get("playback_id")))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		errors.WriteHTTPInternalServerError(w, "Could not marshal response", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b) // nolint:errcheck
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	errors2 "errors"
 	"fmt"
@@ -39,6 +41,12 @@ type UploadVODRequestOutputLocation struct {
 	Outputs         UploadVODRequestOutputLocationOutputs `json:"outputs,omitempty"`
 }
 
+// UploadVODRequestWaitForUpload is set for direct uploads, where the source URL is still being uploaded to. The job is
+// started once the upload completes instead of straight away.
+type UploadVODRequestWaitForUpload struct {
+	TimeoutSecs int64 `json:"timeout_secs"`
+}
+
 type UploadVODRequest struct {
 	ExternalID      string                           `json:"external_id,omitempty"`
 	Url             string                           `json:"url"`
@@ -48,18 +56,52 @@ type UploadVODRequest struct {
 	TranscodeAPIUrl string                           `json:"transcodeAPIUrl"`
 	Encryption      *pipeline.EncryptionPayload      `json:"encryption,omitempty"`
 	C2PA            bool                             `json:"c2pa,omitempty"`
+	WaitForUpload   *UploadVODRequestWaitForUpload   `json:"wait_for_upload,omitempty"`
+	Metadata        map[string]string                `json:"metadata,omitempty"`
+	// How long the job may run for before it's failed, overriding the -job-deadline default
+	DeadlineSecs int64 `json:"deadline_secs,omitempty"`
 
 	// Forwarded to transcoding stage:
 	TargetSegmentSizeSecs int64                  `json:"target_segment_size_secs"`
 	Profiles              []video.EncodedProfile `json:"profiles"`
 	PipelineStrategy      pipeline.Strategy      `json:"pipeline_strategy"`
+	LadderCap             *video.LadderCap       `json:"ladder_cap,omitempty"`
+	// Overrides -rendition-naming, for consumers expecting other rendition names than the default
+	RenditionNaming video.RenditionNaming `json:"rendition_naming,omitempty"`
+	// Re-runs a previous job for some of its renditions or segments, writing to the same output locations
+	Retranscode *video.PartialRetranscode `json:"retranscode,omitempty"`
+	// Conditions the HLS outputs to be fed into server-side ad insertion systems
+	ConditionedOutput *video.ConditionedOutput `json:"conditioned_output,omitempty"`
+	// Reports the stretches of black frames and silence in the source, optionally failing the job if it's all blank
+	BlankDetection *video.BlankDetection `json:"blank_detection,omitempty"`
+	// Identifies the spoken language of the primary audio track, reported in the final callback and the HLS manifest
+	DetectLanguage bool `json:"detect_language,omitempty"`
+	// Transcodes an HLS recording with segments missing from both the primary and backup stores around the gaps,
+	// instead of failing the job
+	RepairRecording bool `json:"repair_recording,omitempty"`
+	// Checks the segments of an HLS source for corruption before transcoding, failing the job up front, leaving the
+	// corrupt segments out or only reporting them
+	SegmentIntegrity *video.SegmentIntegrityCheck `json:"segment_integrity,omitempty"`
+	// Percentages of the source segments transcoded that milestone callbacks are sent at, along with the ones for
+	// the source and renditions becoming playable
+	Milestones []int `json:"milestones,omitempty"`
 
 	// Forwarded to clipping stage:
 	ClipStrategy video.ClipStrategy `json:"clip_strategy"`
+
+	// Forwarded to the MP4 and clipping stages:
+	TrackSelection video.TrackSelection `json:"track_selection"`
 }
 
+// IdempotencyKeyHeader deduplicates the retries of a /api/vod request, see pipeline.Coordinator.ClaimIdempotencyKey
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 256
+
 type UploadVODResponse struct {
 	RequestID string `json:"request_id"`
+	// Status of the existing job when the request was deduplicated by its Idempotency-Key
+	Status string `json:"status,omitempty"`
 }
 
 func HasContentType(r *http.Request, mimetype string) bool {
@@ -197,13 +239,16 @@ func (d *CatalystAPIHandlersCollection) handleUploadVOD(w http.ResponseWriter, r
 
 	if !HasContentType(req, "application/json") {
 		return false, errors.WriteHTTPUnsupportedMediaType(w, "Requires application/json content type", nil)
-	} else if payload, err := io.ReadAll(req.Body); err != nil {
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
 		return false, errors.WriteHTTPInternalServerError(w, "Cannot read payload", err)
-	} else if result, err := schema.Validate(gojsonschema.NewBytesLoader(payload)); err != nil {
+	}
+	if result, err := schema.Validate(gojsonschema.NewBytesLoader(body)); err != nil {
 		return false, errors.WriteHTTPInternalServerError(w, "Cannot validate payload", err)
 	} else if !result.Valid() {
 		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("%s", result.Errors()))
-	} else if err := json.Unmarshal(payload, &uploadVODRequest); err != nil {
+	} else if err := json.Unmarshal(body, &uploadVODRequest); err != nil {
 		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
 	}
 
@@ -215,8 +260,88 @@ func (d *CatalystAPIHandlersCollection) handleUploadVOD(w http.ResponseWriter, r
 		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
 	}
 
+	idempotencyKey := req.Header.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return false, errors.WriteHTTPBadRequest(w, "Invalid Idempotency-Key", fmt.Errorf("longer than %d characters", maxIdempotencyKeyLength))
+	}
+
+	// The source of a direct upload doesn't exist yet, so there's nothing to check
+	if uploadVODRequest.WaitForUpload == nil {
+		var preflightErr clients.PreflightError
+		if err := clients.PreflightCheck(req.Context(), requestID, uploadVODRequest.Url); errors2.As(err, &preflightErr) && preflightErr.IsFinal() {
+			return false, errors.WriteHTTPBadRequest(w, "Source URL is not accessible", err)
+		}
+	}
+
+	if uploadVODRequest.DetectLanguage && d.VODEngine.LanguageDetector == nil {
+		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("language detection is not configured"))
+	}
+
+	if uploadVODRequest.Encryption != nil {
+		if _, err := d.VODEngine.VodDecryptKeys.Get(uploadVODRequest.Encryption.KeyID); err != nil {
+			return false, errors.WriteHTTPBadRequest(w, "Invalid encryption", err)
+		}
+	}
+
+	payload, ok, apiError := newUploadJobPayload(w, uploadVODRequest, requestID)
+	if !ok {
+		return false, apiError
+	}
+	if idempotencyKey != "" {
+		// Scoped by API token so that callers can't collide with each other's keys
+		idempotencyKey = config.APITokenID(req.Context()) + "/" + idempotencyKey
+	}
+	payload.IdempotencyKey = idempotencyKey
+
+	log.Log(requestID, "Received VOD Upload request", "pipeline_strategy", payload.PipelineStrategy, "num_profiles", len(payload.Profiles), "hlsTargetURL", payload.HlsTargetURL)
+
+	var uploadTimeout time.Duration
+	if uploadVODRequest.WaitForUpload != nil {
+		uploadTimeout = time.Duration(uploadVODRequest.WaitForUpload.TimeoutSecs) * time.Second
+		if uploadTimeout <= 0 {
+			uploadTimeout = config.DefaultDirectUploadTimeout
+		}
+	}
+
+	if idempotencyKey != "" {
+		bodyHash := sha256.Sum256(body)
+		// The job may wait for its upload before its deadline starts
+		deadline := payload.Deadline
+		if deadline <= 0 {
+			deadline = config.DefaultJobDeadline
+		}
+		if deadline > 0 {
+			deadline += uploadTimeout
+		}
+		existing, claimed, err := d.VODEngine.ClaimIdempotencyKey(idempotencyKey, hex.EncodeToString(bodyHash[:]), requestID, deadline)
+		if errors2.Is(err, pipeline.ErrIdempotencyKeyReused) {
+			return false, errors.WriteHTTPUnprocessableEntity(w, "Invalid Idempotency-Key", err)
+		}
+		if !claimed {
+			log.Log(requestID, "Deduplicated VOD Upload request by its Idempotency-Key", "existing_request_id", existing.RequestID, "existing_status", existing.Status)
+			return writeUploadVODResponse(w, UploadVODResponse{RequestID: existing.RequestID, Status: existing.Status})
+		}
+	}
+
+	// Once we're happy with the request, do the rest of the Segmenting stage asynchronously to allow us to
+	// from the API call and free up the HTTP connection
+	if uploadVODRequest.WaitForUpload != nil {
+		if err := d.VODEngine.StartUploadJobWhenUploaded(payload, uploadTimeout); err != nil {
+			d.VODEngine.ReleaseIdempotencyKey(idempotencyKey, requestID)
+			return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		}
+	} else {
+		d.VODEngine.StartUploadJob(payload)
+	}
+
+	return writeUploadVODResponse(w, UploadVODResponse{RequestID: requestID})
+}
+
+// newUploadJobPayload validates the options of the request and resolves its output locations into the payload of
+// its job, checking that they can be written to
+func newUploadJobPayload(w http.ResponseWriter, uploadVODRequest UploadVODRequest, requestID string) (pipeline.UploadJobPayload, bool, errors.APIError) {
 	if !uploadVODRequest.IsProfileValid() {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("invalid transcode profile requested"))
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("invalid transcode profile requested"))
 	}
 
 	// If the segment size isn't being overridden then use the default
@@ -234,7 +359,7 @@ func (d *CatalystAPIHandlersCollection) handleUploadVOD(w http.ResponseWriter, r
 	var err error
 	if uploadVODRequest.IsClippingRequest() {
 		if err := uploadVODRequest.ValidateClippingRequest(); err != nil {
-			return false, errors.WriteHTTPBadRequest(w, "Invalid Clipping Request", err)
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid Clipping Request", err)
 		}
 
 		clipTargetOutput := uploadVODRequest.getTargetOutput(func(o UploadVODRequestOutputLocationOutputs) string {
@@ -242,10 +367,10 @@ func (d *CatalystAPIHandlersCollection) handleUploadVOD(w http.ResponseWriter, r
 		})
 		clipTargetURL, err = toTargetURL(clipTargetOutput, requestID)
 		if err != nil {
-			return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
 		}
 		if clipTargetURL == nil {
-			return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("clip output location not specified"))
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("clip output location not specified"))
 		}
 		uploadVODRequest.ClipStrategy.Enabled = true
 	}
@@ -256,45 +381,99 @@ func (d *CatalystAPIHandlersCollection) handleUploadVOD(w http.ResponseWriter, r
 	})
 	hlsTargetURL, err := toTargetURL(hlsTargetOutput, requestID)
 	if err != nil {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
 	}
 	mp4TargetOutput, mp4OnlyShort := uploadVODRequest.getTargetMp4Output()
 	mp4TargetURL, err := toTargetURL(mp4TargetOutput, requestID)
 	if err != nil {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
 	}
 	fragMp4TargetOutput := uploadVODRequest.getTargetOutput(func(o UploadVODRequestOutputLocationOutputs) string {
 		return o.FragmentedMP4
 	})
 	fragMp4TargetURL, err := toTargetURL(fragMp4TargetOutput, requestID)
 	if err != nil {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
 	}
 	if hlsTargetURL == nil && mp4TargetURL == nil && fragMp4TargetURL == nil {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("none of output enabled: hls or mp4 or f-mp4"))
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("none of output enabled: hls or mp4 or f-mp4"))
 	}
 	thumbsTargetOutput := uploadVODRequest.getTargetOutput(func(o UploadVODRequestOutputLocationOutputs) string {
 		return o.Thumbnails
 	})
 	thumbsTargetURL, err := toTargetURL(thumbsTargetOutput, requestID)
 	if err != nil {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+
+	if err := uploadVODRequest.TrackSelection.Validate(); err != nil {
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	if err := uploadVODRequest.LadderCap.Validate(); err != nil {
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	if err := uploadVODRequest.RenditionNaming.Validate(); err != nil {
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	if err := uploadVODRequest.Retranscode.Validate(); err != nil {
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	if uploadVODRequest.Retranscode != nil {
+		// Only the FFMPEG pipeline can reuse the outputs of the previous job
+		switch uploadVODRequest.PipelineStrategy {
+		case "", pipeline.StrategyCatalystFfmpegDominance:
+			uploadVODRequest.PipelineStrategy = pipeline.StrategyCatalystFfmpegDominance
+		default:
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("pipeline strategy %q can't re-transcode part of a job", uploadVODRequest.PipelineStrategy))
+		}
+		if uploadVODRequest.ClipStrategy.Enabled {
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("clips can't be partially re-transcoded"))
+		}
+	}
+
+	if err := uploadVODRequest.ConditionedOutput.Validate(); err != nil {
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	if uploadVODRequest.ConditionedOutput != nil {
+		// Only the FFMPEG pipeline cuts the segments itself
+		switch uploadVODRequest.PipelineStrategy {
+		case "", pipeline.StrategyCatalystFfmpegDominance:
+			uploadVODRequest.PipelineStrategy = pipeline.StrategyCatalystFfmpegDominance
+		default:
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("pipeline strategy %q can't condition the outputs", uploadVODRequest.PipelineStrategy))
+		}
+		if uploadVODRequest.ClipStrategy.Enabled {
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("clips can't be conditioned"))
+		}
+	}
+
+	if err := uploadVODRequest.BlankDetection.Validate(); err != nil {
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+	}
+	if uploadVODRequest.SegmentIntegrity != nil {
+		if err := uploadVODRequest.SegmentIntegrity.Validate(); err != nil {
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+		}
+		if uploadVODRequest.ClipStrategy.Enabled {
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", errors2.New("the segments of clips can't be checked"))
+		}
 	}
 
 	// Verify pipeline strategy
 	if strat := uploadVODRequest.PipelineStrategy; strat != "" && !strat.IsValid() {
-		return false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("invalid value provided for pipeline strategy: %q", uploadVODRequest.PipelineStrategy))
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("invalid value provided for pipeline strategy: %q", uploadVODRequest.PipelineStrategy))
 	}
 
-	if err = checkWritePermission(requestID, uploadVODRequest.ExternalID, hlsTargetURL, mp4TargetURL, fragMp4TargetURL, clipTargetURL, thumbsTargetURL); err != nil {
-		return false, errors.WriteHTTPInternalServerError(w, "Internal error", err)
+	if problem, err := checkWritePermission(requestID, uploadVODRequest.ExternalID, hlsTargetURL, mp4TargetURL, fragMp4TargetURL, clipTargetURL, thumbsTargetURL); err != nil {
+		switch problem {
+		case clients.StorageProblemUnreachable, clients.StorageProblemUnknown:
+			return pipeline.UploadJobPayload{}, false, errors.WriteHTTPInternalServerError(w, "Internal error", err)
+		}
+		// The output location is misconfigured, which retrying won't fix
+		return pipeline.UploadJobPayload{}, false, errors.WriteHTTPBadRequest(w, "Output location is not writable", err)
 	}
 
-	log.Log(requestID, "Received VOD Upload request", "pipeline_strategy", uploadVODRequest.PipelineStrategy, "num_profiles", len(uploadVODRequest.Profiles), "hlsTargetURL", hlsTargetURL)
-
-	// Once we're happy with the request, do the rest of the Segmenting stage asynchronously to allow us to
-	// from the API call and free up the HTTP connection
-	d.VODEngine.StartUploadJob(pipeline.UploadJobPayload{
+	return pipeline.UploadJobPayload{
 		SourceFile:            uploadVODRequest.Url,
 		CallbackURL:           uploadVODRequest.CallbackUrl,
 		HlsTargetURL:          hlsTargetURL,
@@ -310,13 +489,28 @@ func (d *CatalystAPIHandlersCollection) handleUploadVOD(w http.ResponseWriter, r
 		Profiles:              uploadVODRequest.Profiles,
 		PipelineStrategy:      uploadVODRequest.PipelineStrategy,
 		TargetSegmentSizeSecs: uploadVODRequest.TargetSegmentSizeSecs,
+		LadderCap:             uploadVODRequest.LadderCap,
+		RenditionNaming:       uploadVODRequest.RenditionNaming,
+		Retranscode:           uploadVODRequest.Retranscode,
+		ConditionedOutput:     uploadVODRequest.ConditionedOutput,
+		BlankDetection:        uploadVODRequest.BlankDetection,
+		DetectLanguage:        uploadVODRequest.DetectLanguage,
+		RepairRecording:       uploadVODRequest.RepairRecording,
+		SegmentIntegrity:      uploadVODRequest.SegmentIntegrity,
+		Milestones:            uploadVODRequest.Milestones,
 		Encryption:            uploadVODRequest.Encryption,
 		SourceCopy:            uploadVODRequest.getSourceCopyEnabled(),
 		ClipStrategy:          uploadVODRequest.ClipStrategy,
 		C2PA:                  uploadVODRequest.C2PA,
-	})
+		Metadata:              uploadVODRequest.Metadata,
+		TrackSelection:        uploadVODRequest.TrackSelection,
+		Deadline:              time.Duration(uploadVODRequest.DeadlineSecs) * time.Second,
+	}, true, errors.APIError{}
+}
 
-	respBytes, err := json.Marshal(UploadVODResponse{RequestID: requestID})
+func writeUploadVODResponse(w http.ResponseWriter, resp UploadVODResponse) (bool, errors.APIError) {
+	requestID := resp.RequestID
+	respBytes, err := json.Marshal(resp)
 	if err != nil {
 		log.LogError(requestID, "Failed to build a /upload HTTP API response", err)
 		return false, errors.WriteHTTPInternalServerError(w, "Failed marshaling response", err)
@@ -349,7 +543,9 @@ func toTargetURL(ol UploadVODRequestOutputLocation, reqID string) (*url.URL, err
 	return nil, nil
 }
 
-func checkWritePermission(reqID, externalID string, urls ...*url.URL) error {
+// checkWritePermission writes the job's metadata to its output locations, returning what's wrong with the first
+// location that can't be written to
+func checkWritePermission(reqID, externalID string, urls ...*url.URL) (clients.StorageProblem, error) {
 	// we don't want to re-check the same locations so track them with this map
 	alreadyChecked := make(map[string]bool)
 
@@ -362,12 +558,13 @@ func checkWritePermission(reqID, externalID string, urls ...*url.URL) error {
 		// check write permission by uploading a file
 		err := clients.UploadToOSURL(u.String(), "metadata.json", strings.NewReader(fmt.Sprintf(`{"external_id": "%s"}`, externalID)), 30*time.Second)
 		if err != nil {
-			log.LogError(reqID, "failed write permission check", err, "url", log.RedactURL(urlString))
-			return fmt.Errorf("failed write permission check for %s", log.RedactURL(urlString))
+			problem := clients.ClassifyStorageProblem(err)
+			log.LogError(reqID, "failed write permission check", err, "url", log.RedactURL(urlString), "problem", problem)
+			return problem, fmt.Errorf("failed write permission check for %s: %s", log.RedactURL(urlString), problem)
 		}
 		alreadyChecked[urlString] = true
 	}
-	return nil
+	return "", nil
 }
 
 func CheckSourceURLValid(sourceURL string) error {
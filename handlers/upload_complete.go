@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// StorageEventNotification is the body of the S3 event notifications, which other S3 compatible storage providers
+// (e.g. MinIO, R2) also send in the same format
+type StorageEventNotification struct {
+	Records []StorageEventRecord `json:"Records"`
+}
+
+type StorageEventRecord struct {
+	EventName string `json:"eventName"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key  string `json:"key"`
+			Size int64  `json:"size"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+type UploadCompleteResponse struct {
+	JobsStarted int `json:"jobs_started"`
+}
+
+// UploadComplete receives the storage event notifications for direct uploads and starts the jobs that were waiting for
+// the uploaded objects. The notifications don't name the storage, so the host of its endpoint is given with the host
+// query parameter, e.g. ?host=storage.example.com. They're received by any node with -redis-url and only by the node
+// the jobs were submitted to otherwise. Jobs that don't get a notification are started once polling finds their source
+// instead.
+func (d *CatalystAPIHandlersCollection) UploadComplete() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		host := req.URL.Query().Get("host")
+		if host == "" {
+			errors.WriteHTTPBadRequest(w, "Missing host query parameter", fmt.Errorf("the host of the storage endpoint is needed to tell its buckets apart"))
+			return
+		}
+		var notification StorageEventNotification
+		if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+
+		var resp UploadCompleteResponse
+		for _, record := range notification.Records {
+			// e.g. s3:ObjectCreated:Put or s3:ObjectCreated:CompleteMultipartUpload
+			if !strings.Contains(record.EventName, "ObjectCreated:") {
+				continue
+			}
+			// Keys are URL encoded in the notifications
+			key, err := url.QueryUnescape(record.S3.Object.Key)
+			if err != nil {
+				errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("invalid object key %q: %w", record.S3.Object.Key, err))
+				return
+			}
+			log.LogNoRequestID("received object created notification", "host", host, "bucket", record.S3.Bucket.Name, "key", key, "size", record.S3.Object.Size)
+			resp.JobsStarted += d.VODEngine.NotifyObjectCreated(host, record.S3.Bucket.Name, key)
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.LogNoRequestID("failed to write upload complete response", "err", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// Most output locations a job can have, i.e. HLS, MP4, fragmented MP4, clip and thumbnails
+const maxValidateStorageURLs = 5
+
+type ValidateStorageRequest struct {
+	// The target URLs of a job, e.g. its HLS and MP4 output locations
+	URLs []string `json:"urls"`
+}
+
+type ValidateStorageResponse struct {
+	OK     bool                   `json:"ok"`
+	Checks []clients.StorageCheck `json:"checks"`
+}
+
+// ValidateStorage checks that the given output locations can be written to, e.g. for a user to verify the
+// credentials of their own bucket before submitting jobs. A test object is written to and deleted from every location,
+// and what went wrong is reported for each of them.
+func (d *CatalystAPIHandlersCollection) ValidateStorage() httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		var body ValidateStorageRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if len(body.URLs) == 0 || len(body.URLs) > maxValidateStorageURLs {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", fmt.Errorf("between 1 and %d URLs must be given", maxValidateStorageURLs))
+			return
+		}
+
+		requestID := config.RandomTrailer(8)
+		resp := ValidateStorageResponse{OK: true}
+		for _, u := range body.URLs {
+			check := clients.CheckStorage(req.Context(), requestID, u)
+			resp.OK = resp.OK && check.OK
+			resp.Checks = append(resp.Checks, check)
+		}
+		log.Log(requestID, "validated storage", "locations", len(body.URLs), "ok", resp.OK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.LogError(requestID, "Failed to write a validate storage HTTP API response", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/events"
+	"github.com/livepeer/catalyst-api/handlers/geolocation"
+)
+
+type VanityPathRequest struct {
+	PlaybackID string `json:"playback_id"`
+}
+
+// VanityPaths returns the vanity paths known to this node and the playback IDs they're mapped to
+func (d *EventsHandlersCollection) VanityPaths() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		paths, _ := d.vanityPaths.All()
+		b, err := json.Marshal(paths)
+		if err != nil {
+			errors.WriteHTTPInternalServerError(w, "Could not marshal vanity paths", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b) // nolint:errcheck
+	}
+}
+
+// SetVanityPath maps a vanity path to a playback ID on all nodes, e.g. "my-event" to serve /v/my-event
+func (d *EventsHandlersCollection) SetVanityPath() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		path, err := geolocation.NormalizeVanityPath(params.ByName("path"))
+		if err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid vanity path", err)
+			return
+		}
+		var body VanityPathRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		if err := geolocation.ValidateVanityPlaybackID(body.PlaybackID); err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid request payload", err)
+			return
+		}
+		event := events.NewVanityPathEvent(path, body.PlaybackID)
+		d.propagateEvent(w, r, fmt.Sprintf("%s-%s", event.Resource, event.Path), event)
+	}
+}
+
+// DeleteVanityPath removes a vanity path on all nodes
+func (d *EventsHandlersCollection) DeleteVanityPath() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		path, err := geolocation.NormalizeVanityPath(params.ByName("path"))
+		if err != nil {
+			errors.WriteHTTPBadRequest(w, "Invalid vanity path", err)
+			return
+		}
+		event := events.NewVanityPathEvent(path, "")
+		d.propagateEvent(w, r, fmt.Sprintf("%s-%s", event.Resource, event.Path), event)
+	}
+}
@@ -0,0 +1,70 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// captures holds the files the log lines of request IDs are teed into, by request ID, see StartCapture
+var captures sync.Map
+
+type capture struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// StartCapture tees the lines logged for the request ID into the file at path, appending to it, until StopCapture is
+// called. The credentials of URLs are redacted, see RedactLine.
+func StartCapture(requestID, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the log capture file: %w", err)
+	}
+	if previous, loaded := captures.Swap(requestID, &capture{f: f}); loaded {
+		previous.(*capture).close()
+	}
+	return nil
+}
+
+// StopCapture stops teeing the lines logged for the request ID and closes its capture file
+func StopCapture(requestID string) error {
+	c, loaded := captures.LoadAndDelete(requestID)
+	if !loaded {
+		return nil
+	}
+	return c.(*capture).close()
+}
+
+func (c *capture) write(line []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f != nil {
+		// A capture failing to be written mustn't fail the logging itself
+		_, _ = c.f.Write(line)
+	}
+}
+
+func (c *capture) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f = nil
+	return err
+}
+
+// captureWriter writes the log lines of a request to its capture, if there's one at the time. Loggers are cached for
+// longer than a capture, so it's looked up on each line.
+type captureWriter struct {
+	requestID string
+}
+
+func (w captureWriter) Write(p []byte) (int, error) {
+	if c, found := captures.Load(w.requestID); found {
+		c.(*capture).write([]byte(RedactLine(string(p))))
+	}
+	return len(p), nil
+}
@@ -71,16 +71,28 @@ func WithLogValues(ctx context.Context, args ...string) context.Context {
 	return context.WithValue(ctx, clogContextKey, newMetadata)
 }
 
+// WithRequestID returns a context carrying the request ID, for logging and for the outgoing HTTP calls made with it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return WithLogValues(ctx, "request_id", requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by the context, empty if there's none
+func RequestIDFromContext(ctx context.Context) string {
+	meta, _ := ctx.Value(clogContextKey).(metadata)
+	requestID, _ := meta["request_id"].(string)
+	return requestID
+}
+
 // Actual log handler; the others have wrappers to properly handle stack depth
 func (v *VerboseLogger) logCtx(ctx context.Context, message string, args ...any) {
 	if !glog.V(v.level) {
 		return
 	}
-	var requestID string
+	requestID := RequestIDFromContext(ctx)
 	meta, _ := ctx.Value(clogContextKey).(metadata)
-	if meta != nil {
-		requestID, _ = meta["request_id"].(string)
-	}
 	allArgs := append([]any{}, meta.Flat()...)
 	allArgs = append(allArgs, args...)
 	allArgs = append(allArgs, "caller", caller(3))
@@ -4,6 +4,8 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -50,7 +52,7 @@ func getLogger(requestID string) kitlog.Logger {
 		return logger.(kitlog.Logger)
 	}
 
-	newLogger := kitlog.With(newLogger(), "request_id", requestID)
+	newLogger := kitlog.With(newLoggerTo(io.MultiWriter(logDestination, getRecentLines(requestID), captureWriter{requestID})), "request_id", requestID)
 	err := loggerCache.Add(requestID, newLogger, default_logger_cache_expiry)
 	if err != nil {
 		_ = newLogger.Log("msg", "error adding logger to cache", "request_id", requestID, "err", err.Error())
@@ -61,7 +63,11 @@ func getLogger(requestID string) kitlog.Logger {
 var logDestination io.Writer = os.Stderr
 
 func newLogger() kitlog.Logger {
-	newLogger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(logDestination))
+	return newLoggerTo(logDestination)
+}
+
+func newLoggerTo(w io.Writer) kitlog.Logger {
+	newLogger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(w))
 	return kitlog.With(newLogger, "ts", kitlog.DefaultTimestampUTC)
 }
 
@@ -108,7 +114,7 @@ func RedactLogs(str, delim string) string {
 
 func RedactURL(str string) string {
 	strLower := strings.ToLower(str)
-	if !strings.HasPrefix(strLower, "http") && !strings.HasPrefix(strLower, "s3") {
+	if !strings.HasPrefix(strLower, "http") && !strings.HasPrefix(strLower, "s3") && !strings.HasPrefix(strLower, "azblob") {
 		return str
 	}
 
@@ -116,5 +122,67 @@ func RedactURL(str string) string {
 	if err != nil {
 		return "REDACTED"
 	}
+	u.RawQuery = RedactQuery(u.RawQuery)
 	return u.Redacted()
 }
+
+// The query parameters carrying credentials, compared case-insensitively: the signatures of Azure SAS tokens and of
+// S3 / GCS presigned URLs, the JWTs and access keys of gated playback and the secret of the Mist triggers
+var secretQueryParams = map[string]bool{
+	"sig":                  true,
+	"signature":            true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-goog-signature":     true,
+	"x-goog-credential":    true,
+	"jwt":                  true,
+	"accesskey":            true,
+	"secret":               true,
+	"token":                true,
+}
+
+// Credentials in URLs logged without being redacted, e.g. in errors
+var regexpURLPassword = regexp.MustCompile(`(://[^:/@\s"]+:)[^@/\s"]+@`)
+
+// The values of the secret query parameters of the URLs found in free text, e.g. the error of a failed request
+var regexpSecretQueryParam = regexp.MustCompile(secretQueryParamPattern())
+
+func secretQueryParamPattern() string {
+	var names []string
+	for name := range secretQueryParams {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	sort.Strings(names)
+	return `(?i)([?&](?:` + strings.Join(names, "|") + `)=)[^&\s"'\\]+`
+}
+
+// RedactLine redacts the credentials of the URLs found anywhere in a log line, i.e. their passwords and the values of
+// their secret query parameters
+func RedactLine(line string) string {
+	line = regexpURLPassword.ReplaceAllString(line, "${1}xxxxx@")
+	return regexpSecretQueryParam.ReplaceAllString(line, "${1}xxxxx")
+}
+
+// RedactQuery replaces the values of the query parameters carrying credentials. The query is returned untouched when
+// there's none.
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "REDACTED"
+	}
+	redacted := false
+	for k := range q {
+		if secretQueryParams[strings.ToLower(k)] {
+			q[k] = []string{"xxxxx"}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+	return q.Encode()
+}
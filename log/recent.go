@@ -0,0 +1,109 @@
+package log
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How many of the latest log lines of a request are kept, e.g. for its debug bundle
+	recentLinesPerRequest = 500
+	// Longer lines are truncated, so that a request can't take more than its share of the memory
+	recentLineMaxBytes = 8 << 10
+)
+
+// How much memory the lines of all the requests can take, the requests that logged least recently are forgotten first
+// beyond it
+var recentLinesMaxBytes = 64 << 20
+
+// recentLinesStore keeps the recent lines of the requests, least recently logged first
+var recentLinesStore = struct {
+	mu       sync.Mutex
+	requests map[string]*list.Element
+	order    *list.List
+	size     int
+}{requests: map[string]*list.Element{}, order: list.New()}
+
+// recentLines keeps the latest log lines of a request
+type recentLines struct {
+	requestID string
+	lines     []string
+	size      int
+	lastWrite time.Time
+}
+
+func (r *recentLines) Write(p []byte) (int, error) {
+	line := RedactLine(strings.TrimRight(string(p), "\n"))
+	if len(line) > recentLineMaxBytes {
+		line = line[:recentLineMaxBytes] + "..."
+	}
+
+	store := &recentLinesStore
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	r.lines = append(r.lines, line)
+	r.size += len(line)
+	store.size += len(line)
+	for len(r.lines) > recentLinesPerRequest {
+		r.size -= len(r.lines[0])
+		store.size -= len(r.lines[0])
+		r.lines = r.lines[1:]
+	}
+	// kept for as long as the request logs, even if its logger outlives the first expiry or the lines were forgotten
+	r.lastWrite = time.Now()
+	if elem, ok := store.requests[r.requestID]; ok && elem.Value == r {
+		store.order.MoveToBack(elem)
+	} else {
+		if ok {
+			forgetRecentLines(elem)
+		}
+		store.requests[r.requestID] = store.order.PushBack(r)
+	}
+
+	for front := store.order.Front(); front != nil && front.Value != r; front = store.order.Front() {
+		if store.size <= recentLinesMaxBytes && !front.Value.(*recentLines).expired() {
+			break
+		}
+		forgetRecentLines(front)
+	}
+	return len(p), nil
+}
+
+func (r *recentLines) expired() bool {
+	return time.Since(r.lastWrite) > default_logger_cache_expiry
+}
+
+// forgetRecentLines removes the lines of a request from the store, which must be locked
+func forgetRecentLines(elem *list.Element) {
+	store := &recentLinesStore
+	r := store.order.Remove(elem).(*recentLines)
+	if store.requests[r.requestID] == elem {
+		delete(store.requests, r.requestID)
+	}
+	store.size -= r.size
+	r.lines, r.size = nil, 0
+}
+
+func getRecentLines(requestID string) *recentLines {
+	store := &recentLinesStore
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if elem, found := store.requests[requestID]; found {
+		return elem.Value.(*recentLines)
+	}
+	return &recentLines{requestID: requestID}
+}
+
+// RecentLines returns the latest lines logged for a request ID, oldest first, with the credentials of URLs redacted
+func RecentLines(requestID string) []string {
+	store := &recentLinesStore
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	elem, found := store.requests[requestID]
+	if !found || elem.Value.(*recentLines).expired() {
+		return nil
+	}
+	return append([]string(nil), elem.Value.(*recentLines).lines...)
+}
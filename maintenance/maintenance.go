@@ -0,0 +1,217 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// DrainingTag is the Serf tag of nodes draining for maintenance, which the balancers don't send new viewers to
+const DrainingTag = "draining"
+
+// Node drains this node for its maintenance windows or by hand. nil when the node isn't set up for maintenance, in
+// which case it never drains.
+var Node *Scheduler
+
+// Window is a recurring maintenance window of the node: when it starts, as a cron spec in UTC, and how long it lasts
+type Window struct {
+	Spec     string
+	Duration time.Duration
+	schedule *schedule
+}
+
+// ParseWindow parses a window from its cron spec followed by its duration, e.g. "0 3 * * 0 2h" for 3am to 5am UTC on
+// Sundays
+func ParseWindow(s string) (Window, error) {
+	fields := strings.Fields(s)
+	if len(fields) != len(cronFields)+1 {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: expected a cron spec followed by a duration", s)
+	}
+	spec := strings.Join(fields[:len(cronFields)], " ")
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return Window{}, err
+	}
+	duration, err := time.ParseDuration(fields[len(cronFields)])
+	if err != nil || duration <= 0 {
+		return Window{}, fmt.Errorf("invalid maintenance window %q: invalid duration", s)
+	}
+	return Window{Spec: spec, Duration: duration, schedule: sched}, nil
+}
+
+// ParseWindows parses semicolon separated windows, the cron specs having commas of their own
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for _, w := range strings.Split(s, ";") {
+		if strings.TrimSpace(w) == "" {
+			continue
+		}
+		window, err := ParseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func (w Window) String() string {
+	return w.Spec + " " + w.Duration.String()
+}
+
+// occurrence returns the start of the window's next occurrence that isn't over at t, possibly running already
+func (w Window) occurrence(t time.Time) time.Time {
+	return w.schedule.next(t.Add(-w.Duration))
+}
+
+// Period is an occurrence of a maintenance window
+type Period struct {
+	Window string    `json:"window"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// Status is the maintenance state of the node, for the admin API
+type Status struct {
+	Draining bool `json:"draining"`
+	// Set when drained by hand rather than for a window
+	Manual  bool     `json:"manual"`
+	Windows []string `json:"windows"`
+	// How long before a window the node starts draining
+	DrainLead string `json:"drain_lead"`
+	// The next occurrence of the windows, which is running when its start is in the past
+	Next *Period `json:"next,omitempty"`
+}
+
+// Scheduler drains the node ahead of its maintenance windows, long enough before for the viewers and jobs it has to
+// move off, and makes it available again once they're over. While draining, the node is tagged in Serf so that the
+// balancers leave it out, and it turns new VOD jobs away.
+type Scheduler struct {
+	lead time.Duration
+	// updates the Serf tags of the node, nil when it isn't in a cluster
+	setTags func(map[string]string) error
+	now     func() time.Time
+
+	mu       sync.Mutex
+	windows  []Window
+	manual   bool
+	draining bool
+	// whether the Serf tags match draining, retried until they do
+	tagged bool
+}
+
+func NewScheduler(windows []Window, lead time.Duration, setTags func(map[string]string) error) *Scheduler {
+	return &Scheduler{
+		lead:    lead,
+		setTags: setTags,
+		now:     func() time.Time { return time.Now().UTC() },
+		windows: windows,
+		tagged:  true,
+	}
+}
+
+// Draining returns whether the node is drained, for a window or by hand
+func (s *Scheduler) Draining() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// SetManual drains the node, or stops draining it by hand. It stays drained while a window is running.
+func (s *Scheduler) SetManual(drain bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manual = drain
+	return s.update()
+}
+
+// SetWindows replaces the windows of the node, e.g. from the admin API. They last until the node restarts.
+func (s *Scheduler) SetWindows(windows []Window) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = windows
+	return s.update()
+}
+
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{Draining: s.draining, Manual: s.manual, Windows: []string{}, DrainLead: s.lead.String()}
+	now := s.now()
+	for _, w := range s.windows {
+		status.Windows = append(status.Windows, w.String())
+		start := w.occurrence(now)
+		if start.IsZero() {
+			continue
+		}
+		if status.Next == nil || start.Before(status.Next.Start) {
+			status.Next = &Period{Window: w.String(), Start: start, End: start.Add(w.Duration)}
+		}
+	}
+	return status
+}
+
+// Run checks the windows every interval, until the context is done
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		if err := s.update(); err != nil {
+			log.LogNoRequestID("failed to update the maintenance state of the node", "err", err)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// inWindow returns the window the node drains for at now, if any. Must be called with mu held.
+func (s *Scheduler) inWindow(now time.Time) (Window, bool) {
+	for _, w := range s.windows {
+		start := w.occurrence(now)
+		if !start.IsZero() && !now.Before(start.Add(-s.lead)) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// update drains the node or makes it available again, and tags it accordingly. Must be called with mu held.
+func (s *Scheduler) update() error {
+	window, inWindow := s.inWindow(s.now())
+	draining := s.manual || inWindow
+	if draining != s.draining {
+		s.draining, s.tagged = draining, false
+		log.LogNoRequestID("node maintenance state changed", "draining", draining, "manual", s.manual, "window", window.Spec)
+		if draining {
+			metrics.Metrics.NodeDraining.Set(1)
+		} else {
+			metrics.Metrics.NodeDraining.Set(0)
+		}
+	}
+	if s.tagged || s.setTags == nil {
+		return nil
+	}
+	value := ""
+	if s.draining {
+		value = "true"
+	}
+	if err := s.setTags(map[string]string{DrainingTag: value}); err != nil {
+		return fmt.Errorf("failed to tag the node: %w", err)
+	}
+	s.tagged = true
+	return nil
+}
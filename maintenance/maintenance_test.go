@@ -0,0 +1,131 @@
+package maintenance
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestScheduleNext(t *testing.T) {
+	for _, tt := range []struct {
+		spec, from, next string
+	}{
+		{"0 3 * * *", "2024-05-01 02:00", "2024-05-01 03:00"},
+		{"0 3 * * *", "2024-05-01 03:00", "2024-05-02 03:00"},
+		{"*/15 * * * *", "2024-05-01 10:07", "2024-05-01 10:15"},
+		{"30 22 * * 0", "2024-05-01 10:00", "2024-05-05 22:30"},
+		{"30 22 * * 7", "2024-05-01 10:00", "2024-05-05 22:30"},
+		{"0 1 1,15 * *", "2024-05-02 00:00", "2024-05-15 01:00"},
+		{"0 0 * 2 1-5", "2024-05-01 00:00", "2025-02-03 00:00"},
+		{"0 4 29 2 *", "2024-03-01 00:00", "2028-02-29 04:00"},
+		// either of a restricted day of month and day of week matches
+		{"0 0 13 * 5", "2024-09-01 00:00", "2024-09-06 00:00"},
+		{"0 0 31 2 *", "2024-01-01 00:00", "0001-01-01 00:00"},
+	} {
+		t.Run(tt.spec+" from "+tt.from, func(t *testing.T) {
+			s, err := parseSchedule(tt.spec)
+			require.NoError(t, err)
+			require.Equal(t, date(tt.next), s.next(date(tt.from)))
+		})
+	}
+}
+
+func TestParseInvalidSchedules(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := parseSchedule(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("0 3 * * 0 2h; 30 1 1,15 * * 45m;")
+	require.NoError(t, err)
+	require.Len(t, windows, 2)
+	require.Equal(t, "0 3 * * 0 2h0m0s", windows[0].String())
+	require.Equal(t, "30 1 1,15 * * 45m0s", windows[1].String())
+
+	windows, err = ParseWindows("")
+	require.NoError(t, err)
+	require.Empty(t, windows)
+
+	_, err = ParseWindows("0 3 * * 0")
+	require.ErrorContains(t, err, "expected a cron spec followed by a duration")
+	_, err = ParseWindows("0 3 * * 0 -1h")
+	require.ErrorContains(t, err, "invalid duration")
+}
+
+func TestSchedulerDrainsAroundWindows(t *testing.T) {
+	window, err := ParseWindow("0 3 * * * 1h")
+	require.NoError(t, err)
+	var tags []map[string]string
+	s := NewScheduler([]Window{window}, 15*time.Minute, func(t map[string]string) error {
+		tags = append(tags, t)
+		return nil
+	})
+	now := date("2024-05-01 02:00")
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.SetWindows(s.windows))
+	require.False(t, s.Draining())
+	require.Empty(t, tags)
+	status := s.Status()
+	require.Equal(t, &Period{Window: "0 3 * * * 1h0m0s", Start: date("2024-05-01 03:00"), End: date("2024-05-01 04:00")}, status.Next)
+
+	// Drains ahead of the window
+	now = date("2024-05-01 02:45")
+	require.NoError(t, s.update())
+	require.True(t, s.Draining())
+	require.Equal(t, []map[string]string{{DrainingTag: "true"}}, tags)
+
+	// Stays drained through the window, without tagging again
+	now = date("2024-05-01 03:59")
+	require.NoError(t, s.update())
+	require.True(t, s.Draining())
+	require.Len(t, tags, 1)
+
+	// Rejoins after it
+	now = date("2024-05-01 04:00")
+	require.NoError(t, s.update())
+	require.False(t, s.Draining())
+	require.Equal(t, map[string]string{DrainingTag: ""}, tags[1])
+
+	// Drained by hand, whatever the windows
+	require.NoError(t, s.SetManual(true))
+	require.True(t, s.Draining())
+	require.True(t, s.Status().Manual)
+	require.NoError(t, s.SetManual(false))
+	require.False(t, s.Draining())
+	require.Len(t, tags, 4)
+}
+
+func TestSchedulerRetriesTagging(t *testing.T) {
+	fail := true
+	s := NewScheduler(nil, time.Minute, func(map[string]string) error {
+		if fail {
+			return fmt.Errorf("serf not initialized")
+		}
+		return nil
+	})
+	require.ErrorContains(t, s.SetManual(true), "serf not initialized")
+	require.True(t, s.Draining())
+	require.False(t, s.tagged)
+
+	fail = false
+	require.NoError(t, s.update())
+	require.True(t, s.tagged)
+}
+
+func TestNilSchedulerNeverDrains(t *testing.T) {
+	var s *Scheduler
+	require.False(t, s.Draining())
+}
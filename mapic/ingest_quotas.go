@@ -0,0 +1,232 @@
+package mistapiconnector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/livepeer-data/pkg/data"
+)
+
+const ingestLimitsCacheDuration = time.Minute
+const eventIngestQuotaExceeded = "stream.quota_exceeded"
+
+// IngestLimits are the limits of an account on its live streams, from the Livepeer API. Zero is unlimited.
+type IngestLimits struct {
+	MaxConcurrentIngests int `json:"maxConcurrentIngests,omitempty"`
+	// In bits per second, of all the tracks of a stream
+	MaxIngestBitrate int64 `json:"maxIngestBitrate,omitempty"`
+}
+
+// IngestQuotaExceededPayload is the payload of the webhook event sent when a stream is rejected for going over the
+// ingest limits of its account
+type IngestQuotaExceededPayload struct {
+	Quota   string `json:"quota"`
+	Limit   int64  `json:"limit"`
+	Current int64  `json:"current"`
+}
+
+// ingestLimitsCache fetches the ingest limits of the accounts from the Livepeer API, keeping them for a while as
+// they're checked on every new ingest
+type ingestLimitsCache struct {
+	server, token string
+	httpClient    *http.Client
+	ttl           time.Duration
+
+	mu      sync.Mutex
+	entries map[string]ingestLimitsEntry
+}
+
+type ingestLimitsEntry struct {
+	limits   IngestLimits
+	updateAt time.Time
+}
+
+func newIngestLimitsCache(server, token string) *ingestLimitsCache {
+	return &ingestLimitsCache{
+		server:     strings.TrimSuffix(server, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		ttl:        ingestLimitsCacheDuration,
+		entries:    make(map[string]ingestLimitsEntry),
+	}
+}
+
+// Get returns the ingest limits of an account. Accounts the API has no limits for are unlimited.
+func (c *ingestLimitsCache) Get(userID string) (IngestLimits, error) {
+	c.mu.Lock()
+	e, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && e.updateAt.Add(c.ttl).After(time.Now()) {
+		return e.limits, nil
+	}
+
+	limits, err := c.fetch(userID)
+	if err != nil {
+		return IngestLimits{}, err
+	}
+	c.mu.Lock()
+	c.entries[userID] = ingestLimitsEntry{limits: limits, updateAt: time.Now()}
+	c.mu.Unlock()
+	return limits, nil
+}
+
+func (c *ingestLimitsCache) fetch(userID string) (IngestLimits, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/user/%s/ingest-limits", c.server, url.PathEscape(userID)), nil)
+	if err != nil {
+		return IngestLimits{}, fmt.Errorf("error creating ingest limits request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return IngestLimits{}, fmt.Errorf("error getting ingest limits userId=%s: %w", userID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return IngestLimits{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return IngestLimits{}, fmt.Errorf("error getting ingest limits userId=%s status=%d", userID, resp.StatusCode)
+	}
+	var limits IngestLimits
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		return IngestLimits{}, fmt.Errorf("error parsing ingest limits userId=%s: %w", userID, err)
+	}
+	return limits, nil
+}
+
+// getIngestLimits returns the limits of the account of a stream, unlimited when the quotas aren't enforced or can't
+// be fetched, so that the Livepeer API being down doesn't stop every stream
+func (mc *mac) getIngestLimits(stream *api.Stream) IngestLimits {
+	if mc.ingestLimits == nil || stream.UserID == "" {
+		return IngestLimits{}
+	}
+	limits, err := mc.ingestLimits.Get(stream.UserID)
+	if err != nil {
+		glog.Warningf("Not enforcing ingest quotas, could not get them streamId=%s userId=%s err=%v", stream.ID, stream.UserID, err)
+		return IngestLimits{}
+	}
+	return limits
+}
+
+// checkConcurrentIngests returns whether the account of a stream can start ingesting it, without going over its
+// limit of concurrent ingests. A stream reconnecting while its last session is still seen isn't counted twice.
+func (mc *mac) checkConcurrentIngests(stream *api.Stream) bool {
+	limits := mc.getIngestLimits(stream)
+	if limits.MaxConcurrentIngests <= 0 {
+		return true
+	}
+	ingests := mc.userIngests(stream.UserID)
+	delete(ingests, stream.PlaybackID)
+	if len(ingests) < limits.MaxConcurrentIngests {
+		return true
+	}
+	glog.Warningf("Rejecting stream over the concurrent ingests quota of its account streamId=%s playbackId=%s userId=%s ingests=%d max=%d",
+		stream.ID, stream.PlaybackID, stream.UserID, len(ingests), limits.MaxConcurrentIngests)
+	mc.emitIngestQuotaExceededEvent(stream, IngestQuotaExceededPayload{
+		Quota:   "maxConcurrentIngests",
+		Limit:   int64(limits.MaxConcurrentIngests),
+		Current: int64(len(ingests) + 1),
+	})
+	return false
+}
+
+// checkIngestBitrate stops the ingest of a stream going over the bitrate limit of its account, once its tracks are
+// known. Only the node ingesting the stream enforces it.
+func (mc *mac) checkIngestBitrate(streamName string, tracks map[string]clients.MistStreamInfoTrack) {
+	playbackID, ok := mistStreamName2playbackID(streamName)
+	if !ok {
+		return
+	}
+	mc.mu.RLock()
+	si, ok := mc.streamInfo[playbackID]
+	mc.mu.RUnlock()
+	if !ok || si.isLazy || si.stream == nil {
+		return
+	}
+	limits := mc.getIngestLimits(si.stream)
+	if limits.MaxIngestBitrate <= 0 {
+		return
+	}
+	var bitrate int64
+	for _, track := range tracks {
+		// Mist reports bytes per second
+		bitrate += int64(track.Bps) * 8
+	}
+	if bitrate <= limits.MaxIngestBitrate {
+		return
+	}
+	glog.Warningf("Stopping stream over the ingest bitrate quota of its account streamId=%s playbackId=%s userId=%s bitrate=%d max=%d",
+		si.stream.ID, playbackID, si.stream.UserID, bitrate, limits.MaxIngestBitrate)
+	mc.emitIngestQuotaExceededEvent(si.stream, IngestQuotaExceededPayload{
+		Quota:   "maxIngestBitrate",
+		Limit:   limits.MaxIngestBitrate,
+		Current: bitrate,
+	})
+	if err := mc.mist.NukeStream(streamName); err != nil {
+		glog.Errorf("Error stopping stream over the ingest bitrate quota streamName=%s err=%v", streamName, err)
+	}
+}
+
+// userIngests returns the playback IDs of the streams of an account ingested across the cluster: on this node as of
+// their PUSH_REWRITE and on the others as of their last node stats
+func (mc *mac) userIngests(userID string) map[string]bool {
+	ingests := map[string]bool{}
+	mc.mu.RLock()
+	for playbackID, si := range mc.streamInfo {
+		si.mu.Lock()
+		if !si.isLazy && !si.stopped && si.stream != nil && si.stream.UserID == userID {
+			ingests[playbackID] = true
+		}
+		si.mu.Unlock()
+	}
+	mc.mu.RUnlock()
+
+	if mc.nodeStats == nil {
+		return ingests
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	nodeUpdates, err := mc.nodeStats.GetNodeUpdates(ctx)
+	if err != nil {
+		glog.Warningf("Counting the ingests of this node only, could not get the node stats userId=%s err=%v", userID, err)
+		return ingests
+	}
+	for _, event := range nodeUpdates {
+		if event.NodeID == mc.nodeID || time.Since(event.NodeMetrics.Timestamp) >= mc.config.CataBalancerMetricTimeout {
+			continue
+		}
+		for streamName, user := range event.IngestUsers {
+			if user == userID {
+				if playbackID, ok := mistStreamName2playbackID(streamName); ok {
+					ingests[playbackID] = true
+				}
+			}
+		}
+	}
+	return ingests
+}
+
+func (mc *mac) emitIngestQuotaExceededEvent(stream *api.Stream, payload IngestQuotaExceededPayload) {
+	go func() {
+		streamID, sessionID := stream.ParentID, stream.ID
+		if streamID == "" {
+			streamID = sessionID
+		}
+		hookEvt, err := data.NewWebhookEvent(streamID, eventIngestQuotaExceeded, stream.UserID, sessionID, payload)
+		if err != nil {
+			glog.Errorf("Error creating webhook event err=%v", err)
+			return
+		}
+		mc.emitAmqpEvent(webhooksExchangeName, "events."+eventIngestQuotaExceeded, hookEvt)
+	}()
+}
@@ -0,0 +1,131 @@
+package mistapiconnector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	mockmistclient "github.com/livepeer/catalyst-api/mocks/clients"
+	"github.com/livepeer/go-api-client"
+	"github.com/stretchr/testify/require"
+)
+
+type staticNodeStats []catabalancer.NodeUpdateEvent
+
+func (s staticNodeStats) PublishNodeUpdate(catabalancer.NodeUpdateEvent) error { return nil }
+
+func (s staticNodeStats) GetNodeUpdates(context.Context) ([]catabalancer.NodeUpdateEvent, error) {
+	return s, nil
+}
+
+func newLimitsServer(t *testing.T, limits map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		body, ok := limits[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConcurrentIngestsQuota(t *testing.T) {
+	server := newLimitsServer(t, map[string]string{
+		"/api/user/limited/ingest-limits": `{"maxConcurrentIngests": 2}`,
+	})
+	otherNode := catabalancer.NodeUpdateEvent{
+		NodeID:      "other",
+		NodeMetrics: catabalancer.NodeMetrics{Timestamp: time.Now()},
+		IngestUsers: map[string]string{"video+remote": "limited", "video+someone-else": "unlimited"},
+	}
+	staleNode := catabalancer.NodeUpdateEvent{
+		NodeID:      "stale",
+		NodeMetrics: catabalancer.NodeMetrics{Timestamp: time.Now().Add(-time.Hour)},
+		IngestUsers: map[string]string{"video+gone": "limited"},
+	}
+	mc := mac{
+		nodeID:       "me",
+		config:       &config.Cli{CataBalancerMetricTimeout: time.Minute},
+		ingestLimits: newIngestLimitsCache(server.URL+"/", "token"),
+		nodeStats:    staticNodeStats{otherNode, staleNode},
+		streamInfo:   map[string]*streamInfo{},
+	}
+
+	stream := func(playbackID, userID string) *api.Stream {
+		return &api.Stream{ID: playbackID + "-id", PlaybackID: playbackID, UserID: userID}
+	}
+	// One ingest on the other node, so there's room for one more
+	require.True(t, mc.checkConcurrentIngests(stream("local", "limited")))
+	mc.streamInfo["local"] = &streamInfo{stream: stream("local", "limited")}
+
+	require.False(t, mc.checkConcurrentIngests(stream("new", "limited")))
+	// Reconnecting streams aren't counted twice
+	require.True(t, mc.checkConcurrentIngests(stream("remote", "limited")))
+	require.True(t, mc.checkConcurrentIngests(stream("local", "limited")))
+	// Accounts without limits
+	require.True(t, mc.checkConcurrentIngests(stream("new", "unlimited")))
+
+	// Ended ingests don't count
+	mc.streamInfo["local"].stopped = true
+	require.True(t, mc.checkConcurrentIngests(stream("new", "limited")))
+}
+
+func TestIngestBitrateQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	server := newLimitsServer(t, map[string]string{
+		"/api/user/limited/ingest-limits": `{"maxIngestBitrate": 3000000}`,
+	})
+	mc := mac{
+		mist:         mm,
+		config:       &config.Cli{},
+		ingestLimits: newIngestLimitsCache(server.URL, "token"),
+		streamInfo: map[string]*streamInfo{
+			"ingest":   {stream: &api.Stream{PlaybackID: "ingest", UserID: "limited"}},
+			"playback": {isLazy: true, stream: &api.Stream{PlaybackID: "playback", UserID: "limited"}},
+		},
+	}
+	tracks := map[string]clients.MistStreamInfoTrack{
+		"video_H264_1280x720_30fps_0": {Type: "video", Bps: 400000},
+		"audio_AAC_2ch_48000hz_1":     {Type: "audio", Bps: 16000},
+	}
+
+	// Only the ingest node stops the stream
+	mm.EXPECT().NukeStream("video+ingest").Return(nil).Times(1)
+	mc.checkIngestBitrate("video+ingest", tracks)
+	mc.checkIngestBitrate("video+playback", tracks)
+
+	tracks["video_H264_1280x720_30fps_0"] = clients.MistStreamInfoTrack{Type: "video", Bps: 300000}
+	mc.checkIngestBitrate("video+ingest", tracks)
+}
+
+func TestIngestLimitsAreCached(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"maxConcurrentIngests": 1}`))
+	}))
+	defer server.Close()
+
+	c := newIngestLimitsCache(server.URL, "token")
+	for i := 0; i < 3; i++ {
+		limits, err := c.Get("user")
+		require.NoError(t, err)
+		require.Equal(t, IngestLimits{MaxConcurrentIngests: 1}, limits)
+	}
+	require.Equal(t, 1, requests)
+
+	server.Close()
+	c.ttl = 0
+	_, err := c.Get("user")
+	require.Error(t, err)
+}
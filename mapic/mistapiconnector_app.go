@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
 	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/handlers/misttriggers"
@@ -47,28 +48,61 @@ type (
 		NukeStream(playbackID string)
 		InvalidateAllSessions(playbackID string)
 		StopSessions(playbackID string)
+		// SubscribeStateDiffs streams the changes of the Mist state seen by the reconcile loop
+		SubscribeStateDiffs() (<-chan clients.MistStateDiff, func())
+		// SRTIngestURL returns the URL to push a stream to over SRT, on a port allocated to the stream. A port is only
+		// allocated to a stream that has none when allocate is set, otherwise the URL is empty for it.
+		SRTIngestURL(playbackID string, allocate bool) (string, error)
+		// MultistreamStatus returns the health of the pushes of a stream to its multistream targets
+		MultistreamStatus(playbackID string) ([]MultistreamTargetStatus, error)
 		IStreamCache
+		IStreamSessions
 	}
 
 	IStreamCache interface {
 		GetCachedStream(playbackID string) *api.Stream
 	}
 
+	IStreamSessions interface {
+		GetStreamSession(streamName string) (StreamSession, bool)
+	}
+
 	pushStatus struct {
 		target              *api.MultistreamTarget
 		profile             string
 		lastEvent           string
 		lastEventAt         time.Time
 		lastEventErrorCount int
-		metrics             *data.MultistreamMetrics
-		mu                  sync.Mutex
+		// all the errors of the push, unlike lastEventErrorCount which is capped and reset once connected
+		errorCount int
+		metrics    *data.MultistreamMetrics
+		mu         sync.Mutex
+	}
+
+	// StreamSession is what the node knows of the live session of a stream, used to enrich the analytics events of
+	// its viewers
+	StreamSession struct {
+		StreamID string
+		UserID   string
+		// Node the stream is ingested on, this one or the node the playback stream is replicated from
+		IngestNode string
+		// Protocol the stream is ingested with, only known on the ingest node
+		IngestProtocol string
+		StartedAt      time.Time
+	}
+
+	// activeStream is a stream active in Mist, as of the last reconcile
+	activeStream struct {
+		ingestNode string
+		firstSeen  time.Time
 	}
 
 	streamInfo struct {
-		id        string
-		isLazy    bool
-		stream    *api.Stream
-		startedAt time.Time
+		id             string
+		isLazy         bool
+		stream         *api.Stream
+		startedAt      time.Time
+		ingestProtocol string
 
 		mu               sync.Mutex
 		done             chan struct{}
@@ -102,6 +136,7 @@ type (
 		checkBandwidth            bool
 		baseStreamName            string
 		streamInfo                map[string]*streamInfo
+		activeStreams             map[string]activeStream
 		producer                  event.AMQPProducer
 		nodeID                    string
 		ownRegion                 string
@@ -113,6 +148,13 @@ type (
 		streamUpdated             chan struct{}
 		metricsCollector          *metricsCollector
 		streamMetricsRe           *regexp.Regexp
+		stateDiffs                stateDiffs
+		// nil when the ingest quotas aren't enforced
+		ingestLimits *ingestLimitsCache
+		// the stats of the nodes of the cluster, to count the ingests of an account across it. nil without them.
+		nodeStats catabalancer.NodeStatsStore
+		// the SRT ports allocated to streams, nil when SRT ingest isn't managed
+		srtPorts *srtPortPool
 	}
 )
 
@@ -135,6 +177,9 @@ func (mc *mac) Start(ctx context.Context) error {
 	})
 	mc.lapi = lapi
 	mc.lapiCached = NewApiClientCached(lapi)
+	if mc.config.IngestQuotas {
+		mc.ingestLimits = newIngestLimitsCache(mc.config.APIServer, mc.config.APIToken)
+	}
 
 	if mc.balancerHost != "" && !strings.Contains(mc.balancerHost, ":") {
 		mc.balancerHost = mc.balancerHost + ":8042" // must set default port for Mist's Load Balancer
@@ -207,7 +252,7 @@ func (mc *mac) StopSessions(playbackID string) {
 	}
 
 	streamNames := []string{
-		"video+" + playbackID,
+		"video+" + config.WithTenant(playbackID),
 	}
 
 	for _, streamName := range streamNames {
@@ -238,8 +283,11 @@ func (mc *mac) handleStreamBuffer(ctx context.Context, payload *misttriggers.Str
 		return nil
 	}
 	playbackID := payload.StreamName
-	if mc.baseStreamName != "" && strings.Contains(playbackID, "+") {
-		playbackID = strings.Split(playbackID, "+")[1]
+	if mc.baseStreamName != "" {
+		var ok bool
+		if playbackID, ok = mistStreamName2playbackID(playbackID); !ok {
+			return nil
+		}
 	}
 	if info, ok := mc.getStreamInfoLogged(playbackID); ok {
 		glog.Infof("Setting stream's manifestID=%s playbackID=%s active status to %v", info.id, playbackID, isActive)
@@ -272,6 +320,14 @@ func (mc *mac) handlePushRewrite(ctx context.Context, payload *misttriggers.Push
 			return "", nil
 		}
 	}
+	if payload.URL.Scheme == "srt" {
+		key, err := srtStreamKey(payload)
+		if err != nil {
+			glog.Errorf("Rejecting SRT push hostname=%s err=%v", payload.Hostname, err)
+			return "", nil
+		}
+		streamKey = key
+	}
 	glog.V(model.VVERBOSE).Infof("Requested stream key is '%s'", streamKey)
 	// ask API
 	stream, err := mc.lapi.GetStreamByKey(streamKey)
@@ -283,6 +339,14 @@ func (mc *mac) handlePushRewrite(ctx context.Context, payload *misttriggers.Push
 	}
 	glog.V(model.VERBOSE).Infof("For stream %s got info %+v", streamKey, stream)
 
+	if !mc.checkConcurrentIngests(stream) {
+		return "", nil
+	}
+	if payload.URL.Scheme == "srt" && !mc.srtPortAccepts(payload, stream.PlaybackID) {
+		glog.Errorf("Rejecting SRT push on a port not allocated to the stream playbackID=%s port=%s", stream.PlaybackID, payload.URL.Port())
+		return "", nil
+	}
+
 	if stream.PlaybackID != "" {
 		mc.mu.Lock()
 		if info, ok := mc.streamInfo[stream.PlaybackID]; ok {
@@ -292,29 +356,24 @@ func (mc *mac) handlePushRewrite(ctx context.Context, payload *misttriggers.Push
 			mc.removeInfoLocked(stream.PlaybackID)
 		}
 		info := &streamInfo{
-			id:         stream.ID,
-			stream:     stream,
-			done:       make(chan struct{}),
-			pushStatus: make(map[string]*pushStatus),
-			startedAt:  time.Now(),
+			id:             stream.ID,
+			stream:         stream,
+			done:           make(chan struct{}),
+			pushStatus:     make(map[string]*pushStatus),
+			startedAt:      time.Now(),
+			ingestProtocol: payload.URL.Scheme,
 		}
 		mc.streamInfo[stream.PlaybackID] = info
 		mc.mu.Unlock()
-		streamKey = stream.PlaybackID
-		// streamKey = strings.ReplaceAll(streamKey, "-", "")
-		if mc.balancerHost != "" {
-			streamKey = streamPlaybackPrefix + streamKey
-		}
-		if mc.baseStreamName == "" {
-			responseName = streamKey
-		} else {
-			responseName = mc.wildcardPlaybackID(stream)
-		}
+		responseName = mc.mistStreamName(stream)
 	} else {
 		glog.Errorf("Shouldn't happen streamID=%s", stream.ID)
 	}
 	if stream.Deleted || stream.Suspended {
 		// Do not allow to start deleted or suspended streams
+		if mc.srtPorts != nil {
+			mc.srtPorts.release(stream.PlaybackID)
+		}
 		return "", nil
 	}
 	glog.Infof("Responded with '%s'", responseName)
@@ -324,8 +383,12 @@ func (mc *mac) handlePushRewrite(ctx context.Context, payload *misttriggers.Push
 func (mc *mac) handleLiveTrackList(ctx context.Context, payload *misttriggers.LiveTrackListPayload) error {
 	go func() {
 		videoTracksNum := payload.CountVideoTracks()
-		playbackID := mistStreamName2playbackID(payload.StreamName)
+		playbackID, ok := mistStreamName2playbackID(payload.StreamName)
+		if !ok {
+			return
+		}
 		glog.Infof("for video %s got %d video tracks", playbackID, videoTracksNum)
+		mc.checkIngestBitrate(payload.StreamName, payload.TrackList)
 		mc.refreshStream(playbackID)
 	}()
 	return nil
@@ -357,7 +420,10 @@ func (mc *mac) refreshStream(playbackID string) (*streamInfo, error) {
 
 func (mc *mac) handlePushOutStart(ctx context.Context, payload *misttriggers.PushOutStartPayload) (string, error) {
 	go func() {
-		playbackID := mistStreamName2playbackID(payload.StreamName)
+		playbackID, ok := mistStreamName2playbackID(payload.StreamName)
+		if !ok {
+			return
+		}
 		if info, ok := mc.getStreamInfoLogged(playbackID); ok {
 			info.mu.Lock()
 			defer info.mu.Unlock()
@@ -475,7 +541,10 @@ func (mc *mac) emitAmqpEvent(exchange, key string, evt data.Event) {
 
 func (mc *mac) handlePushEnd(ctx context.Context, payload *misttriggers.PushEndPayload) error {
 	go func() {
-		playbackID := mistStreamName2playbackID(payload.StreamName)
+		playbackID, ok := mistStreamName2playbackID(payload.StreamName)
+		if !ok {
+			return
+		}
 		if info, ok := mc.getStreamInfoLogged(playbackID); ok {
 			info.mu.Lock()
 			defer info.mu.Unlock()
@@ -494,6 +563,7 @@ func (mc *mac) handlePushEnd(ctx context.Context, payload *misttriggers.PushEndP
 					pushInfo.lastEventErrorCount = 0
 				default:
 					pushInfo.lastEvent = eventMultistreamError
+					pushInfo.errorCount++
 					if pushInfo.lastEventErrorCount <= eventMultistreamErrorTolerance {
 						pushInfo.lastEventErrorCount++
 					}
@@ -536,7 +606,18 @@ func (mc *mac) removeInfoLocked(playbackID string) {
 }
 
 func (mc *mac) wildcardPlaybackID(stream *api.Stream) string {
-	return mc.baseStreamName + "+" + stream.PlaybackID
+	return mc.baseStreamName + "+" + config.WithTenant(stream.PlaybackID)
+}
+
+// mistStreamName is the name of the Mist stream an ingest of the stream is pushed to
+func (mc *mac) mistStreamName(stream *api.Stream) string {
+	if mc.baseStreamName != "" {
+		return mc.wildcardPlaybackID(stream)
+	}
+	if mc.balancerHost != "" {
+		return streamPlaybackPrefix + config.WithTenant(stream.PlaybackID)
+	}
+	return config.WithTenant(stream.PlaybackID)
 }
 
 // reconcileLoop calls reconcileStream, reconcileMultistream and processStats
@@ -556,12 +637,145 @@ func (mc *mac) reconcileLoop(ctx context.Context) {
 			glog.Errorf("error executing query on Mist, cannot reconcile err=%v", err)
 			continue
 		}
+		mc.reconcileStreamConfigs(mistState)
+		if mc.srtPorts != nil {
+			mc.reconcileSRTListeners()
+		}
 		mc.reconcileStreams(mistState)
 		mc.reconcileMultistream(mistState)
 		mc.processStats(mistState)
+		mc.updateActiveStreams(mistState)
+		mc.stateDiffs.publish(mistState)
 	}
 }
 
+// updateActiveStreams records the streams active in Mist with where they're ingested and since when they're seen, for
+// the sessions of the streams this node only plays back
+func (mc *mac) updateActiveStreams(mistState clients.MistState) {
+	now := time.Now()
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	active := make(map[string]activeStream, len(mistState.ActiveStreams))
+	for streamName, as := range mistState.ActiveStreams {
+		playbackID, ok := mistStreamName2playbackID(streamName)
+		if !ok {
+			continue
+		}
+		s, ok := active[playbackID]
+		if !ok {
+			s, ok = mc.activeStreams[playbackID]
+		}
+		if !ok {
+			s = activeStream{firstSeen: now}
+		}
+		if mistState.IsIngestStream(streamName) {
+			s.ingestNode = mc.nodeID
+		} else if s.ingestNode == "" {
+			s.ingestNode = replicationSourceNode(as.Source)
+		}
+		active[playbackID] = s
+	}
+	mc.activeStreams = active
+}
+
+// replicationSourceNode returns the node a playback stream is replicated from, given its Mist source, e.g.
+// push://INTERNAL_ONLY:dtsc://node.example.com:4200/video+playbackID
+func replicationSourceNode(source string) string {
+	u, err := url.Parse(strings.TrimPrefix(source, "push://INTERNAL_ONLY:"))
+	if err != nil || u.Scheme != "dtsc" {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// GetStreamSession returns the session of a live stream the node ingests or plays back, if it knows of it
+func (mc *mac) GetStreamSession(streamName string) (StreamSession, bool) {
+	playbackID, ok := mistStreamName2playbackID(streamName)
+	if !ok {
+		return StreamSession{}, false
+	}
+
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	si, hasInfo := mc.streamInfo[playbackID]
+	active, isActive := mc.activeStreams[playbackID]
+	if !hasInfo && !isActive {
+		return StreamSession{}, false
+	}
+
+	session := StreamSession{IngestNode: active.ingestNode, StartedAt: active.firstSeen}
+	if hasInfo {
+		session.StreamID = si.id
+		if si.stream != nil {
+			session.UserID = si.stream.UserID
+		}
+		if !si.isLazy {
+			// Ingested here, so the start and protocol of the session are known from its PUSH_REWRITE
+			session.IngestNode = mc.nodeID
+			session.IngestProtocol = si.ingestProtocol
+			session.StartedAt = si.startedAt
+		}
+	}
+	return session, true
+}
+
+// reconcileStreamConfigs makes sure that Mist has the stream configs the ingest streams are pushed to, e.g. after a
+// Mist restart lost or reverted them. With a wildcard base stream that's just the base stream config, otherwise there
+// is a config for every ingest stream in the streamInfo cache.
+func (mc *mac) reconcileStreamConfigs(mistState clients.MistState) {
+	if mistState.Streams == nil {
+		glog.Warning("Mist did not return its stream configs, cannot reconcile them")
+		return
+	}
+	for name, source := range mc.expectedStreamConfigs() {
+		current, exists := mistState.Streams.Configs[name]
+		if !exists && mistState.Streams.Incomplete {
+			// it may be configured but missing from the truncated list
+			continue
+		}
+		if exists && current.Source() == source {
+			continue
+		}
+
+		// keep the rest of the config, e.g. the processes set up for the stream
+		config := clients.MistStreamConfig{}
+		for k, v := range current {
+			config[k] = v
+		}
+		config["name"] = name
+		config["source"] = source
+		glog.Infof("fixing Mist stream config stream=%s source=%s previous_source=%s exists=%v", name, source, current.Source(), exists)
+		if err := mc.mist.AddStreamConfig(name, config); err != nil {
+			glog.Errorf("cannot fix Mist stream config stream=%s err=%v", name, err)
+		}
+	}
+}
+
+// expectedStreamConfigs returns the source of every stream that should be configured in Mist, by stream name
+func (mc *mac) expectedStreamConfigs() map[string]string {
+	if mc.mistStreamSource == "" {
+		return nil
+	}
+	if mc.baseStreamName != "" {
+		return map[string]string{mc.baseStreamName: mc.mistStreamSource}
+	}
+
+	expected := map[string]string{}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for _, si := range mc.streamInfo {
+		si.mu.Lock()
+		stopped := si.stopped
+		si.mu.Unlock()
+		// lazy stream infos are only cached for metrics and may not be ingested here
+		if stopped || si.isLazy || si.stream.Deleted || si.stream.Suspended {
+			continue
+		}
+		expected[mc.mistStreamName(si.stream)] = mc.mistStreamSource
+	}
+	return expected
+}
+
 func (mc *mac) reconcileStreams(mistState clients.MistState) {
 	for streamName, _ := range mistState.ActiveStreams {
 		if !mistState.IsIngestStream(streamName) {
@@ -584,6 +798,9 @@ func (mc *mac) reconcileSingleStream(si *streamInfo) {
 		// the only thing we do here is nuke
 		return
 	}
+	if mc.srtPorts != nil {
+		mc.srtPorts.release(si.stream.PlaybackID)
+	}
 
 	// make sure we nuke any possible stream names on mist to account for any inconsistencies
 	mc.nukeAllStreamNames(si.stream.PlaybackID)
@@ -605,7 +822,7 @@ func (mc *mac) nukeAllStreamNames(playbackID string) {
 
 func (mc *mac) invalidateAllSessions(playbackID string) {
 	streamNames := []string{
-		"video+" + playbackID,
+		"video+" + config.WithTenant(playbackID),
 	}
 
 	for _, streamName := range streamNames {
@@ -767,7 +984,10 @@ func (mc *mac) getStreamInfoLogged(playbackID string) (*streamInfo, bool) {
 }
 
 func (mc *mac) getStreamInfo(playbackID string) (*streamInfo, error) {
-	playbackID = mistStreamName2playbackID(playbackID)
+	playbackID, ok := mistStreamName2playbackID(playbackID)
+	if !ok {
+		return nil, fmt.Errorf("stream of another tenant")
+	}
 
 	mc.mu.RLock()
 	info := mc.streamInfo[playbackID]
@@ -814,6 +1034,9 @@ func (mc *mac) refreshStreamInfo(playbackID string) (*streamInfo, error) {
 		}
 		mc.streamInfo[playbackID] = info
 	} else {
+		if mc.config.LiveProfileUpdates && !info.isLazy && profilesChanged(info.stream.Profiles, stream.Profiles) {
+			go mc.updateLiveProfiles(stream)
+		}
 		info.id = stream.ID
 		info.stream = stream
 	}
@@ -853,11 +1076,13 @@ func (mc *mac) GetCachedStream(playbackID string) *api.Stream {
 	return nil
 }
 
-func mistStreamName2playbackID(msn string) string {
+// mistStreamName2playbackID returns the playback ID of a Mist stream name, or false for the streams of another tenant
+// sharing Mist
+func mistStreamName2playbackID(msn string) (string, bool) {
 	if strings.Contains(msn, "+") {
-		return strings.Split(msn, "+")[1]
+		msn = strings.Split(msn, "+")[1]
 	}
-	return msn
+	return config.SplitTenant(msn)
 }
 
 func pushToMultistreamTargetInfo(pushInfo *pushStatus) data.MultistreamTargetInfo {
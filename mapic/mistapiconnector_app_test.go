@@ -2,6 +2,7 @@ package mistapiconnector
 
 import (
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/livepeer/catalyst-api/clients"
@@ -259,3 +260,121 @@ func TestReconcileStreams(t *testing.T) {
 	}
 	require.ElementsMatch(t, expectedNuked, recodedNuked)
 }
+
+func TestReconcileStreamConfigs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	mc := mac{
+		mist:             mm,
+		baseStreamName:   "video",
+		mistStreamSource: "push://",
+		config:           &config.Cli{},
+	}
+
+	// Missing wildcard config, e.g. after a Mist restart
+	mm.EXPECT().AddStreamConfig("video", clients.MistStreamConfig{"name": "video", "source": "push://"}).Return(nil)
+	mc.reconcileStreamConfigs(clients.MistState{Streams: &clients.MistStreamConfigs{Configs: map[string]clients.MistStreamConfig{}}})
+
+	// Wrong source, keeping the rest of the config
+	mm.EXPECT().AddStreamConfig("video", clients.MistStreamConfig{"name": "video", "source": "push://", "processes": "livepeer"}).Return(nil)
+	mc.reconcileStreamConfigs(clients.MistState{Streams: &clients.MistStreamConfigs{Configs: map[string]clients.MistStreamConfig{
+		"video": {"name": "video", "source": "dtsc://wrong", "processes": "livepeer"},
+	}}})
+
+	// Nothing to fix, or not enough information to fix it
+	mc.reconcileStreamConfigs(clients.MistState{Streams: &clients.MistStreamConfigs{Configs: map[string]clients.MistStreamConfig{
+		"video": {"name": "video", "source": "push://"},
+	}}})
+	mc.reconcileStreamConfigs(clients.MistState{Streams: &clients.MistStreamConfigs{Configs: map[string]clients.MistStreamConfig{}, Incomplete: true}})
+	mc.reconcileStreamConfigs(clients.MistState{})
+}
+
+func TestReconcileStreamConfigsWithoutWildcard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	mc := mac{
+		mist:             mm,
+		mistStreamSource: "push://",
+		config:           &config.Cli{},
+		streamInfo: map[string]*streamInfo{
+			"active":     {stream: &api.Stream{PlaybackID: "active"}},
+			"configured": {stream: &api.Stream{PlaybackID: "configured"}},
+			"stopped":    {stream: &api.Stream{PlaybackID: "stopped"}, stopped: true},
+			"suspended":  {stream: &api.Stream{PlaybackID: "suspended", Suspended: true}},
+			"lazy":       {stream: &api.Stream{PlaybackID: "lazy"}, isLazy: true},
+		},
+	}
+
+	mm.EXPECT().AddStreamConfig("active", clients.MistStreamConfig{"name": "active", "source": "push://"}).Return(nil)
+	mc.reconcileStreamConfigs(clients.MistState{Streams: &clients.MistStreamConfigs{Configs: map[string]clients.MistStreamConfig{
+		"configured": {"name": "configured", "source": "push://"},
+	}}})
+}
+
+func TestGetStreamSession(t *testing.T) {
+	startedAt := time.Now().Add(-time.Hour)
+	mc := mac{
+		nodeID: "ingest-node",
+		streamInfo: map[string]*streamInfo{
+			"ingested":   {id: "stream-1", stream: &api.Stream{UserID: "user-1"}, startedAt: startedAt, ingestProtocol: "rtmp"},
+			"replicated": {id: "stream-2", stream: &api.Stream{UserID: "user-2"}, isLazy: true},
+		},
+	}
+	mc.updateActiveStreams(clients.MistState{ActiveStreams: map[string]*clients.ActiveStream{
+		"video+ingested":   {Source: "push://"},
+		"video+replicated": {Source: "push://INTERNAL_ONLY:dtsc://other-node.example.com:4200/video+replicated"},
+		"video+unknown":    {Source: "push://INTERNAL_ONLY:dtsc://other-node.example.com:4200/video+unknown"},
+	}})
+
+	session, ok := mc.GetStreamSession("video+ingested")
+	require.True(t, ok)
+	require.Equal(t, StreamSession{StreamID: "stream-1", UserID: "user-1", IngestNode: "ingest-node", IngestProtocol: "rtmp", StartedAt: startedAt}, session)
+
+	session, ok = mc.GetStreamSession("video+replicated")
+	require.True(t, ok)
+	require.Equal(t, "stream-2", session.StreamID)
+	require.Equal(t, "user-2", session.UserID)
+	require.Equal(t, "other-node.example.com", session.IngestNode)
+	require.Empty(t, session.IngestProtocol)
+	firstSeen := session.StartedAt
+	require.False(t, firstSeen.IsZero())
+
+	// Only known from Mist, without the stream's info
+	session, ok = mc.GetStreamSession("video+unknown")
+	require.True(t, ok)
+	require.Empty(t, session.StreamID)
+	require.Equal(t, "other-node.example.com", session.IngestNode)
+
+	// Seen since the first reconcile, forgotten once inactive
+	mc.updateActiveStreams(clients.MistState{ActiveStreams: map[string]*clients.ActiveStream{
+		"video+replicated": {Source: "push://INTERNAL_ONLY:dtsc://other-node.example.com:4200/video+replicated"},
+	}})
+	session, ok = mc.GetStreamSession("video+replicated")
+	require.True(t, ok)
+	require.Equal(t, firstSeen, session.StartedAt)
+	_, ok = mc.GetStreamSession("video+unknown")
+	require.False(t, ok)
+}
+
+func TestStateDiffSubscription(t *testing.T) {
+	mc := &mac{}
+	diffs, unsubscribe := mc.SubscribeStateDiffs()
+
+	// The first state is the baseline, and unchanged states aren't sent
+	state := clients.MistState{ActiveStreams: map[string]*clients.ActiveStream{"video+a": {}}}
+	mc.stateDiffs.publish(state)
+	mc.stateDiffs.publish(state)
+	require.Empty(t, diffs)
+
+	mc.stateDiffs.publish(clients.MistState{ActiveStreams: map[string]*clients.ActiveStream{"video+b": {}}})
+	diff := <-diffs
+	require.Equal(t, []string{"video+b"}, diff.StreamsStarted)
+	require.Equal(t, []string{"video+a"}, diff.StreamsStopped)
+
+	unsubscribe()
+	_, ok := <-diffs
+	require.False(t, ok)
+	// Safe to publish and unsubscribe again once unsubscribed
+	mc.stateDiffs.publish(state)
+	unsubscribe()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/config"
 	"io"
 	"net/http"
 	"strings"
@@ -113,6 +114,11 @@ func (mc *mac) parsePlaybackID(line string) (string, bool) {
 
 func (mc *mac) enrichLabels(playbackID string) string {
 	res := mc.streamLabel(playbackID)
+	// the stream names of a tenant are qualified with it, the streams of the other tenants aren't ours to enrich
+	playbackID, ok := config.SplitTenant(playbackID)
+	if !ok {
+		return res
+	}
 	si, err := mc.getStreamInfo(playbackID)
 	if err != nil {
 		glog.Warning("could not enrich Mist metrics for stream=%s err=%v", playbackID, err)
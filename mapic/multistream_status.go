@@ -0,0 +1,95 @@
+package mistapiconnector
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/clients"
+)
+
+// States of the multistream targets of a stream, from the last event sent for them
+const (
+	MultistreamConnected    = "connected"
+	MultistreamError        = "error"
+	MultistreamDisconnected = "disconnected"
+)
+
+// MultistreamTargetStatus is the health of the push of a stream to one of its multistream targets, as seen by this
+// node. The events are only seen by the node ingesting the stream.
+type MultistreamTargetStatus struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Profile string `json:"profile"`
+	// Disabled targets aren't pushed to
+	Disabled bool   `json:"disabled,omitempty"`
+	State    string `json:"state"`
+	// When the last multistream event or push end was seen, unset if none was
+	LastEventAt *time.Time `json:"last_event_at,omitempty"`
+	// Errors in a row since the target was last connected, and in total since the stream was seen by the node
+	ConsecutiveErrors int `json:"consecutive_errors"`
+	Errors            int `json:"errors"`
+	// Whether Mist is pushing to the target right now, with the stats of the push when it is
+	Pushing bool                   `json:"pushing"`
+	Stats   *clients.MistPushStats `json:"stats,omitempty"`
+}
+
+// MultistreamStatus returns the health of the pushes of a stream to its multistream targets, with the current stats
+// of the ones Mist is pushing
+func (mc *mac) MultistreamStatus(playbackID string) ([]MultistreamTargetStatus, error) {
+	info, err := mc.getStreamInfo(playbackID)
+	if err != nil {
+		return nil, err
+	}
+
+	pushes := map[string]*clients.MistPush{}
+	if mistState, err := mc.mist.GetState(); err != nil {
+		glog.Errorf("error getting the multistream push stats, mist GetState failed playbackId=%s err=%q", playbackID, err)
+	} else {
+		for _, push := range mistState.PushList {
+			if pushPlaybackID, ok := mistStreamName2playbackID(push.Stream); ok && pushPlaybackID == playbackID {
+				pushes[push.OriginalURL] = push
+			}
+		}
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	statuses := []MultistreamTargetStatus{}
+	for pushURL, pushInfo := range info.pushStatus {
+		pushInfo.mu.Lock()
+		status := MultistreamTargetStatus{
+			Profile:           pushInfo.profile,
+			State:             multistreamState(pushInfo.lastEvent),
+			ConsecutiveErrors: pushInfo.lastEventErrorCount,
+			Errors:            pushInfo.errorCount,
+		}
+		if pushInfo.target != nil {
+			status.ID, status.Name, status.Disabled = pushInfo.target.ID, pushInfo.target.Name, pushInfo.target.Disabled
+		}
+		if !pushInfo.lastEventAt.IsZero() {
+			lastEventAt := pushInfo.lastEventAt
+			status.LastEventAt = &lastEventAt
+		}
+		pushInfo.mu.Unlock()
+		if push, ok := pushes[pushURL]; ok {
+			status.Pushing = true
+			status.Stats = push.Stats
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses, nil
+}
+
+func multistreamState(lastEvent string) string {
+	switch lastEvent {
+	case eventMultistreamConnected:
+		return MultistreamConnected
+	case eventMultistreamError:
+		return MultistreamError
+	default:
+		// also before the first push, and once a disconnected push was reset by its push end
+		return MultistreamDisconnected
+	}
+}
@@ -0,0 +1,68 @@
+package mistapiconnector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/livepeer/catalyst-api/clients"
+	mockmistclient "github.com/livepeer/catalyst-api/mocks/clients"
+	"github.com/livepeer/go-api-client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultistreamStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	lastEventAt := time.Now().Add(-time.Minute)
+	mc := mac{
+		mist: mm,
+		streamInfo: map[string]*streamInfo{
+			"abcd": {
+				stream: &api.Stream{PlaybackID: "abcd"},
+				pushStatus: map[string]*pushStatus{
+					"rtmp://youtube/live/key": {
+						target:      &api.MultistreamTarget{ID: "target-1", Name: "YouTube"},
+						profile:     "720p",
+						lastEvent:   eventMultistreamConnected,
+						lastEventAt: lastEventAt,
+						errorCount:  3,
+					},
+					"rtmp://twitch/live/key": {
+						target:              &api.MultistreamTarget{ID: "target-2", Name: "Twitch"},
+						profile:             "source",
+						lastEvent:           eventMultistreamError,
+						lastEventAt:         lastEventAt,
+						lastEventErrorCount: 2,
+						errorCount:          2,
+					},
+					"rtmp://disabled/live/key": {
+						target:  &api.MultistreamTarget{ID: "target-3", Disabled: true},
+						profile: "source",
+					},
+				},
+			},
+		},
+	}
+	mm.EXPECT().GetState().Return(clients.MistState{PushList: []*clients.MistPush{
+		{ID: 1, Stream: "video+abcd", OriginalURL: "rtmp://youtube/live/key", Stats: &clients.MistPushStats{ActiveSeconds: 60, Bytes: 1000, MediaTime: 59000}},
+		{ID: 2, Stream: "video+other", OriginalURL: "rtmp://twitch/live/key"},
+	}}, nil)
+
+	statuses, err := mc.MultistreamStatus("abcd")
+	require.NoError(t, err)
+	require.Equal(t, []MultistreamTargetStatus{
+		{ID: "target-1", Name: "YouTube", Profile: "720p", State: MultistreamConnected, LastEventAt: &lastEventAt, Errors: 3, Pushing: true,
+			Stats: &clients.MistPushStats{ActiveSeconds: 60, Bytes: 1000, MediaTime: 59000}},
+		{ID: "target-2", Name: "Twitch", Profile: "source", State: MultistreamError, LastEventAt: &lastEventAt, ConsecutiveErrors: 2, Errors: 2},
+		{ID: "target-3", Profile: "source", Disabled: true, State: MultistreamDisconnected},
+	}, statuses)
+
+	// the states are still returned without the stats of Mist
+	mm.EXPECT().GetState().Return(clients.MistState{}, errors.New("mist is down"))
+	statuses, err = mc.MultistreamStatus("abcd")
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.False(t, statuses[0].Pushing)
+}
@@ -0,0 +1,152 @@
+package mistapiconnector
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-api-client"
+)
+
+// How the edited transcode profiles of a live stream were applied to its session
+const (
+	// The target profiles of the transcode process in the stream's Mist config were updated, which Mist restarts the
+	// process for
+	profileUpdateConfig = "config"
+	// The transcode session of the stream was stopped, which Mist restarts with a new broadcaster session that gets the
+	// new profiles
+	profileUpdateRestart = "restart"
+)
+
+// Name of the Mist process transcoding a stream with the Livepeer network (MistProcLivepeer)
+const livepeerProcess = "Livepeer"
+
+func profilesChanged(old, new []api.Profile) bool {
+	if len(old) == 0 && len(new) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(old, new)
+}
+
+// updateLiveProfiles applies the edited transcode profiles of a stream to its live session, if it's ingested by this
+// node. Either way the next sessions of the stream get them.
+func (mc *mac) updateLiveProfiles(stream *api.Stream) {
+	how, err := mc.applyProfileUpdate(stream)
+	if err != nil {
+		glog.Errorf("Error applying the updated transcode profiles to the live stream, they apply from its next session playbackId=%s err=%v", stream.PlaybackID, err)
+		return
+	}
+	if how != "" {
+		glog.Infof("Applied the updated transcode profiles to the live stream playbackId=%s profiles=%d how=%s", stream.PlaybackID, len(stream.Profiles), how)
+	}
+}
+
+// applyProfileUpdate applies the transcode profiles of the stream to its live session, returning how. With a Mist
+// config of its own whose transcode process lists its profiles, the process is updated. Otherwise, e.g. for the
+// wildcard streams sharing the base stream's config, where the broadcaster fetches the profiles when the session
+// starts, the transcode session is restarted. The transcode process starts its segments on keyframes, so the new
+// profiles apply from the next keyframe of the source either way.
+func (mc *mac) applyProfileUpdate(stream *api.Stream) (string, error) {
+	streamName := mc.mistStreamName(stream)
+	mistState, err := mc.mist.GetState()
+	if err != nil {
+		return "", err
+	}
+	if !mistState.IsIngestStream(streamName) {
+		// only the ingest node transcodes the stream
+		return "", nil
+	}
+
+	if mc.baseStreamName == "" && mistState.Streams != nil {
+		if config, ok := mistState.Streams.Configs[streamName]; ok {
+			if updated, ok := withTargetProfiles(config, stream.Profiles); ok {
+				if err := mc.mist.AddStreamConfig(streamName, updated); err != nil {
+					return "", fmt.Errorf("error updating the stream config: %w", err)
+				}
+				return profileUpdateConfig, nil
+			}
+		}
+	}
+
+	sessions, err := mc.mist.GetSessions(streamName)
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for _, session := range sessions {
+		if session.Protocol == livepeerProcess || strings.HasSuffix(session.Protocol, ":"+livepeerProcess) {
+			ids = append(ids, session.ID)
+		}
+	}
+	if len(ids) == 0 {
+		// not transcoding yet, the process gets the profiles when it starts
+		return "", nil
+	}
+	if err := mc.mist.StopSessionIDs(ids); err != nil {
+		return "", fmt.Errorf("error restarting the transcode session: %w", err)
+	}
+	return profileUpdateRestart, nil
+}
+
+// withTargetProfiles returns a copy of the stream config with the target profiles of its Livepeer processes replaced,
+// and whether it has any. The processes without target profiles fetch them from the broadcaster instead.
+func withTargetProfiles(config map[string]interface{}, profiles []api.Profile) (map[string]interface{}, bool) {
+	processes, _ := config["processes"].([]interface{})
+	updatedProcesses := make([]interface{}, len(processes))
+	found := false
+	for i, p := range processes {
+		updatedProcesses[i] = p
+		process, ok := p.(map[string]interface{})
+		if !ok || process["process"] != livepeerProcess {
+			continue
+		}
+		if _, ok := process["target_profiles"]; !ok {
+			continue
+		}
+		updated := make(map[string]interface{}, len(process))
+		for k, v := range process {
+			updated[k] = v
+		}
+		updated["target_profiles"] = mistTargetProfiles(profiles)
+		updatedProcesses[i] = updated
+		found = true
+	}
+	if !found {
+		return nil, false
+	}
+
+	updatedConfig := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		updatedConfig[k] = v
+	}
+	updatedConfig["processes"] = updatedProcesses
+	return updatedConfig, true
+}
+
+// mistTargetProfiles converts the profiles into the target profiles of MistProcLivepeer. Each rendition is skipped
+// for sources smaller than it.
+func mistTargetProfiles(profiles []api.Profile) []interface{} {
+	targets := make([]interface{}, 0, len(profiles))
+	for _, p := range profiles {
+		target := map[string]interface{}{
+			"name":          p.Name,
+			"width":         p.Width,
+			"height":        p.Height,
+			"bitrate":       p.Bitrate,
+			"fps":           p.Fps,
+			"track_inhibit": fmt.Sprintf("video=<%dx%d", p.Width, p.Height),
+		}
+		if p.FpsDen > 0 {
+			target["fpsDen"] = p.FpsDen
+		}
+		if p.Gop != "" {
+			target["gop"] = p.Gop
+		}
+		if p.Profile != "" {
+			target["profile"] = p.Profile
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
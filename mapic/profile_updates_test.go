@@ -0,0 +1,111 @@
+package mistapiconnector
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/livepeer/catalyst-api/clients"
+	mockmistclient "github.com/livepeer/catalyst-api/mocks/clients"
+	"github.com/livepeer/go-api-client"
+	"github.com/stretchr/testify/require"
+)
+
+var testProfiles = []api.Profile{{Name: "360p", Width: 640, Height: 360, Bitrate: 1000000, Fps: 30}}
+
+func TestProfilesChanged(t *testing.T) {
+	require.False(t, profilesChanged(nil, []api.Profile{}))
+	require.False(t, profilesChanged(testProfiles, []api.Profile{testProfiles[0]}))
+	require.True(t, profilesChanged(nil, testProfiles))
+	require.True(t, profilesChanged(testProfiles, []api.Profile{{Name: "360p", Width: 640, Height: 360, Bitrate: 800000, Fps: 30}}))
+}
+
+func TestWithTargetProfiles(t *testing.T) {
+	config := map[string]interface{}{
+		"name":   "abcd",
+		"source": "push://",
+		"processes": []interface{}{
+			map[string]interface{}{"process": "Livepeer", "target_profiles": []interface{}{}},
+			map[string]interface{}{"process": "AV"},
+		},
+	}
+
+	updated, ok := withTargetProfiles(config, testProfiles)
+	require.True(t, ok)
+	require.Equal(t, "push://", updated["source"])
+	processes := updated["processes"].([]interface{})
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"name":          "360p",
+		"width":         640,
+		"height":        360,
+		"bitrate":       1000000,
+		"fps":           30,
+		"track_inhibit": "video=<640x360",
+	}}, processes[0].(map[string]interface{})["target_profiles"])
+	require.Equal(t, map[string]interface{}{"process": "AV"}, processes[1])
+	// The original config is left as is
+	require.Equal(t, []interface{}{}, config["processes"].([]interface{})[0].(map[string]interface{})["target_profiles"])
+
+	_, ok = withTargetProfiles(map[string]interface{}{"processes": []interface{}{map[string]interface{}{"process": "Livepeer"}}}, testProfiles)
+	require.False(t, ok)
+	_, ok = withTargetProfiles(map[string]interface{}{"source": "push://"}, testProfiles)
+	require.False(t, ok)
+}
+
+func TestApplyProfileUpdateRestartsTheTranscodeSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	mc := mac{mist: mm, baseStreamName: "video"}
+	stream := &api.Stream{PlaybackID: "abcd", Profiles: testProfiles}
+
+	mm.EXPECT().GetState().Return(clients.MistState{ActiveStreams: map[string]*clients.ActiveStream{"video+abcd": {Source: "push://"}}}, nil)
+	mm.EXPECT().GetSessions("video+abcd").Return([]clients.MistSession{
+		{ID: "s1", Protocol: "INPUT:RTMP"},
+		{ID: "s2", Protocol: "OUTPUT:Livepeer"},
+		{ID: "s3", Protocol: "HLS"},
+	}, nil)
+	mm.EXPECT().StopSessionIDs([]string{"s2"}).Return(nil)
+
+	how, err := mc.applyProfileUpdate(stream)
+	require.NoError(t, err)
+	require.Equal(t, profileUpdateRestart, how)
+}
+
+func TestApplyProfileUpdateUpdatesTheStreamConfig(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	mc := mac{mist: mm}
+	stream := &api.Stream{PlaybackID: "abcd", Profiles: testProfiles}
+
+	mm.EXPECT().GetState().Return(clients.MistState{
+		ActiveStreams: map[string]*clients.ActiveStream{"abcd": {Source: "push://"}},
+		Streams: &clients.MistStreamConfigs{Configs: map[string]clients.MistStreamConfig{
+			"abcd": {"name": "abcd", "source": "push://", "processes": []interface{}{
+				map[string]interface{}{"process": "Livepeer", "target_profiles": []interface{}{}},
+			}},
+		}},
+	}, nil)
+	mm.EXPECT().AddStreamConfig("abcd", gomock.Any()).DoAndReturn(func(_ string, config clients.MistStreamConfig) error {
+		targets := config["processes"].([]interface{})[0].(map[string]interface{})["target_profiles"].([]interface{})
+		require.Len(t, targets, 1)
+		require.Equal(t, "360p", targets[0].(map[string]interface{})["name"])
+		return nil
+	})
+
+	how, err := mc.applyProfileUpdate(stream)
+	require.NoError(t, err)
+	require.Equal(t, profileUpdateConfig, how)
+}
+
+func TestApplyProfileUpdateIgnoresStreamsNotIngestedHere(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	mc := mac{mist: mm, baseStreamName: "video"}
+
+	mm.EXPECT().GetState().Return(clients.MistState{ActiveStreams: map[string]*clients.ActiveStream{
+		"video+abcd": {Source: "push://INTERNAL_ONLY:dtsc://other-node"},
+	}}, nil)
+
+	how, err := mc.applyProfileUpdate(&api.Stream{PlaybackID: "abcd", Profiles: testProfiles})
+	require.NoError(t, err)
+	require.Empty(t, how)
+}
@@ -0,0 +1,415 @@
+package mistapiconnector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+)
+
+const srtConnector = "TSSRT"
+
+// How long the SRT port of a stream is kept for it without being asked for nor ingested on, before it's given to
+// another stream
+const srtAllocationTTL = 24 * time.Hour
+
+var (
+	ErrSRTIngestDisabled = errors.New("no SRT port range configured")
+	ErrSRTPortsExhausted = errors.New("all the SRT ports are allocated")
+
+	srtStreamKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+)
+
+// srtPortPool gives every stream asking for SRT ingest a port of its own out of a range, on which Mist listens with an
+// SRT connector. A port is kept for its stream while the stream uses it. The allocations are written to a file when
+// one is configured so that they survive restarts, otherwise the ports Mist still listens on are adopted on start.
+type srtPortPool struct {
+	first, last int
+	now         func() time.Time
+	// where the allocations are persisted, none when empty
+	file string
+
+	mu         sync.Mutex
+	byPort     map[int]*srtAllocation
+	byPlayback map[string]int
+	// set once the listeners left from before a restart were adopted
+	adopted bool
+	// set when the last used times changed since the allocations were last persisted
+	dirty bool
+	// serializes the changes to the SRT connectors of Mist
+	reconcileMu sync.Mutex
+}
+
+type srtAllocation struct {
+	// empty for a port adopted from the Mist config, claimed by the first stream pushed on it
+	PlaybackID string    `json:"playback_id"`
+	LastUsed   time.Time `json:"last_used"`
+}
+
+func newSRTPortPool(first, last int, file string) *srtPortPool {
+	if first <= 0 || last < first {
+		return nil
+	}
+	p := &srtPortPool{
+		first:      first,
+		last:       last,
+		now:        time.Now,
+		file:       file,
+		byPort:     map[int]*srtAllocation{},
+		byPlayback: map[string]int{},
+	}
+	if err := p.load(); err != nil {
+		glog.Errorf("cannot load the SRT port allocations file=%s err=%v", file, err)
+	}
+	return p
+}
+
+func (p *srtPortPool) inRange(port int) bool {
+	return port >= p.first && port <= p.last
+}
+
+// load reads the persisted allocations. The ports that are no longer in the range are dropped.
+func (p *srtPortPool) load() error {
+	if p.file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(p.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var byPort map[int]*srtAllocation
+	if err := json.Unmarshal(b, &byPort); err != nil {
+		return err
+	}
+	for port, a := range byPort {
+		if !p.inRange(port) || a == nil || a.PlaybackID == "" {
+			continue
+		}
+		p.byPort[port] = a
+		p.byPlayback[a.PlaybackID] = port
+	}
+	// the listeners of the loaded ports are Mist's, no need to adopt them
+	p.adopted = true
+	return nil
+}
+
+// saveLocked persists the allocations, by replacing the file so that a crash never leaves a partial one
+func (p *srtPortPool) saveLocked() {
+	if p.file == "" {
+		return
+	}
+	p.dirty = false
+	b, err := json.Marshal(p.byPort)
+	if err == nil {
+		tmp := p.file + ".tmp"
+		if err = os.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, p.file)
+		}
+	}
+	if err != nil {
+		glog.Errorf("cannot persist the SRT port allocations file=%s err=%v", p.file, err)
+	}
+}
+
+// persist saves the allocations if their last used times changed since they were last saved
+func (p *srtPortPool) persist() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dirty {
+		p.saveLocked()
+	}
+}
+
+// allocate returns the port of the stream, allocating one if it has none yet. The bool is true when it's a new one.
+func (p *srtPortPool) allocate(playbackID string) (int, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if port, ok := p.byPlayback[playbackID]; ok {
+		p.byPort[port].LastUsed = now
+		p.dirty = true
+		return port, false, nil
+	}
+	p.expireLocked(now)
+	for port := p.first; port <= p.last; port++ {
+		if _, taken := p.byPort[port]; !taken {
+			p.byPort[port] = &srtAllocation{PlaybackID: playbackID, LastUsed: now}
+			p.byPlayback[playbackID] = port
+			p.saveLocked()
+			return port, true, nil
+		}
+	}
+	return 0, false, ErrSRTPortsExhausted
+}
+
+// lookup returns the port allocated to the stream, without allocating one
+func (p *srtPortPool) lookup(playbackID string) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	port, ok := p.byPlayback[playbackID]
+	return port, ok
+}
+
+// accepts returns whether a stream may be ingested on a port: any port outside of the range, e.g. a shared SRT port,
+// or the port allocated to the stream. An adopted port is claimed by the first stream pushed on it that has no other.
+func (p *srtPortPool) accepts(port int, playbackID string) bool {
+	if !p.inRange(port) {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, ok := p.byPort[port]
+	if !ok {
+		return false
+	}
+	if a.PlaybackID == "" {
+		if _, hasPort := p.byPlayback[playbackID]; hasPort {
+			return false
+		}
+		glog.Infof("Adopted SRT port=%d claimed by playbackID=%s", port, playbackID)
+		a.PlaybackID = playbackID
+		p.byPlayback[playbackID] = port
+		a.LastUsed = p.now()
+		p.saveLocked()
+		return true
+	}
+	if a.PlaybackID != playbackID {
+		return false
+	}
+	a.LastUsed = p.now()
+	p.dirty = true
+	return true
+}
+
+// touch keeps the port of a stream that's live from expiring
+func (p *srtPortPool) touch(playbackID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if port, ok := p.byPlayback[playbackID]; ok {
+		p.byPort[port].LastUsed = p.now()
+		p.dirty = true
+	}
+}
+
+// adopt keeps the ports Mist listens on from before a restart, until they're claimed or expire. It's only done once.
+func (p *srtPortPool) adopt(ports []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.adopted {
+		return
+	}
+	p.adopted = true
+	now := p.now()
+	for _, port := range ports {
+		if _, taken := p.byPort[port]; !taken && p.inRange(port) {
+			glog.Infof("Adopting SRT listener port=%d", port)
+			p.byPort[port] = &srtAllocation{LastUsed: now}
+		}
+	}
+}
+
+func (p *srtPortPool) release(playbackID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if port, ok := p.byPlayback[playbackID]; ok {
+		delete(p.byPort, port)
+		delete(p.byPlayback, playbackID)
+		p.saveLocked()
+	}
+}
+
+// allocated returns the ports allocated to streams
+func (p *srtPortPool) allocated() map[int]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(p.now())
+	ports := make(map[int]bool, len(p.byPort))
+	for port := range p.byPort {
+		ports[port] = true
+	}
+	return ports
+}
+
+func (p *srtPortPool) expireLocked(now time.Time) {
+	expired := false
+	for port, a := range p.byPort {
+		if now.Sub(a.LastUsed) > srtAllocationTTL {
+			glog.Infof("Releasing unused SRT port=%d playbackID=%s", port, a.PlaybackID)
+			delete(p.byPort, port)
+			if a.PlaybackID != "" {
+				delete(p.byPlayback, a.PlaybackID)
+			}
+			expired = true
+		}
+	}
+	if expired {
+		p.saveLocked()
+	}
+}
+
+// SRTIngestURL returns the URL to push a stream to over SRT, on the port allocated to the stream. When allocate is
+// set, a port is allocated to the stream if it has none yet, otherwise an empty URL is returned for it.
+// The stream is identified by its stream key as the SRT stream ID.
+func (mc *mac) SRTIngestURL(playbackID string, allocate bool) (string, error) {
+	if mc.srtPorts == nil {
+		return "", ErrSRTIngestDisabled
+	}
+	if !allocate {
+		if _, ok := mc.srtPorts.lookup(playbackID); !ok {
+			return "", nil
+		}
+	}
+	info, err := mc.getStreamInfo(playbackID)
+	if err != nil {
+		return "", err
+	}
+	info.mu.Lock()
+	stream := info.stream
+	info.mu.Unlock()
+	if stream.Deleted || stream.Suspended {
+		return "", fmt.Errorf("stream %s is deleted or suspended", playbackID)
+	}
+	if stream.StreamKey == "" {
+		return "", fmt.Errorf("no stream key for stream %s", playbackID)
+	}
+
+	var port int
+	if allocate {
+		var isNew bool
+		port, isNew, err = mc.srtPorts.allocate(stream.PlaybackID)
+		if err != nil {
+			return "", err
+		}
+		if isNew {
+			glog.Infof("Allocated SRT port=%d playbackID=%s", port, stream.PlaybackID)
+			// Listen straight away rather than on the next reconcile, the caller is about to hand the URL out
+			mc.reconcileSRTListeners()
+		}
+	} else if port, _ = mc.srtPorts.lookup(stream.PlaybackID); port == 0 {
+		return "", nil
+	}
+
+	host := mc.config.SRTIngestHost
+	if host == "" {
+		host = mc.nodeID
+	}
+	u := url.URL{
+		Scheme:   "srt",
+		Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+		RawQuery: url.Values{"streamid": {stream.StreamKey}}.Encode(),
+	}
+	return u.String(), nil
+}
+
+// reconcileSRTListeners makes Mist listen with an SRT connector on the allocated SRT ports, and stop listening on the
+// ports of the range which are no longer allocated
+func (mc *mac) reconcileSRTListeners() {
+	mc.srtPorts.reconcileMu.Lock()
+	defer mc.srtPorts.reconcileMu.Unlock()
+
+	mistConfig, err := mc.mist.GetConfig()
+	if err != nil {
+		glog.Errorf("error getting Mist config, cannot reconcile SRT listeners err=%v", err)
+		return
+	}
+	var listening []int
+	for _, protocol := range mistConfig.Protocols {
+		if protocol.Connector() == srtConnector && mc.srtPorts.inRange(protocol.Port()) {
+			listening = append(listening, protocol.Port())
+		}
+	}
+	mc.srtPorts.adopt(listening)
+	mc.touchLiveSRTStreams()
+	mc.srtPorts.persist()
+
+	missing := mc.srtPorts.allocated()
+	for _, protocol := range mistConfig.Protocols {
+		port := protocol.Port()
+		if protocol.Connector() != srtConnector || !mc.srtPorts.inRange(port) {
+			continue
+		}
+		if missing[port] {
+			delete(missing, port)
+			continue
+		}
+		glog.Infof("Removing SRT listener of released port=%d", port)
+		if err := mc.mist.DeleteProtocol(protocol); err != nil {
+			glog.Errorf("cannot remove SRT listener port=%d err=%v", port, err)
+		}
+	}
+	for port := range missing {
+		glog.Infof("Adding SRT listener port=%d", port)
+		if err := mc.mist.AddProtocol(clients.MistProtocol{"connector": srtConnector, "port": port}); err != nil {
+			glog.Errorf("cannot add SRT listener port=%d err=%v", port, err)
+		}
+	}
+}
+
+// touchLiveSRTStreams keeps the ports of the streams ingested over SRT on the node from expiring while they're live
+func (mc *mac) touchLiveSRTStreams() {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	for playbackID, info := range mc.streamInfo {
+		info.mu.Lock()
+		live := info.ingestProtocol == "srt" && !info.stopped
+		info.mu.Unlock()
+		if live {
+			mc.srtPorts.touch(playbackID)
+		}
+	}
+}
+
+// srtStreamKey returns the stream key of an SRT push, given as its SRT stream ID. The stream ID is either the stream
+// key itself or follows the SRT access control syntax, e.g. #!::r=<stream key>,m=publish.
+func srtStreamKey(payload *misttriggers.PushRewritePayload) (string, error) {
+	streamID := payload.URL.Query().Get("streamid")
+	if streamID == "" {
+		streamID = payload.StreamName
+	}
+	key := streamID
+	if strings.HasPrefix(streamID, "#!::") {
+		key = ""
+		for _, kv := range strings.Split(strings.TrimPrefix(streamID, "#!::"), ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "r":
+				key = v
+			case "m":
+				if v != "publish" {
+					return "", fmt.Errorf("SRT stream ID mode must be publish, got %q", v)
+				}
+			}
+		}
+	}
+	if !srtStreamKeyRe.MatchString(key) {
+		// not logging the stream ID, it may be a stream key
+		return "", errors.New("invalid SRT stream ID")
+	}
+	return key, nil
+}
+
+// srtPortAccepts returns whether a stream may be pushed over SRT on the port the push came in on
+func (mc *mac) srtPortAccepts(payload *misttriggers.PushRewritePayload, playbackID string) bool {
+	if mc.srtPorts == nil {
+		return true
+	}
+	port, err := strconv.Atoi(payload.URL.Port())
+	if err != nil {
+		// the default port, which is never in the range
+		return true
+	}
+	return mc.srtPorts.accepts(port, playbackID)
+}
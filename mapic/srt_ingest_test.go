@@ -0,0 +1,148 @@
+package mistapiconnector
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+	mockmistclient "github.com/livepeer/catalyst-api/mocks/clients"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSRTPortPool(t *testing.T) {
+	require.Nil(t, newSRTPortPool(0, 0, ""))
+
+	p := newSRTPortPool(9000, 9001, "")
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	port, isNew, err := p.allocate("abc")
+	require.NoError(t, err)
+	require.True(t, isNew)
+	require.Equal(t, 9000, port)
+	port, isNew, err = p.allocate("abc")
+	require.NoError(t, err)
+	require.False(t, isNew)
+	require.Equal(t, 9000, port)
+
+	port, _, err = p.allocate("def")
+	require.NoError(t, err)
+	require.Equal(t, 9001, port)
+	_, _, err = p.allocate("ghi")
+	require.ErrorIs(t, err, ErrSRTPortsExhausted)
+
+	require.True(t, p.accepts(9000, "abc"))
+	require.False(t, p.accepts(9000, "def"))
+	// ports outside of the range are shared
+	require.True(t, p.accepts(8889, "def"))
+
+	// a released or unused port is given to another stream
+	p.release("abc")
+	require.False(t, p.accepts(9000, "abc"))
+	now = now.Add(srtAllocationTTL + time.Second)
+	require.Empty(t, p.allocated())
+	port, _, err = p.allocate("ghi")
+	require.NoError(t, err)
+	require.Equal(t, 9000, port)
+}
+
+func TestSRTPortPoolPersisted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "srt-ports.json")
+	p := newSRTPortPool(9000, 9009, file)
+	_, _, err := p.allocate("abc")
+	require.NoError(t, err)
+	_, _, err = p.allocate("def")
+	require.NoError(t, err)
+	p.release("abc")
+
+	// the stream keeps its port across restarts and the adopted listeners aren't needed
+	p = newSRTPortPool(9000, 9009, file)
+	port, ok := p.lookup("def")
+	require.True(t, ok)
+	require.Equal(t, 9001, port)
+	_, ok = p.lookup("abc")
+	require.False(t, ok)
+	p.adopt([]int{9005})
+	require.Equal(t, map[int]bool{9001: true}, p.allocated())
+
+	// a live stream keeps its port
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	now = now.Add(srtAllocationTTL / 2)
+	p.touch("def")
+	p.persist()
+	now = now.Add(srtAllocationTTL/2 + time.Second)
+	require.Equal(t, map[int]bool{9001: true}, p.allocated())
+}
+
+func TestSRTPortPoolAdopted(t *testing.T) {
+	p := newSRTPortPool(9000, 9009, "")
+	p.adopt([]int{9000, 9001, 8889})
+	// only adopted once, on the first reconcile
+	p.adopt([]int{9002})
+	require.Equal(t, map[int]bool{9000: true, 9001: true}, p.allocated())
+
+	port, _, err := p.allocate("ghi")
+	require.NoError(t, err)
+	require.Equal(t, 9002, port)
+
+	// the first stream pushed on an adopted port claims it, if it has no port of its own
+	require.False(t, p.accepts(9000, "ghi"))
+	require.True(t, p.accepts(9000, "abc"))
+	require.False(t, p.accepts(9000, "def"))
+	require.False(t, p.accepts(9001, "abc"))
+	port, ok := p.lookup("abc")
+	require.True(t, ok)
+	require.Equal(t, 9000, port)
+}
+
+func TestSRTStreamKey(t *testing.T) {
+	streamKey := func(pushURL, streamName string) (string, error) {
+		u, err := url.Parse(pushURL)
+		require.NoError(t, err)
+		return srtStreamKey(&misttriggers.PushRewritePayload{URL: u, StreamName: streamName})
+	}
+
+	key, err := streamKey("srt://mist.example.com:9000?streamid=abcd-1234", "abcd-1234")
+	require.NoError(t, err)
+	require.Equal(t, "abcd-1234", key)
+
+	key, err = streamKey("srt://mist.example.com:9000", "#!::r=abcd-1234,m=publish")
+	require.NoError(t, err)
+	require.Equal(t, "abcd-1234", key)
+
+	_, err = streamKey("srt://mist.example.com:9000", "#!::r=abcd-1234,m=request")
+	require.Error(t, err)
+	_, err = streamKey("srt://mist.example.com:9000", "#!::m=publish")
+	require.Error(t, err)
+	_, err = streamKey("srt://mist.example.com:9000", "../abcd")
+	require.Error(t, err)
+}
+
+func TestReconcileSRTListeners(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mm := mockmistclient.NewMockMistAPIClient(ctrl)
+	mc := mac{mist: mm, srtPorts: newSRTPortPool(9000, 9009, "")}
+	mc.srtPorts.adopted = true
+	_, _, err := mc.srtPorts.allocate("abc")
+	require.NoError(t, err)
+	_, _, err = mc.srtPorts.allocate("def")
+	require.NoError(t, err)
+
+	released := clients.MistProtocol{"connector": "TSSRT", "port": float64(9005)}
+	mm.EXPECT().GetConfig().Return(clients.MistConfigState{Protocols: []clients.MistProtocol{
+		{"connector": "RTMP"},
+		// shared SRT port, not managed
+		{"connector": "TSSRT", "port": float64(8889)},
+		{"connector": "TSSRT", "port": float64(9000)},
+		released,
+	}}, nil)
+	mm.EXPECT().DeleteProtocol(released).Return(nil)
+	mm.EXPECT().AddProtocol(clients.MistProtocol{"connector": "TSSRT", "port": 9001}).Return(nil)
+
+	mc.reconcileSRTListeners()
+}
@@ -2,6 +2,7 @@ package mistapiconnector
 
 import (
 	"fmt"
+	"github.com/livepeer/catalyst-api/balancer/catabalancer"
 	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/handlers/misttriggers"
@@ -10,7 +11,9 @@ import (
 	"regexp"
 )
 
-func NewMapic(cli *config.Cli, broker misttriggers.TriggerBroker, mist clients.MistAPIClient) IMac {
+// NewMapic creates the Mist API connector. nodeStats are the stats of the nodes of the cluster, used to count the
+// ingests of an account across it when enforcing the ingest quotas, and may be nil.
+func NewMapic(cli *config.Cli, broker misttriggers.TriggerBroker, mist clients.MistAPIClient, nodeStats catabalancer.NodeStatsStore) IMac {
 	streamMetricsRe := regexp.MustCompile(fmt.Sprintf(`stream="%s\+(.*?)"`, cli.MistBaseStreamName))
 	mc := &mac{
 		config:                    cli,
@@ -18,6 +21,7 @@ func NewMapic(cli *config.Cli, broker misttriggers.TriggerBroker, mist clients.M
 		mistHot:                   cli.MistHost,
 		checkBandwidth:            false,
 		streamInfo:                make(map[string]*streamInfo),
+		activeStreams:             make(map[string]activeStream),
 		baseStreamName:            cli.MistBaseStreamName,
 		ownRegion:                 cli.OwnRegion,
 		mistStreamSource:          cli.MistStreamSource,
@@ -25,6 +29,8 @@ func NewMapic(cli *config.Cli, broker misttriggers.TriggerBroker, mist clients.M
 		broker:                    broker,
 		mist:                      mist,
 		streamMetricsRe:           streamMetricsRe,
+		nodeStats:                 nodeStats,
+		srtPorts:                  newSRTPortPool(cli.SRTPortMin, cli.SRTPortMax, cli.SRTPortsFile),
 	}
 	metrics.InitCensus(mc.config.NodeName, model.Version, "mistconnector")
 	return mc
@@ -0,0 +1,67 @@
+package mistapiconnector
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/clients"
+)
+
+// Diffs buffered per subscriber. A subscriber that falls further behind misses diffs rather than holding up the
+// reconcile loop.
+const stateDiffsBuffer = 16
+
+// stateDiffs fans out the changes of the Mist state seen by the reconcile loop to its subscribers, e.g. the admin
+// WebSocket of live ops dashboards
+type stateDiffs struct {
+	mu          sync.Mutex
+	last        *clients.MistState
+	subscribers map[chan clients.MistStateDiff]struct{}
+}
+
+// SubscribeStateDiffs returns the diffs of the Mist state computed from now on, and a func to unsubscribe that closes
+// the channel
+func (mc *mac) SubscribeStateDiffs() (<-chan clients.MistStateDiff, func()) {
+	d := &mc.stateDiffs
+	ch := make(chan clients.MistStateDiff, stateDiffsBuffer)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subscribers == nil {
+		d.subscribers = map[chan clients.MistStateDiff]struct{}{}
+	}
+	d.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			delete(d.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+// publish diffs the Mist state against the one of the previous reconcile and sends the changes to the subscribers.
+// The first state is only a baseline.
+func (d *stateDiffs) publish(mistState clients.MistState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	last := d.last
+	d.last = &mistState
+	if last == nil {
+		return
+	}
+	diff := clients.DiffMistState(*last, mistState)
+	if diff.IsEmpty() {
+		return
+	}
+	for ch := range d.subscribers {
+		select {
+		case ch <- diff:
+		default:
+			glog.Warning("Mist state diff subscriber is falling behind, dropping diff")
+		}
+	}
+}
@@ -19,11 +19,52 @@ type VODPipelineMetrics struct {
 	TranscodedSegments *prometheus.CounterVec
 	SourceBytes        *prometheus.SummaryVec
 	SourceDuration     *prometheus.SummaryVec
+	TransferBytes      *prometheus.CounterVec
+	DTSHHeaders        *prometheus.CounterVec
+	FeedImportItems    *prometheus.CounterVec
+	JobErrors          *prometheus.CounterVec
+	SourcePlayback     *prometheus.HistogramVec
+}
+
+type FFmpegMetrics struct {
+	Duration *prometheus.HistogramVec
+	Failures *prometheus.CounterVec
+	CPUTime  *prometheus.HistogramVec
+	MaxRSS   *prometheus.HistogramVec
+	InFlight *prometheus.GaugeVec
+}
+
+// CatabalancerMetrics are the inputs and scores of the catabalancer node selection, by node
+type CatabalancerMetrics struct {
+	NodeUsage       *prometheus.GaugeVec
+	NodeStatsAge    *prometheus.GaugeVec
+	NodeDraining    *prometheus.GaugeVec
+	NodeScore       *prometheus.GaugeVec
+	NodeGeoDistance *prometheus.GaugeVec
+	NodeSelected    *prometheus.CounterVec
+}
+
+type StorageMetrics struct {
+	OperationDuration *prometheus.HistogramVec
+	OperationErrors   *prometheus.CounterVec
+	ThrottleEvents    *prometheus.CounterVec
+	UploadParallelism *prometheus.GaugeVec
+	MirrorWrites      *prometheus.CounterVec
+	MirrorQueueDepth  prometheus.Gauge
+}
+
+type CacheMetrics struct {
+	Hits          *prometheus.CounterVec
+	Misses        *prometheus.CounterVec
+	Evictions     *prometheus.CounterVec
+	Size          *prometheus.GaugeVec
+	BackendErrors *prometheus.CounterVec
 }
 
 type AnalyticsMetrics struct {
 	AnalyticsLogsPlaytimeMs   *prometheus.SummaryVec
 	AnalyticsLogsBufferTimeMs *prometheus.SummaryVec
+	AnalyticsHeatmapViewers   *prometheus.GaugeVec
 
 	LogProcessorWriteErrors prometheus.Counter
 	AnalyticsLogsErrors     prometheus.Counter
@@ -39,22 +80,55 @@ type CatalystAPIMetrics struct {
 	UploadVODRequestDurationSec     *prometheus.SummaryVec
 	TranscodeSegmentDurationSec     prometheus.Histogram
 	PlaybackRequestDurationSec      *prometheus.SummaryVec
+	HTTPRequestDurationSec          *prometheus.HistogramVec
 	CDNRedirectCount                *prometheus.CounterVec
 	CDNRedirectWebRTC406            *prometheus.CounterVec
 	UserEventBufferSize             prometheus.Gauge
 	MemberEventBufferSize           prometheus.Gauge
 	SerfEventBufferSize             prometheus.Gauge
+	UserEventTooLargeCount          *prometheus.CounterVec
 	AccessControlRequestCount       *prometheus.CounterVec
 	AccessControlRequestDurationSec *prometheus.SummaryVec
-	CatabalancerRequestDurationSec  *prometheus.HistogramVec
+	AccessControlGeoBlockedCount    *prometheus.CounterVec
+	// Blocked JWTs, see -gate-blocked-jwts and -gate-blocked-jwts-list
+	AccessControlBlockedJWTMatchCount  *prometheus.CounterVec
+	AccessControlBlockedJWTListSize    prometheus.Gauge
+	AccessControlBlockedJWTReloadCount *prometheus.CounterVec
+	CatabalancerRequestDurationSec     *prometheus.HistogramVec
+	FederationRoutingCount             *prometheus.CounterVec
+	FederationPeerAvailable            *prometheus.GaugeVec
+	APIAuthRequestCount                *prometheus.CounterVec
+	SourcePreflightCount               *prometheus.CounterVec
+	JanitorReclaimedBytes              *prometheus.CounterVec
+	JanitorDeletedCount                *prometheus.CounterVec
+	InjectedFaults                     *prometheus.CounterVec
+	MistTriggersThrottled              *prometheus.CounterVec
+	BroadcasterConnections             *prometheus.CounterVec
 
 	JobsInFlight         prometheus.Gauge
 	HTTPRequestsInFlight prometheus.Gauge
+	ConcurrencyLimit     *prometheus.GaugeVec
+	NodeDraining         prometheus.Gauge
+
+	AdmissionQueueDepth *prometheus.GaugeVec
+	AdmissionWaitSec    *prometheus.SummaryVec
+	AdmissionRejected   *prometheus.CounterVec
+
+	CDNWarmUpRequests    *prometheus.CounterVec
+	CDNWarmUpDurationSec prometheus.Summary
+
+	HTTPConnections          *prometheus.GaugeVec
+	HTTPHandlerGoroutines    *prometheus.GaugeVec
+	HTTPRouteRequestsDropped *prometheus.CounterVec
 
 	TranscodingStatusUpdate ClientMetrics
 	BroadcasterClient       ClientMetrics
 	MistClient              ClientMetrics
 	ObjectStoreClient       ClientMetrics
+	StorageMetrics          StorageMetrics
+	FFmpegMetrics           FFmpegMetrics
+	CacheMetrics            CacheMetrics
+	CatabalancerMetrics     CatabalancerMetrics
 
 	VODPipelineMetrics VODPipelineMetrics
 
@@ -80,6 +154,48 @@ func NewMetrics() *CatalystAPIMetrics {
 			Name: "http_requests_in_flight",
 			Help: "A count of the http requests in flight",
 		}),
+		ConcurrencyLimit: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "concurrency_limit",
+			Help: "The effective concurrency limits of VOD work, tuned to the node's resource headroom",
+		}, []string{"limit"}),
+		NodeDraining: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "node_draining",
+			Help: "Whether the node is drained for maintenance, by hand or for one of its maintenance windows",
+		}),
+		AdmissionQueueDepth: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vod_admission_queue_depth",
+			Help: "The VOD requests turned away for lack of capacity that their callers are still retrying",
+		}, []string{"job_type"}),
+		AdmissionWaitSec: promauto.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "vod_admission_wait_seconds",
+			Help:       "Time from a VOD request being first turned away for lack of capacity until a retry of it is admitted",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, []string{"job_type"}),
+		AdmissionRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "vod_admission_rejected_count",
+			Help: "The VOD requests turned away for lack of capacity",
+		}, []string{"job_type"}),
+		CDNWarmUpRequests: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "vod_cdn_warmup_requests_count",
+			Help: "The requests for newly published VOD outputs made through the CDN to warm its cache, by the type of object and result",
+		}, []string{"type", "result"}),
+		CDNWarmUpDurationSec: promauto.NewSummary(prometheus.SummaryOpts{
+			Name:       "vod_cdn_warmup_duration_seconds",
+			Help:       "Time taken to warm the CDN cache with the outputs of a VOD job",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		HTTPConnections: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_server_connections",
+			Help: "The open connections of the public and internal API servers, including the idle keep-alive ones",
+		}, []string{"server"}),
+		HTTPHandlerGoroutines: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_server_handler_goroutines",
+			Help: "The goroutines of the public and internal API servers handling a request",
+		}, []string{"server"}),
+		HTTPRouteRequestsDropped: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_route_requests_dropped",
+			Help: "The total number of requests turned away because their route was at its concurrency cap",
+		}, []string{"server", "route"}),
 		UserEventBufferSize: promauto.NewGauge(prometheus.GaugeOpts{
 			Name: "user_event_buffer_size",
 			Help: "A count of the user events currently held in the buffer",
@@ -92,6 +208,10 @@ func NewMetrics() *CatalystAPIMetrics {
 			Name: "serf_event_buffer_size",
 			Help: "A count of the serf events currently held in the buffer",
 		}),
+		UserEventTooLargeCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "user_event_too_large_count",
+			Help: "The number of Serf user events not broadcast because they don't fit in a gossip packet, by event name",
+		}, []string{"event"}),
 
 		// /api/vod request metrics
 		UploadVODRequestCount: promauto.NewCounter(prometheus.CounterOpts{
@@ -111,6 +231,11 @@ func NewMetrics() *CatalystAPIMetrics {
 			Name: "catalyst_playback_request_duration_seconds",
 			Help: "The latency of the requests made to /asset/hls in seconds broken up by success and status code",
 		}, []string{"success", "status_code", "version"}),
+		HTTPRequestDurationSec: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "The latency of the requests served by the public and internal API servers, by route template and status code",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"server", "method", "route", "status_code"}),
 		CDNRedirectCount: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "cdn_redirect_count",
 			Help: "Number of requests redirected to CDN",
@@ -127,11 +252,65 @@ func NewMetrics() *CatalystAPIMetrics {
 			Name: "access_control_request_duration_seconds",
 			Help: "The latency of the access control requests",
 		}, []string{"allowed", "playbackID"}),
+		AccessControlGeoBlockedCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "access_control_geo_blocked_count",
+			Help: "The number of playback requests denied because of the geo-restriction policy of the playback ID",
+		}, []string{"playbackID"}),
+		AccessControlBlockedJWTMatchCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "access_control_blocked_jwt_match_count",
+			Help: "The number of playback requests rejected for using a blocked JWT, broken up by where it was blocked (static for -gate-blocked-jwts and the API, list for -gate-blocked-jwts-list)",
+		}, []string{"source"}),
+		AccessControlBlockedJWTListSize: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "access_control_blocked_jwt_list_size",
+			Help: "The number of JWTs in the blocked JWTs list as last loaded",
+		}),
+		AccessControlBlockedJWTReloadCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "access_control_blocked_jwt_reload_count",
+			Help: "The number of reloads of the blocked JWTs list, broken up by result (changed, unchanged or error)",
+		}, []string{"result"}),
 		CatabalancerRequestDurationSec: promauto.NewHistogramVec(prometheus.HistogramOpts{
 			Name:    "catabalancer_request_duration",
 			Help:    "Time taken for catabalancer load balancing requests",
 			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
 		}, []string{"success", "request_type", "mist_match", "background"}),
+		FederationRoutingCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "federation_routing_count",
+			Help: "The number of playback requests routed by the federated balancer, broken up by the cluster chosen",
+		}, []string{"cluster"}),
+		FederationPeerAvailable: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "federation_peer_available",
+			Help: "Whether a peer cluster of the federation is considered for routing (1) or not, e.g. because its circuit breaker is open (0)",
+		}, []string{"cluster"}),
+
+		APIAuthRequestCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "api_auth_request_count",
+			Help: "The total number of authenticated API requests, broken up by token ID, required scope and result",
+		}, []string{"token_id", "scope", "result"}),
+
+		SourcePreflightCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "source_preflight_count",
+			Help: "The total number of source URL pre-flight checks, broken up by result (ok or the error type)",
+		}, []string{"result"}),
+		JanitorReclaimedBytes: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "janitor_reclaimed_bytes",
+			Help: "The total number of bytes deleted by the orphaned files janitor, broken up by location (object_store or local)",
+		}, []string{"location"}),
+		JanitorDeletedCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "janitor_deleted_count",
+			Help: "The total number of files deleted by the orphaned files janitor, broken up by location (object_store or local)",
+		}, []string{"location"}),
+		InjectedFaults: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "injected_faults",
+			Help: "The total number of calls failed on purpose by -fault-inject, broken up by target (upload, broadcaster or mist)",
+		}, []string{"target"}),
+		MistTriggersThrottled: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "mist_triggers_throttled",
+			Help: "The total number of Mist triggers held back by the per-stream rate limit, broken up by trigger and result (delayed, coalesced into a later one of the same stream or dropped)",
+		}, []string{"trigger", "result"}),
+		BroadcasterConnections: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "broadcaster_client_connections",
+			Help: "The total number of connections used to post segments to the broadcasters, broken up by whether an idle one was reused",
+		}, []string{"host", "reused"}),
 
 		// Clients metrics
 		TranscodingStatusUpdate: ClientMetrics{
@@ -198,6 +377,109 @@ func NewMetrics() *CatalystAPIMetrics {
 			}, []string{"host", "operation", "bucket"}),
 		},
 
+		FFmpegMetrics: FFmpegMetrics{
+			Duration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "ffmpeg_subprocess_duration_seconds",
+				Help:    "Time taken by the ffmpeg subprocesses broken up by operation (clip, mux_mp4, mux_fmp4, concat, segment, thumbnail, vmaf)",
+				Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+			}, []string{"operation", "success"}),
+			Failures: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "ffmpeg_subprocess_failures",
+				Help: "The total number of failed ffmpeg subprocesses broken up by operation and reason (start, timeout, killed, error)",
+			}, []string{"operation", "reason"}),
+			CPUTime: promauto.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "ffmpeg_subprocess_cpu_seconds",
+				Help:    "User and system CPU time used by the ffmpeg subprocesses broken up by operation",
+				Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+			}, []string{"operation"}),
+			MaxRSS: promauto.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "ffmpeg_subprocess_max_rss_bytes",
+				Help:    "Peak resident memory of the ffmpeg subprocesses broken up by operation",
+				Buckets: prometheus.ExponentialBuckets(16*1024*1024, 2, 10),
+			}, []string{"operation"}),
+			InFlight: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "ffmpeg_subprocesses_in_flight",
+				Help: "The number of ffmpeg subprocesses running broken up by operation",
+			}, []string{"operation"}),
+		},
+		StorageMetrics: StorageMetrics{
+			OperationDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "storage_operation_duration_seconds",
+				Help:    "Time taken by storage operations (get, upload, sign) broken up by provider and bucket",
+				Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+			}, []string{"operation", "provider", "bucket", "success"}),
+			OperationErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "storage_operation_errors",
+				Help: "The total number of failed storage operations broken up by provider, bucket and error class (timeout, 403, 404, 4xx, 5xx, other)",
+			}, []string{"operation", "provider", "bucket", "error_class"}),
+			ThrottleEvents: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "storage_throttle_events",
+				Help: "The total number of storage operations the provider asked us to back off from (429, 503, SlowDown) broken up by provider and bucket",
+			}, []string{"operation", "provider", "bucket"}),
+			UploadParallelism: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "storage_upload_parallelism",
+				Help: "The number of uploads currently allowed to run in parallel to each bucket, lowered while the provider throttles us",
+			}, []string{"provider", "bucket"}),
+			MirrorWrites: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "storage_mirror_writes",
+				Help: "The total number of uploads to a primary store copied to its backup store, broken up by result (mirrored, failed, dropped when the queue is full, skipped for the recording playlists whose segments failed to be copied) and primary store",
+			}, []string{"result", "store"}),
+			MirrorQueueDepth: promauto.NewGauge(prometheus.GaugeOpts{
+				Name: "storage_mirror_queue_depth",
+				Help: "The number of uploads to a primary store waiting to be copied to its backup store",
+			}),
+		},
+
+		CatabalancerMetrics: CatabalancerMetrics{
+			NodeUsage: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "catabalancer_node_usage",
+				Help: "The latest resource usage reported by each node to the catabalancer: cpu, ram and bandwidth percentages, and the 5 minute load average",
+			}, []string{"node", "resource"}),
+			NodeStatsAge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "catabalancer_node_stats_age_seconds",
+				Help: "How long ago the latest stats of each node were reported, the nodes being ignored once it reaches -catabalancer-metric-timeout",
+			}, []string{"node"}),
+			NodeDraining: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "catabalancer_node_draining",
+				Help: "Whether each node is draining for maintenance (1) and so not picked for new viewers",
+			}, []string{"node"}),
+			NodeScore: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "catabalancer_node_score",
+				Help: "The scores of each node, from 0 (bad) to 2 (good), the last time it was considered for a viewer: load, geo, stream and their total",
+			}, []string{"node", "score"}),
+			NodeGeoDistance: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "catabalancer_node_geo_distance_km",
+				Help: "The distance between each node and the viewer the last time it was considered for one",
+			}, []string{"node"}),
+			NodeSelected: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "catabalancer_node_selected",
+				Help: "The total number of viewers and broadcasters sent to each node by the catabalancer",
+			}, []string{"node"}),
+		},
+
+		CacheMetrics: CacheMetrics{
+			Hits: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "cache_hits",
+				Help: "The total number of lookups found in the in-memory caches (jobs, mist_state, gate_decisions) or their shared backend",
+			}, []string{"cache"}),
+			Misses: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "cache_misses",
+				Help: "The total number of lookups not found in the in-memory caches or their shared backend",
+			}, []string{"cache"}),
+			Evictions: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "cache_evictions",
+				Help: "The total number of entries dropped from the in-memory caches before being removed, by reason (expired, size)",
+			}, []string{"cache", "reason"}),
+			Size: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "cache_size",
+				Help: "The number of entries held by the in-memory caches",
+			}, []string{"cache"}),
+			BackendErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "cache_backend_errors",
+				Help: "The total number of failed requests to the shared backend (e.g. Redis) of the caches",
+			}, []string{"cache"}),
+		},
+
 		VODPipelineMetrics: VODPipelineMetrics{
 			Count: promauto.NewCounterVec(prometheus.CounterOpts{
 				Name: "vod_count",
@@ -223,6 +505,27 @@ func NewMetrics() *CatalystAPIMetrics {
 				Name: "vod_source_duration",
 				Help: "Duration of the source asset",
 			}, vodLabels),
+			TransferBytes: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "vod_transfer_bytes",
+				Help: "Bytes moved over the network by the VOD jobs, broken up by pipeline and direction (download, upload or broadcaster)",
+			}, []string{"pipeline", "direction"}),
+			DTSHHeaders: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "vod_dtsh_headers",
+				Help: "The Mist headers (.dtsh) of the MP4 outputs checked once the VOD jobs complete, by output type and result (valid, generated, repaired or failed)",
+			}, []string{"type", "result"}),
+			FeedImportItems: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "vod_feed_import_items",
+				Help: "The items of the imported RSS feeds, by feed and result (enqueued, then imported or failed once their job is done, skipped when already imported or being imported, no_media, or invalid_url for enclosures not on a public HTTP(S) server or HLS playlists)",
+			}, []string{"feed_id", "result"}),
+			JobErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+				Name: "vod_job_errors",
+				Help: "The errors the VOD jobs failed with, by pipeline, error class (e.g. not_found, access_denied, unsupported_input, storage, broadcaster or timeout) and whether they were reported as unretriable",
+			}, []string{"pipeline", "class", "unretriable"}),
+			SourcePlayback: promauto.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "vod_source_playback_latency",
+				Help:    "Seconds from a VOD job being accepted until the playback manifest of its source is published, by input type (mp4, mov, hls or other)",
+				Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600},
+			}, []string{"input_type"}),
 		},
 
 		AnalyticsMetrics: AnalyticsMetrics{
@@ -234,6 +537,10 @@ func NewMetrics() *CatalystAPIMetrics {
 				Name: "analytics_logs_buffer_time_ms",
 				Help: "Buffer time in milliseconds gathered from Analytics Logs",
 			}, []string{"playback_id", "user_id", "project_id", "continent"}),
+			AnalyticsHeatmapViewers: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "analytics_heatmap_viewers",
+				Help: "Viewers of a stream by country over the last window of the viewer heatmaps, from the analytics heartbeats received by this node",
+			}, []string{"playback_id", "country"}),
 			LogProcessorWriteErrors: promauto.NewCounter(prometheus.CounterOpts{
 				Name: "log_processor_write_errors",
 				Help: "Number of log processors errors while writing to Kafka",
@@ -15,7 +15,8 @@ type Retries struct {
 }
 
 func MonitorRequest(clientMetrics ClientMetrics, client *http.Client, r *http.Request) (*http.Response, error) {
-	ctx := context.Background()
+	// Keeps the values of the request's context, e.g. its request ID, but not its cancellation
+	ctx := context.WithoutCancel(r.Context())
 	ctx = context.WithValue(ctx, RetriesKey, &Retries{-1, 0})
 	req := r.WithContext(ctx)
 
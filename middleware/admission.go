@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// How long a request turned away is still counted as waiting if its caller doesn't retry it
+const admissionWaitExpiry = 30 * time.Minute
+
+// admissionQueue keeps the requests turned away for lack of capacity by the key their callers retry them with, i.e.
+// their Idempotency-Key or external ID, to tell how many are waiting and for how long. There's no actual queue: callers
+// retry until they're admitted.
+type admissionQueue struct {
+	mu      sync.Mutex
+	waiting map[string]map[string]time.Time
+	now     func() time.Time
+}
+
+func (q *admissionQueue) time() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// reject counts a request of a job type as waiting from the first time it's turned away and returns how many requests
+// of that type are waiting
+func (q *admissionQueue) reject(jobType, key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	metrics.Metrics.AdmissionRejected.WithLabelValues(jobType).Inc()
+	if q.waiting == nil {
+		q.waiting = map[string]map[string]time.Time{}
+	}
+	if q.waiting[jobType] == nil {
+		q.waiting[jobType] = map[string]time.Time{}
+	}
+	if _, ok := q.waiting[jobType][key]; !ok && key != "" {
+		q.waiting[jobType][key] = q.time()
+	}
+	return q.depth(jobType)
+}
+
+// admit records how long a request that was turned away before waited for
+func (q *admissionQueue) admit(jobType, key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if since, ok := q.waiting[jobType][key]; ok && key != "" {
+		metrics.Metrics.AdmissionWaitSec.WithLabelValues(jobType).Observe(q.time().Sub(since).Seconds())
+		delete(q.waiting[jobType], key)
+	}
+	q.depth(jobType)
+}
+
+// depth returns how many requests of a job type are waiting, forgetting those that weren't retried for too long. Must
+// be called with the lock held.
+func (q *admissionQueue) depth(jobType string) int {
+	now := q.time()
+	for key, since := range q.waiting[jobType] {
+		if now.Sub(since) > admissionWaitExpiry {
+			delete(q.waiting[jobType], key)
+		}
+	}
+	depth := len(q.waiting[jobType])
+	metrics.Metrics.AdmissionQueueDepth.WithLabelValues(jobType).Set(float64(depth))
+	return depth
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmissionQueueTracksRetriedRequests(t *testing.T) {
+	now := time.Now()
+	q := admissionQueue{now: func() time.Time { return now }}
+
+	require.Equal(t, 1, q.reject("vod", "task-1"))
+	require.Equal(t, 2, q.reject("vod", "task-2"))
+	// Retries of a request turned away don't add to the queue, nor do requests that can't be told apart
+	now = now.Add(10 * time.Second)
+	require.Equal(t, 2, q.reject("vod", "task-1"))
+	require.Equal(t, 2, q.reject("vod", ""))
+	require.Equal(t, 0, q.reject("clip", ""))
+	require.Equal(t, float64(2), testutil.ToFloat64(metrics.Metrics.AdmissionQueueDepth.WithLabelValues("vod")))
+
+	// Waited since it was first turned away
+	now = now.Add(20 * time.Second)
+	q.admit("vod", "task-1")
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.Metrics.AdmissionQueueDepth.WithLabelValues("vod")))
+
+	// Forgotten once not retried for too long
+	now = now.Add(admissionWaitExpiry)
+	require.Equal(t, 1, q.reject("vod", "task-3"))
+}
@@ -5,25 +5,41 @@ import (
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/metrics"
 )
 
-func IsAuthorized(apiToken string, next httprouter.Handle) httprouter.Handle {
+// IsAuthorized checks that the request carries one of the configured API tokens and that the token has been granted
+// the scope required by the route. The ID of the token is recorded for logging and metrics, never the secret itself.
+func IsAuthorized(tokens config.APITokens, scope string, next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		authHeader := r.Header.Get("Authorization")
 
 		if authHeader == "" {
+			metrics.Metrics.APIAuthRequestCount.WithLabelValues("", scope, "unauthorized").Inc()
 			errors.WriteHTTPUnauthorized(w, "No authorization header", nil)
 			return
 		}
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-
-		if token != apiToken {
+		token, ok := tokens.Lookup(strings.TrimPrefix(authHeader, "Bearer "))
+		if !ok {
+			metrics.Metrics.APIAuthRequestCount.WithLabelValues("", scope, "unauthorized").Inc()
 			errors.WriteHTTPUnauthorized(w, "Invalid Token", nil)
 			return
 		}
 
-		next(w, r, ps)
+		if rw, ok := w.(*responseWriter); ok {
+			rw.tokenID = token.ID
+		}
+
+		if !token.HasScope(scope) {
+			metrics.Metrics.APIAuthRequestCount.WithLabelValues(token.ID, scope, "forbidden").Inc()
+			errors.WriteHTTPForbidden(w, "Token is missing the required scope", nil)
+			return
+		}
+
+		metrics.Metrics.APIAuthRequestCount.WithLabelValues(token.ID, scope, "authorized").Inc()
+		next(w, r.WithContext(config.WithAPITokenID(r.Context(), token.ID)), ps)
 	}
 }
@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"sync/atomic"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/concurrency"
 	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/handlers"
+	"github.com/livepeer/catalyst-api/maintenance"
 	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/livepeer/catalyst-api/pipeline"
 )
@@ -18,12 +22,20 @@ import (
 type CapacityMiddleware struct {
 	vodRequestsInFlight  atomic.Int64
 	clipRequestsInFlight atomic.Int64
+	queue                admissionQueue
 }
 
 func (c *CapacityMiddleware) HasCapacity(vodEngine *pipeline.Coordinator, next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		var vodJobCount, clipJobCount int
 
+		// New jobs go to other nodes while this one drains for maintenance
+		if maintenance.Node.Draining() {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
 		// Keep a gauge of HTTP requests in flight
 		metrics.Metrics.HTTPRequestsInFlight.Add(1)
 		defer metrics.Metrics.HTTPRequestsInFlight.Add(-1)
@@ -41,41 +53,47 @@ func (c *CapacityMiddleware) HasCapacity(vodEngine *pipeline.Coordinator, next h
 		}
 
 		// Get this current request's job type (i.e. clipping or regular-vod request)
-		isClip, err := isClipRequest(r)
+		isClip, key, err := parseAdmissionRequest(r)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		// Compare limits for clipping vs regular-vod jobs
+		jobType, jobCount, maxJobs := "vod", vodJobCount, concurrency.Tuner.MaxInFlightJobs()
+		inFlightReqs := &c.vodRequestsInFlight
 		if isClip {
-			inFlightClipReqs := c.clipRequestsInFlight.Add(1)
-			defer c.clipRequestsInFlight.Add(-1)
-
-			if clipJobCount+int(inFlightClipReqs) >= config.MaxInFlightClipJobs {
-				w.WriteHeader(http.StatusTooManyRequests)
-				return
-			}
-		} else {
-			inFlightVodReqs := c.vodRequestsInFlight.Add(1)
-			defer c.vodRequestsInFlight.Add(-1)
-
-			if vodJobCount+int(inFlightVodReqs) >= config.MaxInFlightJobs {
-				w.WriteHeader(http.StatusTooManyRequests)
-				return
-			}
+			jobType, jobCount, maxJobs = "clip", clipJobCount, config.MaxInFlightClipJobs
+			inFlightReqs = &c.clipRequestsInFlight
+		}
+		inFlight := inFlightReqs.Add(1)
+		defer inFlightReqs.Add(-1)
+
+		if jobCount+int(inFlight) >= maxJobs {
+			waiting := c.queue.reject(jobType, key)
+			retryAfter := vodEngine.EstimateRetryAfter(isClip, waiting)
+			errors.WriteHTTPBackpressure(w, "Too many jobs in flight", errors.Backpressure{
+				JobType:         jobType,
+				InFlightJobs:    jobCount,
+				MaxInFlightJobs: maxJobs,
+				QueueDepth:      waiting,
+				RetryAfterSecs:  int(math.Ceil(retryAfter.Seconds())),
+			})
+			return
 		}
+		c.queue.admit(jobType, key)
 
 		next(w, r, ps)
 	}
 }
 
-func isClipRequest(r *http.Request) (bool, error) {
+// parseAdmissionRequest returns whether the request is for a clip and the key its caller retries it with, if any
+func parseAdmissionRequest(r *http.Request) (bool, string, error) {
 
 	if r == nil {
-		return false, fmt.Errorf("request is empty")
+		return false, "", fmt.Errorf("request is empty")
 	}
 	if r.Body == nil {
-		return false, fmt.Errorf("request body is empty")
+		return false, "", fmt.Errorf("request body is empty")
 
 	}
 	var buf bytes.Buffer
@@ -87,15 +105,20 @@ func isClipRequest(r *http.Request) (bool, error) {
 	b := handlers.UploadVODRequest{}
 	err := decoder.Decode(&b)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	// Reset request body so the next http handlers can continue processing the request
 	r.Body = io.NopCloser(&buf)
 
+	key := r.Header.Get(handlers.IdempotencyKeyHeader)
+	if key == "" {
+		key = b.ExternalID
+	}
+
 	// Check if current request is a clipping request
 	if b.ClipStrategy.PlaybackID != "" {
-		return true, nil
+		return true, key, nil
 	}
-	return false, nil
+	return false, key, nil
 }
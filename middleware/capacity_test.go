@@ -13,6 +13,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/handlers"
 	"github.com/livepeer/catalyst-api/pipeline"
 	"github.com/livepeer/catalyst-api/video"
@@ -111,6 +112,11 @@ func TestItErrorsWhenNoRegularVodJobCapacityAvailable(t *testing.T) {
 	handler(responseRecorder, vodReq, nil)
 	// Confirm we got an HTTP 429 response
 	require.Equal(t, http.StatusTooManyRequests, responseRecorder.Code)
+	// With how long to back off for, no job having finished to estimate it from
+	require.Equal(t, "60", responseRecorder.Header().Get("Retry-After"))
+	var body errors.Backpressure
+	require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, errors.Backpressure{JobType: "vod", InFlightJobs: config.MaxInFlightJobs, MaxInFlightJobs: config.MaxInFlightJobs, RetryAfterSecs: 60}, body)
 	// Confirm the handler didn't call the next middleware
 	require.False(t, nextCalled)
 
@@ -3,6 +3,7 @@ package middleware
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/livepeer/catalyst-api/config"
@@ -17,11 +18,18 @@ import (
 
 type GatingHandler struct {
 	AccessControl *accesscontrol.AccessControlHandlersCollection
+	// Verifies the signed playback URLs, which are let through without asking the gate API. Nil when disabled.
+	URLSigner *playback.URLSigner
+	// Denies the requests without a signed URL token instead of asking the gate API
+	RequireSignedURLs bool
 }
 
 func NewGatingHandler(cli config.Cli, mapic mistapiconnector.IMac) *GatingHandler {
+	signer := playback.NewURLSigner(cli.PlaybackSigningKey)
 	return &GatingHandler{
-		AccessControl: accesscontrol.NewAccessControlHandlersCollection(cli, mapic),
+		AccessControl:     accesscontrol.NewAccessControlHandlersCollection(cli, mapic),
+		URLSigner:         signer,
+		RequireSignedURLs: signer != nil && cli.PlaybackRequireSignedURLs,
 	}
 }
 
@@ -30,6 +38,21 @@ func (h *GatingHandler) GatingCheck(next httprouter.Handle) httprouter.Handle {
 		requestID := requests.GetRequestId(req)
 
 		playbackID := params.ByName("playbackID")
+		if token := req.URL.Query().Get(playback.SignedURLParam); h.URLSigner != nil && token != "" {
+			if err := h.URLSigner.Verify(token, req.URL.Path, time.Now()); err != nil {
+				log.Log(requestID, "signed playback url denied", "playbackID", playbackID, "err", err, "url", req.URL.Redacted())
+				deny(params.ByName("file"), w)
+				return
+			}
+			next(w, req, params)
+			return
+		}
+		if h.RequireSignedURLs {
+			log.Log(requestID, "playback denied without a signed url", "playbackID", playbackID, "url", req.URL.Redacted())
+			deny(params.ByName("file"), w)
+			return
+		}
+
 		accessKey := req.URL.Query().Get("accessKey")
 		jwt := req.URL.Query().Get("jwt")
 
@@ -61,6 +84,11 @@ func (h *GatingHandler) GatingCheck(next httprouter.Handle) httprouter.Handle {
 		}
 
 		playbackAccessControlAllowed, err := h.AccessControl.IsAuthorized(req.Context(), playbackID, &payload)
+		if errors.Is(err, catErrs.GeoBlocked) {
+			log.Log(requestID, "playback geo-blocked", "playbackID", playbackID, "url", req.URL.Redacted())
+			catErrs.WriteHTTPUnavailableForLegalReasons(w, "playback is not available in your country", err)
+			return
+		}
 		if err != nil {
 			log.LogError(requestID, "unable to get playback access control info", err, "playbackID", playbackID, "accessKey", accessKey, "jwt", jwt, "url", req.URL.Redacted())
 			if errors.Is(err, catErrs.InvalidJWT) {
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/handlers"
+	"github.com/livepeer/catalyst-api/playback"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedPlaybackURLs(t *testing.T) {
+	signer := playback.NewURLSigner("signing-key")
+	gating := &GatingHandler{URLSigner: signer, RequireSignedURLs: true}
+
+	router := httprouter.New()
+	router.POST("/api/playback/sign", IsAuthorized(testTokens, config.ScopePlaybackSign, handlers.NewSignedPlaybackHandler(signer).Sign()))
+	router.GET("/asset/hls/:playbackID/*file", gating.GatingCheck(func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	sign := func(body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/playback/sign", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(req)
+	}
+	require.Equal(t, http.StatusForbidden, sign(`{"playback_id": "abc123"}`, "IUpload").Code)
+	require.Equal(t, http.StatusBadRequest, sign(`{"playback_id": "../abc123"}`, "IAmAuthorized").Code)
+	require.Equal(t, http.StatusBadRequest, sign(`{"playback_id": "abc123", "file": "../other/index.m3u8"}`, "IAmAuthorized").Code)
+	require.Equal(t, http.StatusBadRequest, sign(`{"playback_id": "abc123", "expires_in": 604801}`, "IAmAuthorized").Code)
+	require.Equal(t, http.StatusBadRequest, sign(`{"playback_id": "abc123", "expires_in": 9223372036854775807}`, "IAmAuthorized").Code)
+
+	rr := sign(`{"playback_id": "abc123", "expires_in": 60}`, "IAmAuthorized")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var signed handlers.SignPlaybackResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &signed))
+	require.True(t, strings.HasPrefix(signed.URL, "/asset/hls/abc123/index.m3u8?token="))
+	require.WithinDuration(t, time.Now().Add(time.Minute), signed.ExpiresAt, 2*time.Second)
+
+	require.Equal(t, http.StatusNoContent, serve(httptest.NewRequest(http.MethodGet, signed.URL, nil)).Code)
+	require.Equal(t, http.StatusNoContent, serve(httptest.NewRequest(http.MethodGet, "/asset/hls/abc123/720p0/0.ts?token="+signed.Token, nil)).Code)
+	require.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodGet, "/asset/hls/other/0.ts?token="+signed.Token, nil)).Code)
+	require.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodGet, "/asset/hls/abc123/0.ts", nil)).Code)
+
+	// playlists are denied with an HLS error for the players to stop
+	rr = serve(httptest.NewRequest(http.MethodGet, "/asset/hls/abc123/index.m3u8?token=invalid", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "#EXT-X-ERROR")
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// LimitConcurrency caps the requests handled at once by the given routes, keyed by route template as registered, e.g.
+// "/api/mist/trigger". The requests over the cap are turned away with a 503 straight away, rather than piling up
+// behind the slow ones. Each method of a route has its own cap. Only applies to the routes registered after it's
+// called.
+func (r *Router) LimitConcurrency(caps map[string]int) {
+	r.concurrency = caps
+}
+
+func (r *Router) limitConcurrency(route string, next httprouter.Handle) httprouter.Handle {
+	limit := r.concurrency[route]
+	if limit <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, limit)
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next(w, req, ps)
+		default:
+			metrics.Metrics.HTTPRouteRequestsDropped.WithLabelValues(r.server, route).Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteConcurrencyCap(t *testing.T) {
+	router := NewRouter("test-limits")
+	router.LimitConcurrency(map[string]int{"/slow": 1})
+	started, release := make(chan struct{}), make(chan struct{})
+	router.POST("/slow", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		started <- struct{}{}
+		<-release
+	})
+	router.POST("/fast", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {})
+
+	serve := func(path string) int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+		return rr.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- serve("/slow") }()
+	<-started
+
+	// The cap is reached, other routes are unaffected
+	require.Equal(t, http.StatusServiceUnavailable, serve("/slow"))
+	require.Equal(t, http.StatusOK, serve("/fast"))
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+	go func() { <-started }()
+	require.Equal(t, http.StatusOK, serve("/slow"))
+	require.Equal(t, map[string]uint64{
+		"POST /slow 200": 2,
+		"POST /slow 503": 1,
+		"POST /fast 200": 1,
+	}, observedRequests(t, "test-limits"))
+}
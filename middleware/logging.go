@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"bufio"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strconv"
@@ -12,13 +15,20 @@ import (
 	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// RequestIDHeader carries the ID used in the access logs. Taken from the request when set by the caller, generated
+// otherwise, and always returned in the response.
+const RequestIDHeader = "X-Request-Id"
+
 type responseWriter struct {
 	http.ResponseWriter
 	status      int
 	wroteHeader bool
+	// tokenID is set by the auth middleware once the request has been authenticated
+	tokenID string
 }
 
 func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
@@ -35,54 +45,165 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.wroteHeader = true
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
 func (rw *responseWriter) Flush() {
 	flusher := rw.ResponseWriter.(http.Flusher)
 	flusher.Flush()
 }
 
-func LogAndMetrics(metric *prometheus.SummaryVec) func(httprouter.Handle) httprouter.Handle {
-	return logRequest(metric)
+// Hijack lets WebSocket handlers take over the connection
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.status = http.StatusSwitchingProtocols
+	rw.wroteHeader = true
+	return hijacker.Hijack()
+}
+
+// statusCode returns the status sent to the client, which is a 200 when the handler didn't write anything
+func (rw *responseWriter) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Router logs every request of the routes registered through it and records their latency, labelled by the route
+// template rather than the path so that the path parameters don't blow up the metric cardinality. Routes registered
+// directly on the embedded httprouter.Router aren't logged.
+type Router struct {
+	*httprouter.Router
+	server      string
+	concurrency map[string]int
+}
+
+// NewRouter creates a Router whose logs and metrics are labelled with the given server name, e.g. "public"
+func NewRouter(server string) *Router {
+	return &Router{Router: httprouter.New(), server: server}
+}
+
+func (r *Router) Handle(method, path string, handle httprouter.Handle) {
+	r.HandleSampled(method, path, 1, handle)
+}
+
+// HandleSampled only logs the given fraction of the successful requests, for high volume routes like playback, or all
+// of them at -v=4. The failed requests are always logged and the metrics always recorded.
+func (r *Router) HandleSampled(method, path string, sampleRate float64, handle httprouter.Handle) {
+	r.Router.Handle(method, path, logRequest(r.server, path, sampleRate)(r.limitConcurrency(path, handle)))
+}
+
+// SetNotFound handles the requests that don't match any route, logging them under the given route name
+func (r *Router) SetNotFound(route string, sampleRate float64, handle httprouter.Handle) {
+	h := logRequest(r.server, route, sampleRate)(handle)
+	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h(w, req, httprouter.Params{})
+	})
+}
+
+func (r *Router) GET(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodGet, path, handle)
 }
 
-func LogRequest() func(httprouter.Handle) httprouter.Handle {
-	return logRequest(nil)
+func (r *Router) HEAD(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodHead, path, handle)
 }
 
-func logRequest(metric *prometheus.SummaryVec) func(httprouter.Handle) httprouter.Handle {
+func (r *Router) OPTIONS(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodOptions, path, handle)
+}
+
+func (r *Router) POST(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPost, path, handle)
+}
+
+func (r *Router) PUT(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPut, path, handle)
+}
+
+func (r *Router) PATCH(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPatch, path, handle)
+}
+
+func (r *Router) DELETE(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodDelete, path, handle)
+}
+
+// RecordDuration records the latency of the requests in a route specific summary, on top of the histogram recorded
+// for all routes
+func RecordDuration(metric *prometheus.SummaryVec) func(httprouter.Handle) httprouter.Handle {
 	return func(next httprouter.Handle) httprouter.Handle {
-		fn := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			start := time.Now()
+			wrapped, ok := w.(*responseWriter)
+			if !ok {
+				wrapped = wrapResponseWriter(w)
+			}
+
+			next(wrapped, r, ps)
+
+			status := wrapped.statusCode()
+			metric.
+				WithLabelValues(strconv.FormatBool(status < 400), fmt.Sprint(status), config.Version).
+				Observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+func logRequest(server, route string, sampleRate float64) func(httprouter.Handle) httprouter.Handle {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 			start := time.Now()
 			wrapped := wrapResponseWriter(w)
 
+			requestID := r.Header.Get(RequestIDHeader)
+			if requestID == "" {
+				requestID = config.RandomTrailer(8)
+			}
+			wrapped.Header().Set(RequestIDHeader, requestID)
+
 			defer func() {
 				if err := recover(); err != nil {
 					errors.WriteHTTPInternalServerError(wrapped, "Internal Server Error", nil)
-					log.LogNoRequestID("returning HTTP 500", "err", err, "trace", debug.Stack())
+					log.LogNoRequestID("returning HTTP 500", "request_id", requestID, "method", r.Method, "route", route, "err", err, "trace", debug.Stack())
 				}
 			}()
 
 			next(wrapped, r, ps)
 			duration := time.Since(start)
+			status := wrapped.statusCode()
 
-			if glog.V(4) || wrapped.status >= 400 {
-				log.LogNoRequestID("received HTTP request",
-					"remote", r.RemoteAddr,
-					"proto", r.Proto,
-					"method", r.Method,
-					"uri", r.URL.RequestURI(),
-					"duration", duration,
-					"status", wrapped.status,
-				)
-			}
+			metrics.Metrics.HTTPRequestDurationSec.
+				WithLabelValues(server, r.Method, route, fmt.Sprint(status)).
+				Observe(duration.Seconds())
 
-			if metric != nil {
-				success := wrapped.status < 400
-				metric.
-					WithLabelValues(strconv.FormatBool(success), fmt.Sprint(wrapped.status), config.Version).
-					Observe(duration.Seconds())
+			if status < 400 && sampleRate < 1 && !glog.V(4) && rand.Float64() >= sampleRate {
+				return
+			}
+			// not logged with log.Log, which would cache a logger for every request ID
+			keyvals := []interface{}{
+				"request_id", requestID,
+				"server", server,
+				"method", r.Method,
+				"route", route,
+				"path", r.URL.Path,
+				"query", log.RedactQuery(r.URL.RawQuery),
+				"status", status,
+				"duration", duration,
+				"remote", r.RemoteAddr,
+				"proto", r.Proto,
 			}
+			if wrapped.tokenID != "" {
+				keyvals = append(keyvals, "token_id", wrapped.tokenID)
+			}
+			log.LogNoRequestID("received HTTP request", keyvals...)
 		}
-
-		return fn
 	}
 }
@@ -6,10 +6,19 @@ import (
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/livepeer/catalyst-api/config"
 	"github.com/livepeer/catalyst-api/handlers"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 )
 
+var testTokens = config.APITokens{
+	{ID: config.DefaultAPITokenID, Token: "IAmAuthorized", Scopes: []string{config.ScopeAll}},
+	{ID: "uploader", Token: "IUpload", Scopes: []string{config.ScopeVODWrite}},
+}
+
 func TestNoAuthHeader(t *testing.T) {
 	require := require.New(t)
 
@@ -17,11 +26,11 @@ func TestNoAuthHeader(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/ok", nil)
 	rr := httptest.NewRecorder()
 	catalystApiHandlers := handlers.CatalystAPIHandlersCollection{}
-	router.GET("/ok", IsAuthorized("IAmAuthorized", catalystApiHandlers.Ok()))
+	router.GET("/ok", IsAuthorized(testTokens, config.ScopeAdminRead, catalystApiHandlers.Ok()))
 	router.ServeHTTP(rr, req)
 
 	require.Equal(rr.Code, 401, "should return 401")
-	require.JSONEq(rr.Body.String(), `{"error":"No authorization header", "error_detail":""}`)
+	require.JSONEq(rr.Body.String(), `{"error":"No authorization header", "error_detail":"", "error_code":"UNAUTHORIZED"}`)
 }
 
 func TestWrongKey(t *testing.T) {
@@ -33,9 +42,89 @@ func TestWrongKey(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	catalystApiHandlers := handlers.CatalystAPIHandlersCollection{}
-	router.GET("/ok", IsAuthorized("IAmAuthorized", catalystApiHandlers.Ok()))
+	router.GET("/ok", IsAuthorized(testTokens, config.ScopeAdminRead, catalystApiHandlers.Ok()))
 	router.ServeHTTP(rr, req)
 
 	require.Equal(rr.Code, 401, "should return 401")
-	require.JSONEq(rr.Body.String(), `{"error":"Invalid Token", "error_detail":""}`)
+	require.JSONEq(rr.Body.String(), `{"error":"Invalid Token", "error_detail":"", "error_code":"UNAUTHORIZED"}`)
+}
+
+func TestTokenScopes(t *testing.T) {
+	require := require.New(t)
+
+	router := NewRouter("test")
+	catalystApiHandlers := handlers.CatalystAPIHandlersCollection{}
+	router.GET("/ok", IsAuthorized(testTokens, config.ScopeAdminRead, catalystApiHandlers.Ok()))
+	router.GET("/vod", IsAuthorized(testTokens, config.ScopeVODWrite, catalystApiHandlers.Ok()))
+
+	for _, tt := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/ok", "IAmAuthorized", 200},
+		{"/vod", "IAmAuthorized", 200},
+		{"/vod", "IUpload", 200},
+		{"/ok", "IUpload", 403},
+	} {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(tt.code, rr.Code, "path=%s token=%s", tt.path, tt.token)
+	}
+}
+
+func TestRouterRecordsRouteTemplate(t *testing.T) {
+	router := NewRouter("test-route")
+	router.GET("/api/:id/status", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	router.SetNotFound("redirect", 0, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+
+	for _, path := range []string{"/api/123/status", "/api/456/status", "/hls/abc/index.m3u8"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.NotEmpty(t, rr.Header().Get(RequestIDHeader))
+	}
+
+	require.Equal(t, map[string]uint64{
+		"GET /api/:id/status 200": 2,
+		"GET redirect 307":        1,
+	}, observedRequests(t, "test-route"))
+}
+
+// observedRequests returns the number of requests recorded for a server, keyed by method, route and status
+func observedRequests(t *testing.T, server string) map[string]uint64 {
+	ch := make(chan prometheus.Metric, 100)
+	metrics.Metrics.HTTPRequestDurationSec.Collect(ch)
+	close(ch)
+
+	observed := map[string]uint64{}
+	for m := range ch {
+		var pb dto.Metric
+		require.NoError(t, m.Write(&pb))
+		labels := map[string]string{}
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["server"] == server {
+			observed[labels["method"]+" "+labels["route"]+" "+labels["status_code"]] = pb.GetHistogram().GetSampleCount()
+		}
+	}
+	return observed
+}
+
+func TestRouterKeepsCallerRequestID(t *testing.T) {
+	router := NewRouter("test")
+	catalystApiHandlers := handlers.CatalystAPIHandlersCollection{}
+	router.GET("/ok", catalystApiHandlers.Ok())
+
+	req, _ := http.NewRequest("GET", "/ok", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, "abc123", rr.Header().Get(RequestIDHeader))
 }
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MistCleanup is the native implementation of mist-cleanup.sh. It deletes the shared memory pages and semaphores
+// left behind in /dev/shm by Mist streams whose input process is gone.
+type MistCleanup struct {
+	ShmDir  string
+	ProcDir string
+}
+
+func NewMistCleanup() *MistCleanup {
+	return &MistCleanup{ShmDir: "/dev/shm", ProcDir: "/proc"}
+}
+
+func (m *MistCleanup) Run(ctx context.Context) error {
+	entries, err := os.ReadDir(m.ShmDir)
+	if errors.Is(err, os.ErrNotExist) {
+		// no shared memory on this platform, so nothing Mist could have left behind
+		return nil
+	} else if err != nil {
+		return err
+	}
+	processes, err := listProcesses(m.ProcDir)
+	if err != nil {
+		return err
+	}
+	buffers := streamsOf(processes, "MistInBuffer")
+	pulls := streamsOf(processes, "MistInDTSC")
+
+	var dataDeleted, semsDeleted, statesDeleted int
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := entry.Name()
+		var (
+			active  map[string]bool
+			counter *int
+			stream  string
+		)
+		switch {
+		case hasAnyPrefix(name, "sem.MstTRKS", "sem.MstUser", "sem.MstInpt"):
+			active, counter, stream = buffers, &semsDeleted, name[len("sem.MstTRKS"):]
+		case strings.HasPrefix(name, "sem.MstPull_"):
+			active, counter, stream = pulls, &semsDeleted, name[len("sem.MstPull_"):]
+		case hasAnyPrefix(name, "MstData", "MstMeta", "MstTrak"):
+			stream, _, _ = strings.Cut(name[len("MstData"):], "@")
+			active, counter = buffers, &dataDeleted
+		case strings.HasPrefix(name, "MstSTATEgolive"):
+			active, counter, stream = buffers, &statesDeleted, name[len("MstSTATE"):]
+		default:
+			continue
+		}
+		if active[stream] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.ShmDir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("mist-cleanup: failed to delete %s: %s\n", name, err)
+			continue
+		}
+		*counter++
+	}
+	log.Printf("mist-cleanup: Done. Deleted %d data pages, %d semaphores, %d state pages\n", dataDeleted, semsDeleted, statesDeleted)
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamsOf returns the streams that the processes of a Mist binary are running for, i.e. their second argument
+func streamsOf(processes []process, binary string) map[string]bool {
+	streams := map[string]bool{}
+	for _, p := range processes {
+		if len(p.args) > 2 && filepath.Base(p.args[0]) == binary {
+			streams[p.args[2]] = true
+		}
+	}
+	return streams
+}
+
+type process struct {
+	pid  int
+	args []string
+}
+
+// listProcesses reads the command lines of the running processes from procfs, returning none when there's no procfs
+func listProcesses(procDir string) ([]process, error) {
+	entries, err := os.ReadDir(procDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var processes []process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			// the process exited or is a kernel thread
+			continue
+		}
+		processes = append(processes, process{pid: pid, args: strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")})
+	}
+	return processes, nil
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// TaskModeAuto runs the shell script when it's on the PATH and the native implementation otherwise
+	TaskModeAuto   = "auto"
+	TaskModeShell  = "shell"
+	TaskModeNative = "native"
+)
+
+// Task is a job run periodically in the background, either a shell script or its native implementation
+type Task interface {
+	Run() error
+	RunBg() *time.Ticker
+}
+
+// NewTask returns the shell script or the native implementation of a periodic task depending on the mode, so that
+// nodes without the scripts (e.g. minimal or non-Linux images) can still run it
+func NewTask(mode string, interval, timeout time.Duration, script string, native func(ctx context.Context) error) (Task, error) {
+	switch mode {
+	case TaskModeShell:
+		return NewShell(interval, timeout, script)
+	case TaskModeNative:
+		return NewNativeTask(interval, timeout, script, native)
+	case TaskModeAuto, "":
+		if _, err := exec.LookPath(script); err == nil {
+			return NewShell(interval, timeout, script)
+		}
+		return NewNativeTask(interval, timeout, script, native)
+	}
+	return nil, fmt.Errorf("invalid periodic task mode %q, must be one of %s, %s or %s", mode, TaskModeAuto, TaskModeShell, TaskModeNative)
+}
+
+// NativeTask periodically runs a Go function with a timeout, the same way Shell runs a command
+type NativeTask struct {
+	mu           sync.Mutex
+	Name         string
+	Fn           func(ctx context.Context) error
+	IntervalSecs time.Duration
+	TimeoutSecs  time.Duration
+}
+
+func NewNativeTask(interval, timeout time.Duration, name string, fn func(ctx context.Context) error) (*NativeTask, error) {
+	if interval < 0 {
+		return &NativeTask{}, fmt.Errorf("task needs to be set with a valid interval value")
+	}
+	if timeout < 0 {
+		return &NativeTask{}, fmt.Errorf("task needs a valid timeout value")
+	}
+	return &NativeTask{
+		Name:         name,
+		Fn:           fn,
+		IntervalSecs: interval,
+		TimeoutSecs:  timeout,
+	}, nil
+}
+
+func (t *NativeTask) RunBg() *time.Ticker {
+	ticker := time.NewTicker(t.IntervalSecs)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := t.Run(); err != nil {
+				glog.Errorf("task: %s failed: %s", t.Name, err)
+			}
+		}
+	}()
+	return ticker
+}
+
+func (t *NativeTask) Run() error {
+	// like the scripts' lock files, so that a slow run doesn't overlap with the next one
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.TimeoutSecs)
+	defer cancel()
+
+	log.Printf("task: running: %s (native), interval:%s, timeout:%s\n", t.Name, t.IntervalSecs, t.TimeoutSecs)
+	return t.Fn(ctx)
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFakeProcess(t *testing.T, procDir string, pid string, stat string, args ...string) {
+	dir := filepath.Join(procDir, pid)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.Join(args, "\x00")+"\x00"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644))
+}
+
+func TestNewTaskModes(t *testing.T) {
+	native := func(ctx context.Context) error { return nil }
+
+	task, err := NewTask(TaskModeNative, time.Second, time.Second, "echo", native)
+	require.NoError(t, err)
+	require.IsType(t, &NativeTask{}, task)
+
+	task, err = NewTask(TaskModeShell, time.Second, time.Second, "not-a-script.sh", native)
+	require.NoError(t, err)
+	require.IsType(t, &Shell{}, task)
+
+	// auto only runs scripts that exist
+	task, err = NewTask(TaskModeAuto, time.Second, time.Second, "not-a-script.sh", native)
+	require.NoError(t, err)
+	require.IsType(t, &NativeTask{}, task)
+	task, err = NewTask(TaskModeAuto, time.Second, time.Second, "echo", native)
+	require.NoError(t, err)
+	require.IsType(t, &Shell{}, task)
+
+	_, err = NewTask("cron", time.Second, time.Second, "echo", native)
+	require.ErrorContains(t, err, "invalid periodic task mode")
+}
+
+func TestNativeTaskTimesOut(t *testing.T) {
+	task, err := NewNativeTask(10*time.Second, 10*time.Millisecond, "sleep", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.NoError(t, err)
+	require.True(t, errors.Is(task.Run(), context.DeadlineExceeded))
+
+	_, err = NewNativeTask(-1, time.Second, "sleep", nil)
+	require.ErrorContains(t, err, "valid interval")
+}
+
+func TestMistCleanupDeletesPagesOfStoppedStreams(t *testing.T) {
+	shmDir, procDir := t.TempDir(), t.TempDir()
+	writeFakeProcess(t, procDir, "10", "", "/usr/bin/MistInBuffer", "-s", "video+live")
+	writeFakeProcess(t, procDir, "11", "", "MistInDTSC", "-s", "video+pulled")
+	for _, name := range []string{
+		"sem.MstTRKSvideo+live", "sem.MstTRKSvideo+gone", "sem.MstUservideo+gone",
+		"sem.MstPull_video+pulled", "sem.MstPull_video+gone",
+		"MstDatavideo+live@1_0", "MstMetavideo+gone@2", "MstTrakvideo+gone",
+		"MstSTATEgolive+video", "MstSTATEgolive+gone",
+		"unrelated",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(shmDir, name), nil, 0644))
+	}
+	// golive+video has a buffer
+	writeFakeProcess(t, procDir, "12", "", "MistInBuffer", "-s", "golive+video")
+
+	m := &MistCleanup{ShmDir: shmDir, ProcDir: procDir}
+	require.NoError(t, m.Run(context.Background()))
+
+	entries, err := os.ReadDir(shmDir)
+	require.NoError(t, err)
+	var remaining []string
+	for _, e := range entries {
+		remaining = append(remaining, e.Name())
+	}
+	require.ElementsMatch(t, []string{
+		"sem.MstTRKSvideo+live", "sem.MstPull_video+pulled", "MstDatavideo+live@1_0", "MstSTATEgolive+video", "unrelated",
+	}, remaining)
+}
+
+func TestNativeTasksWithoutProcfs(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	require.NoError(t, (&MistCleanup{ShmDir: missing, ProcDir: missing}).Run(context.Background()))
+	require.NoError(t, (&PodMon{ProcDir: missing, Processes: []string{"MistController"}}).Run(context.Background()))
+}
+
+func TestPodMonUsage(t *testing.T) {
+	procDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "uptime"), []byte("1000.00 3000.00\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(procDir, "meminfo"), []byte("MemTotal:       1000000 kB\nMemFree:  10 kB\n"), 0644))
+	// started at 500s with 50s of user and 50s of system time, i.e. 20% CPU
+	rssPages := int64(1024000 / os.Getpagesize())
+	stat := func(utime, stime string) string {
+		fields := strings.Fields("S 1 1 1 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 0 1000 0")
+		fields[11], fields[12] = utime, stime
+		fields[19] = "50000"
+		fields[21] = strconv.FormatInt(rssPages, 10)
+		return "42 (Mist Controller) " + strings.Join(fields, " ")
+	}
+	writeFakeProcess(t, procDir, "42", stat("5000", "5000"), "MistController", "-c", "/etc/mistserver.conf")
+	writeFakeProcess(t, procDir, "43", stat("2500", "2500"), "MistController", "-c", "/etc/other.conf")
+	writeFakeProcess(t, procDir, "44", stat("0", "0"), "sleep", "10")
+
+	usage, err := (&PodMon{ProcDir: procDir, Processes: []string{"MistController", "MistUtilLoad"}}).usage()
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	require.Equal(t, "MistController", usage[0].Process)
+	require.InDelta(t, 20, usage[0].MaxCPUPerCore, 0.01)
+	require.InDelta(t, 0.1, usage[0].AvgMemPerProcess, 0.01)
+	require.Equal(t, processUsage{Process: "MistUtilLoad"}, usage[1])
+}
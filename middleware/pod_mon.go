@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Clock ticks per second of the times in /proc/<pid>/stat, which is 100 on all the platforms we run on
+const clockTicks = 100
+
+// PodMon is the native implementation of pod-mon.sh. It logs the CPU and memory usage of the Mist and catalyst
+// processes, so that resource usage shows up in line with the catalyst-api logs.
+type PodMon struct {
+	ProcDir   string
+	Processes []string
+}
+
+type processUsage struct {
+	Process          string  `json:"process"`
+	AvgCPUPerCore    float64 `json:"avg_cpu_per_core"`
+	MaxCPUPerCore    float64 `json:"max_cpu_per_core"`
+	AvgMemPerProcess float64 `json:"avg_mem_per_process"`
+}
+
+func NewPodMon() *PodMon {
+	return &PodMon{
+		ProcDir: "/proc",
+		Processes: []string{"MistController", "MistProcLivepeer", "MistUtilLoad", "MistOutWebRTC", "MistInDTSC", "MistOutDTSC",
+			"MistOutFLV", "MistInFLV", "MistInBuffer", "MistOutHTTPTS", "catalyst-api", "catalyst-uploader"},
+	}
+}
+
+func (p *PodMon) Run(ctx context.Context) error {
+	usage, err := p.usage()
+	if err != nil {
+		return err
+	}
+	if usage == nil {
+		// no procfs on this platform
+		return nil
+	}
+	out, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	log.Printf("[pod-mon] %s\n", out)
+	return nil
+}
+
+// usage returns the CPU and memory usage of each monitored process name, in percent like `ps aux` shows it
+func (p *PodMon) usage() ([]processUsage, error) {
+	uptime, err := readUptime(p.ProcDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	memTotal, err := readMemTotal(p.ProcDir)
+	if err != nil {
+		return nil, err
+	}
+	processes, err := listProcesses(p.ProcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]processUsage, 0, len(p.Processes))
+	for _, name := range p.Processes {
+		var cpu, maxCPU, mem float64
+		count := 0
+		for _, proc := range processes {
+			// like grepping the output of ps, the name can be anywhere in the command line
+			if !strings.Contains(strings.Join(proc.args, " "), name) {
+				continue
+			}
+			procCPU, procMem, err := p.processUsage(proc.pid, uptime, memTotal)
+			if err != nil {
+				// the process exited
+				continue
+			}
+			cpu += procCPU
+			mem += procMem
+			maxCPU = max(maxCPU, procCPU)
+			count++
+		}
+		u := processUsage{Process: name}
+		if count > 0 {
+			u.AvgCPUPerCore = cpu / float64(runtime.NumCPU())
+			u.MaxCPUPerCore = maxCPU
+			u.AvgMemPerProcess = mem / float64(count)
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// processUsage returns the CPU usage of a process over its lifetime and its share of the memory, in percent
+func (p *PodMon) processUsage(pid int, uptime float64, memTotal int64) (float64, float64, error) {
+	stat, err := os.ReadFile(filepath.Join(p.ProcDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	// the command name in parentheses may contain spaces, so the fields are counted from after it
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0, 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	// utime, stime, starttime and rss are fields 14, 15, 22 and 24, i.e. 11, 12, 19 and 21 after the command name
+	if len(fields) < 22 {
+		return 0, 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	startTime, _ := strconv.ParseFloat(fields[19], 64)
+	rssPages, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	var cpu float64
+	if elapsed := uptime - startTime/clockTicks; elapsed > 0 {
+		cpu = 100 * (utime + stime) / clockTicks / elapsed
+	}
+	var mem float64
+	if memTotal > 0 {
+		mem = 100 * float64(rssPages*int64(os.Getpagesize())) / float64(memTotal)
+	}
+	return cpu, mem, nil
+}
+
+func readUptime(procDir string) (float64, error) {
+	data, err := os.ReadFile(filepath.Join(procDir, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, errors.New("invalid uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// readMemTotal returns the total memory in bytes
+func readMemTotal(procDir string) (int64, error) {
+	f, err := os.Open(filepath.Join(procDir, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, errors.New("no MemTotal in meminfo")
+}
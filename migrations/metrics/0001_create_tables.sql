@@ -0,0 +1,45 @@
+-- The tables of the metrics DB as they were before the migrations were versioned
+
+CREATE TABLE IF NOT EXISTS user_end_trigger (
+	uuid varchar,
+	timestamp_ms bigint,
+	connection_token varchar,
+	downloaded_bytes bigint,
+	uploaded_bytes bigint,
+	session_duration_s bigint,
+	stream_id varchar,
+	stream_id_count int,
+	protocol varchar,
+	protocol_count int,
+	ip_address varchar,
+	ip_address_count int,
+	tags varchar
+);
+
+CREATE TABLE IF NOT EXISTS vod_completed (
+	timestamp                bigint,
+	request_id               text,
+	job_duration             double precision,
+	source_segment_count     integer,
+	transcoded_segment_count integer,
+	source_bytes_count       integer,
+	source_duration          double precision,
+	source_codec_video       text,
+	source_codec_audio       text,
+	pipeline                 text,
+	catalyst_region          text,
+	state                    text,
+	profiles_count           integer,
+	started_at               bigint,
+	finished_at              bigint,
+	source_url               text,
+	target_url               text,
+	in_fallback_mode         boolean,
+	external_id              text,
+	source_playback_at       bigint,
+	download_done_at         bigint,
+	segmenting_done_at       bigint,
+	transcoding_done_at      bigint,
+	is_clip                  boolean,
+	is_thumbs                boolean
+);
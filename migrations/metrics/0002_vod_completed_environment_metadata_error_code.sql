@@ -0,0 +1,6 @@
+-- The environment and metadata of the VOD jobs, and the code of the error they failed with
+
+ALTER TABLE vod_completed
+	ADD COLUMN IF NOT EXISTS environment jsonb,
+	ADD COLUMN IF NOT EXISTS metadata    jsonb,
+	ADD COLUMN IF NOT EXISTS error_code  text;
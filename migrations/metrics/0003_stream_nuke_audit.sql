@@ -0,0 +1,12 @@
+-- The audit trail of the cluster-wide stream nukes, see handlers.StreamNukeAudit
+
+CREATE TABLE IF NOT EXISTS stream_nuke_audit (
+	at           timestamptz NOT NULL,
+	playback_id  text        NOT NULL,
+	token_id     text,
+	requested_by text,
+	reason       text,
+	nodes        jsonb
+);
+
+CREATE INDEX IF NOT EXISTS stream_nuke_audit_playback_id_at ON stream_nuke_audit (playback_id, at);
@@ -0,0 +1,38 @@
+// Package migrations holds the versioned schema migrations of the metrics DB the nodes write the VOD jobs and the
+// analytics to. The nodes don't apply them, the DB user they run as usually isn't allowed to alter the tables: they're
+// applied in order by the owner of the DB, e.g. with psql, before the nodes writing the new columns are deployed.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed metrics/*.sql
+var metrics embed.FS
+
+// MetricsDB returns the statements of the migrations of the metrics DB, in the order they're applied
+func MetricsDB() ([]string, error) {
+	names, err := fs.Glob(metrics, "metrics/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var stmts []string
+	for i, name := range names {
+		version, _, _ := strings.Cut(strings.TrimPrefix(name, "metrics/"), "_")
+		if v, err := strconv.Atoi(version); err != nil || v != i+1 {
+			return nil, fmt.Errorf("migration %s isn't version %d", name, i+1)
+		}
+		stmt, err := metrics.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, string(stmt))
+	}
+	return stmts, nil
+}
@@ -0,0 +1,16 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsDB(t *testing.T) {
+	stmts, err := MetricsDB()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(stmts), 3)
+	require.Contains(t, stmts[0], "CREATE TABLE IF NOT EXISTS vod_completed")
+	require.Contains(t, stmts[1], "ADD COLUMN IF NOT EXISTS error_code")
+	require.Contains(t, stmts[2], "CREATE TABLE IF NOT EXISTS stream_nuke_audit")
+}
@@ -0,0 +1,313 @@
+// Package mistbackup keeps backups of the Mist config that catalyst-api changes, i.e. the triggers and stream configs,
+// so that a bad update can be inspected and rolled back. A snapshot is only taken when the config changed since the
+// previous one. Secrets are redacted from the snapshots and taken from the live config when restoring.
+package mistbackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// Number of snapshots kept, older ones are deleted
+const maxSnapshots = 100
+
+const storeTimeout = time.Minute
+
+const idFormat = "20060102T150405.000Z"
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is a redacted copy of the Mist triggers and stream configs
+type Snapshot struct {
+	ID        string                              `json:"id"`
+	CreatedAt time.Time                           `json:"created_at"`
+	Triggers  clients.Triggers                    `json:"triggers"`
+	Streams   map[string]clients.MistStreamConfig `json:"streams"`
+	// Mist only returned some of the stream configs, so streams missing from the snapshot may have been configured
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+type SnapshotInfo struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Triggers   int       `json:"triggers"`
+	Streams    int       `json:"streams"`
+	Incomplete bool      `json:"incomplete,omitempty"`
+}
+
+type Backups struct {
+	mist clients.MistAPIClient
+	// Object store or local directory the snapshots of this node are written to, only kept in memory if empty
+	storeURL string
+
+	mu        sync.Mutex
+	snapshots []*Snapshot // oldest first
+}
+
+// NewBackups backs up the Mist config of a node. Its snapshots are stored under the node's name in the store, so that
+// the nodes sharing a store don't overwrite each other's.
+func NewBackups(mist clients.MistAPIClient, storeURL, node string) *Backups {
+	if storeURL != "" && node != "" {
+		if u, err := url.Parse(storeURL); err == nil {
+			storeURL = u.JoinPath(node).String()
+		}
+	}
+	return &Backups{mist: mist, storeURL: storeURL}
+}
+
+// Run loads the stored snapshots, then backs up the Mist config every interval until the context is cancelled
+func (b *Backups) Run(ctx context.Context, interval time.Duration) {
+	if err := b.load(ctx); err != nil {
+		log.LogNoRequestID("failed to load Mist config backups", "err", err, "store", log.RedactURL(b.storeURL))
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, _, err := b.Backup(ctx); err != nil {
+			log.LogNoRequestID("failed to back up Mist config", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Backup snapshots the current Mist config if it changed since the latest snapshot. Returns the latest snapshot and
+// whether it was just taken.
+func (b *Backups) Backup(ctx context.Context) (*Snapshot, bool, error) {
+	state, err := b.mist.GetConfig()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get Mist config: %w", err)
+	}
+	if state.Streams == nil {
+		return nil, false, errors.New("mist did not return its stream configs")
+	}
+	snapshot := newSnapshot(state, time.Now())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := len(b.snapshots); n > 0 && sameConfig(b.snapshots[n-1], snapshot) {
+		return b.snapshots[n-1], false, nil
+	}
+	if n := len(b.snapshots); n > 0 && b.snapshots[n-1].ID >= snapshot.ID {
+		// keep the IDs ordered even if the clock went backwards
+		snapshot.CreatedAt = b.snapshots[n-1].CreatedAt.Add(time.Millisecond)
+		snapshot.ID = snapshot.CreatedAt.UTC().Format(idFormat)
+	}
+	if err := b.store(ctx, snapshot); err != nil {
+		return nil, false, err
+	}
+	b.snapshots = append(b.snapshots, snapshot)
+	for len(b.snapshots) > maxSnapshots {
+		b.remove(ctx, b.snapshots[0].ID)
+		b.snapshots = b.snapshots[1:]
+	}
+	log.LogNoRequestID("backed up Mist config", "id", snapshot.ID, "triggers", len(snapshot.Triggers), "streams", len(snapshot.Streams))
+	return snapshot, true, nil
+}
+
+// List returns the snapshots, newest first
+func (b *Backups) List() []SnapshotInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	infos := make([]SnapshotInfo, 0, len(b.snapshots))
+	for i := len(b.snapshots) - 1; i >= 0; i-- {
+		s := b.snapshots[i]
+		infos = append(infos, SnapshotInfo{ID: s.ID, CreatedAt: s.CreatedAt, Triggers: len(s.Triggers), Streams: len(s.Streams), Incomplete: s.Incomplete})
+	}
+	return infos
+}
+
+func (b *Backups) Get(id string) (*Snapshot, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.snapshots {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, ErrSnapshotNotFound
+}
+
+func newSnapshot(state clients.MistConfigState, now time.Time) *Snapshot {
+	s := &Snapshot{
+		ID:        now.UTC().Format(idFormat),
+		CreatedAt: now,
+		Triggers:  clients.Triggers{},
+		Streams:   map[string]clients.MistStreamConfig{},
+	}
+	for name, triggers := range state.Triggers {
+		redacted := make([]clients.ConfigTrigger, 0, len(triggers))
+		for _, t := range triggers {
+			t.Handler = log.RedactURL(t.Handler)
+			redacted = append(redacted, t)
+		}
+		s.Triggers[name] = redacted
+	}
+	if state.Streams != nil {
+		for name, config := range state.Streams.Configs {
+			s.Streams[name] = redactStreamConfig(config)
+		}
+		s.Incomplete = state.Streams.Incomplete
+	}
+	return s
+}
+
+func redactStreamConfig(config clients.MistStreamConfig) clients.MistStreamConfig {
+	redacted := clients.MistStreamConfig{}
+	for k, v := range config {
+		redacted[k] = redactValue(k, v)
+	}
+	return redacted
+}
+
+// redactValue hides the credentials of URLs and the values of secret looking keys, e.g. the password of a stream
+func redactValue(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		lower := strings.ToLower(key)
+		if v != "" && (strings.Contains(lower, "password") || strings.Contains(lower, "secret") || strings.Contains(lower, "token")) {
+			return redacted
+		}
+		return log.RedactURL(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = redactValue(k, val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			l[i] = redactValue(key, val)
+		}
+		return l
+	}
+	return v
+}
+
+const redacted = "REDACTED"
+
+// isRedacted tells if a redacted value has anything hidden, in which case it can't be restored as is
+func isRedacted(v interface{}) bool {
+	b, _ := json.Marshal(v)
+	return bytes.Contains(b, []byte(redacted)) || bytes.Contains(b, []byte(":xxxxx@"))
+}
+
+func sameConfig(a, b *Snapshot) bool {
+	return a.Incomplete == b.Incomplete && jsonEqual(a.Triggers, b.Triggers) && jsonEqual(a.Streams, b.Streams)
+}
+
+// jsonEqual compares values the way they're stored, so that snapshots loaded from the store compare equal to the ones
+// taken from Mist
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+func (b *Backups) session() (drivers.OSSession, error) {
+	osDriver, err := clients.ParseOSURL(b.storeURL, true)
+	if err != nil {
+		return nil, err
+	}
+	return osDriver.NewSession(""), nil
+}
+
+func (b *Backups) store(ctx context.Context, s *Snapshot) error {
+	if b.storeURL == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := clients.UploadToOSURLFields(ctx, b.storeURL, s.ID+".json", bytes.NewReader(data), storeTimeout, nil); err != nil {
+		return fmt.Errorf("failed to store Mist config backup: %w", err)
+	}
+	return nil
+}
+
+func (b *Backups) remove(ctx context.Context, id string) {
+	if b.storeURL == "" {
+		return
+	}
+	sess, err := b.session()
+	if err == nil {
+		err = sess.DeleteFile(ctx, id+".json")
+	}
+	if err != nil {
+		log.LogNoRequestID("failed to delete old Mist config backup", "id", id, "err", err)
+	}
+}
+
+// load reads the stored snapshots, keeping the newest ones
+func (b *Backups) load(ctx context.Context) error {
+	if b.storeURL == "" {
+		return nil
+	}
+	sess, err := b.session()
+	if err != nil {
+		return err
+	}
+	var names []string
+	page, err := sess.ListFiles(ctx, "", "")
+	for err == nil {
+		for _, f := range page.Files() {
+			if strings.HasSuffix(f.Name, ".json") {
+				names = append(names, path.Base(f.Name))
+			}
+		}
+		if !page.HasNextPage() {
+			break
+		}
+		page, err = page.NextPage()
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// the local directory of a node that didn't back up yet
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	sort.Strings(names)
+	if len(names) > maxSnapshots {
+		names = names[len(names)-maxSnapshots:]
+	}
+
+	var snapshots []*Snapshot
+	for _, name := range names {
+		fi, err := sess.ReadData(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to read backup %s: %w", name, err)
+		}
+		var s Snapshot
+		err = json.NewDecoder(fi.Body).Decode(&s)
+		fi.Body.Close()
+		if err != nil {
+			log.LogNoRequestID("skipping invalid Mist config backup", "name", name, "err", err)
+			continue
+		}
+		snapshots = append(snapshots, &s)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.snapshots = append(snapshots, b.snapshots...)
+	return nil
+}
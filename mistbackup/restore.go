@@ -0,0 +1,235 @@
+package mistbackup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// Change is a trigger or stream config that differs between two versions of the config. Before or After is nil when
+// it was added or removed.
+type Change struct {
+	Name   string      `json:"name"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+type Diff struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Triggers []Change `json:"triggers"`
+	Streams  []Change `json:"streams"`
+	// Either side is missing some stream configs, so removed streams may just not have been listed
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// Diff returns what changed from the snapshot with the given ID to the one with the `to` ID, or to the current config
+// when `to` is empty
+func (b *Backups) Diff(from, to string) (*Diff, error) {
+	fromSnapshot, err := b.Get(from)
+	if err != nil {
+		return nil, err
+	}
+	var toSnapshot *Snapshot
+	if to == "" {
+		state, err := b.mist.GetConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Mist config: %w", err)
+		}
+		toSnapshot = newSnapshot(state, time.Now())
+		toSnapshot.ID = "current"
+	} else if toSnapshot, err = b.Get(to); err != nil {
+		return nil, err
+	}
+
+	d := &Diff{From: fromSnapshot.ID, To: toSnapshot.ID, Incomplete: fromSnapshot.Incomplete || toSnapshot.Incomplete}
+	d.Triggers = diffMaps(fromSnapshot.Triggers, toSnapshot.Triggers)
+	d.Streams = diffMaps(fromSnapshot.Streams, toSnapshot.Streams)
+	return d, nil
+}
+
+func diffMaps[V any](before, after map[string]V) []Change {
+	changes := []Change{}
+	for name, b := range before {
+		a, ok := after[name]
+		if !ok {
+			changes = append(changes, Change{Name: name, Before: b})
+		} else if !jsonEqual(a, b) {
+			changes = append(changes, Change{Name: name, Before: b, After: a})
+		}
+	}
+	for name, a := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, Change{Name: name, After: a})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+type RestoreResult struct {
+	// Snapshot of the config from before the restore, to undo it
+	PreviousID       string   `json:"previous_id"`
+	TriggersRestored bool     `json:"triggers_restored"`
+	StreamsRestored  []string `json:"streams_restored"`
+	StreamsDeleted   []string `json:"streams_deleted"`
+	// What couldn't be restored and why
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// Restore brings the Mist triggers and stream configs of the snapshot with the given ID back, adding the missing ones
+// and overwriting the changed ones. Values that were redacted from the snapshot are taken from the current config,
+// with URL credentials only carried over to the same user and host, and configs whose redacted values can't be
+// recovered are left alone. The triggers and streams missing from the snapshot are only removed when pruning, and
+// the streams not even then when Mist only listed some of them.
+func (b *Backups) Restore(ctx context.Context, id string, prune bool) (*RestoreResult, error) {
+	snapshot, err := b.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	// back up the current config first, so that the restore can be undone
+	previous, _, err := b.Backup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, err := b.mist.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Mist config: %w", err)
+	}
+
+	result := &RestoreResult{PreviousID: previous.ID, StreamsRestored: []string{}, StreamsDeleted: []string{}, Failed: map[string]string{}}
+	currentTriggers := newSnapshot(current, time.Now()).Triggers
+	wantedTriggers := snapshot.Triggers
+	if !prune {
+		wantedTriggers = mergeTriggers(snapshot.Triggers, currentTriggers)
+	}
+	if !jsonEqual(currentTriggers, wantedTriggers) {
+		triggers, err := unredactTriggers(wantedTriggers, current.Triggers)
+		if err == nil {
+			err = b.mist.SetTriggers(triggers)
+		}
+		if err != nil {
+			result.Failed["triggers"] = err.Error()
+		} else {
+			result.TriggersRestored = true
+		}
+	}
+
+	currentStreams := map[string]clients.MistStreamConfig{}
+	if current.Streams != nil {
+		currentStreams = current.Streams.Configs
+	}
+	for name, config := range snapshot.Streams {
+		currentConfig, exists := currentStreams[name]
+		if exists && jsonEqual(redactStreamConfig(currentConfig), config) {
+			continue
+		}
+		restored, err := unredactStreamConfig(config, currentConfig)
+		if err == nil {
+			err = b.mist.AddStreamConfig(name, restored)
+		}
+		if err != nil {
+			result.Failed["stream "+name] = err.Error()
+			continue
+		}
+		result.StreamsRestored = append(result.StreamsRestored, name)
+	}
+	if prune && !snapshot.Incomplete && current.Streams != nil && !current.Streams.Incomplete {
+		for name := range currentStreams {
+			if _, ok := snapshot.Streams[name]; ok {
+				continue
+			}
+			if err := b.mist.DeleteStream(name); err != nil {
+				result.Failed["stream "+name] = err.Error()
+				continue
+			}
+			result.StreamsDeleted = append(result.StreamsDeleted, name)
+		}
+	}
+	sort.Strings(result.StreamsRestored)
+	sort.Strings(result.StreamsDeleted)
+
+	log.LogNoRequestID("restored Mist config backup", "id", id, "prune", prune, "previous_id", previous.ID, "triggers_restored", result.TriggersRestored,
+		"streams_restored", len(result.StreamsRestored), "streams_deleted", len(result.StreamsDeleted), "failed", len(result.Failed))
+	// snapshot the restored config, so that it's the latest one
+	if _, _, err := b.Backup(ctx); err != nil {
+		log.LogNoRequestID("failed to back up restored Mist config", "err", err)
+	}
+	return result, nil
+}
+
+// mergeTriggers returns the triggers of the snapshot along with the current ones of the trigger types it doesn't have
+func mergeTriggers(snapshot, current clients.Triggers) clients.Triggers {
+	merged := clients.Triggers{}
+	for name, triggers := range current {
+		merged[name] = triggers
+	}
+	for name, triggers := range snapshot {
+		merged[name] = triggers
+	}
+	return merged
+}
+
+// unredactStreamConfig returns the config of the snapshot with the redacted values taken from the current config
+func unredactStreamConfig(snapshot, current clients.MistStreamConfig) (clients.MistStreamConfig, error) {
+	restored := clients.MistStreamConfig{}
+	for k, v := range snapshot {
+		if currentValue, ok := current[k]; ok && jsonEqual(redactValue(k, currentValue), v) {
+			restored[k] = currentValue
+			continue
+		}
+		if isRedacted(v) {
+			redactedURL, _ := v.(string)
+			currentURL, _ := current[k].(string)
+			u, ok := withCredentials(redactedURL, currentURL)
+			if !ok {
+				return nil, fmt.Errorf("redacted value of %q changed since the backup", k)
+			}
+			v = u
+		}
+		restored[k] = v
+	}
+	return restored, nil
+}
+
+// withCredentials puts the credentials of the current URL back into the redacted URL from the backup, as long as both
+// are for the same user and host
+func withCredentials(redactedURL, currentURL string) (string, bool) {
+	r, err := url.Parse(redactedURL)
+	if err != nil || r.User == nil {
+		return "", false
+	}
+	c, err := url.Parse(currentURL)
+	if err != nil || c.User == nil || c.User.Username() != r.User.Username() || c.Host != r.Host {
+		return "", false
+	}
+	r.User = c.User
+	return r.String(), true
+}
+
+// unredactTriggers returns the triggers of the snapshot with redacted handlers taken from the current triggers
+func unredactTriggers(snapshot, current clients.Triggers) (clients.Triggers, error) {
+	handlers := map[string]string{}
+	for _, triggers := range current {
+		for _, t := range triggers {
+			handlers[log.RedactURL(t.Handler)] = t.Handler
+		}
+	}
+	restored := clients.Triggers{}
+	for name, triggers := range snapshot {
+		for _, t := range triggers {
+			if handler, ok := handlers[t.Handler]; ok {
+				t.Handler = handler
+			} else if isRedacted(t.Handler) {
+				return nil, fmt.Errorf("redacted handler of trigger %s changed since the backup", name)
+			}
+			restored[name] = append(restored[name], t)
+		}
+	}
+	return restored, nil
+}
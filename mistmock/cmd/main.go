@@ -0,0 +1,32 @@
+// Standalone fake MistServer for local development, e.g.:
+//
+//	go run ./mistmock/cmd -addr 127.0.0.1:4242
+//	go run main.go -no-mist -mist-port 4242
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/catalyst-api/mistmock"
+)
+
+func main() {
+	fs := flag.NewFlagSet("mistmock", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:4242", "Address to serve the Mist API on")
+	user := fs.String("user", "", "Username accepted by the API")
+	password := fs.String("password", "", "Password accepted by the API. Leave empty to authorize all requests")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		glog.Fatal(err)
+	}
+	if err := flag.Set("logtostderr", "true"); err != nil {
+		glog.Fatal(err)
+	}
+
+	glog.Infof("Starting fake Mist API on %s", *addr)
+	if err := http.ListenAndServe(*addr, mistmock.New(*user, *password)); err != nil {
+		glog.Fatal(err)
+	}
+}
@@ -0,0 +1,412 @@
+// Package mistmock is a fake MistServer implementing the subset of the Mist API used by catalyst-api, so that trigger
+// and balancer logic can be tested without a real Mist. It can be run in-process with httptest or as a standalone
+// binary (see cmd/) next to catalyst-api started with -no-mist.
+package mistmock
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/handlers/misttriggers"
+)
+
+// Version reported to the trigger handlers in the X-Version header
+const Version = "mistmock"
+
+// ServerVersion is the version of Mist the fake reports in its config, for the capabilities handshake
+const ServerVersion = "mistmock 3.2"
+
+type push struct {
+	id           int64
+	stream       string
+	originalURL  string
+	effectiveURL string
+}
+
+type streamStats struct {
+	clients     int
+	mediaTimeMs int64
+}
+
+// Server is a fake MistServer. The zero value isn't usable, use New.
+type Server struct {
+	user, password string
+	challenge      string
+
+	mu            sync.Mutex
+	authorized    map[string]bool
+	streams       map[string]string
+	activeStreams map[string]string
+	stats         map[string]streamStats
+	streamInfo    map[string]clients.MistStreamInfo
+	pushes        []push
+	nextPushID    int64
+	autoPushes    [][]interface{}
+	triggers      clients.Triggers
+	// the triggers listed in the capabilities
+	supportedTriggers []string
+	httpClient        *http.Client
+}
+
+// New creates a fake Mist accepting the given credentials. With an empty password all requests are authorized, like
+// a Mist without any accounts configured.
+func New(user, password string) *Server {
+	return &Server{
+		user:          user,
+		password:      password,
+		challenge:     config.RandomTrailer(16),
+		authorized:    map[string]bool{},
+		streams:       map[string]string{},
+		activeStreams: map[string]string{},
+		stats:         map[string]streamStats{},
+		streamInfo:    map[string]clients.MistStreamInfo{},
+		triggers:      clients.Triggers{},
+		httpClient:    &http.Client{},
+
+		supportedTriggers: misttriggers.Triggers(),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if stream, ok := strings.CutPrefix(req.URL.Path, "/json_"); ok && strings.HasSuffix(stream, ".js") {
+		s.serveStreamInfo(w, strings.TrimSuffix(stream, ".js"))
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported by the API", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var command map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(req.PostForm.Get("command")), &command); err != nil {
+		http.Error(w, "invalid command: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := s.handleCommand(remoteHost(req), command)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// handleCommand runs the commands of an API request. Like Mist, unauthorized requests only get a challenge back and
+// authorization is remembered per client host.
+func (s *Server) handleCommand(host string, command map[string]json.RawMessage) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if raw, ok := command["authorize"]; ok {
+		var auth clients.Authorize
+		if err := json.Unmarshal(raw, &auth); err == nil && auth.Username == s.user && auth.Password == s.expectedPassword() {
+			s.authorized[host] = true
+		}
+	}
+	if s.password != "" && !s.authorized[host] {
+		return map[string]interface{}{
+			"authorize": map[string]string{"status": "CHALL", "challenge": s.challenge},
+		}
+	}
+
+	resp := map[string]interface{}{
+		"authorize": map[string]string{"status": "OK"},
+	}
+	for name, raw := range command {
+		switch name {
+		case "config":
+			s.updateConfig(raw)
+			resp["config"] = map[string]interface{}{"triggers": s.triggers, "version": ServerVersion}
+		case "capabilities":
+			supported := map[string]interface{}{}
+			for _, t := range s.supportedTriggers {
+				supported[t] = map[string]interface{}{}
+			}
+			resp["capabilities"] = map[string]interface{}{"triggers": supported}
+		case "addstream":
+			var streams map[string]clients.Stream
+			if err := json.Unmarshal(raw, &streams); err == nil {
+				for stream, st := range streams {
+					s.streams[stream] = st.Source
+				}
+			}
+			resp["streams"] = s.streamsResponse()
+		case "deletestream":
+			var streams map[string]interface{}
+			if err := json.Unmarshal(raw, &streams); err == nil {
+				for stream := range streams {
+					delete(s.streams, stream)
+				}
+			}
+			resp["streams"] = s.streamsResponse()
+		case "nuke_stream":
+			var stream string
+			if err := json.Unmarshal(raw, &stream); err == nil {
+				delete(s.activeStreams, stream)
+				delete(s.stats, stream)
+			}
+		case "push_auto_add":
+			var add clients.PushAutoAdd
+			if err := json.Unmarshal(raw, &add); err == nil {
+				s.autoPushes = append(s.autoPushes, []interface{}{add.Stream, add.Target})
+			}
+		case "push_auto_remove":
+			var remove [][]interface{}
+			if err := json.Unmarshal(raw, &remove); err == nil {
+				s.removeAutoPushes(remove)
+			}
+		case "push_stop":
+			var ids []int64
+			if err := json.Unmarshal(raw, &ids); err == nil {
+				s.stopPushes(ids)
+			}
+		case "active_streams":
+			active := map[string][]string{}
+			for stream, source := range s.activeStreams {
+				active[stream] = []string{source}
+			}
+			resp["active_streams"] = active
+		case "stats_streams":
+			stats := map[string][]interface{}{}
+			for stream, st := range s.stats {
+				stats[stream] = []interface{}{st.clients, st.mediaTimeMs}
+			}
+			resp["stats_streams"] = stats
+		case "push_list":
+			list := [][]interface{}{}
+			for _, p := range s.pushes {
+				list = append(list, []interface{}{p.id, p.stream, p.originalURL, p.effectiveURL, nil, clients.MistPushStats{}})
+			}
+			resp["push_list"] = list
+		case "push_auto_list":
+			resp["push_auto_list"] = s.autoPushes
+		}
+		// invalidate_sessions and stop_sessions only affect viewers, which aren't simulated
+	}
+	return resp
+}
+
+func (s *Server) expectedPassword() string {
+	passwordMd5 := md5Hex(s.password)
+	return md5Hex(passwordMd5 + s.challenge)
+}
+
+func md5Hex(input string) string {
+	sum := md5.Sum([]byte(input))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateConfig applies the triggers of a config command. Mist replaces the triggers listed in the command, where null
+// removes a trigger, and an empty config only returns the current one.
+func (s *Server) updateConfig(raw json.RawMessage) {
+	var cfg struct {
+		Triggers map[string][]clients.ConfigTrigger `json:"triggers"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return
+	}
+	for name, triggers := range cfg.Triggers {
+		if len(triggers) == 0 {
+			delete(s.triggers, name)
+		} else {
+			s.triggers[name] = triggers
+		}
+	}
+}
+
+func (s *Server) streamsResponse() map[string]interface{} {
+	streams := map[string]interface{}{}
+	for name, source := range s.streams {
+		streams[name] = map[string]string{"name": name, "source": source}
+	}
+	return streams
+}
+
+func (s *Server) removeAutoPushes(remove [][]interface{}) {
+	var kept [][]interface{}
+	for _, p := range s.autoPushes {
+		removed := false
+		for _, r := range remove {
+			if len(r) >= 2 && r[0] == p[0] && r[1] == p[1] {
+				removed = true
+			}
+		}
+		if !removed {
+			kept = append(kept, p)
+		}
+	}
+	s.autoPushes = kept
+}
+
+func (s *Server) stopPushes(ids []int64) {
+	var kept []push
+	for _, p := range s.pushes {
+		stopped := false
+		for _, id := range ids {
+			if p.id == id {
+				stopped = true
+			}
+		}
+		if !stopped {
+			kept = append(kept, p)
+		}
+	}
+	s.pushes = kept
+}
+
+func (s *Server) serveStreamInfo(w http.ResponseWriter, stream string) {
+	s.mu.Lock()
+	info, ok := s.streamInfo[stream]
+	s.mu.Unlock()
+	if !ok {
+		info = clients.MistStreamInfo{Error: "Stream is offline"}
+	}
+	w.Header().Set("Content-Type", "application/javascript")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SetActiveStream marks the stream as active with the given source, e.g. "push://" for an ingest stream, with the
+// number of viewers and media time reported in the stream stats
+func (s *Server) SetActiveStream(stream, source string, viewers int, mediaTimeMs int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activeStreams[stream] = source
+	s.stats[stream] = streamStats{clients: viewers, mediaTimeMs: mediaTimeMs}
+}
+
+// RemoveActiveStream marks the stream as offline
+func (s *Server) RemoveActiveStream(stream string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.activeStreams, stream)
+	delete(s.stats, stream)
+}
+
+// SetStreamInfo sets the response of json_<stream>.js
+func (s *Server) SetStreamInfo(stream string, info clients.MistStreamInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streamInfo[stream] = info
+}
+
+// StartPush adds a push to the push list, as if Mist started pushing the stream to the target. Returns the push ID.
+func (s *Server) StartPush(stream, target string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextPushID++
+	s.pushes = append(s.pushes, push{id: s.nextPushID, stream: stream, originalURL: target, effectiveURL: target})
+	return s.nextPushID
+}
+
+// Streams returns the configured streams and their sources
+func (s *Server) Streams() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	streams := map[string]string{}
+	for name, source := range s.streams {
+		streams[name] = source
+	}
+	return streams
+}
+
+// AutoPushes returns the [stream, target] of the configured auto pushes
+func (s *Server) AutoPushes() [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]interface{}{}, s.autoPushes...)
+}
+
+// Triggers returns the configured triggers
+func (s *Server) Triggers() clients.Triggers {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	triggers := clients.Triggers{}
+	for name, t := range s.triggers {
+		triggers[name] = append([]clients.ConfigTrigger{}, t...)
+	}
+	return triggers
+}
+
+// SetSupportedTriggers changes the triggers listed in the capabilities, e.g. to pretend to be an older Mist. They're
+// those catalyst-api sets up by default.
+func (s *Server) SetSupportedTriggers(triggers []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.supportedTriggers = triggers
+}
+
+// FireTrigger sends the trigger to all of the handlers configured for the stream, the same way Mist does: the payload
+// lines are newline separated and the trigger name is in the X-Trigger header. Returns the responses of the sync
+// handlers, which Mist would use to decide how to proceed.
+func (s *Server) FireTrigger(triggerName, stream string, payload ...string) ([]string, error) {
+	var handlers []clients.ConfigTrigger
+	for _, t := range s.Triggers()[triggerName] {
+		if triggerAppliesTo(t, stream) {
+			handlers = append(handlers, t)
+		}
+	}
+
+	var responses []string
+	for _, t := range handlers {
+		req, err := http.NewRequest(http.MethodPost, t.Handler, bytes.NewBufferString(strings.Join(payload, "\n")))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Trigger", triggerName)
+		req.Header.Set("X-Version", Version)
+		req.Header.Set("X-UUID", config.RandomTrailer(8))
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("trigger handler %s returned HTTP %d: %s", t.Handler, resp.StatusCode, body)
+		}
+		if t.Sync {
+			responses = append(responses, string(body))
+		}
+	}
+	return responses, nil
+}
+
+// triggerAppliesTo checks the trigger's stream list. Like Mist, wildcard streams (name+suffix) match on the base name
+// and an empty list matches all streams.
+func triggerAppliesTo(t clients.ConfigTrigger, stream string) bool {
+	if len(t.Streams) == 0 {
+		return true
+	}
+	base, _, _ := strings.Cut(stream, "+")
+	for _, s := range t.Streams {
+		if s == stream || s == base {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package mistmock
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, mock *Server, password string) *clients.MistClient {
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	mc := clients.NewMistAPIClient("user", password, u.Hostname(), port).(*clients.MistClient)
+	mc.HttpReqUrl = server.URL
+	return mc
+}
+
+func TestAuthorization(t *testing.T) {
+	mock := New("user", "secret")
+
+	_, err := newTestClient(t, mock, "wrong").GetState()
+	require.EqualError(t, err, "authorization to Mist API failed")
+
+	require.NoError(t, newTestClient(t, mock, "secret").AddStream("stream", "push://"))
+	require.Equal(t, map[string]string{"stream": "push://"}, mock.Streams())
+}
+
+func TestTriggers(t *testing.T) {
+	mock := New("user", "secret")
+	mc := newTestClient(t, mock, "secret")
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	handler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		received = append(received, req.Header.Get("X-Trigger")+":"+string(body))
+		mu.Unlock()
+		_, _ = w.Write([]byte("true"))
+	}))
+	defer handler.Close()
+
+	require.NoError(t, mc.AddTrigger([]string{"video"}, "PUSH_END", handler.URL, false))
+	require.NoError(t, mc.AddTrigger(nil, "STREAM_SOURCE", handler.URL, true))
+	require.Len(t, mock.Triggers(), 2)
+
+	responses, err := mock.FireTrigger("STREAM_SOURCE", "video+abc", "video+abc")
+	require.NoError(t, err)
+	require.Equal(t, []string{"true"}, responses)
+	responses, err = mock.FireTrigger("PUSH_END", "video+abc", "1", "video+abc")
+	require.NoError(t, err)
+	require.Empty(t, responses)
+	_, err = mock.FireTrigger("PUSH_END", "other+abc", "2", "other+abc")
+	require.NoError(t, err)
+	mu.Lock()
+	require.Equal(t, []string{"STREAM_SOURCE:video+abc", "PUSH_END:1\nvideo+abc"}, received)
+	mu.Unlock()
+
+	require.NoError(t, mc.DeleteTrigger([]string{"video"}, "PUSH_END"))
+	require.Len(t, mock.Triggers(), 1)
+}
+
+func TestState(t *testing.T) {
+	mock := New("", "")
+	mc := newTestClient(t, mock, "")
+
+	mock.SetActiveStream("video+abc", "push://", 3, 1000)
+	mock.SetActiveStream("video+def", "push://INTERNAL_ONLY:dtsc://origin", 1, 500)
+	id := mock.StartPush("video+abc", "rtmp://example.com/live")
+	require.NoError(t, mc.PushAutoAdd("video+abc", "rtmp://example.com/auto"))
+
+	state, err := mc.GetState()
+	require.NoError(t, err)
+	require.True(t, state.IsIngestStream("video+abc"))
+	require.False(t, state.IsIngestStream("video+def"))
+	require.Equal(t, 3, state.StreamsStats["video+abc"].Clients)
+	require.Len(t, state.PushList, 1)
+	require.Equal(t, id, state.PushList[0].ID)
+	require.Len(t, state.PushAutoList, 1)
+	require.Equal(t, "rtmp://example.com/auto", state.PushAutoList[0].Target)
+
+	require.NoError(t, mc.PushStop(id))
+	require.NoError(t, mc.PushAutoRemove(state.PushAutoList[0].StreamParams))
+	require.NoError(t, mc.NukeStream("video+abc"))
+	require.Empty(t, mock.AutoPushes())
+}
+
+func TestStreamInfo(t *testing.T) {
+	mock := New("", "")
+	mc := newTestClient(t, mock, "")
+
+	_, err := mc.GetStreamInfo("video+abc")
+	require.EqualError(t, err, "Stream is offline")
+
+	mock.SetStreamInfo("video+abc", clients.MistStreamInfo{Type: "live", Width: 1280, Height: 720})
+	info, err := mc.GetStreamInfo("video+abc")
+	require.NoError(t, err)
+	require.Equal(t, 1280, info.Width)
+}
+
+func TestCapabilities(t *testing.T) {
+	mock := New("user", "secret")
+	mc := newTestClient(t, mock, "secret")
+
+	report := clients.CheckMistCapabilities(mc, []string{"PUSH_END", "USER_NEW"}, "3.0")
+	require.True(t, report.OK, report.String())
+	require.Equal(t, ServerVersion, report.Version)
+
+	// An older Mist without the USER_NEW trigger, and too old for the commands checked by version
+	mock.SetSupportedTriggers([]string{"PUSH_END"})
+	report = clients.CheckMistCapabilities(mc, []string{"PUSH_END", "USER_NEW"}, "3.3")
+	require.False(t, report.OK)
+	var missing []string
+	for _, f := range report.Features {
+		if !f.Supported {
+			missing = append(missing, f.Name)
+		}
+	}
+	require.Equal(t, []string{"trigger USER_NEW", "stop_sessions", "nuke_stream", "invalidate_sessions"}, missing)
+	require.Contains(t, report.String(), "stop_sessions: Mist 3.3 or later is required")
+
+	// Authorization is remembered per host, hence a new Mist
+	report = clients.CheckMistCapabilities(newTestClient(t, New("user", "secret"), "wrong"), nil, "")
+	require.False(t, report.OK)
+	require.Equal(t, "could not check Mist capabilities: authorization to Mist API failed", report.String())
+}
+
+func TestCapabilitiesAreCheckedAgainUntilTheyPass(t *testing.T) {
+	defer clients.ResetMistCapabilityReport()
+	mock := New("", "")
+	mc := newTestClient(t, mock, "")
+	mock.SetSupportedTriggers([]string{"PUSH_END"})
+	clients.SetMistCapabilityReport(clients.CheckMistCapabilities(mc, []string{"PUSH_END", "USER_NEW"}, ""))
+	require.False(t, clients.GetMistCapabilityReport().OK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		clients.RecheckMistCapabilities(ctx, mc, []string{"PUSH_END", "USER_NEW"}, "", 10*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, clients.GetMistCapabilityReport().OK)
+
+	// Mist was upgraded
+	mock.SetSupportedTriggers([]string{"PUSH_END", "USER_NEW"})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the capabilities weren't checked again")
+	}
+	require.True(t, clients.GetMistCapabilityReport().OK)
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/livepeer/catalyst-api/concurrency"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/maintenance"
+)
+
+// How long a job enqueued by the node itself waits for a free job slot before checking again
+var admissionRetryInterval = 10 * time.Second
+
+// HasCapacity tells whether the node can start another regular or clip VOD job, under the limits applied to the jobs
+// submitted to /api/vod
+func (c *Coordinator) HasCapacity(clip bool) bool {
+	if maintenance.Node.Draining() {
+		return false
+	}
+	jobs := 0
+	for _, job := range c.Jobs.GetJobs() {
+		if job.ClipStrategy.Enabled == clip {
+			jobs++
+		}
+	}
+	if clip {
+		return jobs < config.MaxInFlightClipJobs
+	}
+	return jobs < concurrency.Tuner.MaxInFlightJobs()
+}
+
+// StartUploadJobWhenAdmitted starts a job that the node enqueues itself rather than being asked to over the API, e.g.
+// for a recording, once there's a free job slot for it. Returns an error if the context is done first.
+func (c *Coordinator) StartUploadJobWhenAdmitted(ctx context.Context, p UploadJobPayload) error {
+	if err := c.waitForAdmission(ctx, p); err != nil {
+		return err
+	}
+	c.StartUploadJob(p)
+	return nil
+}
+
+// waitForAdmission returns once there's a free job slot for the job, or an error if the context is done first
+func (c *Coordinator) waitForAdmission(ctx context.Context, p UploadJobPayload) error {
+	if !c.HasCapacity(p.ClipStrategy.Enabled) {
+		log.Log(p.RequestID, "waiting for a free job slot")
+	}
+	for !c.HasCapacity(p.ClipStrategy.Enabled) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(admissionRetryInterval):
+		}
+	}
+	return nil
+}
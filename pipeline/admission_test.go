@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartUploadJobWhenAdmittedWaitsForAFreeSlot(t *testing.T) {
+	defer func(max int, interval time.Duration) {
+		config.MaxInFlightJobs, admissionRetryInterval = max, interval
+	}(config.MaxInFlightJobs, admissionRetryInterval)
+	config.MaxInFlightJobs, admissionRetryInterval = 1, 10*time.Millisecond
+
+	coord := NewStubCoordinator()
+	require.True(t, coord.HasCapacity(false))
+	coord.Jobs.Store("catalyst_vod_running", &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "running"}})
+	require.False(t, coord.HasCapacity(false))
+	require.True(t, coord.HasCapacity(true), "clips have their own limit")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, coord.StartUploadJobWhenAdmitted(ctx, UploadJobPayload{RequestID: "waiting"}), context.DeadlineExceeded)
+	_, started := coord.Jobs.Lookup(config.SegmentingStreamName("waiting"))
+	require.False(t, started)
+}
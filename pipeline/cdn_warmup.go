@@ -0,0 +1,28 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/thumbnails"
+)
+
+// How long the CDN warm-up of a job's outputs may take, it's given up on after that
+const cdnWarmUpTimeout = 5 * time.Minute
+
+// warmUpCDN requests the outputs of a completed job through the CDN, once they're announced, so that it has them cached
+// by the time the first viewers play them
+func warmUpCDN(job *JobInfo, out *HandlerOutput) {
+	if out == nil || out.Result == nil {
+		return
+	}
+	var poster string
+	if job.ThumbnailsTargetURL != nil {
+		poster = thumbnails.PosterURL(job.ThumbnailsTargetURL).String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cdnWarmUpTimeout)
+	defer cancel()
+	clients.WarmUpCDN(ctx, job.RequestID, config.CDNWarmUpPrefix, out.Result.Outputs, poster, config.CDNWarmUpSegments)
+}
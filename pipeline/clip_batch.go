@@ -0,0 +1,167 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// ClipBatchPayload is a batch of clips of the same recording, e.g. one per chapter marker of a stream
+type ClipBatchPayload struct {
+	RequestID   string
+	CallbackURL string
+	// Opaque caller metadata, echoed in the status callbacks of the batch
+	Metadata map[string]string
+	Clips    []ClipBatchClip
+}
+
+// ClipBatchClip is one of the clips of a batch, run as a clipping job of its own
+type ClipBatchClip struct {
+	Title string
+	UploadJobPayload
+}
+
+// StartClipBatch starts a clipping job for each clip of the batch. The clips download the recording's manifest and
+// segments once between them, and their statuses are combined into the status callbacks of the batch, which
+// complete once all of the clips are done.
+func (c *Coordinator) StartClipBatch(b ClipBatchPayload) error {
+	if len(b.Clips) == 0 {
+		return fmt.Errorf("no clips in the batch")
+	}
+	sources, err := clients.NewClipSources()
+	if err != nil {
+		return err
+	}
+	batch := newClipBatch(b, c.statusClient, func() {
+		if err := sources.Close(); err != nil {
+			log.LogError(b.RequestID, "failed to remove the clip sources", err)
+		}
+	})
+	log.Log(b.RequestID, "Starting clip batch", "clips", len(b.Clips))
+	for i, clip := range b.Clips {
+		p := clip.UploadJobPayload
+		p.CallbackURL = b.CallbackURL
+		p.ClipSources = sources
+		log.AddContext(p.RequestID, "clip_batch", b.RequestID)
+		c.startUploadJobReporting(p, batch.clipStatusClient(i))
+	}
+	return nil
+}
+
+// clipBatch combines the status messages of the clips of a batch into those of the batch
+type clipBatch struct {
+	requestID    string
+	callbackURL  string
+	metadata     map[string]string
+	statusClient clients.TranscodeStatusClient
+	// called once all the clips are done
+	onDone func()
+
+	mu        sync.Mutex
+	clips     []clients.ClipStatus
+	remaining int
+}
+
+func newClipBatch(b ClipBatchPayload, statusClient clients.TranscodeStatusClient, onDone func()) *clipBatch {
+	batch := &clipBatch{
+		requestID:    b.RequestID,
+		callbackURL:  b.CallbackURL,
+		metadata:     b.Metadata,
+		statusClient: statusClient,
+		onDone:       onDone,
+		clips:        make([]clients.ClipStatus, len(b.Clips)),
+		remaining:    len(b.Clips),
+	}
+	for i, clip := range b.Clips {
+		batch.clips[i] = clients.ClipStatus{RequestID: clip.RequestID, Title: clip.Title, Status: clients.TranscodeStatusPreparing}
+	}
+	return batch
+}
+
+func (b *clipBatch) clipStatusClient(i int) clients.TranscodeStatusClient {
+	return clients.TranscodeStatusFunc(func(tsm clients.TranscodeStatusMessage) error {
+		return b.update(i, tsm)
+	})
+}
+
+// update records the status of a clip and sends that of the batch. The lock is held while sending so that no progress
+// of the batch is sent after its final status.
+func (b *clipBatch) update(i int, tsm clients.TranscodeStatusMessage) error {
+	// An error of a pipeline with a fallback isn't sent, the clip carries on in the fallback pipeline
+	if tsm.URL == "" {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clip := &b.clips[i]
+	if clip.Status == clients.TranscodeStatusCompleted || clip.Status == clients.TranscodeStatusError {
+		return nil
+	}
+	clip.Status, clip.CompletionRatio, clip.Error, clip.ErrorCode, clip.Outputs = tsm.Status, tsm.CompletionRatio, tsm.Error, tsm.ErrorCode, tsm.Outputs
+	if !tsm.IsTerminal() {
+		return b.statusClient.SendTranscodeStatus(b.statusMessage())
+	}
+
+	b.remaining--
+	err := b.statusClient.SendTranscodeStatus(b.statusMessage())
+	if b.remaining == 0 {
+		b.onDone()
+	}
+	return err
+}
+
+// statusMessage is the status of the batch: the least advanced status of the clips still running and their average
+// completion, or once they're all done, whether any of them failed
+func (b *clipBatch) statusMessage() clients.TranscodeStatusMessage {
+	var tsm clients.TranscodeStatusMessage
+	clips := make([]clients.ClipStatus, len(b.clips))
+	copy(clips, b.clips)
+
+	if b.remaining == 0 {
+		failed, errorCode := 0, ""
+		for _, clip := range clips {
+			if clip.Status == clients.TranscodeStatusError {
+				failed++
+				if errorCode == "" {
+					errorCode = clip.ErrorCode
+				}
+			}
+		}
+		if failed > 0 {
+			tsm = clients.NewTranscodeStatusError(b.callbackURL, b.requestID, fmt.Sprintf("%d of %d clips failed", failed, len(clips)), false)
+			// the code of the first failed clip, the others having theirs in the clip statuses
+			if errorCode != "" {
+				tsm.ErrorCode = errorCode
+			}
+		} else {
+			tsm = clients.NewTranscodeStatusCompleted(b.callbackURL, b.requestID, video.InputVideo{}, nil)
+		}
+	} else {
+		status := clients.TranscodeStatusCompleted
+		var completion float64
+		for _, clip := range clips {
+			switch clip.Status {
+			case clients.TranscodeStatusCompleted, clients.TranscodeStatusError:
+				completion += 1
+			default:
+				status = min(status, clip.Status)
+				completion += clip.CompletionRatio
+			}
+		}
+		tsm = clients.TranscodeStatusMessage{
+			URL:             b.callbackURL,
+			RequestID:       b.requestID,
+			CompletionRatio: completion / float64(len(clips)),
+			Status:          status,
+			Timestamp:       config.Clock.GetTimestampUTC(),
+		}
+	}
+	tsm.Metadata = b.metadata
+	tsm.Clips = clips
+	return tsm
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/livepeer/catalyst-api/clients"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/video"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClipBatchCombinesTheClipStatuses(t *testing.T) {
+	var sent []clients.TranscodeStatusMessage
+	statusClient := clients.TranscodeStatusFunc(func(tsm clients.TranscodeStatusMessage) error {
+		sent = append(sent, tsm)
+		return nil
+	})
+	done := 0
+	batch := newClipBatch(ClipBatchPayload{
+		RequestID:   "batch",
+		CallbackURL: "http://example.com/callback",
+		Metadata:    map[string]string{"stream": "abc"},
+		Clips: []ClipBatchClip{
+			{Title: "Intro", UploadJobPayload: UploadJobPayload{RequestID: "clip1"}},
+			{Title: "Q&A", UploadJobPayload: UploadJobPayload{RequestID: "clip2"}},
+		},
+	}, statusClient, func() { done++ })
+	clip1, clip2 := batch.clipStatusClient(0), batch.clipStatusClient(1)
+
+	require.NoError(t, clip1.SendTranscodeStatus(clients.NewTranscodeStatusProgress("http://example.com/callback", "clip1", clients.TranscodeStatusTranscoding, 0.5)))
+	require.Len(t, sent, 1)
+	require.Equal(t, "batch", sent[0].RequestID)
+	require.Equal(t, clients.TranscodeStatusPreparing, sent[0].Status)
+	require.InDelta(t, 0.325, sent[0].CompletionRatio, 0.001)
+	require.Equal(t, map[string]string{"stream": "abc"}, sent[0].Metadata)
+	require.Equal(t, "Intro", sent[0].Clips[0].Title)
+	require.Equal(t, clients.TranscodeStatusTranscoding, sent[0].Clips[0].Status)
+
+	// The errors of a pipeline with a fallback aren't sent
+	require.NoError(t, clip2.SendTranscodeStatus(clients.NewTranscodeStatusError("", "clip2", "ffmpeg failed", false)))
+	require.Len(t, sent, 1)
+
+	outputs := []video.OutputVideo{{Type: "object_store", Manifest: "clip1/index.m3u8"}}
+	require.NoError(t, clip1.SendTranscodeStatus(clients.NewTranscodeStatusCompleted("http://example.com/callback", "clip1", video.InputVideo{}, outputs)))
+	require.Len(t, sent, 2)
+	require.False(t, sent[1].IsTerminal())
+	require.InDelta(t, 0.5, sent[1].CompletionRatio, 0.001)
+	require.Equal(t, outputs, sent[1].Clips[0].Outputs)
+	require.Zero(t, done)
+
+	clipErr := clients.NewTranscodeStatusError("http://example.com/callback", "clip2", "external failed", false)
+	clipErr.ErrorCode = catErrs.CodeTranscodeFailed
+	require.NoError(t, clip2.SendTranscodeStatus(clipErr))
+	require.Len(t, sent, 3)
+	require.Equal(t, clients.TranscodeStatusError, sent[2].Status)
+	require.Equal(t, "1 of 2 clips failed", sent[2].Error)
+	require.Equal(t, catErrs.CodeTranscodeFailed, sent[2].ErrorCode)
+	require.Equal(t, "external failed", sent[2].Clips[1].Error)
+	require.Equal(t, catErrs.CodeTranscodeFailed, sent[2].Clips[1].ErrorCode)
+	require.Equal(t, 1, done)
+
+	// Nothing is sent after the batch is done
+	require.NoError(t, clip2.SendTranscodeStatus(clients.NewTranscodeStatusProgress("http://example.com/callback", "clip2", clients.TranscodeStatusTranscoding, 1)))
+	require.Len(t, sent, 3)
+}
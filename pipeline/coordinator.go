@@ -1,8 +1,9 @@
 package pipeline
 
 import (
-	"crypto/rsa"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"math"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -24,6 +26,7 @@ import (
 	"github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/log"
 	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/catalyst-api/playback"
 	"github.com/livepeer/catalyst-api/video"
 )
 
@@ -78,11 +81,44 @@ type UploadJobPayload struct {
 	InputFileInfo         video.InputVideo
 	SourceCopy            bool
 	ClipStrategy          video.ClipStrategy
+	TrackSelection        video.TrackSelection
 	C2PA                  bool
+	// Source files shared with the other clips of a batch, see StartClipBatch
+	ClipSources *clients.ClipSources
+	// Caps the rendition bitrates based on the source, nil to only use the default ladder's capping
+	LadderCap *video.LadderCap
+	// How the generated renditions are named, config.RenditionNaming when unset
+	RenditionNaming video.RenditionNaming
+	// Only transcodes some of the renditions or segments again, reusing the existing outputs at the targets
+	Retranscode *video.PartialRetranscode
+	// Conditions the HLS outputs for server-side ad insertion
+	ConditionedOutput *video.ConditionedOutput
+	// Looks for black frames and silence in the source before transcoding it
+	BlankDetection *video.BlankDetection
+	// Identifies the spoken language of the primary audio track of the source
+	DetectLanguage bool
+	// Transcodes a recording with missing segments around the gaps instead of failing the job
+	RepairRecording bool
+	// Checks the segments of an HLS source for corruption before transcoding it
+	SegmentIntegrity *video.SegmentIntegrityCheck
+	// Percentages of the source segments transcoded that milestone status messages are sent at, along with the
+	// playable milestones. No milestones are sent when empty.
+	Milestones []int
+	// How long the job may run for before it's failed, config.DefaultJobDeadline when unset. No deadline when neither is set.
+	Deadline time.Duration
+	// Opaque caller metadata, echoed in all status callbacks and the metrics DB
+	Metadata map[string]string
+	// Idempotency-Key of the request, the job's retries are deduplicated by
+	IdempotencyKey string
+	// Only downloads the source from public addresses, for the sources given by third parties such as the imported
+	// feeds, see clients.WithPublicAddressesOnly
+	PublicSourceOnly bool
 }
 
 type EncryptionPayload struct {
 	EncryptedKey string `json:"encrypted_key"`
+	// ID of the node's key the source was encrypted for, as served with its public key. The current key when unset.
+	KeyID string `json:"key_id,omitempty"`
 }
 
 // UploadJobResult is the object returned by the successful execution of an
@@ -102,6 +138,8 @@ type JobInfo struct {
 	SegmentingTargetURL string
 
 	statusClient clients.TranscodeStatusClient
+	// Records the timeline, probe results and callbacks of the job for its debug bundle
+	journal *jobJournal
 
 	SourcePlaybackDone time.Time
 	DownloadDone       time.Time
@@ -130,7 +168,45 @@ type JobInfo struct {
 	inFallbackMode        bool
 	SignedSourceURL       string
 	LivepeerSupported     bool
-	C2PA                  *c2pa.C2PA
+	// Signs the outputs of jobs asking for C2PA signing, nil otherwise
+	C2PA        *c2pa.Signer
+	environment JobEnvironment
+	// Black frames and silence found in the source, reported in the final callback
+	blankReport *video.BlankReport
+	// The language of the audio of the source, when asked for and detected
+	languageDetection *clients.LanguageDetection
+	// Set when the source was a live playlist still being written, of which the segments listed at the start were used
+	sourceSnapshot bool
+	// The segments of the recording that were missing, when asked to repair it
+	recordingRepair clients.RecordingRepair
+	// The corrupt segments of the source, when asked to check them
+	segmentIntegrity *video.SegmentIntegrityReport
+	// Bytes moved over the network by the job, counted through its context, and how much of it was already exported
+	// when a pipeline before the fallback one finished
+	transfer         *clients.TransferStats
+	transferExported clients.TransferReport
+	// File the log lines of the job are captured into, empty if they aren't, see startJobLogs
+	logsPath string
+	// The milestones already sent, and those waiting to be, see sendMilestone
+	milestonesSent    map[string]bool
+	milestoneQueue    []clients.TranscodeStatusMessage
+	sendingMilestones bool
+	// Code of the error failing the job, recorded in the vod_completed metrics
+	errorCode string
+
+	// ctx is done once the job's deadline passes, aborting whichever stage is running. Shared with the fallback
+	// pipeline, so the deadline covers both.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	deadline time.Duration
+	// stage is the part of the pipeline being run, reported when the deadline is exceeded
+	stage string
+
+	// statusMu guards stage and the last reported progress, which are read by ListJobs while the handler holds mu
+	statusMu       sync.Mutex
+	createdAt      time.Time
+	lastStatus     clients.TranscodeStatus
+	lastCompletion float64
 }
 
 // PipelineInfo represents the state of an individual pipeline, i.e. ffmpeg or mediaconvert
@@ -146,9 +222,134 @@ type PipelineInfo struct {
 }
 
 func (j *JobInfo) ReportProgress(stage clients.TranscodeStatus, completionRatio float64) {
+	j.statusMu.Lock()
+	j.lastStatus, j.lastCompletion = stage, completionRatio
+	j.statusMu.Unlock()
+
 	tsm := clients.NewTranscodeStatusProgress(j.CallbackURL, j.RequestID, stage, completionRatio)
+	tsm.Metadata = j.Metadata
 	// Ignore errors, send the progress next time
 	_ = j.statusClient.SendTranscodeStatus(tsm)
+
+	if stage != clients.TranscodeStatusTranscoding {
+		return
+	}
+	for _, percent := range j.Milestones {
+		if completionRatio*100 >= float64(percent) {
+			j.sendMilestone(stage, completionRatio, clients.Milestone{Event: clients.MilestoneSegments, Percent: percent})
+		}
+	}
+}
+
+// reportPlayable sends the playable milestone once the HLS manifests of the renditions are published
+func (j *JobInfo) reportPlayable(manifestURL string) {
+	j.sendMilestone(clients.TranscodeStatusTranscoding, 1, clients.Milestone{Event: clients.MilestonePlayable, PreviewURL: previewURL(manifestURL)})
+}
+
+// sendMilestone sends a status message for the milestone, unless the job didn't ask for milestones or it was already
+// sent. The messages are sent in order in the background, so that a slow callback endpoint doesn't hold up the job.
+func (j *JobInfo) sendMilestone(stage clients.TranscodeStatus, completionRatio float64, milestone clients.Milestone) {
+	if len(j.Milestones) == 0 {
+		return
+	}
+	key := fmt.Sprintf("%s:%d", milestone.Event, milestone.Percent)
+	j.statusMu.Lock()
+	if j.milestonesSent[key] {
+		j.statusMu.Unlock()
+		return
+	}
+	if j.milestonesSent == nil {
+		j.milestonesSent = map[string]bool{}
+	}
+	j.milestonesSent[key] = true
+	log.Log(j.RequestID, "Job reached milestone", "event", milestone.Event, "percent", milestone.Percent)
+	tsm := clients.NewTranscodeStatusMilestone(j.CallbackURL, j.RequestID, stage, completionRatio, milestone)
+	tsm.Metadata = j.Metadata
+	j.milestoneQueue = append(j.milestoneQueue, tsm)
+	start := !j.sendingMilestones
+	j.sendingMilestones = true
+	j.statusMu.Unlock()
+
+	if start {
+		go j.sendQueuedMilestones()
+	}
+}
+
+// sendQueuedMilestones sends the queued milestone messages until there's none left
+func (j *JobInfo) sendQueuedMilestones() {
+	for {
+		j.statusMu.Lock()
+		if len(j.milestoneQueue) == 0 {
+			j.sendingMilestones = false
+			j.statusMu.Unlock()
+			return
+		}
+		tsm := j.milestoneQueue[0]
+		j.milestoneQueue = j.milestoneQueue[1:]
+		j.statusMu.Unlock()
+
+		if err := j.statusClient.SendTranscodeStatus(tsm); err != nil {
+			log.LogError(j.RequestID, "failed to send milestone status message", err, "event", tsm.Milestone.Event)
+		}
+	}
+}
+
+// previewURL is the manifest URL without the credentials of the object store it's in
+func previewURL(manifestURL string) string {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// setStage records the part of the pipeline the job moved on to
+func (j *JobInfo) setStage(stage string) {
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	j.stage = stage
+	j.journal.record("stage", stage)
+}
+
+func (j *JobInfo) currentStage() string {
+	j.statusMu.Lock()
+	defer j.statusMu.Unlock()
+	return j.stage
+}
+
+// jobCtx returns the context bounding the job to its deadline
+func (j *JobInfo) jobCtx() context.Context {
+	if j.ctx == nil {
+		return context.Background()
+	}
+	return j.ctx
+}
+
+// deadlineError names the stage that was running when the job's deadline passed, leaving other errors as they are
+func (j *JobInfo) deadlineError(err error) error {
+	if j.ctx == nil || j.ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	return errors.WithClass(errors.ClassTimeout, fmt.Errorf("job exceeded its %s deadline during the %s stage: %w", j.deadline, j.currentStage(), err))
+}
+
+// segmentBoundaries returns where the source is cut for conditioned outputs, nil when they aren't requested
+func (j *JobInfo) segmentBoundaries() []float64 {
+	return j.ConditionedOutput.SegmentBoundaries(j.InputFileInfo.Duration, j.TargetSegmentSizeSecs)
+}
+
+// metadataJSON returns the caller metadata of the job for the metrics DB, NULL if there's none
+func (j *JobInfo) metadataJSON() sql.NullString {
+	if len(j.Metadata) == 0 {
+		return sql.NullString{}
+	}
+	metadata, err := json.Marshal(j.Metadata)
+	if err != nil {
+		log.LogError(j.RequestID, "failed to encode job metadata", err)
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(metadata), Valid: true}
 }
 
 func ClippingRetryBackoff() backoff.BackOff {
@@ -163,16 +364,38 @@ type Coordinator struct {
 	statusClient clients.TranscodeStatusClient
 
 	pipeFfmpeg, pipeExternal Handler
-
-	Jobs                 *cache.Cache[*JobInfo]
-	MetricsDB            *sql.DB
-	InputCopy            clients.InputCopier
-	VodDecryptPrivateKey *rsa.PrivateKey
-	SourceOutputURL      *url.URL
-	C2PA                 *c2pa.C2PA
+	broadcaster              clients.BroadcasterClient
+
+	Jobs          *cache.Cache[*JobInfo]
+	MetricsDB     *sql.DB
+	MetricsExport *MetricsExporter
+	InputCopy     clients.InputCopier
+	// Private keys of the node that encrypted sources are decrypted with
+	VodDecryptKeys  *crypto.KeyRing
+	SourceOutputURL *url.URL
+	C2PA            *c2pa.C2PA
+	// Identifies the language of the audio of the sources of the jobs asking for it, nil when not configured
+	LanguageDetector clients.LanguageDetector
+
+	pendingUploads *pendingUploads
+
+	pipelineDecisions *pipelineDecisions
+
+	// idempotencyMu guards idempotencyKeys and idempotencyKeyLocks, it's never held while the shared backend is called
+	idempotencyMu       sync.Mutex
+	idempotencyKeys     map[string]*idempotentJob
+	idempotencyKeyLocks map[string]*idempotencyKeyLock
+	// shares the idempotency keys with the other nodes, nil when there's no shared cache backend
+	idempotencyBackend cache.Backend
+
+	throughput  jobThroughput
+	journals    jobJournals
+	feedImports feedImports
+	// The marker written in the transfer dirs of the jobs for the janitor to clean them up, nil when it doesn't run
+	transferOwnerMarker atomic.Pointer[string]
 }
 
-func NewCoordinator(strategy Strategy, sourceOutputURL, extTranscoderURL string, statusClient clients.TranscodeStatusClient, metricsDB *sql.DB, VodDecryptPrivateKey *rsa.PrivateKey, broadcasterURL string, sourcePlaybackHosts map[string]string, c2pa *c2pa.C2PA) (*Coordinator, error) {
+func NewCoordinator(strategy Strategy, sourceOutputURL, extTranscoderURL string, statusClient clients.TranscodeStatusClient, metricsDB *sql.DB, metricsExport *MetricsExporter, vodDecryptKeys *crypto.KeyRing, broadcaster clients.BroadcasterClient, sourcePlaybackHosts map[string]string, sourceSessions *playback.SourceSessions, c2pa *c2pa.C2PA, languageDetector clients.LanguageDetector) (*Coordinator, error) {
 	if !strategy.IsValid() {
 		return nil, fmt.Errorf("invalid strategy: %s", strategy)
 	}
@@ -192,11 +415,6 @@ func NewCoordinator(strategy Strategy, sourceOutputURL, extTranscoderURL string,
 	if err != nil {
 		return nil, fmt.Errorf("cannot create sourceOutputUrl: %w", err)
 	}
-	broadcaster, err := clients.NewLocalBroadcasterClient(broadcasterURL)
-	if err != nil {
-		return nil, fmt.Errorf("cannot initalilze local broadcaster: %w", err)
-	}
-
 	return &Coordinator{
 		strategy:     strategy,
 		statusClient: statusClient,
@@ -205,14 +423,21 @@ func NewCoordinator(strategy Strategy, sourceOutputURL, extTranscoderURL string,
 			Broadcaster:         broadcaster,
 			probe:               video.Probe{},
 			sourcePlaybackHosts: sourcePlaybackHosts,
+			sourceSessions:      sourceSessions,
 		},
-		pipeExternal:         &external{extTranscoder},
-		Jobs:                 cache.New[*JobInfo](),
-		MetricsDB:            metricsDB,
-		InputCopy:            clients.NewInputCopy(),
-		VodDecryptPrivateKey: VodDecryptPrivateKey,
-		SourceOutputURL:      sourceOutput,
-		C2PA:                 c2pa,
+		pipeExternal:       &external{extTranscoder},
+		broadcaster:        broadcaster,
+		Jobs:               cache.NewWithOptions[*JobInfo](cache.Options{Name: "jobs"}),
+		MetricsDB:          metricsDB,
+		MetricsExport:      metricsExport,
+		InputCopy:          clients.NewInputCopy(),
+		VodDecryptKeys:     vodDecryptKeys,
+		SourceOutputURL:    sourceOutput,
+		C2PA:               c2pa,
+		LanguageDetector:   languageDetector,
+		pendingUploads:     newPendingUploads(cache.Shared),
+		pipelineDecisions:  newPipelineDecisions(sourceOutput, config.PipelineDecisionTTL),
+		idempotencyBackend: cache.Shared,
 	}, nil
 }
 
@@ -238,11 +463,12 @@ func NewStubCoordinatorOpts(strategy Strategy, statusClient clients.TranscodeSta
 		statusClient: statusClient,
 		pipeFfmpeg:   pipeFfmpeg,
 		pipeExternal: pipeExternal,
-		Jobs:         cache.New[*JobInfo](),
+		Jobs:         cache.NewWithOptions[*JobInfo](cache.Options{Name: "jobs"}),
 		InputCopy: &clients.InputCopy{
 			Probe: video.Probe{},
 		},
 		SourceOutputURL: &url.URL{},
+		pendingUploads:  newPendingUploads(nil),
 	}
 }
 
@@ -251,15 +477,48 @@ func NewStubCoordinatorOpts(strategy Strategy, statusClient clients.TranscodeSta
 // This has the main logic regarding the pipeline strategy. It starts jobs and
 // handles processing the response and triggering a fallback if appropriate.
 func (c *Coordinator) StartUploadJob(p UploadJobPayload) {
+	c.startUploadJobReporting(p, c.statusClient)
+}
+
+// startUploadJobReporting is StartUploadJob sending the status of the job to the given client, e.g. to combine the
+// statuses of the clips of a batch
+func (c *Coordinator) startUploadJobReporting(p UploadJobPayload, statusClient clients.TranscodeStatusClient) {
 	streamName := config.SegmentingStreamName(p.RequestID)
 	log.AddContext(p.RequestID, "stream_name", streamName)
+	deadline := p.Deadline
+	if deadline <= 0 {
+		deadline = config.DefaultJobDeadline
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if deadline > 0 {
+		log.AddContext(p.RequestID, "deadline", deadline)
+		ctx, cancel = context.WithTimeout(context.Background(), deadline)
+	}
+	transfer := clients.NewTransferStats()
+	ctx = clients.WithTransferStats(ctx, transfer)
+	if p.PublicSourceOnly {
+		ctx = clients.WithPublicAddressesOnly(ctx)
+	}
+	environment := c.snapshotEnvironment(p)
+	journal := c.journals.start(p.RequestID, environment)
+	journal.record("created", string(environment.PipelineStrategy))
+	journal.setState("running")
 	si := &JobInfo{
 		UploadJobPayload: p,
-		statusClient:     c.statusClient,
+		statusClient:     journal.statusClient(statusClient),
+		journal:          journal,
 		StreamName:       streamName,
 
 		numProfiles:    len(p.Profiles),
 		catalystRegion: os.Getenv("MY_REGION"),
+		environment:    environment,
+		ctx:            ctx,
+		cancel:         cancel,
+		deadline:       deadline,
+		transfer:       transfer,
+		logsPath:       startJobLogs(p.RequestID),
+		stage:          "input_copy",
+		createdAt:      time.Now(),
 		PipelineInfo: PipelineInfo{
 			startTime: time.Now(),
 			state:     "segmenting",
@@ -279,14 +538,19 @@ func (c *Coordinator) StartUploadJob(p UploadJobPayload) {
 		var decryptor *crypto.DecryptionKeys
 
 		if p.Encryption != nil {
+			decryptKey, err := c.VodDecryptKeys.Get(p.Encryption.KeyID)
+			if err != nil {
+				return nil, errors.Unretriable(errors.WithClass(errors.ClassInvalidRequest, fmt.Errorf("error selecting decryption key: %w", err)))
+			}
 			decryptor = &crypto.DecryptionKeys{
-				DecryptKey:   c.VodDecryptPrivateKey,
+				DecryptKey:   decryptKey,
 				EncryptedKey: p.Encryption.EncryptedKey,
 			}
 		}
 
 		osTransferURL := c.SourceOutputURL.JoinPath(p.RequestID, "transfer", path.Base(sourceURL.Path))
 		originalSource := sourceURL
+		c.markTransferOwner(ctx, p.RequestID)
 
 		// Update osTransferURL if needed
 		if clients.IsHLSInput(sourceURL) {
@@ -295,27 +559,46 @@ func (c *Coordinator) StartUploadJob(p UploadJobPayload) {
 			// it happens when there's a very large input manifest
 			if !p.ClipStrategy.Enabled {
 				// Handle falling back to backup bucket for manifest and segments
-				sourceURL, err = clients.RecordingBackupCheck(p.RequestID, sourceURL, osTransferURL.JoinPath(".."))
+				if p.RepairRecording {
+					sourceURL, si.recordingRepair, err = clients.RepairRecording(p.RequestID, sourceURL, osTransferURL.JoinPath(".."))
+					if err == nil && len(si.recordingRepair.MissingSegments) > 0 {
+						si.journal.record("recording_repaired", fmt.Sprintf("missing segments %v, %d recovered from backup", si.recordingRepair.MissingSegments, si.recordingRepair.RecoveredSegments))
+					}
+				} else {
+					sourceURL, err = clients.RecordingBackupCheck(p.RequestID, sourceURL, osTransferURL.JoinPath(".."))
+				}
 				if err != nil {
 					return nil, err
 				}
+				// A stream that's still running is made a VOD of up to now. Clips are bounded by their end time already.
+				sourceURL, si.sourceSnapshot, err = clients.SnapshotLiveManifest(p.RequestID, sourceURL, osTransferURL.JoinPath(".."))
+				if err != nil {
+					return nil, err
+				}
+				if p.SegmentIntegrity != nil {
+					if sourceURL, err = c.checkSegmentIntegrity(si, sourceURL, osTransferURL.JoinPath("..")); err != nil {
+						return nil, err
+					}
+				}
 			}
 
 			// Currently we only clip an HLS source (e.g recordings or transcoded asset)
 			if p.ClipStrategy.Enabled {
+				si.setStage("clipping")
 				err := backoff.Retry(func() error {
 					log.Log(p.RequestID, "clippity clipping the input", "Playback-ID", p.ClipStrategy.PlaybackID)
 					// Use new clipped manifest as the source URL
-					clipSourceURL, err := clients.ClipInputManifest(p.RequestID, sourceURL.String(), p.ClipTargetURL.String(), p.ClipStrategy.StartTime, p.ClipStrategy.EndTime)
+					clipSourceURL, err := clients.ClipInputManifest(p.RequestID, sourceURL.String(), p.ClipTargetURL.String(), p.ClipStrategy.StartTime, p.ClipStrategy.EndTime, p.ClipStrategy.SessionStartTime, p.TrackSelection, p.ClipSources)
 					if err != nil {
 						return fmt.Errorf("clipping failed: %s %w", sourceURL.Redacted(), err)
 					}
 					sourceURL = clipSourceURL
 					return nil
-				}, ClippingRetryBackoff())
+				}, backoff.WithContext(ClippingRetryBackoff(), ctx))
 				if err != nil {
 					return nil, err
 				}
+				si.setStage("input_copy")
 			}
 			// Use the source URL location as the transfer directory to hold the clipped outputs
 			osTransferURL = sourceURL
@@ -324,15 +607,24 @@ func (c *Coordinator) StartUploadJob(p UploadJobPayload) {
 			osTransferURL = p.HlsTargetURL.JoinPath("video")
 		}
 
-		inputVideoProbe, signedNewSourceURL, err := c.InputCopy.CopyInputToS3(p.RequestID, sourceURL, osTransferURL, decryptor)
+		inputVideoProbe, signedNewSourceURL, err := c.InputCopy.CopyInputToS3(ctx, p.RequestID, sourceURL, osTransferURL, decryptor)
 		if err != nil {
 			return nil, fmt.Errorf("error copying input to storage: %w", err)
 		}
 
 		checkClipResolution(p, &inputVideoProbe, originalSource)
 
+		if config.NormalizeSources && !clients.IsHLSInput(sourceURL) {
+			if n := video.NeedsNormalization(inputVideoProbe); n != nil {
+				osTransferURL, signedNewSourceURL, inputVideoProbe, err = c.normalizeSource(ctx, si, n, signedNewSourceURL, inputVideoProbe, c.SourceOutputURL.JoinPath(p.RequestID, "transfer"))
+				if err != nil {
+					return nil, fmt.Errorf("error normalizing the source: %w", err)
+				}
+			}
+		}
+
 		if p.C2PA {
-			si.C2PA = c.C2PA
+			si.C2PA = c.C2PA.NewSigner(config.C2PASigningWorkers)
 		}
 		si.SourceFile = osTransferURL.String() // OS URL used by ffmpeg pipeline
 		log.AddContext(p.RequestID, "new_source_url", si.SourceFile)
@@ -341,6 +633,8 @@ func (c *Coordinator) StartUploadJob(p UploadJobPayload) {
 		log.AddContext(p.RequestID, "signed_url", si.SignedSourceURL)
 
 		si.InputFileInfo = inputVideoProbe
+		si.journal.setProbe(inputVideoProbe)
+		si.TrackSelection = si.TrackSelection.ForSource(inputVideoProbe)
 
 		shouldGenerateMP4, reason := ShouldGenerateMP4(sourceURL, p.Mp4TargetURL, p.FragMp4TargetURL, p.Mp4OnlyShort, si.InputFileInfo.Duration)
 		log.Log(si.RequestID, "Deciding whether to generate MP4s", "should_generate", shouldGenerateMP4, "duration", si.InputFileInfo.Duration, "reason", reason)
@@ -348,6 +642,41 @@ func (c *Coordinator) StartUploadJob(p UploadJobPayload) {
 
 		si.DownloadDone = time.Now()
 
+		// Conditioned outputs are cut from the source file, which HLS sources aren't segmented from, at times that
+		// depend on its duration
+		if p.ConditionedOutput != nil && si.InputFileInfo.Format == "hls" {
+			return nil, fmt.Errorf("conditioned outputs can't be generated from HLS sources")
+		}
+		if p.ConditionedOutput != nil && si.InputFileInfo.Duration <= 0 {
+			return nil, fmt.Errorf("conditioned outputs can't be generated from sources of unknown duration")
+		}
+
+		if p.BlankDetection != nil {
+			si.setStage("blank_detection")
+			si.blankReport, err = video.DetectBlank(ctx, p.RequestID, signedNewSourceURL, si.InputFileInfo, *p.BlankDetection)
+			if err != nil {
+				return nil, err
+			}
+			if p.BlankDetection.FailIfBlank && si.blankReport.AllBlack {
+				return nil, errors.Unretriable(errors.WithClass(errors.ClassUnsupportedInput, fmt.Errorf("the video of the source is entirely black")))
+			}
+			if p.BlankDetection.FailIfBlank && si.blankReport.AllSilent {
+				return nil, errors.Unretriable(errors.WithClass(errors.ClassUnsupportedInput, fmt.Errorf("the audio of the source is entirely silent")))
+			}
+		}
+
+		if p.DetectLanguage && c.LanguageDetector != nil {
+			si.setStage("language_detection")
+			si.languageDetection = c.detectLanguage(ctx, p.RequestID, signedNewSourceURL, si.InputFileInfo, p.TrackSelection)
+		}
+
+		// The external pipeline can't reuse the existing outputs, so it can't do partial re-transcodes
+		if p.Retranscode != nil {
+			if ok, _ := checkLivepeerCompatible(p.RequestID, StrategyFallbackExternal, si.InputFileInfo); !ok {
+				return nil, fmt.Errorf("partial re-transcodes are only supported for sources the %s pipeline can transcode", StrategyCatalystFfmpegDominance)
+			}
+		}
+
 		c.startUploadJob(si)
 		return nil, nil
 	})
@@ -389,6 +718,28 @@ func checkClipResolution(p UploadJobPayload, inputVideoProbe *video.InputVideo,
 	}
 }
 
+// checkSegmentIntegrity checks the segments of an HLS source for corruption, failing the job up front or leaving them
+// out of the source as asked, and returns the URL of the source to transcode
+func (c *Coordinator) checkSegmentIntegrity(si *JobInfo, sourceURL, osTransferURL *url.URL) (*url.URL, error) {
+	si.setStage("segment_integrity")
+	defer si.setStage("input_copy")
+	check := *si.SegmentIntegrity
+	checkedURL, report, err := clients.CheckSourceSegments(si.jobCtx(), si.RequestID, sourceURL, osTransferURL, check)
+	si.segmentIntegrity = report
+	if report != nil && len(report.CorruptSegments) > 0 {
+		si.journal.record("segment_integrity", fmt.Sprintf("%d of %d segments corrupt, %s", len(report.CorruptSegments), report.CheckedSegments, check.Action()))
+	}
+	if err != nil {
+		// e.g. a segment failing to download, retried with the job
+		return nil, fmt.Errorf("error checking the source segments: %w", err)
+	}
+	if len(report.CorruptSegments) > 0 && check.Action() == video.SegmentIntegrityFail {
+		first := report.CorruptSegments[0]
+		return nil, errors.Unretriable(errors.WithClass(errors.ClassUnsupportedInput, fmt.Errorf("%d of the %d source segments are corrupt, the first is segment %d: %s", len(report.CorruptSegments), report.CheckedSegments, first.Index, first.Reason)))
+	}
+	return checkedURL, nil
+}
+
 func ShouldGenerateMP4(sourceURL, mp4TargetUrl *url.URL, fragMp4TargetUrl *url.URL, mp4OnlyShort bool, durationSecs float64) (bool, string) {
 	// Skip mp4 generation if we weren't able to determine the duration of the input file for any reason
 	if durationSecs == 0.0 {
@@ -417,6 +768,7 @@ func (c *Coordinator) startUploadJob(p *JobInfo) {
 		strategy = p.PipelineStrategy
 	}
 	p.LivepeerSupported, strategy = checkLivepeerCompatible(p.RequestID, strategy, p.InputFileInfo)
+	p.environment.PipelineStrategy = strategy
 	log.AddContext(p.RequestID, "strategy", strategy)
 	log.Log(p.RequestID, "Starting upload job")
 
@@ -426,13 +778,25 @@ func (c *Coordinator) startUploadJob(p *JobInfo) {
 	case StrategyCatalystFfmpegDominance:
 		c.startOneUploadJob(p, c.pipeFfmpeg, false)
 	case StrategyFallbackExternal:
+		if outcome, ok := c.pipelineDecisions.Lookup(p.RequestID, p.InputFileInfo.Checksum); ok {
+			log.Log(p.RequestID, "Skipping the pipeline that previously failed for the same content", "failed_pipeline", outcome.FailedPipeline, "recorded_at", outcome.RecordedAt)
+			p.environment.PipelineStrategy = StrategyExternalDominance
+			c.startOneUploadJob(p, c.pipeExternal, false)
+			return
+		}
 		// nolint:errcheck
 		go recovered(func() (t bool, e error) {
 			success := <-c.startOneUploadJob(p, c.pipeFfmpeg, true)
 			if !success {
 				p.inFallbackMode = true
 				log.Log(p.RequestID, "Entering fallback pipeline")
-				c.startOneUploadJob(p, c.pipeExternal, false)
+				if <-c.startOneUploadJob(p, c.pipeExternal, false) {
+					c.pipelineDecisions.Record(p.RequestID, p.InputFileInfo.Checksum, pipelineOutcome{
+						FailedPipeline:    c.pipeFfmpeg.Name(),
+						SucceededPipeline: c.pipeExternal.Name(),
+						RecordedAt:        time.Now(),
+					})
+				}
 			}
 			return
 		})
@@ -458,6 +822,12 @@ func checkLivepeerCompatible(requestID string, strategy Strategy, iv video.Input
 			log.Log(requestID, "video rotation not supported by Livepeer pipeline", "rotation", track.Rotation)
 			return livepeerNotSupported(strategy)
 		}
+		// The transcoders can't deinterlace, MediaConvert does. The ffmpeg pipeline rejects the interlaced sources when
+		// it's forced
+		if track.Type == video.TrackTypeVideo && track.Interlaced() {
+			log.Log(requestID, "interlaced video not supported by Livepeer pipeline", "fieldOrder", track.FieldOrder)
+			return livepeerNotSupported(strategy)
+		}
 		if !checkDisplayAspectRatio(track, requestID) {
 			return livepeerNotSupported(strategy)
 		}
@@ -541,6 +911,8 @@ func (c *Coordinator) startOneUploadJob(si *JobInfo, handler Handler, hasFallbac
 		audioStartTimeSec = audioTrack.StartTimeSec
 	}
 
+	si.journal.record("pipeline", pipeline)
+	si.journal.setState("running")
 	si.PipelineInfo = PipelineInfo{
 		startTime:          time.Now(),
 		result:             make(chan bool, 1),
@@ -604,27 +976,77 @@ func (c *Coordinator) finishJob(job *JobInfo, out *HandlerOutput, err error) {
 	defer close(job.result)
 	var tsm clients.TranscodeStatusMessage
 	if err != nil {
+		err = job.deadlineError(err)
 		callbackURL := job.CallbackURL
 		if job.hasFallback {
 			// an empty url will skip actually sending the callback. we still want the log tho
 			callbackURL = ""
 		}
 		tsm = clients.NewTranscodeStatusError(callbackURL, job.RequestID, err.Error(), errors.IsUnretriable(err))
+		tsm.ErrorCode = errors.Code(err)
+		job.errorCode = tsm.ErrorCode
+		metrics.Metrics.VODPipelineMetrics.JobErrors.WithLabelValues(job.pipeline, errors.Class(err), strconv.FormatBool(tsm.Unretriable)).Inc()
+		tsm.Environment = job.environmentJSON()
 		job.state = "failed"
 	} else {
+		if config.PublishedManifestTimeout > 0 {
+			job.setStage("verifying_manifests")
+			if err := clients.WaitForPublishedManifests(job.jobCtx(), job.RequestID, out.Result.Outputs, config.PublishedManifestTimeout); err != nil {
+				log.LogError(job.RequestID, "published manifests aren't served yet, sending the completion callback anyway", err)
+			}
+		}
+		if job.sourceSnapshot {
+			for i := range out.Result.Outputs {
+				out.Result.Outputs[i].SourceSnapshot = true
+			}
+		}
+		if missing := job.recordingRepair.MissingSegments; len(missing) > 0 {
+			for i := range out.Result.Outputs {
+				out.Result.Outputs[i].MissingSegments = missing
+			}
+		}
 		tsm = clients.NewTranscodeStatusCompleted(job.CallbackURL, job.RequestID, out.Result.InputVideo, out.Result.Outputs)
 		job.state = "completed"
 	}
+	tsm.Metadata = job.Metadata
+	tsm.BlankReport = job.blankReport
+	tsm.LanguageDetection = job.languageDetection
+	tsm.SegmentIntegrity = job.segmentIntegrity
+	tsm.SourcePlaybackLatencyMs = job.sourcePlaybackLatency().Milliseconds()
+	tsm.C2PA = job.C2PA.Summary()
+	transfer := job.transfer.Report()
+	tsm.Transfer = &transfer
 	err2 := job.statusClient.SendTranscodeStatus(tsm)
 	if err2 != nil {
 		log.LogError(tsm.RequestID, "failed sending finalize callback, job state set to 'failed'", err2)
 		job.state = "failed"
 	}
 
+	if err != nil {
+		job.journal.record(job.state, err.Error())
+	} else {
+		job.journal.record(job.state, "")
+	}
+	job.journal.setState(job.state)
+
 	// Automatically delete jobs after an error or result
 	success := err == nil && err2 == nil
+	if job.cancel != nil && (success || !job.hasFallback) {
+		job.cancel()
+	}
 	c.Jobs.Remove(job.StreamName)
 	log.Log(job.RequestID, "Finished job and deleted from job cache", "success", success)
+	if success || !job.hasFallback {
+		c.finishIdempotentJob(job.IdempotencyKey, job.RequestID, success)
+		c.throughput.record(job.ClipStrategy.Enabled, time.Now())
+		finishJobLogs(job)
+	}
+	if success && config.CDNWarmUpPrefix != nil {
+		go warmUpCDN(job, out)
+	}
+	if success && config.RepairDTSH {
+		go repairDTSH(job, out)
+	}
 	metrics.Metrics.JobsInFlight.Set(float64(len(c.Jobs.GetKeys())))
 
 	var labels = []string{
@@ -665,11 +1087,23 @@ func (c *Coordinator) finishJob(job *JobInfo, out *HandlerOutput, err error) {
 		WithLabelValues(labels...).
 		Add(float64(job.transcodedSegments))
 
+	job.exportTransfer(transfer)
+
 	c.sendDBMetrics(job, out)
 
 	job.result <- success
 }
 
+// exportTransfer adds the bytes the job moved over the network since the last pipeline finished to the counters of
+// the pipeline that just did
+func (j *JobInfo) exportTransfer(transfer clients.TransferReport) {
+	counters := metrics.Metrics.VODPipelineMetrics.TransferBytes
+	counters.WithLabelValues(j.pipeline, "download").Add(float64(transfer.DownloadedBytes - j.transferExported.DownloadedBytes))
+	counters.WithLabelValues(j.pipeline, "upload").Add(float64(transfer.TotalUploadedBytes() - j.transferExported.TotalUploadedBytes()))
+	counters.WithLabelValues(j.pipeline, "broadcaster").Add(float64(transfer.BroadcasterBytes - j.transferExported.BroadcasterBytes))
+	j.transferExported = transfer
+}
+
 func getProfileCount(out *HandlerOutput) int {
 	if out == nil || out.Result == nil || len(out.Result.Outputs) < 1 {
 		return 0
@@ -678,7 +1112,7 @@ func getProfileCount(out *HandlerOutput) int {
 }
 
 func (c *Coordinator) sendDBMetrics(job *JobInfo, out *HandlerOutput) {
-	if c.MetricsDB == nil {
+	if c.MetricsDB == nil && c.MetricsExport == nil {
 		return
 	}
 
@@ -692,6 +1126,45 @@ func (c *Coordinator) sendDBMetrics(job *JobInfo, out *HandlerOutput) {
 	if job.HlsTargetURL != nil {
 		targetURL = job.HlsTargetURL.Redacted()
 	}
+	metadata := job.metadataJSON()
+	record := VODCompletedRecord{
+		FinishedAt:             time.Now().Unix(),
+		StartedAt:              job.startTime.Unix(),
+		RequestID:              metricsRequestID,
+		ExternalID:             job.ExternalID,
+		SourceCodecVideo:       job.sourceCodecVideo,
+		SourceCodecAudio:       job.sourceCodecAudio,
+		Pipeline:               job.pipeline,
+		CatalystRegion:         job.catalystRegion,
+		State:                  job.state,
+		ProfilesCount:          getProfileCount(out),
+		JobDuration:            time.Since(job.startTime).Milliseconds(),
+		SourceSegmentCount:     job.sourceSegments,
+		TranscodedSegmentCount: job.transcodedSegments,
+		SourceBytesCount:       job.sourceBytes,
+		SourceDuration:         job.sourceDurationMs,
+		SourceURL:              log.RedactURL(job.SourceFile),
+		TargetURL:              targetURL,
+		InFallbackMode:         job.inFallbackMode,
+		SourcePlaybackAt:       job.SourcePlaybackDone.Unix(),
+		DownloadDoneAt:         job.DownloadDone.Unix(),
+		SegmentingDoneAt:       job.SegmentingDone.Unix(),
+		TranscodingDoneAt:      job.TranscodingDone.Unix(),
+		IsClip:                 job.ClipStrategy.Enabled,
+		IsThumbs:               job.ThumbnailsTargetURL != nil,
+		Environment:            job.environmentJSON(),
+		ErrorCode:              job.errorCode,
+	}
+	if metadata.Valid {
+		record.Metadata = json.RawMessage(metadata.String)
+	}
+	if c.MetricsExport != nil {
+		c.MetricsExport.Add(record)
+	}
+	if c.MetricsDB == nil {
+		return
+	}
+
 	insertDynStmt := `insert into "vod_completed"(
                             "finished_at",
                             "started_at",
@@ -716,34 +1189,40 @@ func (c *Coordinator) sendDBMetrics(job *JobInfo, out *HandlerOutput) {
                             "segmenting_done_at",
                             "transcoding_done_at",
                             "is_clip",
-                            "is_thumbs"
-                            ) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)`
+                            "is_thumbs",
+                            "environment",
+                            "metadata",
+                            "error_code"
+                            ) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)`
 	_, err := c.MetricsDB.Exec(
 		insertDynStmt,
-		time.Now().Unix(),
-		job.startTime.Unix(),
-		metricsRequestID,
-		job.ExternalID,
-		job.sourceCodecVideo,
-		job.sourceCodecAudio,
-		job.pipeline,
-		job.catalystRegion,
-		job.state,
-		getProfileCount(out),
-		time.Since(job.startTime).Milliseconds(),
-		job.sourceSegments,
-		job.transcodedSegments,
-		job.sourceBytes,
-		job.sourceDurationMs,
-		log.RedactURL(job.SourceFile),
-		targetURL,
-		job.inFallbackMode,
-		job.SourcePlaybackDone.Unix(),
-		job.DownloadDone.Unix(),
-		job.SegmentingDone.Unix(),
-		job.TranscodingDone.Unix(),
-		job.ClipStrategy.Enabled,
-		job.ThumbnailsTargetURL != nil,
+		record.FinishedAt,
+		record.StartedAt,
+		record.RequestID,
+		record.ExternalID,
+		record.SourceCodecVideo,
+		record.SourceCodecAudio,
+		record.Pipeline,
+		record.CatalystRegion,
+		record.State,
+		record.ProfilesCount,
+		record.JobDuration,
+		record.SourceSegmentCount,
+		record.TranscodedSegmentCount,
+		record.SourceBytesCount,
+		record.SourceDuration,
+		record.SourceURL,
+		record.TargetURL,
+		record.InFallbackMode,
+		record.SourcePlaybackAt,
+		record.DownloadDoneAt,
+		record.SegmentingDoneAt,
+		record.TranscodingDoneAt,
+		record.IsClip,
+		record.IsThumbs,
+		string(record.Environment),
+		metadata,
+		record.ErrorCode,
 	)
 	if err != nil {
 		log.LogError(job.RequestID, "error writing postgres metrics", err)
@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// How long the journal of a job is kept after it's created, as long as its log lines
+const jobJournalTTL = 6 * time.Hour
+
+// DebugBundle is what support needs to debug a job, gathered in one place: GET /api/debug/bundle/:requestID
+type DebugBundle struct {
+	RequestID string `json:"request_id"`
+	InFlight  bool   `json:"in_flight"`
+	State     string `json:"state"`
+	// Redacted snapshot of the config the job ran with
+	Config    JobEnvironment      `json:"config"`
+	Timeline  []JobTimelineEvent  `json:"timeline"`
+	Probe     *video.InputVideo   `json:"probe,omitempty"`
+	Callbacks []JobCallbackRecord `json:"callbacks"`
+	// The latest log lines tagged with the request ID
+	Logs []string `json:"logs"`
+}
+
+// JobTimelineEvent is a step of a job, e.g. a stage of its pipeline starting
+type JobTimelineEvent struct {
+	At     time.Time `json:"at"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// JobCallbackRecord is a status callback sent for a job
+type JobCallbackRecord struct {
+	At              time.Time `json:"at"`
+	URL             string    `json:"url,omitempty"`
+	Status          string    `json:"status"`
+	CompletionRatio float64   `json:"completion_ratio"`
+	Error           string    `json:"error,omitempty"`
+	// Set when the callback couldn't be delivered
+	SendError string `json:"send_error,omitempty"`
+}
+
+// jobJournal records what happened to a job as it runs, kept for a while after it finishes for its debug bundle
+type jobJournal struct {
+	mu          sync.Mutex
+	createdAt   time.Time
+	state       string
+	environment JobEnvironment
+	timeline    []JobTimelineEvent
+	probe       *video.InputVideo
+	callbacks   []JobCallbackRecord
+}
+
+// record adds an event to the timeline of the job. The detail, usually an error, has the credentials of its URLs redacted.
+func (j *jobJournal) record(event, detail string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.timeline = append(j.timeline, JobTimelineEvent{At: time.Now(), Event: event, Detail: log.RedactLine(detail)})
+}
+
+func (j *jobJournal) setState(state string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+}
+
+func (j *jobJournal) setProbe(iv video.InputVideo) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.probe = &iv
+}
+
+// statusClient returns a client sending the callbacks of the job through next and recording them
+func (j *jobJournal) statusClient(next clients.TranscodeStatusClient) clients.TranscodeStatusClient {
+	return clients.TranscodeStatusFunc(func(tsm clients.TranscodeStatusMessage) error {
+		err := next.SendTranscodeStatus(tsm)
+		record := JobCallbackRecord{
+			At:              time.Now(),
+			URL:             log.RedactURL(tsm.URL),
+			Status:          tsm.Status.String(),
+			CompletionRatio: tsm.CompletionRatio,
+			Error:           log.RedactLine(tsm.Error),
+		}
+		if err != nil {
+			record.SendError = log.RedactLine(err.Error())
+		}
+		j.mu.Lock()
+		j.callbacks = append(j.callbacks, record)
+		j.mu.Unlock()
+		return err
+	})
+}
+
+func (j *jobJournal) bundle(requestID string) DebugBundle {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return DebugBundle{
+		RequestID: requestID,
+		State:     j.state,
+		Config:    j.environment,
+		Timeline:  append([]JobTimelineEvent{}, j.timeline...),
+		Probe:     j.probe,
+		Callbacks: append([]JobCallbackRecord{}, j.callbacks...),
+		Logs:      log.RecentLines(requestID),
+	}
+}
+
+// jobJournals keeps the journals of the recent jobs by request ID
+type jobJournals struct {
+	mu       sync.Mutex
+	journals map[string]*jobJournal
+}
+
+// start creates the journal of a job, or returns the existing one when the job is a retry of the request
+func (s *jobJournals) start(requestID string, environment JobEnvironment) *jobJournal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, j := range s.journals {
+		if now.Sub(j.createdAt) > jobJournalTTL {
+			delete(s.journals, id)
+		}
+	}
+	if s.journals == nil {
+		s.journals = map[string]*jobJournal{}
+	}
+	j, ok := s.journals[requestID]
+	if !ok {
+		j = &jobJournal{createdAt: now}
+		s.journals[requestID] = j
+	}
+	j.mu.Lock()
+	j.environment = environment
+	j.mu.Unlock()
+	return j
+}
+
+func (s *jobJournals) get(requestID string) (*jobJournal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.journals[requestID]
+	if !ok || time.Since(j.createdAt) > jobJournalTTL {
+		return nil, false
+	}
+	return j, true
+}
+
+// DebugBundle returns the timeline, config snapshot, probe results, callbacks and log lines of a job that ran on this
+// node recently, false if there's no such job
+func (c *Coordinator) DebugBundle(requestID string) (DebugBundle, bool) {
+	j, ok := c.journals.get(requestID)
+	if !ok {
+		return DebugBundle{}, false
+	}
+	bundle := j.bundle(requestID)
+	for _, job := range c.ListJobs() {
+		if job.RequestID == requestID {
+			bundle.InFlight = true
+		}
+	}
+	return bundle, true
+}
@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+// DeleteOutputsTimeout bounds how long a single delete request can keep deleting files for
+var DeleteOutputsTimeout = 1 * time.Hour
+
+// deleteProgressBatch is how many files are deleted between two progress callbacks
+const deleteProgressBatch = 500
+
+// DeleteOutputsPayload lists the output locations of a job (e.g. its HLS, MP4 and thumbnails target directories)
+// whose files are to be deleted from both the primary and the backup storage
+type DeleteOutputsPayload struct {
+	RequestID   string
+	CallbackURL string
+	URLs        []string
+}
+
+type deleteLocation struct {
+	url   string
+	sess  drivers.OSSession
+	files []drivers.FileInfo
+}
+
+// DeleteOutputs deletes the output files in the background, sending a progress callback per batch of files and a final
+// deleted or error callback once it's done. Returns an error if a job with the same request ID is still running.
+func (c *Coordinator) DeleteOutputs(p DeleteOutputsPayload) error {
+	for _, job := range c.Jobs.GetJobs() {
+		if job.RequestID == p.RequestID {
+			return fmt.Errorf("job %s is still running", p.RequestID)
+		}
+	}
+
+	go recovered(func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), DeleteOutputsTimeout)
+		defer cancel()
+
+		deleted, err := c.deleteOutputs(ctx, p)
+		var tsm clients.TranscodeStatusMessage
+		if err != nil {
+			log.LogError(p.RequestID, "failed to delete outputs", err, "deleted", deleted)
+			tsm = clients.NewTranscodeStatusError(p.CallbackURL, p.RequestID, err.Error(), false)
+			tsm.ErrorCode = catErrs.Code(err)
+		} else {
+			log.Log(p.RequestID, "deleted outputs", "deleted", deleted)
+			tsm = clients.NewTranscodeStatusDeleted(p.CallbackURL, p.RequestID, deleted)
+		}
+		if err := c.statusClient.SendTranscodeStatus(tsm); err != nil {
+			log.LogError(p.RequestID, "failed to send delete callback", err)
+		}
+		return true, nil
+	})
+	return nil
+}
+
+// deleteOutputs lists everything to delete upfront so that the progress can be reported, then deletes the files.
+// Returns the number of files deleted.
+func (c *Coordinator) deleteOutputs(ctx context.Context, p DeleteOutputsPayload) (int, error) {
+	var locations []deleteLocation
+	total := 0
+	for _, u := range p.URLs {
+		urls := []string{u}
+		if backup := config.GetStorageBackupURL(u); backup != "" {
+			urls = append(urls, backup)
+		}
+		for _, locationURL := range urls {
+			location, err := listDeleteLocation(ctx, locationURL)
+			if err != nil {
+				return 0, fmt.Errorf("failed to list %s: %w", log.RedactURL(locationURL), err)
+			}
+			locations = append(locations, location)
+			total += len(location.files)
+		}
+	}
+	log.Log(p.RequestID, "deleting outputs", "files", total, "locations", len(locations))
+
+	deleted := 0
+	for _, location := range locations {
+		for _, f := range location.files {
+			if err := location.sess.DeleteFile(ctx, f.Name); err != nil {
+				return deleted, fmt.Errorf("failed to delete %s from %s: %w", f.Name, log.RedactURL(location.url), err)
+			}
+			deleted++
+			if deleted%deleteProgressBatch == 0 && deleted < total {
+				c.statusClient.SendTranscodeStatus(clients.NewTranscodeStatusProgress(p.CallbackURL, p.RequestID, clients.TranscodeStatusDeleting, float64(deleted)/float64(total))) // nolint:errcheck
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func listDeleteLocation(ctx context.Context, locationURL string) (deleteLocation, error) {
+	osDriver, err := clients.ParseOSURL(strings.TrimSuffix(locationURL, "/"), true)
+	if err != nil {
+		return deleteLocation{}, err
+	}
+	sess := osDriver.NewSession("")
+	var files []drivers.FileInfo
+	if key, keyErr := clients.ObjectStoreKey(locationURL); keyErr == nil && strings.Trim(key, "/") != "" {
+		// The object stores list by key prefix, the separator keeps the locations sharing the prefix, e.g. .../abc2
+		// for .../abc, out of it
+		files, err = listObjects(ctx, sess, strings.Trim(key, "/")+"/")
+	} else {
+		files, err = listAllFiles(ctx, sess, "")
+	}
+	// The backup storage won't have the outputs if the job didn't need to fall back to it
+	if errors.Is(err, os.ErrNotExist) {
+		return deleteLocation{url: locationURL, sess: sess}, nil
+	}
+	if err != nil {
+		return deleteLocation{}, err
+	}
+	return deleteLocation{url: locationURL, sess: sess, files: files}, nil
+}
+
+// listObjects returns all of the objects under the key prefix, named by their full keys
+func listObjects(ctx context.Context, sess drivers.OSSession, prefix string) ([]drivers.FileInfo, error) {
+	page, err := sess.ListFiles(ctx, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	var files []drivers.FileInfo
+	for {
+		files = append(files, page.Files()...)
+		if !page.HasNextPage() {
+			return files, nil
+		}
+		if page, err = page.NextPage(); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteOutputsFromPrimaryAndBackup(t *testing.T) {
+	primary, backup := t.TempDir(), t.TempDir()
+	defer func(urls map[string]string) { config.StorageFallbackURLs = urls }(config.StorageFallbackURLs)
+	config.StorageFallbackURLs = map[string]string{"file://" + primary: "file://" + backup}
+
+	for _, f := range []string{
+		filepath.Join(primary, "hls", "abc", "index.m3u8"),
+		filepath.Join(primary, "hls", "abc", "720p0", "0.ts"),
+		filepath.Join(primary, "hls", "other", "index.m3u8"),
+		filepath.Join(backup, "hls", "abc", "720p0", "1.ts"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+		require.NoError(t, os.WriteFile(f, []byte("data"), 0644))
+	}
+
+	callbackHandler, callbacks := callbacksRecorder()
+	coord := NewStubCoordinatorOpts("", callbackHandler, nil, nil)
+	require.NoError(t, coord.DeleteOutputs(DeleteOutputsPayload{
+		RequestID:   "abc",
+		CallbackURL: "http://localhost/callback",
+		URLs:        []string{"file://" + filepath.Join(primary, "hls", "abc"), "file://" + filepath.Join(primary, "thumbnails", "abc")},
+	}))
+
+	var msg clients.TranscodeStatusMessage
+	for msg.Status != clients.TranscodeStatusDeleted {
+		msg = requireReceive(t, callbacks, 5*time.Second)
+		require.NotEqual(t, clients.TranscodeStatusError, msg.Status, msg.Error)
+	}
+	require.Equal(t, 3, msg.DeletedFiles)
+	require.Equal(t, 1.0, msg.CompletionRatio)
+
+	require.NoFileExists(t, filepath.Join(primary, "hls", "abc", "720p0", "0.ts"))
+	require.NoFileExists(t, filepath.Join(backup, "hls", "abc", "720p0", "1.ts"))
+	require.FileExists(t, filepath.Join(primary, "hls", "other", "index.m3u8"))
+}
+
+func TestDeleteOutputsLeavesSiblingPrefixes(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string]bool{
+		"hls/abc/index.m3u8":    true,
+		"hls/abc/720p0/0.ts":    true,
+		"hls/abc2/index.m3u8":   true,
+		"hls/abcdef/720p0/0.ts": true,
+		"hls/other/abc/0.ts":    true,
+		"thumbnails/abc/0.jpg":  true,
+		"thumbnails/abc2/0.jpg": true,
+	}
+	// Lists and deletes the objects of the bucket like S3 does
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch r.Method {
+		case http.MethodGet:
+			prefix := r.URL.Query().Get("prefix")
+			fmt.Fprint(w, `<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) {
+					fmt.Fprintf(w, `<Contents><Key>%s</Key><ETag>"e"</ETag><LastModified>2024-01-01T00:00:00Z</LastModified><Size>4</Size></Contents>`, key)
+				}
+			}
+			fmt.Fprint(w, `</ListBucketResult>`)
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer s3.Close()
+	bucketURL := "s3+" + strings.Replace(s3.URL, "://", "://user:pass@", 1) + "/bucket"
+
+	callbackHandler, callbacks := callbacksRecorder()
+	coord := NewStubCoordinatorOpts("", callbackHandler, nil, nil)
+	require.NoError(t, coord.DeleteOutputs(DeleteOutputsPayload{
+		RequestID:   "abc",
+		CallbackURL: "http://localhost/callback",
+		URLs:        []string{bucketURL + "/hls/abc", bucketURL + "/thumbnails/abc/"},
+	}))
+
+	var msg clients.TranscodeStatusMessage
+	for msg.Status != clients.TranscodeStatusDeleted {
+		msg = requireReceive(t, callbacks, 5*time.Second)
+		require.NotEqual(t, clients.TranscodeStatusError, msg.Status, msg.Error)
+	}
+	require.Equal(t, 3, msg.DeletedFiles)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string]bool{
+		"hls/abc2/index.m3u8":   true,
+		"hls/abcdef/720p0/0.ts": true,
+		"hls/other/abc/0.ts":    true,
+		"thumbnails/abc2/0.jpg": true,
+	}, objects)
+}
+
+func TestDeleteOutputsRejectsRunningJobs(t *testing.T) {
+	callbackHandler, _ := callbacksRecorder()
+	coord := NewStubCoordinatorOpts("", callbackHandler, nil, nil)
+	coord.Jobs.Store("catalyst_vod_abc", &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "abc"}})
+
+	require.Error(t, coord.DeleteOutputs(DeleteOutputsPayload{RequestID: "abc", URLs: []string{"file:///tmp/hls/abc"}}))
+}
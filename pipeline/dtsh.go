@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// How long checking and repairing the Mist headers of a job's outputs may take, it's given up on after that
+const dtshRepairTimeout = 30 * time.Minute
+
+// Larger headers aren't read, they can't be valid
+const maxDTSHSize = 64 * 1024 * 1024
+
+// Results of the checks of the Mist headers, labelling the vod_dtsh_headers metric
+const (
+	dtshValid     = "valid"
+	dtshGenerated = "generated"
+	dtshRepaired  = "repaired"
+	dtshFailed    = "failed"
+)
+
+// Replaced in tests, Mist isn't installed there
+var generateDTSH = video.GenerateDTSH
+
+// dtshTarget is an MP4 output played back by Mist, which reads the header (.dtsh) next to it when there's one instead
+// of indexing the whole file on the first playback. The HLS outputs aren't checked, Mist doesn't read headers for
+// them.
+type dtshTarget struct {
+	kind  string
+	osURL *url.URL
+	// Of the output, to check the header against
+	tracks []video.InputTrack
+}
+
+// repairDTSH checks the Mist headers of the MP4 outputs of a completed job, having Mist generate the missing and
+// invalid ones again from the outputs themselves
+func repairDTSH(job *JobInfo, out *HandlerOutput) {
+	if out == nil || out.Result == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dtshRepairTimeout)
+	defer cancel()
+	for _, target := range dtshTargets(job, out.Result.Outputs) {
+		result, err := checkDTSH(ctx, job.RequestID, target)
+		if err != nil {
+			log.LogError(job.RequestID, "failed to check the mist header", err, "url", target.osURL.Redacted())
+		} else if result != dtshValid {
+			log.Log(job.RequestID, "checked the mist header", "result", result, "url", target.osURL.Redacted())
+		}
+		metrics.Metrics.VODPipelineMetrics.DTSHHeaders.WithLabelValues(target.kind, result).Inc()
+	}
+}
+
+func dtshTargets(job *JobInfo, outputs []video.OutputVideo) []dtshTarget {
+	var targets []dtshTarget
+	for _, output := range outputs {
+		if job.Mp4TargetURL == nil {
+			continue
+		}
+		for _, mp4 := range output.MP4Outputs {
+			if mp4.Type != "mp4" {
+				continue
+			}
+			u, err := url.Parse(mp4.Location)
+			if err != nil {
+				continue
+			}
+			targets = append(targets, dtshTarget{
+				kind:   "mp4",
+				osURL:  job.Mp4TargetURL.JoinPath(path.Base(u.Path)),
+				tracks: mp4.Tracks,
+			})
+		}
+	}
+	return targets
+}
+
+// checkDTSH returns the result of the check of the header of an output, after repairing it when it's missing or
+// invalid
+func checkDTSH(ctx context.Context, requestID string, target dtshTarget) (string, error) {
+	header, err := readDTSH(ctx, requestID, target.osURL)
+	if err != nil && !catErrs.IsObjectNotFound(err) {
+		return dtshFailed, err
+	}
+	missing := err != nil
+	if !missing && video.ValidateDTSH(header, target.tracks) == nil {
+		return dtshValid, nil
+	}
+
+	if err := regenerateDTSH(ctx, requestID, target); err != nil {
+		// an invalid header is worse than none, Mist would trust it instead of indexing the output
+		if !missing {
+			if err := deleteDTSH(ctx, target.osURL); err != nil {
+				log.LogError(requestID, "failed to delete the invalid mist header", err, "url", target.osURL.Redacted())
+			}
+		}
+		return dtshFailed, err
+	}
+	if missing {
+		return dtshGenerated, nil
+	}
+	return dtshRepaired, nil
+}
+
+func readDTSH(ctx context.Context, requestID string, mediaURL *url.URL) ([]byte, error) {
+	rc, err := clients.GetFile(ctx, requestID, mediaURL.String()+video.DTSHExtension, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxDTSHSize))
+}
+
+// regenerateDTSH downloads the output to have Mist generate its header, which is uploaded next to it once valid
+func regenerateDTSH(ctx context.Context, requestID string, target dtshTarget) error {
+	dir, err := os.MkdirTemp("", "dtsh-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Base(target.osURL.Path)
+	mediaPath := filepath.Join(dir, filename)
+	if err := downloadTo(ctx, requestID, target.osURL.String(), mediaPath); err != nil {
+		return fmt.Errorf("failed to download the output: %w", err)
+	}
+	header, err := generateDTSH(ctx, mediaPath)
+	if err != nil {
+		return err
+	}
+	if err := video.ValidateDTSH(header, target.tracks); err != nil {
+		return fmt.Errorf("generated header: %w", err)
+	}
+	dirURL := target.osURL.JoinPath("..")
+	return clients.UploadToOSURLFields(ctx, dirURL.String(), filename+video.DTSHExtension, bytes.NewReader(header), dtshRepairTimeout, nil)
+}
+
+func deleteDTSH(ctx context.Context, mediaURL *url.URL) error {
+	osDriver, err := clients.ParseOSURL(mediaURL.JoinPath("..").String(), true)
+	if err != nil {
+		return err
+	}
+	return osDriver.NewSession("").DeleteFile(ctx, path.Base(mediaURL.Path)+video.DTSHExtension)
+}
+
+func downloadTo(ctx context.Context, requestID, osURL, localPath string) error {
+	rc, err := clients.GetFile(ctx, requestID, osURL, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
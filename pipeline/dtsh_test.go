@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/catalyst-api/video"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairDTSH(t *testing.T) {
+	outputs := t.TempDir()
+	mp4Dir, hlsDir := filepath.Join(outputs, "mp4"), filepath.Join(outputs, "hls")
+	require.NoError(t, os.MkdirAll(mp4Dir, 0755))
+	require.NoError(t, os.MkdirAll(hlsDir, 0755))
+	for _, rendition := range []string{"720p0", "360p0", "240p0"} {
+		require.NoError(t, os.WriteFile(filepath.Join(mp4Dir, rendition+".mp4"), []byte(rendition), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(hlsDir, "index.m3u8"), []byte("#EXTM3U"), 0644))
+
+	// laid out like the header MistInMP4 -H writes for an H264 / AAC MP4 output
+	header, err := os.ReadFile("../video/fixtures/720p0.mp4.dtsh")
+	require.NoError(t, err)
+	// the headers of the 360p and 240p renditions are cut short
+	require.NoError(t, os.WriteFile(filepath.Join(mp4Dir, "360p0.mp4.dtsh"), header[:100], 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(mp4Dir, "240p0.mp4.dtsh"), header[:100], 0644))
+
+	var generated []string
+	generateDTSH = func(ctx context.Context, mediaPath string) ([]byte, error) {
+		generated = append(generated, filepath.Base(mediaPath))
+		if filepath.Base(mediaPath) == "240p0.mp4" {
+			return nil, errors.New("MistInMP4 failed")
+		}
+		return header, nil
+	}
+	defer func() { generateDTSH = video.GenerateDTSH }()
+
+	mp4Target, err := url.Parse(mp4Dir)
+	require.NoError(t, err)
+	hlsTarget, err := url.Parse(hlsDir)
+	require.NoError(t, err)
+	job := &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "dtsh-repair", Mp4TargetURL: mp4Target, HlsTargetURL: hlsTarget}}
+	tracks := []video.InputTrack{{Type: video.TrackTypeVideo, Codec: "h264"}, {Type: video.TrackTypeAudio, Codec: "aac"}}
+	out := &HandlerOutput{Result: &UploadJobResult{Outputs: []video.OutputVideo{{
+		Manifest: "https://example.com/hls/index.m3u8",
+		MP4Outputs: []video.OutputVideoFile{
+			{Type: "mp4", Location: "https://example.com/mp4/720p0.mp4", Tracks: tracks},
+			{Type: "mp4", Location: "https://example.com/mp4/360p0.mp4", Tracks: tracks},
+			{Type: "mp4", Location: "https://example.com/mp4/240p0.mp4", Tracks: tracks},
+		},
+	}}}}
+
+	m := metrics.Metrics.VODPipelineMetrics.DTSHHeaders
+	before := map[string]float64{}
+	for _, result := range []string{dtshGenerated, dtshRepaired, dtshValid, dtshFailed} {
+		before[result] = testutil.ToFloat64(m.WithLabelValues("mp4", result))
+	}
+	repairDTSH(job, out)
+
+	require.ElementsMatch(t, []string{"720p0.mp4", "360p0.mp4", "240p0.mp4"}, generated)
+	for _, rendition := range []string{"720p0", "360p0"} {
+		repaired, err := os.ReadFile(filepath.Join(mp4Dir, rendition+".mp4.dtsh"))
+		require.NoError(t, err)
+		require.Equal(t, header, repaired)
+	}
+	// the invalid header that couldn't be generated again is deleted, for Mist to index the output instead
+	require.NoFileExists(t, filepath.Join(mp4Dir, "240p0.mp4.dtsh"))
+	// the HLS outputs aren't checked
+	require.NoFileExists(t, filepath.Join(hlsDir, "index.m3u8.dtsh"))
+
+	require.Equal(t, before[dtshGenerated]+1, testutil.ToFloat64(m.WithLabelValues("mp4", dtshGenerated)))
+	require.Equal(t, before[dtshRepaired]+1, testutil.ToFloat64(m.WithLabelValues("mp4", dtshRepaired)))
+	require.Equal(t, before[dtshFailed]+1, testutil.ToFloat64(m.WithLabelValues("mp4", dtshFailed)))
+
+	// valid headers are left alone
+	generated = nil
+	out.Result.Outputs[0].MP4Outputs = out.Result.Outputs[0].MP4Outputs[:2]
+	repairDTSH(job, out)
+	require.Empty(t, generated)
+	require.Equal(t, before[dtshValid]+2, testutil.ToFloat64(m.WithLabelValues("mp4", dtshValid)))
+}
@@ -29,7 +29,8 @@ func (e *external) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 		return nil, fmt.Errorf("invalid source file URL: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	job.setStage("transcoding")
+	ctx, cancel := context.WithTimeout(job.jobCtx(), 6*time.Hour)
 	defer cancel()
 	outputVideos, err := e.transcoder.Transcode(ctx, clients.TranscodeJobArgs{
 		RequestID:         job.RequestID,
@@ -38,6 +39,7 @@ func (e *external) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 		HLSOutputLocation: job.HlsTargetURL,
 		MP4OutputLocation: job.Mp4TargetURL,
 		Profiles:          job.Profiles,
+		RenditionNaming:   job.RenditionNaming,
 		GenerateMP4:       job.GenerateMP4,
 		ReportProgress: func(progress float64) {
 			job.ReportProgress(clients.TranscodeStatusTranscoding, progress)
@@ -78,7 +80,7 @@ func generateThumbs(job *JobInfo) {
 
 	log.Log(job.RequestID, "generating thumbs for mediaconvert", "manifest", manifestUrl.Redacted())
 	manifest := manifestUrl.String()
-	err = thumbnails.GenerateThumbsAndVTT(job.RequestID, manifest, job.ThumbnailsTargetURL)
+	err = thumbnails.GenerateThumbsAndVTT(job.RequestID, manifest, job.ThumbnailsTargetURL, job.C2PA)
 	if err != nil {
 		log.LogError(job.RequestID, "mediaconvert thumbs failed", err, "in", manifest, "out", job.ThumbnailsTargetURL)
 		return
@@ -0,0 +1,419 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+const (
+	feedImportStateTimeout = time.Minute
+	// Feeds are expected to be small, this only bounds a misbehaving server
+	maxFeedSize = 32 * 1024 * 1024
+)
+
+// Replaced in tests, resolving the hosts of the enclosures
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// FeedImport is an RSS feed, e.g. of a podcast or video show, whose media enclosures are imported as VOD jobs
+type FeedImport struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Object store URL the outputs of each item are written to, where {feed_id}, {item_id} (derived from the GUID of
+	// the item) and {request_id} are replaced
+	TargetURL   string                 `json:"target_url"`
+	CallbackURL string                 `json:"callback_url,omitempty"`
+	Profiles    []video.EncodedProfile `json:"profiles,omitempty"`
+	// Caps how many new items are enqueued per check of the feed, the rest are picked up by the next ones. Useful for
+	// the first import of a feed with a long back catalogue. Zero is unlimited.
+	MaxItemsPerCheck int `json:"max_items_per_check,omitempty"`
+}
+
+// ParseFeedImports parses the JSON list of feeds of -feed-imports
+func ParseFeedImports(s string) ([]FeedImport, error) {
+	var feeds []FeedImport
+	if err := json.Unmarshal([]byte(s), &feeds); err != nil {
+		return nil, fmt.Errorf("invalid -feed-imports: %w", err)
+	}
+	ids := map[string]bool{}
+	for i, feed := range feeds {
+		switch {
+		case feed.ID == "":
+			return nil, fmt.Errorf("feed %d has no id", i+1)
+		case ids[feed.ID]:
+			return nil, fmt.Errorf("feed id %q is used more than once", feed.ID)
+		case feed.URL == "":
+			return nil, fmt.Errorf("feed %q has no url", feed.ID)
+		case feed.TargetURL == "":
+			return nil, fmt.Errorf("feed %q has no target_url", feed.ID)
+		}
+		ids[feed.ID] = true
+	}
+	return feeds, nil
+}
+
+// FeedItem is an item of a feed with a media enclosure
+type FeedItem struct {
+	GUID  string
+	Title string
+	URL   string
+}
+
+// ID is a short identifier of the item derived from its GUID, safe to use in a path. GUIDs are often URLs themselves.
+func (i FeedItem) ID() string {
+	sum := sha256.Sum256([]byte(i.GUID))
+	return hex.EncodeToString(sum[:8])
+}
+
+type rssFeed struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	Title      string         `xml:"title"`
+	GUID       string         `xml:"guid"`
+	Enclosures []rssEnclosure `xml:"enclosure"`
+	// Media RSS, used by video feeds instead of or alongside the enclosures
+	MediaContents []rssEnclosure `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroups   []struct {
+		Contents []rssEnclosure `xml:"http://search.yahoo.com/mrss/ content"`
+	} `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+func (e rssEnclosure) isMedia() bool {
+	if e.URL == "" {
+		return false
+	}
+	if e.Type == "" {
+		return e.Medium == "" || e.Medium == "video" || e.Medium == "audio"
+	}
+	return strings.HasPrefix(e.Type, "video/") || strings.HasPrefix(e.Type, "audio/") ||
+		e.Type == "application/x-mpegURL" || e.Type == "application/vnd.apple.mpegurl"
+}
+
+// ParseFeed returns the items of an RSS feed with a media enclosure, in the order of the feed, along with the number
+// of items without one. Items without a GUID are identified by the URL of their enclosure.
+func ParseFeed(r io.Reader) ([]FeedItem, int, error) {
+	var feed rssFeed
+	decoder := xml.NewDecoder(r)
+	// Feeds declared as e.g. ISO-8859-1 are almost always UTF-8 in practice
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := decoder.Decode(&feed); err != nil {
+		return nil, 0, fmt.Errorf("invalid RSS feed: %w", err)
+	}
+
+	var items []FeedItem
+	skipped := 0
+	for _, item := range feed.Items {
+		candidates := append(append([]rssEnclosure{}, item.Enclosures...), item.MediaContents...)
+		for _, group := range item.MediaGroups {
+			candidates = append(candidates, group.Contents...)
+		}
+		var enclosure *rssEnclosure
+		for i := range candidates {
+			if candidates[i].isMedia() {
+				enclosure = &candidates[i]
+				break
+			}
+		}
+		if enclosure == nil {
+			skipped++
+			continue
+		}
+		guid := strings.TrimSpace(item.GUID)
+		if guid == "" {
+			guid = enclosure.URL
+		}
+		items = append(items, FeedItem{GUID: guid, Title: strings.TrimSpace(item.Title), URL: strings.TrimSpace(enclosure.URL)})
+	}
+	return items, skipped, nil
+}
+
+// checkEnclosureURL checks that the enclosure of an item is on a public HTTP(S) server, the feeds being third party
+// content that mustn't get the node to fetch e.g. internal endpoints or the metadata server of the cloud provider. This
+// only turns the items away early: the jobs of the items only download their source from public addresses, which is
+// checked when connecting (see clients.WithPublicAddressesOnly). HLS playlists are refused, their segments being read
+// by the pipelines directly rather than through that download.
+func checkEnclosureURL(ctx context.Context, enclosureURL string) error {
+	u, err := url.Parse(enclosureURL)
+	if err != nil {
+		return fmt.Errorf("invalid enclosure URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("enclosure URL scheme %q isn't http or https", u.Scheme)
+	}
+	if clients.IsHLSInput(u) {
+		return fmt.Errorf("enclosure is an HLS playlist")
+	}
+	addrs, err := lookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to resolve the enclosure host: %w", err)
+	}
+	for _, addr := range addrs {
+		if !clients.IsPublicIP(addr.IP) {
+			return fmt.Errorf("enclosure host %s resolves to the non-public address %s", u.Hostname(), addr.IP)
+		}
+	}
+	return nil
+}
+
+// feedImportState is the record of the items already imported from a feed, stored as <state URL>/<feed id>.json
+type feedImportState struct {
+	Imported map[string]feedImportedItem `json:"imported"`
+}
+
+type feedImportedItem struct {
+	RequestID  string    `json:"request_id"`
+	BatchID    string    `json:"batch_id"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+func loadFeedImportState(ctx context.Context, stateURL *url.URL, feedID string) (*feedImportState, error) {
+	state := &feedImportState{Imported: map[string]feedImportedItem{}}
+	rc, err := clients.GetFile(ctx, "", stateURL.JoinPath(feedID+".json").String(), nil)
+	if errors.IsObjectNotFound(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(state); err != nil {
+		return nil, fmt.Errorf("invalid feed import state: %w", err)
+	}
+	if state.Imported == nil {
+		state.Imported = map[string]feedImportedItem{}
+	}
+	return state, nil
+}
+
+func saveFeedImportState(ctx context.Context, stateURL *url.URL, feedID string, state *feedImportState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return clients.UploadToOSURLFields(ctx, stateURL.String(), feedID+".json", bytes.NewReader(b), feedImportStateTimeout, nil)
+}
+
+// feedImports tracks the feed items whose jobs are still running, for the next checks of the feed not to enqueue them
+// again, the items only being recorded in the import state once their job succeeded
+type feedImports struct {
+	mu       sync.Mutex
+	inFlight map[string]bool
+	// stateMu serializes the updates of the import states by the jobs completing
+	stateMu sync.Mutex
+}
+
+func feedItemKey(feedID, guid string) string {
+	return feedID + "/" + guid
+}
+
+func (f *feedImports) inProgress(feedID, guid string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.inFlight[feedItemKey(feedID, guid)]
+}
+
+func (f *feedImports) start(feedID, guid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.inFlight == nil {
+		f.inFlight = map[string]bool{}
+	}
+	f.inFlight[feedItemKey(feedID, guid)] = true
+}
+
+func (f *feedImports) done(feedID, guid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.inFlight, feedItemKey(feedID, guid))
+}
+
+// recordImported adds an item whose job succeeded to the import state of its feed
+func (f *feedImports) recordImported(stateURL *url.URL, feedID, guid string, item feedImportedItem) error {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), feedImportStateTimeout)
+	defer cancel()
+	state, err := loadFeedImportState(ctx, stateURL, feedID)
+	if err != nil {
+		return err
+	}
+	state.Imported[guid] = item
+	return saveFeedImportState(ctx, stateURL, feedID, state)
+}
+
+// itemStatusClient wraps the status client of the job of a feed item to record the item as imported when the job
+// succeeds. A failed item is left out of the import state for the next checks of the feed to retry it.
+func (f *feedImports) itemStatusClient(stateURL *url.URL, feedID, guid string, item feedImportedItem, next clients.TranscodeStatusClient) clients.TranscodeStatusClient {
+	return clients.TranscodeStatusFunc(func(tsm clients.TranscodeStatusMessage) error {
+		switch tsm.Status {
+		case clients.TranscodeStatusCompleted:
+			item.ImportedAt = time.Now().UTC()
+			if err := f.recordImported(stateURL, feedID, guid, item); err != nil {
+				log.LogError(item.RequestID, "failed to record the imported feed item", err, "feed_id", feedID, "guid", guid)
+			}
+			f.done(feedID, guid)
+			metrics.Metrics.VODPipelineMetrics.FeedImportItems.WithLabelValues(feedID, "imported").Inc()
+		case clients.TranscodeStatusError:
+			f.done(feedID, guid)
+			metrics.Metrics.VODPipelineMetrics.FeedImportItems.WithLabelValues(feedID, "failed").Inc()
+		}
+		return next.SendTranscodeStatus(tsm)
+	})
+}
+
+// RunFeedImports checks the feeds for new items on startup and then every interval until the context is cancelled.
+// A non-positive interval checks them once. The feeds are only checked while elected returns true, for a single node
+// of the cluster to import them, the state of the imported items being read and written without any locking.
+func (c *Coordinator) RunFeedImports(ctx context.Context, feeds []FeedImport, stateURL string, interval time.Duration, elected func() bool) {
+	u, err := url.Parse(stateURL)
+	if err != nil {
+		log.LogNoRequestID("invalid feed import state URL", "err", err)
+		return
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		for _, feed := range feeds {
+			if !elected() {
+				break
+			}
+			if _, err := c.ImportFeed(ctx, feed, u); err != nil {
+				log.LogNoRequestID("failed to import feed", "feed_id", feed.ID, "feed", log.RedactURL(feed.URL), "err", err)
+			}
+		}
+		if tick == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+	}
+}
+
+// ImportFeed enqueues a VOD job for each item of the feed that wasn't imported yet, and returns the ID of the batch
+// they were enqueued as, empty if there were none. The statuses of the jobs are sent to the callback URL of the feed
+// in the format of a clip batch, one clip per item.
+//
+// The items are recorded as imported once their job succeeds, an item whose job fails being retried by the next checks
+// of the feed. The items whose jobs are still running are skipped by them. The jobs are started one after the other as
+// job slots free up, like the jobs of the API, and the ones still waiting when the context is done are reported as
+// failed.
+func (c *Coordinator) ImportFeed(ctx context.Context, feed FeedImport, stateURL *url.URL) (string, error) {
+	rc, err := clients.GetFile(ctx, "", feed.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the feed: %w", err)
+	}
+	items, withoutMedia, err := ParseFeed(io.LimitReader(rc, maxFeedSize))
+	rc.Close()
+	if err != nil {
+		return "", err
+	}
+	metrics.Metrics.VODPipelineMetrics.FeedImportItems.WithLabelValues(feed.ID, "no_media").Add(float64(withoutMedia))
+
+	state, err := loadFeedImportState(ctx, stateURL, feed.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load the imported items: %w", err)
+	}
+
+	batchID := config.RandomTrailer(8)
+	batch := ClipBatchPayload{
+		RequestID:   batchID,
+		CallbackURL: feed.CallbackURL,
+		Metadata:    map[string]string{"feed_id": feed.ID, "feed": feed.URL},
+	}
+	seen := map[string]bool{}
+	var guids []string
+	for _, item := range items {
+		if _, ok := state.Imported[item.GUID]; ok || seen[item.GUID] || c.feedImports.inProgress(feed.ID, item.GUID) {
+			metrics.Metrics.VODPipelineMetrics.FeedImportItems.WithLabelValues(feed.ID, "skipped").Inc()
+			continue
+		}
+		if feed.MaxItemsPerCheck > 0 && len(batch.Clips) >= feed.MaxItemsPerCheck {
+			break
+		}
+		seen[item.GUID] = true
+		if err := checkEnclosureURL(ctx, item.URL); err != nil {
+			log.LogNoRequestID("skipping feed item", "feed_id", feed.ID, "guid", item.GUID, "err", err)
+			metrics.Metrics.VODPipelineMetrics.FeedImportItems.WithLabelValues(feed.ID, "invalid_url").Inc()
+			continue
+		}
+
+		requestID := config.RandomTrailer(8)
+		targetURL, err := url.Parse(strings.NewReplacer("{feed_id}", feed.ID, "{item_id}", item.ID(), "{request_id}", requestID).Replace(feed.TargetURL))
+		if err != nil {
+			return "", fmt.Errorf("invalid feed target URL: %w", err)
+		}
+		log.AddContext(requestID, "source", item.URL, "feed_id", feed.ID, "feed_batch", batchID)
+		batch.Clips = append(batch.Clips, ClipBatchClip{
+			Title: item.Title,
+			UploadJobPayload: UploadJobPayload{
+				SourceFile:            item.URL,
+				CallbackURL:           feed.CallbackURL,
+				HlsTargetURL:          targetURL,
+				Mp4TargetURL:          targetURL,
+				RequestID:             requestID,
+				Profiles:              feed.Profiles,
+				TargetSegmentSizeSecs: config.DefaultSegmentSizeSecs,
+				Metadata: map[string]string{
+					"feed_id": feed.ID,
+					"guid":    item.GUID,
+				},
+				PublicSourceOnly: true,
+			},
+		})
+		guids = append(guids, item.GUID)
+	}
+	if len(batch.Clips) == 0 {
+		return "", nil
+	}
+
+	for _, guid := range guids {
+		c.feedImports.start(feed.ID, guid)
+	}
+	log.LogNoRequestID("Importing new feed items", "feed_id", feed.ID, "batch_id", batchID, "items", len(batch.Clips))
+	statuses := newClipBatch(batch, c.statusClient, func() {})
+	go func() {
+		for i, item := range batch.Clips {
+			metrics.Metrics.VODPipelineMetrics.FeedImportItems.WithLabelValues(feed.ID, "enqueued").Inc()
+			imported := feedImportedItem{RequestID: item.RequestID, BatchID: batchID}
+			statusClient := c.feedImports.itemStatusClient(stateURL, feed.ID, guids[i], imported, statuses.clipStatusClient(i))
+			if err := c.waitForAdmission(ctx, item.UploadJobPayload); err != nil {
+				msg := fmt.Sprintf("feed import stopped before the job started: %s", err)
+				_ = statusClient.SendTranscodeStatus(clients.NewTranscodeStatusError(item.CallbackURL, item.RequestID, msg, false))
+				continue
+			}
+			c.startUploadJobReporting(item.UploadJobPayload, statusClient)
+		}
+	}()
+	return batchID, nil
+}
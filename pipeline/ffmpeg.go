@@ -14,7 +14,10 @@ import (
 	"github.com/grafov/m3u8"
 	"github.com/livepeer/catalyst-api/clients"
 	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/catalyst-api/playback"
 	"github.com/livepeer/catalyst-api/thumbnails"
 	"github.com/livepeer/catalyst-api/transcode"
 	"github.com/livepeer/catalyst-api/video"
@@ -30,6 +33,8 @@ type ffmpeg struct {
 	Broadcaster         clients.BroadcasterClient
 	probe               video.Prober
 	sourcePlaybackHosts map[string]string
+	// Source playback of private buckets goes through signed URLs renewed with these sessions, none when nil
+	sourceSessions *playback.SourceSessions
 }
 
 func init() {
@@ -47,6 +52,12 @@ func (f *ffmpeg) Name() string {
 func (f *ffmpeg) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 	log.Log(job.RequestID, "Handling job via FFMPEG/Livepeer pipeline")
 
+	// Neither the segmenting nor the transcoders deinterlace, the interlaced sources are sent to the external
+	// transcoder unless this pipeline is forced
+	if track, err := job.InputFileInfo.GetTrack(video.TrackTypeVideo); err == nil && track.Interlaced() {
+		return nil, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassUnsupportedInput, fmt.Errorf("interlaced sources (field order %s) are only deinterlaced by the external transcoder", track.FieldOrder)))
+	}
+
 	sourceOutputURL := f.SourceOutputURL.JoinPath(job.RequestID)
 	segmentingTargetURL := sourceOutputURL.JoinPath(config.SEGMENTING_SUBDIR, config.SEGMENTING_TARGET_MANIFEST)
 
@@ -57,6 +68,7 @@ func (f *ffmpeg) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 	// Segment only for non-HLS inputs
 	var localSourceTmp string
 	if job.InputFileInfo.Format != "hls" {
+		job.setStage("segmenting")
 		var err error
 		localSourceTmp, err = copyFileToLocalTmpAndSegment(job)
 		if err != nil {
@@ -80,7 +92,7 @@ func (f *ffmpeg) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 			if job.ThumbnailsTargetURL == nil {
 				return
 			}
-			err := thumbnails.GenerateThumbsFromManifest(job.RequestID, job.SegmentingTargetURL, job.ThumbnailsTargetURL)
+			err := thumbnails.GenerateThumbsFromManifest(job.RequestID, job.SegmentingTargetURL, job.ThumbnailsTargetURL, job.C2PA)
 			if err != nil {
 				log.LogError(job.RequestID, "generate thumbs failed", err, "in", job.SegmentingTargetURL, "out", job.ThumbnailsTargetURL)
 			}
@@ -108,10 +120,19 @@ func (f *ffmpeg) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 		FragMp4TargetUrl:  toStr(job.FragMp4TargetURL),
 		RequestID:         job.RequestID,
 		ReportProgress:    job.ReportProgress,
+		ReportStage:       job.setStage,
+		ReportPlayable:    job.reportPlayable,
 		GenerateMP4:       job.GenerateMP4,
 		IsClip:            job.ClipStrategy.Enabled,
+		TrackSelection:    job.TrackSelection,
+		LadderCap:         job.LadderCap,
+		RenditionNaming:   job.RenditionNaming,
 		C2PA:              job.C2PA,
 		LocalSourceTmp:    localSourceTmp,
+		Retranscode:       job.Retranscode,
+		ConditionedOutput: job.ConditionedOutput,
+		SegmentBoundaries: job.segmentBoundaries(),
+		AudioLanguage:     job.hlsAudioLanguage(),
 	}
 
 	inputInfo := video.InputVideo{
@@ -149,6 +170,7 @@ func (f *ffmpeg) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 	}
 
 	job.state = "transcoding"
+	job.setStage("transcoding")
 
 	sourceManifest, err := clients.DownloadRenditionManifest(transcodeRequest.RequestID, transcodeRequest.SourceManifestURL)
 	if err != nil {
@@ -162,7 +184,7 @@ func (f *ffmpeg) HandleStartUploadJob(job *JobInfo) (*HandlerOutput, error) {
 		return nil, err
 	}
 
-	outputs, transcodedSegments, err := transcode.RunTranscodeProcess(transcodeRequest, job.StreamName, inputInfo, f.Broadcaster)
+	outputs, transcodedSegments, err := transcode.RunTranscodeProcess(job.jobCtx(), transcodeRequest, job.StreamName, inputInfo, f.Broadcaster)
 	if err != nil {
 		log.LogError(job.RequestID, "RunTranscodeProcess returned an error", err)
 		return nil, fmt.Errorf("transcoding failed: %w", err)
@@ -194,6 +216,15 @@ var sourcePlaybackBucketBlocklist = []string{"lp-us-catalyst-vod-pvt-monster", "
 const maxBitrateSourcePb = 6_000_000
 
 func (f *ffmpeg) sendSourcePlayback(job *JobInfo) {
+	// the source playback playlist would replace the master playlist of the renditions already published
+	if job.Retranscode != nil {
+		log.Log(job.RequestID, "source playback not available, re-transcoding existing outputs")
+		return
+	}
+	if masterPlaylistExists(job) {
+		log.Log(job.RequestID, "source playback not available, the master playlist already exists")
+		return
+	}
 	for _, track := range job.InputFileInfo.Tracks {
 		if track.Bitrate > maxBitrateSourcePb {
 			log.Log(job.RequestID, "source playback not available, bitrate too high", "bitrate", track.Bitrate)
@@ -240,11 +271,16 @@ func (f *ffmpeg) sendSourcePlayback(job *JobInfo) {
 		return
 	}
 
-	// source playback won't currently work for token gating so we're excluding the private buckets here
+	// the private buckets aren't readable without token gating, so their source segments are played back through signed
+	// URLs that the session lets players renew
+	signedPlayback := false
 	for _, blocked := range sourcePlaybackBucketBlocklist {
 		if segmentingBucket == blocked {
-			log.Log(job.RequestID, "source playback not available, not main bucket")
-			return
+			if f.sourceSessions == nil {
+				log.Log(job.RequestID, "source playback not available, not main bucket")
+				return
+			}
+			signedPlayback = true
 		}
 	}
 
@@ -255,7 +291,20 @@ func (f *ffmpeg) sendSourcePlayback(job *JobInfo) {
 		return
 	}
 
-	if renditionURL == "" {
+	if signedPlayback {
+		renditionURL, err = f.sourceSessions.PlaylistURI(job.SegmentingTargetURL)
+		if err != nil {
+			log.Log(job.RequestID, "source playback not available with signed URLs", "err", err)
+			return
+		}
+	} else if config.RelativePlaylistURIs {
+		// The source segments can only be referenced relatively when they're stored next to the outputs
+		renditionURL, err = clients.RelativePlaylistURI(job.HlsTargetURL.JoinPath("index.m3u8"), segmentingTargetURL)
+		if err != nil {
+			log.Log(job.RequestID, "source playback not available with relative playlist URIs", "err", err)
+			return
+		}
+	} else if renditionURL == "" {
 		renditionURL = "/" + path.Join(segmentingPath[2:]...)
 	}
 	sourceMaster.Append(renditionURL, &m3u8.MediaPlaylist{}, m3u8.VariantParams{
@@ -263,23 +312,65 @@ func (f *ffmpeg) sendSourcePlayback(job *JobInfo) {
 		Resolution: fmt.Sprintf("%dx%d", videoTrack.Width, videoTrack.Height),
 		Name:       fmt.Sprintf("%dp", videoTrack.Height),
 	})
-	err = clients.UploadToOSURLFields(job.HlsTargetURL.String(), "index.m3u8", sourceMaster.Encode(), 10*time.Minute, &drivers.FileProperties{CacheControl: "max-age=60"})
+	if config.RelativePlaylistURIs && !signedPlayback {
+		if err := clients.LintRelativePlaylist(sourceMaster.String()); err != nil {
+			log.LogError(job.RequestID, "invalid source playback playlist", err)
+			return
+		}
+	}
+	err = clients.UploadToOSURLFields(job.jobCtx(), job.HlsTargetURL.String(), "index.m3u8", sourceMaster.Encode(), 10*time.Minute, &drivers.FileProperties{CacheControl: "max-age=60"})
 	if err != nil {
 		log.LogError(job.RequestID, "failed to write source playback playlist", err)
 		return
 	}
 
+	job.SourcePlaybackDone = time.Now()
+	latency := job.sourcePlaybackLatency()
+	metrics.Metrics.VODPipelineMetrics.SourcePlayback.WithLabelValues(sourceInputType(job.InputFileInfo.Format)).Observe(latency.Seconds())
+	log.Log(job.RequestID, "source playback published", "latency_ms", latency.Milliseconds())
+
 	sourcePlaylist := job.HlsTargetURL.JoinPath("index.m3u8").String()
 	sourceOutput := video.OutputVideo{
 		Manifest: sourcePlaylist,
 	}
 	tsm := clients.NewTranscodeStatusSourcePlayback(job.CallbackURL, job.RequestID, clients.TranscodeStatusPreparingCompleted, 1, &sourceOutput)
+	tsm.Metadata = job.Metadata
+	tsm.SourcePlaybackLatencyMs = latency.Milliseconds()
 	err = job.statusClient.SendTranscodeStatus(tsm)
 	if err != nil {
 		log.LogError(job.RequestID, "failed to send status message for source playback", err)
 		return
 	}
-	job.SourcePlaybackDone = time.Now()
+	job.sendMilestone(clients.TranscodeStatusPreparingCompleted, 1, clients.Milestone{Event: clients.MilestoneSourcePlayable, PreviewURL: previewURL(sourcePlaylist)})
+}
+
+// masterPlaylistExists tells whether the job's outputs already have a master playlist, as when the job is a retry
+func masterPlaylistExists(job *JobInfo) bool {
+	f, err := clients.GetOSURL(job.HlsTargetURL.JoinPath("index.m3u8").String(), "")
+	if err != nil {
+		return false
+	}
+	f.Body.Close()
+	return true
+}
+
+// sourcePlaybackLatency is the time from the job being accepted until its source playback was published, zero if it
+// wasn't
+func (j *JobInfo) sourcePlaybackLatency() time.Duration {
+	if j.SourcePlaybackDone.IsZero() {
+		return 0
+	}
+	return j.SourcePlaybackDone.Sub(j.createdAt)
+}
+
+// sourceInputType is the input type the source playback latency is broken down by, the container of the source
+// bounded to the supported ones
+func sourceInputType(format string) string {
+	switch format {
+	case "mp4", "mov", "hls":
+		return format
+	}
+	return "other"
 }
 
 func (f *ffmpeg) probeSourceSegments(job *JobInfo, sourceSegments []*m3u8.MediaSegment) error {
@@ -345,15 +436,16 @@ func copyFileToLocalTmpAndSegment(job *JobInfo) (string, error) {
 
 	// Copy the file locally because of issues with ffmpeg segmenting and remote files
 	// We can be aggressive with the timeout because we're copying from cloud storage
+	ctx := job.jobCtx()
 	if err := backoff.Retry(func() error {
-		timeout, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		timeout, cancel := context.WithTimeout(ctx, 30*time.Minute)
 		defer cancel()
 		_, err = clients.CopyFile(timeout, job.SignedSourceURL, localSourceFile.Name(), "", job.RequestID)
 		if err != nil {
 			return fmt.Errorf("failed to copy file (%s) locally for segmenting: %s", log.RedactURL(job.SignedSourceURL), err)
 		}
 		return nil
-	}, retries(6)); err != nil {
+	}, backoff.WithContext(retries(6), ctx)); err != nil {
 		return "", err
 	}
 
@@ -368,7 +460,10 @@ func copyFileToLocalTmpAndSegment(job *JobInfo) (string, error) {
 	}
 
 	destinationURL := fmt.Sprintf("%s/api/ffmpeg/%s/index.m3u8", internalAddress, job.StreamName)
-	if err := video.Segment(localSourceFile.Name(), destinationURL, job.TargetSegmentSizeSecs); err != nil {
+	if job.ConditionedOutput != nil {
+		log.Log(job.RequestID, "Conditioning the source segments", "cue_points", job.ConditionedOutput.CuePoints)
+	}
+	if err := video.Segment(ctx, localSourceFile.Name(), destinationURL, job.TargetSegmentSizeSecs, job.segmentBoundaries(), job.InputFileInfo.TimedMetadata); err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,264 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// How often the node running a job refreshes its key in the shared backend while the job is in flight
+var idempotencyHeartbeatInterval = time.Minute
+
+// How long the key of a job in flight is kept in the shared backend without a heartbeat, e.g. once its node died
+func idempotencyInFlightTTL() time.Duration {
+	return 3 * idempotencyHeartbeatInterval
+}
+
+// How long to wait for the shared backend, the key is only deduplicated on this node when it doesn't answer in time
+const idempotencyBackendTimeout = 500 * time.Millisecond
+
+// ErrIdempotencyKeyReused is returned when the key of a job is sent again with a different request
+var ErrIdempotencyKeyReused = errors.New("the Idempotency-Key was already used for a different request")
+
+// idempotentJob is the job started for an idempotency key
+type idempotentJob struct {
+	RequestID string `json:"request_id"`
+	// SHA-256 of the body of the request, retries must send the same body
+	BodyHash   string    `json:"body_hash"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	// When the job is failed for running past its deadline, zero when it has none
+	DeadlineAt time.Time `json:"deadline_at,omitempty"`
+}
+
+func (job *idempotentJob) expired() bool {
+	if job.FinishedAt.IsZero() {
+		return !job.DeadlineAt.IsZero() && time.Now().After(job.DeadlineAt)
+	}
+	return time.Since(job.FinishedAt) > config.IdempotencyWindow
+}
+
+// idempotencyKeyLock serializes the claims of a key, while the other keys are claimed in parallel
+type idempotencyKeyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockIdempotencyKey locks the key until the returned function is called
+func (c *Coordinator) lockIdempotencyKey(key string) func() {
+	c.idempotencyMu.Lock()
+	if c.idempotencyKeyLocks == nil {
+		c.idempotencyKeyLocks = map[string]*idempotencyKeyLock{}
+	}
+	l, ok := c.idempotencyKeyLocks[key]
+	if !ok {
+		l = &idempotencyKeyLock{}
+		c.idempotencyKeyLocks[key] = l
+	}
+	l.refs++
+	c.idempotencyMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.idempotencyMu.Lock()
+		defer c.idempotencyMu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(c.idempotencyKeyLocks, key)
+		}
+	}
+}
+
+// localIdempotentJob returns the job this node started for the key
+func (c *Coordinator) localIdempotentJob(key string) (*idempotentJob, bool) {
+	c.idempotencyMu.Lock()
+	defer c.idempotencyMu.Unlock()
+	if c.idempotencyKeys == nil {
+		c.idempotencyKeys = map[string]*idempotentJob{}
+	}
+	for k, job := range c.idempotencyKeys {
+		if job.expired() {
+			delete(c.idempotencyKeys, k)
+		}
+	}
+	job, ok := c.idempotencyKeys[key]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+// ExistingJob is the job a request was deduplicated to
+type ExistingJob struct {
+	RequestID string
+	Status    string
+}
+
+// ClaimIdempotencyKey records that the job with the request ID is started for the key, unless a job was already
+// started for it. In that case the existing job is returned instead, as long as it's still in flight or it completed
+// within the idempotency window. Jobs that failed don't count, so that retrying them transcodes again.
+//
+// Keys are scoped by the caller, e.g. by API token. The body hash of the request must match the one of the existing
+// job, ErrIdempotencyKeyReused is returned otherwise. The keys are shared with the other nodes through the shared
+// cache backend when there's one, on a best effort basis: two nodes claiming the same key at once both start a job.
+//
+// The key of a job in flight is kept until the job finishes or its deadline passes, the longest the job may wait for
+// and run for, unlimited when it's 0. The node running the job keeps the shared key alive with heartbeats, so that the
+// key is released soon after the node dies.
+func (c *Coordinator) ClaimIdempotencyKey(key, bodyHash, requestID string, deadline time.Duration) (ExistingJob, bool, error) {
+	unlock := c.lockIdempotencyKey(key)
+	defer unlock()
+
+	job, ok := c.localIdempotentJob(key)
+	if !ok {
+		job, ok = c.sharedIdempotentJob(key)
+	}
+	if ok {
+		existing := ExistingJob{RequestID: job.RequestID, Status: c.jobStatus(job)}
+		if job.BodyHash != bodyHash {
+			return existing, false, ErrIdempotencyKeyReused
+		}
+		return existing, false, nil
+	}
+	job = &idempotentJob{RequestID: requestID, BodyHash: bodyHash}
+	if deadline > 0 {
+		job.DeadlineAt = time.Now().Add(deadline)
+	}
+	c.idempotencyMu.Lock()
+	c.idempotencyKeys[key] = job
+	c.idempotencyMu.Unlock()
+	c.shareIdempotentJob(key, *job, idempotencyInFlightTTL())
+	if c.idempotencyBackend != nil {
+		go c.idempotencyHeartbeat(key, *job)
+	}
+	return ExistingJob{}, true, nil
+}
+
+// idempotencyHeartbeat refreshes the shared key of a job while it's in flight on this node
+func (c *Coordinator) idempotencyHeartbeat(key string, job idempotentJob) {
+	ticker := time.NewTicker(idempotencyHeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		unlock := c.lockIdempotencyKey(key)
+		current, ok := c.localIdempotentJob(key)
+		if !ok || current.RequestID != job.RequestID || !current.FinishedAt.IsZero() {
+			unlock()
+			return
+		}
+		c.shareIdempotentJob(key, job, idempotencyInFlightTTL())
+		unlock()
+	}
+}
+
+// ReleaseIdempotencyKey forgets the key of a job that couldn't be started
+func (c *Coordinator) ReleaseIdempotencyKey(key, requestID string) {
+	c.finishIdempotentJob(key, requestID, false)
+}
+
+// finishIdempotentJob starts the idempotency window of a job that completed, or forgets the key of one that failed
+func (c *Coordinator) finishIdempotentJob(key, requestID string, success bool) {
+	if key == "" {
+		return
+	}
+	unlock := c.lockIdempotencyKey(key)
+	defer unlock()
+	c.idempotencyMu.Lock()
+	job, ok := c.idempotencyKeys[key]
+	if !ok || job.RequestID != requestID {
+		c.idempotencyMu.Unlock()
+		return
+	}
+	if !success {
+		delete(c.idempotencyKeys, key)
+		c.idempotencyMu.Unlock()
+		c.unshareIdempotentJob(key)
+		return
+	}
+	job.FinishedAt = time.Now()
+	finished := *job
+	c.idempotencyMu.Unlock()
+	if config.IdempotencyWindow > 0 {
+		c.shareIdempotentJob(key, finished, config.IdempotencyWindow)
+	} else {
+		c.unshareIdempotentJob(key)
+	}
+	log.Log(requestID, "job completed, deduplicating its retries", "window", config.IdempotencyWindow)
+}
+
+func idempotencyBackendKey(key string) string {
+	return "idempotency:" + key
+}
+
+// sharedIdempotentJob returns the job another node started for the key
+func (c *Coordinator) sharedIdempotentJob(key string) (*idempotentJob, bool) {
+	if c.idempotencyBackend == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyBackendTimeout)
+	defer cancel()
+	b, found, err := c.idempotencyBackend.Get(ctx, idempotencyBackendKey(key))
+	if err != nil {
+		log.LogNoRequestID("failed to get the shared idempotency key", "err", err)
+		return nil, false
+	}
+	if !found {
+		return nil, false
+	}
+	var job idempotentJob
+	if err := json.Unmarshal(b, &job); err != nil {
+		log.LogNoRequestID("invalid shared idempotency key", "err", err)
+		return nil, false
+	}
+	if job.expired() {
+		return nil, false
+	}
+	return &job, true
+}
+
+func (c *Coordinator) shareIdempotentJob(key string, job idempotentJob, ttl time.Duration) {
+	if c.idempotencyBackend == nil {
+		return
+	}
+	b, err := json.Marshal(job)
+	if err != nil {
+		log.LogNoRequestID("failed to encode the shared idempotency key", "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyBackendTimeout)
+	defer cancel()
+	if err := c.idempotencyBackend.Set(ctx, idempotencyBackendKey(key), b, ttl); err != nil {
+		log.Log(job.RequestID, "failed to share the idempotency key", "err", err)
+	}
+}
+
+func (c *Coordinator) unshareIdempotentJob(key string) {
+	if c.idempotencyBackend == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyBackendTimeout)
+	defer cancel()
+	if err := c.idempotencyBackend.Delete(ctx, idempotencyBackendKey(key)); err != nil {
+		log.LogNoRequestID("failed to delete the shared idempotency key", "err", err)
+	}
+}
+
+func (c *Coordinator) jobStatus(job *idempotentJob) string {
+	if !job.FinishedAt.IsZero() {
+		return "completed"
+	}
+	if si := c.Jobs.Get(config.SegmentingStreamName(job.RequestID)); si != nil {
+		si.statusMu.Lock()
+		defer si.statusMu.Unlock()
+		return si.lastStatus.String()
+	}
+	if _, ok := c.pendingUploads.byRequestID.Lookup(job.RequestID); ok {
+		return "waiting_for_upload"
+	}
+	// Between the pipelines of a fallback, or running on another node
+	return "in_flight"
+}
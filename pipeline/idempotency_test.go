@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	c := NewStubCoordinator()
+
+	_, claimed, err := c.ClaimIdempotencyKey("key", "body", "first", 0)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	// In flight
+	existing, claimed, err := c.ClaimIdempotencyKey("key", "body", "second", 0)
+	require.NoError(t, err)
+	require.False(t, claimed)
+	require.Equal(t, ExistingJob{RequestID: "first", Status: "in_flight"}, existing)
+
+	// Reused for another request
+	_, claimed, err = c.ClaimIdempotencyKey("key", "other body", "second", 0)
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+	require.False(t, claimed)
+
+	// Completed within the window
+	c.finishIdempotentJob("key", "first", true)
+	existing, claimed, err = c.ClaimIdempotencyKey("key", "body", "third", 0)
+	require.NoError(t, err)
+	require.False(t, claimed)
+	require.Equal(t, ExistingJob{RequestID: "first", Status: "completed"}, existing)
+
+	// Completed before the window
+	defer func(window time.Duration) { config.IdempotencyWindow = window }(config.IdempotencyWindow)
+	config.IdempotencyWindow = 0
+	_, claimed, err = c.ClaimIdempotencyKey("key", "other body", "fourth", 0)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	// Failed jobs are retried, and don't affect the job that replaced them
+	c.finishIdempotentJob("key", "first", false)
+	c.finishIdempotentJob("key", "fourth", false)
+	_, claimed, _ = c.ClaimIdempotencyKey("key", "body", "fifth", 0)
+	require.True(t, claimed)
+	c.ReleaseIdempotencyKey("key", "fifth")
+	_, claimed, _ = c.ClaimIdempotencyKey("key", "body", "sixth", 0)
+	require.True(t, claimed)
+}
+
+func TestIdempotencyKeysAreShared(t *testing.T) {
+	redis, err := cache.NewRedis("redis://" + miniredis.RunT(t).Addr())
+	require.NoError(t, err)
+	node1, node2 := NewStubCoordinator(), NewStubCoordinator()
+	node1.idempotencyBackend, node2.idempotencyBackend = redis, redis
+
+	_, claimed, err := node1.ClaimIdempotencyKey("token/key", "body", "first", 0)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	existing, claimed, err := node2.ClaimIdempotencyKey("token/key", "body", "second", 0)
+	require.NoError(t, err)
+	require.False(t, claimed)
+	require.Equal(t, ExistingJob{RequestID: "first", Status: "in_flight"}, existing)
+	_, _, err = node2.ClaimIdempotencyKey("token/key", "other body", "second", 0)
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	node1.finishIdempotentJob("token/key", "first", true)
+	existing, claimed, _ = node2.ClaimIdempotencyKey("token/key", "body", "third", 0)
+	require.False(t, claimed)
+	require.Equal(t, ExistingJob{RequestID: "first", Status: "completed"}, existing)
+
+	// Another token's key
+	_, claimed, _ = node2.ClaimIdempotencyKey("other-token/key", "body", "fourth", 0)
+	require.True(t, claimed)
+
+	// A failed job can be retried on any node
+	_, claimed, _ = node1.ClaimIdempotencyKey("token/retried", "body", "fifth", 0)
+	require.True(t, claimed)
+	node1.finishIdempotentJob("token/retried", "fifth", false)
+	_, claimed, _ = node2.ClaimIdempotencyKey("token/retried", "body", "sixth", 0)
+	require.True(t, claimed)
+}
+
+func TestIdempotencyKeysOfDeadNodesExpire(t *testing.T) {
+	defer func(interval time.Duration) { idempotencyHeartbeatInterval = interval }(idempotencyHeartbeatInterval)
+	idempotencyHeartbeatInterval = 100 * time.Millisecond
+	mr := miniredis.RunT(t)
+	redis, err := cache.NewRedis("redis://" + mr.Addr())
+	require.NoError(t, err)
+	node1, node2 := NewStubCoordinator(), NewStubCoordinator()
+	node1.idempotencyBackend, node2.idempotencyBackend = redis, redis
+
+	_, claimed, _ := node1.ClaimIdempotencyKey("token/key", "body", "first", 0)
+	require.True(t, claimed)
+	// Kept alive by the heartbeats of the node running the job
+	for i := 0; i < 5; i++ {
+		mr.FastForward(idempotencyInFlightTTL() / 2)
+		time.Sleep(2 * idempotencyHeartbeatInterval)
+	}
+	_, claimed, _ = node2.ClaimIdempotencyKey("token/key", "body", "second", 0)
+	require.False(t, claimed)
+
+	node1.finishIdempotentJob("token/key", "first", true)
+
+	// Released once they stop, e.g. when the node dies
+	_, claimed, _ = node1.ClaimIdempotencyKey("token/dead", "body", "third", 0)
+	require.True(t, claimed)
+	node1.idempotencyMu.Lock()
+	delete(node1.idempotencyKeys, "token/dead")
+	node1.idempotencyMu.Unlock()
+	mr.FastForward(idempotencyInFlightTTL() + time.Second)
+	_, claimed, _ = node2.ClaimIdempotencyKey("token/dead", "body", "fourth", 0)
+	require.True(t, claimed)
+}
+
+func TestIdempotencyKeysExpireAtTheirDeadline(t *testing.T) {
+	c := NewStubCoordinator()
+	_, claimed, _ := c.ClaimIdempotencyKey("key", "body", "first", 50*time.Millisecond)
+	require.True(t, claimed)
+	_, claimed, _ = c.ClaimIdempotencyKey("key", "body", "second", 0)
+	require.False(t, claimed)
+	time.Sleep(100 * time.Millisecond)
+	_, claimed, _ = c.ClaimIdempotencyKey("key", "body", "third", 0)
+	require.True(t, claimed)
+}
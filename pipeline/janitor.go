@@ -0,0 +1,278 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+const (
+	janitorLocationObjectStore = "object_store"
+	janitorLocationLocal       = "local"
+)
+
+// How long writing the janitor marker of a job can take
+const janitorMarkerTimeout = 10 * time.Second
+
+// Most files deleted from the source output location in a sweep, the rest are left to the next sweeps
+const janitorMaxDeletesPerSweep = 1000
+
+// Prefix of the marker file written in the transfer dir of a job, suffixed with the name of the node that ran it. The
+// source output location is shared by the nodes, which only clean up the transfers they wrote since they can't tell
+// whether the jobs of the others are still running.
+const transferOwnerMarkerPrefix = ".janitor-owner-"
+
+// Prefixes of the temp dirs created while processing a job. Only transmux dirs contain the requestID.
+var janitorLocalDirPrefixes = []string{"transmux_stage_", "clip_stage_", "thumbs-"}
+
+// RunJanitor cleans up the files left behind by jobs that were interrupted (e.g. by a crash or a deploy), once on
+// startup and then every interval until the context is cancelled. Only artifacts that belong to no active job and
+// haven't been modified for longer than ttl are deleted, and only the transfers of the jobs started by this node,
+// named nodeName, since it started the janitor. It should keep its name across restarts for them to be cleaned up.
+func (c *Coordinator) RunJanitor(ctx context.Context, nodeName string, interval, ttl time.Duration) {
+	marker := transferOwnerMarkerPrefix + nodeName
+	c.transferOwnerMarker.Store(&marker)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.cleanUpOrphanedFiles(ctx, marker, ttl)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// markTransferOwner writes the marker of this node in the transfer dir of a job when the janitor runs, for it to
+// clean up the dir if the job is interrupted
+func (c *Coordinator) markTransferOwner(ctx context.Context, requestID string) {
+	marker := c.transferOwnerMarker.Load()
+	if marker == nil || c.SourceOutputURL == nil || c.SourceOutputURL.String() == "" {
+		return
+	}
+	transferURL := c.SourceOutputURL.JoinPath(requestID, "transfer").String()
+	if err := clients.UploadToOSURLFields(ctx, transferURL, *marker, strings.NewReader(""), janitorMarkerTimeout, nil); err != nil {
+		log.LogError(requestID, "failed to write the janitor marker, the transfer won't be cleaned up if the job is interrupted", err)
+	}
+}
+
+func (c *Coordinator) cleanUpOrphanedFiles(ctx context.Context, marker string, ttl time.Duration) {
+	active := map[string]bool{}
+	for _, job := range c.Jobs.GetJobs() {
+		active[job.RequestID] = true
+	}
+
+	if c.SourceOutputURL != nil && c.SourceOutputURL.String() != "" {
+		if err := cleanUpOrphanedTransfers(ctx, c.SourceOutputURL, marker, active, ttl); err != nil {
+			log.LogNoRequestID("janitor failed to clean up source output", "err", err, "source_output", log.RedactURL(c.SourceOutputURL.String()))
+		}
+	}
+	if err := cleanUpOrphanedTempDirs(os.TempDir(), active, ttl); err != nil {
+		log.LogNoRequestID("janitor failed to clean up temp dirs", "err", err)
+	}
+}
+
+// cleanUpOrphanedTransfers deletes the <requestID>/transfer/ copies of the input files under the source output
+// location, of the transfers holding the marker. The segmented source is left alone since it's used for source
+// playback. At most janitorMaxDeletesPerSweep files are deleted.
+func cleanUpOrphanedTransfers(ctx context.Context, sourceOutput *url.URL, marker string, active map[string]bool, ttl time.Duration) error {
+	osDriver, err := clients.ParseOSURL(sourceOutput.String(), true)
+	if err != nil {
+		return err
+	}
+	sess := osDriver.NewSession("")
+	page, err := sess.ListFiles(ctx, "", "/")
+	if err != nil {
+		return err
+	}
+
+	deletes := janitorMaxDeletesPerSweep
+	for {
+		for _, dir := range page.Directories() {
+			if deletes <= 0 {
+				log.LogNoRequestID("janitor reached the most files deleted in a sweep, leaving the rest to the next one", "max", janitorMaxDeletesPerSweep)
+				return nil
+			}
+			requestID := path.Base(strings.TrimSuffix(dir, "/"))
+			if active[requestID] {
+				continue
+			}
+			transferURL := sourceOutput.JoinPath(requestID, "transfer").String()
+			if err := cleanUpOrphanedTransfer(ctx, requestID, transferURL, marker, ttl, &deletes); err != nil {
+				log.Log(requestID, "janitor failed to clean up transfer dir", "err", err)
+			}
+		}
+		if !page.HasNextPage() {
+			return nil
+		}
+		if page, err = page.NextPage(); err != nil {
+			return err
+		}
+	}
+}
+
+func cleanUpOrphanedTransfer(ctx context.Context, requestID, transferURL, marker string, ttl time.Duration, deletes *int) error {
+	osDriver, err := clients.ParseOSURL(transferURL, true)
+	if err != nil {
+		return err
+	}
+	sess := osDriver.NewSession("")
+	files, err := listAllFiles(ctx, sess, "")
+	if err != nil {
+		// The job never got to copy its input
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	owned := false
+	for _, f := range files {
+		if time.Since(f.LastModified) < ttl {
+			// The transfer is still recent, the job may have just been picked up by another attempt
+			return nil
+		}
+		owned = owned || f.Name == marker
+	}
+	if !owned {
+		// Written by another node, or before the janitor ran
+		return nil
+	}
+
+	// The marker goes last, for the rest to be cleaned up by the next sweeps
+	sort.SliceStable(files, func(i, j int) bool { return files[j].Name == marker })
+	for i, f := range files {
+		if *deletes <= 0 {
+			log.Log(requestID, "janitor cleaned up part of the orphaned transfer files", "count", i)
+			return nil
+		}
+		if err := sess.DeleteFile(ctx, f.Name); err != nil {
+			return err
+		}
+		*deletes--
+		metrics.Metrics.JanitorDeletedCount.WithLabelValues(janitorLocationObjectStore).Inc()
+		if f.Size != nil {
+			metrics.Metrics.JanitorReclaimedBytes.WithLabelValues(janitorLocationObjectStore).Add(float64(*f.Size))
+		}
+	}
+	if len(files) > 0 {
+		log.Log(requestID, "janitor cleaned up orphaned transfer files", "count", len(files))
+	}
+	return nil
+}
+
+// listAllFiles returns all of the files under the prefix. Object stores list recursively, but the local filesystem
+// driver returns the subdirectories which need to be listed separately.
+func listAllFiles(ctx context.Context, sess drivers.OSSession, prefix string) ([]drivers.FileInfo, error) {
+	page, err := sess.ListFiles(ctx, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	var files []drivers.FileInfo
+	for {
+		for _, f := range page.Files() {
+			f.Name = path.Join(prefix, f.Name)
+			files = append(files, f)
+		}
+		for _, dir := range page.Directories() {
+			dirFiles, err := listAllFiles(ctx, sess, path.Join(prefix, dir))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, dirFiles...)
+		}
+		if !page.HasNextPage() {
+			return files, nil
+		}
+		if page, err = page.NextPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// cleanUpOrphanedTempDirs deletes the local scratch dirs of jobs that are no longer running
+func cleanUpOrphanedTempDirs(dir string, active map[string]bool, ttl time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isJobTempDir(entry.Name()) {
+			continue
+		}
+		if requestID := transmuxDirRequestID(entry.Name()); active[requestID] {
+			continue
+		}
+
+		tempDir := filepath.Join(dir, entry.Name())
+		size, count, lastModified, err := dirStats(tempDir)
+		if err != nil {
+			log.LogNoRequestID("janitor failed to stat temp dir", "dir", tempDir, "err", err)
+			continue
+		}
+		if time.Since(lastModified) < ttl {
+			continue
+		}
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.LogNoRequestID("janitor failed to remove temp dir", "dir", tempDir, "err", err)
+			continue
+		}
+		metrics.Metrics.JanitorDeletedCount.WithLabelValues(janitorLocationLocal).Add(float64(count))
+		metrics.Metrics.JanitorReclaimedBytes.WithLabelValues(janitorLocationLocal).Add(float64(size))
+		log.LogNoRequestID("janitor cleaned up orphaned temp dir", "dir", tempDir, "bytes", size, "last_modified", lastModified)
+	}
+	return nil
+}
+
+func isJobTempDir(name string) bool {
+	for _, prefix := range janitorLocalDirPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// transmuxDirRequestID extracts the requestID from transmux_stage_<requestID>_<random> dir names
+func transmuxDirRequestID(name string) string {
+	rest, ok := strings.CutPrefix(name, "transmux_stage_")
+	if !ok {
+		return ""
+	}
+	if i := strings.LastIndex(rest, "_"); i >= 0 {
+		return rest[:i]
+	}
+	return rest
+}
+
+// dirStats returns the total size, number of files and most recent modification time of the files in the dir. The
+// modification time of the dir itself is only used when it's empty.
+func dirStats(dir string) (size int64, count int, lastModified time.Time, err error) {
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir || info.Mode().IsRegular() {
+			if count == 0 || info.ModTime().After(lastModified) {
+				lastModified = info.ModTime()
+			}
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+			count++
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeOldFile(t *testing.T, name string, age time.Duration) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	require.NoError(t, os.WriteFile(name, []byte("some data"), 0644))
+	modTime := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(name, modTime, modTime))
+}
+
+func TestJanitorCleansUpOrphanedTransfers(t *testing.T) {
+	sourceOutput := t.TempDir()
+	writeOldFile(t, filepath.Join(sourceOutput, "orphaned", "transfer", "video.mp4"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "orphaned", "transfer", "nested", "index.m3u8"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "orphaned", "transfer", ".janitor-owner-node-a"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "orphaned", "source", "index.m3u8"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "active", "transfer", "video.mp4"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "active", "transfer", ".janitor-owner-node-a"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "recent", "transfer", "video.mp4"), time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "recent", "transfer", ".janitor-owner-node-a"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "other-node", "transfer", "video.mp4"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "other-node", "transfer", ".janitor-owner-node-b"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "unmarked", "transfer", "video.mp4"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(sourceOutput, "segmented-only", "source", "index.m3u8"), 48*time.Hour)
+
+	sourceOutputURL, err := url.Parse(sourceOutput)
+	require.NoError(t, err)
+	err = cleanUpOrphanedTransfers(context.Background(), sourceOutputURL, ".janitor-owner-node-a", map[string]bool{"active": true}, 24*time.Hour)
+	require.NoError(t, err)
+
+	require.NoFileExists(t, filepath.Join(sourceOutput, "orphaned", "transfer", "video.mp4"))
+	require.NoFileExists(t, filepath.Join(sourceOutput, "orphaned", "transfer", "nested", "index.m3u8"))
+	require.NoFileExists(t, filepath.Join(sourceOutput, "orphaned", "transfer", ".janitor-owner-node-a"))
+	// Jobs that may still be running on other nodes
+	require.FileExists(t, filepath.Join(sourceOutput, "other-node", "transfer", "video.mp4"))
+	require.FileExists(t, filepath.Join(sourceOutput, "unmarked", "transfer", "video.mp4"))
+	// The segmented source is still used for playback
+	require.FileExists(t, filepath.Join(sourceOutput, "orphaned", "source", "index.m3u8"))
+	require.FileExists(t, filepath.Join(sourceOutput, "active", "transfer", "video.mp4"))
+	require.FileExists(t, filepath.Join(sourceOutput, "recent", "transfer", "video.mp4"))
+	require.FileExists(t, filepath.Join(sourceOutput, "segmented-only", "source", "index.m3u8"))
+}
+
+func TestJanitorCapsDeletesPerSweep(t *testing.T) {
+	sourceOutput := t.TempDir()
+	for i := 0; i < janitorMaxDeletesPerSweep+10; i++ {
+		writeOldFile(t, filepath.Join(sourceOutput, "orphaned", "transfer", fmt.Sprintf("%d.ts", i)), 48*time.Hour)
+	}
+	writeOldFile(t, filepath.Join(sourceOutput, "orphaned", "transfer", ".janitor-owner-node-a"), 48*time.Hour)
+	sourceOutputURL, err := url.Parse(sourceOutput)
+	require.NoError(t, err)
+
+	require.NoError(t, cleanUpOrphanedTransfers(context.Background(), sourceOutputURL, ".janitor-owner-node-a", nil, 24*time.Hour))
+	entries, err := os.ReadDir(filepath.Join(sourceOutput, "orphaned", "transfer"))
+	require.NoError(t, err)
+	require.Len(t, entries, 11)
+	// The marker is kept for the next sweep to carry on
+	require.FileExists(t, filepath.Join(sourceOutput, "orphaned", "transfer", ".janitor-owner-node-a"))
+
+	require.NoError(t, cleanUpOrphanedTransfers(context.Background(), sourceOutputURL, ".janitor-owner-node-a", nil, 24*time.Hour))
+	require.NoFileExists(t, filepath.Join(sourceOutput, "orphaned", "transfer", ".janitor-owner-node-a"))
+}
+
+func TestJanitorCleansUpOrphanedTempDirs(t *testing.T) {
+	tmp := t.TempDir()
+	writeOldFile(t, filepath.Join(tmp, "transmux_stage_orphaned_123", "out.ts"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(tmp, "transmux_stage_active_456", "out.ts"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(tmp, "clip_stage_789", "clip.ts"), 48*time.Hour)
+	writeOldFile(t, filepath.Join(tmp, "thumbs-123", "thumb.jpg"), time.Hour)
+	writeOldFile(t, filepath.Join(tmp, "unrelated", "file"), 48*time.Hour)
+
+	require.NoError(t, cleanUpOrphanedTempDirs(tmp, map[string]bool{"active": true}, 24*time.Hour))
+
+	require.NoDirExists(t, filepath.Join(tmp, "transmux_stage_orphaned_123"))
+	require.NoDirExists(t, filepath.Join(tmp, "clip_stage_789"))
+	require.DirExists(t, filepath.Join(tmp, "transmux_stage_active_456"))
+	require.DirExists(t, filepath.Join(tmp, "thumbs-123"))
+	require.DirExists(t, filepath.Join(tmp, "unrelated"))
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// JobEnvironment is a snapshot of the runtime config a job ran with, included in error callbacks and the vod_completed
+// row so that failures can be debugged with the exact config. All URLs are redacted.
+type JobEnvironment struct {
+	Version               string            `json:"version"`
+	PipelineStrategy      Strategy          `json:"pipeline_strategy"`
+	ParallelTranscodeJobs int               `json:"parallel_transcode_jobs"`
+	SegmentSlots          int               `json:"segment_slots"`
+	StorageFallbackURLs   map[string]string `json:"storage_fallback_urls,omitempty"`
+	Broadcaster           string            `json:"broadcaster,omitempty"`
+	// Set when the source was converted into a mezzanine MP4 before being transcoded, see -normalize-sources
+	SourceNormalization *video.SourceNormalization `json:"source_normalization,omitempty"`
+}
+
+func (c *Coordinator) snapshotEnvironment(p UploadJobPayload) JobEnvironment {
+	env := JobEnvironment{
+		Version:               config.Version,
+		PipelineStrategy:      c.strategy,
+		ParallelTranscodeJobs: config.TranscodingParallelJobs,
+		SegmentSlots:          config.TranscodingSegmentSlots,
+	}
+	if p.PipelineStrategy.IsValid() {
+		env.PipelineStrategy = p.PipelineStrategy
+	}
+	if len(config.StorageFallbackURLs) > 0 {
+		env.StorageFallbackURLs = map[string]string{}
+		for primary, backup := range config.StorageFallbackURLs {
+			env.StorageFallbackURLs[log.RedactURL(primary)] = log.RedactURL(backup)
+		}
+	}
+	// The broadcaster clients describe themselves with redacted URLs
+	if b, ok := c.broadcaster.(fmt.Stringer); ok {
+		env.Broadcaster = b.String()
+	}
+	return env
+}
+
+// environmentJSON returns the environment snapshot of the job, or nil if it can't be encoded
+func (j *JobInfo) environmentJSON() json.RawMessage {
+	env, err := json.Marshal(j.environment)
+	if err != nil {
+		log.LogError(j.RequestID, "failed to encode job environment", err)
+		return nil
+	}
+	return env
+}
@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"sort"
+	"time"
+)
+
+// JobSummary is what the admin API shows about an in-flight job. Only fields that are immutable or guarded by the
+// job's statusMu are included, since the job's handler holds its main lock for as long as it runs.
+type JobSummary struct {
+	RequestID  string    `json:"request_id"`
+	ExternalID string    `json:"external_id,omitempty"`
+	StreamName string    `json:"stream_name"`
+	Clip       bool      `json:"clip"`
+	Stage      string    `json:"stage"`
+	Status     string    `json:"status"`
+	Completion float64   `json:"completion_ratio"`
+	StartedAt  time.Time `json:"started_at"`
+	// Unset for the jobs without a deadline
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// ListJobs returns the jobs currently in flight, oldest first
+func (c *Coordinator) ListJobs() []JobSummary {
+	jobs := c.Jobs.GetJobs()
+	summaries := make([]JobSummary, 0, len(jobs))
+	for _, job := range jobs {
+		var deadline *time.Time
+		if job.deadline > 0 {
+			d := job.createdAt.Add(job.deadline)
+			deadline = &d
+		}
+		job.statusMu.Lock()
+		summaries = append(summaries, JobSummary{
+			RequestID:  job.RequestID,
+			ExternalID: job.ExternalID,
+			StreamName: job.StreamName,
+			Clip:       job.ClipStrategy.Enabled,
+			Stage:      job.stage,
+			Status:     job.lastStatus.String(),
+			Completion: job.lastCompletion,
+			StartedAt:  job.createdAt,
+			Deadline:   deadline,
+		})
+		job.statusMu.Unlock()
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].StartedAt.Before(summaries[j].StartedAt)
+	})
+	return summaries
+}
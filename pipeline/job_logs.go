@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// How long uploading the log lines of a job may take, they're given up on after that
+const jobLogsUploadTimeout = 2 * time.Minute
+
+// startJobLogs starts capturing the log lines of a job, when they're kept with -job-logs-dir or uploaded with
+// -job-logs-url, and returns the file they're written to. Empty if they aren't captured.
+func startJobLogs(requestID string) string {
+	if config.JobLogsDir == "" && config.JobLogsURL == "" {
+		return ""
+	}
+	var path string
+	if config.JobLogsDir != "" {
+		path = filepath.Join(config.JobLogsDir, jobLogsFilename(requestID))
+	} else {
+		// only kept until it's uploaded
+		f, err := os.CreateTemp("", "job-logs-*.log")
+		if err != nil {
+			log.LogError(requestID, "failed to create the job logs file", err)
+			return ""
+		}
+		f.Close()
+		path = f.Name()
+	}
+	if err := log.StartCapture(requestID, path); err != nil {
+		log.LogError(requestID, "failed to capture the job logs", err)
+		return ""
+	}
+	return path
+}
+
+// finishJobLogs stops capturing the log lines of a finished job and uploads them when asked to. Otherwise, the ones
+// of the jobs that finished longer than -job-logs-retention ago are removed from -job-logs-dir.
+func finishJobLogs(job *JobInfo) {
+	if job.logsPath == "" {
+		return
+	}
+	if err := log.StopCapture(job.RequestID); err != nil {
+		log.LogError(job.RequestID, "failed to close the job logs file", err)
+	}
+	if config.JobLogsURL != "" {
+		go uploadJobLogs(job.RequestID, job.logsPath, config.JobLogsURL)
+		return
+	}
+	go pruneJobLogs(config.JobLogsDir, config.JobLogsRetention)
+}
+
+// uploadJobLogs uploads the log lines of a job and removes the local file once they are
+func uploadJobLogs(requestID, path, logsURL string) {
+	if config.JobLogsDir == "" {
+		defer os.Remove(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.LogError(requestID, "failed to read the job logs file", err)
+		return
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), jobLogsUploadTimeout)
+	defer cancel()
+	filename := jobLogsFilename(requestID)
+	if err := clients.UploadToOSURLFields(ctx, logsURL, filename, f, jobLogsUploadTimeout, nil); err != nil {
+		log.LogError(requestID, "failed to upload the job logs", err)
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.LogError(requestID, "failed to remove the uploaded job logs file", err)
+	}
+	log.Log(requestID, "Uploaded the job logs", "url", log.RedactURL(logsURL), "file", filename)
+}
+
+// pruneJobLogs removes the log files of the jobs that finished longer than the retention ago
+func pruneJobLogs(dir string, retention time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.LogNoRequestID("failed to list the job logs", "dir", dir, "err", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			log.LogNoRequestID("failed to remove old job logs", "file", entry.Name(), "err", err)
+		}
+	}
+}
+
+func jobLogsFilename(requestID string) string {
+	return strings.ReplaceAll(requestID, "/", "_") + ".log"
+}
@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobLogsKeptInDir(t *testing.T) {
+	dir := t.TempDir()
+	defer func(d, u string) { config.JobLogsDir, config.JobLogsURL = d, u }(config.JobLogsDir, config.JobLogsURL)
+	config.JobLogsDir, config.JobLogsURL = dir, ""
+
+	job := &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "job-logs-dir"}}
+	job.logsPath = startJobLogs(job.RequestID)
+	require.Equal(t, filepath.Join(dir, "job-logs-dir.log"), job.logsPath)
+	log.Log(job.RequestID, "transcoding")
+	finishJobLogs(job)
+	log.Log(job.RequestID, "after the job")
+
+	b, err := os.ReadFile(job.logsPath)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "msg=transcoding")
+	require.NotContains(t, string(b), "after the job")
+}
+
+func TestJobLogsRedacted(t *testing.T) {
+	dir := t.TempDir()
+	defer func(d, u string) { config.JobLogsDir, config.JobLogsURL = d, u }(config.JobLogsDir, config.JobLogsURL)
+	config.JobLogsDir, config.JobLogsURL = dir, ""
+
+	job := &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "job-logs-redacted"}}
+	job.logsPath = startJobLogs(job.RequestID)
+	log.LogError(job.RequestID, "failed to copy", fmt.Errorf("GET https://host/source.mp4?X-Amz-Signature=abcdef: 403"))
+	finishJobLogs(job)
+
+	b, err := os.ReadFile(job.logsPath)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "X-Amz-Signature=xxxxx")
+	require.NotContains(t, string(b), "abcdef")
+}
+
+func TestJobLogsPrunedFromDir(t *testing.T) {
+	dir := t.TempDir()
+	old, recent := filepath.Join(dir, "old.log"), filepath.Join(dir, "recent.log")
+	require.NoError(t, os.WriteFile(old, []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(recent, []byte("recent"), 0644))
+	require.NoError(t, os.Chtimes(old, time.Now().Add(-4*24*time.Hour), time.Now().Add(-4*24*time.Hour)))
+
+	pruneJobLogs(dir, 72*time.Hour)
+	require.NoFileExists(t, old)
+	require.FileExists(t, recent)
+}
+
+func TestJobLogsUploadedToPrivateLocation(t *testing.T) {
+	dir, logsBucket := t.TempDir(), t.TempDir()
+	defer func(d, u string) { config.JobLogsDir, config.JobLogsURL = d, u }(config.JobLogsDir, config.JobLogsURL)
+	config.JobLogsDir, config.JobLogsURL = dir, logsBucket
+
+	outputs, err := url.Parse(t.TempDir())
+	require.NoError(t, err)
+	job := &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "job-logs-upload", HlsTargetURL: outputs}}
+	job.logsPath = startJobLogs(job.RequestID)
+	require.NotEmpty(t, job.logsPath)
+	log.Log(job.RequestID, "transcoding")
+	finishJobLogs(job)
+
+	// removed from the dir once uploaded
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(job.logsPath)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+	b, err := os.ReadFile(filepath.Join(logsBucket, "job-logs-upload.log"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "msg=transcoding")
+	require.NoDirExists(t, filepath.Join(outputs.Path, "logs"))
+}
+
+func TestJobLogsNotCapturedByDefault(t *testing.T) {
+	require.Empty(t, startJobLogs("job-logs-off"))
+}
@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// Detections less confident than this are reported in the callback but not tagged in the HLS manifest, where players
+// would pick the audio by it
+const minManifestLanguageConfidence = 0.5
+
+// detectLanguage identifies the spoken language of the primary audio track of the source. It's informational, so a
+// failing detector doesn't fail the job and only leaves the language out.
+func (c *Coordinator) detectLanguage(ctx context.Context, requestID, sourceURL string, iv video.InputVideo, tracks video.TrackSelection) *clients.LanguageDetection {
+	if _, err := iv.GetTrack(video.TrackTypeAudio); err != nil || tracks.VideoOnly {
+		log.Log(requestID, "Skipping language detection, the outputs have no audio")
+		return nil
+	}
+	detection, err := c.LanguageDetector.DetectLanguage(ctx, requestID, sourceURL)
+	if err != nil {
+		log.LogError(requestID, "Language detection failed, carrying on without the language", err)
+		return nil
+	}
+	log.Log(requestID, "Detected the language of the audio", "language", detection.Language, "confidence", detection.Confidence)
+	return &detection
+}
+
+// hlsAudioLanguage returns the language the audio of the HLS renditions is tagged with, empty when unknown or unsure
+func (j *JobInfo) hlsAudioLanguage() string {
+	d := j.languageDetection
+	if d == nil || (d.Confidence > 0 && d.Confidence < minManifestLanguageConfidence) {
+		return ""
+	}
+	return d.Language
+}
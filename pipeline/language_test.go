@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/video"
+	"github.com/stretchr/testify/require"
+)
+
+type stubLanguageDetector struct {
+	detection clients.LanguageDetection
+	err       error
+	calls     int
+}
+
+func (s *stubLanguageDetector) DetectLanguage(context.Context, string, string) (clients.LanguageDetection, error) {
+	s.calls++
+	return s.detection, s.err
+}
+
+func TestDetectLanguage(t *testing.T) {
+	withAudio := video.InputVideo{Tracks: []video.InputTrack{{Type: video.TrackTypeVideo}, {Type: video.TrackTypeAudio}}}
+	videoOnly := video.InputVideo{Tracks: []video.InputTrack{{Type: video.TrackTypeVideo}}}
+	detector := &stubLanguageDetector{detection: clients.LanguageDetection{Language: "en", Confidence: 0.9}}
+	c := &Coordinator{LanguageDetector: detector}
+
+	job := &JobInfo{languageDetection: c.detectLanguage(context.Background(), "req", "https://example.com/in.mp4", withAudio, video.TrackSelection{})}
+	require.Equal(t, "en", job.hlsAudioLanguage())
+
+	// Nothing to detect without audio in the outputs
+	require.Nil(t, c.detectLanguage(context.Background(), "req", "https://example.com/in.mp4", videoOnly, video.TrackSelection{}))
+	require.Nil(t, c.detectLanguage(context.Background(), "req", "https://example.com/in.mp4", withAudio, video.TrackSelection{VideoOnly: true}))
+	require.Equal(t, 1, detector.calls)
+
+	// Unsure detections are only reported in the callback
+	detector.detection.Confidence = 0.2
+	job = &JobInfo{languageDetection: c.detectLanguage(context.Background(), "req", "https://example.com/in.mp4", withAudio, video.TrackSelection{})}
+	require.NotNil(t, job.languageDetection)
+	require.Empty(t, job.hlsAudioLanguage())
+
+	// Failures don't fail the job
+	detector.err = errors.New("detector down")
+	require.Nil(t, c.detectLanguage(context.Background(), "req", "https://example.com/in.mp4", withAudio, video.TrackSelection{}))
+}
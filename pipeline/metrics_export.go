@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+const (
+	// Records kept in memory between flushes, further ones drop the oldest ones
+	maxBufferedMetricsRecords = 50_000
+	metricsExportTimeout      = 2 * time.Minute
+)
+
+// VODCompletedRecord is a row of the vod_completed metrics, written to Postgres and/or exported to object storage
+type VODCompletedRecord struct {
+	FinishedAt             int64           `json:"finished_at"`
+	StartedAt              int64           `json:"started_at"`
+	RequestID              string          `json:"request_id"`
+	ExternalID             string          `json:"external_id"`
+	SourceCodecVideo       string          `json:"source_codec_video"`
+	SourceCodecAudio       string          `json:"source_codec_audio"`
+	Pipeline               string          `json:"pipeline"`
+	CatalystRegion         string          `json:"catalyst_region"`
+	State                  string          `json:"state"`
+	ProfilesCount          int             `json:"profiles_count"`
+	JobDuration            int64           `json:"job_duration"`
+	SourceSegmentCount     int             `json:"source_segment_count"`
+	TranscodedSegmentCount int             `json:"transcoded_segment_count"`
+	SourceBytesCount       int64           `json:"source_bytes_count"`
+	SourceDuration         int64           `json:"source_duration"`
+	SourceURL              string          `json:"source_url"`
+	TargetURL              string          `json:"target_url"`
+	InFallbackMode         bool            `json:"in_fallback_mode"`
+	SourcePlaybackAt       int64           `json:"source_playback_at"`
+	DownloadDoneAt         int64           `json:"download_done_at"`
+	SegmentingDoneAt       int64           `json:"segmenting_done_at"`
+	TranscodingDoneAt      int64           `json:"transcoding_done_at"`
+	IsClip                 bool            `json:"is_clip"`
+	IsThumbs               bool            `json:"is_thumbs"`
+	Environment            json.RawMessage `json:"environment,omitempty"`
+	Metadata               json.RawMessage `json:"metadata,omitempty"`
+	// Stable code of the error failing the job, see errors.Code
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// MetricsExporter batches vod_completed records and writes them to object storage as gzipped NDJSON files,
+// partitioned by hour, for deployments that want the job analytics without a Postgres metrics DB
+type MetricsExporter struct {
+	exportURL string
+	nodeName  string
+
+	mu      sync.Mutex
+	records []VODCompletedRecord
+}
+
+func NewMetricsExporter(exportURL, nodeName string) *MetricsExporter {
+	return &MetricsExporter{exportURL: exportURL, nodeName: nodeName}
+}
+
+func (e *MetricsExporter) Add(record VODCompletedRecord) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.records) >= maxBufferedMetricsRecords {
+		log.LogNoRequestID("metrics export buffer full, dropping the oldest record", "request_id", e.records[0].RequestID)
+		e.records = e.records[1:]
+	}
+	e.records = append(e.records, record)
+}
+
+// Run flushes the buffered records every interval until the context is cancelled, and once more on the way out
+func (e *MetricsExporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := e.Flush(context.Background()); err != nil {
+				log.LogNoRequestID("failed to export metrics on shutdown", "err", err)
+			}
+			return
+		case <-ticker.C:
+			if err := e.Flush(ctx); err != nil {
+				log.LogNoRequestID("failed to export metrics", "err", err)
+			}
+		}
+	}
+}
+
+// Flush writes the buffered records to a new file under vod_completed/dt=<date>/hour=<hour>/. The records are kept for
+// the next flush if the upload fails.
+func (e *MetricsExporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	records := e.records
+	e.records = nil
+	e.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("failed to encode metrics record %s: %w", r.RequestID, err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress metrics records: %w", err)
+	}
+
+	now := time.Now().UTC()
+	filename := fmt.Sprintf("vod_completed/dt=%s/hour=%s/%s-%d.ndjson.gz", now.Format("2006-01-02"), now.Format("15"), e.nodeName, now.UnixNano())
+	if err := clients.UploadToOSURLFields(ctx, e.exportURL, filename, &buf, metricsExportTimeout, nil); err != nil {
+		e.mu.Lock()
+		e.records = append(records, e.records...)
+		if excess := len(e.records) - maxBufferedMetricsRecords; excess > 0 {
+			e.records = e.records[excess:]
+		}
+		e.mu.Unlock()
+		return err
+	}
+	log.LogNoRequestID("exported metrics records", "count", len(records), "file", filename)
+	return nil
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsExporterWritesHourlyNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	exporter := NewMetricsExporter(dir, "node-1")
+
+	// nothing to write
+	require.NoError(t, exporter.Flush(context.Background()))
+	files, err := filepath.Glob(filepath.Join(dir, "vod_completed", "*", "*", "*"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	exporter.Add(VODCompletedRecord{RequestID: "req-1", State: "completed", ProfilesCount: 2, Metadata: json.RawMessage(`{"a":"b"}`)})
+	exporter.Add(VODCompletedRecord{RequestID: "fb_req-2", State: "failed", InFallbackMode: true})
+	require.NoError(t, exporter.Flush(context.Background()))
+
+	files, err = filepath.Glob(filepath.Join(dir, "vod_completed", "dt=*", "hour=*", "node-1-*.ndjson.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	var records []VODCompletedRecord
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var r VODCompletedRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+	require.Equal(t, "req-1", records[0].RequestID)
+	require.JSONEq(t, `{"a":"b"}`, string(records[0].Metadata))
+	require.True(t, records[1].InFallbackMode)
+
+	// the buffer was emptied
+	require.NoError(t, exporter.Flush(context.Background()))
+	files, err = filepath.Glob(filepath.Join(dir, "vod_completed", "*", "*", "*"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestMetricsExporterKeepsRecordsWhenUploadFails(t *testing.T) {
+	exporter := NewMetricsExporter("s4+htps://123/456", "node-1")
+	exporter.Add(VODCompletedRecord{RequestID: "req-1"})
+	require.Error(t, exporter.Flush(context.Background()))
+	require.Len(t, exporter.records, 1)
+}
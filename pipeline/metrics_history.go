@@ -0,0 +1,190 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultVODHistoryLimit = 50
+	MaxVODHistoryLimit     = 500
+)
+
+var ErrInvalidVODHistoryCursor = errors.New("invalid cursor")
+
+// VODHistoryFilter selects the vod_completed records of past jobs, newest first
+type VODHistoryFilter struct {
+	// Range of the time the jobs finished at, either end may be left zero
+	From, To   time.Time
+	State      string
+	Pipeline   string
+	ExternalID string
+	Limit      int
+	// Cursor returned with the previous page, empty for the first page
+	Cursor string
+}
+
+// VODHistoryPage is a page of past jobs, with the cursor of the next page if there's one
+type VODHistoryPage struct {
+	Jobs       []VODCompletedRecord `json:"jobs"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// The columns of a VODCompletedRecord, in order. Older rows may lack some of them, and the durations are doubles.
+const vodHistoryColumns = `"finished_at",
+	"started_at",
+	"request_id",
+	coalesce("external_id", ''),
+	coalesce("source_codec_video", ''),
+	coalesce("source_codec_audio", ''),
+	coalesce("pipeline", ''),
+	coalesce("catalyst_region", ''),
+	coalesce("state", ''),
+	coalesce("profiles_count", 0),
+	coalesce("job_duration", 0)::bigint,
+	coalesce("source_segment_count", 0),
+	coalesce("transcoded_segment_count", 0),
+	coalesce("source_bytes_count", 0),
+	coalesce("source_duration", 0)::bigint,
+	coalesce("source_url", ''),
+	coalesce("target_url", ''),
+	coalesce("in_fallback_mode", false),
+	coalesce("source_playback_at", 0),
+	coalesce("download_done_at", 0),
+	coalesce("segmenting_done_at", 0),
+	coalesce("transcoding_done_at", 0),
+	coalesce("is_clip", false),
+	coalesce("is_thumbs", false),
+	"environment",
+	"metadata",
+	coalesce("error_code", '')`
+
+// QueryVODHistory reads a page of past jobs from the vod_completed metrics table
+func QueryVODHistory(ctx context.Context, db *sql.DB, filter VODHistoryFilter) (VODHistoryPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultVODHistoryLimit
+	}
+	limit = min(limit, MaxVODHistoryLimit)
+
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if !filter.From.IsZero() {
+		where(`"finished_at" >= $%d`, filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		where(`"finished_at" < $%d`, filter.To.Unix())
+	}
+	if filter.State != "" {
+		where(`"state" = $%d`, filter.State)
+	}
+	if filter.Pipeline != "" {
+		where(`"pipeline" = $%d`, filter.Pipeline)
+	}
+	if filter.ExternalID != "" {
+		where(`"external_id" = $%d`, filter.ExternalID)
+	}
+	if filter.Cursor != "" {
+		finishedAt, requestID, err := parseVODHistoryCursor(filter.Cursor)
+		if err != nil {
+			return VODHistoryPage{}, err
+		}
+		args = append(args, finishedAt, requestID)
+		conditions = append(conditions, fmt.Sprintf(`("finished_at", "request_id") < ($%d, $%d)`, len(args)-1, len(args)))
+	}
+
+	query := `select ` + vodHistoryColumns + ` from "vod_completed"`
+	if len(conditions) > 0 {
+		query += ` where ` + strings.Join(conditions, " and ")
+	}
+	// One more than the page to tell whether there's a next one
+	args = append(args, limit+1)
+	query += fmt.Sprintf(` order by "finished_at" desc, "request_id" desc limit $%d`, len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return VODHistoryPage{}, fmt.Errorf("error querying vod_completed: %w", err)
+	}
+	defer rows.Close()
+
+	page := VODHistoryPage{Jobs: []VODCompletedRecord{}}
+	for rows.Next() {
+		var r VODCompletedRecord
+		var environment, metadata []byte
+		err := rows.Scan(
+			&r.FinishedAt,
+			&r.StartedAt,
+			&r.RequestID,
+			&r.ExternalID,
+			&r.SourceCodecVideo,
+			&r.SourceCodecAudio,
+			&r.Pipeline,
+			&r.CatalystRegion,
+			&r.State,
+			&r.ProfilesCount,
+			&r.JobDuration,
+			&r.SourceSegmentCount,
+			&r.TranscodedSegmentCount,
+			&r.SourceBytesCount,
+			&r.SourceDuration,
+			&r.SourceURL,
+			&r.TargetURL,
+			&r.InFallbackMode,
+			&r.SourcePlaybackAt,
+			&r.DownloadDoneAt,
+			&r.SegmentingDoneAt,
+			&r.TranscodingDoneAt,
+			&r.IsClip,
+			&r.IsThumbs,
+			&environment,
+			&metadata,
+			&r.ErrorCode,
+		)
+		if err != nil {
+			return VODHistoryPage{}, fmt.Errorf("error reading vod_completed: %w", err)
+		}
+		r.Environment, r.Metadata = environment, metadata
+		page.Jobs = append(page.Jobs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return VODHistoryPage{}, fmt.Errorf("error reading vod_completed: %w", err)
+	}
+
+	if len(page.Jobs) > limit {
+		page.Jobs = page.Jobs[:limit]
+		last := page.Jobs[limit-1]
+		page.NextCursor = vodHistoryCursor(last.FinishedAt, last.RequestID)
+	}
+	return page, nil
+}
+
+// The cursor is the position of the last job of a page, so that the pages don't shift as new jobs finish
+func vodHistoryCursor(finishedAt int64, requestID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(finishedAt, 10) + ":" + requestID))
+}
+
+func parseVODHistoryCursor(cursor string) (int64, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidVODHistoryCursor
+	}
+	finishedAt, requestID, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return 0, "", ErrInvalidVODHistoryCursor
+	}
+	ts, err := strconv.ParseInt(finishedAt, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidVODHistoryCursor
+	}
+	return ts, requestID, nil
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
+)
+
+// Name of the mezzanine MP4 the unsupported sources are converted into, in the transfer directory of the job
+const mezzanineFilename = "mezzanine.mp4"
+
+const mezzanineUploadTimeout = 30 * time.Minute
+
+// Replaced in tests, ffmpeg isn't installed there
+var (
+	normalizeSource = video.NormalizeSource
+	probeMezzanine  = func(requestID, signedURL string) (video.InputVideo, error) {
+		return video.Probe{IgnoreErrMessages: clients.IgnoreProbeErrs}.ProbeFile(requestID, signedURL, "-analyzeduration", "15000000")
+	}
+)
+
+// normalizeSource converts a source in a container or with codecs the pipelines don't support into a mezzanine MP4
+// with local ffmpeg, uploaded to the transfer directory of the job. The job is then transcoded from it instead, keeping
+// the checksum of the source for the pipeline decisions.
+func (c *Coordinator) normalizeSource(ctx context.Context, si *JobInfo, n *video.SourceNormalization, signedSourceURL string, iv video.InputVideo, transferDir *url.URL) (*url.URL, string, video.InputVideo, error) {
+	si.setStage("normalization")
+	log.Log(si.RequestID, "normalizing the source", "mode", n.Mode, "reason", n.Reason)
+
+	dir, err := os.MkdirTemp("", "normalize-*")
+	if err != nil {
+		return nil, "", video.InputVideo{}, err
+	}
+	defer os.RemoveAll(dir)
+	mezzaninePath := filepath.Join(dir, mezzanineFilename)
+	if err := normalizeSource(ctx, n, signedSourceURL, mezzaninePath); err != nil {
+		return nil, "", video.InputVideo{}, err
+	}
+
+	f, err := os.Open(mezzaninePath)
+	if err != nil {
+		return nil, "", video.InputVideo{}, err
+	}
+	defer f.Close()
+	mezzanineURL := transferDir.JoinPath(mezzanineFilename)
+	if err := clients.UploadToOSURLFields(ctx, transferDir.String(), mezzanineFilename, f, mezzanineUploadTimeout, nil); err != nil {
+		return nil, "", video.InputVideo{}, fmt.Errorf("failed to upload the mezzanine MP4: %w", err)
+	}
+
+	signedURL, err := clients.SignURL(mezzanineURL)
+	if err != nil {
+		return nil, "", video.InputVideo{}, err
+	}
+	mezzanine, err := probeMezzanine(si.RequestID, signedURL)
+	if err != nil {
+		return nil, "", video.InputVideo{}, fmt.Errorf("error probing the mezzanine MP4: %w", err)
+	}
+	if track, err := mezzanine.GetTrack(video.TrackTypeVideo); err == nil && track.FPS <= 0 {
+		return nil, "", video.InputVideo{}, catErrs.Unretriable(catErrs.WithClass(catErrs.ClassUnsupportedInput, fmt.Errorf("invalid framerate of the mezzanine MP4: %f", track.FPS)))
+	}
+	mezzanine.Checksum = iv.Checksum
+
+	si.environment.SourceNormalization = n
+	si.journal.record("source_normalized", fmt.Sprintf("%s, %s", n.Mode, n.Reason))
+	log.Log(si.RequestID, "normalized the source", "mode", n.Mode, "url", mezzanineURL.Redacted())
+	return mezzanineURL, signedURL, mezzanine, nil
+}
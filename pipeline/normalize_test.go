@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/video"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSource(t *testing.T) {
+	sourceOutput := t.TempDir()
+	sourceOutputURL, err := url.Parse(sourceOutput)
+	require.NoError(t, err)
+	c := NewStubCoordinator()
+	c.SourceOutputURL = sourceOutputURL
+
+	defer func(f func(context.Context, *video.SourceNormalization, string, string) error) { normalizeSource = f }(normalizeSource)
+	defer func(f func(string, string) (video.InputVideo, error)) { probeMezzanine = f }(probeMezzanine)
+	normalizeSource = func(_ context.Context, n *video.SourceNormalization, sourceURL, outputPath string) error {
+		require.Equal(t, "https://example.com/source.wmv", sourceURL)
+		return os.WriteFile(outputPath, []byte("mezzanine"), 0644)
+	}
+	var probed string
+	probeMezzanine = func(_, signedURL string) (video.InputVideo, error) {
+		probed = signedURL
+		return video.InputVideo{Format: "mp4", Tracks: []video.InputTrack{{Type: video.TrackTypeVideo, Codec: "h264", VideoTrack: video.VideoTrack{FPS: 30}}}}, nil
+	}
+
+	iv := video.InputVideo{Format: "asf", Checksum: "source-checksum", Tracks: []video.InputTrack{{Type: video.TrackTypeVideo, Codec: "wmv3"}}}
+	n := video.NeedsNormalization(iv)
+	require.NotNil(t, n)
+	si := &JobInfo{UploadJobPayload: UploadJobPayload{RequestID: "normalize"}}
+	transferDir := sourceOutputURL.JoinPath("normalize", "transfer")
+
+	mezzanineURL, signedURL, mezzanine, err := c.normalizeSource(context.Background(), si, n, "https://example.com/source.wmv", iv, transferDir)
+	require.NoError(t, err)
+	require.Equal(t, transferDir.JoinPath(mezzanineFilename).String(), mezzanineURL.String())
+	require.Equal(t, probed, signedURL)
+	require.Equal(t, "mp4", mezzanine.Format)
+	require.Equal(t, "source-checksum", mezzanine.Checksum)
+	require.Equal(t, n, si.environment.SourceNormalization)
+
+	b, err := os.ReadFile(filepath.Join(sourceOutput, "normalize", "transfer", mezzanineFilename))
+	require.NoError(t, err)
+	require.Equal(t, "mezzanine", string(b))
+}
@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+const pipelineDecisionsDir = "pipeline-decisions"
+
+// pipelineDecisions remembers the content that failed the ffmpeg pipeline and was then transcoded by the external one,
+// so that re-uploads of the same content go straight to the external pipeline instead of wasting a failed attempt
+// first. The outcomes are kept as small objects next to the source copies, keyed by the source checksum, so that they
+// are shared by all the nodes and survive restarts.
+type pipelineDecisions struct {
+	baseURL *url.URL
+	ttl     time.Duration
+}
+
+type pipelineOutcome struct {
+	FailedPipeline    string    `json:"failed_pipeline"`
+	SucceededPipeline string    `json:"succeeded_pipeline"`
+	RecordedAt        time.Time `json:"recorded_at"`
+}
+
+// newPipelineDecisions returns nil, i.e. nothing is remembered, without a storage location or with a TTL of 0
+func newPipelineDecisions(baseURL *url.URL, ttl time.Duration) *pipelineDecisions {
+	if baseURL == nil || baseURL.String() == "" || ttl <= 0 {
+		return nil
+	}
+	return &pipelineDecisions{baseURL: baseURL.JoinPath(pipelineDecisionsDir), ttl: ttl}
+}
+
+// Lookup returns the outcome recorded for the content, unless it's older than the TTL so that the ffmpeg pipeline is
+// tried again once it may have been fixed
+func (d *pipelineDecisions) Lookup(requestID, checksum string) (pipelineOutcome, bool) {
+	if d == nil || checksum == "" {
+		return pipelineOutcome{}, false
+	}
+	rc, err := clients.DownloadOSURL(d.baseURL.JoinPath(checksum + ".json").String())
+	if err != nil {
+		if !errors.IsObjectNotFound(err) {
+			log.LogError(requestID, "failed to read the pipeline decision of the source", err, "checksum", checksum)
+		}
+		return pipelineOutcome{}, false
+	}
+	defer rc.Close()
+
+	var outcome pipelineOutcome
+	if err := json.NewDecoder(rc).Decode(&outcome); err != nil {
+		log.LogError(requestID, "invalid pipeline decision of the source", err, "checksum", checksum)
+		return pipelineOutcome{}, false
+	}
+	if time.Since(outcome.RecordedAt) > d.ttl {
+		return pipelineOutcome{}, false
+	}
+	return outcome, true
+}
+
+// Record stores the outcome of the content's fallback. Failures are only logged since the job itself succeeded.
+func (d *pipelineDecisions) Record(requestID, checksum string, outcome pipelineOutcome) {
+	if d == nil || checksum == "" {
+		return
+	}
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		log.LogError(requestID, "failed to encode the pipeline decision of the source", err)
+		return
+	}
+	if err := clients.UploadToOSURL(d.baseURL.String(), checksum+".json", bytes.NewReader(data), time.Minute); err != nil {
+		log.LogError(requestID, "failed to record the pipeline decision of the source", err, "checksum", checksum)
+		return
+	}
+	log.Log(requestID, "recorded the pipeline decision of the source", "checksum", checksum, "failed_pipeline", outcome.FailedPipeline, "succeeded_pipeline", outcome.SucceededPipeline)
+}
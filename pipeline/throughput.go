@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How far back job completions are counted to estimate how fast job slots free up
+	throughputWindow = 15 * time.Minute
+
+	minRetryAfter = 5 * time.Second
+	maxRetryAfter = 10 * time.Minute
+	// When no job finished recently to estimate from
+	defaultRetryAfter = time.Minute
+)
+
+// jobThroughput keeps the completion times of recent jobs, separately for clips and regular VOD jobs as they're
+// admitted against different limits
+type jobThroughput struct {
+	mu          sync.Mutex
+	completions map[bool][]time.Time
+}
+
+func (t *jobThroughput) record(clip bool, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.completions == nil {
+		t.completions = map[bool][]time.Time{}
+	}
+	t.completions[clip] = append(t.prune(t.completions[clip], at), at)
+}
+
+// rate returns the jobs completed per second over the window, 0 when none completed
+func (t *jobThroughput) rate(clip bool, now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	completions := t.prune(t.completions[clip], now)
+	if t.completions != nil {
+		t.completions[clip] = completions
+	}
+	if len(completions) == 0 {
+		return 0
+	}
+	// Over the time since the first completion rather than the whole window, so that a node that only started recently
+	// isn't taken for a slow one
+	elapsed := max(now.Sub(completions[0]), time.Minute)
+	return float64(len(completions)) / elapsed.Seconds()
+}
+
+// prune drops the completions older than the window. Must be called with the lock held.
+func (t *jobThroughput) prune(completions []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(completions) && now.Sub(completions[i]) > throughputWindow {
+		i++
+	}
+	return completions[i:]
+}
+
+// JobThroughput returns the number of clip or regular VOD jobs that finished per second recently, 0 when none did
+func (c *Coordinator) JobThroughput(clip bool) float64 {
+	return c.throughput.rate(clip, time.Now())
+}
+
+// EstimateRetryAfter returns how long it should take for a number of clip or regular VOD job slots to free up, from the
+// recent job throughput
+func (c *Coordinator) EstimateRetryAfter(clip bool, slots int) time.Duration {
+	return estimateRetryAfter(slots, c.JobThroughput(clip))
+}
+
+func estimateRetryAfter(slots int, throughput float64) time.Duration {
+	if throughput <= 0 {
+		return defaultRetryAfter
+	}
+	d := time.Duration(float64(max(slots, 1)) / throughput * float64(time.Second))
+	return min(max(d, minRetryAfter), maxRetryAfter)
+}
@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobThroughput(t *testing.T) {
+	var tp jobThroughput
+	now := time.Now()
+	require.Zero(t, tp.rate(false, now))
+
+	for i := 0; i < 10; i++ {
+		tp.record(false, now.Add(time.Duration(i)*10*time.Second))
+	}
+	tp.record(true, now)
+	now = now.Add(100 * time.Second)
+	require.InDelta(t, 0.1, tp.rate(false, now), 0.001)
+	require.InDelta(t, 1.0/100, tp.rate(true, now), 0.001)
+
+	// Completions out of the window no longer count
+	require.Zero(t, tp.rate(false, now.Add(throughputWindow)))
+}
+
+func TestEstimateRetryAfter(t *testing.T) {
+	require.Equal(t, defaultRetryAfter, estimateRetryAfter(3, 0))
+	require.Equal(t, 30*time.Second, estimateRetryAfter(3, 0.1))
+	require.Equal(t, minRetryAfter, estimateRetryAfter(1, 10))
+	require.Equal(t, maxRetryAfter, estimateRetryAfter(1000, 0.1))
+}
@@ -0,0 +1,231 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+)
+
+// How much longer than their timeout the pending uploads are kept, for the node polling them to fail them when it
+// times out
+const pendingUploadGracePeriod = time.Minute
+
+// How long the job of a direct upload can wait for a free job slot once its upload completed before it's failed
+var uploadAdmissionTimeout = 1 * time.Hour
+
+// How long the notifications of the uploads received by another node are kept for the node they were submitted to
+const notifiedUploadTTL = time.Hour
+
+// pendingUploads are the direct uploads that jobs wait for. Their payloads, with the credentials of their URLs, are
+// only kept in the memory of the node they were submitted to, which polls them and starts their jobs. The locations of
+// their sources and the storage event notifications are shared through the cache backend when there's one, so that
+// the notifications received by any node start them.
+type pendingUploads struct {
+	byRequestID *cache.Cache[UploadJobPayload]
+	// Request IDs of the pending uploads by the locations their source can be notified as, see objectLocations
+	byObject *cache.Cache[string]
+	// The pending uploads notified on another node than the one they were submitted to, by request ID
+	notified *cache.Cache[bool]
+}
+
+func newPendingUploads(backend cache.Backend) *pendingUploads {
+	return &pendingUploads{
+		byRequestID: cache.NewWithOptions[UploadJobPayload](cache.Options{Name: "pending_uploads"}),
+		byObject:    cache.NewWithOptions[string](cache.Options{Name: "pending_upload_objects", Backend: backend}),
+		notified:    cache.NewWithOptions[bool](cache.Options{Name: "notified_uploads", TTL: notifiedUploadTTL, Backend: backend}),
+	}
+}
+
+// take removes a pending upload, along with the locations of its source so that later notifications are ignored
+func (u *pendingUploads) take(requestID string) (UploadJobPayload, bool) {
+	p, ok := u.byRequestID.Take(requestID)
+	if !ok {
+		return p, false
+	}
+	if sourceURL, err := url.Parse(p.SourceFile); err == nil {
+		for _, location := range objectLocations(sourceURL) {
+			u.byObject.Take(location)
+		}
+	}
+	return p, true
+}
+
+// StartUploadJobWhenUploaded is used for direct uploads, where the source URL is handed to the user to upload to and
+// the file doesn't exist yet. The job is started as soon as the upload completes and there's a free job slot for it.
+// The upload is detected either through a storage event notification (see NotifyObjectCreated) or by polling the
+// source. If the upload doesn't complete within the timeout an error callback is sent instead.
+func (c *Coordinator) StartUploadJobWhenUploaded(p UploadJobPayload, timeout time.Duration) error {
+	sourceURL, err := url.Parse(p.SourceFile)
+	if err != nil {
+		return fmt.Errorf("error parsing source as url: %w", err)
+	}
+
+	c.pendingUploads.byRequestID.StoreTTL(p.RequestID, p, timeout+pendingUploadGracePeriod)
+	for _, location := range objectLocations(sourceURL) {
+		c.pendingUploads.byObject.StoreTTL(location, p.RequestID, timeout+pendingUploadGracePeriod)
+	}
+	log.Log(p.RequestID, "waiting for direct upload to complete", "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	go func() {
+		defer cancel()
+		c.pollUpload(ctx, p.RequestID, sourceURL)
+	}()
+	return nil
+}
+
+// pollUpload starts the job once its source exists or it's notified on another node, until the upload times out or the
+// job is started by a notification received by this node
+func (c *Coordinator) pollUpload(ctx context.Context, requestID string, sourceURL *url.URL) {
+	ticker := time.NewTicker(config.DirectUploadPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.expireUpload(requestID)
+			return
+		case <-ticker.C:
+		}
+		if _, ok := c.pendingUploads.byRequestID.Lookup(requestID); !ok {
+			return
+		}
+		if _, ok := c.pendingUploads.notified.Take(requestID); ok {
+			if c.startPendingUpload(requestID) {
+				log.Log(requestID, "direct upload completed, notified by storage event on another node")
+			}
+			return
+		}
+
+		if uploaded, err := isUploaded(ctx, sourceURL); err != nil {
+			log.Log(requestID, "error checking whether direct upload completed", "err", err)
+		} else if uploaded {
+			if c.startPendingUpload(requestID) {
+				log.Log(requestID, "direct upload completed, detected by polling")
+			}
+			return
+		}
+	}
+}
+
+// NotifyObjectCreated starts the jobs waiting for the given object of the storage at host to be uploaded. The jobs
+// submitted to other nodes are started by them once they see the notification, when the pending uploads are shared.
+// It returns the number of jobs started.
+func (c *Coordinator) NotifyObjectCreated(host, bucket, key string) int {
+	location := host + "/" + bucket + "/" + key
+	requestID, ok := c.pendingUploads.byObject.Lookup(location)
+	if !ok {
+		return 0
+	}
+	if _, local := c.pendingUploads.byRequestID.Lookup(requestID); local {
+		if !c.startPendingUpload(requestID) {
+			return 0
+		}
+		log.Log(requestID, "direct upload completed, notified by storage event", "bucket", bucket, "key", key)
+		return 1
+	}
+	// Taken so that the duplicate notifications are ignored
+	if _, ok := c.pendingUploads.byObject.Take(location); !ok {
+		return 0
+	}
+	c.pendingUploads.notified.Store(requestID, true)
+	log.Log(requestID, "direct upload completed, notified by storage event for the node it was submitted to", "bucket", bucket, "key", key)
+	return 1
+}
+
+// startPendingUpload starts the job once there's a free job slot for it, if it's still waiting for its upload.
+// Returns false if the job was already started or expired.
+func (c *Coordinator) startPendingUpload(requestID string) bool {
+	p, ok := c.pendingUploads.take(requestID)
+	if !ok {
+		return false
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), uploadAdmissionTimeout)
+		defer cancel()
+		if err := c.StartUploadJobWhenAdmitted(ctx, p); err != nil {
+			log.LogError(requestID, "gave up on the job of the direct upload, no free job slot", err)
+			c.failPendingUpload(p, "timed out waiting for a free job slot", errors.CodeTooManyRequests)
+		}
+	}()
+	return true
+}
+
+func (c *Coordinator) expireUpload(requestID string) {
+	p, ok := c.pendingUploads.take(requestID)
+	if !ok {
+		return
+	}
+	log.Log(requestID, "timed out waiting for direct upload to complete")
+	c.failPendingUpload(p, "timed out waiting for the source file to be uploaded", errors.CodeInputNotFound)
+}
+
+// failPendingUpload sends the error callback of a job that couldn't be started
+func (c *Coordinator) failPendingUpload(p UploadJobPayload, msg, code string) {
+	c.finishIdempotentJob(p.IdempotencyKey, p.RequestID, false)
+	tsm := clients.NewTranscodeStatusError(p.CallbackURL, p.RequestID, msg, true)
+	tsm.ErrorCode = code
+	tsm.Metadata = p.Metadata
+	if err := c.statusClient.SendTranscodeStatus(tsm); err != nil {
+		log.LogError(p.RequestID, "failed to send the error callback of the direct upload", err)
+	}
+}
+
+// objectLocations returns the locations, as host/bucket/key, that the storage events can name the source with. The
+// source can be either an object store URL or an HTTP URL in path (host/bucket/key) or virtual host (bucket.host/key)
+// style. The credentials of the URL are left out.
+func objectLocations(sourceURL *url.URL) []string {
+	p := strings.TrimPrefix(sourceURL.Path, "/")
+	locations := []string{sourceURL.Host + "/" + p}
+	if bucket, host, ok := strings.Cut(sourceURL.Host, "."); ok {
+		locations = append(locations, host+"/"+bucket+"/"+p)
+	}
+	return locations
+}
+
+// objectMatches checks if the object from a storage event is the source of a pending upload
+func objectMatches(sourceURL *url.URL, host, bucket, key string) bool {
+	return slices.Contains(objectLocations(sourceURL), host+"/"+bucket+"/"+key)
+}
+
+// isUploaded checks whether the source file exists. Object store URLs are checked with the storage driver and HTTP URLs
+// with a HEAD request. Notice that pre-signed upload URLs are only valid for PUT, so those rely on event notifications.
+func isUploaded(ctx context.Context, sourceURL *url.URL) (bool, error) {
+	if clients.IsOSURL(sourceURL.String()) {
+		// Not all drivers support byte ranges, but the body is streamed and closed straight away
+		info, err := clients.GetOSURL(sourceURL.String(), "")
+		if errors.IsObjectNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		info.Body.Close()
+		return true, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sourceURL.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("HEAD returned HTTP %d", resp.StatusCode)
+	}
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package playback
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func bucketHosts(buckets []*url.URL) []string {
+	var hosts []string
+	for _, b := range buckets {
+		hosts = append(hosts, b.Host)
+	}
+	return hosts
+}
+
+func TestBucketRouter(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`
+buckets:
+  eu: s3+https://eu.example.com/bucket
+  us: s3+https://us.example.com/bucket
+  ap: s3+https://ap.example.com/bucket
+rules:
+  - playback_id_prefix: eu
+    buckets: [eu]
+  - region: sin
+    buckets: [ap, us]
+  - buckets: [eu, us]
+    round_robin: true
+`), 0644))
+
+	r, err := NewBucketRouter(nil, "fra", rulesFile)
+	require.NoError(t, err)
+	require.Equal(t, []string{"eu.example.com"}, bucketHosts(r.Route("eu123")))
+	// The region rule doesn't match the node's region, so the round robin one applies
+	require.Equal(t, []string{"us.example.com", "eu.example.com"}, bucketHosts(r.Route("abc")))
+	require.Equal(t, []string{"eu.example.com", "us.example.com"}, bucketHosts(r.Route("abc")))
+
+	// Failing buckets are tried last
+	r.MarkUnhealthy(r.Route("eu123")[0])
+	require.Equal(t, []string{"us.example.com", "eu.example.com"}, bucketHosts(r.Route("abc")))
+	require.Equal(t, []string{"us.example.com", "eu.example.com"}, bucketHosts(r.Route("abc")))
+
+	r.region = "sin"
+	require.Equal(t, []string{"ap.example.com", "us.example.com"}, bucketHosts(r.Route("abc")))
+}
+
+func TestBucketRouterReload(t *testing.T) {
+	first, _ := url.Parse("s3+https://first.example.com/bucket")
+	second, _ := url.Parse("s3+https://second.example.com/bucket")
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`{"rules": [{"playback_id_prefix": "x", "buckets": ["1"]}]}`), 0644))
+
+	r, err := NewBucketRouter([]*url.URL{first, second}, "", rulesFile)
+	require.NoError(t, err)
+	require.Equal(t, []string{"second.example.com"}, bucketHosts(r.Route("x1")))
+	require.Equal(t, []string{"first.example.com", "second.example.com"}, bucketHosts(r.Route("y1")))
+
+	// Invalid rules are rejected and the previous ones kept
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`{"rules": [{"buckets": ["2"]}]}`), 0644))
+	require.NoError(t, os.Chtimes(rulesFile, time.Now(), time.Now().Add(time.Second)))
+	_, err = r.reload()
+	require.EqualError(t, err, `private bucket rule 0 refers to unknown bucket "2"`)
+	require.Equal(t, []string{"second.example.com"}, bucketHosts(r.Route("x1")))
+
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`{"rules": [{"buckets": ["0"]}]}`), 0644))
+	require.NoError(t, os.Chtimes(rulesFile, time.Now(), time.Now().Add(2*time.Second)))
+	reloaded, err := r.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, []string{"first.example.com"}, bucketHosts(r.Route("x1")))
+
+	_, err = NewBucketRouter(nil, "", filepath.Join(t.TempDir(), "missing.yaml"))
+	require.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package playback
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// DeviceClientHints are the client hints the device capabilities are detected from, on top of the user agent. The
+// browsers only send them once asked to with Accept-CH.
+var DeviceClientHints = []string{"Sec-CH-UA-Mobile", "Device-Memory"}
+
+// Devices with user agents containing these tokens are known to struggle with the taller renditions
+var lowEndUserAgents = []struct {
+	token     string
+	maxHeight int
+}{
+	{"KAIOS", 360},
+	{"Android 4.", 480},
+	{"Android 5.", 720},
+	{"Tizen 2.", 720},
+}
+
+// DeviceCapabilities is what a device playing a master playlist can decode, as far as its request tells
+type DeviceCapabilities struct {
+	// Tallest rendition the device plays smoothly, 0 if it's not known to be limited
+	MaxHeight int
+	// Whether the device decodes HEVC
+	HEVC bool
+}
+
+// DetectDeviceCapabilities guesses what the device decodes from the user agent and client hints of its request. The
+// devices it knows nothing about are assumed to play every rendition but not HEVC.
+func DetectDeviceCapabilities(h http.Header) DeviceCapabilities {
+	var caps DeviceCapabilities
+	limit := func(maxHeight int) {
+		if caps.MaxHeight == 0 || maxHeight < caps.MaxHeight {
+			caps.MaxHeight = maxHeight
+		}
+	}
+
+	userAgent := h.Get("User-Agent")
+	upperUserAgent := strings.ToUpper(userAgent)
+	for _, lowEnd := range lowEndUserAgents {
+		if strings.Contains(upperUserAgent, strings.ToUpper(lowEnd.token)) {
+			limit(lowEnd.maxHeight)
+		}
+	}
+	// In GiB, rounded down to a power of 2
+	if memory, err := strconv.ParseFloat(h.Get("Device-Memory"), 64); err == nil && memory > 0 {
+		switch {
+		case memory <= 0.5:
+			limit(360)
+		case memory <= 1:
+			limit(480)
+		case memory <= 2:
+			limit(720)
+		}
+	}
+	if h.Get("Sec-CH-UA-Mobile") == "?1" || strings.Contains(userAgent, "Mobi") {
+		limit(1080)
+	}
+
+	// Apple's players decode HEVC, be it AVPlayer or Safari. Chrome and the other browsers claiming to be Safari don't
+	// reliably.
+	isSafari := strings.Contains(userAgent, "Safari/") && strings.Contains(userAgent, "Version/") &&
+		!strings.Contains(userAgent, "Chrome") && !strings.Contains(userAgent, "Chromium") && !strings.Contains(userAgent, "Android")
+	isApple := strings.Contains(userAgent, "Macintosh") || strings.Contains(userAgent, "iPhone") || strings.Contains(userAgent, "iPad")
+	caps.HEVC = strings.Contains(userAgent, "AppleCoreMedia") || (isSafari && isApple)
+	return caps
+}
+
+// TailorMasterPlaylist fits the variants of a master playlist to the device playing it. The variants taller than the
+// device plays smoothly are removed, unless no video would be left, in which case only the shortest ones are kept. The
+// variants the device decodes best are listed first, for players to start with them: HEVC for the devices decoding
+// it, H.264 for the others. Returns whether the playlist was changed.
+func TailorMasterPlaylist(pl *m3u8.MasterPlaylist, device DeviceCapabilities) bool {
+	var variants []*m3u8.Variant
+	for _, v := range pl.Variants {
+		if v == nil {
+			break
+		}
+		variants = append(variants, v)
+	}
+
+	kept := variants
+	if device.MaxHeight > 0 {
+		kept = nil
+		shortest, keptVideo := -1, false
+		for _, v := range variants {
+			h := variantHeight(v)
+			if h <= device.MaxHeight {
+				kept = append(kept, v)
+				keptVideo = keptVideo || h > 0
+			}
+			if h > 0 && (shortest < 0 || h < shortest) {
+				shortest = h
+			}
+		}
+		if !keptVideo && shortest > 0 {
+			kept = nil
+			for _, v := range variants {
+				if h := variantHeight(v); h == 0 || h == shortest {
+					kept = append(kept, v)
+				}
+			}
+		}
+	}
+
+	ordered := append([]*m3u8.Variant(nil), kept...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return isHEVCVariant(ordered[i]) == device.HEVC && isHEVCVariant(ordered[j]) != device.HEVC
+	})
+
+	changed := len(ordered) != len(variants)
+	for i := range ordered {
+		changed = changed || ordered[i] != variants[i]
+	}
+	pl.Variants = ordered
+	return changed
+}
+
+// variantHeight returns the height of the resolution of a variant, 0 if it has none, e.g. audio only
+func variantHeight(v *m3u8.Variant) int {
+	_, height, found := strings.Cut(v.Resolution, "x")
+	if !found {
+		return 0
+	}
+	h, _ := strconv.Atoi(height)
+	return h
+}
+
+func isHEVCVariant(v *m3u8.Variant) bool {
+	codecs := strings.ToLower(v.Codecs)
+	return strings.Contains(codecs, "hvc1") || strings.Contains(codecs, "hev1")
+}
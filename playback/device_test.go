@@ -0,0 +1,128 @@
+package playback
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectDeviceCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected DeviceCapabilities
+	}{
+		{
+			name:     "unknown device",
+			headers:  map[string]string{},
+			expected: DeviceCapabilities{},
+		},
+		{
+			name:     "desktop chrome",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
+			expected: DeviceCapabilities{},
+		},
+		{
+			name:     "desktop safari",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15"},
+			expected: DeviceCapabilities{HEVC: true},
+		},
+		{
+			name:     "iphone safari",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"},
+			expected: DeviceCapabilities{MaxHeight: 1080, HEVC: true},
+		},
+		{
+			name:     "avplayer",
+			headers:  map[string]string{"User-Agent": "AppleCoreMedia/1.0.0.21A329 (Apple TV; U; CPU OS 17_0 like Mac OS X; en_us)"},
+			expected: DeviceCapabilities{HEVC: true},
+		},
+		{
+			name:     "old android",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 4.4.2; SM-G7102) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/30.0.0.0 Mobile Safari/537.36"},
+			expected: DeviceCapabilities{MaxHeight: 480},
+		},
+		{
+			name:     "kaios",
+			headers:  map[string]string{"User-Agent": "Mozilla/5.0 (Mobile; LYF/F300B/LYF-F300B-001-01-15-130718-i; Android; rv:48.0) Gecko/48.0 Firefox/48.0 KAIOS/2.5"},
+			expected: DeviceCapabilities{MaxHeight: 360},
+		},
+		{
+			name:     "mobile client hint",
+			headers:  map[string]string{"Sec-CH-UA-Mobile": "?1"},
+			expected: DeviceCapabilities{MaxHeight: 1080},
+		},
+		{
+			name:     "low device memory",
+			headers:  map[string]string{"Sec-CH-UA-Mobile": "?1", "Device-Memory": "1"},
+			expected: DeviceCapabilities{MaxHeight: 480},
+		},
+		{
+			name:     "plenty of device memory",
+			headers:  map[string]string{"Device-Memory": "8"},
+			expected: DeviceCapabilities{},
+		},
+		{
+			name:     "invalid device memory",
+			headers:  map[string]string{"Device-Memory": "lots"},
+			expected: DeviceCapabilities{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			require.Equal(t, tt.expected, DetectDeviceCapabilities(h))
+		})
+	}
+}
+
+func newTestMasterPlaylist(variants ...*m3u8.VariantParams) *m3u8.MasterPlaylist {
+	pl := m3u8.NewMasterPlaylist()
+	for _, v := range variants {
+		pl.Append(v.Name+"/index.m3u8", nil, *v)
+	}
+	return pl
+}
+
+func variantNames(pl *m3u8.MasterPlaylist) []string {
+	var names []string
+	for _, v := range pl.Variants {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+func TestTailorMasterPlaylist(t *testing.T) {
+	h264 := func(name, resolution string) *m3u8.VariantParams {
+		return &m3u8.VariantParams{Name: name, Resolution: resolution, Codecs: "avc1.64001f,mp4a.40.2"}
+	}
+	hevc := func(name, resolution string) *m3u8.VariantParams {
+		return &m3u8.VariantParams{Name: name, Resolution: resolution, Codecs: "hvc1.1.6.L93.B0,mp4a.40.2"}
+	}
+
+	pl := newTestMasterPlaylist(h264("1080p", "1920x1080"), h264("720p", "1280x720"), h264("360p", "640x360"))
+	require.False(t, TailorMasterPlaylist(pl, DeviceCapabilities{}))
+	require.Equal(t, []string{"1080p", "720p", "360p"}, variantNames(pl))
+
+	require.True(t, TailorMasterPlaylist(pl, DeviceCapabilities{MaxHeight: 720}))
+	require.Equal(t, []string{"720p", "360p"}, variantNames(pl))
+
+	// only the shortest is kept when they're all too tall, the audio only one staying whatever the device
+	pl = newTestMasterPlaylist(h264("720p", "1280x720"), h264("480p", "854x480"), &m3u8.VariantParams{Name: "audio", Codecs: "mp4a.40.2"})
+	require.True(t, TailorMasterPlaylist(pl, DeviceCapabilities{MaxHeight: 360}))
+	require.Equal(t, []string{"480p", "audio"}, variantNames(pl))
+	pl = newTestMasterPlaylist(&m3u8.VariantParams{Name: "audio", Codecs: "mp4a.40.2"})
+	require.False(t, TailorMasterPlaylist(pl, DeviceCapabilities{MaxHeight: 360}))
+
+	// the codec the device decodes best is listed first
+	pl = newTestMasterPlaylist(h264("720p", "1280x720"), hevc("720p-hevc", "1280x720"), h264("360p", "640x360"), hevc("360p-hevc", "640x360"))
+	require.True(t, TailorMasterPlaylist(pl, DeviceCapabilities{HEVC: true}))
+	require.Equal(t, []string{"720p-hevc", "360p-hevc", "720p", "360p"}, variantNames(pl))
+	require.True(t, TailorMasterPlaylist(pl, DeviceCapabilities{MaxHeight: 480}))
+	require.Equal(t, []string{"360p", "360p-hevc"}, variantNames(pl))
+}
@@ -6,9 +6,11 @@ import (
 	"io"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/grafov/m3u8"
 	"github.com/livepeer/catalyst-api/clients"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/go-tools/drivers"
 )
 
@@ -19,6 +21,15 @@ type Request struct {
 	GatingParam     string
 	GatingParamName string
 	Range           string
+	// Conditional request headers, the objects other than manifests are served as not modified when they match
+	IfNoneMatch     string
+	IfModifiedSince string
+	// A ranged read is served whole when the object doesn't match the If-Range header
+	IfRange string
+	// Told about the buckets failing to serve the request, nil when the buckets aren't routed
+	Router *BucketRouter
+	// The master playlists are tailored to the device, see TailorMasterPlaylist, unless nil
+	Device *DeviceCapabilities
 }
 
 type Response struct {
@@ -26,25 +37,21 @@ type Response struct {
 	ContentType   string
 	ContentLength *int64
 	ETag          string
+	LastModified  time.Time
 	ContentRange  string
+	// The client's copy of the object is current as per the conditional headers of the request, there's no body
+	NotModified bool
 }
 
 func Handle(buckets []*url.URL, req Request) (*Response, error) {
-	f, err := osFetch(buckets, req.PlaybackID, req.File, req.Range)
+	if !IsManifest(req.File) {
+		return handleObject(buckets, req)
+	}
+	f, err := osFetch(buckets, req.Router, req.PlaybackID, req.File, "")
 	if err != nil {
 		return nil, err
 	}
 
-	if !IsManifest(req.File) {
-		return &Response{
-			Body:          f.Body,
-			ContentType:   f.ContentType,
-			ContentLength: f.Size,
-			ETag:          f.ETag,
-			ContentRange:  f.ContentRange,
-		}, nil
-	}
-	// don't close the body for non-manifest files where we return above as we simply proxying the body back
 	defer f.Body.Close()
 
 	p, listType, err := m3u8.DecodeFrom(f.Body, true)
@@ -54,6 +61,9 @@ func Handle(buckets []*url.URL, req Request) (*Response, error) {
 	switch listType {
 	case m3u8.MASTER:
 		masterPl := p.(*m3u8.MasterPlaylist)
+		if req.Device != nil {
+			TailorMasterPlaylist(masterPl, *req.Device)
+		}
 		for _, variant := range masterPl.Variants {
 			if variant == nil {
 				break
@@ -85,6 +95,64 @@ func Handle(buckets []*url.URL, req Request) (*Response, error) {
 	}, nil
 }
 
+// handleObject proxies an object other than a manifest as is, honouring the range and the conditional headers of the
+// request. Small ranges are served from memory once read.
+func handleObject(buckets []*url.URL, req Request) (*Response, error) {
+	cacheKey := rangeCacheKey(req.PlaybackID, req.File, req.Range)
+	if req.Range != "" {
+		if cached, ok := rangeCache.Lookup(cacheKey); ok && ifRangeMatches(req.IfRange, cached.ETag, cached.LastModified) {
+			if notModified(req, cached.ETag, cached.LastModified) {
+				return &Response{NotModified: true, ETag: cached.ETag, LastModified: cached.LastModified}, nil
+			}
+			return cached.response(), nil
+		}
+	}
+
+	f, err := osFetch(buckets, req.Router, req.PlaybackID, req.File, req.Range)
+	if err != nil {
+		return nil, err
+	}
+	if req.Range != "" && !ifRangeMatches(req.IfRange, f.ETag, f.LastModified) {
+		// the object changed since the client read its other parts, so it gets the whole of it
+		f.Body.Close()
+		req.Range = ""
+		if f, err = osFetch(buckets, req.Router, req.PlaybackID, req.File, ""); err != nil {
+			return nil, err
+		}
+	}
+	if notModified(req, f.ETag, f.LastModified) {
+		f.Body.Close()
+		return &Response{NotModified: true, ETag: f.ETag, LastModified: f.LastModified}, nil
+	}
+
+	if f.ContentRange == "" || f.Size == nil || *f.Size > maxCachedRangeBytes {
+		return &Response{
+			Body:          f.Body,
+			ContentType:   f.ContentType,
+			ContentLength: f.Size,
+			ETag:          f.ETag,
+			LastModified:  f.LastModified,
+			ContentRange:  f.ContentRange,
+		}, nil
+	}
+	defer f.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(f.Body, maxCachedRangeBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read range: %w", err)
+	}
+	cached := &cachedRange{
+		Body:         body,
+		ContentType:  f.ContentType,
+		ContentRange: f.ContentRange,
+		ETag:         f.ETag,
+		LastModified: f.LastModified,
+	}
+	if int64(len(body)) == *f.Size {
+		rangeCache.Store(cacheKey, cached)
+	}
+	return cached.response(), nil
+}
+
 func appendAccessKey(uri, gatingParam, gatingParamName string) (string, error) {
 	if gatingParam == "" {
 		return uri, nil
@@ -99,7 +167,7 @@ func appendAccessKey(uri, gatingParam, gatingParamName string) (string, error) {
 	return variantURI.String(), nil
 }
 
-func osFetch(buckets []*url.URL, playbackID, file, byteRange string) (*drivers.FileInfoReader, error) {
+func osFetch(buckets []*url.URL, router *BucketRouter, playbackID, file, byteRange string) (*drivers.FileInfoReader, error) {
 	if len(buckets) < 1 {
 		return nil, errors.New("playback failed, no private buckets configured")
 	}
@@ -112,10 +180,29 @@ func osFetch(buckets []*url.URL, playbackID, file, byteRange string) (*drivers.F
 	for _, bucket := range buckets {
 		osURL := bucket.JoinPath("hls").JoinPath(playbackID).JoinPath(file)
 		f, err = clients.GetOSURL(osURL.String(), byteRange)
+		if byteRange != "" && errors.Is(err, drivers.ErrNotSupported) {
+			// the store doesn't support ranged reads, e.g. a filesystem, so the range is read from the whole object
+			if f, err = clients.GetOSURL(osURL.String(), ""); err == nil {
+				f, err = sliceRange(f, byteRange)
+			}
+		} else if byteRange != "" && err == nil && f.ContentRange == "" {
+			// the store ignored the range and returned the whole object
+			f, err = sliceRange(f, byteRange)
+		}
 		if err == nil {
 			// object found successfully so return early
 			return f, nil
 		}
+		var notSatisfiable RangeNotSatisfiableError
+		if errors.As(err, &notSatisfiable) {
+			return nil, err
+		}
+		if isRangeNotSatisfiable(err) {
+			return nil, RangeNotSatisfiableError{Size: -1}
+		}
+		if !catErrs.IsObjectNotFound(err) {
+			router.MarkUnhealthy(bucket)
+		}
 		// if this is the final bucket in the list then the error set here will be used in the final return
 		err = fmt.Errorf("failed to get file for playback: %w", err)
 	}
@@ -0,0 +1,195 @@
+package playback
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/go-tools/drivers"
+)
+
+const (
+	// Ranged reads up to this size are cached, e.g. the moov atoms players read from both ends of an MP4 when they
+	// scrub through it
+	maxCachedRangeBytes = 256 * 1024
+	rangeCacheTTL       = 5 * time.Minute
+	rangeCacheMaxSize   = 512
+)
+
+// cachedRange is the response to a ranged read of an object, kept in memory
+type cachedRange struct {
+	Body         []byte
+	ContentType  string
+	ContentRange string
+	ETag         string
+	LastModified time.Time
+}
+
+var rangeCache = cache.NewWithOptions[*cachedRange](cache.Options{Name: "playback_ranges", TTL: rangeCacheTTL, MaxSize: rangeCacheMaxSize})
+
+func rangeCacheKey(playbackID, file, byteRange string) string {
+	return playbackID + "/" + file + "|" + byteRange
+}
+
+func (c *cachedRange) response() *Response {
+	size := int64(len(c.Body))
+	return &Response{
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentType:   c.ContentType,
+		ContentLength: &size,
+		ETag:          c.ETag,
+		LastModified:  c.LastModified,
+		ContentRange:  c.ContentRange,
+	}
+}
+
+// RangeNotSatisfiableError is returned for a range starting past the end of the object. Size is the size of the
+// object, -1 if unknown.
+type RangeNotSatisfiableError struct {
+	Size int64
+}
+
+func (e RangeNotSatisfiableError) Error() string {
+	return "requested range not satisfiable"
+}
+
+// ContentRange is the Content-Range header of the 416 response
+func (e RangeNotSatisfiableError) ContentRange() string {
+	if e.Size < 0 {
+		return ""
+	}
+	return fmt.Sprintf("bytes */%d", e.Size)
+}
+
+// isRangeNotSatisfiable tells whether the object store rejected the range of a read, as S3 does for a range starting
+// past the end of the object
+func isRangeNotSatisfiable(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == "InvalidRange"
+}
+
+// parseByteRange parses a Range header of a single range, e.g. "bytes=0-99", "bytes=100-" or "bytes=-100", into the
+// first and last offsets of the range in an object of the given size. ok is false for headers that aren't a single
+// byte range, which are served the whole object as allowed by RFC 9110.
+func parseByteRange(header string, size int64) (start, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false, nil
+		}
+		if size == 0 {
+			return 0, 0, false, RangeNotSatisfiableError{Size: size}
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, RangeNotSatisfiableError{Size: size}
+	}
+	return start, end, true, nil
+}
+
+// sliceRange serves the range of an object read whole, for the object stores that don't support ranged reads. The
+// body of the object is closed on errors.
+func sliceRange(f *drivers.FileInfoReader, byteRange string) (*drivers.FileInfoReader, error) {
+	if f.Size == nil {
+		// the range can't be resolved without the size, so the whole object is served
+		return f, nil
+	}
+	size := *f.Size
+	start, end, ok, err := parseByteRange(byteRange, size)
+	if err != nil {
+		f.Body.Close()
+		return nil, err
+	}
+	if !ok {
+		return f, nil
+	}
+	if _, err := io.CopyN(io.Discard, f.Body, start); err != nil {
+		f.Body.Close()
+		return nil, fmt.Errorf("failed to skip to the start of the range: %w", err)
+	}
+	length := end - start + 1
+	sliced := *f
+	sliced.Body = readCloser{io.LimitReader(f.Body, length), f.Body}
+	sliced.Size = &length
+	sliced.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+	return &sliced, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ifRangeMatches tells whether a ranged read is still served as a range with the If-Range header of the request,
+// i.e. whether the object is the one the client has the other parts of. Only strong ETags match.
+func ifRangeMatches(ifRange, etag string, lastModified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && ifRange == etag
+	}
+	at, err := http.ParseTime(ifRange)
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(at)
+}
+
+// notModified tells whether the client's copy of the object is current per the If-None-Match and If-Modified-Since
+// headers of the request, the latter only being considered without the former as per RFC 9110
+func notModified(req Request, etag string, lastModified time.Time) bool {
+	if req.IfNoneMatch != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(req.IfNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if req.IfModifiedSince == "" || lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(req.IfModifiedSince)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,87 @@
+package playback
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-tools/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		start, end int64
+		ok         bool
+		err        bool
+	}{
+		{header: "bytes=0-99", start: 0, end: 99, ok: true},
+		{header: "bytes=100-", start: 100, end: 999, ok: true},
+		{header: "bytes=900-2000", start: 900, end: 999, ok: true},
+		{header: "bytes=-100", start: 900, end: 999, ok: true},
+		{header: "bytes=-5000", start: 0, end: 999, ok: true},
+		{header: "bytes=1000-", err: true},
+		{header: "bytes=0-1,5-6"},
+		{header: "bytes=5-1"},
+		{header: "items=0-1"},
+		{header: "bytes=abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, end, ok, err := parseByteRange(tt.header, 1000)
+			if tt.err {
+				require.Equal(t, RangeNotSatisfiableError{Size: 1000}, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.start, start)
+			require.Equal(t, tt.end, end)
+		})
+	}
+}
+
+func TestSliceRange(t *testing.T) {
+	size := int64(10)
+	f, err := sliceRange(&drivers.FileInfoReader{
+		FileInfo: drivers.FileInfo{Size: &size, ETag: `"abc"`},
+		Body:     io.NopCloser(strings.NewReader("0123456789")),
+	}, "bytes=3-4")
+	require.NoError(t, err)
+	require.Equal(t, "bytes 3-4/10", f.ContentRange)
+	require.Equal(t, int64(2), *f.Size)
+	require.Equal(t, `"abc"`, f.ETag)
+	body, err := io.ReadAll(f.Body)
+	require.NoError(t, err)
+	require.Equal(t, "34", string(body))
+	require.NoError(t, f.Body.Close())
+}
+
+func TestConditionalRequests(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 10, 0, 0, 500, time.UTC)
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	require.True(t, notModified(Request{IfNoneMatch: `"abc"`}, `"abc"`, lastModified))
+	require.True(t, notModified(Request{IfNoneMatch: `"xyz", W/"abc"`}, `"abc"`, lastModified))
+	require.True(t, notModified(Request{IfNoneMatch: "*"}, `"abc"`, lastModified))
+	require.False(t, notModified(Request{IfNoneMatch: `"xyz"`}, `"abc"`, lastModified))
+	require.False(t, notModified(Request{IfNoneMatch: `"abc"`}, "", lastModified))
+	// If-Modified-Since is ignored with If-None-Match
+	require.False(t, notModified(Request{IfNoneMatch: `"xyz"`, IfModifiedSince: after}, `"abc"`, lastModified))
+	require.True(t, notModified(Request{IfModifiedSince: lastModified.Format(http.TimeFormat)}, `"abc"`, lastModified))
+	require.True(t, notModified(Request{IfModifiedSince: after}, `"abc"`, lastModified))
+	require.False(t, notModified(Request{IfModifiedSince: before}, `"abc"`, lastModified))
+	require.False(t, notModified(Request{IfModifiedSince: after}, `"abc"`, time.Time{}))
+	require.False(t, notModified(Request{}, `"abc"`, lastModified))
+
+	require.True(t, ifRangeMatches("", "", time.Time{}))
+	require.True(t, ifRangeMatches(`"abc"`, `"abc"`, lastModified))
+	require.False(t, ifRangeMatches(`"xyz"`, `"abc"`, lastModified))
+	require.True(t, ifRangeMatches(after, `"abc"`, lastModified))
+	require.False(t, ifRangeMatches(before, `"abc"`, lastModified))
+	require.False(t, ifRangeMatches(after, `"abc"`, time.Time{}))
+}
@@ -0,0 +1,104 @@
+package playback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	catErrs "github.com/livepeer/catalyst-api/errors"
+)
+
+// SignedURLParam is the query parameter of the signed playback URLs carrying their token. The playback endpoint passes
+// it on to the URIs of the playlists, like the other gating parameters.
+const SignedURLParam = "token"
+
+var ErrInvalidSignedURL = fmt.Errorf("invalid signed playback url: %w", catErrs.UnauthorisedError)
+
+// URLSigner mints and verifies short-lived signed playback URLs, an HMAC over the path they're valid for and their
+// expiry with a shared key. It lets simple deployments gate their content without running the gate API.
+type URLSigner struct {
+	key []byte
+}
+
+type signedPlayback struct {
+	// Path of the file the URL is valid for, or of the directory when ending with a slash, e.g.
+	// "/asset/hls/<playbackID>/" for all the playlists and segments of a playback ID
+	Path    string `json:"p"`
+	Expires int64  `json:"e"`
+}
+
+// NewURLSigner returns nil when no key is set, in which case there are no signed playback URLs
+func NewURLSigner(key string) *URLSigner {
+	if key == "" {
+		return nil
+	}
+	return &URLSigner{key: []byte(key)}
+}
+
+// PlaybackIDPath is the path of the playback endpoint to sign for all the playlists and segments of a playback ID
+func PlaybackIDPath(playbackID string) string {
+	return "/asset/hls/" + playbackID + "/"
+}
+
+// Token returns the token of the signed URLs of the path, valid until the expiry. Paths ending with a slash are signed
+// for all the files within.
+func (s *URLSigner) Token(playbackPath string, expires time.Time) (string, error) {
+	if !strings.HasPrefix(playbackPath, "/") || cleanPath(playbackPath) != playbackPath {
+		return "", fmt.Errorf("invalid playback path %q", playbackPath)
+	}
+	payload, err := json.Marshal(signedPlayback{Path: playbackPath, Expires: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), nil
+}
+
+// Verify checks that the token is signed with the key, hasn't expired and is valid for the requested path
+func (s *URLSigner) Verify(token, requestPath string, now time.Time) error {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidSignedURL
+	}
+	decodedSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(decodedSig, s.sign(encoded)) {
+		return ErrInvalidSignedURL
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidSignedURL
+	}
+	var signed signedPlayback
+	if err := json.Unmarshal(payload, &signed); err != nil || signed.Path == "" {
+		return ErrInvalidSignedURL
+	}
+	if now.Unix() > signed.Expires {
+		return fmt.Errorf("%w: expired", ErrInvalidSignedURL)
+	}
+	// the requested path is cleaned so that the directories can't be escaped from with ..
+	requestPath = cleanPath(requestPath)
+	if requestPath != signed.Path && !(strings.HasSuffix(signed.Path, "/") && strings.HasPrefix(requestPath, signed.Path)) {
+		return fmt.Errorf("%w: not valid for %s", ErrInvalidSignedURL, requestPath)
+	}
+	return nil
+}
+
+func (s *URLSigner) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// cleanPath cleans a path like path.Clean, keeping its trailing slash
+func cleanPath(p string) string {
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
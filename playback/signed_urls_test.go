@@ -0,0 +1,52 @@
+package playback
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoURLSignerWithoutKey(t *testing.T) {
+	require.Nil(t, NewURLSigner(""))
+}
+
+func TestSignedURLs(t *testing.T) {
+	s := NewURLSigner("key")
+	now := time.Now()
+	token, err := s.Token(PlaybackIDPath("abc123"), now.Add(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Verify(token, "/asset/hls/abc123/index.m3u8", now))
+	require.NoError(t, s.Verify(token, "/asset/hls/abc123/720p0/1.ts", now))
+	require.NoError(t, s.Verify(token, "/asset/hls/abc123/", now))
+
+	for name, verify := range map[string]func() error{
+		"other playback ID": func() error { return s.Verify(token, "/asset/hls/abc1234/index.m3u8", now) },
+		"escaping the path": func() error { return s.Verify(token, "/asset/hls/abc123/../other/index.m3u8", now) },
+		"expired":           func() error { return s.Verify(token, "/asset/hls/abc123/index.m3u8", now.Add(2*time.Hour)) },
+		"other key":         func() error { return NewURLSigner("other").Verify(token, "/asset/hls/abc123/index.m3u8", now) },
+		"tampered":          func() error { return s.Verify("x"+token, "/asset/hls/abc123/index.m3u8", now) },
+		"malformed":         func() error { return s.Verify("token", "/asset/hls/abc123/index.m3u8", now) },
+		"invalid signature": func() error { return s.Verify(token+"!", "/asset/hls/abc123/index.m3u8", now) },
+		"missing signature": func() error { return s.Verify(token[:len(token)-44], "/asset/hls/abc123/index.m3u8", now) },
+	} {
+		err := verify()
+		require.Error(t, err, name)
+		require.True(t, errors.Is(err, catErrs.UnauthorisedError), name)
+	}
+
+	// a single file
+	token, err = s.Token("/asset/hls/abc123/index.m3u8", now.Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, s.Verify(token, "/asset/hls/abc123/index.m3u8", now))
+	require.Error(t, s.Verify(token, "/asset/hls/abc123/index.m3u8/1.ts", now))
+	require.Error(t, s.Verify(token, "/asset/hls/abc123/720p0/index.m3u8", now))
+
+	_, err = s.Token("/asset/hls/abc123/../other/", now.Add(time.Hour))
+	require.Error(t, err)
+	_, err = s.Token("asset/hls/abc123/", now.Add(time.Hour))
+	require.Error(t, err)
+}
@@ -0,0 +1,181 @@
+package playback
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/clients"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+)
+
+// SourceRenewPath is the public endpoint that exchanges the signed source playback URLs for fresh ones
+const SourceRenewPath = "/source-playback/renew"
+
+var ErrInvalidSession = fmt.Errorf("invalid source playback session: %w", catErrs.UnauthorisedError)
+
+// SourceSessions hands out source playback URLs for the source segments of private buckets. The URLs are signed, so
+// they expire, and are wrapped in the renew endpoint with a session that proves access to the job's source segments
+// for longer than a single signature lasts.
+type SourceSessions struct {
+	secret []byte
+	// Where the source segments are written to, see -source-output
+	sourceOutput *url.URL
+	ttl          time.Duration
+}
+
+type sourceSession struct {
+	// Key prefix of the source segments within the source output, e.g. "<requestID>/source/"
+	Prefix  string `json:"p"`
+	Expires int64  `json:"e"`
+}
+
+// NewSourceSessions returns nil when no secret is set, in which case private buckets have no source playback
+func NewSourceSessions(secret, sourceOutputURL string, ttl time.Duration) (*SourceSessions, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	sourceOutput, err := url.Parse(sourceOutputURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source output URL: %w", err)
+	}
+	return &SourceSessions{secret: []byte(secret), sourceOutput: sourceOutput, ttl: ttl}, nil
+}
+
+// PlaylistURI returns the URI of the renew endpoint for the source playlist at the given OS URL, which has to be
+// within the source output
+func (s *SourceSessions) PlaylistURI(playlistOSURL string) (string, error) {
+	rel, ok := strings.CutPrefix(playlistOSURL, strings.TrimSuffix(s.sourceOutput.String(), "/")+"/")
+	if !ok {
+		return "", errors.New("source playlist is not in the source output")
+	}
+	session, err := s.newSession(path.Dir(rel)+"/", time.Now())
+	if err != nil {
+		return "", err
+	}
+	signedURL, err := clients.SignURL(s.sourceOutput.JoinPath(rel))
+	if err != nil {
+		return "", err
+	}
+	return renewURI(session, signedURL), nil
+}
+
+func renewURI(session, signedURL string) string {
+	return SourceRenewPath + "?" + url.Values{"session": {session}, "url": {signedURL}}.Encode()
+}
+
+// Renew signs the object of a source playback URL again, as long as the session grants access to it. The URL may have
+// expired already.
+func (s *SourceSessions) Renew(session, signedURL string) (string, time.Time, error) {
+	osURL, err := s.objectURL(session, signedURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	renewed, err := clients.SignURL(osURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return renewed, time.Now().Add(clients.PresignDuration), nil
+}
+
+// RenewPlaylist returns the source media playlist of a source playback URL with its segments signed again and wrapped
+// in the renew endpoint, so that players keep renewing them for as long as the session lasts
+func (s *SourceSessions) RenewPlaylist(session, signedURL string) (*bytes.Buffer, error) {
+	osURL, err := s.objectURL(session, signedURL)
+	if err != nil {
+		return nil, err
+	}
+	f, err := clients.GetOSURL(osURL.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Body.Close()
+	p, listType, err := m3u8.DecodeFrom(f.Body, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest contents: %w", err)
+	}
+	if listType != m3u8.MEDIA {
+		return nil, errors.New("source playlist is not a media playlist")
+	}
+	mediaPl := p.(*m3u8.MediaPlaylist)
+	for _, segment := range mediaPl.Segments {
+		if segment == nil {
+			break
+		}
+		ref, err := url.Parse(segment.URI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse segment uri: %w", err)
+		}
+		segmentURL, err := clients.SignURL(osURL.ResolveReference(ref))
+		if err != nil {
+			return nil, err
+		}
+		segment.URI = renewURI(session, segmentURL)
+	}
+	return mediaPl.Encode(), nil
+}
+
+// objectURL returns the OS URL of the object a signed URL points to, if the session is valid for it
+func (s *SourceSessions) objectURL(session, signedURL string) (*url.URL, error) {
+	sess, err := s.verify(session, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid url", ErrInvalidSession)
+	}
+	// the host and path of signed URLs depend on the storage provider, so only the key suffix is relied on
+	_, file, ok := strings.Cut(u.Path, "/"+sess.Prefix)
+	if !ok || file == "" || path.Clean("/"+file) != "/"+file {
+		return nil, fmt.Errorf("%w: url is not part of the session", ErrInvalidSession)
+	}
+	return s.sourceOutput.JoinPath(sess.Prefix, file), nil
+}
+
+func (s *SourceSessions) newSession(prefix string, now time.Time) (string, error) {
+	payload, err := json.Marshal(sourceSession{Prefix: prefix, Expires: now.Add(s.ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), nil
+}
+
+func (s *SourceSessions) verify(session string, now time.Time) (sourceSession, error) {
+	encoded, sig, ok := strings.Cut(session, ".")
+	if !ok {
+		return sourceSession{}, ErrInvalidSession
+	}
+	decodedSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(decodedSig, s.sign(encoded)) {
+		return sourceSession{}, ErrInvalidSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return sourceSession{}, ErrInvalidSession
+	}
+	var sess sourceSession
+	if err := json.Unmarshal(payload, &sess); err != nil || sess.Prefix == "" {
+		return sourceSession{}, ErrInvalidSession
+	}
+	if now.Unix() > sess.Expires {
+		return sourceSession{}, fmt.Errorf("%w: session expired", ErrInvalidSession)
+	}
+	return sess, nil
+}
+
+func (s *SourceSessions) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/cucumber/godog"
 	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/livepeer/catalyst-api/migrations"
 )
 
 const DB_CONNECTION_STRING = "host=127.0.0.1 port=5432 sslmode=disable user=postgres password=postgres dbname=postgres"
@@ -25,60 +26,15 @@ func (s *StepContext) StartDatabase() error {
 		return err
 	}
 
-	// Create the table we'll be writing to
-	_, err = metricsDB.Exec(`
-		CREATE table user_end_trigger (
-			uuid varchar,
-			timestamp_ms bigint,
-			connection_token varchar,
-			downloaded_bytes bigint,
-			uploaded_bytes bigint,
-			session_duration_s bigint,
-			stream_id varchar,
-			stream_id_count int,
-			protocol varchar,
-			protocol_count int,
-			ip_address varchar,
-			ip_address_count int,
-			tags varchar
-		)
-	`)
+	// Create the tables we'll be writing to, the way the metrics DBs are migrated
+	stmts, err := migrations.MetricsDB()
 	if err != nil {
 		return err
 	}
-
-	// Create vod table
-	_, err = metricsDB.Exec(`
-		CREATE TABLE vod_completed (
-			timestamp                bigint,
-			request_id               text,
-			job_duration             double precision,
-			source_segment_count     integer,
-			transcoded_segment_count integer,
-			source_bytes_count       integer,
-			source_duration          double precision,
-			source_codec_video       text,
-			source_codec_audio       text,
-			pipeline                 text,
-			catalyst_region          text,
-			state                    text,
-			profiles_count           integer,
-			started_at               bigint,
-			finished_at              bigint,
-			source_url               text,
-			target_url               text,
-			in_fallback_mode         boolean,
-			external_id              text,
-			source_playback_at       bigint,
-			download_done_at         bigint,
-			segmenting_done_at       bigint,
-			transcoding_done_at      bigint,
-			is_clip                  boolean,
-			is_thumbs                boolean
-		);
-	`)
-	if err != nil {
-		return err
+	for _, stmt := range stmts {
+		if _, err := metricsDB.Exec(stmt); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -16,7 +16,10 @@ import (
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/grafov/m3u8"
+	"github.com/livepeer/catalyst-api/c2pa"
 	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/video"
 	"github.com/livepeer/go-tools/drivers"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"golang.org/x/sync/errgroup"
@@ -101,7 +104,7 @@ func GenerateThumbsVTT(requestID string, input string, output *url.URL) error {
 	// upload VTT file
 	vttContent := builder.Bytes()
 	err = backoff.Retry(func() error {
-		return clients.UploadToOSURLFields(outputLocation.String(), vttFilename, bytes.NewReader(vttContent), time.Minute, &drivers.FileProperties{ContentType: "text/vtt"})
+		return clients.UploadToOSURLFields(context.Background(), outputLocation.String(), vttFilename, bytes.NewReader(vttContent), time.Minute, &drivers.FileProperties{ContentType: "text/vtt"})
 	}, clients.UploadRetryBackoff())
 	if err != nil {
 		return fmt.Errorf("failed to upload vtt: %w", err)
@@ -109,7 +112,8 @@ func GenerateThumbsVTT(requestID string, input string, output *url.URL) error {
 	return nil
 }
 
-func GenerateThumb(segmentURI string, input []byte, output *url.URL, segmentOffset int64) error {
+// GenerateThumb generates the thumbnail of a segment and uploads it, signed with a C2PA manifest when signer is set
+func GenerateThumb(segmentURI string, input []byte, output *url.URL, segmentOffset int64, signer *c2pa.Signer) error {
 	if output == nil {
 		return fmt.Errorf("output URL is nil")
 	}
@@ -135,6 +139,10 @@ func GenerateThumb(segmentURI string, input []byte, output *url.URL, segmentOffs
 	if err := processSegment(inFilename, thumbOut); err != nil {
 		return err
 	}
+	// An unsigned thumbnail is still uploaded, as the standard MP4s are
+	if err := signer.SignFiles(context.Background(), c2pa.KindThumbnail, []c2pa.File{{Path: thumbOut, Title: filename}}, ""); err != nil {
+		log.LogNoRequestID("failed to sign thumbnail", "thumbnail", filename, "err", err)
+	}
 
 	err = backoff.Retry(func() error {
 		// upload thumbnail to storage
@@ -156,8 +164,8 @@ func GenerateThumb(segmentURI string, input []byte, output *url.URL, segmentOffs
 	return nil
 }
 
-func GenerateThumbsAndVTT(requestID, input string, output *url.URL) error {
-	err := GenerateThumbsFromManifest(requestID, input, output)
+func GenerateThumbsAndVTT(requestID, input string, output *url.URL, signer *c2pa.Signer) error {
+	err := GenerateThumbsFromManifest(requestID, input, output, signer)
 	if err != nil {
 		return err
 	}
@@ -168,7 +176,7 @@ func GenerateThumbsAndVTT(requestID, input string, output *url.URL) error {
 	return nil
 }
 
-func GenerateThumbsFromManifest(requestID, input string, output *url.URL) error {
+func GenerateThumbsFromManifest(requestID, input string, output *url.URL, signer *c2pa.Signer) error {
 	if output == nil {
 		return fmt.Errorf("output URL is nil")
 	}
@@ -218,7 +226,7 @@ func GenerateThumbsFromManifest(requestID, input string, output *url.URL) error
 			}
 
 			// generate thumbnail for the segment
-			return GenerateThumb(path.Base(segment.URI), bs, output, segmentOffset)
+			return GenerateThumb(path.Base(segment.URI), bs, output, segmentOffset, signer)
 		})
 	}
 	return uploadGroup.Wait()
@@ -230,7 +238,7 @@ func processSegment(input string, thumbOut string) error {
 
 	err := backoff.Retry(func() error {
 		ffmpegErr = bytes.Buffer{}
-		return ffmpeg.
+		return video.RunFFmpeg(video.FFmpegOpThumbnail, ffmpeg.
 			Input(input, ffmpeg.KwArgs{"skip_frame": "nokey"}). // only extract key frames
 			Output(
 				thumbOut,
@@ -240,7 +248,7 @@ func processSegment(input string, thumbOut string) error {
 					// video filter to resize
 					"vf": fmt.Sprintf("scale=%s:force_original_aspect_ratio=decrease", resolution),
 				},
-			).OverWriteOutput().WithErrorOutput(&ffmpegErr).Run()
+			).OverWriteOutput().WithErrorOutput(&ffmpegErr).Compile())
 	}, clients.DownloadRetryBackoff())
 	if err != nil {
 		return fmt.Errorf("error running ffmpeg for thumbnails %s [%s]: %w", input, ffmpegErr.String(), err)
@@ -267,6 +275,11 @@ func segmentIndex(segmentURI string) (int64, error) {
 	return i, nil
 }
 
+// PosterURL returns the location of the first thumbnail generated for a video, that players show as its poster
+func PosterURL(output *url.URL) *url.URL {
+	return output.JoinPath(outputDir, "keyframes_0.png")
+}
+
 func thumbFilename(segmentURI string, segmentOffset int64) (string, error) {
 	i, err := segmentIndex(segmentURI)
 	if err != nil {
@@ -16,7 +16,7 @@ import (
 func generateThumb(t *testing.T, filename string, out *url.URL) {
 	bs, err := os.ReadFile(filename)
 	require.NoError(t, err)
-	err = GenerateThumb(path.Base(filename), bs, out, 0)
+	err = GenerateThumb(path.Base(filename), bs, out, 0, nil)
 	require.NoError(t, err)
 }
 
@@ -45,7 +45,7 @@ func TestGenerateThumbs(t *testing.T) {
 	out, err = url.Parse(outDir)
 	require.NoError(t, err)
 
-	err = GenerateThumbsFromManifest("req ID", path.Join(wd, "..", "test/fixtures/tiny.m3u8"), out)
+	err = GenerateThumbsFromManifest("req ID", path.Join(wd, "..", "test/fixtures/tiny.m3u8"), out, nil)
 	require.NoError(t, err)
 
 	testGenerateThumbsRun(t, outDir, path.Join(wd, "..", "test/fixtures/tiny.m3u8"))
@@ -89,7 +89,7 @@ clip_102.ts
 		require.NoError(t, err)
 	}
 
-	err = GenerateThumbsFromManifest("req ID", inputFile, out)
+	err = GenerateThumbsFromManifest("req ID", inputFile, out, nil)
 	require.NoError(t, err)
 
 	testGenerateThumbsRun(t, outDir, inputFile)
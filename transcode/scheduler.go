@@ -0,0 +1,120 @@
+package transcode
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/livepeer/catalyst-api/config"
+)
+
+// Job priorities used by the segment scheduler. Higher values are scheduled first.
+const (
+	PriorityNormal = 0
+	PriorityHigh   = 10
+)
+
+// segmentScheduler hands out the node-wide segment transcode slots (config.TranscodingSegmentSlots) shared by all
+// running jobs. Workers waiting for a slot are served by job priority first and in arrival order within the same
+// priority. With no slot limit configured segments are never held back and the priority only affects the number of
+// workers allocated to a job.
+type segmentScheduler struct {
+	mu      sync.Mutex
+	inUse   int
+	seq     uint64
+	waiters []*slotWaiter
+}
+
+type slotWaiter struct {
+	job   *ParallelTranscoding
+	seq   uint64
+	ready chan struct{}
+}
+
+var scheduler = &segmentScheduler{}
+
+// acquire blocks until the job can transcode a segment and returns the function to release the slot
+func (s *segmentScheduler) acquire(job *ParallelTranscoding) func() {
+	s.mu.Lock()
+	if s.hasFreeSlot() && len(s.waiters) == 0 {
+		s.inUse++
+		s.mu.Unlock()
+		return s.release
+	}
+	s.seq++
+	w := &slotWaiter{job: job, seq: s.seq, ready: make(chan struct{})}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+
+	<-w.ready
+	return s.release
+}
+
+func (s *segmentScheduler) hasFreeSlot() bool {
+	return config.TranscodingSegmentSlots <= 0 || s.inUse < config.TranscodingSegmentSlots
+}
+
+func (s *segmentScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse--
+	s.grant()
+}
+
+// grant hands free slots to the highest priority waiters. The priority is read when the slot is granted, so bumping a
+// job moves its already queued segments ahead. Must be called with the lock held.
+func (s *segmentScheduler) grant() {
+	for len(s.waiters) > 0 && s.hasFreeSlot() {
+		next := 0
+		for i, w := range s.waiters[1:] {
+			if w.job.Priority() > s.waiters[next].job.Priority() ||
+				(w.job.Priority() == s.waiters[next].job.Priority() && w.seq < s.waiters[next].seq) {
+				next = i + 1
+			}
+		}
+		w := s.waiters[next]
+		s.waiters = append(s.waiters[:next], s.waiters[next+1:]...)
+		s.inUse++
+		close(w.ready)
+	}
+}
+
+var (
+	runningJobsMu sync.Mutex
+	runningJobs   = map[string]*ParallelTranscoding{}
+)
+
+func registerJob(requestID string, job *ParallelTranscoding) {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+	runningJobs[requestID] = job
+}
+
+func unregisterJob(requestID string, job *ParallelTranscoding) {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+	if runningJobs[requestID] == job {
+		delete(runningJobs, requestID)
+	}
+}
+
+// BumpPriority accelerates a running transcode job, e.g. when a user starts waiting for a job that was running in the
+// background. The job's remaining segments are scheduled ahead of lower priority jobs, which only holds them back when
+// config.TranscodingSegmentSlots is set, and up to extraWorkers more segments of the job are transcoded in parallel.
+// Returns the number of workers added, see ParallelTranscoding.AddWorkers.
+func BumpPriority(requestID string, priority, extraWorkers int) (int, error) {
+	runningJobsMu.Lock()
+	job, ok := runningJobs[requestID]
+	runningJobsMu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("no running transcode job found for request ID %s", requestID)
+	}
+
+	job.SetPriority(priority)
+	added := job.AddWorkers(extraWorkers)
+
+	// Waiters of this job may now be first in line
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	scheduler.grant()
+	return added, nil
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -22,11 +24,16 @@ import (
 	"github.com/livepeer/catalyst-api/log"
 	"github.com/livepeer/catalyst-api/metrics"
 	"github.com/livepeer/catalyst-api/video"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	UploadTimeout      = 5 * time.Minute
 	SegmentChannelSize = 10
+	// Number of renditions of a segment uploaded at the same time
+	RenditionUploadWorkers = 3
+	// The sidecar with the ID3 timed metadata of the source, next to the HLS manifest
+	TimedMetadataFilename = "timed_metadata.json"
 )
 
 type TranscodeSegmentRequest struct {
@@ -51,13 +58,30 @@ type TranscodeSegmentRequest struct {
 
 	RequestID      string                                 `json:"-"`
 	ReportProgress func(clients.TranscodeStatus, float64) `json:"-"`
-	C2PA           *c2pa2.C2PA                            `json:"-"`
-	LocalSourceTmp string                                 `json:"-"`
+	// ReportStage is called when the transcoding moves on to uploading the outputs
+	ReportStage    func(stage string) `json:"-"`
+	C2PA           *c2pa2.Signer      `json:"-"`
+	LocalSourceTmp string             `json:"-"`
 	GenerateMP4    bool
 	IsClip         bool
+	TrackSelection video.TrackSelection
+	LadderCap      *video.LadderCap
+	// How the generated renditions are named, config.RenditionNaming when unset
+	RenditionNaming video.RenditionNaming
+	// Retranscode only transcodes some of the renditions or segments again, reusing the job's existing outputs for
+	// the rest. The rendition sizes then only count the segments transcoded again.
+	Retranscode *video.PartialRetranscode
+	// ConditionedOutput conditions the HLS outputs for SSAI, the source having been cut at SegmentBoundaries
+	ConditionedOutput *video.ConditionedOutput
+	SegmentBoundaries []float64
+	// AudioLanguage is the language of the audio of the renditions, tagged in the master manifest when known
+	AudioLanguage string
+	// ReportPlayable is called with the playback URL of the master manifest once the HLS manifests are uploaded
+	ReportPlayable func(manifestURL string) `json:"-"`
 }
 
-func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName string, inputInfo video.InputVideo, broadcaster clients.BroadcasterClient) ([]video.OutputVideo, int, error) {
+// RunTranscodeProcess transcodes the source segments and uploads the outputs. It gives up as soon as ctx is done.
+func RunTranscodeProcess(ctx context.Context, transcodeRequest TranscodeSegmentRequest, streamName string, inputInfo video.InputVideo, broadcaster clients.BroadcasterClient) ([]video.OutputVideo, int, error) {
 	log.AddContext(transcodeRequest.RequestID, "source_manifest", transcodeRequest.SourceManifestURL, "stream_name", streamName)
 	log.Log(transcodeRequest.RequestID, "RunTranscodeProcess (v2) Beginning")
 
@@ -80,17 +104,32 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	} else if len(transcodeProfiles) == 0 {
 		return outputs, segmentsCount, fmt.Errorf("no transcode profiles could be resolved")
 	}
+	if transcodeRequest.LadderCap != nil {
+		if videoTrack, err := inputInfo.GetTrack(video.TrackTypeVideo); err == nil {
+			transcodeProfiles = video.CapLadder(transcodeProfiles, videoTrack, transcodeRequest.LadderCap.MaxBitrateFactor)
+		}
+	}
+	// Named before the VMAF check, which may lower the bitrates but keeps the names
+	transcodeProfiles = video.NameRenditions(transcodeProfiles, transcodeRequest.RenditionNaming)
+	transcodeProfiles, err = transcodeRequest.Retranscode.FilterProfiles(transcodeProfiles)
+	if err != nil {
+		return outputs, segmentsCount, err
+	}
+	if transcodeRequest.Retranscode.PartialSegments() && transcodeRequest.GenerateMP4 {
+		log.Log(transcodeRequest.RequestID, "skipping MP4 generation for a partial re-transcode of the segments")
+		transcodeRequest.GenerateMP4 = false
+	}
 
 	// Download the "source" manifest that contains all the segments we'll be transcoding
 	sourceManifest, err := clients.DownloadRenditionManifest(transcodeRequest.RequestID, sourceManifestOSURL)
 	if err != nil {
-		return outputs, segmentsCount, fmt.Errorf("error downloading source manifest: %s", err)
+		return outputs, segmentsCount, fmt.Errorf("error downloading source manifest: %w", err)
 	}
 
 	// Generate the full segment URLs from the manifest
 	sourceSegmentURLs, err := clients.GetSourceSegmentURLs(sourceManifestOSURL, sourceManifest)
 	if err != nil {
-		return outputs, segmentsCount, fmt.Errorf("error generating source segment URLs: %s", err)
+		return outputs, segmentsCount, fmt.Errorf("error generating source segment URLs: %w", err)
 	}
 	log.Log(transcodeRequest.RequestID, "Fetched Source Segments URLs", "num_urls", len(sourceSegmentURLs))
 
@@ -119,7 +158,8 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	var ignoreProbeErrs = []string{
 		"non-existing sps 0",
 	}
-	p := video.Probe{IgnoreErrMessages: ignoreProbeErrs}
+	// The outputs were just written, so there's no earlier probe of them to reuse
+	p := video.Probe{IgnoreErrMessages: ignoreProbeErrs, NoCache: true}
 	// ProbeFile will return err for various reasons so we use the subsequent GetTrack method to check for video tracks
 	lastSegmentProbe, _ := p.ProbeFile(transcodeRequest.RequestID, lastSegmentURL)
 	// GetTrack will return an err if TrackTypeVideo was not found
@@ -138,6 +178,23 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 		sourceSegmentURLs = sourceSegmentURLs[:len(sourceSegmentURLs)-1]
 	}
 
+	if transcodeRequest.Retranscode.PartialSegments() {
+		if err := checkRetranscodeSegments(transcodeRequest.RequestID, hlsTargetURL, transcodeProfiles, sourceManifest, len(sourceSegmentURLs)); err != nil {
+			return outputs, segmentsCount, err
+		}
+	}
+
+	if transcodeRequest.LadderCap != nil && transcodeRequest.LadderCap.VMAFTarget > 0 && len(sourceSegmentURLs) == 0 {
+		// e.g. when the only segment was audio-only and dropped above
+		log.Log(transcodeRequest.RequestID, "skipping the VMAF check of the ladder, no source segment to sample")
+	} else if transcodeRequest.LadderCap != nil && transcodeRequest.LadderCap.VMAFTarget > 0 {
+		sampleURL, err := clients.SignURL(sourceSegmentURLs[0].URL)
+		if err != nil {
+			return outputs, segmentsCount, fmt.Errorf("failed to create signed url for VMAF sample %s: %w", sourceSegmentURLs[0].URL.Redacted(), err)
+		}
+		transcodeProfiles = video.CheckLadderVMAF(ctx, transcodeRequest.RequestID, sampleURL, transcodeProfiles, transcodeRequest.LadderCap.VMAFTarget, video.FFmpegVMAF)
+	}
+
 	// Use RequestID as part of manifestID when talking to the Broadcaster
 	manifestID := "manifest-" + transcodeRequest.RequestID
 	// transcodedStats hold actual info from transcoded results within requested constraints (this usually differs from requested profiles)
@@ -176,10 +233,17 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	// Create a waitgroup to synchronize when the disk writing goroutine finishes
 	var wg sync.WaitGroup
 
+	// The ID3 timed metadata found in the source segments, carried into the renditions' segments and a sidecar
+	var timedMetadata video.TimedMetadataTrack
+
 	// Setup parallel transcode sessions
 	var jobs *ParallelTranscoding
 	jobs = NewParallelTranscoding(sourceSegmentURLs, func(segment segmentInfo) error {
-		err := transcodeSegment(segment, streamName, manifestID, transcodeRequest, transcodeProfiles, hlsTargetURL, transcodedStats, &renditionList, broadcaster, segmentChannel)
+		if !transcodeRequest.Retranscode.IncludesSegment(segment.Index) {
+			// the existing outputs of the segment are kept
+			return nil
+		}
+		err := transcodeSegment(ctx, segment, streamName, manifestID, transcodeRequest, transcodeProfiles, hlsTargetURL, transcodedStats, &renditionList, broadcaster, segmentChannel, &timedMetadata)
 		segmentsCount++
 		if err != nil {
 			return err
@@ -193,8 +257,13 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	})
 
 	var TransmuxStorageDir string
+	var scratchKey *video.ScratchKey
 	if transcodeRequest.GenerateMP4 {
 		var err error
+		scratchKey, err = video.NewScratchKey()
+		if err != nil {
+			return outputs, segmentsCount, err
+		}
 		// Create folder to hold transmux-ed files in local storage temporarily
 		TransmuxStorageDir, err = os.MkdirTemp(os.TempDir(), "transmux_stage_"+transcodeRequest.RequestID+"_")
 		if err != nil && !os.IsExist(err) {
@@ -214,7 +283,7 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 				segmentBatch = append(segmentBatch, segInfo)
 				// Begin writing to disk if at-least 50% of buffered channel is full
 				if len(segmentBatch) >= SegmentChannelSize/2 {
-					err := video.WriteSegmentsToDisk(transmuxTopLevelDir, renditionList, segmentBatch)
+					err := video.WriteSegmentsToDisk(transmuxTopLevelDir, renditionList, segmentBatch, scratchKey)
 					if err != nil {
 						return
 					}
@@ -223,7 +292,7 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 			}
 			// Handle any remaining segments after the channel is closed
 			if len(segmentBatch) > 0 {
-				err := video.WriteSegmentsToDisk(transmuxTopLevelDir, renditionList, segmentBatch)
+				err := video.WriteSegmentsToDisk(transmuxTopLevelDir, renditionList, segmentBatch, scratchKey)
 				if err != nil {
 					return
 				}
@@ -232,8 +301,18 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	}
 
 	// Start the transcoding (producer) goroutines
+	registerJob(transcodeRequest.RequestID, jobs)
 	jobs.Start()
-	if err = jobs.Wait(); err != nil {
+	// Stop handing out segments once the job's deadline passes, the ones in flight are aborted through ctx
+	stopJobs := context.AfterFunc(ctx, jobs.Stop)
+	err = jobs.Wait()
+	stopJobs()
+	unregisterJob(transcodeRequest.RequestID, jobs)
+	if err == nil {
+		// the workers exit without an error when stopped between segments
+		err = ctx.Err()
+	}
+	if err != nil {
 		// return first error to caller
 		return outputs, segmentsCount, err
 	}
@@ -244,11 +323,43 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	// Wait for disk-writing goroutine to finish. This will be a no-op if MP4s are not requested.
 	wg.Wait()
 
+	// The segments of every rendition were checked to start with a keyframe as they came in
+	if err := transcodeRequest.ConditionedOutput.CheckSegments(sourceManifest.Segments, transcodeRequest.SegmentBoundaries); err != nil {
+		return outputs, segmentsCount, err
+	}
+
+	if transcodeRequest.ReportStage != nil {
+		transcodeRequest.ReportStage("uploading")
+	}
+
 	// Build the manifests and push them to storage
-	manifestURL, err := clients.GenerateAndUploadManifests(sourceManifest, hlsTargetURL.String(), transcodedStats, transcodeRequest.IsClip)
+	var manifestURL string
+	cueSegments := transcodeRequest.ConditionedOutput.CueSegments(sourceManifest.Segments)
+	if transcodeRequest.Retranscode != nil {
+		manifestURL, err = clients.UploadRenditionManifests(ctx, sourceManifest, hlsTargetURL.String(), transcodedStats, transcodeRequest.IsClip, cueSegments)
+	} else {
+		manifestURL, err = clients.GenerateAndUploadManifests(ctx, sourceManifest, hlsTargetURL.String(), transcodedStats, transcodeRequest.IsClip, cueSegments, transcodeRequest.AudioLanguage)
+	}
 	if err != nil {
 		return outputs, segmentsCount, err
 	}
+	// Storages published once all the outputs are uploaded, i.e. web3.storage, aren't playable before then
+	if transcodeRequest.ReportPlayable != nil && transcodeRequest.HlsTargetURL != "" && hlsTargetURL.Scheme != "w3s" {
+		hlsPlaybackBaseURL, _, err := clients.Publish(hlsTargetURL.String(), "")
+		if err != nil {
+			return outputs, segmentsCount, err
+		}
+		transcodeRequest.ReportPlayable(strings.ReplaceAll(manifestURL, hlsTargetURL.String(), hlsPlaybackBaseURL))
+	}
+
+	// A partial retranscode doesn't have the timed metadata of the segments it reuses, so the existing sidecar is kept
+	var timedMetadataURL string
+	if sidecar := timedMetadata.Sidecar(sourceManifest.Segments); len(sidecar) > 0 && transcodeRequest.Retranscode == nil {
+		timedMetadataURL, err = uploadTimedMetadata(ctx, hlsTargetURL, sidecar)
+		if err != nil {
+			return outputs, segmentsCount, err
+		}
+	}
 
 	var mp4OutputsPre []video.OutputVideoFile
 	var fmp4ManifestUrls []string
@@ -268,9 +379,10 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 		}
 
 		var concatFiles []string
+		var standardMp4s []standardMp4Rendition
 		for rendition, segments := range renditionList.RenditionSegmentTable {
 			// Create a single .ts file for a given rendition by concatenating all segments in order
-			if rendition == "low-bitrate" {
+			if rendition == video.LowBitrateProfileName {
 				// skip mp4 generation for low-bitrate profile
 				continue
 			}
@@ -280,10 +392,12 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 
 			var totalBytes int64
 
-			if transcodeRequest.IsClip {
-				totalBytes, err = video.ConcatTS(concatTsFileName, segments, sourceManifest, true)
+			// The segments around the gaps of a repaired recording are joined like those of a clip, rebasing their
+			// timestamps so that the MP4 is trimmed around the gaps rather than stalling over them
+			if transcodeRequest.IsClip || hasDiscontinuity(sourceManifest) {
+				totalBytes, err = video.ConcatTS(concatTsFileName, segments, sourceManifest, true, transcodeRequest.TrackSelection, scratchKey)
 			} else {
-				totalBytes, err = video.ConcatTS(concatTsFileName, segments, sourceManifest, false)
+				totalBytes, err = video.ConcatTS(concatTsFileName, segments, sourceManifest, false, transcodeRequest.TrackSelection, scratchKey)
 			}
 			if err != nil {
 				log.Log(transcodeRequest.RequestID, "error concatenating .ts", "file", concatTsFileName, "err", err)
@@ -316,25 +430,33 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 				// Transmux the single .ts file into an mp4 file
 				mp4OutputFileName := concatTsFileName[:len(concatTsFileName)-len(filepath.Ext(concatTsFileName))] + ".mp4"
 				defer os.Remove(mp4OutputFileName)
-				standardMp4OutputFiles, err := video.MuxTStoMP4(concatTsFileName, mp4OutputFileName)
+				standardMp4OutputFiles, err := video.MuxTStoMP4(concatTsFileName, mp4OutputFileName, transcodeRequest.TrackSelection, scratchKey)
 				if err != nil {
 					log.Log(transcodeRequest.RequestID, "error transmuxing to regular mp4", "file", mp4OutputFileName, "err", err)
 					continue
 				}
 
-				// Add C2PA Signature
-				if transcodeRequest.C2PA != nil {
-					for _, f := range standardMp4OutputFiles {
-						if err := transcodeRequest.C2PA.SignFile(f, f, rendition, transcodeRequest.LocalSourceTmp); err != nil {
-							log.LogError(transcodeRequest.RequestID, "error signing C2PA manifest", err, "file", f)
-						}
-					}
+				standardMp4s = append(standardMp4s, standardMp4Rendition{rendition: rendition, files: standardMp4OutputFiles})
+			}
+		}
+
+		if enableStandardMp4 {
+			// Add C2PA Signature, to all the renditions at once rather than one after the other
+			var toSign []c2pa2.File
+			for _, mp4 := range standardMp4s {
+				for _, f := range mp4.files {
+					toSign = append(toSign, c2pa2.File{Path: f, Title: mp4.rendition})
 				}
+			}
+			if err := transcodeRequest.C2PA.SignFiles(ctx, c2pa2.KindMP4, toSign, transcodeRequest.LocalSourceTmp); err != nil {
+				log.LogError(transcodeRequest.RequestID, "error signing C2PA manifest", err)
+			}
 
-				// Upload the mp4 file
-				mp4Out, err := uploadMp4Files(mp4TargetUrlBase, standardMp4OutputFiles, rendition)
+			// Upload the mp4 files
+			for _, mp4 := range standardMp4s {
+				mp4Out, err := uploadMp4Files(ctx, mp4TargetUrlBase, mp4.files, mp4.rendition)
 				if err != nil {
-					return outputs, segmentsCount, fmt.Errorf("error uploading transmuxed standard mp4 file: %s", err)
+					return outputs, segmentsCount, fmt.Errorf("error uploading transmuxed standard mp4 file: %w", err)
 				}
 				mp4OutputsPre = append(mp4OutputsPre, mp4Out...)
 			}
@@ -343,10 +465,29 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 		if enableFragMp4 {
 			fmp4OutputDir := filepath.Join(TransmuxStorageDir, transcodeRequest.RequestID+"_fmp4")
 			fmp4ManifestOutputFile := filepath.Join(fmp4OutputDir, clients.DashManifestFilename)
-			err := video.MuxTStoFMP4(fmp4ManifestOutputFile, concatFiles...)
+			// Signed renditions need segment files of their own, the signatures changing the sizes of the fragments
+			err := video.MuxTStoFMP4(fmp4ManifestOutputFile, scratchKey, transcodeRequest.C2PA != nil, concatFiles...)
 			if err != nil {
 				return outputs, segmentsCount, fmt.Errorf("error transmuxing to fmp4: %w", err)
 			}
+			if transcodeRequest.C2PA != nil {
+				// The audio rendition is muxed first, followed by the video renditions
+				var toSign []c2pa2.Fragments
+				for i := 0; i <= len(concatFiles); i++ {
+					title := "audio"
+					if i > 0 {
+						title = strings.TrimSuffix(strings.TrimPrefix(filepath.Base(concatFiles[i-1]), transcodeRequest.RequestID+"_"), ".ts")
+					}
+					toSign = append(toSign, c2pa2.Fragments{
+						InitSegment: filepath.Join(fmp4OutputDir, fmt.Sprintf(video.FMP4InitSegmentPattern, i)),
+						Glob:        fmt.Sprintf(video.FMP4FragmentsPattern, i),
+						Title:       title,
+					})
+				}
+				if err := transcodeRequest.C2PA.SignFragments(ctx, toSign, transcodeRequest.LocalSourceTmp); err != nil {
+					log.LogError(transcodeRequest.RequestID, "error signing C2PA manifest of fragmented mp4", err)
+				}
+			}
 			// Upload the fragmented-mp4 file(s) and related manifests
 			fragMp4TargetBaseOutput := fragMp4TargetUrlBase.JoinPath(clients.Fmp4PostfixDir)
 			entries, err := os.ReadDir(fmp4OutputDir)
@@ -357,7 +498,7 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 			for _, entry := range entries {
 				files = append(files, filepath.Join(fmp4OutputDir, entry.Name()))
 			}
-			_, err = uploadMp4Files(fragMp4TargetBaseOutput, files, "")
+			_, err = uploadMp4Files(ctx, fragMp4TargetBaseOutput, files, "")
 			if err != nil {
 				return outputs, segmentsCount, fmt.Errorf("error uploading transmuxed fragmented mp4 file(s): %w", err)
 			}
@@ -401,7 +542,7 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 				}
 			}
 			// Populate OutputVideo structs with results from probing step to send back in final response to Studio
-			mp4Out, err = video.PopulateOutput(transcodeRequest.RequestID, video.Probe{}, probeURL, mp4Out)
+			mp4Out, err = video.PopulateOutput(transcodeRequest.RequestID, video.Probe{NoCache: true}, probeURL, mp4Out)
 			if err != nil {
 				return outputs, segmentsCount, fmt.Errorf("failed to populate output for %s: %w", log.RedactURL(probeURL), err)
 			}
@@ -427,6 +568,9 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 			videoManifestURL := strings.ReplaceAll(rendition.ManifestLocation, hlsTargetURL.String(), hlsPlaybackBaseURL)
 			output.Videos = append(output.Videos, video.OutputVideoFile{Location: videoManifestURL, SizeBytes: rendition.Bytes})
 		}
+		if timedMetadataURL != "" {
+			output.TimedMetadata = strings.ReplaceAll(timedMetadataURL, hlsTargetURL.String(), hlsPlaybackBaseURL)
+		}
 	}
 	output.MP4Outputs = mp4Outputs
 	outputs = []video.OutputVideo{output}
@@ -434,7 +578,13 @@ func RunTranscodeProcess(transcodeRequest TranscodeSegmentRequest, streamName st
 	return outputs, segmentsCount, nil
 }
 
-func uploadMp4Files(basePath *url.URL, mp4OutputFiles []string, prefix string) ([]video.OutputVideoFile, error) {
+// standardMp4Rendition is the mp4 files muxed for a rendition, signed and uploaded along with the others
+type standardMp4Rendition struct {
+	rendition string
+	files     []string
+}
+
+func uploadMp4Files(ctx context.Context, basePath *url.URL, mp4OutputFiles []string, prefix string) ([]video.OutputVideoFile, error) {
 	var mp4OutputsPre []video.OutputVideoFile
 	// e. Upload all mp4 related output files
 	for _, o := range mp4OutputFiles {
@@ -450,10 +600,10 @@ func uploadMp4Files(basePath *url.URL, mp4OutputFiles []string, prefix string) (
 			filename = filepath.Base(mp4OutputFile.Name())
 		}
 		err = backoff.Retry(func() error {
-			return clients.UploadToOSURL(basePath.String(), filename, bufio.NewReader(mp4OutputFile), UploadTimeout)
-		}, clients.UploadRetryBackoff())
+			return clients.UploadToOSURLFields(ctx, basePath.String(), filename, bufio.NewReader(mp4OutputFile), UploadTimeout, nil)
+		}, backoff.WithContext(clients.UploadRetryBackoff(), ctx))
 		if err != nil {
-			return []video.OutputVideoFile{}, fmt.Errorf("failed to upload %s: %s", mp4OutputFile.Name(), err)
+			return []video.OutputVideoFile{}, fmt.Errorf("failed to upload %s: %w", mp4OutputFile.Name(), err)
 		}
 
 		mp4Out := video.OutputVideoFile{
@@ -500,6 +650,7 @@ func getHlsTargetURL(tsr TranscodeSegmentRequest) (*url.URL, error) {
 }
 
 func transcodeSegment(
+	ctx context.Context,
 	segment segmentInfo, streamName, manifestID string,
 	transcodeRequest TranscodeSegmentRequest,
 	encodedProfiles []video.EncodedProfile,
@@ -508,6 +659,7 @@ func transcodeSegment(
 	renditionList *video.TRenditionList,
 	broadcaster clients.BroadcasterClient,
 	segmentChannel chan<- video.TranscodedSegmentInfo,
+	timedMetadata *video.TimedMetadataTrack,
 ) error {
 	start := time.Now()
 
@@ -533,23 +685,27 @@ func transcodeSegment(
 
 	var tr clients.TranscodeResult
 	var sourceSegment *bytes.Buffer
+	var metadataExtractor *video.TimedMetadataExtractor
 	err := backoff.Retry(func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), clients.MaxCopyFileDuration)
+		ctx, cancel := context.WithTimeout(ctx, clients.MaxCopyFileDuration)
 		defer cancel()
-		rc, err := clients.GetFile(ctx, transcodeRequest.RequestID, segment.Input.URL.String(), nil)
+		rc, err := clients.GetFileRange(ctx, transcodeRequest.RequestID, segment.Input.URL.String(), nil, segment.Input.Offset, segment.Input.Length)
 		if err != nil {
 			return fmt.Errorf("failed to download source segment %q: %w", segment.Input, err)
 		}
 		defer rc.Close()
 
+		// Look for the ID3 timed metadata as the segment is read, since the transcoder drops it
+		metadataExtractor = &video.TimedMetadataExtractor{}
 		var r io.Reader
-		r, sourceSegment, err = withPipedSource(rc, copySource, transcodeProfiles)
+		r, sourceSegment, err = withPipedSource(io.TeeReader(rc, metadataExtractor), copySource, transcodeProfiles)
 		if err != nil {
 			return err
 		} else if r == nil {
 			// In this case the pipe already consumed the input (no transcode needed), so source segment is already copied. Just return.
 			return nil
 		}
+		r = clients.CountBroadcasterTransfer(ctx, r)
 
 		// If an AccessToken is provided via the request for transcode, then use remote Broadcasters.
 		// Otherwise, use the local harcoded Broadcaster.
@@ -560,9 +716,9 @@ func transcodeSegment(
 			}
 			broadcasterClient, _ := clients.NewRemoteBroadcasterClient(creds)
 			// TODO: failed to run TranscodeSegmentWithRemoteBroadcaster: CreateStream(): http POST(https://origin.livepeer.com/api/stream) returned 422 422 Unprocessable Entity
-			tr, err = broadcasterClient.TranscodeSegmentWithRemoteBroadcaster(r, int64(segment.Index), transcodeProfiles, streamName, segment.Input.DurationMillis)
+			tr, err = broadcasterClient.TranscodeSegmentWithRemoteBroadcaster(log.WithRequestID(ctx, transcodeRequest.RequestID), r, int64(segment.Index), transcodeProfiles, streamName, segment.Input.DurationMillis)
 			if err != nil {
-				return fmt.Errorf("failed to run TranscodeSegmentWithRemoteBroadcaster: %s", err)
+				return fmt.Errorf("failed to run TranscodeSegmentWithRemoteBroadcaster: %w", err)
 			}
 		} else {
 			transcodeConf := clients.LivepeerTranscodeConfiguration{
@@ -582,22 +738,26 @@ func transcodeSegment(
 			} else {
 				transcodeConf.ForceSessionReinit = false
 			}
-			tr, err = broadcaster.TranscodeSegment(r, int64(segment.Index), segment.Input.DurationMillis, manifestID, transcodeConf)
+			tr, err = broadcaster.TranscodeSegment(log.WithRequestID(ctx, transcodeRequest.RequestID), r, int64(segment.Index), segment.Input.DurationMillis, manifestID, transcodeConf)
 			if err != nil {
-				return fmt.Errorf("failed to run TranscodeSegment: %s", err)
+				return fmt.Errorf("failed to run TranscodeSegment: %w", err)
 			}
 		}
 		return nil
-	}, TranscodeRetryBackoff())
+	}, backoff.WithContext(TranscodeRetryBackoff(), ctx))
 
 	if err != nil {
 		return err
 	}
+	clients.CountBroadcasterResult(ctx, tr)
 
 	duration := time.Since(start)
 	metrics.Metrics.TranscodeSegmentDurationSec.Observe(duration.Seconds())
 
-	err = processTranscodeResult(segment, transcodeRequest, sourceSegment, tr, encodedProfiles, targetOSURL, transcodedStats, renditionList, segmentChannel)
+	segment.TimedMetadata = metadataExtractor.Metadata()
+	timedMetadata.Add(segment.Index, segment.TimedMetadata)
+
+	err = processTranscodeResult(ctx, segment, transcodeRequest, sourceSegment, tr, encodedProfiles, targetOSURL, transcodedStats, renditionList, segmentChannel)
 	if err != nil {
 		return fmt.Errorf("failed to process transcode result: %w", err)
 	}
@@ -619,7 +779,7 @@ func withPipedSource(in io.Reader, copySource bool, transcodeProfiles []video.En
 		// This is a copy-only job, so skip transcoding with the broadcaster
 		_, err := io.Copy(source, in)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to copy source segment: %s", err)
+			return nil, nil, fmt.Errorf("failed to copy source segment: %w", err)
 		}
 		return nil, source, nil
 	}
@@ -629,6 +789,7 @@ func withPipedSource(in io.Reader, copySource bool, transcodeProfiles []video.En
 }
 
 func processTranscodeResult(
+	ctx context.Context,
 	segment segmentInfo,
 	transcodeRequest TranscodeSegmentRequest,
 	sourceSegment *bytes.Buffer,
@@ -639,6 +800,8 @@ func processTranscodeResult(
 	renditionList *video.TRenditionList,
 	segmentChannel chan<- video.TranscodedSegmentInfo) error {
 
+	renditionsData := make([][]byte, len(encodedProfiles))
+	renditionURLs := make([]string, len(encodedProfiles))
 	for renditionIndex, profile := range encodedProfiles {
 		var mediaData []byte
 		if profile.Copy {
@@ -654,6 +817,17 @@ func processTranscodeResult(
 		if mediaData == nil {
 			return fmt.Errorf("failed to find rendition with name %q while parsing transcode result", profile.Name)
 		}
+		if !profile.Copy && len(segment.TimedMetadata) > 0 {
+			withMetadata, err := video.InjectTimedMetadata(mediaData, segment.TimedMetadata)
+			if err != nil {
+				log.LogError(transcodeRequest.RequestID, "failed to carry timed metadata into rendition segment", err, "segment", segment.Index, "rendition", profile.Name)
+			} else {
+				mediaData = withMetadata
+			}
+		}
+		if transcodeRequest.ConditionedOutput != nil && !video.StartsWithKeyframe(mediaData) {
+			return fmt.Errorf("segment %d of rendition %s doesn't start with a keyframe, as conditioned outputs must", segment.Index, profile.Name)
+		}
 
 		targetRenditionURL, err := url.JoinPath(targetOSURL.String(), profile.Name)
 		if err != nil {
@@ -681,14 +855,33 @@ func processTranscodeResult(
 			}
 		}
 
-		err = backoff.Retry(func() error {
-			return clients.UploadToOSURL(targetRenditionURL, fmt.Sprintf("%d.ts", segment.Index), bytes.NewReader(mediaData), UploadTimeout)
-		}, clients.UploadRetryBackoff())
-		if err != nil {
-			return fmt.Errorf("failed to upload segment %d of profile %s: %w", segment.Index, profile.Name, err)
-		}
+		renditionsData[renditionIndex] = mediaData
+		renditionURLs[renditionIndex] = targetRenditionURL
+	}
 
-		// bitrate calculation
+	// Upload the renditions in parallel, each with its own retries, and report all the ones that failed
+	uploads := errgroup.Group{}
+	uploads.SetLimit(RenditionUploadWorkers)
+	uploadErrs := make([]error, len(encodedProfiles))
+	for renditionIndex, profile := range encodedProfiles {
+		renditionIndex, profile := renditionIndex, profile
+		uploads.Go(func() error {
+			err := backoff.Retry(func() error {
+				return clients.UploadToOSURLFields(ctx, renditionURLs[renditionIndex], fmt.Sprintf("%d.ts", segment.Index), bytes.NewReader(renditionsData[renditionIndex]), UploadTimeout, nil)
+			}, backoff.WithContext(clients.UploadRetryBackoff(), ctx))
+			if err != nil {
+				uploadErrs[renditionIndex] = fmt.Errorf("failed to upload segment %d of profile %s: %w", segment.Index, profile.Name, err)
+			}
+			return nil
+		})
+	}
+	_ = uploads.Wait()
+	if err := errors.Join(uploadErrs...); err != nil {
+		return err
+	}
+
+	// bitrate calculation
+	for renditionIndex, mediaData := range renditionsData {
 		transcodedStats[renditionIndex].Bytes += int64(len(mediaData))
 		transcodedStats[renditionIndex].DurationMs += float64(segment.Input.DurationMillis)
 	}
@@ -726,6 +919,8 @@ type segmentInfo struct {
 	Input         clients.SourceSegment
 	Index         int
 	IsLastSegment bool
+	// The ID3 timed metadata found in the source segment, to carry into the transcoded ones
+	TimedMetadata []video.TimedMetadata
 }
 
 func statsFromProfiles(profiles []video.EncodedProfile) []*video.RenditionStats {
@@ -774,6 +969,53 @@ func HandleAVStartTimeOffsets(requestID string, iv video.InputVideo, segments []
 	return segments, segmentURLs
 }
 
+// checkRetranscodeSegments checks that the segments transcoded again line up with the existing outputs of the
+// renditions, i.e. that the source was segmented with the same target duration into the same number of segments.
+// Otherwise the new segments would be mixed up with the existing ones.
+func checkRetranscodeSegments(requestID string, hlsTargetURL *url.URL, profiles []video.EncodedProfile, sourceManifest m3u8.MediaPlaylist, segmentCount int) error {
+	for _, profile := range profiles {
+		existingURL := hlsTargetURL.JoinPath(profile.Name, "index.m3u8")
+		existing, err := clients.DownloadRenditionManifest(requestID, existingURL.String())
+		if err != nil {
+			return fmt.Errorf("failed to download the existing %s playlist to re-transcode: %w", profile.Name, err)
+		}
+		if existing.TargetDuration != sourceManifest.TargetDuration {
+			return fmt.Errorf("the target duration of the existing %s playlist is %v, the source segments' is %v", profile.Name, existing.TargetDuration, sourceManifest.TargetDuration)
+		}
+		if existingCount := len(existing.GetAllSegments()); existingCount != segmentCount {
+			return fmt.Errorf("the existing %s playlist has %d segments, the source has %d", profile.Name, existingCount, segmentCount)
+		}
+	}
+	return nil
+}
+
 func TranscodeRetryBackoff() backoff.BackOff {
 	return backoff.WithMaxRetries(backoff.NewConstantBackOff(5*time.Second), 10)
 }
+
+// uploadTimedMetadata uploads the timed metadata of the video as a JSON sidecar next to the HLS manifest, for players of
+// outputs that can't carry it such as the MP4s
+func uploadTimedMetadata(ctx context.Context, hlsTargetURL *url.URL, metadata []video.TimedMetadata) (string, error) {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal timed metadata: %w", err)
+	}
+	err = backoff.Retry(func() error {
+		return clients.UploadToOSURLFields(ctx, hlsTargetURL.String(), TimedMetadataFilename, bytes.NewReader(b), UploadTimeout, nil)
+	}, backoff.WithContext(clients.UploadRetryBackoff(), ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to upload timed metadata: %w", err)
+	}
+	return hlsTargetURL.JoinPath(TimedMetadataFilename).String(), nil
+}
+
+// hasDiscontinuity returns whether the source has gaps, e.g. it's a recording repaired around missing segments, i.e.
+// an EXT-X-DISCONTINUITY tag between two of its segments. A tag before the first segment doesn't join anything.
+func hasDiscontinuity(sourceManifest m3u8.MediaPlaylist) bool {
+	for i, segment := range sourceManifest.GetAllSegments() {
+		if i > 0 && segment.Discontinuity {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/livepeer/catalyst-api/clients"
+	"github.com/livepeer/catalyst-api/concurrency"
 	"github.com/livepeer/catalyst-api/config"
 )
 
@@ -18,6 +19,10 @@ type ParallelTranscoding struct {
 	isRunning         bool
 	totalSegments     int
 	completedSegments int
+	priority          int
+	activeWorkers     int
+	// Workers added on top of the ones the job started with, up to config.TranscodingMaxExtraWorkers
+	extraWorkers int
 }
 
 func NewParallelTranscoding(sourceSegmentURLs []clients.SourceSegment, work func(segment segmentInfo) error) *ParallelTranscoding {
@@ -43,8 +48,12 @@ func NewParallelTranscoding(sourceSegmentURLs []clients.SourceSegment, work func
 
 // Start spawns configured number of goroutines to process segments in parallel
 func (t *ParallelTranscoding) Start() {
-	t.completed.Add(config.TranscodingParallelJobs)
-	for index := 0; index < config.TranscodingParallelJobs; index++ {
+	workers := concurrency.Tuner.ParallelTranscodeJobs()
+	t.m.Lock()
+	t.activeWorkers += workers
+	t.completed.Add(workers)
+	t.m.Unlock()
+	for index := 0; index < workers; index++ {
 		go t.workerRoutine()
 		// Add a sleep after the first transcoding goroutine starts, to avoid the situation where 2 segments
 		// hit the Broadcaster at once and get routed to different Os, then immediately switch away from
@@ -53,6 +62,38 @@ func (t *ParallelTranscoding) Start() {
 	}
 }
 
+// AddWorkers spawns additional goroutines to process the remaining segments, up to config.TranscodingMaxExtraWorkers
+// over the life of the job, and returns how many were added. This is a no-op once all of the workers have exited,
+// since there is nothing left to process then.
+func (t *ParallelTranscoding) AddWorkers(n int) int {
+	t.m.Lock()
+	defer t.m.Unlock()
+	n = min(n, config.TranscodingMaxExtraWorkers-t.extraWorkers)
+	if n <= 0 || !t.isRunning || t.activeWorkers == 0 {
+		return 0
+	}
+	// Safe to Add while Wait() is in progress since the counter is held above zero by the active workers
+	t.extraWorkers += n
+	t.activeWorkers += n
+	t.completed.Add(n)
+	for i := 0; i < n; i++ {
+		go t.workerRoutine()
+	}
+	return n
+}
+
+func (t *ParallelTranscoding) SetPriority(priority int) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.priority = priority
+}
+
+func (t *ParallelTranscoding) Priority() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.priority
+}
+
 func (t *ParallelTranscoding) Stop() {
 	t.m.Lock()
 	defer t.m.Unlock()
@@ -96,13 +137,22 @@ func (t *ParallelTranscoding) segmentCompleted() {
 	t.completedSegments += 1
 }
 
+func (t *ParallelTranscoding) workerExited() {
+	t.m.Lock()
+	t.activeWorkers--
+	t.m.Unlock()
+	t.completed.Done()
+}
+
 func (t *ParallelTranscoding) workerRoutine() {
-	defer t.completed.Done()
+	defer t.workerExited()
 	for segment := range t.queue {
 		if !t.IsRunning() {
 			return
 		}
+		release := scheduler.acquire(t)
 		err := t.work(segment)
+		release()
 		if err != nil {
 			// stop all other goroutines on first error
 			t.Stop()
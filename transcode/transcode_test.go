@@ -2,6 +2,7 @@ package transcode
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -46,7 +47,7 @@ type StubBroadcasterClient struct {
 	tr clients.TranscodeResult
 }
 
-func (c StubBroadcasterClient) TranscodeSegment(segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf clients.LivepeerTranscodeConfiguration) (clients.TranscodeResult, error) {
+func (c StubBroadcasterClient) TranscodeSegment(ctx context.Context, segment io.Reader, sequenceNumber int64, durationMillis int64, manifestID string, conf clients.LivepeerTranscodeConfiguration) (clients.TranscodeResult, error) {
 	return c.tr, nil
 }
 
@@ -134,6 +135,7 @@ func TestItCanTranscode(t *testing.T) {
 	statusClient := clients.NewPeriodicCallbackClient(100*time.Minute, map[string]string{})
 	// Check we don't get an error downloading or parsing it
 	outputs, segmentsCount, err := RunTranscodeProcess(
+		context.Background(),
 		TranscodeSegmentRequest{
 			CallbackURL:       callbackServer.URL,
 			SourceManifestURL: manifestFile.Name(),
@@ -406,6 +408,7 @@ func TestProcessTranscodeResult(t *testing.T) {
 			}
 			segmentChannel := make(chan video.TranscodedSegmentInfo, 100)
 			err = processTranscodeResult(
+				context.Background(),
 				tt.segment,
 				tt.transcodeRequest,
 				tt.sourceSegment,
@@ -451,6 +454,80 @@ func TestProcessTranscodeResult(t *testing.T) {
 	}
 }
 
+func TestProcessTranscodeResultReportsEveryFailedUpload(t *testing.T) {
+	dir := t.TempDir()
+	// files in place of the rendition directories make their uploads fail
+	for _, name := range []string{"profile1", "profile2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte{}, 0644))
+	}
+	encodedProfiles := []video.EncodedProfile{
+		{Name: "profile0", Width: 1920, Height: 1080, Bitrate: 5_000_000},
+		{Name: "profile1", Width: 1280, Height: 720, Bitrate: 3_000_000},
+		{Name: "profile2", Width: 640, Height: 420, Bitrate: 1_000_000},
+	}
+	err := processTranscodeResult(
+		context.Background(),
+		segmentInfo{Index: 0, Input: clients.SourceSegment{DurationMillis: 4000}},
+		TranscodeSegmentRequest{RequestID: "request-id"},
+		bytes.NewBuffer([]byte("source data")),
+		clients.TranscodeResult{
+			Renditions: []*clients.RenditionSegment{
+				{Name: "profile0", MediaData: []byte("media data")},
+				{Name: "profile1", MediaData: []byte("media data")},
+				{Name: "profile2", MediaData: []byte("media data")},
+			},
+		},
+		encodedProfiles,
+		&url.URL{Scheme: "file", Path: dir},
+		statsFromProfiles(encodedProfiles),
+		&video.TRenditionList{RenditionSegmentTable: make(map[string]*video.TSegmentList)},
+		make(chan video.TranscodedSegmentInfo, 100),
+	)
+	require.ErrorContains(t, err, "profile1/0.ts")
+	require.ErrorContains(t, err, "profile2/0.ts")
+
+	_, err = os.Stat(filepath.Join(dir, "profile0", "0.ts"))
+	require.NoError(t, err, "the successful rendition should still be uploaded")
+}
+
+func TestProcessTranscodeResultCarriesTimedMetadata(t *testing.T) {
+	dir := t.TempDir()
+	source, err := os.ReadFile("../test/fixtures/seg-0.ts")
+	require.NoError(t, err)
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+	encodedProfiles := []video.EncodedProfile{
+		{Name: "source", Copy: true},
+		{Name: "profile0", Width: 1280, Height: 720, Bitrate: 3_000_000},
+	}
+	err = processTranscodeResult(
+		context.Background(),
+		segmentInfo{Index: 0, Input: clients.SourceSegment{DurationMillis: 4000}, TimedMetadata: []video.TimedMetadata{{Time: 1, ID3: tag}}},
+		TranscodeSegmentRequest{RequestID: "request-id"},
+		bytes.NewBuffer(source),
+		clients.TranscodeResult{
+			Renditions: []*clients.RenditionSegment{{Name: "profile0", MediaData: source}},
+		},
+		encodedProfiles,
+		&url.URL{Scheme: "file", Path: dir},
+		statsFromProfiles(encodedProfiles),
+		&video.TRenditionList{RenditionSegmentTable: make(map[string]*video.TSegmentList)},
+		make(chan video.TranscodedSegmentInfo, 100),
+	)
+	require.NoError(t, err)
+
+	rendition, err := os.ReadFile(filepath.Join(dir, "profile0", "0.ts"))
+	require.NoError(t, err)
+	metadata := video.ExtractTimedMetadata(rendition)
+	require.Len(t, metadata, 1)
+	require.InDelta(t, 1, metadata[0].Time, 0.001)
+	require.Equal(t, tag, metadata[0].ID3)
+
+	// the source copy is uploaded as it was, with the metadata it already has
+	copied, err := os.ReadFile(filepath.Join(dir, "source", "0.ts"))
+	require.NoError(t, err)
+	require.Equal(t, len(source), len(copied))
+}
+
 func TestItCalculatesTheTranscodeCompletionPercentageCorrectly(t *testing.T) {
 	require.Equal(t, 0.5, calculateCompletedRatio(2, 1))
 	require.Equal(t, 0.5, calculateCompletedRatio(4, 2))
@@ -523,6 +600,76 @@ func TestParallelJobSaveTime(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)              // wait for other workers to exit
 }
 
+func TestBumpedJobIsScheduledFirst(t *testing.T) {
+	config.TranscodingParallelJobs = 1
+	config.TranscodingParallelSleep = 0
+	config.TranscodingSegmentSlots = 1
+	defer func() { config.TranscodingSegmentSlots = 0 }()
+
+	segments := []clients.SourceSegment{
+		{URL: segmentURL(t, "1.ts"), DurationMillis: 1000}, {URL: segmentURL(t, "2.ts"), DurationMillis: 1000}, {URL: segmentURL(t, "3.ts"), DurationMillis: 1000},
+	}
+	m := sync.Mutex{}
+	var order []string
+	work := func(name string) func(segment segmentInfo) error {
+		return func(segment segmentInfo) error {
+			m.Lock()
+			order = append(order, fmt.Sprintf("%s-%d", name, segment.Index))
+			m.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}
+	}
+
+	background := NewParallelTranscoding(segments, work("background"))
+	visible := NewParallelTranscoding(segments, work("visible"))
+	registerJob("visible", visible)
+	defer unregisterJob("visible", visible)
+
+	background.Start()
+	time.Sleep(5 * time.Millisecond) // background job holds the only slot
+	visible.Start()
+	added, err := BumpPriority("visible", PriorityHigh, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, added)
+	require.NoError(t, visible.Wait())
+	require.NoError(t, background.Wait())
+
+	// Apart from the segment that was already running, all of the visible job's segments go first
+	require.Equal(t, []string{"background-0", "visible-0", "visible-1", "visible-2", "background-1", "background-2"}, order)
+	_, err = BumpPriority("unknown", PriorityHigh, 1)
+	require.Error(t, err)
+}
+
+func TestAddWorkers(t *testing.T) {
+	config.TranscodingParallelJobs = 1
+	config.TranscodingParallelSleep = 0
+	config.TranscodingMaxExtraWorkers = 3
+	defer func() { config.TranscodingMaxExtraWorkers = 8 }()
+	sourceSegmentURLs := []clients.SourceSegment{
+		{URL: segmentURL(t, "1.ts"), DurationMillis: 1000}, {URL: segmentURL(t, "2.ts"), DurationMillis: 1000}, {URL: segmentURL(t, "3.ts"), DurationMillis: 1000},
+		{URL: segmentURL(t, "4.ts"), DurationMillis: 1000},
+	}
+	start := time.Now()
+	jobs := NewParallelTranscoding(sourceSegmentURLs, func(segment segmentInfo) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	jobs.Start()
+	require.Equal(t, 2, jobs.AddWorkers(2))
+	// Up to the maximum across all of the additions
+	require.Equal(t, 1, jobs.AddWorkers(5))
+	require.Equal(t, 0, jobs.AddWorkers(1))
+	require.NoError(t, jobs.Wait())
+	require.Less(t, time.Since(start), 150*time.Millisecond) // ~200ms with a single worker
+	require.Equal(t, 4, jobs.GetCompletedCount())
+
+	// No-op once the job is done
+	config.TranscodingMaxExtraWorkers = 8
+	require.Equal(t, 0, jobs.AddWorkers(1))
+	require.NoError(t, jobs.Wait())
+}
+
 func TestNewParallelTranscoding(t *testing.T) {
 	sourceSegmentURLs := []clients.SourceSegment{
 		{URL: segmentURL(t, "1.ts"), DurationMillis: 1000}, {URL: segmentURL(t, "2.ts"), DurationMillis: 1000}, {URL: segmentURL(t, "3.ts"), DurationMillis: 1000},
@@ -693,6 +840,22 @@ func TestHandleAVStartTimeOffsetsWhenSingleSegment(t *testing.T) {
 	}
 }
 
+func TestHasDiscontinuity(t *testing.T) {
+	decode := func(manifest string) m3u8.MediaPlaylist {
+		playlist, _, err := m3u8.DecodeFrom(strings.NewReader(manifest), true)
+		require.NoError(t, err)
+		return *playlist.(*m3u8.MediaPlaylist)
+	}
+	const header = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:0\n"
+
+	// A plain HLS source keeps the MP4 concat path of the non-clipped sources
+	require.False(t, hasDiscontinuity(decode(header+"#EXTINF:10.000,\n0.ts\n#EXTINF:10.000,\n1.ts\n#EXTINF:4.000,\n2.ts\n#EXT-X-ENDLIST\n")))
+	require.False(t, hasDiscontinuity(decode(header+"#EXT-X-DISCONTINUITY-SEQUENCE:3\n#EXTINF:10.000,\n0.ts\n#EXTINF:4.000,\n1.ts\n#EXT-X-ENDLIST\n")))
+	require.False(t, hasDiscontinuity(decode(header+"#EXT-X-DISCONTINUITY\n#EXTINF:10.000,\n0.ts\n#EXTINF:4.000,\n1.ts\n#EXT-X-ENDLIST\n")))
+
+	require.True(t, hasDiscontinuity(decode(header+"#EXTINF:10.000,\n0.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:4.000,\n2.ts\n#EXT-X-ENDLIST\n")))
+}
+
 func TestWithPipedSource(t *testing.T) {
 	dummyProfiles := []video.EncodedProfile{{Name: "dummy"}}
 
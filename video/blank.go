@@ -0,0 +1,148 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/catalyst-api/log"
+)
+
+const (
+	// Shortest stretch of black frames or silence reported when the request doesn't set one
+	DefaultBlankMinDurationSecs = 2.0
+	// Ratio of dark pixels above which a frame is black, and the level below which audio is silent
+	blackPixelThreshold = 0.10
+	silenceNoiseLevel   = "-60dB"
+	// How much of the ends of a source may not be black or silent for the whole of it to be, e.g. a frame or two
+	// decoded before the filters kick in
+	blankToleranceSecs = 1.0
+)
+
+// BlankDetection looks for long stretches of black frames or silence in the source, e.g. broken uploads or a
+// recording of a stream that never had its camera on, with ffmpeg's blackdetect and silencedetect filters
+type BlankDetection struct {
+	// Shortest stretch reported, in seconds. DefaultBlankMinDurationSecs when unset.
+	MinDurationSecs float64 `json:"min_duration_secs,omitempty"`
+	// Fails the job when the whole of the video is black or the whole of the audio is silent
+	FailIfBlank bool `json:"fail_if_blank,omitempty"`
+}
+
+func (b *BlankDetection) Validate() error {
+	if b == nil {
+		return nil
+	}
+	if b.MinDurationSecs < 0 {
+		return fmt.Errorf("invalid blank detection min_duration_secs %v, must be positive", b.MinDurationSecs)
+	}
+	return nil
+}
+
+// BlankInterval is a stretch of the source, in seconds from its start
+type BlankInterval struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// BlankReport lists the stretches of black frames and silence found in a source
+type BlankReport struct {
+	Black     []BlankInterval `json:"black,omitempty"`
+	Silence   []BlankInterval `json:"silence,omitempty"`
+	AllBlack  bool            `json:"all_black,omitempty"`
+	AllSilent bool            `json:"all_silent,omitempty"`
+}
+
+// IsBlank returns whether the whole of the video is black or the whole of the audio is silent
+func (r *BlankReport) IsBlank() bool {
+	return r != nil && (r.AllBlack || r.AllSilent)
+}
+
+// DetectBlank decodes the whole source looking for black frames in its video track and silence in its audio track
+func DetectBlank(ctx context.Context, requestID, sourceURL string, iv InputVideo, opts BlankDetection) (*BlankReport, error) {
+	minDuration := opts.MinDurationSecs
+	if minDuration <= 0 {
+		minDuration = DefaultBlankMinDurationSecs
+	}
+	_, videoErr := iv.GetTrack(TrackTypeVideo)
+	_, audioErr := iv.GetTrack(TrackTypeAudio)
+	if videoErr != nil && audioErr != nil {
+		return &BlankReport{}, nil
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-i", sourceURL}
+	if videoErr == nil {
+		args = append(args, "-vf", fmt.Sprintf("blackdetect=d=%g:pix_th=%g", minDuration, blackPixelThreshold))
+	} else {
+		args = append(args, "-vn")
+	}
+	if audioErr == nil {
+		args = append(args, "-af", fmt.Sprintf("silencedetect=n=%s:d=%g", silenceNoiseLevel, minDuration))
+	} else {
+		args = append(args, "-an")
+	}
+	args = append(args, "-f", "null", "-")
+
+	stdErr, err := runFFmpeg(ctx, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect black frames and silence: %w", err)
+	}
+	duration := iv.Duration
+	if videoTrack, err := iv.GetTrack(TrackTypeVideo); duration <= 0 && err == nil {
+		duration = videoTrack.DurationSec
+	}
+	report := parseBlankReport(stdErr, duration)
+	// A source without audio isn't silent, nor is one without video black
+	report.AllBlack = report.AllBlack && videoErr == nil
+	report.AllSilent = report.AllSilent && audioErr == nil
+	log.Log(requestID, "detected black frames and silence", "black", len(report.Black), "silence", len(report.Silence), "all_black", report.AllBlack, "all_silent", report.AllSilent)
+	return report, nil
+}
+
+var (
+	blackDetectRegex  = regexp.MustCompile(`black_start:\s*([0-9.]+)\s+black_end:\s*([0-9.]+)`)
+	silenceStartRegex = regexp.MustCompile(`silence_start:\s*(-?[0-9.]+)`)
+	silenceEndRegex   = regexp.MustCompile(`silence_end:\s*([0-9.]+)`)
+)
+
+// parseBlankReport reads the intervals logged by the blackdetect and silencedetect filters. Silence lasting until the
+// end of the source may not be closed in the log, so it's closed at the source's duration.
+func parseBlankReport(ffmpegOutput string, durationSecs float64) *BlankReport {
+	report := &BlankReport{}
+	for _, match := range blackDetectRegex.FindAllStringSubmatch(ffmpegOutput, -1) {
+		start, _ := strconv.ParseFloat(match[1], 64)
+		end, _ := strconv.ParseFloat(match[2], 64)
+		report.Black = append(report.Black, BlankInterval{Start: start, End: end})
+	}
+
+	silenceStart := -1.0
+	for _, line := range strings.Split(ffmpegOutput, "\n") {
+		if match := silenceStartRegex.FindStringSubmatch(line); match != nil {
+			silenceStart, _ = strconv.ParseFloat(match[1], 64)
+			silenceStart = max(silenceStart, 0)
+		} else if match := silenceEndRegex.FindStringSubmatch(line); match != nil && silenceStart >= 0 {
+			end, _ := strconv.ParseFloat(match[1], 64)
+			report.Silence = append(report.Silence, BlankInterval{Start: silenceStart, End: end})
+			silenceStart = -1
+		}
+	}
+	if silenceStart >= 0 && durationSecs > silenceStart {
+		report.Silence = append(report.Silence, BlankInterval{Start: silenceStart, End: durationSecs})
+	}
+
+	report.AllBlack = coversDuration(report.Black, durationSecs)
+	report.AllSilent = coversDuration(report.Silence, durationSecs)
+	return report
+}
+
+func coversDuration(intervals []BlankInterval, durationSecs float64) bool {
+	if durationSecs <= 0 {
+		return false
+	}
+	var covered float64
+	for _, interval := range intervals {
+		covered += interval.End - interval.Start
+	}
+	return covered >= durationSecs-blankToleranceSecs
+}
@@ -0,0 +1,41 @@
+package video
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const blankDetectOutput = `Input #0, mpegts, from 'in.ts':
+  Duration: 00:00:30.00, start: 1.400000, bitrate: 1000 kb/s
+[silencedetect @ 0x5581] silence_start: -0.0213
+[silencedetect @ 0x5581] silence_end: 4.5 | silence_duration: 4.5213
+[blackdetect @ 0x5580] black_start:10 black_end:12.52 black_duration:2.52
+[silencedetect @ 0x5581] silence_start: 20.1
+[blackdetect @ 0x5580] black_start:25.04 black_end:30 black_duration:4.96
+video:0kB audio:0kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown
+`
+
+func TestParseBlankReport(t *testing.T) {
+	report := parseBlankReport(blankDetectOutput, 30)
+	require.Equal(t, []BlankInterval{{Start: 10, End: 12.52}, {Start: 25.04, End: 30}}, report.Black)
+	// The silence until the end isn't closed in the log
+	require.Equal(t, []BlankInterval{{Start: 0, End: 4.5}, {Start: 20.1, End: 30}}, report.Silence)
+	require.False(t, report.IsBlank())
+
+	report = parseBlankReport("[blackdetect @ 0x5580] black_start:0 black_end:29.5 black_duration:29.5\n[silencedetect @ 0x5581] silence_start: 0\n", 30)
+	require.True(t, report.AllBlack)
+	require.True(t, report.AllSilent)
+	require.True(t, report.IsBlank())
+
+	// Nothing can be told of sources of unknown duration
+	report = parseBlankReport("[blackdetect @ 0x5580] black_start:0 black_end:29.5 black_duration:29.5\n", 0)
+	require.False(t, report.IsBlank())
+}
+
+func TestBlankDetectionValidate(t *testing.T) {
+	var unset *BlankDetection
+	require.NoError(t, unset.Validate())
+	require.NoError(t, (&BlankDetection{MinDurationSecs: 0.5, FailIfBlank: true}).Validate())
+	require.Error(t, (&BlankDetection{MinDurationSecs: -1}).Validate())
+}
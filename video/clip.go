@@ -2,10 +2,10 @@ package video
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"math"
 	"os/exec"
+	"sort"
 	"time"
 
 	"github.com/grafov/m3u8"
@@ -17,6 +17,8 @@ type ClipStrategy struct {
 	StartTime  int64  `json:"start_time,omitempty"`
 	EndTime    int64  `json:"end_time,omitempty"`
 	PlaybackID string `json:"playback_id,omitempty"` // playback-id of asset to clip
+	// Unix time (milliseconds) the recording session started at, used to clip recordings without PROGRAM-DATE-TIME
+	SessionStartTime int64 `json:"session_start_time,omitempty"`
 }
 
 type ClipSegmentInfo struct {
@@ -109,6 +111,45 @@ func ConvertUnixMillisToSeconds(requestID string, firstSegment *m3u8.MediaSegmen
 	return startTimeSeconds, endTimeSeconds, nil
 }
 
+// MediaTimeToManifestOffset converts a media time (seconds since the start of the recording session) to an offset in
+// seconds from the start of the manifest. The segment durations in the manifest drift from the real timestamps over
+// long recordings, so the segment containing the media time is found with a binary search over the segments' actual
+// start timestamps, only probing a few of them. segmentStart returns the start timestamp of the segment at the index.
+func MediaTimeToManifestOffset(segments []*m3u8.MediaSegment, mediaTime float64, segmentStart func(i int) (float64, error)) (float64, error) {
+	var searchErr error
+	starts := map[int]float64{}
+	startOf := func(i int) float64 {
+		if start, ok := starts[i]; ok {
+			return start
+		}
+		start, err := segmentStart(i)
+		if err != nil && searchErr == nil {
+			searchErr = err
+		}
+		starts[i] = start
+		return start
+	}
+
+	// The first segment that starts after the media time, the one before it contains the media time
+	next := sort.Search(len(segments), func(i int) bool {
+		return startOf(i) > mediaTime
+	})
+	if searchErr != nil {
+		return 0, fmt.Errorf("error clipping: failed to get segment start time: %w", searchErr)
+	}
+	if next == 0 {
+		// The media time is before the start of the recording
+		return 0, nil
+	}
+
+	idx := next - 1
+	offset := 0.0
+	for _, segment := range segments[:idx] {
+		offset += segment.Duration
+	}
+	return offset + mediaTime - startOf(idx), nil
+}
+
 // Function to find relevant segments that span from the clipping start and end times
 func ClipManifest(requestID string, manifest *m3u8.MediaPlaylist, startTime, endTime float64) ([]*m3u8.MediaSegment, []ClipSegmentInfo, error) {
 	var clipStartSegmentInfo, clipEndSegmentInfo ClipSegmentInfo
@@ -176,15 +217,32 @@ func ClipManifest(requestID string, manifest *m3u8.MediaPlaylist, startTime, end
 //		"sc_threshold": 50: Detects scene changes with threshold 50.
 //		"bf": "0": Disables B-frames for bidirectional prediction.
 //		"c:a": "aac": re-encode audio and clip.
-//	     "map 0:a map 0:v": so that audio track is always first which matches recording segments
-func ClipSegment(requestID, tsInputFile, tsOutputFile string, startTime, endTime float64) error {
+//	     "map 0:a map 0:v": so that audio track is always first which matches recording segments, unless a
+//	     track selection is given
+func ClipSegment(requestID, tsInputFile, tsOutputFile string, startTime, endTime float64, tracks TrackSelection, key *ScratchKey) error {
+	pipes := key.pipes()
+	input, err := pipes.input(tsInputFile)
+	if err != nil {
+		return err
+	}
+	output, err := pipes.outputTo(tsOutputFile)
+	if err != nil {
+		return err
+	}
 
 	var baseArgs []string
 	mapArgs := []string{"-map", "0:a", "-map", "0:v"}
+	// data tracks are dropped since the clipped segments are re-encoded
+	if maps := tracks.MapArgs(false); maps != nil {
+		mapArgs = nil
+		for _, m := range maps {
+			mapArgs = append(mapArgs, "-map", m)
+		}
+	}
 
 	// append input file
 	baseArgs = append(baseArgs,
-		"-i", tsInputFile)
+		"-i", input)
 
 	// append args that will apply re-encoding
 	baseArgs = append(baseArgs,
@@ -217,11 +275,9 @@ func ClipSegment(requestID, tsInputFile, tsOutputFile string, startTime, endTime
 	baseArgs = append(baseArgs, mapArgs...)
 
 	// append output file
-	baseArgs = append(baseArgs, tsOutputFile, "-y")
+	baseArgs = append(baseArgs, "-f", "mpegts", output, "-y")
 
-	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-	cmd := exec.CommandContext(timeout, "ffmpeg", baseArgs...)
+	cmd := exec.Command("ffmpeg", baseArgs...)
 
 	log.Log(requestID, "clipping", "compiled-command", fmt.Sprintf("ffmpeg %s", baseArgs))
 
@@ -229,7 +285,7 @@ func ClipSegment(requestID, tsInputFile, tsOutputFile string, startTime, endTime
 	var stdErr bytes.Buffer
 	cmd.Stdout = &outputBuf
 	cmd.Stderr = &stdErr
-	err := cmd.Run()
+	err = pipes.run(FFmpegOpClip, cmd)
 	if err != nil {
 		return fmt.Errorf("failed to clip segments from %s [%s] [%s]: %w", tsInputFile, outputBuf.String(), stdErr.String(), err)
 	}
@@ -3330,3 +3330,31 @@ source/1047.ts
 source/1048.ts
 #EXT-X-ENDLIST
 `
+
+func TestMediaTimeToManifestOffset(t *testing.T) {
+	sourceManifestB, _, err := m3u8.DecodeFrom(strings.NewReader(manifestB), true)
+	require.NoError(t, err)
+	segments := sourceManifestB.(*m3u8.MediaPlaylist).GetAllSegments()
+
+	// The real timestamps drift from the manifest durations, and the recording starts 100s into the session
+	starts := []float64{100, 105.9, 112.1, 118.3}
+	var probed []int
+	segmentStart := func(i int) (float64, error) {
+		probed = append(probed, i)
+		return starts[i], nil
+	}
+
+	offset, err := MediaTimeToManifestOffset(segments, 113.1, segmentStart)
+	require.NoError(t, err)
+	require.Equal(t, "12.780", fmt.Sprintf("%.3f", offset)) // 5.78 + 6 + (113.1 - 112.1)
+	require.Less(t, len(probed), len(segments))
+
+	offset, err = MediaTimeToManifestOffset(segments, 50, segmentStart)
+	require.NoError(t, err)
+	require.Zero(t, offset)
+
+	_, err = MediaTimeToManifestOffset(segments, 113.1, func(i int) (float64, error) {
+		return 0, fmt.Errorf("probe failed")
+	})
+	require.ErrorContains(t, err, "probe failed")
+}
@@ -0,0 +1,137 @@
+package video
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/grafov/m3u8"
+)
+
+// How far off the expected duration a conditioned segment may be, about a frame at 24fps since the segments can
+// only be cut between frames
+const ConditionedSegmentTolerance = 0.05
+
+// ConditionedOutput conditions the HLS outputs to be fed into server-side ad insertion (SSAI) systems: the segments
+// are of exactly the target duration and start with a keyframe in every rendition, the GOPs are closed, and the ad
+// insertion opportunities are marked in the playlists at the cue points.
+type ConditionedOutput struct {
+	// Seconds from the start of the video where ads can be inserted. A segment starts at each of them, and the next
+	// ones are of the target duration again from there.
+	CuePoints []float64 `json:"cue_points,omitempty"`
+}
+
+func (c *ConditionedOutput) Validate() error {
+	if c == nil {
+		return nil
+	}
+	for i, cue := range c.CuePoints {
+		if cue <= 0 {
+			return fmt.Errorf("invalid cue point %v, must be positive", cue)
+		}
+		if i > 0 && cue <= c.CuePoints[i-1] {
+			return fmt.Errorf("invalid cue point %v, cue points must be in increasing order", cue)
+		}
+	}
+	return nil
+}
+
+// SegmentBoundaries returns the times the source is cut at into segments of the target size, restarting from each
+// cue point. Segments before a cue point may be shorter.
+func (c *ConditionedOutput) SegmentBoundaries(durationSecs float64, targetSegmentSize int64) []float64 {
+	if c == nil || targetSegmentSize <= 0 {
+		return nil
+	}
+	cues := c.CuePoints
+	boundaries := []float64{}
+	start := 0.0
+	for {
+		next := start + float64(targetSegmentSize)
+		for len(cues) > 0 && cues[0] <= start {
+			cues = cues[1:]
+		}
+		if len(cues) > 0 && cues[0] < next {
+			next = cues[0]
+		}
+		if next >= durationSecs {
+			return boundaries
+		}
+		boundaries = append(boundaries, next)
+		start = next
+	}
+}
+
+// CheckSegments checks that the segments were cut at the boundaries, the last one ending wherever the video does
+func (c *ConditionedOutput) CheckSegments(segments []*m3u8.MediaSegment, boundaries []float64) error {
+	if c == nil {
+		return nil
+	}
+	var count int
+	for _, segment := range segments {
+		if segment == nil {
+			break
+		}
+		count++
+	}
+	if count != len(boundaries)+1 {
+		return fmt.Errorf("conditioned output has %d segments, expected %d", count, len(boundaries)+1)
+	}
+	start := 0.0
+	for i, boundary := range boundaries {
+		if diff := math.Abs(segments[i].Duration - (boundary - start)); diff > ConditionedSegmentTolerance {
+			return fmt.Errorf("conditioned output segment %d lasts %.3fs, expected %.3fs", i, segments[i].Duration, boundary-start)
+		}
+		start = boundary
+	}
+	return nil
+}
+
+// CueSegments returns the indexes of the segments starting at the cue points
+func (c *ConditionedOutput) CueSegments(segments []*m3u8.MediaSegment) map[int]bool {
+	if c == nil || len(c.CuePoints) == 0 {
+		return nil
+	}
+	cueSegments := map[int]bool{}
+	start := 0.0
+	for i, segment := range segments {
+		if segment == nil {
+			break
+		}
+		cue := sort.SearchFloat64s(c.CuePoints, start-ConditionedSegmentTolerance)
+		if cue < len(c.CuePoints) && math.Abs(c.CuePoints[cue]-start) <= ConditionedSegmentTolerance {
+			cueSegments[i] = true
+		}
+		start += segment.Duration
+	}
+	return cueSegments
+}
+
+const tsPacketSize = 188
+
+// StartsWithKeyframe returns whether the first video frame of an MPEG-TS segment is a keyframe, from the random access
+// indicator that's set on the packets starting one
+func StartsWithKeyframe(ts []byte) bool {
+	for offset := 0; offset+tsPacketSize <= len(ts); offset += tsPacketSize {
+		packet := ts[offset : offset+tsPacketSize]
+		if packet[0] != 0x47 || packet[1]&0x40 == 0 {
+			// out of sync, or not the start of a PES packet
+			continue
+		}
+		payload := 4
+		randomAccess := false
+		if adaptation := packet[3] & 0x20; adaptation != 0 {
+			length := int(packet[4])
+			randomAccess = length > 0 && packet[5]&0x40 != 0
+			payload = 5 + length
+		}
+		if payload+4 > tsPacketSize || packet[3]&0x10 == 0 {
+			continue
+		}
+		pes := packet[payload:]
+		// PES start code followed by a video stream ID
+		if pes[0] == 0 && pes[1] == 0 && pes[2] == 1 && pes[3]&0xf0 == 0xe0 {
+			return randomAccess
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package video
+
+import (
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionedSegmentBoundaries(t *testing.T) {
+	var none *ConditionedOutput
+	require.Nil(t, none.SegmentBoundaries(60, 10))
+
+	c := &ConditionedOutput{}
+	require.Equal(t, []float64{10, 20, 30}, c.SegmentBoundaries(35, 10))
+	require.Equal(t, []float64{10, 20}, c.SegmentBoundaries(30, 10))
+	require.Equal(t, []float64{}, c.SegmentBoundaries(8, 10))
+
+	// The segments restart from the cue points
+	c = &ConditionedOutput{CuePoints: []float64{15, 20, 50}}
+	require.Equal(t, []float64{10, 15, 20, 30}, c.SegmentBoundaries(38, 10))
+}
+
+func TestConditionedOutputValidate(t *testing.T) {
+	require.NoError(t, (*ConditionedOutput)(nil).Validate())
+	require.NoError(t, (&ConditionedOutput{CuePoints: []float64{10, 30.5}}).Validate())
+	require.Error(t, (&ConditionedOutput{CuePoints: []float64{0}}).Validate())
+	require.Error(t, (&ConditionedOutput{CuePoints: []float64{30, 10}}).Validate())
+}
+
+func TestConditionedCheckSegments(t *testing.T) {
+	c := &ConditionedOutput{CuePoints: []float64{15}}
+	boundaries := c.SegmentBoundaries(28, 10)
+	segments := func(durations ...float64) []*m3u8.MediaSegment {
+		var segments []*m3u8.MediaSegment
+		for _, d := range durations {
+			segments = append(segments, &m3u8.MediaSegment{Duration: d})
+		}
+		// like the ring buffer of the playlists
+		return append(segments, nil)
+	}
+
+	require.NoError(t, c.CheckSegments(segments(10, 5, 10, 3), boundaries))
+	require.NoError(t, c.CheckSegments(segments(10.02, 4.98, 10, 3), boundaries))
+	require.Error(t, c.CheckSegments(segments(10, 5, 9.5, 3.5), boundaries))
+	require.Error(t, c.CheckSegments(segments(10, 5, 13), boundaries))
+
+	require.Equal(t, map[int]bool{2: true}, c.CueSegments(segments(10, 5, 10, 3)))
+	require.Nil(t, (&ConditionedOutput{}).CueSegments(segments(10, 5)))
+}
+
+func tsPacket(pusi bool, randomAccess bool, payload ...byte) []byte {
+	packet := make([]byte, tsPacketSize)
+	packet[0] = 0x47
+	if pusi {
+		packet[1] = 0x40
+	}
+	packet[3] = 0x30 // adaptation field and payload
+	packet[4] = 1
+	if randomAccess {
+		packet[5] = 0x40
+	}
+	copy(packet[6:], payload)
+	return packet
+}
+
+func TestStartsWithKeyframe(t *testing.T) {
+	audio := tsPacket(true, true, 0, 0, 1, 0xc0)
+	video := func(key bool) []byte { return tsPacket(true, key, 0, 0, 1, 0xe0) }
+	cont := tsPacket(false, false)
+
+	require.True(t, StartsWithKeyframe(append(append(audio, cont...), video(true)...)))
+	require.False(t, StartsWithKeyframe(append(append(audio, video(false)...), video(true)...)))
+	require.False(t, StartsWithKeyframe(audio))
+	require.False(t, StartsWithKeyframe(nil))
+}
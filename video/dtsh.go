@@ -0,0 +1,199 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DTSHExtension is appended to the name of a media file for the name of its Mist header, e.g. 720p0.mp4.dtsh
+const DTSHExtension = ".dtsh"
+
+// Type markers of the DTMI encoding Mist uses for the metadata of its DTSC packets
+const (
+	dtmiInt    = 0x01
+	dtmiString = 0x02
+	dtmiObject = 0xE0
+	dtmiArray  = 0x0A
+)
+
+// The Mist input generating the headers of the MP4 outputs
+const dtshInput = "MistInMP4"
+
+// Headers nesting deeper aren't decoded, Mist's are only a few levels deep
+const maxDTMIDepth = 16
+
+var (
+	// The magic of the DTSC header packet a Mist header starts with
+	dtshMagic = []byte("DTSC")
+	// Terminates the DTMI objects and arrays
+	dtmiEnd = []byte{0x00, 0x00, 0xEE}
+	// The names Mist gives the codecs in its headers, by the ffprobe name of the ones it plays
+	mistCodecs = map[string]string{
+		"h264": "H264",
+		"hevc": "HEVC",
+		"av1":  "AV1",
+		"vp8":  "VP8",
+		"vp9":  "VP9",
+		"aac":  "AAC",
+		"mp3":  "MP3",
+		"opus": "opus",
+		"ac3":  "AC3",
+		"flac": "FLAC",
+	}
+)
+
+var ErrInvalidDTSH = errors.New("invalid dtsh header")
+
+// The tables Mist looks the packets of a track up in, which it indexes the whole file again for when they're missing
+var dtshTrackTables = []string{"keys", "fragments", "parts"}
+
+// ValidateDTSH checks that a Mist header is a complete DTSC header packet describing tracks along with their tables
+// and, when the tracks of its media are known, that it describes all of their codecs
+func ValidateDTSH(header []byte, tracks []InputTrack) error {
+	meta, err := parseDTSH(header)
+	if err != nil {
+		return err
+	}
+	described, ok := meta["tracks"].(map[string]interface{})
+	if !ok || len(described) == 0 {
+		return fmt.Errorf("%w: no tracks", ErrInvalidDTSH)
+	}
+	codecs := map[string]bool{}
+	for id, t := range described {
+		t, ok := t.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: track %s isn't an object", ErrInvalidDTSH, id)
+		}
+		for _, table := range dtshTrackTables {
+			if _, ok := t[table].(string); !ok {
+				return fmt.Errorf("%w: track %s has no %s table", ErrInvalidDTSH, id, table)
+			}
+		}
+		if codec, ok := t["codec"].(string); ok {
+			codecs[codec] = true
+		}
+	}
+	for _, track := range tracks {
+		codec, ok := mistCodecs[strings.ToLower(track.Codec)]
+		if ok && !codecs[codec] {
+			return fmt.Errorf("%w: %s track missing", ErrInvalidDTSH, codec)
+		}
+	}
+	return nil
+}
+
+// GenerateDTSH has MistInMP4 generate the header of an MP4 file, written next to it, the way Mist does on the first
+// playback of the file
+func GenerateDTSH(ctx context.Context, mediaPath string) ([]byte, error) {
+	// -H only generates the header and exits
+	cmd := exec.CommandContext(ctx, dtshInput, "-H", mediaPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed to generate the header: %w: %s", dtshInput, err, strings.TrimSpace(string(out)))
+	}
+	header, err := os.ReadFile(mediaPath + DTSHExtension)
+	if err != nil {
+		return nil, fmt.Errorf("%s didn't generate the header: %w", dtshInput, err)
+	}
+	return header, nil
+}
+
+// parseDTSH decodes the metadata object of the DTSC header packet a Mist header starts with
+func parseDTSH(header []byte) (map[string]interface{}, error) {
+	if len(header) < 8 || !bytes.Equal(header[:4], dtshMagic) {
+		return nil, fmt.Errorf("%w: no DTSC header packet", ErrInvalidDTSH)
+	}
+	size := binary.BigEndian.Uint32(header[4:8])
+	if size == 0 || int64(size) > int64(len(header)-8) {
+		return nil, fmt.Errorf("%w: header packet of %d bytes truncated to %d bytes", ErrInvalidDTSH, size, len(header)-8)
+	}
+	d := dtmiDecoder{b: header[8 : 8+size]}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDTSH, err)
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata isn't an object", ErrInvalidDTSH)
+	}
+	return meta, nil
+}
+
+type dtmiDecoder struct {
+	b []byte
+}
+
+func (d *dtmiDecoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.b) {
+		return nil, fmt.Errorf("unexpected end of metadata")
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *dtmiDecoder) atEnd() bool {
+	if bytes.HasPrefix(d.b, dtmiEnd) {
+		d.b = d.b[len(dtmiEnd):]
+		return true
+	}
+	return false
+}
+
+// value decodes the next value. Binary strings, like the key and fragment tables, are kept as strings.
+func (d *dtmiDecoder) value(depth int) (interface{}, error) {
+	if depth > maxDTMIDepth {
+		return nil, fmt.Errorf("metadata nested too deep")
+	}
+	marker, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+	switch marker[0] {
+	case dtmiInt:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case dtmiString:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(binary.BigEndian.Uint32(b)))
+		return string(s), err
+	case dtmiObject:
+		obj := map[string]interface{}{}
+		for !d.atEnd() {
+			b, err := d.take(2)
+			if err != nil {
+				return nil, err
+			}
+			key, err := d.take(int(binary.BigEndian.Uint16(b)))
+			if err != nil {
+				return nil, err
+			}
+			if obj[string(key)], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case dtmiArray:
+		var arr []interface{}
+		for !d.atEnd() {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("unknown metadata type 0x%02x", marker[0])
+}
@@ -0,0 +1,71 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDTSH(t *testing.T) {
+	// laid out like the header MistInMP4 -H writes for an H264 / AAC MP4 output
+	header, err := os.ReadFile("fixtures/720p0.mp4.dtsh")
+	require.NoError(t, err)
+
+	tracks := []InputTrack{{Type: TrackTypeVideo, Codec: "h264"}, {Type: TrackTypeAudio, Codec: "aac"}}
+	require.NoError(t, ValidateDTSH(header, tracks))
+	require.NoError(t, ValidateDTSH(header, nil))
+	// codecs Mist doesn't name aren't checked
+	require.NoError(t, ValidateDTSH(header, []InputTrack{{Type: TrackTypeVideo, Codec: "h264"}, {Type: TrackTypeAudio, Codec: "pcm_s16le"}}))
+	require.ErrorIs(t, ValidateDTSH(header, []InputTrack{{Type: TrackTypeVideo, Codec: "hevc"}}), ErrInvalidDTSH)
+
+	wrongMagic := append([]byte("DTSH"), header[4:]...)
+	for name, header := range map[string][]byte{
+		"empty":         nil,
+		"not dtsc":      []byte("ftypisom"),
+		"wrong magic":   wrongMagic,
+		"empty packet":  []byte("DTSC\x00\x00\x00\x00"),
+		"truncated":     header[:len(header)/2],
+		"not an object": []byte("DTSC\x00\x00\x00\x09\x01\x00\x00\x00\x00\x00\x00\x00\x01"),
+		// only the track descriptions, as probed by ffprobe
+		"no tables": dtscHeader(t, map[string]interface{}{"tracks": map[string]interface{}{
+			"1": map[string]interface{}{"codec": "H264", "type": "video", "width": int64(1280), "height": int64(720)},
+			"2": map[string]interface{}{"codec": "AAC", "type": "audio", "rate": int64(48000), "channels": int64(2)},
+		}}),
+	} {
+		require.ErrorIs(t, ValidateDTSH(header, tracks), ErrInvalidDTSH, name)
+	}
+}
+
+// dtscHeader encodes the metadata in a DTSC header packet
+func dtscHeader(t *testing.T, meta map[string]interface{}) []byte {
+	var body bytes.Buffer
+	writeDTMI(t, &body, meta)
+	header := append([]byte("DTSC"), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[4:], uint32(body.Len()))
+	return append(header, body.Bytes()...)
+}
+
+func writeDTMI(t *testing.T, b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case int64:
+		b.WriteByte(dtmiInt)
+		require.NoError(t, binary.Write(b, binary.BigEndian, v))
+	case string:
+		b.WriteByte(dtmiString)
+		require.NoError(t, binary.Write(b, binary.BigEndian, uint32(len(v))))
+		b.WriteString(v)
+	case map[string]interface{}:
+		b.WriteByte(dtmiObject)
+		for key, v := range v {
+			require.NoError(t, binary.Write(b, binary.BigEndian, uint16(len(key))))
+			b.WriteString(key)
+			writeDTMI(t, b, v)
+		}
+		b.Write(dtmiEnd)
+	default:
+		t.Fatalf("unsupported metadata value %v", v)
+	}
+}
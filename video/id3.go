@@ -0,0 +1,612 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/grafov/m3u8"
+)
+
+// Timed metadata is carried in MPEG-TS as ID3 tags in PES packets of a "metadata" stream, see
+// https://developer.apple.com/library/archive/documentation/AudioVideo/Conceptual/HTTP_Live_Streaming_Metadata_Spec
+const (
+	tsSyncByte                = 0x47
+	streamTypeTimedID3        = 0x15
+	pesStreamIDPrivate1       = 0xBD
+	ptsClockRate              = 90000
+	ptsWrap             int64 = 1 << 33
+)
+
+// TimedMetadata is an ID3 tag inserted in a stream by its encoder, e.g. to synchronise interactive overlays with
+// the video
+type TimedMetadata struct {
+	// Seconds from the start of the segment it was found in, or of the video in the sidecar of the HLS outputs
+	Time float64 `json:"time"`
+	// The whole ID3 tag, as inserted
+	ID3    []byte     `json:"id3"`
+	Frames []ID3Frame `json:"frames,omitempty"`
+}
+
+// ID3Frame is a frame of an ID3 tag, decoded for the text frames (T***, TXXX, W***, WXXX) and kept as is otherwise
+type ID3Frame struct {
+	ID string `json:"id"`
+	// The description of TXXX and WXXX frames, or the owner of PRIV frames
+	Description string `json:"description,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// TimedMetadataExtractor collects the timed metadata of an MPEG-TS segment as it's written to it, so that it can be
+// teed from the segment on its way elsewhere
+type TimedMetadataExtractor struct {
+	pending []byte
+
+	pmtPID   int
+	id3PIDs  map[int]bool
+	pes      map[int][]byte
+	firstPTS int64
+	tags     []timedTag
+}
+
+type timedTag struct {
+	pts int64
+	id3 []byte
+}
+
+func (e *TimedMetadataExtractor) Write(p []byte) (int, error) {
+	e.pending = append(e.pending, p...)
+	i := 0
+	for len(e.pending)-i >= tsPacketSize {
+		if e.pending[i] != tsSyncByte {
+			i++
+			continue
+		}
+		e.packet(e.pending[i : i+tsPacketSize])
+		i += tsPacketSize
+	}
+	e.pending = append(e.pending[:0], e.pending[i:]...)
+	return len(p), nil
+}
+
+func (e *TimedMetadataExtractor) packet(pkt []byte) {
+	if e.id3PIDs == nil {
+		e.pmtPID = -1
+		e.id3PIDs = map[int]bool{}
+		e.pes = map[int][]byte{}
+		e.firstPTS = -1
+	}
+	pid, pusi, payload := parseTSPacket(pkt)
+	if payload == nil {
+		return
+	}
+	switch {
+	case pid == 0 && pusi:
+		if pmtPID, ok := parsePAT(payload); ok {
+			e.pmtPID = pmtPID
+		}
+	case pid == e.pmtPID && pusi:
+		if section, ok := psiSection(payload); ok {
+			for _, es := range parsePMTStreams(section) {
+				if es.streamType == streamTypeTimedID3 {
+					e.id3PIDs[es.pid] = true
+				}
+			}
+		}
+	case e.id3PIDs[pid]:
+		if pusi {
+			e.flushPES(pid)
+			e.pes[pid] = append([]byte{}, payload...)
+		} else if e.pes[pid] != nil {
+			e.pes[pid] = append(e.pes[pid], payload...)
+		}
+	case pusi:
+		// The earliest presentation time of the audio and video is where the segment starts
+		if pts, _, ok := parsePESHeader(payload); ok && (e.firstPTS < 0 || ptsBefore(pts, e.firstPTS)) {
+			e.firstPTS = pts
+		}
+	}
+}
+
+func (e *TimedMetadataExtractor) flushPES(pid int) {
+	pes := e.pes[pid]
+	delete(e.pes, pid)
+	pts, data, ok := parsePESHeader(pes)
+	if !ok || len(data) == 0 {
+		return
+	}
+	e.tags = append(e.tags, timedTag{pts: pts, id3: data})
+}
+
+// Metadata returns the timed metadata found in the segment, timed from the start of the segment
+func (e *TimedMetadataExtractor) Metadata() []TimedMetadata {
+	for pid := range e.pes {
+		e.flushPES(pid)
+	}
+	var res []TimedMetadata
+	for _, tag := range e.tags {
+		var t float64
+		if e.firstPTS >= 0 {
+			t = max(float64(ptsDiff(tag.pts, e.firstPTS))/ptsClockRate, 0)
+		}
+		res = append(res, TimedMetadata{Time: t, ID3: tag.id3, Frames: ParseID3Frames(tag.id3)})
+	}
+	return res
+}
+
+// ExtractTimedMetadata returns the timed metadata of an MPEG-TS segment
+func ExtractTimedMetadata(segment []byte) []TimedMetadata {
+	e := &TimedMetadataExtractor{}
+	_, _ = e.Write(segment)
+	return e.Metadata()
+}
+
+// InjectTimedMetadata adds timed metadata to an MPEG-TS segment that has none, e.g. one transcoded from a segment that
+// had, in a metadata stream of its own. The metadata is timed from the start of the segment. Segments that already
+// carry timed metadata are returned as they are.
+func InjectTimedMetadata(segment []byte, metadata []TimedMetadata) ([]byte, error) {
+	if len(metadata) == 0 {
+		return segment, nil
+	}
+	pmtPID, firstPTS := -1, int64(-1)
+	var pmtSection []byte
+	usedPIDs := map[int]bool{}
+	for i := 0; i+tsPacketSize <= len(segment); i += tsPacketSize {
+		pkt := segment[i : i+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			return nil, fmt.Errorf("lost MPEG-TS sync at byte %d", i)
+		}
+		pid, pusi, payload := parseTSPacket(pkt)
+		usedPIDs[pid] = true
+		if payload == nil || !pusi {
+			continue
+		}
+		switch {
+		case pid == 0:
+			if p, ok := parsePAT(payload); ok {
+				pmtPID = p
+			}
+		case pid == pmtPID:
+			if pmtSection == nil {
+				section, ok := psiSection(payload)
+				if !ok {
+					return nil, fmt.Errorf("the PMT spans several packets")
+				}
+				pmtSection = section
+			}
+		default:
+			if pts, _, ok := parsePESHeader(payload); ok && (firstPTS < 0 || ptsBefore(pts, firstPTS)) {
+				firstPTS = pts
+			}
+		}
+	}
+	if pmtSection == nil {
+		return nil, fmt.Errorf("no PMT found")
+	}
+	if firstPTS < 0 {
+		return nil, fmt.Errorf("no timestamps found")
+	}
+	streams := parsePMTStreams(pmtSection)
+	for _, es := range streams {
+		if es.streamType == streamTypeTimedID3 {
+			return segment, nil
+		}
+	}
+
+	id3PID := 0x100
+	for _, es := range streams {
+		id3PID = max(id3PID, es.pid+1)
+	}
+	for usedPIDs[id3PID] {
+		id3PID++
+	}
+	if id3PID >= 0x1FFF {
+		return nil, fmt.Errorf("no PID left for the timed metadata")
+	}
+	newPMT := addTimedID3Stream(pmtSection, id3PID)
+	if 1+len(newPMT) > tsPacketSize-4 {
+		return nil, fmt.Errorf("the PMT with timed metadata doesn't fit in a packet")
+	}
+
+	var id3Packets []byte
+	cc := 0
+	for _, m := range metadata {
+		pts := (firstPTS + int64(m.Time*ptsClockRate)) % ptsWrap
+		for _, pkt := range packetizePES(id3PID, timedID3PES(pts, m.ID3), &cc) {
+			id3Packets = append(id3Packets, pkt...)
+		}
+	}
+
+	out := make([]byte, 0, len(segment)+len(id3Packets))
+	injected := false
+	for i := 0; i+tsPacketSize <= len(segment); i += tsPacketSize {
+		pkt := segment[i : i+tsPacketSize]
+		pid, pusi, _ := parseTSPacket(pkt)
+		if pid != pmtPID || !pusi {
+			out = append(out, pkt...)
+			continue
+		}
+		out = append(out, pmtPacket(pkt, newPMT)...)
+		// After the first PMT, for demuxers to know what the packets of the new stream are
+		if !injected {
+			out = append(out, id3Packets...)
+			injected = true
+		}
+	}
+	return out, nil
+}
+
+// TimedMetadataTrack collects the timed metadata of the segments of a video as they're transcoded, in any order
+type TimedMetadataTrack struct {
+	mu       sync.Mutex
+	segments map[int][]TimedMetadata
+}
+
+func (t *TimedMetadataTrack) Add(segmentIndex int, metadata []TimedMetadata) {
+	if t == nil || len(metadata) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.segments == nil {
+		t.segments = map[int][]TimedMetadata{}
+	}
+	t.segments[segmentIndex] = metadata
+}
+
+// Sidecar returns the timed metadata of the whole video, timed from its start as per the durations of the segments
+func (t *TimedMetadataTrack) Sidecar(segments []*m3u8.MediaSegment) []TimedMetadata {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var res []TimedMetadata
+	var start float64
+	for i, segment := range segments {
+		if segment == nil {
+			break
+		}
+		for _, m := range t.segments[i] {
+			m.Time += start
+			res = append(res, m)
+		}
+		start += segment.Duration
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time < res[j].Time })
+	return res
+}
+
+// ParseID3Frames decodes the frames of an ID3v2.3 or v2.4 tag, nil if it isn't one
+func ParseID3Frames(tag []byte) []ID3Frame {
+	if len(tag) < 10 || !bytes.HasPrefix(tag, []byte("ID3")) {
+		return nil
+	}
+	version, flags := tag[3], tag[5]
+	if version < 3 || version > 4 {
+		return nil
+	}
+	end := min(10+synchsafe(tag[6:10]), len(tag))
+	pos := 10
+	if flags&0x40 != 0 && pos+4 <= end {
+		// extended header, its size including itself in v2.4 but not in v2.3
+		size := int(binary.BigEndian.Uint32(tag[pos : pos+4]))
+		if version == 4 {
+			size = synchsafe(tag[pos : pos+4])
+		} else {
+			size += 4
+		}
+		pos += size
+	}
+
+	var frames []ID3Frame
+	for pos+10 <= end && tag[pos] != 0 {
+		id := string(tag[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(tag[pos+4 : pos+8]))
+		if version == 4 {
+			size = synchsafe(tag[pos+4 : pos+8])
+		}
+		pos += 10
+		if size < 0 || pos+size > end {
+			break
+		}
+		frames = append(frames, decodeID3Frame(id, tag[pos:pos+size]))
+		pos += size
+	}
+	return frames
+}
+
+func decodeID3Frame(id string, data []byte) ID3Frame {
+	f := ID3Frame{ID: id}
+	switch {
+	case id == "TXXX" || id == "WXXX":
+		if len(data) == 0 {
+			return f
+		}
+		parts := splitID3Text(data[0], data[1:])
+		f.Description = parts[0]
+		if len(parts) > 1 {
+			f.Value = parts[1]
+		}
+	case id[0] == 'T':
+		if len(data) > 0 {
+			f.Value = splitID3Text(data[0], data[1:])[0]
+		}
+	case id[0] == 'W':
+		f.Value = string(bytes.TrimRight(data, "\x00"))
+	case id == "PRIV":
+		owner, rest, _ := bytes.Cut(data, []byte{0})
+		f.Description = string(owner)
+		f.Data = rest
+	default:
+		f.Data = data
+	}
+	return f
+}
+
+// splitID3Text splits the null-terminated strings of a text frame in the given encoding
+func splitID3Text(encoding byte, data []byte) []string {
+	var parts []string
+	switch encoding {
+	case 1, 2:
+		// UTF-16, with a BOM or big-endian, terminated by two null bytes
+		for len(data) > 0 {
+			i := 0
+			for i+1 < len(data) && (data[i] != 0 || data[i+1] != 0) {
+				i += 2
+			}
+			parts = append(parts, decodeUTF16(data[:min(i, len(data))], encoding == 2))
+			if i+2 > len(data) {
+				break
+			}
+			data = data[i+2:]
+		}
+	default:
+		// latin-1 or UTF-8
+		for _, p := range bytes.Split(data, []byte{0}) {
+			if encoding == 0 {
+				runes := make([]rune, len(p))
+				for i, b := range p {
+					runes[i] = rune(b)
+				}
+				parts = append(parts, string(runes))
+			} else {
+				parts = append(parts, string(p))
+			}
+		}
+	}
+	if len(parts) == 0 {
+		parts = []string{""}
+	}
+	// a trailing terminator doesn't start another string
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE {
+		b, bigEndian = b[2:], false
+	} else if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		b, bigEndian = b[2:], true
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		if bigEndian {
+			u[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			u[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// parseTSPacket returns the PID of a packet, whether a PES packet or PSI section starts in it and its payload, nil
+// when it has none
+func parseTSPacket(pkt []byte) (int, bool, []byte) {
+	pid := int(pkt[1]&0x1F)<<8 | int(pkt[2])
+	pusi := pkt[1]&0x40 != 0
+	afc := (pkt[3] >> 4) & 0x3
+	offset := 4
+	if afc&0x2 != 0 {
+		offset += 1 + int(pkt[4])
+	}
+	if afc&0x1 == 0 || offset >= tsPacketSize {
+		return pid, pusi, nil
+	}
+	return pid, pusi, pkt[offset:]
+}
+
+// psiSection returns the section starting in the payload of a packet, if it's whole
+func psiSection(payload []byte) ([]byte, bool) {
+	if len(payload) < 1 {
+		return nil, false
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) {
+		return nil, false
+	}
+	length := int(payload[start+1]&0x0F)<<8 | int(payload[start+2])
+	if start+3+length > len(payload) {
+		return nil, false
+	}
+	return payload[start : start+3+length], true
+}
+
+// parsePAT returns the PID of the PMT of the first program
+func parsePAT(payload []byte) (int, bool) {
+	section, ok := psiSection(payload)
+	if !ok || section[0] != 0x00 || len(section) < 12 {
+		return 0, false
+	}
+	// programs from after the header up to the CRC
+	for i := 8; i+4 <= len(section)-4; i += 4 {
+		if program := binary.BigEndian.Uint16(section[i:]); program != 0 {
+			return int(section[i+2]&0x1F)<<8 | int(section[i+3]), true
+		}
+	}
+	return 0, false
+}
+
+type pmtStream struct {
+	streamType byte
+	pid        int
+}
+
+func parsePMTStreams(section []byte) []pmtStream {
+	if len(section) < 16 || section[0] != 0x02 {
+		return nil
+	}
+	programInfoLength := int(section[10]&0x0F)<<8 | int(section[11])
+	var streams []pmtStream
+	for i := 12 + programInfoLength; i+5 <= len(section)-4; {
+		streams = append(streams, pmtStream{
+			streamType: section[i],
+			pid:        int(section[i+1]&0x1F)<<8 | int(section[i+2]),
+		})
+		i += 5 + (int(section[i+3]&0x0F)<<8 | int(section[i+4]))
+	}
+	return streams
+}
+
+// addTimedID3Stream returns the PMT section with a timed ID3 stream added, described the way ffmpeg does
+func addTimedID3Stream(section []byte, pid int) []byte {
+	descriptor := []byte{0x26, 13, 0xFF, 0xFF, 'I', 'D', '3', ' ', 0xFF, 'I', 'D', '3', ' ', 0x00, 0x0F}
+	es := []byte{streamTypeTimedID3, 0xE0 | byte(pid>>8), byte(pid), 0xF0, byte(len(descriptor))}
+	es = append(es, descriptor...)
+
+	body := section[:len(section)-4]
+	newSection := make([]byte, 0, len(section)+len(es))
+	newSection = append(newSection, body...)
+	newSection = append(newSection, es...)
+	length := len(newSection) - 3 + 4
+	newSection[1] = (newSection[1] & 0xF0) | byte(length>>8)
+	newSection[2] = byte(length)
+	return binary.BigEndian.AppendUint32(newSection, crc32MPEG2(newSection))
+}
+
+// pmtPacket returns the packet with its section replaced, keeping its header and continuity counter
+func pmtPacket(pkt []byte, section []byte) []byte {
+	out := make([]byte, tsPacketSize)
+	copy(out, pkt[:4])
+	// no adaptation field, payload only
+	out[3] = 0x10 | (pkt[3] & 0x0F)
+	out[4] = 0
+	n := copy(out[5:], section)
+	for i := 5 + n; i < tsPacketSize; i++ {
+		out[i] = 0xFF
+	}
+	return out
+}
+
+func timedID3PES(pts int64, id3 []byte) []byte {
+	header := []byte{0x00, 0x00, 0x01, pesStreamIDPrivate1, 0, 0, 0x84, 0x80, 5}
+	header = append(header, encodePTS(pts)...)
+	if length := len(header) - 6 + len(id3); length <= 0xFFFF {
+		binary.BigEndian.PutUint16(header[4:], uint16(length))
+	}
+	return append(header, id3...)
+}
+
+// packetizePES splits a PES packet in TS packets, the last one padded with stuffing
+func packetizePES(pid int, pes []byte, cc *int) [][]byte {
+	var packets [][]byte
+	for first := true; len(pes) > 0; first = false {
+		pkt := make([]byte, tsPacketSize)
+		pkt[0] = tsSyncByte
+		pkt[1] = byte(pid>>8) & 0x1F
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		n := min(len(pes), tsPacketSize-4)
+		if n == tsPacketSize-4 {
+			pkt[3] = 0x10 | byte(*cc&0x0F)
+			copy(pkt[4:], pes[:n])
+		} else {
+			// adaptation field to pad the rest of the packet
+			pkt[3] = 0x30 | byte(*cc&0x0F)
+			stuffing := tsPacketSize - 4 - n
+			pkt[4] = byte(stuffing - 1)
+			if stuffing > 1 {
+				pkt[5] = 0x00
+				for i := 6; i < 4+stuffing; i++ {
+					pkt[i] = 0xFF
+				}
+			}
+			copy(pkt[4+stuffing:], pes[:n])
+		}
+		*cc++
+		pes = pes[n:]
+		packets = append(packets, pkt)
+	}
+	return packets
+}
+
+// parsePESHeader returns the PTS and the data of a PES packet, or false if it doesn't start one with a PTS
+func parsePESHeader(pes []byte) (int64, []byte, bool) {
+	if len(pes) < 14 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || pes[7]&0x80 == 0 {
+		return 0, nil, false
+	}
+	dataStart := 9 + int(pes[8])
+	if dataStart > len(pes) {
+		return 0, nil, false
+	}
+	data := pes[dataStart:]
+	if length := int(binary.BigEndian.Uint16(pes[4:6])); length > 0 && 6+length >= dataStart && 6+length < len(pes) {
+		data = pes[dataStart : 6+length]
+	}
+	return decodePTS(pes[9:14]), data, true
+}
+
+func decodePTS(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func encodePTS(pts int64) []byte {
+	return []byte{
+		0x21 | byte(pts>>29)&0x0E,
+		byte(pts >> 22),
+		0x01 | byte(pts>>14)&0xFE,
+		byte(pts >> 7),
+		0x01 | byte(pts<<1)&0xFE,
+	}
+}
+
+// ptsDiff returns a-b, taking the wrap around of the 33 bits timestamps into account
+func ptsDiff(a, b int64) int64 {
+	d := (a - b) % ptsWrap
+	if d > ptsWrap/2 {
+		d -= ptsWrap
+	} else if d < -ptsWrap/2 {
+		d += ptsWrap
+	}
+	return d
+}
+
+func ptsBefore(a, b int64) bool {
+	return ptsDiff(a, b) < 0
+}
+
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,87 @@
+package video
+
+import (
+	"os"
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/stretchr/testify/require"
+)
+
+// id3Tag returns an ID3v2.4 tag with a TXXX frame, as encoders insert them
+func id3Tag(description, value string) []byte {
+	frame := append([]byte{3}, description...)
+	frame = append(frame, 0)
+	frame = append(frame, value...)
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, byte(10 + len(frame))}
+	tag = append(tag, 'T', 'X', 'X', 'X', 0, 0, 0, byte(len(frame)), 0, 0)
+	return append(tag, frame...)
+}
+
+func TestTimedMetadataRoundTrip(t *testing.T) {
+	segment, err := os.ReadFile("../test/fixtures/seg-0.ts")
+	require.NoError(t, err)
+	require.Empty(t, ExtractTimedMetadata(segment))
+
+	tags := []TimedMetadata{
+		{Time: 0.5, ID3: id3Tag("overlay", `{"poll":1}`)},
+		// longer than a packet
+		{Time: 1.25, ID3: id3Tag("caption", string(make([]byte, 400)))},
+	}
+	withMetadata, err := InjectTimedMetadata(segment, tags)
+	require.NoError(t, err)
+	require.Equal(t, len(segment)+4*tsPacketSize, len(withMetadata))
+	require.Equal(t, StartsWithKeyframe(segment), StartsWithKeyframe(withMetadata))
+
+	extracted := ExtractTimedMetadata(withMetadata)
+	require.Len(t, extracted, 2)
+	require.InDelta(t, 0.5, extracted[0].Time, 0.001)
+	require.Equal(t, tags[0].ID3, extracted[0].ID3)
+	require.Equal(t, []ID3Frame{{ID: "TXXX", Description: "overlay", Value: `{"poll":1}`}}, extracted[0].Frames)
+	require.InDelta(t, 1.25, extracted[1].Time, 0.001)
+	require.Equal(t, tags[1].ID3, extracted[1].ID3)
+
+	// Streamed in pieces of any size
+	e := &TimedMetadataExtractor{}
+	for i := 0; i < len(withMetadata); i += 1000 {
+		_, _ = e.Write(withMetadata[i:min(i+1000, len(withMetadata))])
+	}
+	require.Equal(t, extracted, e.Metadata())
+
+	// Segments that already carry timed metadata are kept as they are
+	again, err := InjectTimedMetadata(withMetadata, tags)
+	require.NoError(t, err)
+	require.Equal(t, withMetadata, again)
+}
+
+func TestParseID3Frames(t *testing.T) {
+	tag := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 36}
+	// latin-1 title
+	tag = append(tag, 'T', 'I', 'T', '2', 0, 0, 0, 5, 0, 0, 0, 'c', 'a', 'f', 0xE9)
+	// UTF-16 with a BOM
+	tag = append(tag, 'T', 'P', 'E', '1', 0, 0, 0, 5, 0, 0, 1, 0xFF, 0xFE, 'a', 0)
+	tag = append(tag, 0, 0, 0, 0, 0, 0)
+	require.Equal(t, []ID3Frame{
+		{ID: "TIT2", Value: "café"},
+		{ID: "TPE1", Value: "a"},
+	}, ParseID3Frames(tag))
+
+	require.Nil(t, ParseID3Frames([]byte("not a tag")))
+}
+
+func TestTimedMetadataSidecar(t *testing.T) {
+	var track TimedMetadataTrack
+	track.Add(2, []TimedMetadata{{Time: 1, ID3: []byte("c")}})
+	track.Add(0, []TimedMetadata{{Time: 3, ID3: []byte("a")}, {Time: 5, ID3: []byte("b")}})
+	track.Add(1, nil)
+
+	segments := []*m3u8.MediaSegment{{Duration: 10}, {Duration: 10}, {Duration: 10}, nil}
+	require.Equal(t, []TimedMetadata{
+		{Time: 3, ID3: []byte("a")},
+		{Time: 5, ID3: []byte("b")},
+		{Time: 21, ID3: []byte("c")},
+	}, track.Sidecar(segments))
+
+	var none *TimedMetadataTrack
+	require.Empty(t, none.Sidecar(segments))
+}
@@ -0,0 +1,203 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/livepeer/catalyst-api/log"
+)
+
+const (
+	// Steps the VMAF check takes down from a rendition's bitrate, each one 25% lower than the previous
+	maxVMAFSteps     = 3
+	vmafStepFactor   = 0.75
+	vmafSampleSecs   = 10
+	vmafCheckTimeout = 5 * time.Minute
+)
+
+// LadderCap caps the bitrates of a job's renditions based on its source, so that e.g. a 2 Mbps source doesn't yield a
+// 6 Mbps 1080p rendition
+type LadderCap struct {
+	// Max rendition bitrate relative to the source bitrate at the same resolution, MaxBitrateFactor when unset
+	MaxBitrateFactor float64 `json:"max_bitrate_factor,omitempty"`
+	// When set, renditions of a sample segment are encoded and their bitrates lowered for as long as their VMAF score
+	// stays above this target
+	VMAFTarget float64 `json:"vmaf_target,omitempty"`
+}
+
+func (l *LadderCap) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.MaxBitrateFactor < 0 {
+		return fmt.Errorf("invalid max_bitrate_factor %v, must be positive", l.MaxBitrateFactor)
+	}
+	if l.VMAFTarget < 0 || l.VMAFTarget > 100 {
+		return fmt.Errorf("invalid vmaf_target %v, must be between 0 and 100", l.VMAFTarget)
+	}
+	return nil
+}
+
+// CapLadder limits the bitrate of every transcoded profile to the factor times the source bitrate scaled to the
+// profile's resolution. Profiles at or above the source resolution are capped to the factor times the source bitrate.
+func CapLadder(profiles []EncodedProfile, videoTrack InputTrack, factor float64) []EncodedProfile {
+	if factor <= 0 {
+		factor = MaxBitrateFactor
+	}
+	sourcePixels := videoTrack.Width * videoTrack.Height
+	if videoTrack.Bitrate <= 0 || sourcePixels <= 0 {
+		return profiles
+	}
+	sourceBitrate := math.Min(float64(videoTrack.Bitrate), MaxVideoBitrate)
+	capped := make([]EncodedProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if !profile.Copy {
+			pixelRatio := 1.0
+			if pixels := profileWidth(profile, videoTrack) * profileHeight(profile, videoTrack); pixels < sourcePixels {
+				pixelRatio = float64(pixels) / float64(sourcePixels)
+			}
+			maxBitrate := int64(math.Max(factor*sourceBitrate*pixelRatio, AbsoluteMinVideoBitrate))
+			if profile.Bitrate > maxBitrate {
+				profile.Bitrate = maxBitrate
+			}
+		}
+		capped = append(capped, profile)
+	}
+	return capped
+}
+
+// profileWidth and profileHeight return the dimensions of a profile, derived from the source aspect ratio when only
+// one of them is set
+func profileWidth(profile EncodedProfile, videoTrack InputTrack) int64 {
+	if profile.Width == 0 && profile.Height != 0 {
+		return profile.Height * videoTrack.Width / videoTrack.Height
+	}
+	return profile.Width
+}
+
+func profileHeight(profile EncodedProfile, videoTrack InputTrack) int64 {
+	if profile.Height == 0 && profile.Width != 0 {
+		return profile.Width * videoTrack.Height / videoTrack.Width
+	}
+	return profile.Height
+}
+
+// VMAFScorer encodes the sample at a profile's resolution and bitrate and returns its VMAF score against the sample
+type VMAFScorer func(ctx context.Context, sampleURL string, profile EncodedProfile) (float64, error)
+
+// CheckLadderVMAF lowers the bitrate of each transcoded profile for as long as a sample segment encoded at the lower
+// bitrate still scores above the VMAF target. Profiles that can't be scored are left as they are.
+func CheckLadderVMAF(ctx context.Context, requestID, sampleURL string, profiles []EncodedProfile, target float64, score VMAFScorer) []EncodedProfile {
+	checked := make([]EncodedProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if !profile.Copy && profile.Bitrate > 0 {
+			bitrate := lowestBitrateAboveTarget(ctx, requestID, sampleURL, profile, target, score)
+			if bitrate != profile.Bitrate {
+				log.Log(requestID, "lowered rendition bitrate from VMAF check", "profile", profile.Name, "bitrate", profile.Bitrate, "new_bitrate", bitrate, "vmaf_target", target)
+				profile.Bitrate = bitrate
+			}
+		}
+		checked = append(checked, profile)
+	}
+	return checked
+}
+
+func lowestBitrateAboveTarget(ctx context.Context, requestID, sampleURL string, profile EncodedProfile, target float64, score VMAFScorer) int64 {
+	bitrate := profile.Bitrate
+	for i := 0; i < maxVMAFSteps; i++ {
+		candidate := profile
+		candidate.Bitrate = int64(float64(bitrate) * vmafStepFactor)
+		if candidate.Bitrate < MinVideoBitrate {
+			break
+		}
+		vmaf, err := score(ctx, sampleURL, candidate)
+		if err != nil {
+			log.LogError(requestID, "failed to check VMAF of rendition", err, "profile", profile.Name, "bitrate", candidate.Bitrate)
+			break
+		}
+		if vmaf < target {
+			break
+		}
+		bitrate = candidate.Bitrate
+	}
+	return bitrate
+}
+
+var vmafScoreRegex = regexp.MustCompile(`VMAF score[:=]\s*([0-9.]+)`)
+
+// FFmpegVMAF scores a profile with ffmpeg, which needs to be built with libvmaf. The encoded sample is scaled back to
+// the source resolution to be compared with it, the same way a player would upscale it.
+func FFmpegVMAF(ctx context.Context, sampleURL string, profile EncodedProfile) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, vmafCheckTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp(os.TempDir(), "vmaf-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	encoded := filepath.Join(dir, "encoded.ts")
+
+	width, height := profile.Width, profile.Height
+	if width == 0 {
+		width = -2
+	}
+	if height == 0 {
+		height = -2
+	}
+	bitrate := strconv.FormatInt(profile.Bitrate, 10)
+	encodeArgs := []string{
+		"-i", sampleURL,
+		"-t", strconv.Itoa(vmafSampleSecs),
+		"-an",
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-b:v", bitrate,
+		"-maxrate", bitrate,
+		"-bufsize", strconv.FormatInt(2*profile.Bitrate, 10),
+		encoded, "-y",
+	}
+	if _, err := runFFmpeg(ctx, encodeArgs); err != nil {
+		return 0, fmt.Errorf("failed to encode VMAF sample: %w", err)
+	}
+
+	scoreArgs := []string{
+		"-i", encoded,
+		"-t", strconv.Itoa(vmafSampleSecs),
+		"-i", sampleURL,
+		"-lavfi", "[0:v]setpts=PTS-STARTPTS[distorted];[1:v]setpts=PTS-STARTPTS[reference];[distorted][reference]scale2ref=flags=bicubic[distorted][reference];[distorted][reference]libvmaf",
+		"-f", "null", "-",
+	}
+	stdErr, err := runFFmpeg(ctx, scoreArgs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute VMAF: %w", err)
+	}
+	return parseVMAFScore(stdErr)
+}
+
+func parseVMAFScore(ffmpegOutput string) (float64, error) {
+	match := vmafScoreRegex.FindStringSubmatch(ffmpegOutput)
+	if match == nil {
+		return 0, fmt.Errorf("no VMAF score in ffmpeg output")
+	}
+	return strconv.ParseFloat(match[1], 64)
+}
+
+func runFFmpeg(ctx context.Context, args []string) (string, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stdErr bytes.Buffer
+	cmd.Stderr = &stdErr
+	if err := RunFFmpeg(FFmpegOpVMAF, cmd); err != nil {
+		return stdErr.String(), fmt.Errorf("ffmpeg failed [%s]: %w", stdErr.String(), err)
+	}
+	return stdErr.String(), nil
+}
@@ -0,0 +1,92 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapLadder(t *testing.T) {
+	source := InputTrack{
+		Type:       "video",
+		Bitrate:    2_000_000,
+		VideoTrack: VideoTrack{Width: 1920, Height: 1080},
+	}
+	profiles := []EncodedProfile{
+		{Name: "1080p0", Width: 1920, Height: 1080, Bitrate: 6_000_000},
+		{Name: "720p0", Height: 720, Bitrate: 4_000_000},
+		{Name: "360p0", Width: 640, Height: 360, Bitrate: 200_000},
+		{Name: "1080p-copy", Width: 1920, Height: 1080, Bitrate: 2_000_000, Copy: true},
+	}
+
+	capped := CapLadder(profiles, source, 1.2)
+	require.Equal(t, []EncodedProfile{
+		{Name: "1080p0", Width: 1920, Height: 1080, Bitrate: 2_400_000},
+		{Name: "720p0", Height: 720, Bitrate: 1_066_666},
+		{Name: "360p0", Width: 640, Height: 360, Bitrate: 200_000},
+		{Name: "1080p-copy", Width: 1920, Height: 1080, Bitrate: 2_000_000, Copy: true},
+	}, capped)
+	// the requested profiles are left untouched
+	require.Equal(t, int64(6_000_000), profiles[0].Bitrate)
+
+	// upscaled profiles don't get more than the source bitrate
+	capped = CapLadder([]EncodedProfile{{Name: "4k", Width: 3840, Height: 2160, Bitrate: 20_000_000}}, source, 1)
+	require.Equal(t, int64(2_000_000), capped[0].Bitrate)
+
+	// nothing to cap against without the source bitrate
+	source.Bitrate = 0
+	require.Equal(t, profiles, CapLadder(profiles, source, 1.2))
+}
+
+func TestCheckLadderVMAF(t *testing.T) {
+	profiles := []EncodedProfile{
+		{Name: "1080p0", Width: 1920, Height: 1080, Bitrate: 4_000_000},
+		{Name: "360p0", Width: 640, Height: 360, Bitrate: 1_000_000},
+		{Name: "source", Width: 1920, Height: 1080, Bitrate: 5_000_000, Copy: true},
+	}
+	var scored []EncodedProfile
+	score := func(ctx context.Context, sampleURL string, profile EncodedProfile) (float64, error) {
+		require.Equal(t, "sample.ts", sampleURL)
+		scored = append(scored, profile)
+		if profile.Name == "360p0" {
+			return 0, errors.New("no libvmaf")
+		}
+		// 1080p keeps its quality down to 2.25 Mbps
+		if profile.Bitrate >= 2_250_000 {
+			return 96, nil
+		}
+		return 90, nil
+	}
+
+	checked := CheckLadderVMAF(context.Background(), "request-id", "sample.ts", profiles, 93, score)
+	require.Equal(t, []EncodedProfile{
+		{Name: "1080p0", Width: 1920, Height: 1080, Bitrate: 2_250_000},
+		{Name: "360p0", Width: 640, Height: 360, Bitrate: 1_000_000},
+		{Name: "source", Width: 1920, Height: 1080, Bitrate: 5_000_000, Copy: true},
+	}, checked)
+
+	var scoredBitrates []int64
+	for _, p := range scored {
+		scoredBitrates = append(scoredBitrates, p.Bitrate)
+	}
+	require.Equal(t, []int64{3_000_000, 2_250_000, 1_687_500, 750_000}, scoredBitrates)
+}
+
+func TestParseVMAFScore(t *testing.T) {
+	score, err := parseVMAFScore("[Parsed_libvmaf_4 @ 0x55d8c8c0] VMAF score: 94.861528\n")
+	require.NoError(t, err)
+	require.Equal(t, 94.861528, score)
+
+	_, err = parseVMAFScore("No such filter: 'libvmaf'")
+	require.Error(t, err)
+}
+
+func TestLadderCapValidate(t *testing.T) {
+	var l *LadderCap
+	require.NoError(t, l.Validate())
+	require.NoError(t, (&LadderCap{MaxBitrateFactor: 1.5, VMAFTarget: 93}).Validate())
+	require.Error(t, (&LadderCap{MaxBitrateFactor: -1}).Validate())
+	require.Error(t, (&LadderCap{VMAFTarget: 101}).Validate())
+}
@@ -2,7 +2,6 @@ package video
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -99,14 +98,14 @@ type TranscodedSegmentInfo struct {
 	SegmentIndex  int
 }
 
-func WriteSegmentsToDisk(transmuxTopLevelDir string, renditionList *TRenditionList, segmentBatch []TranscodedSegmentInfo) error {
+func WriteSegmentsToDisk(transmuxTopLevelDir string, renditionList *TRenditionList, segmentBatch []TranscodedSegmentInfo, key *ScratchKey) error {
 	for _, segInfo := range segmentBatch {
 
 		// All accesses to renditionList and segmentList is protected by a mutex behind the scenes
 		segmentList := renditionList.GetSegmentList(segInfo.RenditionName)
 		segmentData := segmentList.GetSegment(segInfo.SegmentIndex)
 		segmentFilename := filepath.Join(transmuxTopLevelDir, segInfo.RequestID+"_"+segInfo.RenditionName+"_"+strconv.Itoa(segInfo.SegmentIndex)+".ts")
-		segmentFile, err := os.Create(segmentFilename)
+		segmentFile, err := key.Create(segmentFilename)
 		if err != nil {
 			return fmt.Errorf("error creating .ts file to write transcoded segment data err: %w", err)
 		}
@@ -0,0 +1,82 @@
+package video
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/livepeer/catalyst-api/config"
+)
+
+// RenditionNaming is how the renditions we generate are named. The name is used for the rendition's HLS path, its
+// MP4 filename and in the callback outputs, so changing it breaks the consumers relying on the previous names.
+type RenditionNaming string
+
+const (
+	// e.g. 720p0, the names used before the naming was configurable
+	RenditionNamingLegacy RenditionNaming = "legacy"
+	// e.g. 720p
+	RenditionNamingResolution RenditionNaming = "resolution"
+	// e.g. 720p_4000k
+	RenditionNamingResolutionBitrate RenditionNaming = "resolution_bitrate"
+)
+
+func (n RenditionNaming) Validate() error {
+	switch n {
+	case "", RenditionNamingLegacy, RenditionNamingResolution, RenditionNamingResolutionBitrate:
+		return nil
+	}
+	return fmt.Errorf("invalid rendition naming %q, must be one of %s, %s or %s", n, RenditionNamingLegacy, RenditionNamingResolution, RenditionNamingResolutionBitrate)
+}
+
+// NameRenditions names the profiles that don't have a name yet or have the one we generated for them, following the
+// naming or the -rendition-naming default when it's empty. Names given by the request are kept as they are. Names
+// that would clash with another rendition's fall back to including the bitrate, and then an index.
+func NameRenditions(profiles []EncodedProfile, naming RenditionNaming) []EncodedProfile {
+	if naming == "" {
+		naming = RenditionNaming(config.RenditionNaming)
+	}
+	rename := func(profile EncodedProfile) bool {
+		return profile.Name == "" || (naming != RenditionNamingLegacy && isGeneratedName(profile))
+	}
+
+	named := make([]EncodedProfile, len(profiles))
+	taken := map[string]bool{}
+	for _, profile := range profiles {
+		if !rename(profile) {
+			taken[profile.Name] = true
+		}
+	}
+	for i, profile := range profiles {
+		named[i] = profile
+		if !rename(profile) {
+			continue
+		}
+		name := renditionName(profile, naming)
+		if taken[name] {
+			name = renditionName(profile, RenditionNamingResolutionBitrate)
+		}
+		for n := 2; taken[name]; n++ {
+			name = renditionName(profile, naming) + "_" + strconv.Itoa(n)
+		}
+		named[i].Name = name
+		taken[name] = true
+	}
+	return named
+}
+
+func renditionName(profile EncodedProfile, naming RenditionNaming) string {
+	resolution := strconv.FormatInt(profile.Height, 10) + "p"
+	switch naming {
+	case RenditionNamingResolution:
+		return resolution
+	case RenditionNamingResolutionBitrate:
+		return resolution + "_" + strconv.FormatInt(profile.Bitrate/1000, 10) + "k"
+	}
+	return resolution + "0"
+}
+
+// isGeneratedName returns whether the profile has the name the default ladder gives it. The low bitrate profile keeps
+// its name whatever the naming, it tells it apart from the profile of the same resolution.
+func isGeneratedName(profile EncodedProfile) bool {
+	return profile.Name == renditionName(profile, RenditionNamingLegacy)
+}
@@ -0,0 +1,41 @@
+package video
+
+import (
+	"testing"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameRenditions(t *testing.T) {
+	ladder := []EncodedProfile{
+		{Name: "360p0", Width: 640, Height: 360, Bitrate: 1_000_000},
+		{Name: "custom", Width: 1280, Height: 720, Bitrate: 3_000_000},
+		{Name: LowBitrateProfileName, Width: 1080, Height: 1080, Bitrate: 500_000},
+		{Name: "1080p0", Width: 1080, Height: 1080, Bitrate: 5_500_000},
+		{Width: 1080, Height: 1080, Bitrate: 6_000_000},
+	}
+	names := func(profiles []EncodedProfile) []string {
+		var names []string
+		for _, p := range profiles {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"360p0", "custom", "low-bitrate", "1080p0", "1080p_6000k"}, names(NameRenditions(ladder, RenditionNamingLegacy)))
+	require.Equal(t, []string{"360p", "custom", "low-bitrate", "1080p", "1080p_6000k"}, names(NameRenditions(ladder, RenditionNamingResolution)))
+	require.Equal(t, []string{"360p_1000k", "custom", "low-bitrate", "1080p_5500k", "1080p_6000k"}, names(NameRenditions(ladder, RenditionNamingResolutionBitrate)))
+	// The profiles passed in are left as they are
+	require.Equal(t, "360p0", ladder[0].Name)
+
+	defer func(naming string) { config.RenditionNaming = naming }(config.RenditionNaming)
+	config.RenditionNaming = string(RenditionNamingResolution)
+	require.Equal(t, "360p", NameRenditions(ladder, "")[0].Name)
+}
+
+func TestValidateRenditionNaming(t *testing.T) {
+	require.NoError(t, RenditionNaming("").Validate())
+	require.NoError(t, RenditionNamingResolutionBitrate.Validate())
+	require.EqualError(t, RenditionNaming("bitrate").Validate(), "invalid rendition naming \"bitrate\", must be one of legacy, resolution or resolution_bitrate")
+}
@@ -0,0 +1,109 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// How a source was normalized into a mezzanine MP4 before being transcoded
+const (
+	// The tracks were copied into an MP4 as they were, only the container was unsupported
+	NormalizeRemux = "remux"
+	// Some of the tracks were transcoded, to H.264 or AAC, as their codecs can't be carried by an MP4 or transcoded by
+	// the pipelines
+	NormalizeTranscode = "transcode"
+)
+
+var (
+	// The containers neither pipeline can read, as named by ffprobe, which are remuxed into the mezzanine MP4s. The
+	// others, e.g. MPEG-TS or Matroska, are read by the pipelines as they are.
+	unsupportedContainers = []string{"avi", "asf", "mpeg", "rm", "mxf", "dv"}
+	// The codecs of the tracks copied into the mezzanine MP4s as they are, which the pipelines transcode
+	mezzanineVideoCodecs = []string{"h264", "hevc", "av1"}
+	mezzanineAudioCodecs = []string{"aac", "mp3", "ac3", "eac3", "opus"}
+)
+
+// SourceNormalization describes the mezzanine MP4 a source was converted into, e.g. an AVI or WMV file that neither
+// pipeline can read
+type SourceNormalization struct {
+	Mode               string `json:"mode"`
+	Reason             string `json:"reason"`
+	OriginalFormat     string `json:"original_format"`
+	OriginalVideoCodec string `json:"original_video_codec,omitempty"`
+	OriginalAudioCodec string `json:"original_audio_codec,omitempty"`
+
+	transcodeVideo bool
+	transcodeAudio bool
+}
+
+// NeedsNormalization returns how the source has to be normalized before being transcoded, nil when its container and
+// codecs are supported by the pipelines as they are. HLS sources are never normalized.
+func NeedsNormalization(iv InputVideo) *SourceNormalization {
+	if iv.Format == "hls" {
+		return nil
+	}
+	n := &SourceNormalization{OriginalFormat: iv.Format}
+	var reasons []string
+	if containsStr(unsupportedContainers, iv.Format) {
+		reasons = append(reasons, fmt.Sprintf("container %s", iv.Format))
+	}
+	if track, err := iv.GetTrack(TrackTypeVideo); err == nil {
+		n.OriginalVideoCodec = track.Codec
+		n.transcodeVideo = !containsStr(mezzanineVideoCodecs, strings.ToLower(track.Codec)) || track.FPS <= 0
+	}
+	if track, err := iv.GetTrack(TrackTypeAudio); err == nil {
+		n.OriginalAudioCodec = track.Codec
+		n.transcodeAudio = !containsStr(mezzanineAudioCodecs, strings.ToLower(track.Codec))
+	}
+	if n.transcodeVideo {
+		reasons = append(reasons, fmt.Sprintf("video codec %s", n.OriginalVideoCodec))
+		if track, _ := iv.GetTrack(TrackTypeVideo); track.FPS <= 0 {
+			reasons[len(reasons)-1] += " without a framerate"
+		}
+	}
+	if n.transcodeAudio {
+		reasons = append(reasons, fmt.Sprintf("audio codec %s", n.OriginalAudioCodec))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	n.Reason = "unsupported " + strings.Join(reasons, ", ")
+	n.Mode = NormalizeRemux
+	if n.transcodeVideo || n.transcodeAudio {
+		n.Mode = NormalizeTranscode
+	}
+	return n
+}
+
+// args returns the ffmpeg arguments converting the source into the mezzanine MP4, keeping the first video track and
+// all the audio tracks
+func (n *SourceNormalization) args(sourceURL, outputPath string) []string {
+	args := []string{"-hide_banner", "-nostats", "-analyzeduration", "15M", "-i", sourceURL, "-map", "0:v:0?", "-map", "0:a?"}
+	if n.transcodeVideo {
+		// high quality, the mezzanine is transcoded again
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-pix_fmt", "yuv420p")
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	if n.transcodeAudio {
+		args = append(args, "-c:a", "aac", "-b:a", "192k")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	return append(args, "-movflags", "+faststart", "-f", "mp4", "-y", outputPath)
+}
+
+// NormalizeSource converts the source into the mezzanine MP4 at the local output path
+func NormalizeSource(ctx context.Context, n *SourceNormalization, sourceURL, outputPath string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", n.args(sourceURL, outputPath)...)
+	var stdErr bytes.Buffer
+	cmd.Stderr = &stdErr
+	if err := RunFFmpeg(FFmpegOpNormalize, cmd); err != nil {
+		return fmt.Errorf("failed to %s the source into an MP4 [%s]: %w", n.Mode, stdErr.String(), err)
+	}
+	return nil
+}
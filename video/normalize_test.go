@@ -0,0 +1,65 @@
+package video
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeedsNormalization(t *testing.T) {
+	source := func(format, videoCodec, audioCodec string) InputVideo {
+		iv := InputVideo{Format: format}
+		if videoCodec != "" {
+			iv.Tracks = append(iv.Tracks, InputTrack{Type: TrackTypeVideo, Codec: videoCodec, VideoTrack: VideoTrack{FPS: 30}})
+		}
+		if audioCodec != "" {
+			iv.Tracks = append(iv.Tracks, InputTrack{Type: TrackTypeAudio, Codec: audioCodec})
+		}
+		return iv
+	}
+
+	require.Nil(t, NeedsNormalization(source("mp4", "h264", "aac")))
+	require.Nil(t, NeedsNormalization(source("mov", "hevc", "")))
+	require.Nil(t, NeedsNormalization(source("hls", "mpeg2video", "mp2")))
+	// the containers the pipelines read as they are
+	require.Nil(t, NeedsNormalization(source("mpegts", "h264", "aac")))
+	require.Nil(t, NeedsNormalization(source("matroska", "h264", "opus")))
+	require.Nil(t, NeedsNormalization(source("flv", "h264", "mp3")))
+
+	n := NeedsNormalization(source("avi", "h264", "mp3"))
+	require.Equal(t, NormalizeRemux, n.Mode)
+	require.Equal(t, "unsupported container avi", n.Reason)
+	require.Contains(t, n.args("in.avi", "out.mp4"), "copy")
+	require.NotContains(t, n.args("in.avi", "out.mp4"), "libx264")
+
+	n = NeedsNormalization(source("asf", "wmv3", "wmav2"))
+	require.Equal(t, NormalizeTranscode, n.Mode)
+	require.Equal(t, "unsupported container asf, video codec wmv3, audio codec wmav2", n.Reason)
+	require.Equal(t, "wmv3", n.OriginalVideoCodec)
+	args := n.args("in.wmv", "out.mp4")
+	require.Contains(t, args, "libx264")
+	require.Contains(t, args, "aac")
+	require.Equal(t, "out.mp4", args[len(args)-1])
+
+	// only the tracks that can't be copied are transcoded
+	n = NeedsNormalization(source("avi", "h264", "pcm_s16le"))
+	require.Equal(t, NormalizeTranscode, n.Mode)
+	args = n.args("in.avi", "out.mp4")
+	require.NotContains(t, args, "libx264")
+	require.Contains(t, args, "aac")
+
+	// VP9 can't be carried by the mezzanine MP4
+	n = NeedsNormalization(source("matroska", "vp9", "opus"))
+	require.Equal(t, NormalizeTranscode, n.Mode)
+	require.Equal(t, "unsupported video codec vp9", n.Reason)
+
+	// the sources rejected by the probe unless they're normalized
+	n = NeedsNormalization(source("mov", "prores", "pcm_s24le"))
+	require.Equal(t, NormalizeTranscode, n.Mode)
+	require.Contains(t, n.args("in.mov", "out.mp4"), "libx264")
+	noFramerate := source("mp4", "h264", "aac")
+	noFramerate.Tracks[0].FPS = 0
+	n = NeedsNormalization(noFramerate)
+	require.Equal(t, NormalizeTranscode, n.Mode)
+	require.Equal(t, "unsupported video codec h264 without a framerate", n.Reason)
+}
@@ -2,6 +2,7 @@ package video
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -9,6 +10,9 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/livepeer/catalyst-api/cache"
+	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
 	"github.com/livepeer/catalyst-api/log"
 	"gopkg.in/vansante/go-ffprobe.v2"
 )
@@ -24,9 +28,51 @@ type Prober interface {
 
 type Probe struct {
 	IgnoreErrMessages []string
+	// NoCache always runs ffprobe, e.g. when the source may have changed without its ETag or size changing
+	NoCache bool
+	// Normalizable doesn't reject the video codecs the pipelines can't transcode, for the sources that are normalized
+	// into a mezzanine MP4 first, see NeedsNormalization
+	Normalizable bool
 }
 
 func (p Probe) ProbeFile(requestID string, url string, ffProbeOptions ...string) (InputVideo, error) {
+	if p.NoCache || config.ProbeCacheTTL <= 0 {
+		return p.probeFile(requestID, url, ffProbeOptions...)
+	}
+
+	key := probeCache.cacheKey(context.Background(), url, ffProbeOptions)
+	if key != "" && p.Normalizable {
+		key += "|normalizable"
+	}
+	if key == "" {
+		return p.probeFile(requestID, url, ffProbeOptions...)
+	}
+	if iv, ok := probeCache.get(key); ok {
+		log.Log(requestID, "using cached probe result", "url", log.RedactURL(url))
+		return iv, nil
+	}
+	// Probe results are also kept in the node's artifact cache, which outlives the in-memory cache
+	if data, ok := cache.Artifacts.GetBytes("probe|" + key); ok {
+		var iv InputVideo
+		if err := json.Unmarshal(data, &iv); err == nil {
+			log.Log(requestID, "using cached probe result from disk", "url", log.RedactURL(url))
+			probeCache.put(key, iv, config.ProbeCacheTTL)
+			return iv, nil
+		}
+	}
+	iv, err := p.probeFile(requestID, url, ffProbeOptions...)
+	if err == nil {
+		probeCache.put(key, iv, config.ProbeCacheTTL)
+		if data, err := json.Marshal(iv); err == nil {
+			if err := cache.Artifacts.PutBytes("probe|"+key, data); err != nil {
+				log.LogError(requestID, "failed to write probe result to the artifact cache", err)
+			}
+		}
+	}
+	return iv, err
+}
+
+func (p Probe) probeFile(requestID string, url string, ffProbeOptions ...string) (InputVideo, error) {
 	iv, err := p.runProbe(url, ffProbeOptions...)
 	if err == nil {
 		return iv, nil
@@ -64,13 +110,41 @@ func (p Probe) runProbe(url string, ffProbeOptions ...string) (iv InputVideo, er
 	if err != nil {
 		return InputVideo{}, fmt.Errorf("error probing: %w", err)
 	}
+	return p.parse(data)
+}
+
+func (p Probe) parse(data *ffprobe.ProbeData) (InputVideo, error) {
+	if p.Normalizable {
+		return parseProbeData(data)
+	}
 	return parseProbeOutput(data)
 }
 
+// unsupportedInput marks an error about the media of the source, which retrying can't fix
+func unsupportedInput(err error) error {
+	return catErrs.Unretriable(catErrs.WithClass(catErrs.ClassUnsupportedInput, err))
+}
+
+// parseProbeOutput parses the probe data of a media file, rejecting the video codecs the pipelines can't transcode
 func parseProbeOutput(probeData *ffprobe.ProbeData) (InputVideo, error) {
+	if videoStream := probeData.FirstVideoStream(); videoStream != nil && probeData.Format != nil {
+		// check for unsupported video stream(s)
+		for _, codec := range unsupportedVideoCodecList {
+			if strings.ToLower(videoStream.CodecName) == codec {
+				return InputVideo{}, unsupportedInput(fmt.Errorf("error checking for video: %s is not supported", videoStream.CodecName))
+			}
+		}
+		if strings.ToLower(videoStream.CodecName) == "vp9" && strings.Contains(probeData.Format.FormatName, "mp4") {
+			return InputVideo{}, unsupportedInput(fmt.Errorf("error checking for video: VP9 in an MP4 container is not supported"))
+		}
+	}
+	return parseProbeData(probeData)
+}
+
+func parseProbeData(probeData *ffprobe.ProbeData) (InputVideo, error) {
 	// We rely on this being present to get required information about the input video, so error out if it isn't
 	if probeData.Format == nil {
-		return InputVideo{}, fmt.Errorf("error parsing input video: format information missing")
+		return InputVideo{}, unsupportedInput(fmt.Errorf("error parsing input video: format information missing"))
 	}
 	// parse filesize
 	size, err := strconv.ParseInt(probeData.Format.Size, 10, 64)
@@ -83,7 +157,7 @@ func parseProbeOutput(probeData *ffprobe.ProbeData) (InputVideo, error) {
 	if videoStream == nil {
 		audioStream := probeData.FirstAudioStream()
 		if audioStream == nil {
-			return InputVideo{}, errors.New("error checking for video: no video or audio stream found")
+			return InputVideo{}, unsupportedInput(errors.New("error checking for video: no video or audio stream found"))
 		}
 
 		// Audio-only stream
@@ -96,15 +170,6 @@ func parseProbeOutput(probeData *ffprobe.ProbeData) (InputVideo, error) {
 		return addAudioTrack(probeData, iv)
 
 	}
-	// check for unsupported video stream(s)
-	for _, codec := range unsupportedVideoCodecList {
-		if strings.ToLower(videoStream.CodecName) == codec {
-			return InputVideo{}, fmt.Errorf("error checking for video: %s is not supported", videoStream.CodecName)
-		}
-	}
-	if strings.ToLower(videoStream.CodecName) == "vp9" && strings.Contains(probeData.Format.FormatName, "mp4") {
-		return InputVideo{}, fmt.Errorf("error checking for video: VP9 in an MP4 container is not supported")
-	}
 	// parse bitrate
 	bitRateValue := videoStream.BitRate
 	if bitRateValue == "" {
@@ -176,6 +241,7 @@ func parseProbeOutput(probeData *ffprobe.ProbeData) (InputVideo, error) {
 					Rotation:           rotation,
 					DisplayAspectRatio: videoStream.DisplayAspectRatio,
 					PixelFormat:        videoStream.PixFmt,
+					FieldOrder:         videoStream.FieldOrder,
 				},
 			},
 		},
@@ -186,10 +252,31 @@ func parseProbeOutput(probeData *ffprobe.ProbeData) (InputVideo, error) {
 	if err != nil {
 		return InputVideo{}, err
 	}
+	iv.TimedMetadata = hasTimedMetadata(probeData)
+	iv.AudioLanguages = audioLanguages(probeData)
 
 	return iv, nil
 }
 
+func audioLanguages(probeData *ffprobe.ProbeData) []string {
+	var languages []string
+	for _, stream := range probeData.StreamType(ffprobe.StreamAudio) {
+		if language, err := stream.TagList.GetString("language"); err == nil && language != "" {
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
+func hasTimedMetadata(probeData *ffprobe.ProbeData) bool {
+	for _, stream := range probeData.Streams {
+		if stream != nil && stream.CodecName == "timed_id3" {
+			return true
+		}
+	}
+	return false
+}
+
 func addAudioTrack(probeData *ffprobe.ProbeData, iv InputVideo) (InputVideo, error) {
 	audioTrack := probeData.FirstAudioStream()
 	if audioTrack == nil {
@@ -212,6 +299,7 @@ func addAudioTrack(probeData *ffprobe.ProbeData, iv InputVideo) (InputVideo, err
 	}
 
 	bitrate, _ := strconv.ParseInt(audioTrack.BitRate, 10, 64)
+	language, _ := audioTrack.TagList.GetString("language")
 	iv.Tracks = append(iv.Tracks, InputTrack{
 		Type:         TrackTypeAudio,
 		Codec:        audioTrack.CodecName,
@@ -223,6 +311,7 @@ func addAudioTrack(probeData *ffprobe.ProbeData, iv InputVideo) (InputVideo, err
 			SampleBits: audioTrack.BitsPerSample,
 			SampleRate: sampleRate,
 			BitDepth:   bitDepth,
+			Language:   language,
 		},
 	})
 
@@ -0,0 +1,147 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeCache holds successful probe results, so that a source probed again by a clip job or a retry doesn't start a
+// new ffprobe and download the source from its origin again. Entries are keyed by the source's ETag or size, so a
+// changed source is probed again.
+var probeCache = &probeResultCache{entries: map[string]probeCacheEntry{}, validate: sourceValidator}
+
+type probeResultCache struct {
+	mu      sync.Mutex
+	entries map[string]probeCacheEntry
+	// validate returns what identifies the current version of the source, empty if it can't be told
+	validate func(ctx context.Context, sourceURL string) (string, error)
+}
+
+type probeCacheEntry struct {
+	iv      InputVideo
+	expires time.Time
+}
+
+// cacheKey identifies the probe of a source version with the given ffprobe options
+func (c *probeResultCache) cacheKey(ctx context.Context, sourceURL string, ffProbeOptions []string) string {
+	key := sourceVersionKey(ctx, sourceURL, c.validate)
+	if key == "" {
+		return ""
+	}
+	return key + "|" + strings.Join(ffProbeOptions, " ")
+}
+
+// SourceVersionKey identifies the current version of a source by its URL and ETag or size, so that it can be used to
+// cache what's derived from the source's content. Empty if the version can't be told.
+func SourceVersionKey(ctx context.Context, sourceURL string) string {
+	return sourceVersionKey(ctx, sourceURL, sourceValidator)
+}
+
+// Query strings are left out when the source has an ETag, since signed URLs of the same object differ in their
+// signature.
+func sourceVersionKey(ctx context.Context, sourceURL string, validate func(ctx context.Context, sourceURL string) (string, error)) string {
+	validator, err := validate(ctx, sourceURL)
+	if err != nil || validator == "" {
+		return ""
+	}
+	key := sourceURL
+	if strings.HasPrefix(validator, "etag:") {
+		if u, err := url.Parse(sourceURL); err == nil {
+			u.RawQuery = ""
+			key = u.String()
+		}
+	}
+	return key + "|" + validator
+}
+
+func (c *probeResultCache) get(key string) (InputVideo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return InputVideo{}, false
+	}
+	return entry.iv.copy(), true
+}
+
+func (c *probeResultCache) put(key string, iv InputVideo, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = probeCacheEntry{iv: iv.copy(), expires: now.Add(ttl)}
+}
+
+func (iv InputVideo) copy() InputVideo {
+	if iv.Tracks != nil {
+		iv.Tracks = append(make([]InputTrack, 0, len(iv.Tracks)), iv.Tracks...)
+	}
+	return iv
+}
+
+// sourceValidator returns the ETag or size of an HTTP source, or the size and modification time of a local file. A
+// single byte is requested rather than using HEAD, since signed URLs are only valid for GET.
+func sourceValidator(ctx context.Context, sourceURL string) (string, error) {
+	u, err := url.Parse(sourceURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "", "file":
+		info, err := os.Stat(u.Path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("file:%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+	default:
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	default:
+		return "", fmt.Errorf("unexpected status checking source: %d", resp.StatusCode)
+	}
+	// Weak ETags don't guarantee identical bytes
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return "etag:" + etag, nil
+	}
+	size := resp.ContentLength
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		_, total, _ := strings.Cut(contentRange, "/")
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return "", nil
+		}
+	} else if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+	if size <= 0 {
+		return "", nil
+	}
+	return "size:" + strconv.FormatInt(size, 10), nil
+}
@@ -35,6 +35,19 @@ func TestItRejectsWhenMJPEGVideoTrackPresent(t *testing.T) {
 	require.ErrorContains(t, err, "jpeg is not supported")
 }
 
+func TestItParsesTheNormalizableVideos(t *testing.T) {
+	for _, codec := range []string{"mjpeg", "prores"} {
+		iv, err := Probe{Normalizable: true}.parse(&ffprobe.ProbeData{
+			Format:  &ffprobe.Format{Size: "1", FormatName: "mov,mp4,m4a,3gp,3g2,mj2"},
+			Streams: []*ffprobe.Stream{{CodecType: "video", CodecName: codec, AvgFrameRate: "0/0"}},
+		})
+		require.NoError(t, err, codec)
+		track, err := iv.GetTrack(TrackTypeVideo)
+		require.NoError(t, err)
+		require.Equal(t, codec, track.Codec)
+	}
+}
+
 func TestItRejectsProresVideos(t *testing.T) {
 	_, err := parseProbeOutput(&ffprobe.ProbeData{
 		Format: &ffprobe.Format{
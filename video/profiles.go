@@ -24,6 +24,13 @@ type InputVideo struct {
 	Tracks    []InputTrack `json:"tracks,omitempty"`
 	Duration  float64      `json:"duration,omitempty"`
 	SizeBytes int64        `json:"size,omitempty"`
+	// sha256 of the source file as copied, identifying its content across uploads. Empty for HLS sources, and not part
+	// of the probe results.
+	Checksum string `json:"-"`
+	// TimedMetadata is set when the source has an ID3 timed metadata stream, to keep when segmenting it
+	TimedMetadata bool `json:"timed_metadata,omitempty"`
+	// The languages the audio tracks are tagged with, to select them by, see TrackSelection.ForSource
+	AudioLanguages []string `json:"audio_languages,omitempty"`
 }
 
 // Finds the video track from the list of input video tracks
@@ -61,13 +68,25 @@ type VideoTrack struct {
 	FPS                float64 `json:"fps,omitempty"`
 	Rotation           int64   `json:"rotation,omitempty"`
 	DisplayAspectRatio string  `json:"display_aspect_ratio,omitempty"`
+	// FieldOrder is ffprobe's field_order, i.e. progressive, tt, bb, tb or bt
+	FieldOrder string `json:"field_order,omitempty"`
+}
+
+// Interlaced returns whether the track's frames are made of two fields, e.g. 1080i broadcast captures
+func (v VideoTrack) Interlaced() bool {
+	switch v.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
 }
 
 type AudioTrack struct {
-	Channels   int `json:"channels,omitempty"`
-	SampleRate int `json:"sample_rate,omitempty"`
-	SampleBits int `json:"sample_bits,omitempty"`
-	BitDepth   int `json:"bit_depth,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	SampleBits int    `json:"sample_bits,omitempty"`
+	BitDepth   int    `json:"bit_depth,omitempty"`
+	Language   string `json:"language,omitempty"`
 }
 
 type InputTrack struct {
@@ -199,6 +218,9 @@ func GetDefaultPlaybackProfiles(video InputTrack, copySource bool) ([]EncodedPro
 	return profiles, nil
 }
 
+// LowBitrateProfileName names the extra rendition of sources too small for the default ladder, which has no MP4
+const LowBitrateProfileName = "low-bitrate"
+
 // When the input video's height and bitrate combo is too low to meet the default ABR ladder we output a low bitrate
 // profile as well as the profile matching the input video to achieve at least some ABR playback.
 // 50% of the input video's bitrate was found to give a decent experience. We also then check that this isn't below
@@ -211,7 +233,7 @@ func lowBitrateProfile(video InputTrack) EncodedProfile {
 		bitrate = AbsoluteMinVideoBitrate
 	}
 	return EncodedProfile{
-		Name:    "low-bitrate",
+		Name:    LowBitrateProfileName,
 		FPS:     0,
 		Bitrate: bitrate,
 		Width:   nearestEven(video.Width),
@@ -248,6 +270,16 @@ type OutputVideo struct {
 	Manifest   string            `json:"manifest,omitempty"`
 	Videos     []OutputVideoFile `json:"videos"`
 	MP4Outputs []OutputVideoFile `json:"mp4_outputs,omitempty"`
+	// Deinterlaced is set when the source was interlaced and deinterlaced before transcoding
+	Deinterlaced bool `json:"deinterlaced,omitempty"`
+	// TimedMetadata is the location of the JSON sidecar with the ID3 timed metadata of the source, when it had any
+	TimedMetadata string `json:"timed_metadata,omitempty"`
+	// SourceSnapshot is set when the source was a live HLS playlist still being written, of which only the segments
+	// listed when the job started are in the outputs
+	SourceSnapshot bool `json:"source_snapshot,omitempty"`
+	// MissingSegments are the indices of the segments of a repaired recording that couldn't be recovered, skipped
+	// over in the outputs
+	MissingSegments []int `json:"missing_segments,omitempty"`
 }
 
 type OutputVideoFile struct {
@@ -257,6 +289,8 @@ type OutputVideoFile struct {
 	Width     int64  `json:"width,omitempty"`
 	Height    int64  `json:"height,omitempty"`
 	Bitrate   int64  `json:"bitrate,omitempty"`
+	// The tracks found when probing the output, reflecting the track selection of the request
+	Tracks []InputTrack `json:"tracks,omitempty"`
 }
 
 func PopulateOutput(requestID string, probe Prober, outputURL string, videoFile OutputVideoFile) (OutputVideoFile, error) {
@@ -272,5 +306,6 @@ func PopulateOutput(requestID string, probe Prober, outputURL string, videoFile
 	videoFile.Height = videoTrack.Height
 	videoFile.Width = videoTrack.Width
 	videoFile.Bitrate = videoTrack.Bitrate
+	videoFile.Tracks = outputVideoProbe.Tracks
 	return videoFile, nil
 }
@@ -393,6 +393,9 @@ func TestGetDefaultPlaybackProfilesFixtures(t *testing.T) {
 func TestPopulateOutput(t *testing.T) {
 	out, err := PopulateOutput("requestID", Probe{}, "fixtures/bbb-180rotated.mov", OutputVideoFile{})
 	require.NoError(t, err)
+	require.NotEmpty(t, out.Tracks)
+	require.Equal(t, TrackTypeVideo, out.Tracks[0].Type)
+	out.Tracks = nil
 	require.Equal(t, OutputVideoFile{
 		SizeBytes: 123542,
 		Width:     416,
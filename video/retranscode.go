@@ -0,0 +1,64 @@
+package video
+
+import (
+	"fmt"
+	"slices"
+)
+
+// PartialRetranscode re-runs a job for some of its renditions and/or a range of its segments, e.g. when a single
+// rendition failed QC or its upload. Everything else is left as the original job wrote it to the same target.
+type PartialRetranscode struct {
+	// Names of the renditions to transcode again, all of them when empty
+	Renditions []string `json:"renditions,omitempty"`
+	// Range of source segments to transcode again, from SegmentStart up to but excluding SegmentEnd. A SegmentEnd of 0
+	// means up to the last segment.
+	SegmentStart int `json:"segment_start,omitempty"`
+	SegmentEnd   int `json:"segment_end,omitempty"`
+}
+
+func (r *PartialRetranscode) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.SegmentStart < 0 {
+		return fmt.Errorf("invalid segment_start %d, must be positive", r.SegmentStart)
+	}
+	if r.SegmentEnd != 0 && r.SegmentEnd <= r.SegmentStart {
+		return fmt.Errorf("invalid segment_end %d, must be after segment_start", r.SegmentEnd)
+	}
+	return nil
+}
+
+// PartialSegments returns whether only some of the segments are transcoded again, so the outputs that span all of
+// them, like MP4s, can't be regenerated
+func (r *PartialRetranscode) PartialSegments() bool {
+	return r != nil && (r.SegmentStart > 0 || r.SegmentEnd > 0)
+}
+
+// IncludesSegment returns whether the source segment at the index is transcoded again
+func (r *PartialRetranscode) IncludesSegment(index int) bool {
+	if r == nil {
+		return true
+	}
+	return index >= r.SegmentStart && (r.SegmentEnd == 0 || index < r.SegmentEnd)
+}
+
+// FilterProfiles returns the profiles of the renditions transcoded again. Every rendition asked for must be one of
+// the job's profiles, since a rendition missing from the original outputs can't be added by a partial re-transcode.
+func (r *PartialRetranscode) FilterProfiles(profiles []EncodedProfile) ([]EncodedProfile, error) {
+	if r == nil || len(r.Renditions) == 0 {
+		return profiles, nil
+	}
+	var filtered []EncodedProfile
+	for _, profile := range profiles {
+		if slices.Contains(r.Renditions, profile.Name) {
+			filtered = append(filtered, profile)
+		}
+	}
+	for _, name := range r.Renditions {
+		if !slices.ContainsFunc(filtered, func(p EncodedProfile) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("rendition %q is not one of the job's renditions", name)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,45 @@
+package video
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialRetranscode(t *testing.T) {
+	profiles := []EncodedProfile{
+		{Name: "1080p0", Width: 1920, Height: 1080},
+		{Name: "720p0", Width: 1280, Height: 720},
+		{Name: "360p0", Width: 640, Height: 360},
+	}
+
+	var all *PartialRetranscode
+	require.NoError(t, all.Validate())
+	require.False(t, all.PartialSegments())
+	require.True(t, all.IncludesSegment(10))
+	filtered, err := all.FilterProfiles(profiles)
+	require.NoError(t, err)
+	require.Equal(t, profiles, filtered)
+
+	r := &PartialRetranscode{Renditions: []string{"360p0", "1080p0"}, SegmentStart: 2, SegmentEnd: 4}
+	require.NoError(t, r.Validate())
+	require.True(t, r.PartialSegments())
+	require.False(t, r.IncludesSegment(1))
+	require.True(t, r.IncludesSegment(2))
+	require.True(t, r.IncludesSegment(3))
+	require.False(t, r.IncludesSegment(4))
+	filtered, err = r.FilterProfiles(profiles)
+	require.NoError(t, err)
+	require.Equal(t, []EncodedProfile{profiles[0], profiles[2]}, filtered)
+
+	// up to the last segment
+	r = &PartialRetranscode{SegmentStart: 2}
+	require.True(t, r.PartialSegments())
+	require.True(t, r.IncludesSegment(1000))
+	require.False(t, (&PartialRetranscode{Renditions: []string{"720p0"}}).PartialSegments())
+
+	_, err = (&PartialRetranscode{Renditions: []string{"480p0"}}).FilterProfiles(profiles)
+	require.Error(t, err)
+	require.Error(t, (&PartialRetranscode{SegmentStart: -1}).Validate())
+	require.Error(t, (&PartialRetranscode{SegmentStart: 4, SegmentEnd: 4}).Validate())
+}
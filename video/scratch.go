@@ -0,0 +1,211 @@
+package video
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/livepeer/catalyst-api/config"
+)
+
+// ScratchKey encrypts the files a job stages on the local disk, e.g. the transcoded segments concatenated into the
+// MP4 renditions, with AES-CTR. It's generated for the job and only held in memory, so the files can't be read back
+// once the job is gone. A nil key leaves the files in the clear.
+//
+// ffmpeg reads and writes the encrypted files through pipes, see scratchPipes. The MP4 outputs it has to seek in are
+// still written in the clear until they're uploaded.
+type ScratchKey struct {
+	block cipher.Block
+}
+
+// NewScratchKey returns a new random key, or nil when the scratch files aren't encrypted, see -encrypt-scratch-files
+func NewScratchKey() (*ScratchKey, error) {
+	if !config.EncryptScratchFiles {
+		return nil, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate scratch file key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch file cipher: %w", err)
+	}
+	return &ScratchKey{block: block}, nil
+}
+
+type scratchReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Create creates a scratch file that's encrypted as it's written. Each file starts with its own random IV.
+func (k *ScratchKey) Create(name string) (io.WriteCloser, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return f, nil
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to generate scratch file IV: %w", err)
+	}
+	if _, err := f.Write(iv); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cipher.StreamWriter{S: cipher.NewCTR(k.block, iv), W: f}, nil
+}
+
+// Open opens a scratch file written by Create, decrypting it as it's read
+func (k *ScratchKey) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return f, nil
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(f, iv); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read scratch file IV of %s: %w", name, err)
+	}
+	return scratchReader{Reader: cipher.StreamReader{S: cipher.NewCTR(k.block, iv), R: f}, Closer: f}, nil
+}
+
+// Size returns the size of the content of a scratch file, without its IV
+func (k *ScratchKey) Size(name string) (int64, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	if k == nil {
+		return info.Size(), nil
+	}
+	return info.Size() - aes.BlockSize, nil
+}
+
+// scratchPipes passes scratch files to ffmpeg through pipes, decrypting its inputs and encrypting its output on the
+// fly so that they're never on disk in the clear. Without a key ffmpeg reads and writes the files directly.
+type scratchPipes struct {
+	key    *ScratchKey
+	inputs []scratchInput
+	output io.WriteCloser
+}
+
+type scratchInput struct {
+	r, w  *os.File
+	names []string
+}
+
+func (k *ScratchKey) pipes() *scratchPipes {
+	return &scratchPipes{key: k}
+}
+
+func (p *scratchPipes) encrypted() bool {
+	return p.key != nil
+}
+
+// input returns the ffmpeg input reading the scratch files one after the other, like the concat protocol does
+func (p *scratchPipes) input(names ...string) (string, error) {
+	if p.key == nil {
+		if len(names) == 1 {
+			return names[0], nil
+		}
+		return "concat:" + strings.Join(names, "|"), nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to create pipe to ffmpeg: %w", err)
+	}
+	p.inputs = append(p.inputs, scratchInput{r: r, w: w, names: names})
+	// The pipes are passed to ffmpeg after stdin, stdout and stderr
+	return fmt.Sprintf("pipe:%d", 2+len(p.inputs)), nil
+}
+
+// outputTo returns the ffmpeg output writing the scratch file, whose format must be given explicitly since ffmpeg
+// can't tell it from the name of a pipe
+func (p *scratchPipes) outputTo(name string) (string, error) {
+	if p.key == nil {
+		return name, nil
+	}
+	w, err := p.key.Create(name)
+	if err != nil {
+		return "", fmt.Errorf("error creating file (%s) err: %w", name, err)
+	}
+	p.output = w
+	return "pipe:1", nil
+}
+
+// run runs ffmpeg for the operation, see RunFFmpeg, feeding it the inputs and encrypting its output
+func (p *scratchPipes) run(operation string, cmd *exec.Cmd) error {
+	for _, in := range p.inputs {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, in.r)
+	}
+	if p.output != nil {
+		cmd.Stdout = p.output
+	}
+	proc, err := startFFmpeg(operation, cmd)
+	// ffmpeg has its own copies of the read ends now, ours must be closed for writes to fail once it exits
+	for _, in := range p.inputs {
+		in.r.Close()
+	}
+	if err != nil {
+		for _, in := range p.inputs {
+			in.w.Close()
+		}
+		if p.output != nil {
+			p.output.Close()
+		}
+		return err
+	}
+
+	feedErrs := make(chan error, len(p.inputs))
+	for _, in := range p.inputs {
+		go func(in scratchInput) {
+			feedErrs <- p.feed(in)
+		}(in)
+	}
+	err = proc.wait()
+	var feedErr error
+	for range p.inputs {
+		if e := <-feedErrs; e != nil && feedErr == nil {
+			feedErr = e
+		}
+	}
+	if p.output != nil {
+		if e := p.output.Close(); e != nil && feedErr == nil {
+			feedErr = e
+		}
+	}
+	// A failing ffmpeg also fails the feeds, its error is the one that matters
+	if err != nil {
+		return err
+	}
+	return feedErr
+}
+
+func (p *scratchPipes) feed(in scratchInput) error {
+	defer in.w.Close()
+	for _, name := range in.names {
+		f, err := p.key.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(in.w, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to pipe scratch file %s to ffmpeg: %w", name, err)
+		}
+	}
+	return nil
+}
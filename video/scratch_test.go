@@ -0,0 +1,116 @@
+package video
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestScratchKey(t *testing.T) *ScratchKey {
+	defer func(encrypt bool) { config.EncryptScratchFiles = encrypt }(config.EncryptScratchFiles)
+	config.EncryptScratchFiles = true
+	key, err := NewScratchKey()
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	return key
+}
+
+func writeScratchFile(t *testing.T, key *ScratchKey, name string, data []byte) {
+	w, err := key.Create(name)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func readScratchFile(t *testing.T, key *ScratchKey, name string) []byte {
+	r, err := key.Open(name)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func TestScratchFilesAreEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("segment data "), 1000)
+	key := newTestScratchKey(t)
+
+	name := filepath.Join(dir, "seg.ts")
+	writeScratchFile(t, key, name, data)
+	onDisk, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.NotContains(t, string(onDisk), "segment data")
+	require.Equal(t, data, readScratchFile(t, key, name))
+	size, err := key.Size(name)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	// Files of the same job have different IVs
+	other := filepath.Join(dir, "other.ts")
+	writeScratchFile(t, key, other, data)
+	otherOnDisk, err := os.ReadFile(other)
+	require.NoError(t, err)
+	require.NotEqual(t, onDisk, otherOnDisk)
+
+	// Another job's key can't read them
+	require.NotEqual(t, data, readScratchFile(t, newTestScratchKey(t), name))
+}
+
+func TestScratchFilesInTheClearWithoutKey(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "seg.ts")
+	key, err := NewScratchKey()
+	require.NoError(t, err)
+	require.Nil(t, key)
+
+	writeScratchFile(t, key, name, []byte("segment data"))
+	onDisk, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.Equal(t, "segment data", string(onDisk))
+	size, err := key.Size(name)
+	require.NoError(t, err)
+	require.Equal(t, int64(12), size)
+}
+
+func TestScratchPipes(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestScratchKey(t)
+	first, second, output := filepath.Join(dir, "1.ts"), filepath.Join(dir, "2.ts"), filepath.Join(dir, "out.ts")
+	writeScratchFile(t, key, first, []byte("first "))
+	writeScratchFile(t, key, second, []byte("second"))
+
+	pipes := key.pipes()
+	input, err := pipes.input(first, second)
+	require.NoError(t, err)
+	require.Equal(t, "pipe:3", input)
+	out, err := pipes.outputTo(output)
+	require.NoError(t, err)
+	require.Equal(t, "pipe:1", out)
+
+	// Stands in for ffmpeg, copying its input to its output
+	require.NoError(t, pipes.run(FFmpegOpConcat, exec.Command("sh", "-c", "cat <&3")))
+	require.Equal(t, "first second", string(readScratchFile(t, key, output)))
+
+	pipes = key.pipes()
+	_, err = pipes.input(first)
+	require.NoError(t, err)
+	require.Error(t, pipes.run(FFmpegOpConcat, exec.Command("sh", "-c", "exit 1")))
+}
+
+func TestScratchPipesWithoutKey(t *testing.T) {
+	var key *ScratchKey
+	pipes := key.pipes()
+	input, err := pipes.input("1.ts", "2.ts")
+	require.NoError(t, err)
+	require.Equal(t, "concat:1.ts|2.ts", input)
+	output, err := pipes.outputTo("out.ts")
+	require.NoError(t, err)
+	require.Equal(t, "out.ts", output)
+}
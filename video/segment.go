@@ -2,7 +2,9 @@ package video
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	ffmpeg "github.com/u2takey/ffmpeg-go"
@@ -13,23 +15,53 @@ import (
 // FFMPEG can use remote files, but depending on the layout of the file can get bogged
 // down and end up making multiple range requests per segment.
 // Because of this, we download first and then clean up at the end.
-func Segment(sourceFilename string, outputManifestURL string, targetSegmentSize int64) error {
+// ffmpeg is killed when ctx is done.
+//
+// The video is copied as is, so the segments are cut at the source's keyframes.
+//
+// When segmentTimes is set, the source is cut exactly at those times instead, which it's re-encoded for with closed
+// GOPs and a keyframe at each cut, see ConditionedOutput.
+func Segment(ctx context.Context, sourceFilename string, outputManifestURL string, targetSegmentSize int64, segmentTimes []float64, timedMetadata bool) error {
+	args := ffmpeg.KwArgs{
+		"c:a":               "aac",
+		"c:v":               "copy",
+		"f":                 "segment",
+		"segment_list":      outputManifestURL,
+		"segment_list_type": "m3u8",
+		"segment_format":    "mpegts",
+		"segment_time":      targetSegmentSize,
+		"min_seg_duration":  "2",
+	}
+	if timedMetadata {
+		// ffmpeg only keeps a video and an audio stream by default, the ID3 one is carried into the transcoded segments
+		args["map"] = []string{"0:v:0?", "0:a:0?", "0:d?"}
+		args["c:d"] = "copy"
+	}
+	if segmentTimes != nil {
+		times := make([]string, 0, len(segmentTimes))
+		for _, t := range segmentTimes {
+			times = append(times, strconv.FormatFloat(t, 'f', 3, 64))
+		}
+		args["c:v"] = "libx264"
+		args["preset"] = "veryfast"
+		args["crf"] = "18"
+		args["flags"] = "+cgop"
+		delete(args, "min_seg_duration")
+		// A video shorter than a segment isn't cut at all
+		if len(times) > 0 {
+			args["force_key_frames"] = strings.Join(times, ",")
+			args["segment_times"] = strings.Join(times, ",")
+			delete(args, "segment_time")
+		}
+	}
+
 	// Do the segmenting, using the local file as source
 	ffmpegErr := bytes.Buffer{}
-	err := ffmpeg.Input(sourceFilename).
-		Output(
-			strings.Replace(outputManifestURL, ".m3u8", "", 1)+"%d.ts",
-			ffmpeg.KwArgs{
-				"c:a":               "aac",
-				"c:v":               "copy",
-				"f":                 "segment",
-				"segment_list":      outputManifestURL,
-				"segment_list_type": "m3u8",
-				"segment_format":    "mpegts",
-				"segment_time":      targetSegmentSize,
-				"min_seg_duration":  "2",
-			},
-		).OverWriteOutput().WithErrorOutput(&ffmpegErr).Run()
+	err := RunFFmpeg(FFmpegOpSegment, ffmpeg.OutputContext(ctx,
+		[]*ffmpeg.Stream{ffmpeg.Input(sourceFilename)},
+		strings.Replace(outputManifestURL, ".m3u8", "", 1)+"%d.ts",
+		args,
+	).OverWriteOutput().WithErrorOutput(&ffmpegErr).Compile())
 	if err != nil {
 		return fmt.Errorf("failed to segment source file (%s) [%s]: %s", sourceFilename, ffmpegErr.String(), err)
 	}
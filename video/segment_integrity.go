@@ -0,0 +1,193 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Only the start of each segment is read, up to this many packets
+	SegmentIntegritySampled = "sampled"
+	// Each segment is read whole
+	SegmentIntegrityFull = "full"
+
+	// The job is failed up front when a segment is corrupt
+	SegmentIntegrityFail = "fail"
+	// The corrupt segments are left out of the source, with a discontinuity where they were
+	SegmentIntegritySkip = "skip"
+	// The corrupt segments are only reported, and transcoded anyway
+	SegmentIntegrityFlag = "flag"
+
+	// How much of each segment is read in the sampled mode, a whole number of packets
+	SegmentIntegritySampleBytes = 2048 * tsPacketSize
+)
+
+// The PMT stream types carried in PES packets whose headers are checked: the video, audio and timed ID3 streams. The
+// streams carried in sections, e.g. SCTE-35 cues (0x86), don't start with a PES header.
+var pesStreamTypes = map[byte]bool{
+	0x01: true, // MPEG-1 video
+	0x02: true, // MPEG-2 video
+	0x03: true, // MPEG-1 audio
+	0x04: true, // MPEG-2 audio
+	0x06: true, // private PES, e.g. Opus or DVB AC-3
+	0x0F: true, // AAC ADTS
+	0x10: true, // MPEG-4 video
+	0x11: true, // AAC LATM
+	0x15: true, // timed ID3
+	0x1B: true, // H.264
+	0x24: true, // HEVC
+	0x81: true, // AC-3
+	0x87: true, // E-AC-3
+}
+
+// SegmentIntegrityCheck scans the MPEG-TS segments of an HLS source for corruption before transcoding it, checking
+// the packet sync bytes and the PES headers, rather than failing on them with cryptic broadcaster errors mid-transcode
+type SegmentIntegrityCheck struct {
+	// SegmentIntegritySampled (the default) or SegmentIntegrityFull
+	Mode string `json:"mode,omitempty"`
+	// What to do with the corrupt segments: SegmentIntegrityFail (the default), SegmentIntegritySkip or
+	// SegmentIntegrityFlag
+	OnCorrupt string `json:"on_corrupt,omitempty"`
+}
+
+func (c *SegmentIntegrityCheck) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Mode {
+	case "", SegmentIntegritySampled, SegmentIntegrityFull:
+	default:
+		return fmt.Errorf("invalid segment integrity mode %q, must be %s or %s", c.Mode, SegmentIntegritySampled, SegmentIntegrityFull)
+	}
+	switch c.OnCorrupt {
+	case "", SegmentIntegrityFail, SegmentIntegritySkip, SegmentIntegrityFlag:
+	default:
+		return fmt.Errorf("invalid segment integrity on_corrupt %q, must be %s, %s or %s", c.OnCorrupt, SegmentIntegrityFail, SegmentIntegritySkip, SegmentIntegrityFlag)
+	}
+	return nil
+}
+
+// Full returns whether the segments are read whole
+func (c SegmentIntegrityCheck) Full() bool {
+	return c.Mode == SegmentIntegrityFull
+}
+
+// Action returns what's done with the corrupt segments
+func (c SegmentIntegrityCheck) Action() string {
+	if c.OnCorrupt == "" {
+		return SegmentIntegrityFail
+	}
+	return c.OnCorrupt
+}
+
+// CorruptSegment is a segment of the source that failed the integrity check
+type CorruptSegment struct {
+	// Position of the segment in the source playlist, from 0
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// SegmentIntegrityReport is the result of the integrity check of the segments of a source
+type SegmentIntegrityReport struct {
+	Mode            string           `json:"mode"`
+	CheckedSegments int              `json:"checked_segments"`
+	CorruptSegments []CorruptSegment `json:"corrupt_segments,omitempty"`
+	// Whether the corrupt segments were left out of the source
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// CheckTSIntegrity reads an MPEG-TS segment and returns why it's corrupt, nil if it looks sound. Every packet must start
+// with the sync byte and not be flagged with a transport error, and the packets starting a PES packet on the streams of
+// the PMT carried in PES packets must start with a valid PES header. When whole is false only the start of the segment was read, and a
+// trailing partial packet isn't an error.
+func CheckTSIntegrity(r io.Reader, whole bool) error {
+	pkt := make([]byte, tsPacketSize)
+	pmtPID := -1
+	pmtFound := false
+	streams := map[int]bool{}
+	packets, pesPackets := 0, 0
+	for ; ; packets++ {
+		offset := packets * tsPacketSize
+		n, err := io.ReadFull(r, pkt)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if whole {
+				return fmt.Errorf("truncated packet of %d bytes at byte %d", n, offset)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading segment: %w", err)
+		}
+
+		if pkt[0] != tsSyncByte {
+			return fmt.Errorf("lost sync at byte %d", offset)
+		}
+		if pkt[1]&0x80 != 0 {
+			return fmt.Errorf("transport error indicator set on the packet at byte %d", offset)
+		}
+		afc := (pkt[3] >> 4) & 0x3
+		if afc == 0 {
+			return fmt.Errorf("reserved adaptation field control on the packet at byte %d", offset)
+		}
+		if afc&0x2 != 0 && int(pkt[4]) > tsPacketSize-5 {
+			return fmt.Errorf("adaptation field overruns the packet at byte %d", offset)
+		}
+
+		pid, pusi, payload := parseTSPacket(pkt)
+		if payload == nil || !pusi {
+			continue
+		}
+		switch {
+		case pid == 0:
+			if p, ok := parsePAT(payload); ok {
+				pmtPID = p
+			}
+		case pid == pmtPID:
+			if section, ok := psiSection(payload); ok {
+				for _, es := range parsePMTStreams(section) {
+					pmtFound = true
+					streams[es.pid] = pesStreamTypes[es.streamType]
+				}
+			}
+		case streams[pid]:
+			if err := checkPESHeader(payload); err != nil {
+				return fmt.Errorf("%w on PID %d at byte %d", err, pid, offset)
+			}
+			pesPackets++
+		}
+	}
+
+	switch {
+	case packets == 0:
+		return fmt.Errorf("empty segment")
+	case !pmtFound:
+		return fmt.Errorf("no program map table")
+	case pesPackets == 0:
+		return fmt.Errorf("no PES packets")
+	}
+	return nil
+}
+
+// checkPESHeader checks the start of a PES packet, at the start of the payload of a TS packet
+func checkPESHeader(pes []byte) error {
+	if len(pes) < 6 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return fmt.Errorf("missing PES start code")
+	}
+	streamID := pes[3]
+	if streamID < 0xBC {
+		return fmt.Errorf("invalid PES stream ID 0x%X", streamID)
+	}
+	switch streamID {
+	case 0xBC, 0xBE, 0xBF, 0xF0, 0xF1, 0xF2, 0xF8, 0xFF:
+		// no optional header
+		return nil
+	}
+	if len(pes) < 9 || pes[6]&0xC0 != 0x80 {
+		return fmt.Errorf("invalid PES header")
+	}
+	return nil
+}
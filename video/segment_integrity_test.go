@@ -0,0 +1,92 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTSIntegrity(t *testing.T) {
+	segment, err := os.ReadFile("../test/fixtures/seg-0.ts")
+	require.NoError(t, err)
+	require.NoError(t, CheckTSIntegrity(bytes.NewReader(segment), true))
+	// A sample of the start of the segment
+	require.NoError(t, CheckTSIntegrity(bytes.NewReader(segment[:100*tsPacketSize+50]), false))
+
+	corrupt := func(modify func(s []byte) []byte) error {
+		s := modify(append([]byte{}, segment...))
+		return CheckTSIntegrity(bytes.NewReader(s), true)
+	}
+	require.ErrorContains(t, corrupt(func(s []byte) []byte {
+		s[10*tsPacketSize] = 0
+		return s
+	}), "lost sync at byte 1880")
+	require.ErrorContains(t, corrupt(func(s []byte) []byte {
+		s[5*tsPacketSize+1] |= 0x80
+		return s
+	}), "transport error indicator")
+	require.ErrorContains(t, corrupt(func(s []byte) []byte {
+		return s[:len(s)-100]
+	}), "truncated packet")
+	require.ErrorContains(t, corrupt(func(s []byte) []byte {
+		// the start code of every PES packet
+		for i := 0; i+tsPacketSize <= len(s); i += tsPacketSize {
+			if pid, pusi, payload := parseTSPacket(s[i : i+tsPacketSize]); pusi && pid > 0x1F && len(payload) > 3 && payload[0] == 0 && payload[1] == 0 && payload[2] == 1 {
+				payload[2] = 2
+			}
+		}
+		return s
+	}), "missing PES start code")
+	require.NoError(t, corrupt(func(s []byte) []byte {
+		return withSCTE35Stream(t, s)
+	}), "the SCTE-35 sections aren't PES packets")
+	require.ErrorContains(t, CheckTSIntegrity(bytes.NewReader(nil), true), "empty segment")
+	require.ErrorContains(t, CheckTSIntegrity(bytes.NewReader(bytes.Repeat([]byte{0x47, 0x1F, 0xFF, 0x10}, tsPacketSize/4*3)), true), "no program map table")
+}
+
+func TestSegmentIntegrityCheckValidate(t *testing.T) {
+	require.NoError(t, (*SegmentIntegrityCheck)(nil).Validate())
+	require.NoError(t, (&SegmentIntegrityCheck{}).Validate())
+	require.NoError(t, (&SegmentIntegrityCheck{Mode: SegmentIntegrityFull, OnCorrupt: SegmentIntegritySkip}).Validate())
+	require.Error(t, (&SegmentIntegrityCheck{Mode: "quick"}).Validate())
+	require.Error(t, (&SegmentIntegrityCheck{OnCorrupt: "ignore"}).Validate())
+	require.Equal(t, SegmentIntegrityFail, SegmentIntegrityCheck{}.Action())
+}
+
+// withSCTE35Stream adds an SCTE-35 stream to the PMT of the segment, with a splice info section after the PMT
+func withSCTE35Stream(t *testing.T, s []byte) []byte {
+	const scte35PID = 0x1F0
+	pmtPID := -1
+	for i := 0; i+tsPacketSize <= len(s); i += tsPacketSize {
+		pid, pusi, payload := parseTSPacket(s[i : i+tsPacketSize])
+		if !pusi {
+			continue
+		}
+		if pid == 0 {
+			pmtPID, _ = parsePAT(payload)
+			continue
+		}
+		if pid != pmtPID {
+			continue
+		}
+		section, ok := psiSection(payload)
+		require.True(t, ok)
+		// described like the timed ID3 stream, as an SCTE-35 one
+		withStream := addTimedID3Stream(section, scte35PID)
+		withStream[len(section)-4] = 0x86
+		withStream = binary.BigEndian.AppendUint32(withStream[:len(withStream)-4], crc32MPEG2(withStream[:len(withStream)-4]))
+		pmt := pmtPacket(s[i:i+tsPacketSize], withStream)
+
+		cue := bytes.Repeat([]byte{0xFF}, tsPacketSize)
+		copy(cue, []byte{tsSyncByte, 0x40 | scte35PID>>8, scte35PID & 0xFF, 0x10, 0x00, 0xFC, 0x30, 0x11})
+		out := append([]byte{}, s[:i]...)
+		out = append(out, pmt...)
+		out = append(out, cue...)
+		return append(out, s[i+tsPacketSize:]...)
+	}
+	t.Fatal("no PMT in the segment")
+	return nil
+}
@@ -0,0 +1,191 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	catErrs "github.com/livepeer/catalyst-api/errors"
+	"github.com/livepeer/catalyst-api/log"
+	"github.com/livepeer/catalyst-api/metrics"
+)
+
+// Operations the ffmpeg subprocesses are run for, labelling their metrics
+const (
+	FFmpegOpClip      = "clip"
+	FFmpegOpMuxMP4    = "mux_mp4"
+	FFmpegOpMuxFMP4   = "mux_fmp4"
+	FFmpegOpConcat    = "concat"
+	FFmpegOpSegment   = "segment"
+	FFmpegOpThumbnail = "thumbnail"
+	FFmpegOpVMAF      = "vmaf"
+	FFmpegOpNormalize = "normalize"
+)
+
+// How long the subprocess of each operation can run for by default, unless config.FFmpegTimeout is set. The ones
+// missing are bounded by the context they're run with only, like the muxing and concatenation of the MP4 outputs,
+// which take as long as the source, e.g. a recording of several hours.
+var ffmpegTimeouts = map[string]time.Duration{
+	FFmpegOpClip:      10 * time.Minute,
+	FFmpegOpMuxFMP4:   10 * time.Minute,
+	FFmpegOpThumbnail: 2 * time.Minute,
+	FFmpegOpNormalize: time.Hour,
+}
+
+var errFFmpegTimeout = errors.New("ffmpeg timed out")
+
+// RunFFmpeg runs an ffmpeg command within the resource limits of config, see limitFFmpeg, recording its duration,
+// resource usage and failure by operation
+func RunFFmpeg(operation string, cmd *exec.Cmd) error {
+	p, err := startFFmpeg(operation, cmd)
+	if err != nil {
+		return err
+	}
+	return p.wait()
+}
+
+// ffmpegProcess is an ffmpeg subprocess started by startFFmpeg, which must be waited for with wait
+type ffmpegProcess struct {
+	operation string
+	cmd       *exec.Cmd
+	start     time.Time
+	timer     *time.Timer
+
+	mu       sync.Mutex
+	timedOut bool
+}
+
+func startFFmpeg(operation string, cmd *exec.Cmd) (*ffmpegProcess, error) {
+	closeCgroup := limitFFmpeg(cmd)
+	p := &ffmpegProcess{operation: operation, cmd: cmd, start: time.Now()}
+	err := cmd.Start()
+	closeCgroup()
+	if err != nil {
+		metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues(operation, "start").Inc()
+		return nil, err
+	}
+	metrics.Metrics.FFmpegMetrics.InFlight.WithLabelValues(operation).Inc()
+
+	timeout := ffmpegTimeouts[operation]
+	if config.FFmpegTimeout > 0 {
+		timeout = config.FFmpegTimeout
+	}
+	if timeout > 0 {
+		p.timer = time.AfterFunc(timeout, func() {
+			p.mu.Lock()
+			p.timedOut = true
+			p.mu.Unlock()
+			_ = cmd.Process.Kill()
+		})
+	}
+	return p, nil
+}
+
+func (p *ffmpegProcess) wait() error {
+	err := p.cmd.Wait()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.mu.Lock()
+	timedOut := p.timedOut
+	p.mu.Unlock()
+
+	m := metrics.Metrics.FFmpegMetrics
+	m.InFlight.WithLabelValues(p.operation).Dec()
+	m.Duration.WithLabelValues(p.operation, strconv.FormatBool(err == nil)).Observe(time.Since(p.start).Seconds())
+	if state := p.cmd.ProcessState; state != nil {
+		m.CPUTime.WithLabelValues(p.operation).Observe((state.UserTime() + state.SystemTime()).Seconds())
+		if rss := maxRSS(state); rss > 0 {
+			m.MaxRSS.WithLabelValues(p.operation).Observe(float64(rss))
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	reason, class := "error", catErrs.ClassTranscode
+	if timedOut {
+		reason, class = "timeout", catErrs.ClassTimeout
+		err = fmt.Errorf("%w: %w", errFFmpegTimeout, err)
+	} else if status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		// e.g. by the OOM killer, or for using up its CPU time
+		reason = "killed"
+	}
+	m.Failures.WithLabelValues(p.operation, reason).Inc()
+	return catErrs.WithClass(class, err)
+}
+
+// limitFFmpeg runs the command through nice, ionice and prlimit for the limits of config, if they're installed, and
+// starts it in the cgroup of config.FFmpegCgroup. The returned function must be called once the command is started.
+func limitFFmpeg(cmd *exec.Cmd) func() {
+	var wrappers []string
+	if config.FFmpegNice != 0 && hasLimitTool("nice") {
+		wrappers = append(wrappers, "nice", "-n", strconv.Itoa(config.FFmpegNice))
+	}
+	if config.FFmpegIONiceClass != 0 && hasLimitTool("ionice") {
+		wrappers = append(wrappers, "ionice", "-c", strconv.Itoa(config.FFmpegIONiceClass))
+		if config.FFmpegIONiceClass != 3 {
+			wrappers = append(wrappers, "-n", strconv.Itoa(config.FFmpegIONiceLevel))
+		}
+	}
+	if (config.FFmpegMaxMemoryMB > 0 || config.FFmpegMaxCPUTime > 0) && hasLimitTool("prlimit") {
+		wrappers = append(wrappers, "prlimit")
+		if config.FFmpegMaxMemoryMB > 0 {
+			// The data segment rather than the address space, which the stacks of ffmpeg's threads reserve a lot of
+			wrappers = append(wrappers, "--data="+strconv.Itoa(config.FFmpegMaxMemoryMB*1024*1024))
+		}
+		if config.FFmpegMaxCPUTime > 0 {
+			wrappers = append(wrappers, "--cpu="+strconv.Itoa(int(config.FFmpegMaxCPUTime.Seconds())))
+		}
+		wrappers = append(wrappers, "--")
+	}
+	if len(wrappers) > 0 {
+		path, _ := exec.LookPath(wrappers[0])
+		// the wrappers run the resolved ffmpeg path, with the arguments it was given
+		cmd.Args = append(append(wrappers, cmd.Path), cmd.Args[1:]...)
+		cmd.Path = path
+	}
+
+	if config.FFmpegCgroup == "" {
+		return func() {}
+	}
+	closeCgroup, err := startInCgroup(cmd, config.FFmpegCgroup)
+	if err != nil {
+		logLimitOnce("cgroup", "not starting ffmpeg in its cgroup", "cgroup", config.FFmpegCgroup, "err", err)
+		return func() {}
+	}
+	return closeCgroup
+}
+
+var (
+	limitToolsMu sync.Mutex
+	limitTools   = map[string]bool{}
+	limitsLogged sync.Map
+)
+
+// hasLimitTool returns whether the command setting a limit is installed, logging once when it isn't
+func hasLimitTool(name string) bool {
+	limitToolsMu.Lock()
+	defer limitToolsMu.Unlock()
+	found, checked := limitTools[name]
+	if !checked {
+		_, err := exec.LookPath(name)
+		found = err == nil
+		limitTools[name] = found
+		if !found {
+			logLimitOnce(name, "not limiting ffmpeg with "+name+", it isn't installed")
+		}
+	}
+	return found
+}
+
+func logLimitOnce(limit, message string, keyvals ...interface{}) {
+	if _, logged := limitsLogged.LoadOrStore(limit, true); !logged {
+		log.LogNoRequestID(message, keyvals...)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package video
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startInCgroup has the command started straight in the cgroup v2 directory, rather than moved there once it runs.
+// The returned function closes the cgroup once the command is started.
+func startInCgroup(cmd *exec.Cmd, cgroup string) (func(), error) {
+	dir, err := os.Open(cgroup)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return func() { dir.Close() }, nil
+}
+
+// maxRSS returns the peak resident memory of the exited process, in bytes
+func maxRSS(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// in kilobytes on Linux
+		return usage.Maxrss * 1024
+	}
+	return 0
+}
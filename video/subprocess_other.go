@@ -0,0 +1,17 @@
+//go:build !linux
+
+package video
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func startInCgroup(cmd *exec.Cmd, cgroup string) (func(), error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
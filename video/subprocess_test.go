@@ -0,0 +1,58 @@
+package video
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/catalyst-api/config"
+	"github.com/livepeer/catalyst-api/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunFFmpegTimesOut(t *testing.T) {
+	defer func(timeout time.Duration) { config.FFmpegTimeout = timeout }(config.FFmpegTimeout)
+	config.FFmpegTimeout = 100 * time.Millisecond
+	timeouts := testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues("test", "timeout"))
+
+	start := time.Now()
+	err := RunFFmpeg("test", exec.Command("sleep", "10"))
+	require.True(t, errors.Is(err, errFFmpegTimeout), err)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Equal(t, timeouts+1, testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues("test", "timeout")))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.InFlight.WithLabelValues("test")))
+}
+
+func TestRunFFmpegCountsFailures(t *testing.T) {
+	errorsBefore := testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues("test", "error"))
+	require.NoError(t, RunFFmpeg("test", exec.Command("sh", "-c", "exit 0")))
+	require.Error(t, RunFFmpeg("test", exec.Command("sh", "-c", "exit 1")))
+	require.Equal(t, errorsBefore+1, testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues("test", "error")))
+
+	killedBefore := testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues("test", "killed"))
+	require.Error(t, RunFFmpeg("test", exec.Command("sh", "-c", "kill -9 $$")))
+	require.Equal(t, killedBefore+1, testutil.ToFloat64(metrics.Metrics.FFmpegMetrics.Failures.WithLabelValues("test", "killed")))
+}
+
+func TestRunFFmpegWithinLimits(t *testing.T) {
+	for _, tool := range []string{"nice", "prlimit"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s isn't installed", tool)
+		}
+	}
+	defer func(nice, memory int, cpu time.Duration) {
+		config.FFmpegNice, config.FFmpegMaxMemoryMB, config.FFmpegMaxCPUTime = nice, memory, cpu
+	}(config.FFmpegNice, config.FFmpegMaxMemoryMB, config.FFmpegMaxCPUTime)
+	config.FFmpegNice, config.FFmpegMaxMemoryMB, config.FFmpegMaxCPUTime = 5, 512, 30*time.Second
+
+	cmd := exec.Command("sh", "-c", "nice; ulimit -d; ulimit -t")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	require.NoError(t, RunFFmpeg("test", cmd))
+	require.Equal(t, "nice", cmd.Args[0])
+	require.Equal(t, []string{"5", "524288", "30"}, strings.Fields(stdout.String()))
+}
@@ -0,0 +1,64 @@
+package video
+
+import (
+	"fmt"
+	"slices"
+)
+
+// TrackSelection picks the tracks included in the MP4 and clip outputs. The zero value keeps ffmpeg's default of one
+// video and one audio track.
+type TrackSelection struct {
+	// Drop the audio, only keeping the video track
+	VideoOnly bool `json:"video_only,omitempty"`
+	// Only keep the audio track tagged with this ISO 639 language, e.g. "eng"
+	AudioLanguage string `json:"audio_language,omitempty"`
+	// Drop the data tracks (e.g. timed ID3 metadata), which are otherwise kept in the MPEG-TS outputs
+	DropData bool `json:"drop_data,omitempty"`
+
+	// Set when the source has no audio track in AudioLanguage, see ForSource
+	audioLanguageMissing bool
+}
+
+// ForSource returns the selection of the tracks of a probed source. The first audio track is kept instead when none is
+// tagged with AudioLanguage.
+func (t TrackSelection) ForSource(iv InputVideo) TrackSelection {
+	if t.AudioLanguage != "" && !slices.Contains(iv.AudioLanguages, t.AudioLanguage) {
+		t.audioLanguageMissing = true
+	}
+	return t
+}
+
+func (t TrackSelection) IsDefault() bool {
+	return t == TrackSelection{}
+}
+
+func (t TrackSelection) Validate() error {
+	if t.VideoOnly && t.AudioLanguage != "" {
+		return fmt.Errorf("audio_language can't be set for video only outputs")
+	}
+	return nil
+}
+
+// MapArgs returns the ffmpeg -map values selecting the tracks from the first input, or nil for the default selection.
+// The audio track comes first, matching the recording segments. Data tracks can only be kept for MPEG-TS outputs.
+func (t TrackSelection) MapArgs(keepData bool) []string {
+	if t.IsDefault() {
+		return nil
+	}
+	var maps []string
+	// the source may not have audio at all
+	switch {
+	case t.VideoOnly:
+	case t.audioLanguageMissing:
+		maps = append(maps, "0:a:0?")
+	case t.AudioLanguage != "":
+		maps = append(maps, "0:a:m:language:"+t.AudioLanguage+"?")
+	default:
+		maps = append(maps, "0:a?")
+	}
+	maps = append(maps, "0:v")
+	if keepData && !t.DropData {
+		maps = append(maps, "0:d?")
+	}
+	return maps
+}
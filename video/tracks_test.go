@@ -0,0 +1,27 @@
+package video
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackSelectionMapArgs(t *testing.T) {
+	require.Nil(t, TrackSelection{}.MapArgs(true))
+
+	require.Equal(t, []string{"0:v", "0:d?"}, TrackSelection{VideoOnly: true}.MapArgs(true))
+	require.Equal(t, []string{"0:v"}, TrackSelection{VideoOnly: true}.MapArgs(false))
+	require.Equal(t, []string{"0:a:m:language:eng?", "0:v", "0:d?"}, TrackSelection{AudioLanguage: "eng"}.MapArgs(true))
+	// the first audio track is kept when the source has none in the language
+	eng := TrackSelection{AudioLanguage: "eng"}
+	require.Equal(t, []string{"0:a:m:language:eng?", "0:v"}, eng.ForSource(InputVideo{AudioLanguages: []string{"fra", "eng"}}).MapArgs(false))
+	require.Equal(t, []string{"0:a:0?", "0:v"}, eng.ForSource(InputVideo{AudioLanguages: []string{"fra"}}).MapArgs(false))
+	require.Equal(t, []string{"0:a:0?", "0:v"}, eng.ForSource(InputVideo{}).MapArgs(false))
+	require.Equal(t, []string{"0:a?", "0:v"}, TrackSelection{DropData: true}.MapArgs(true))
+}
+
+func TestTrackSelectionValidate(t *testing.T) {
+	require.NoError(t, TrackSelection{}.Validate())
+	require.NoError(t, TrackSelection{AudioLanguage: "eng", DropData: true}.Validate())
+	require.EqualError(t, TrackSelection{VideoOnly: true, AudioLanguage: "eng"}.Validate(), "audio_language can't be set for video only outputs")
+}
@@ -3,15 +3,12 @@ package video
 import (
 	"bufio"
 	"bytes"
-	"context"
 	"fmt"
 	"github.com/grafov/m3u8"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
-	"strings"
-	"time"
 
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
@@ -20,18 +17,31 @@ const (
 	Mp4DurationLimit = 21600 //MP4s will be generated only for first 6 hours
 )
 
-func MuxTStoMP4(tsInputFile, mp4OutputFile string) ([]string, error) {
+// The most encrypted segments a single stream-based concatenation reads, each of them through its own pipe
+var maxConcatPipes = 64
+
+func MuxTStoMP4(tsInputFile, mp4OutputFile string, tracks TrackSelection, key *ScratchKey) ([]string, error) {
 	var transmuxOutputFiles []string
 	// transmux the .ts file into a standalone MP4 file
 	ffmpegErr := bytes.Buffer{}
-	err := ffmpeg.Input(tsInputFile).
-		Output(mp4OutputFile, ffmpeg.KwArgs{
-			"analyzeduration": "15M",           // Analyze up to 15s of video to figure out the format. We saw failures to detect the video codec without this
-			"movflags":        "faststart",     // Need this for progressive playback and probing
-			"c":               "copy",          // Don't accidentally transcode
-			"bsf:a":           "aac_adtstoasc", // Remove ADTS header (required for ts -> mp4 container conversion)
-		}).
-		OverWriteOutput().WithErrorOutput(&ffmpegErr).Run()
+	outputArgs := ffmpeg.KwArgs{
+		"analyzeduration": "15M",           // Analyze up to 15s of video to figure out the format. We saw failures to detect the video codec without this
+		"movflags":        "faststart",     // Need this for progressive playback and probing
+		"c":               "copy",          // Don't accidentally transcode
+		"bsf:a":           "aac_adtstoasc", // Remove ADTS header (required for ts -> mp4 container conversion)
+	}
+	// MP4 can't carry the MPEG-TS data tracks
+	if maps := tracks.MapArgs(false); maps != nil {
+		outputArgs["map"] = maps
+	}
+	pipes := key.pipes()
+	input, err := pipes.input(tsInputFile)
+	if err != nil {
+		return nil, err
+	}
+	err = pipes.run(FFmpegOpMuxMP4, ffmpeg.Input(input).
+		Output(mp4OutputFile, outputArgs).
+		OverWriteOutput().WithErrorOutput(&ffmpegErr).Compile())
 	if err != nil {
 		return nil, fmt.Errorf("failed to transmux concatenated mpeg-ts file (%s) into a mp4 file [%s]: %w", tsInputFile, ffmpegErr.String(), err)
 	}
@@ -45,16 +55,34 @@ func MuxTStoMP4(tsInputFile, mp4OutputFile string) ([]string, error) {
 	return transmuxOutputFiles, nil
 }
 
-func MuxTStoFMP4(fmp4ManifestOutputFile string, inputs ...string) error {
+// Names of the files of each rendition muxed by MuxTStoFMP4 with segmented set, as ffmpeg's dash muxer names them
+const (
+	FMP4InitSegmentPattern = "init-stream%d.m4s"
+	FMP4FragmentsPattern   = "chunk-stream%d-*.m4s"
+)
+
+// MuxTStoFMP4 muxes the renditions into fragmented MP4s packaged with HLS and DASH manifests. Each rendition is a
+// single file addressed by byte ranges, unless segmented is set, which writes its init segment and fragments to files
+// of their own so that they can be changed afterwards, e.g. signed, without breaking the manifests.
+func MuxTStoFMP4(fmp4ManifestOutputFile string, key *ScratchKey, segmented bool, inputs ...string) error {
 	baseFragMp4Dir := filepath.Dir(fmp4ManifestOutputFile)
 	err := os.Mkdir(baseFragMp4Dir, 0700)
 	if err != nil && !os.IsExist(err) {
 		return fmt.Errorf("transmux error: failed to create subdir to output fmp4 files: %w", err)
 	}
 
+	singleFile := "1"
+	if segmented {
+		singleFile = "0"
+	}
 	var args []string
 	mapArgs := []string{"-map", "0:a"}
-	for i, input := range inputs {
+	pipes := key.pipes()
+	for i, inputFile := range inputs {
+		input, err := pipes.input(inputFile)
+		if err != nil {
+			return err
+		}
 		args = append(args, "-i", input)
 		mapArgs = append(mapArgs, "-map", fmt.Sprintf("%d:v", i))
 	}
@@ -64,7 +92,7 @@ func MuxTStoFMP4(fmp4ManifestOutputFile string, inputs ...string) error {
 		"-bsf:a", "aac_adtstoasc",
 		"-f", "dash",
 		"-dash_segment_type", "mp4",
-		"-single_file", "1",
+		"-single_file", singleFile,
 		"-hls_playlist", "1",
 		"-hls_time", "10",
 		"-hls_playlist_type", "vod",
@@ -79,16 +107,14 @@ func MuxTStoFMP4(fmp4ManifestOutputFile string, inputs ...string) error {
 	args = append(args, mapArgs...)
 	args = append(args, fmp4ManifestOutputFile)
 
-	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-	cmd := exec.CommandContext(timeout, "ffmpeg", args...)
+	cmd := exec.Command("ffmpeg", args...)
 
 	var outputBuf bytes.Buffer
 	var stdErr bytes.Buffer
 	cmd.Stdout = &outputBuf
 	cmd.Stderr = &stdErr
 
-	err = cmd.Run()
+	err = pipes.run(FFmpegOpMuxFMP4, cmd)
 	if err != nil {
 		return fmt.Errorf("error running ffmpeg [%s] [%s] %w", outputBuf.String(), stdErr.String(), err)
 	}
@@ -104,7 +130,7 @@ func MuxTStoFMP4(fmp4ManifestOutputFile string, inputs ...string) error {
 	return nil
 }
 
-func ConcatTS(tsFileName string, segmentsList *TSegmentList, sourceMediaPlaylist m3u8.MediaPlaylist, useStreamBasedConcat bool) (int64, error) {
+func ConcatTS(tsFileName string, segmentsList *TSegmentList, sourceMediaPlaylist m3u8.MediaPlaylist, useStreamBasedConcat bool, tracks TrackSelection, key *ScratchKey) (int64, error) {
 	// Used to track total bytes concatenated will match total bytes transcoded
 	var totalBytes int64
 	// Used to ensure total duration of segments processed does not exceed Mp4DurationLimit
@@ -118,110 +144,125 @@ func ConcatTS(tsFileName string, segmentsList *TSegmentList, sourceMediaPlaylist
 			os.Remove(f)
 		}
 	}()
+	// Add the filenames of the segments, in order
+	for segName := range segmentsList.GetSortedSegments() {
+		// Check each segment that was written to disk in the disk-writing goroutine
+		segmentFilename := fileBaseWithoutExt + "_" + strconv.Itoa(segName) + ".ts"
+		segBytes, err := key.Size(segmentFilename)
+		if err != nil {
+			return totalBytes, fmt.Errorf("error stat segment %s  err: %w", segmentFilename, err)
+		}
 
-	if !useStreamBasedConcat {
-		// Add segment filename to the text file
-		for segName := range segmentsList.GetSortedSegments() {
-			// Check each segment that was written to disk in the disk-writing goroutine
-			segmentFilename := fileBaseWithoutExt + "_" + strconv.Itoa(segName) + ".ts"
-			fileInfo, err := os.Stat(segmentFilename)
-			if err != nil {
-				return totalBytes, fmt.Errorf("error stat segment %s  err: %w", segmentFilename, err)
-			}
-			segBytes := fileInfo.Size()
+		segmentFilenames = append(segmentFilenames, segmentFilename)
+		totalBytes = totalBytes + int64(segBytes)
 
-			segmentFilenames = append(segmentFilenames, segmentFilename)
-			totalBytes = totalBytes + int64(segBytes)
+		// Check total duration processed so far and stop concatenating if Mp4DurationLimit is reached
+		// i.e. generate MP4s for only up to duration specified by Mp4DurationLimit
+		segDuration := sourceMediaPlaylist.Segments[segName].Duration
+		totalDuration = totalDuration + segDuration
+		if totalDuration > Mp4DurationLimit {
+			break
+		}
+	}
 
-			// Check total duration processed so far and stop concatenating if Mp4DurationLimit is reached
-			// i.e. generate MP4s for only up to duration specified by Mp4DurationLimit
-			segDuration := sourceMediaPlaylist.Segments[segName].Duration
-			totalDuration = totalDuration + segDuration
-			if totalDuration > Mp4DurationLimit {
-				break
-			}
+	if !useStreamBasedConcat {
+		pipes := key.pipes()
+		// The segments are read one after the other, through a single pipe when encrypted
+		concatArg, err := pipes.input(segmentFilenames...)
+		if err != nil {
+			return totalBytes, err
 		}
-		concatArg := "concat:" + strings.Join(segmentFilenames, "|")
 
 		// Use file-based concatenation by reading segment files in text file
-		err := concatFiles(concatArg, tsFileName)
+		err = concatFiles(concatArg, tsFileName, tracks, pipes)
 		if err != nil {
 			return totalBytes, fmt.Errorf("failed to file-concat into a ts file: %w", err)
 		}
 
 		return totalBytes, nil
+	}
 
-	} else {
-		// Create a text file containing filenames of the segments
-		segmentListTxtFileName := fileBaseWithoutExt + ".txt"
-		segmentListTxtFile, err := os.Create(segmentListTxtFileName)
-		if err != nil {
-			return totalBytes, fmt.Errorf("error creating  segment text file (%s) err: %w", segmentListTxtFileName, err)
-		}
-		defer segmentListTxtFile.Close()
-		defer os.Remove(segmentListTxtFileName)
-		w := bufio.NewWriter(segmentListTxtFile)
-
-		// Add segment filename to the text file
-		for segName := range segmentsList.GetSortedSegments() {
-			// Check each segment that was written to disk in the disk-writing goroutine
-			segmentFilename := fileBaseWithoutExt + "_" + strconv.Itoa(segName) + ".ts"
-			fileInfo, err := os.Stat(segmentFilename)
-			if err != nil {
-				return totalBytes, fmt.Errorf("error stat segment %s  err: %w", segmentFilename, err)
-			}
-			segBytes := fileInfo.Size()
+	// Use stream-based concatenation by reading segment files in text file
+	err := concatStreamsInBatches(segmentFilenames, fileBaseWithoutExt, tsFileName, tracks, key)
+	if err != nil {
+		return totalBytes, fmt.Errorf("failed to stream-concat into a ts file: %w", err)
+	}
 
-			segmentFilenames = append(segmentFilenames, segmentFilename)
-			totalBytes = totalBytes + int64(segBytes)
-			// Add filename to the text file
-			line := fmt.Sprintf("file '%s'\n", segmentFilename)
-			if _, err = w.WriteString(line); err != nil {
-				return totalBytes, fmt.Errorf("error writing segment %d to text file err: %w", segName, err)
-			}
-			// Flush to make sure all buffered operations are applied
-			if err = w.Flush(); err != nil {
-				return totalBytes, fmt.Errorf("error flushing text file %s err: %w", segmentFilename, err)
-			}
+	return totalBytes, nil
+}
 
-			// Check total duration processed so far and stop concatenating if Mp4DurationLimit is reached
-			// i.e. generate MP4s for only up to duration specified by Mp4DurationLimit
-			segDuration := sourceMediaPlaylist.Segments[segName].Duration
-			totalDuration = totalDuration + segDuration
-			if totalDuration > Mp4DurationLimit {
-				break
+// concatStreamsInBatches concatenates the segments with concatStreams. When the segments are encrypted each of them is
+// read through its own pipe, so they're concatenated by batches of at most maxConcatPipes into intermediate files
+// that are then concatenated in turn, keeping the file descriptors ffmpeg inherits bounded.
+func concatStreamsInBatches(segments []string, fileBaseWithoutExt, outputTsFileName string, tracks TrackSelection, key *ScratchKey) error {
+	outputArgs := concatOutputArgs(tracks)
+	for level := 0; key != nil && len(segments) > maxConcatPipes; level++ {
+		var batches []string
+		for i := 0; i < len(segments); i += maxConcatPipes {
+			batchBase := fmt.Sprintf("%s_batch%d_%d", fileBaseWithoutExt, level, len(batches))
+			batches = append(batches, batchBase+".ts")
+			defer os.Remove(batchBase + ".ts")
+			err := concatStreams(segments[i:min(i+maxConcatPipes, len(segments))], batchBase+".txt", batchBase+".ts", outputArgs, key.pipes())
+			if err != nil {
+				return err
 			}
-
 		}
+		// The tracks were selected in the first batches, the next ones keep all of theirs
+		outputArgs = concatOutputArgs(TrackSelection{})
+		outputArgs["map"] = "0"
+		segments = batches
+	}
+	return concatStreams(segments, fileBaseWithoutExt+".txt", outputTsFileName, outputArgs, key.pipes())
+}
 
-		// Use stream-based concatenation by reading segment files in text file
-		err = concatStreams(segmentListTxtFileName, tsFileName)
+func concatStreams(segments []string, segmentListTxtFileName, outputTsFileName string, outputArgs ffmpeg.KwArgs, pipes *scratchPipes) error {
+	// Create a text file containing filenames of the segments
+	segmentListTxtFile, err := os.Create(segmentListTxtFileName)
+	if err != nil {
+		return fmt.Errorf("error creating  segment text file (%s) err: %w", segmentListTxtFileName, err)
+	}
+	defer os.Remove(segmentListTxtFileName)
+	w := bufio.NewWriter(segmentListTxtFile)
+	for _, segmentFilename := range segments {
+		// Add filename to the text file, or the pipe it's read from when encrypted
+		input, err := pipes.input(segmentFilename)
 		if err != nil {
-			return totalBytes, fmt.Errorf("failed to stream-concat %s into a ts file: %w", segmentListTxtFileName, err)
+			segmentListTxtFile.Close()
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "file '%s'\n", input); err != nil {
+			segmentListTxtFile.Close()
+			return fmt.Errorf("error writing segment %s to text file err: %w", segmentFilename, err)
 		}
-
-		return totalBytes, nil
 	}
-}
+	// Flush to make sure all buffered operations are applied
+	err = w.Flush()
+	if closeErr := segmentListTxtFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing segment text file %s err: %w", segmentListTxtFileName, err)
+	}
 
-func concatStreams(segmentList, outputTsFileName string) error {
-	// Create a .ts file for a given rendition
-	tsFile, err := os.Create(outputTsFileName)
+	output, err := concatOutput(outputTsFileName, pipes)
 	if err != nil {
-		return fmt.Errorf("error creating file (%s) err: %w", outputTsFileName, err)
+		return err
+	}
+	inputArgs := ffmpeg.KwArgs{
+		"f":    "concat", // Use stream based concatenation (instead of file based concatenation)
+		"safe": "0",      // Must be 0 since relative paths to segments are used in segmentListTxtFileName
+	}
+	if pipes.encrypted() {
+		// The segments are listed as the pipes they're read from
+		inputArgs["protocol_whitelist"] = "file,pipe"
 	}
-	defer tsFile.Close()
 	// Transmux the individual .ts files into a combined single ts file using stream based concatenation
 	ffmpegErr := bytes.Buffer{}
-	err = ffmpeg.Input(segmentList, ffmpeg.KwArgs{
-		"f":    "concat", // Use stream based concatenation (instead of file based concatenation)
-		"safe": "0"}).    // Must be 0 since relative paths to segments are used in segmentListTxtFileName
-		Output(outputTsFileName, ffmpeg.KwArgs{
-			"c": "copy", // Don't accidentally transcode
-		}).
-		OverWriteOutput().WithErrorOutput(&ffmpegErr).Run()
+	err = pipes.run(FFmpegOpConcat, ffmpeg.Input(segmentListTxtFileName, inputArgs).
+		Output(output, outputArgs).
+		OverWriteOutput().WithErrorOutput(&ffmpegErr).Compile())
 	if err != nil {
-		return fmt.Errorf("failed to transmux multiple ts files from %s into a ts file [%s]: %w", segmentList, ffmpegErr.String(), err)
+		return fmt.Errorf("failed to transmux multiple ts files from %s into a ts file [%s]: %w", segmentListTxtFileName, ffmpegErr.String(), err)
 	}
 	// Verify the ts output file was created
 	_, err = os.Stat(outputTsFileName)
@@ -231,20 +272,16 @@ func concatStreams(segmentList, outputTsFileName string) error {
 	return nil
 }
 
-func concatFiles(segmentList, outputTsFileName string) error {
-	// Create a .ts file for a given rendition
-	tsFile, err := os.Create(outputTsFileName)
+func concatFiles(segmentList, outputTsFileName string, tracks TrackSelection, pipes *scratchPipes) error {
+	output, err := concatOutput(outputTsFileName, pipes)
 	if err != nil {
-		return fmt.Errorf("error creating file (%s) err: %w", outputTsFileName, err)
+		return err
 	}
-	defer tsFile.Close()
 	// Transmux the individual .ts files into a combined single ts file using file based concatenation
 	ffmpegErr := bytes.Buffer{}
-	err = ffmpeg.Input(segmentList).
-		Output(outputTsFileName, ffmpeg.KwArgs{
-			"c": "copy", // Don't accidentally transcode
-		}).
-		OverWriteOutput().WithErrorOutput(&ffmpegErr).Run()
+	err = pipes.run(FFmpegOpConcat, ffmpeg.Input(segmentList).
+		Output(output, concatOutputArgs(tracks)).
+		OverWriteOutput().WithErrorOutput(&ffmpegErr).Compile())
 	if err != nil {
 		return fmt.Errorf("failed to transmux multiple ts files from %s into a ts file [%s]: %w", segmentList, ffmpegErr.String(), err)
 	}
@@ -255,3 +292,27 @@ func concatFiles(segmentList, outputTsFileName string) error {
 	}
 	return nil
 }
+
+// concatOutput creates the .ts file for a given rendition and returns the ffmpeg output writing it
+func concatOutput(outputTsFileName string, pipes *scratchPipes) (string, error) {
+	if pipes.encrypted() {
+		return pipes.outputTo(outputTsFileName)
+	}
+	tsFile, err := os.Create(outputTsFileName)
+	if err != nil {
+		return "", fmt.Errorf("error creating file (%s) err: %w", outputTsFileName, err)
+	}
+	tsFile.Close()
+	return outputTsFileName, nil
+}
+
+func concatOutputArgs(tracks TrackSelection) ffmpeg.KwArgs {
+	args := ffmpeg.KwArgs{
+		"c": "copy",   // Don't accidentally transcode
+		"f": "mpegts", // Needed when writing to a pipe
+	}
+	if maps := tracks.MapArgs(true); maps != nil {
+		args["map"] = maps
+	}
+	return args
+}
@@ -71,7 +71,7 @@ func TestItConcatsStreams(t *testing.T) {
 	pl := *sourceManifest.(*m3u8.MediaPlaylist)
 
 	// write segments to disk to test stream-based concatenation
-	err = WriteSegmentsToDisk(concatDir, tr, sb)
+	err = WriteSegmentsToDisk(concatDir, tr, sb, nil)
 	require.NoError(t, err)
 	// verify segments are not held in memory anymore
 	for _, v := range segmentList.SegmentDataTable {
@@ -79,7 +79,7 @@ func TestItConcatsStreams(t *testing.T) {
 	}
 
 	// verify stream-based concatenation
-	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, true)
+	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, true, TrackSelection{}, nil)
 	require.NoError(t, err)
 	require.Equal(t, int64(594644), totalBytesW)
 
@@ -116,14 +116,14 @@ func TestItConcatsFiles(t *testing.T) {
 	pl := *sourceManifest.(*m3u8.MediaPlaylist)
 
 	// write segments to disk to test stream-based concatenation
-	err = WriteSegmentsToDisk(concatDir, tr, sb)
+	err = WriteSegmentsToDisk(concatDir, tr, sb, nil)
 	require.NoError(t, err)
 	// verify segments are not held in memory anymore
 	for _, v := range segmentList.SegmentDataTable {
 		require.Equal(t, int(0), len(v))
 	}
 	// verify file-based concatenation
-	totalBytesWritten, err := ConcatTS(concatTsFileName, segmentList, pl, false)
+	totalBytesWritten, err := ConcatTS(concatTsFileName, segmentList, pl, false, TrackSelection{}, nil)
 	require.NoError(t, err)
 	require.Equal(t, int64(594644), totalBytesWritten)
 
@@ -159,10 +159,10 @@ func TestItConcatsFilesOnlyUptoMP4DurationLimit(t *testing.T) {
 	require.NoError(t, err)
 	pl := *sourceManifest.(*m3u8.MediaPlaylist)
 	// write segments to disk to test stream-based concatenation
-	err = WriteSegmentsToDisk(concatDir, tr, sb)
+	err = WriteSegmentsToDisk(concatDir, tr, sb, nil)
 	require.NoError(t, err)
 	// verify file-based concatenation
-	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, false)
+	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, false, TrackSelection{}, nil)
 	require.NoError(t, err)
 	// Only first two segments are written since duration exceeded Mp4DurationLimit
 	//206612 seg-0.ts
@@ -201,10 +201,10 @@ func TestItConcatsStreamsOnlyUptoMP4DurationLimit(t *testing.T) {
 	require.NoError(t, err)
 	pl := *sourceManifest.(*m3u8.MediaPlaylist)
 	// write segments to disk to test stream-based concatenation
-	err = WriteSegmentsToDisk(concatDir, tr, sb)
+	err = WriteSegmentsToDisk(concatDir, tr, sb, nil)
 	require.NoError(t, err)
 	// verify stream-based concatenation
-	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, true)
+	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, true, TrackSelection{}, nil)
 	require.NoError(t, err)
 	// Only first two segments are written since duration exceeded Mp4DurationLimit
 	//206612 seg-0.ts
@@ -237,3 +237,32 @@ func readSegmentData(filePath string) []byte {
 	data, _ := os.ReadFile(filePath)
 	return data
 }
+
+func TestItConcatsEncryptedStreamsInBatches(t *testing.T) {
+	defer func(max int) { maxConcatPipes = max }(maxConcatPipes)
+	maxConcatPipes = 2
+	key := newTestScratchKey(t)
+	tr := populateRenditionSegmentList()
+	segmentList := tr.GetSegmentList(rendition)
+	concatDir := t.TempDir()
+	concatTsFileName := filepath.Join(concatDir, request+"_"+rendition+".ts")
+	sb := []TranscodedSegmentInfo{
+		{RequestID: request, RenditionName: rendition, SegmentIndex: 0},
+		{RequestID: request, RenditionName: rendition, SegmentIndex: 1},
+		{RequestID: request, RenditionName: rendition, SegmentIndex: 2},
+	}
+	sourceManifest, _, err := m3u8.DecodeFrom(strings.NewReader(normalManifest), true)
+	require.NoError(t, err)
+	pl := *sourceManifest.(*m3u8.MediaPlaylist)
+	require.NoError(t, WriteSegmentsToDisk(concatDir, tr, sb, key))
+
+	// 3 segments are concatenated as a batch of 2 and a batch of 1, then the batches are concatenated
+	totalBytesW, err := ConcatTS(concatTsFileName, segmentList, pl, true, TrackSelection{}, key)
+	require.NoError(t, err)
+	require.Equal(t, int64(594644), totalBytesW)
+	// only the output is left, encrypted
+	entries, err := os.ReadDir(concatDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, []byte{0x47}, readScratchFile(t, key, concatTsFileName)[:1])
+}